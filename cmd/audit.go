@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/audit"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// openAuditLog opens the audit log given by --audit-log, if any, returning a context which carries it to the push.
+func (f *pushFlagFields) openAuditLog(ctx context.Context) (context.Context, *audit.Log, error) {
+	if f.auditLog == "" {
+		return ctx, nil, nil
+	}
+	auditLog, err := audit.Open(f.auditLog)
+	if err != nil {
+		return nil, nil, err
+	}
+	return audit.WithLog(ctx, auditLog), auditLog, nil
+}
+
+var verifyAuditLogCmd = &cobra.Command{
+	Use:   "verify-audit-log <file>",
+	Short: "Check that an audit log written by push or sync has not been modified.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "Error opening audit log.")
+		}
+		defer file.Close()
+		count, err := audit.Verify(file)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("The audit log is intact, with %d entries.\n", count)
+		return nil
+	},
+}
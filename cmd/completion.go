@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish|powershell]",
+	Short: "Generate a shell completion script for the sync tool.",
+	Long: `Generate a shell completion script for the sync tool.
+
+To load completions in the current Bash session run:
+  source <(codeql-action-sync completion bash)
+
+To load completions in the current Zsh session run:
+  source <(codeql-action-sync completion zsh)
+
+To load completions in the current Fish session run:
+  codeql-action-sync completion fish | source
+
+To load completions in the current PowerShell session run:
+  codeql-action-sync completion powershell | Out-String | Invoke-Expression`,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.ExactValidArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		default:
+			return rootCmd.GenPowerShellCompletion(os.Stdout)
+		}
+	},
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"github.com/github/codeql-action-sync/internal/concurrency"
+	"github.com/spf13/cobra"
+)
+
+const defaultConcurrency = 4
+
+type concurrencyFlagFields struct {
+	concurrency         int
+	downloadConcurrency int
+	uploadConcurrency   int
+	apiConcurrency      int
+	downloadSegments    int
+}
+
+var concurrencyFlags = concurrencyFlagFields{}
+
+func (f *concurrencyFlagFields) Init(cmd *cobra.Command, downloads bool, uploads bool) {
+	cmd.Flags().IntVar(&f.concurrency, "concurrency", defaultConcurrency, "The maximum number of asset transfers and API requests to run at the same time.")
+	if downloads {
+		cmd.Flags().IntVar(&f.downloadConcurrency, "download-concurrency", 0, "The maximum number of assets to download at the same time. If not specified the value of --concurrency will be used.")
+		cmd.Flags().IntVar(&f.downloadSegments, "download-segments", 0, "Download each large asset in this many segments over separate connections at the same time. If not specified each asset is downloaded over a single connection.")
+	}
+	if uploads {
+		cmd.Flags().IntVar(&f.uploadConcurrency, "upload-concurrency", 0, "The maximum number of assets to upload at the same time. If not specified the value of --concurrency will be used.")
+	}
+	cmd.Flags().IntVar(&f.apiConcurrency, "api-concurrency", 0, "The maximum number of API requests to make at the same time. If not specified the value of --concurrency will be used.")
+}
+
+func (f *concurrencyFlagFields) limits() concurrency.Limits {
+	limit := func(override int) int {
+		if override > 0 {
+			return override
+		}
+		return f.concurrency
+	}
+	return concurrency.Limits{
+		Downloads:        limit(f.downloadConcurrency),
+		Uploads:          limit(f.uploadConcurrency),
+		APIRequests:      limit(f.apiConcurrency),
+		DownloadSegments: f.downloadSegments,
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/push"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "List the differences between the cache and a GitHub Enterprise Server installation.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := pushFlags.pinDestination()
+		if err != nil {
+			return err
+		}
+		cacheDirectory, err := readOnlyFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		err = pushFlags.resolveDestinationRepository(cacheDirectory)
+		if err != nil {
+			return err
+		}
+		languageMapping, err := languageFlags.mapping()
+		if err != nil {
+			return err
+		}
+		retentionPolicy, err := retentionFlags.policy()
+		if err != nil {
+			return err
+		}
+		ctx := retry.WithPolicy(cmd.Context(), retryFlags.policy())
+		return push.Diff(ctx, cacheDirectory, pushFlags.destinationURL, pushFlags.destinationToken, pushFlags.destinationRepository, languageMapping, languageFlags.languages, pushFlags.pushSSH, pushFlags.releaseRefsOnly, draftFlags.includeDrafts, retentionPolicy, os.Stdout, diffFlags.output)
+	},
+}
+
+type diffFlagFields struct {
+	output string
+}
+
+var diffFlags = diffFlagFields{}
+
+// Init registers the flags of the diff command. The destination flags are shared with push, as the destination is compared with what push would push there.
+func (f *diffFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&pushFlags.destinationURL, "destination-url", "", "The URL of the GitHub Enterprise instance to compare with.")
+	cmd.MarkFlagRequired("destination-url")
+	cmd.Flags().StringVar(&pushFlags.destinationToken, "destination-token", "", "A token to access the API on the GitHub Enterprise instance.")
+	cmd.MarkFlagRequired("destination-token")
+	cmd.Flags().StringSliceVar(&pushFlags.destinationPins, "destination-pin", []string{}, "A comma-separated list of pins, each either sha256/ followed by the Base64 SHA-256 hash of a public key or a file of PEM certificates, one of which the GitHub Enterprise instance and its subdomains must present over HTTPS.")
+	cmd.Flags().StringVar(&pushFlags.destinationRepository, "destination-repository", push.DefaultDestinationRepository, "The name of the repository to compare with on GitHub Enterprise.")
+	cmd.Flags().BoolVar(&pushFlags.pushSSH, "push-ssh", false, "Read Git references over SSH rather than HTTPS. To use this option you must have SSH access to your GitHub Enterprise instance configured.")
+	cmd.Flags().BoolVar(&pushFlags.releaseRefsOnly, "release-refs-only", false, "Only compare main, major version and release branches and tags, as pushed with --release-refs-only.")
+	cmd.Flags().BoolVar(&draftFlags.includeDrafts, "include-drafts", false, "Also compare draft releases in the cache, as pushed with --include-drafts.")
+	cmd.Flags().StringVar(&f.output, "output", push.DiffFormatText, "The format to print the differences in, either text or json.")
+	cmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{push.DiffFormatText, push.DiffFormatJSON}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
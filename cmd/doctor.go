@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/doctor"
+	"github.com/github/codeql-action-sync/internal/pull"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check connectivity, tokens, the cache and disk space, and list any problems that would stop a sync, most important first.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := pushFlags.pinDestination()
+		if err != nil {
+			return err
+		}
+		return doctor.Run(cmd.Context(), rootFlags.cacheDir, pullFlags.sourceURL, pullFlags.sourceToken, pushFlags.destinationURL, pushFlags.destinationToken, os.Stdout)
+	},
+}
+
+type doctorFlagFields struct{}
+
+var doctorFlags = doctorFlagFields{}
+
+// Init registers the flags of the doctor command. The source and destination flags are shared with pull and push, so that the same instances and tokens are checked.
+func (f *doctorFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&pullFlags.sourceURL, "source-url", pull.DefaultSourceURL, "The URL of the GitHub instance to check the connection to for pulling.")
+	cmd.Flags().StringVar(&pullFlags.sourceToken, "source-token", "", "A token for the GitHub instance being pulled from, to check.")
+	cmd.Flags().StringVar(&pushFlags.destinationURL, "destination-url", "", "The URL of the GitHub Enterprise instance to check the connection to for pushing. If not specified the destination is not checked.")
+	cmd.Flags().StringVar(&pushFlags.destinationToken, "destination-token", "", "A token for the API on the GitHub Enterprise instance, to check the scopes of.")
+	cmd.Flags().StringSliceVar(&pushFlags.destinationPins, "destination-pin", []string{}, "A comma-separated list of pins, each either sha256/ followed by the Base64 SHA-256 hash of a public key or a file of PEM certificates, one of which the GitHub Enterprise instance and its subdomains must present over HTTPS.")
+}
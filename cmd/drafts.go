@@ -0,0 +1,24 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type draftFlagFields struct {
+	includeDrafts bool
+}
+
+var draftFlags = draftFlagFields{}
+
+// Init adds `--include-drafts`, which is shared by `pull` and `push` so that `sync` only takes it once.
+func (f *draftFlagFields) Init(cmd *cobra.Command, pull bool, push bool) {
+	usage := "Also"
+	if pull {
+		usage += " pull draft releases of the source, which needs a source token that can push to the source repository"
+	}
+	if pull && push {
+		usage += ", and"
+	}
+	if push {
+		usage += " push draft releases in the cache to the destination, where they stay drafts"
+	}
+	cmd.Flags().BoolVar(&f.includeDrafts, "include-drafts", false, usage+". By default draft releases are skipped.")
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const environmentVariablePrefix = "CODEQL_ACTION_SYNC_"
+
+func environmentVariableName(flagName string) string {
+	return environmentVariablePrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvironment sets each flag that was not given on the command line from its environment variable, if that is set. This runs before required flags are checked, so they can be provided either way.
+func applyEnvironment(cmd *cobra.Command) error {
+	var result error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if result != nil || flag.Changed {
+			return
+		}
+		name := environmentVariableName(flag.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		err := cmd.Flags().Set(flag.Name, value)
+		if err != nil {
+			result = fmt.Errorf("Invalid value %q for the environment variable %s: %s", value, name, err)
+		}
+	})
+	return result
+}
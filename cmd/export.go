@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/github/codeql-action-sync/internal/migrationarchive"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the cache to an archive that the migration tooling of GitHub Enterprise Server can import.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cacheDirectory, err := rootFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		return migrationarchive.Export(cacheDirectory, exportFlags.archive, exportFlags.format, exportFlags.repository)
+	},
+}
+
+type exportFlagFields struct {
+	archive    string
+	format     string
+	repository string
+}
+
+var exportFlags = exportFlagFields{}
+
+func (f *exportFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.archive, "archive", "", "The path to write the archive to, for example codeql-action.tar.gz.")
+	cmd.MarkFlagRequired("archive")
+	cmd.MarkFlagFilename("archive")
+	cmd.Flags().StringVar(&f.format, "format", migrationarchive.FormatGHEMigrator, "The format of the archive. Only ghe-migrator is supported.")
+	cmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{migrationarchive.FormatGHEMigrator}, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmd.Flags().StringVar(&f.repository, "repository", "github/codeql-action", "The name of the repository to import the Action as.")
+}
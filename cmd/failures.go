@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/github/codeql-action-sync/internal/failures"
+	"github.com/spf13/cobra"
+)
+
+type failureFlagFields struct {
+	continueOnError bool
+}
+
+var failureFlags = failureFlagFields{}
+
+func (f *failureFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&f.continueOnError, "continue-on-error", false, "Carry on syncing the other releases if one of them fails, for example because one of its assets cannot be downloaded, and report every failure at the end.")
+}
+
+// collect returns a context which collects the releases that fail if `--continue-on-error` is given, and the collector to report them from once the command has finished. Otherwise the collector is nil and the first failure stops the command.
+func (f *failureFlagFields) collect(ctx context.Context) (context.Context, *failures.Collector) {
+	if !f.continueOnError {
+		return ctx, nil
+	}
+	collector := failures.NewCollector()
+	return failures.WithCollector(ctx, collector), collector
+}
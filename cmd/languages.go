@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/github/codeql-action-sync/internal/assetselection"
+	"github.com/spf13/cobra"
+)
+
+type languageFlagFields struct {
+	languages       []string
+	languageMapping string
+	includeAssets   []string
+	excludeAssets   []string
+}
+
+var languageFlags = languageFlagFields{}
+
+func (f *languageFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.languages, "languages", []string{}, "A comma-separated list of the languages that will be analyzed on the destination, for example cpp,java,python. Only the CodeQL bundles needed for these languages will be transferred.")
+	cmd.RegisterFlagCompletionFunc("languages", completeLanguages)
+	cmd.Flags().StringVar(&f.languageMapping, "language-mapping", "", "The path to a JSON file describing which CodeQL bundles support which languages. If not specified the mapping for the bundles currently published on GitHub.com will be used.")
+	cmd.MarkFlagFilename("language-mapping", "json")
+	cmd.Flags().StringSliceVar(&f.includeAssets, "include-assets", []string{}, "A comma-separated list of patterns of the names of release assets to transfer, for example *.tar.gz. If not specified all of them are transferred.")
+	cmd.Flags().StringSliceVar(&f.excludeAssets, "exclude-assets", []string{}, "A comma-separated list of patterns of the names of release assets to never transfer, for example *.tar.zst to only transfer one format of each CodeQL bundle.")
+}
+
+func (f *languageFlagFields) mapping() (*assetselection.Mapping, error) {
+	mapping, err := assetselection.LoadMapping(f.languageMapping)
+	if err != nil {
+		return nil, err
+	}
+	err = mapping.SetAssetPatterns(f.includeAssets, f.excludeAssets)
+	if err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+func completeLanguages(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	mapping, err := languageFlags.mapping()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return mapping.KnownLanguages(), cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/list"
+	"github.com/spf13/cobra"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the releases, assets and Git references in the cache that would be pushed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cacheDirectory, err := rootFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		return list.List(cacheDirectory, os.Stdout, listFlags.output)
+	},
+}
+
+type listFlagFields struct {
+	output string
+}
+
+var listFlags = listFlagFields{}
+
+func (f *listFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.output, "output", list.OutputFormatText, "The format to print the cache contents in, either text or json.")
+	cmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{list.OutputFormatText, list.OutputFormatJSON}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
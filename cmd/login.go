@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	usererrors "errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/login"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const errorEmptyToken = "No token was given on standard input."
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Get a token for GitHub.com or GitHub Enterprise Server by logging in with a browser, and store it for later commands.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token, err := login.Login(cmd.Context(), loginFlags.url, loginFlags.clientID, loginFlags.scopes, os.Stderr)
+		if err != nil {
+			return err
+		}
+		return rootFlags.tokenStorage(cmd.Context()).Save(loginFlags.url, token)
+	},
+}
+
+type loginFlagFields struct {
+	url      string
+	clientID string
+	scopes   []string
+}
+
+var loginFlags = loginFlagFields{}
+
+func (f *loginFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.url, "url", "https://github.com", "The URL of the GitHub instance to log in to.")
+	cmd.Flags().StringVar(&f.clientID, "client-id", "", "The client ID of an OAuth app or GitHub App on the instance with the device flow turned on.")
+	cmd.MarkFlagRequired("client-id")
+	cmd.Flags().StringSliceVar(&f.scopes, "scopes", login.DefaultScopes, "A comma-separated list of scopes to request for an OAuth app. This is ignored for GitHub Apps.")
+}
+
+var storeTokenCmd = &cobra.Command{
+	Use:   "store-token",
+	Short: "Read a token from standard input and store it for later commands, so that it does not need to be given on the command line.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		content, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return errors.Wrap(err, "Error reading token.")
+		}
+		token := strings.TrimSpace(string(content))
+		if token == "" {
+			return usererrors.New(errorEmptyToken)
+		}
+		return rootFlags.tokenStorage(cmd.Context()).Save(storeTokenFlags.url, login.Token{AccessToken: token})
+	},
+}
+
+type storeTokenFlagFields struct {
+	url string
+}
+
+var storeTokenFlags = storeTokenFlagFields{}
+
+func (f *storeTokenFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.url, "url", "", "The URL of the GitHub instance the token is for.")
+	cmd.MarkFlagRequired("url")
+}
+
+// tokenFlags pairs each flag for a token with the flag for the URL of the instance it is for.
+var tokenFlags = map[string]string{
+	"source-token":      "source-url",
+	"destination-token": "destination-url",
+}
+
+// applyStoredTokens sets each token flag that was not given on the command line or with an environment variable from the token stored for its instance by `login` or `store-token`, if there is one.
+func applyStoredTokens(cmd *cobra.Command) error {
+	storage := rootFlags.tokenStorage(cmd.Context())
+	var result error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		urlFlagName, isToken := tokenFlags[flag.Name]
+		if result != nil || !isToken || flag.Changed {
+			return
+		}
+		urlFlag := cmd.Flags().Lookup(urlFlagName)
+		if urlFlag == nil || urlFlag.Value.String() == "" {
+			return
+		}
+		token, err := storage.Token(urlFlag.Value.String())
+		if err != nil {
+			result = err
+			return
+		}
+		if token != "" {
+			result = cmd.Flags().Set(flag.Name, token)
+		}
+	})
+	return result
+}
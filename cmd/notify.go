@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"github.com/github/codeql-action-sync/internal/push"
+	"github.com/spf13/cobra"
+)
+
+type notifyFlagFields struct {
+	webhook      string
+	slackWebhook string
+	slackChannel string
+}
+
+var notifyFlags = notifyFlagFields{}
+
+func (f *notifyFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.webhook, "notify-webhook", "", "A URL to POST a JSON summary of the result to once finished.")
+	cmd.Flags().StringVar(&f.slackWebhook, "notify-slack-webhook", "", "A Slack incoming webhook URL to post a message to if new CodeQL bundles are pushed or the command fails.")
+	cmd.Flags().StringVar(&f.slackChannel, "notify-slack-channel", "", "The Slack channel to post to, if not the default channel of the webhook.")
+}
+
+func (f *notifyFlagFields) enabled() bool {
+	return f.webhook != "" || f.slackWebhook != ""
+}
+
+func cachedReleases() []string {
+	cacheDirectory, err := rootFlags.openCacheDirectory()
+	if err != nil {
+		return nil
+	}
+	releases, err := cacheDirectory.ListReleases()
+	if err != nil {
+		return nil
+	}
+	return releases
+}
+
+func pushedReleases() []string {
+	cacheDirectory, err := rootFlags.openCacheDirectory()
+	if err != nil {
+		return nil
+	}
+	destinationRepository, err := push.ResolveDestinationRepository(cacheDirectory, pushFlags.destinationRepository)
+	if err != nil {
+		return nil
+	}
+	releases, err := push.PushedReleases(cacheDirectory, pushFlags.destinationURL, destinationRepository)
+	if err != nil {
+		return nil
+	}
+	return releases
+}
+
+func newReleases(before []string, after []string) []string {
+	existing := map[string]bool{}
+	for _, release := range before {
+		existing[release] = true
+	}
+	result := []string{}
+	for _, release := range after {
+		if !existing[release] {
+			result = append(result, release)
+		}
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const outputText = "text"
+const outputJSON = "json"
+
+type outputFlagFields struct {
+	output      string
+	outputFile  string
+	metricsFile string
+}
+
+var outputFlags = outputFlagFields{}
+
+func (f *outputFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.output, "output", outputText, "The format of the result. Use json to write a machine-readable summary of the run once finished.")
+	cmd.Flags().StringVar(&f.outputFile, "output-file", "", "A file to write the summary given by --output json to, instead of standard output.")
+	cmd.Flags().StringVar(&f.metricsFile, "metrics-file", "", "A file to write metrics of the run to once finished, such as the bytes transferred, API calls made, retries and the time spent on each release.")
+}
+
+func (f *outputFlagFields) validate() error {
+	if f.output != outputText && f.output != outputJSON {
+		return fmt.Errorf("The output format %s is not supported. Use text or json.", f.output)
+	}
+	return nil
+}
+
+func (f *outputFlagFields) enabled() bool {
+	return f.output == outputJSON
+}
+
+func (f *outputFlagFields) write(document report.Document) error {
+	var writer io.Writer = os.Stdout
+	if f.outputFile != "" {
+		file, err := os.Create(f.outputFile)
+		if err != nil {
+			return errors.Wrap(err, "Error creating run summary file.")
+		}
+		defer file.Close()
+		writer = file
+	}
+	return document.Write(writer)
+}
+
+func (f *outputFlagFields) writeMetrics(document metrics.Document) error {
+	file, err := os.Create(f.metricsFile)
+	if err != nil {
+		return errors.Wrap(err, "Error creating metrics file.")
+	}
+	defer file.Close()
+	return document.Write(file)
+}
@@ -0,0 +1,13 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type progressFlagFields struct {
+	noProgress bool
+}
+
+var progressFlags = progressFlagFields{}
+
+func (f *progressFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&f.noProgress, "no-progress", false, "Do not report the progress of transfers. This is useful to keep CI logs short.")
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/github/codeql-action-sync/internal/push"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old CodeQL bundle releases from a GitHub Enterprise Server installation.",
+	RunE: withSummary(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+		version.LogVersion()
+		err := pushFlags.pinDestination()
+		if err != nil {
+			return err
+		}
+		cacheDirectory, err := readOnlyFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		err = pushFlags.resolveDestinationRepository(cacheDirectory)
+		if err != nil {
+			return err
+		}
+		retentionPolicy, err := retentionFlags.policy()
+		if err != nil {
+			return err
+		}
+		ctx, auditLog, err := pushFlags.openAuditLog(ctx)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		ctx = retry.WithPolicy(ctx, retryFlags.policy())
+		ctx = retry.WithBreaker(ctx, retryFlags.destinationBreaker())
+		return push.Prune(ctx, cacheDirectory, pushFlags.destinationURL, pushFlags.destinationToken, pushFlags.destinationRepository, retentionPolicy, pushFlags.force, pruneFlags.deleteTags, pruneFlags.dryRun)
+	}),
+}
+
+type pruneFlagFields struct {
+	deleteTags bool
+	dryRun     bool
+}
+
+var pruneFlags = pruneFlagFields{}
+
+// Init registers the flags of the prune command. The destination flags are shared with push, so that notifications and summaries name the destination in the same way.
+func (f *pruneFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&pushFlags.destinationURL, "destination-url", "", "The URL of the GitHub Enterprise instance to prune.")
+	cmd.MarkFlagRequired("destination-url")
+	cmd.Flags().StringVar(&pushFlags.destinationToken, "destination-token", "", "A token to access the API on the GitHub Enterprise instance.")
+	cmd.MarkFlagRequired("destination-token")
+	cmd.Flags().StringSliceVar(&pushFlags.destinationPins, "destination-pin", []string{}, "A comma-separated list of pins, each either sha256/ followed by the Base64 SHA-256 hash of a public key or a file of PEM certificates, one of which the GitHub Enterprise instance and its subdomains must present over HTTPS.")
+	cmd.Flags().StringVar(&pushFlags.destinationRepository, "destination-repository", push.DefaultDestinationRepository, "The name of the repository to prune on GitHub Enterprise.")
+	cmd.Flags().BoolVar(&pushFlags.force, "force", false, "Prune the repository even if it was not created by the sync tool.")
+	cmd.Flags().BoolVar(&f.deleteTags, "delete-tags", false, "Also delete the Git tag of each deleted release.")
+	cmd.Flags().BoolVar(&f.dryRun, "dry-run", false, "List the releases that would be deleted without deleting them.")
+	cmd.Flags().StringVar(&pushFlags.auditLog, "audit-log", "", "A file to append a tamper-evident record of every change made to GitHub Enterprise Server to.")
+}
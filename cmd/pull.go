@@ -1,28 +1,192 @@
 package cmd
 
 import (
+	"context"
+	usererrors "errors"
+
+	"github.com/github/codeql-action-sync/internal/assetselection"
 	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/packs"
 	"github.com/github/codeql-action-sync/internal/pull"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/internal/retry"
 	"github.com/github/codeql-action-sync/internal/version"
 	"github.com/spf13/cobra"
 )
 
+const errorCLIWithQueries = "The `--codeql-cli` and `--codeql-queries` flags cannot be used together. Pull each of them into its own cache."
+const errorPacksWithSourceDirectory = "The `--packs` flag cannot be used with `--source-directory`, as CodeQL packs are pulled from a container registry."
+const errorSubmoduleDepth = "The `--submodule-depth` flag must be at least 1."
+const errorSourceDirectoryWithURL = "The `--source-directory` flag cannot be used with `--source-url` or `--source-repository`."
+
 var pullCmd = &cobra.Command{
 	Use:   "pull",
 	Short: "Pull the CodeQL Action from GitHub to a local cache.",
-	RunE: func(cmd *cobra.Command, args []string) error {
+	RunE: withSummary(func(ctx context.Context, cmd *cobra.Command, args []string) error {
 		version.LogVersion()
-		cacheDirectory := cachedirectory.NewCacheDirectory(rootFlags.cacheDir)
-		return pull.Pull(cmd.Context(), cacheDirectory, pullFlags.sourceToken)
-	},
+		ctx, collector := failureFlags.collect(ctx)
+		err := installRateLimits(pullFlags.maxDownloadRate, "")
+		if err != nil {
+			return err
+		}
+		cacheDirectory, err := rootFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		languageMapping, err := languageFlags.mapping()
+		if err != nil {
+			return err
+		}
+		retentionPolicy, err := retentionFlags.policy()
+		if err != nil {
+			return err
+		}
+		source, err := pullFlags.source()
+		if err != nil {
+			return err
+		}
+		submoduleDepth, err := pullFlags.submoduleLevels()
+		if err != nil {
+			return err
+		}
+		ctx = retry.WithPolicy(ctx, retryFlags.policy())
+		err = pull.Pull(ctx, cacheDirectory, pullFlags.options(source, submoduleDepth, languageMapping, retentionPolicy))
+		if err != nil {
+			return err
+		}
+		err = pullFlags.pullPacks(ctx, cacheDirectory)
+		if err != nil {
+			return err
+		}
+		return collector.Err()
+	}),
 }
 
 type pullFlagFields struct {
-	sourceToken string
+	sourceURL          string
+	sourceRepository   string
+	sourceDirectory    string
+	sourceToken        string
+	codeqlCLI          bool
+	codeqlQueries      bool
+	minimizeTransfer   bool
+	gitDepth           int
+	submodules         bool
+	submoduleDepth     int
+	includeRefs        []string
+	excludeRefs        []string
+	verifySignatures   bool
+	signingKeys        []string
+	includePrereleases bool
+	repack             bool
+	maxDownloadRate    string
+	packs              []string
 }
 
 var pullFlags = pullFlagFields{}
 
 func (f *pullFlagFields) Init(cmd *cobra.Command) {
-	cmd.Flags().StringVar(&f.sourceToken, "source-token", "", "A token to access the API of GitHub.com. This is normally not required, but can be provided if you have issues with API rate limiting.")
+	cmd.Flags().StringVar(&f.sourceURL, "source-url", pull.DefaultSourceURL, "The URL of the GitHub instance to pull from. This can be another GitHub Enterprise Server instance that the CodeQL Action has already been pushed to.")
+	cmd.Flags().StringVar(&f.sourceRepository, "source-repository", pull.DefaultSourceRepository, "The name of the repository to pull the CodeQL Action from.")
+	cmd.Flags().StringVar(&f.sourceDirectory, "source-directory", "", "A local directory to pull the CodeQL Action and bundles from instead of a GitHub instance, such as a staging area that files are transferred into.")
+	cmd.MarkFlagDirname("source-directory")
+	cmd.Flags().StringVar(&f.sourceToken, "source-token", "", "A token to access the GitHub instance being pulled from, for both the API and Git. This is normally not required for GitHub.com, but raises the API rate limit from 60 requests an hour, and is needed to pull from a private repository.")
+	cmd.Flags().BoolVar(&f.codeqlCLI, "codeql-cli", false, "Pull the standalone CodeQL CLI releases, with the zip files for every platform, instead of the CodeQL Action. The source repository defaults to "+pull.DefaultCLISourceRepository+", and only the latest release is pulled unless more are kept with --keep-last.")
+	cmd.Flags().BoolVar(&f.codeqlQueries, "codeql-queries", false, "Pull the Git repository of the CodeQL queries and libraries, which has no releases, instead of the CodeQL Action. The source repository defaults to "+pull.DefaultQueriesSourceRepository+".")
+	cmd.Flags().BoolVar(&f.minimizeTransfer, "minimize-transfer", false, "Only download the smallest set of CodeQL bundles that supports all of the languages given with --languages.")
+	cmd.Flags().IntVar(&f.gitDepth, "git-depth", 0, "Only pull this many of the most recent commits of each branch and tag of the CodeQL Action, rather than its full history. A cache pulled this way can only be pushed to a destination that already has the older history.")
+	cmd.Flags().BoolVar(&f.submodules, "submodules", false, "Also pull the repositories of the submodules of the CodeQL Action, so that they can be pushed alongside it. The submodule URLs are rewritten to point at the pushed copies, which changes the hashes of the commits that use them.")
+	cmd.Flags().IntVar(&f.submoduleDepth, "submodule-depth", 1, "How many levels of submodules of submodules to pull with --submodules. The default only pulls the submodules of the CodeQL Action itself.")
+	cmd.Flags().StringSliceVar(&f.includeRefs, "include-refs", []string{}, "A comma-separated list of patterns of the branches and tags of the CodeQL Action to pull, for example main,v*. If not specified all of them are pulled.")
+	cmd.Flags().StringSliceVar(&f.excludeRefs, "exclude-refs", []string{}, "A comma-separated list of patterns of branches and tags of the CodeQL Action to never pull into the cache, for example dependabot/*.")
+	cmd.Flags().BoolVar(&f.verifySignatures, "verify-signatures", false, "Check that every branch and tag pulled points at a commit or tag signed with a trusted key, and stop the pull if one does not. Unless --signing-keys is given, the key GitHub.com signs commits made on the web with is trusted.")
+	cmd.Flags().StringSliceVar(&f.signingKeys, "signing-keys", []string{}, "A comma-separated list of files of armored OpenPGP public keys to trust with --verify-signatures, instead of the key of GitHub.com. Implies --verify-signatures.")
+	cmd.MarkFlagFilename("signing-keys")
+	cmd.Flags().BoolVar(&f.includePrereleases, "include-prereleases", false, "Also pull releases that are marked as prereleases, such as beta CodeQL bundles, for example to try them out on a staging GitHub Enterprise Server instance. By default only stable releases are pulled, and prereleases from an earlier pull are removed from the cache.")
+	cmd.Flags().BoolVar(&f.repack, "repack", false, "Repack the Git repository in the cache after pulling, even if it has not yet built up enough packs or loose objects to be repacked automatically.")
+	cmd.Flags().StringSliceVar(&f.packs, "packs", []string{}, "A comma-separated list of CodeQL packs to also pull from the container registry of the source, for example codeql/java-queries,codeql/cpp-queries@1.0.0, so that they can be pushed to the container registry of the destination. The latest version of a pack is pulled unless one is given.")
+	cmd.Flags().StringVar(&f.maxDownloadRate, "max-download-rate", "", "The maximum rate to download at in bytes per second, for example 500K or 10M. If not specified downloads are not throttled.")
+}
+
+// submoduleLevels returns how many levels of submodules to pull, which is 0 unless `--submodules` is given.
+func (f *pullFlagFields) submoduleLevels() (int, error) {
+	if !f.submodules {
+		return 0, nil
+	}
+	if f.submoduleDepth < 1 {
+		return 0, usererrors.New(errorSubmoduleDepth)
+	}
+	return f.submoduleDepth, nil
+}
+
+func (f *pullFlagFields) source() (pull.Source, error) {
+	if f.codeqlCLI && f.codeqlQueries {
+		return pull.Source{}, usererrors.New(errorCLIWithQueries)
+	}
+	if f.sourceDirectory != "" && len(f.packs) != 0 {
+		return pull.Source{}, usererrors.New(errorPacksWithSourceDirectory)
+	}
+	var source pull.Source
+	var err error
+	if f.sourceDirectory != "" {
+		if f.sourceURL != pull.DefaultSourceURL || f.sourceRepository != pull.DefaultSourceRepository {
+			return pull.Source{}, usererrors.New(errorSourceDirectoryWithURL)
+		}
+		source, err = pull.NewDirectorySource(f.sourceDirectory)
+	} else {
+		sourceRepository := f.sourceRepository
+		if sourceRepository == pull.DefaultSourceRepository {
+			if f.codeqlCLI {
+				sourceRepository = pull.DefaultCLISourceRepository
+			} else if f.codeqlQueries {
+				sourceRepository = pull.DefaultQueriesSourceRepository
+			}
+		}
+		source, err = pull.NewSource(f.sourceURL, sourceRepository)
+	}
+	if err != nil {
+		return pull.Source{}, err
+	}
+	if f.codeqlCLI {
+		source.Kind = cachedirectory.SourceKindCLI
+	} else if f.codeqlQueries {
+		source.Kind = cachedirectory.SourceKindQueries
+	}
+	return source, nil
+}
+
+// options collects the settings for a pull from the flags, which are the same for `pull` and `sync`.
+func (f *pullFlagFields) options(source pull.Source, submoduleDepth int, languageMapping *assetselection.Mapping, retentionPolicy retention.Policy) pull.Options {
+	return pull.Options{
+		Source:             source,
+		SourceToken:        f.sourceToken,
+		LanguageMapping:    languageMapping,
+		Languages:          languageFlags.languages,
+		MinimizeTransfer:   f.minimizeTransfer,
+		GitDepth:           f.gitDepth,
+		SubmoduleDepth:     submoduleDepth,
+		IncludeRefs:        f.includeRefs,
+		ExcludeRefs:        f.excludeRefs,
+		VerifySignatures:   f.verifySignatures,
+		SigningKeyFiles:    f.signingKeys,
+		Strict:             strictFlags.strict,
+		IncludePrereleases: f.includePrereleases,
+		IncludeDrafts:      draftFlags.includeDrafts,
+		RetentionPolicy:    retentionPolicy,
+		Repack:             f.repack,
+		Limits:             concurrencyFlags.limits(),
+		ShowProgress:       !progressFlags.noProgress,
+	}
+}
+
+// pullPacks pulls the CodeQL packs given with `--packs` from the container registry of the source instance.
+func (f *pullFlagFields) pullPacks(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory) error {
+	if len(f.packs) == 0 {
+		return nil
+	}
+	registryURL, err := packs.RegistryURL(f.sourceURL)
+	if err != nil {
+		return err
+	}
+	return packs.Pull(ctx, cacheDirectory, registryURL, f.sourceToken, f.packs)
 }
@@ -1,20 +1,74 @@
 package cmd
 
 import (
+	"context"
+	usererrors "errors"
+	"net/url"
+
+	"github.com/github/codeql-action-sync/internal/assetselection"
 	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/httptransport"
+	"github.com/github/codeql-action-sync/internal/packs"
 	"github.com/github/codeql-action-sync/internal/push"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/internal/retry"
 	"github.com/github/codeql-action-sync/internal/version"
 	"github.com/spf13/cobra"
 )
 
+const errorPinWithoutHTTPS = "The `--destination-pin` flag can only be used with a destination URL that uses HTTPS."
+
 var pushCmd = &cobra.Command{
 	Use:   "push",
 	Short: "Push the CodeQL Action from the local cache to a GitHub Enterprise Server installation.",
-	RunE: func(cmd *cobra.Command, args []string) error {
+	RunE: withSummary(func(ctx context.Context, cmd *cobra.Command, args []string) error {
 		version.LogVersion()
-		cacheDirectory := cachedirectory.NewCacheDirectory(rootFlags.cacheDir)
-		return push.Push(cmd.Context(), cacheDirectory, pushFlags.destinationURL, pushFlags.destinationToken, pushFlags.destinationRepository, pushFlags.actionsAdminUser, pushFlags.force, pushFlags.pushSSH)
-	},
+		ctx, collector := failureFlags.collect(ctx)
+		err := installRateLimits("", pushFlags.maxUploadRate)
+		if err != nil {
+			return err
+		}
+		err = pushFlags.pinDestination()
+		if err != nil {
+			return err
+		}
+		cacheDirectory, err := readOnlyFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		err = pushFlags.resolveDestinationRepository(cacheDirectory)
+		if err != nil {
+			return err
+		}
+		languageMapping, err := languageFlags.mapping()
+		if err != nil {
+			return err
+		}
+		retentionPolicy, err := retentionFlags.policy()
+		if err != nil {
+			return err
+		}
+		attestation, err := pushFlags.attestation()
+		if err != nil {
+			return err
+		}
+		ctx, auditLog, err := pushFlags.openAuditLog(ctx)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		ctx = retry.WithPolicy(ctx, retryFlags.policy())
+		ctx = retry.WithBreaker(ctx, retryFlags.destinationBreaker())
+		err = push.Push(ctx, cacheDirectory, pushFlags.options(languageMapping, attestation, retentionPolicy))
+		if err != nil {
+			return err
+		}
+		err = pushFlags.pushPacks(ctx, cacheDirectory)
+		if err != nil {
+			return err
+		}
+		return collector.Err()
+	}),
 }
 
 type pushFlagFields struct {
@@ -22,8 +76,32 @@ type pushFlagFields struct {
 	destinationToken      string
 	destinationRepository string
 	actionsAdminUser      string
+	createOrganization    bool
+	organizationAdmin     string
+	noSiteAdmin           bool
 	force                 bool
+	safePush              bool
+	releaseRefsOnly       bool
+	pruneRefs             bool
+	protectedRefs         []string
 	pushSSH               bool
+	maxUploadRate         string
+	verify                bool
+	verifyUploads         bool
+	auditLog              string
+	repositoryDescription string
+	repositoryHomepage    string
+	defaultBranch         string
+	visibility            string
+	updateVisibility      bool
+	actionsPolicyLevel    string
+	enterprise            string
+	repositoryTopics      []string
+	attest                bool
+	attestationKey        string
+	sbom                  bool
+	registryURL           string
+	destinationPins       []string
 }
 
 var pushFlags = pushFlagFields{}
@@ -33,8 +111,125 @@ func (f *pushFlagFields) Init(cmd *cobra.Command) {
 	cmd.MarkFlagRequired("destination-url")
 	cmd.Flags().StringVar(&f.destinationToken, "destination-token", "", "A token to access the API on the GitHub Enterprise instance.")
 	cmd.MarkFlagRequired("destination-token")
-	cmd.Flags().StringVar(&f.destinationRepository, "destination-repository", "github/codeql-action", "The name of the repository to create on GitHub Enterprise.")
+	cmd.Flags().StringVar(&f.destinationRepository, "destination-repository", push.DefaultDestinationRepository, "The name of the repository to create on GitHub Enterprise. This can be a template such as {{.owner}}/{{.name}}-mirror, which is filled in with the owner and name of the source repository.")
+	cmd.Flags().StringSliceVar(&f.destinationPins, "destination-pin", []string{}, "A comma-separated list of pins, each either sha256/ followed by the Base64 SHA-256 hash of a public key or a file of PEM certificates, one of which the GitHub Enterprise instance and its subdomains must present over HTTPS.")
 	cmd.Flags().StringVar(&f.actionsAdminUser, "actions-admin-user", "actions-admin", "The name of the Actions admin user.")
+	cmd.Flags().BoolVar(&f.createOrganization, "create-org", false, "Create the organization of the destination repository if it does not exist, which requires a token with the site_admin scope. If not specified the push fails instead.")
+	cmd.Flags().StringVar(&f.organizationAdmin, "org-admin", "", "The user to make the admin of the organization if it is created. If not specified the user the destination token belongs to is used.")
+	cmd.Flags().BoolVar(&f.noSiteAdmin, "no-site-admin", false, "Never use site admin access, skipping steps that need it and failing before anything is changed if the push cannot be done without it.")
+	cmd.Flags().StringVar(&f.repositoryDescription, "repository-description", "", "The description to set on the destination repository, for example to say who maintains it. If not specified a new repository is given a description pointing at the upstream CodeQL Action, and the description of an existing one is left unchanged.")
+	cmd.Flags().StringVar(&f.repositoryHomepage, "repository-homepage", push.DefaultRepositoryHomepage, "The homepage to set on the destination repository.")
+	cmd.Flags().StringVar(&f.defaultBranch, "default-branch", "", "The branch to make the default branch of the destination repository. If not specified the default branch of the source is used.")
+	cmd.Flags().StringVar(&f.visibility, "destination-visibility", push.VisibilityPublic, "The visibility to create the destination repository with, one of public, internal or private.")
+	cmd.Flags().BoolVar(&f.updateVisibility, "update-visibility", false, "Also change the visibility of an existing destination repository to the one given with --destination-visibility.")
+	cmd.Flags().StringVar(&f.actionsPolicyLevel, "update-actions-policy", "", "After pushing, allow the destination repository in the Actions policy of its organization or the enterprise, one of organization or enterprise, if the policy only allows selected actions.")
+	cmd.Flags().StringVar(&f.enterprise, "enterprise", "", "The slug of the enterprise whose Actions policy to update with --update-actions-policy enterprise.")
+	cmd.Flags().StringSliceVar(&f.repositoryTopics, "repository-topics", nil, "A comma-separated list of topics to set on the destination repository. If not specified a new repository is given the topics codeql, code-scanning and github-actions, and the topics of an existing one are left unchanged.")
 	cmd.Flags().BoolVar(&f.force, "force", false, "Replace the existing repository even if it was not created by the sync tool.")
+	cmd.Flags().BoolVar(&f.safePush, "safe-push", false, "Refuse to update or delete Git references on the destination that point to commits not in the cache, rather than overwriting them.")
+	cmd.Flags().BoolVar(&f.releaseRefsOnly, "release-refs-only", false, "Only push main, major version and release branches such as v3 and releases/v3, and tags, deleting any other branches from the destination.")
+	cmd.Flags().BoolVar(&f.pruneRefs, "prune-refs", true, "Delete Git references from the destination that are no longer in the cache, such as branches deleted or renamed upstream. Use --prune-refs=false to leave them on the destination.")
+	cmd.Flags().StringSliceVar(&f.protectedRefs, "protected-refs", []string{}, "A comma-separated list of patterns of Git references on the destination that are never deleted, such as branches added there directly, for example local/*,refs/tags/internal-*.")
 	cmd.Flags().BoolVar(&f.pushSSH, "push-ssh", false, "Push Git contents over SSH rather than HTTPS. To use this option you must have SSH access to your GitHub Enterprise instance configured.")
+	cmd.Flags().BoolVar(&f.verify, "verify", false, "After pushing, check that the destination resolves each major version of the Action entry points, such as init@v1, to the pushed metadata.")
+	cmd.Flags().BoolVar(&f.verifyUploads, "verify-uploads", false, "After uploading each release asset, download it again and check that it matches the cache, removing it if it does not.")
+	cmd.Flags().BoolVar(&f.attest, "attest", false, "Attach a provenance attestation describing where the assets came from to every pushed release.")
+	cmd.Flags().StringVar(&f.attestationKey, "attestation-key", "", "A PEM file with an ECDSA, Ed25519 or RSA private key to sign provenance attestations with. Implies --attest.")
+	cmd.Flags().BoolVar(&f.sbom, "sbom", false, "Attach a CycloneDX bill of materials listing the CLI, extractors and packs in each pushed CodeQL bundle to its release.")
+	cmd.Flags().StringVar(&f.registryURL, "registry-url", "", "The URL of the container registry to push CodeQL packs in the cache to. If not specified the container registry of the GitHub Enterprise instance is used, which is served from its containers subdomain.")
+	cmd.Flags().StringVar(&f.auditLog, "audit-log", "", "A file to append a tamper-evident record of every change made to GitHub Enterprise Server to.")
+	cmd.Flags().StringVar(&f.maxUploadRate, "max-upload-rate", "", "The maximum rate to upload at in bytes per second, for example 500K or 10M. If not specified uploads are not throttled.")
+}
+
+func (f *pushFlagFields) attestation() (push.Attestation, error) {
+	if f.attestationKey == "" {
+		return push.Attestation{Enabled: f.attest}, nil
+	}
+	key, err := push.LoadAttestationKey(f.attestationKey)
+	if err != nil {
+		return push.Attestation{}, err
+	}
+	return push.Attestation{Enabled: true, Key: key}, nil
+}
+
+// resolveDestinationRepository replaces a destination repository given as a template with the name it resolves to for the repository the cache was pulled from.
+func (f *pushFlagFields) resolveDestinationRepository(cacheDirectory cachedirectory.CacheDirectory) error {
+	destinationRepository, err := push.ResolveDestinationRepository(cacheDirectory, f.destinationRepository)
+	if err != nil {
+		return err
+	}
+	f.destinationRepository = destinationRepository
+	return nil
+}
+
+// pinDestination makes connections to the destination instance fail unless it presents one of the certificates or public keys given with `--destination-pin`.
+func (f *pushFlagFields) pinDestination() error {
+	if len(f.destinationPins) == 0 {
+		return nil
+	}
+	destinationURL, err := url.Parse(f.destinationURL)
+	if err != nil || destinationURL.Scheme != "https" || destinationURL.Hostname() == "" {
+		return usererrors.New(errorPinWithoutHTTPS)
+	}
+	return httptransport.Pin(destinationURL.Hostname(), f.destinationPins)
+}
+
+// options collects the settings for a push from the flags, which are the same for `push` and `sync`.
+func (f *pushFlagFields) options(languageMapping *assetselection.Mapping, attestation push.Attestation, retentionPolicy retention.Policy) push.Options {
+	return push.Options{
+		DestinationURL:        f.destinationURL,
+		DestinationToken:      f.destinationToken,
+		DestinationRepository: f.destinationRepository,
+		ActionsAdminUser:      f.actionsAdminUser,
+		CreateOrganization:    f.createOrganization,
+		OrganizationAdmin:     f.organizationAdmin,
+		NoSiteAdmin:           f.noSiteAdmin,
+		RepositoryMetadata:    f.repositoryMetadata(),
+		ActionsPolicy:         f.actionsPolicy(),
+		LanguageMapping:       languageMapping,
+		Languages:             languageFlags.languages,
+		Force:                 f.force,
+		SafePush:              f.safePush,
+		ReleaseRefsOnly:       f.releaseRefsOnly,
+		PruneRefs:             f.pruneRefs,
+		ProtectedRefs:         f.protectedRefs,
+		IncludeDrafts:         draftFlags.includeDrafts,
+		PushSSH:               f.pushSSH,
+		Verify:                f.verify,
+		VerifyUploads:         f.verifyUploads,
+		Strict:                strictFlags.strict,
+		Attestation:           attestation,
+		SBOM:                  f.sbom,
+		RetentionPolicy:       retentionPolicy,
+		Limits:                concurrencyFlags.limits(),
+		ShowProgress:          !progressFlags.noProgress,
+	}
+}
+
+func (f *pushFlagFields) actionsPolicy() push.ActionsPolicy {
+	return push.ActionsPolicy{Level: f.actionsPolicyLevel, Enterprise: f.enterprise}
+}
+
+func (f *pushFlagFields) repositoryMetadata() push.RepositoryMetadata {
+	return push.RepositoryMetadata{
+		Description:      f.repositoryDescription,
+		Homepage:         f.repositoryHomepage,
+		Topics:           f.repositoryTopics,
+		DefaultBranch:    f.defaultBranch,
+		Visibility:       f.visibility,
+		UpdateVisibility: f.updateVisibility,
+	}
+}
+
+// pushPacks pushes any CodeQL packs in the cache to the container registry of the destination instance, unless another registry is given with `--registry-url`.
+func (f *pushFlagFields) pushPacks(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory) error {
+	registryURL := f.registryURL
+	if registryURL == "" {
+		var err error
+		registryURL, err = packs.RegistryURL(f.destinationURL)
+		if err != nil {
+			return err
+		}
+	}
+	return packs.Push(ctx, cacheDirectory, registryURL, f.destinationToken)
 }
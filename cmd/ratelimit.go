@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	"github.com/github/codeql-action-sync/internal/ratelimit"
+)
+
+func installRateLimits(maxDownloadRate string, maxUploadRate string) error {
+	downloadRate, err := ratelimit.ParseRate(maxDownloadRate)
+	if err != nil {
+		return err
+	}
+	uploadRate, err := ratelimit.ParseRate(maxUploadRate)
+	if err != nil {
+		return err
+	}
+	ratelimit.Install(downloadRate, uploadRate)
+	return nil
+}
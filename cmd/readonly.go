@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	usererrors "errors"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const errorWorkDirectoryWithoutReadOnly = "`--work-dir` can only be used with `--read-only-cache`."
+
+type readOnlyFlagFields struct {
+	readOnlyCache bool
+	workDirectory string
+}
+
+var readOnlyFlags = readOnlyFlagFields{}
+
+func (f *readOnlyFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&f.readOnlyCache, "read-only-cache", false, "Never write to the cache, for example because it is mounted from a DVD or a write-blocked USB drive. The run lock and the record of where the cache has been pushed to are kept in --work-dir instead.")
+	cmd.Flags().StringVar(&f.workDirectory, "work-dir", "", "The directory to keep the state of a read-only cache in. Use the same directory for every push from the cache, so that each push knows what earlier ones pushed. If not specified a directory in the system temporary directory is used.")
+	cmd.MarkFlagDirname("work-dir")
+}
+
+// openCacheDirectory opens the cache given with `--cache-dir`, making it read-only if `--read-only-cache` is given.
+func (f *readOnlyFlagFields) openCacheDirectory() (cachedirectory.CacheDirectory, error) {
+	if f.workDirectory != "" && !f.readOnlyCache {
+		return cachedirectory.CacheDirectory{}, usererrors.New(errorWorkDirectoryWithoutReadOnly)
+	}
+	cacheDirectory, err := rootFlags.openCacheDirectory()
+	if err != nil || !f.readOnlyCache {
+		return cacheDirectory, err
+	}
+	err = cacheDirectory.SetReadOnly(f.workDirectory)
+	if err != nil {
+		return cacheDirectory, err
+	}
+	log.Infof("Treating the cache as read-only, and keeping state in %s.", cacheDirectory.WorkDirectory())
+	return cacheDirectory, nil
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/spf13/cobra"
+)
+
+type retentionFlagFields struct {
+	keepLast int
+	since    string
+}
+
+var retentionFlags = retentionFlagFields{}
+
+func (f *retentionFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&f.keepLast, "keep-last", 0, "Only handle the given number of most recent CodeQL bundle releases used by main and each major version of the CodeQL Action, removing older releases from the cache when pulling. If not specified only the releases currently in use are pulled, and every release in the cache is pushed. For the CodeQL CLI, the most recently published releases are handled instead.")
+	cmd.Flags().StringVar(&f.since, "since", "", "Only handle CodeQL bundle releases published after the given date, for example 2020-06-30, or use "+retention.SinceLastSync+" to skip releases already handled by the last successful run.")
+}
+
+func (f *retentionFlagFields) policy() (retention.Policy, error) {
+	return retention.NewPolicy(f.keepLast, f.since)
+}
+
+// InitPrune registers the retention flags of the prune command, which decide what is kept on the destination rather than what is synced.
+func (f *retentionFlagFields) InitPrune(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&f.keepLast, "keep-last", 0, "Keep the given number of most recent CodeQL bundle releases used by main and each major version of the CodeQL Action in the cache, or of the most recently published CodeQL CLI releases in a cache of the CodeQL CLI, deleting older releases.")
+	cmd.Flags().StringVar(&f.since, "since", "", "Delete CodeQL bundle releases published before the given date, for example 2020-06-30.")
+}
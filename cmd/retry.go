@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/spf13/cobra"
+)
+
+type retryFlagFields struct {
+	retries           int
+	delay             time.Duration
+	maxDelay          time.Duration
+	maxTime           time.Duration
+	maintenanceWindow time.Duration
+	breakerThreshold  int
+	breaker           *retry.Breaker
+}
+
+var retryFlags = retryFlagFields{}
+
+// Init adds the flags of the retry budget, which is shared by every command that talks to a GitHub instance so that API calls, downloads, uploads and Git operations are all retried in the same way. Commands that change a destination also get `--circuit-breaker-threshold`.
+func (f *retryFlagFields) Init(cmd *cobra.Command, destination bool) {
+	cmd.Flags().IntVar(&f.retries, "retries", retry.DefaultRetries, "The number of times to retry an API call, asset download or upload, or Git operation that fails with a transient error, such as a 502 from a load balancer or a dropped connection.")
+	cmd.Flags().DurationVar(&f.delay, "retry-delay", retry.DefaultDelay, "The delay before the first retry of a failed request, which doubles after each retry.")
+	cmd.Flags().DurationVar(&f.maxDelay, "retry-max-delay", retry.DefaultMaxDelay, "The longest delay between retries of a failed request, however many retries have been made.")
+	cmd.Flags().DurationVar(&f.maxTime, "retry-max-time", 0, "The longest time to spend retrying a failed request, from when it first fails, after which it is given up on even if retries are left. If not specified only --retries limits the retries.")
+	cmd.Flags().DurationVar(&f.maintenanceWindow, "maintenance-window", retry.DefaultMaintenanceWindow, "How long to wait for GitHub Enterprise Server to come out of maintenance mode before giving up. Use 0 to treat maintenance mode like any other transient error.")
+	if destination {
+		cmd.Flags().IntVar(&f.breakerThreshold, "circuit-breaker-threshold", retry.DefaultBreakerThreshold, "The number of requests to the destination in a row that can fail with a transient error, even after retrying them, before the destination is treated as down and the rest of the run fails straight away rather than retrying every remaining request. Use 0 to always retry every request.")
+	}
+}
+
+func (f *retryFlagFields) policy() retry.Policy {
+	return retry.Policy{Retries: f.retries, Delay: f.delay, MaxDelay: f.maxDelay, MaxTime: f.maxTime, MaintenanceWindow: f.maintenanceWindow}
+}
+
+// destinationBreaker returns the breaker for the requests made to the destination, which is shared by everything the run pushes so that it stays open once the destination is found to be down.
+func (f *retryFlagFields) destinationBreaker() *retry.Breaker {
+	if f.breaker == nil {
+		f.breaker = retry.NewBreaker(strings.TrimRight(pushFlags.destinationURL, "/"), f.breakerThreshold)
+	}
+	return f.breaker
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/selftest"
+	"github.com/spf13/cobra"
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Run a pull, transfer and push against a local fake GitHub to check the tool works on this machine.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return selftest.Run(cmd.Context(), selftestFlags.workDir, os.Stdout)
+	},
+}
+
+type selftestFlagFields struct {
+	workDir string
+}
+
+var selftestFlags = selftestFlagFields{}
+
+func (f *selftestFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.workDir, "work-dir", "", "The directory to create temporary files in. Use a directory on the same filesystem as your cache to test it. If not specified the system temporary directory will be used.")
+	cmd.MarkFlagDirname("work-dir")
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/status"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Display the state of the local cache, including the progress of any interrupted downloads.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cacheDirectory, err := rootFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		return status.Status(cacheDirectory, os.Stdout)
+	},
+}
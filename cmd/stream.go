@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type streamFlagFields struct {
+	streamAssets bool
+}
+
+var streamFlags = streamFlagFields{}
+
+func (f *streamFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&f.streamAssets, "stream-assets", false, "Stream release assets from the source to the destination rather than storing them in the cache, for example when copying between two GitHub Enterprise Server instances that can both be reached. Only the Git repository and release metadata are kept in --cache-dir, which must be a local directory.")
+}
+
+// openCacheDirectory opens the cache given with `--cache-dir`, streaming its assets if `--stream-assets` is given.
+func (f *streamFlagFields) openCacheDirectory() (cachedirectory.CacheDirectory, error) {
+	cacheDirectory, err := rootFlags.openCacheDirectory()
+	if err != nil || !f.streamAssets {
+		return cacheDirectory, err
+	}
+	err = cacheDirectory.SetStreaming()
+	if err != nil {
+		return cacheDirectory, err
+	}
+	log.Info("Streaming release assets from the source rather than storing them in the cache.")
+	return cacheDirectory, nil
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type strictFlagFields struct {
+	strict bool
+}
+
+var strictFlags = strictFlagFields{}
+
+// Init adds `--strict`, which is shared by `pull` and `push` so that `sync` only takes it once.
+func (f *strictFlagFields) Init(cmd *cobra.Command, pull bool, push bool) {
+	usage := "Fail rather than warn if"
+	if pull {
+		usage += " a branch or tag of the source has been rewritten so that a release tag already in the cache is moved, deleted or orphaned"
+	}
+	if pull && push {
+		usage += ", or if"
+	}
+	if push {
+		usage += " the GitHub Enterprise Server version is too old for some of the versions of the CodeQL Action being pushed"
+	}
+	cmd.Flags().BoolVar(&f.strict, "strict", false, usage+".")
+}
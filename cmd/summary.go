@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/github/codeql-action-sync/internal/telemetry"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// withSummary wraps a command so that, if notifications, a machine-readable summary, metrics or telemetry are asked for, the result is reported whether the command succeeds or fails. The context passed to the command carries the recorder used to build the summary, the collector of metrics and the recorder of telemetry.
+func withSummary(run func(ctx context.Context, cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		err := outputFlags.validate()
+		if err != nil {
+			return err
+		}
+		if !notifyFlags.enabled() && !outputFlags.enabled() && outputFlags.metricsFile == "" && telemetryFlags.url == "" {
+			return run(cmd.Context(), cmd, args)
+		}
+		startedAt := time.Now()
+		counter := notify.InstallCounter()
+		ctx := cmd.Context()
+		var recorder *report.Recorder
+		if outputFlags.enabled() {
+			recorder = report.NewRecorder()
+			log.AddHook(recorder)
+			ctx = report.WithRecorder(ctx, recorder)
+		}
+		var collector *metrics.Collector
+		if outputFlags.metricsFile != "" {
+			collector = metrics.NewCollector()
+			collector.Install()
+			ctx = metrics.WithCollector(ctx, collector)
+		}
+		var telemetryRecorder *telemetry.Recorder
+		if telemetryFlags.url != "" {
+			telemetryRecorder = telemetry.NewRecorder()
+			ctx = telemetry.WithRecorder(ctx, telemetryRecorder)
+		}
+		pushes := cmd.Flags().Lookup("destination-url") != nil
+		var previouslyPushedReleases []string
+		if pushes {
+			previouslyPushedReleases = pushedReleases()
+		}
+		err = run(ctx, cmd, args)
+		summary := notify.NewSummary(cmd.Name(), startedAt, cachedReleases(), counter, err)
+		if pushes {
+			summary.Destination = strings.TrimRight(pushFlags.destinationURL, "/") + "/" + pushFlags.destinationRepository
+			summary.DestinationFailedFast = retryFlags.breaker.Open()
+			if err == nil {
+				summary.NewReleases = newReleases(previouslyPushedReleases, pushedReleases())
+			}
+		}
+		if notifyFlags.webhook != "" {
+			notifyErr := notify.Send(cmd.Context(), notifyFlags.webhook, summary)
+			if notifyErr != nil {
+				log.Errorf("%+v", notifyErr)
+			}
+		}
+		if notifyFlags.slackWebhook != "" {
+			notifyErr := notify.SendSlack(cmd.Context(), notifyFlags.slackWebhook, notifyFlags.slackChannel, summary)
+			if notifyErr != nil {
+				log.Errorf("%+v", notifyErr)
+			}
+		}
+		if recorder != nil {
+			outputErr := outputFlags.write(recorder.Document(summary))
+			if outputErr != nil {
+				log.Errorf("%+v", outputErr)
+			}
+		}
+		if collector != nil {
+			metricsErr := outputFlags.writeMetrics(collector.Document(summary))
+			if metricsErr != nil {
+				log.Errorf("%+v", metricsErr)
+			}
+		}
+		if telemetryRecorder != nil {
+			telemetryReport := telemetryRecorder.Report(cmd.Name(), time.Since(startedAt), err == nil)
+			log.Debugf("Sending telemetry: %+v", telemetryReport)
+			// Telemetry is only for the maintainers, so failing to send it is never reported as a problem.
+			telemetryErr := telemetry.Send(cmd.Context(), telemetryFlags.url, telemetryReport)
+			if telemetryErr != nil {
+				log.Debugf("%+v", telemetryErr)
+			}
+		}
+		return err
+	}
+}
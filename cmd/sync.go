@@ -1,9 +1,11 @@
 package cmd
 
 import (
-	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"context"
+
 	"github.com/github/codeql-action-sync/internal/pull"
 	"github.com/github/codeql-action-sync/internal/push"
+	"github.com/github/codeql-action-sync/internal/retry"
 	"github.com/github/codeql-action-sync/internal/version"
 	"github.com/spf13/cobra"
 )
@@ -11,17 +13,70 @@ import (
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Sync the CodeQL Action from GitHub to a GitHub Enterprise Server installation.",
-	RunE: func(cmd *cobra.Command, args []string) error {
+	RunE: withSummary(func(ctx context.Context, cmd *cobra.Command, args []string) error {
 		version.LogVersion()
-		cacheDirectory := cachedirectory.NewCacheDirectory(rootFlags.cacheDir)
-		err := pull.Pull(cmd.Context(), cacheDirectory, pullFlags.sourceToken)
+		ctx, collector := failureFlags.collect(ctx)
+		err := installRateLimits(pullFlags.maxDownloadRate, pushFlags.maxUploadRate)
+		if err != nil {
+			return err
+		}
+		err = pushFlags.pinDestination()
+		if err != nil {
+			return err
+		}
+		cacheDirectory, err := streamFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		languageMapping, err := languageFlags.mapping()
+		if err != nil {
+			return err
+		}
+		retentionPolicy, err := retentionFlags.policy()
+		if err != nil {
+			return err
+		}
+		source, err := pullFlags.source()
+		if err != nil {
+			return err
+		}
+		submoduleDepth, err := pullFlags.submoduleLevels()
+		if err != nil {
+			return err
+		}
+		attestation, err := pushFlags.attestation()
+		if err != nil {
+			return err
+		}
+		ctx, auditLog, err := pushFlags.openAuditLog(ctx)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		ctx = retry.WithPolicy(ctx, retryFlags.policy())
+		err = pull.Pull(ctx, cacheDirectory, pullFlags.options(source, submoduleDepth, languageMapping, retentionPolicy))
+		if err != nil {
+			return err
+		}
+		err = pullFlags.pullPacks(ctx, cacheDirectory)
+		if err != nil {
+			return err
+		}
+		// A template is resolved for the source that has just been pulled.
+		err = pushFlags.resolveDestinationRepository(cacheDirectory)
+		if err != nil {
+			return err
+		}
+		// Only the requests to the destination are counted by the breaker, so a flaky source does not stop the push.
+		ctx = retry.WithBreaker(ctx, retryFlags.destinationBreaker())
+		err = push.Push(ctx, cacheDirectory, pushFlags.options(languageMapping, attestation, retentionPolicy))
 		if err != nil {
 			return err
 		}
-		err = push.Push(cmd.Context(), cacheDirectory, pushFlags.destinationURL, pushFlags.destinationToken, pushFlags.destinationRepository, pushFlags.actionsAdminUser, pushFlags.force, pushFlags.pushSSH)
+		err = pushFlags.pushPacks(ctx, cacheDirectory)
 		if err != nil {
 			return err
 		}
-		return nil
-	},
+		return collector.Err()
+	}),
 }
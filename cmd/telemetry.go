@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+type telemetryFlagFields struct {
+	url string
+}
+
+var telemetryFlags = telemetryFlagFields{}
+
+// Init registers the telemetry flag. There is no default endpoint, so nothing is ever sent unless one is given.
+func (f *telemetryFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.url, "telemetry-url", "", "Opt in to sending an anonymous report of each run to this URL once finished, with only the version of the sync tool, the command, whether it succeeded, the feature release of GitHub Enterprise Server and a range the duration fell in. If not specified nothing is sent.")
+}
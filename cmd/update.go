@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/update"
+	"github.com/spf13/cobra"
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update the sync tool to the latest release.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return update.Update(cmd.Context(), updateFlags.sourceURL, updateFlags.sourceToken, updateFlags.sourceRepository, updateFlags.version, updateFlags.check, os.Stdout)
+	},
+}
+
+type updateFlagFields struct {
+	sourceURL        string
+	sourceToken      string
+	sourceRepository string
+	version          string
+	check            bool
+}
+
+var updateFlags = updateFlagFields{}
+
+func (f *updateFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.sourceURL, "source-url", update.DefaultSourceURL, "The URL of the GitHub instance to find releases of the sync tool on. This can be a GitHub Enterprise Server instance with a mirror of the sync tool repository.")
+	cmd.Flags().StringVar(&f.sourceToken, "source-token", "", "A token to access the API of the GitHub instance releases are found on.")
+	cmd.Flags().StringVar(&f.sourceRepository, "source-repository", update.DefaultSourceRepository, "The repository to find releases of the sync tool in.")
+	cmd.Flags().StringVar(&f.version, "version", "", "The version to update to. If not specified the latest release is used.")
+	cmd.Flags().BoolVar(&f.check, "check", false, "Only report whether a different version is available, without updating.")
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/github/codeql-action-sync/internal/verify"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the Git repository, assets and releases in a local cache without using the network, before it is pushed.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		verify.InstallOfflineTransport()
+		cacheDirectory, err := rootFlags.openCacheDirectory()
+		if err != nil {
+			return err
+		}
+		return verify.Run(cacheDirectory, os.Stdout, verifyFlags.output)
+	},
+}
+
+type verifyFlagFields struct {
+	output string
+}
+
+var verifyFlags = verifyFlagFields{}
+
+func (f *verifyFlagFields) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.output, "output", verify.OutputFormatText, "The format to print the result in, either text or json.")
+	cmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{verify.OutputFormatText, verify.OutputFormatJSON}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
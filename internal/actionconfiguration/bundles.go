@@ -0,0 +1,120 @@
+package actionconfiguration
+
+import (
+	"regexp"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var relevantReferences = regexp.MustCompile("^refs/(heads|tags)/(main|v\\d+)$")
+
+const defaultConfigurationPath = "src/defaults.json"
+
+// IsRelevantReference returns whether a reference is `main` or a major version of the CodeQL Action, whose default configuration is used to find the relevant bundles.
+func IsRelevantReference(name plumbing.ReferenceName) bool {
+	return relevantReferences.MatchString(name.String())
+}
+
+// commitBundleVersion returns the bundle version in the default configuration of a commit, or an empty string if the commit has no default configuration.
+func commitBundleVersion(commit *object.Commit) (string, error) {
+	file, err := commit.File(defaultConfigurationPath)
+	if err != nil {
+		if err == object.ErrFileNotFound {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "Error loading default configuration file from commit %s.", commit.Hash)
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return "", errors.Wrapf(err, "Error reading default configuration file content from commit %s.", commit.Hash)
+	}
+	configuration, err := Parse(content)
+	if err != nil {
+		return "", err
+	}
+	return configuration.BundleVersion, nil
+}
+
+// referenceBundleVersions returns the bundle versions used by the most recent commits of a reference, newest first, until keepLast different versions have been found. Older commits without a valid default configuration are skipped, and the history ends early if the repository is shallow.
+func referenceBundleVersions(repository *git.Repository, reference *plumbing.Reference, keepLast int) ([]string, error) {
+	commit, err := repository.CommitObject(reference.Hash())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error loading commit %s for reference %s.", reference.Hash(), reference.Name().String())
+	}
+	bundleVersion, err := commitBundleVersion(commit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading default configuration for reference %s.", reference.Name().String())
+	}
+	if bundleVersion == "" {
+		log.Debugf("Ignoring reference %s as it does not have a default configuration.", reference.Name().String())
+		return []string{}, nil
+	}
+	bundleVersions := []string{bundleVersion}
+	for len(bundleVersions) < keepLast && commit.NumParents() > 0 {
+		// Following the first parent keeps to the history of the branch itself rather than of whatever was merged into it.
+		commit, err = commit.Parent(0)
+		if err == plumbing.ErrObjectNotFound {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error loading history of reference %s.", reference.Name().String())
+		}
+		bundleVersion, err := commitBundleVersion(commit)
+		if err != nil {
+			log.Debugf("Ignoring commit %s of reference %s as its default configuration is not valid.", commit.Hash, reference.Name().String())
+			continue
+		}
+		if bundleVersion != "" && !contains(bundleVersions, bundleVersion) {
+			bundleVersions = append(bundleVersions, bundleVersion)
+		}
+	}
+	return bundleVersions, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BundleVersions returns the CodeQL bundle releases used by `main` and each major version of the CodeQL Action in a Git repository. By default only the bundle each of them currently uses is included. If keepLast is more than one, the history of each is followed to include that many of its most recent bundles.
+func BundleVersions(repository *git.Repository, keepLast int) ([]string, error) {
+	if keepLast < 1 {
+		keepLast = 1
+	}
+	references, err := repository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	defer references.Close()
+	releasesMap := map[string]bool{}
+	releases := []string{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if !IsRelevantReference(reference.Name()) {
+			return nil
+		}
+		log.Debugf("Found %s.", reference.Name().String())
+		bundleVersions, err := referenceBundleVersions(repository, reference, keepLast)
+		if err != nil {
+			return err
+		}
+		for _, bundleVersion := range bundleVersions {
+			if !releasesMap[bundleVersion] {
+				releasesMap[bundleVersion] = true
+				releases = append(releases, bundleVersion)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
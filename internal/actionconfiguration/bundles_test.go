@@ -0,0 +1,58 @@
+package actionconfiguration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func commitDefaults(t *testing.T, repository *git.Repository, content string) plumbing.Hash {
+	worktree, err := repository.Worktree()
+	require.NoError(t, err)
+	if content == "" {
+		_, err = worktree.Remove(defaultConfigurationPath)
+		require.NoError(t, err)
+	} else {
+		require.NoError(t, util.WriteFile(worktree.Filesystem, defaultConfigurationPath, []byte(content), 0644))
+		_, err = worktree.Add(defaultConfigurationPath)
+		require.NoError(t, err)
+	}
+	hash, err := worktree.Commit("Update defaults.", &git.CommitOptions{Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}})
+	require.NoError(t, err)
+	return hash
+}
+
+func TestBundleVersions(t *testing.T) {
+	repository, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+	first := commitDefaults(t, repository, `{"bundleVersion": "codeql-bundle-20200101"}`)
+	commitDefaults(t, repository, "This isn't valid JSON.")
+	commitDefaults(t, repository, `{"bundleVersion": "codeql-bundle-20200630"}`)
+	commitDefaults(t, repository, `{"bundleVersion": "codeql-bundle-20200630", "someOtherThing": "blah"}`)
+	latest := commitDefaults(t, repository, `{"bundleVersion": "codeql-bundle-20200701"}`)
+	withoutDefaults := commitDefaults(t, repository, "")
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), latest)))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("v1"), first)))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("v2"), withoutDefaults)))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("some-branch"), latest)))
+
+	bundleVersions, err := BundleVersions(repository, 0)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"codeql-bundle-20200701", "codeql-bundle-20200101"}, bundleVersions)
+
+	bundleVersions, err = BundleVersions(repository, 2)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"codeql-bundle-20200701", "codeql-bundle-20200630", "codeql-bundle-20200101"}, bundleVersions)
+
+	// Commits with an invalid configuration are skipped, and asking for more releases than there are is fine.
+	bundleVersions, err = BundleVersions(repository, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"codeql-bundle-20200701", "codeql-bundle-20200630", "codeql-bundle-20200101"}, bundleVersions)
+}
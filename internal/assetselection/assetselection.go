@@ -0,0 +1,349 @@
+package assetselection
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// allLanguages is used to mark assets, such as the full CodeQL bundle, that support every language.
+const allLanguages = "*"
+
+// maximumExhaustiveCandidates bounds the number of candidate assets for which every combination is tried. Beyond this a greedy selection is used.
+const maximumExhaustiveCandidates = 16
+
+type Asset struct {
+	Name string
+	Size int64
+}
+
+// BundleRule identifies CodeQL bundle assets by name and lists the languages they support. Languages may refer to submatches of the pattern, for example `$1`.
+type BundleRule struct {
+	Pattern   string   `json:"pattern"`
+	Languages []string `json:"languages"`
+
+	compiledPattern *regexp.Regexp
+}
+
+// Mapping describes which release assets provide analysis support for which languages, so that new bundle layouts published upstream can be supported without a new version of the sync tool.
+type Mapping struct {
+	Languages []string     `json:"languages"`
+	Bundles   []BundleRule `json:"bundles"`
+
+	includeAssets []string
+	excludeAssets []string
+}
+
+const defaultMappingJSON = `{
+	"languages": ["cpp", "csharp", "go", "java", "javascript", "python", "ruby", "swift"],
+	"bundles": [
+		{"pattern": "^codeql-bundle\\.tar\\.(gz|zst)$", "languages": ["*"]},
+		{"pattern": "^codeql-bundle-([a-z]+)\\.tar\\.(gz|zst)$", "languages": ["$1"]}
+	]
+}`
+
+// ParseMapping parses and validates a mapping in JSON format.
+func ParseMapping(mappingJSON []byte) (*Mapping, error) {
+	mapping := Mapping{}
+	err := json.Unmarshal(mappingJSON, &mapping)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing language mapping.")
+	}
+	if len(mapping.Languages) == 0 {
+		return nil, errors.New("The language mapping does not list any languages.")
+	}
+	for index := range mapping.Bundles {
+		rule := &mapping.Bundles[index]
+		rule.compiledPattern, err = regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing bundle pattern `%s` in language mapping.", rule.Pattern)
+		}
+		if len(rule.Languages) == 0 {
+			return nil, errors.Errorf("The bundle pattern `%s` in the language mapping does not list any languages.", rule.Pattern)
+		}
+	}
+	return &mapping, nil
+}
+
+// DefaultMapping returns the mapping for the bundles currently published upstream.
+func DefaultMapping() *Mapping {
+	mapping, err := ParseMapping([]byte(defaultMappingJSON))
+	if err != nil {
+		panic(err)
+	}
+	return mapping
+}
+
+// LoadMapping reads a mapping from a JSON file, or returns the default mapping if no file is given.
+func LoadMapping(path string) (*Mapping, error) {
+	if path == "" {
+		return DefaultMapping(), nil
+	}
+	mappingJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading language mapping file.")
+	}
+	return ParseMapping(mappingJSON)
+}
+
+// KnownLanguages returns all the languages that can be selected.
+func (mapping *Mapping) KnownLanguages() []string {
+	return append([]string{}, mapping.Languages...)
+}
+
+func (mapping *Mapping) isKnownLanguage(language string) bool {
+	for _, knownLanguage := range mapping.Languages {
+		if language == knownLanguage {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAssetPatterns limits the assets that are transferred to those whose names match any of the include patterns, if there are any, and none of the exclude patterns. In the patterns `*` matches any characters and `?` any single character, for example `*.tar.zst`.
+func (mapping *Mapping) SetAssetPatterns(include []string, exclude []string) error {
+	patterns := [][]string{}
+	for _, given := range [][]string{include, exclude} {
+		result := []string{}
+		for _, pattern := range given {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Errorf("The asset pattern `%s` is not valid.", pattern)
+			}
+			result = append(result, pattern)
+		}
+		patterns = append(patterns, result)
+	}
+	mapping.includeAssets, mapping.excludeAssets = patterns[0], patterns[1]
+	return nil
+}
+
+func matchesAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IncludesAsset returns whether an asset is allowed by the patterns given to SetAssetPatterns.
+func (mapping *Mapping) IncludesAsset(name string) bool {
+	if len(mapping.includeAssets) != 0 && !matchesAnyPattern(mapping.includeAssets, name) {
+		return false
+	}
+	return !matchesAnyPattern(mapping.excludeAssets, name)
+}
+
+// ParseLanguages validates and normalizes a list of languages provided by a user.
+func (mapping *Mapping) ParseLanguages(languages []string) ([]string, error) {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, language := range languages {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if language == "" || seen[language] {
+			continue
+		}
+		if !mapping.isKnownLanguage(language) {
+			return nil, errors.Errorf("The language `%s` is not recognized. Supported languages are: %s.", language, strings.Join(mapping.Languages, ", "))
+		}
+		seen[language] = true
+		result = append(result, language)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// languagesForAsset returns the languages an asset provides analysis support for, or nil if the asset is not a CodeQL bundle.
+func (mapping *Mapping) languagesForAsset(name string) []string {
+	for _, rule := range mapping.Bundles {
+		match := rule.compiledPattern.FindStringSubmatchIndex(name)
+		if match == nil {
+			continue
+		}
+		languages := []string{}
+		for _, template := range rule.Languages {
+			language := string(rule.compiledPattern.ExpandString(nil, template, name, match))
+			if language == allLanguages || mapping.isKnownLanguage(language) {
+				languages = append(languages, language)
+			}
+		}
+		if len(languages) > 0 {
+			return languages
+		}
+	}
+	return nil
+}
+
+type candidate struct {
+	asset     Asset
+	languages map[string]bool
+}
+
+func (candidate *candidate) covers(language string) bool {
+	return candidate.languages[allLanguages] || candidate.languages[language]
+}
+
+func coversAll(candidates []candidate, languages []string) bool {
+	for _, language := range languages {
+		covered := false
+		for _, candidate := range candidates {
+			if candidate.covers(language) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+func totalSize(candidates []candidate) int64 {
+	size := int64(0)
+	for _, candidate := range candidates {
+		size += candidate.asset.Size
+	}
+	return size
+}
+
+func exhaustiveSelection(candidates []candidate, languages []string) []candidate {
+	var best []candidate
+	for mask := 1; mask < 1<<len(candidates); mask++ {
+		selection := []candidate{}
+		for index, candidate := range candidates {
+			if mask&(1<<index) != 0 {
+				selection = append(selection, candidate)
+			}
+		}
+		if coversAll(selection, languages) && (best == nil || totalSize(selection) < totalSize(best)) {
+			best = selection
+		}
+	}
+	return best
+}
+
+func greedySelection(candidates []candidate, languages []string) []candidate {
+	uncovered := map[string]bool{}
+	for _, language := range languages {
+		uncovered[language] = true
+	}
+	selection := []candidate{}
+	for len(uncovered) > 0 {
+		bestIndex := -1
+		bestCost := 0.0
+		for index, candidate := range candidates {
+			newlyCovered := 0
+			for language := range uncovered {
+				if candidate.covers(language) {
+					newlyCovered++
+				}
+			}
+			if newlyCovered == 0 {
+				continue
+			}
+			cost := float64(candidate.asset.Size) / float64(newlyCovered)
+			if bestIndex == -1 || cost < bestCost {
+				bestIndex = index
+				bestCost = cost
+			}
+		}
+		if bestIndex == -1 {
+			return nil
+		}
+		for language := range uncovered {
+			if candidates[bestIndex].covers(language) {
+				delete(uncovered, language)
+			}
+		}
+		selection = append(selection, candidates[bestIndex])
+		candidates = append(candidates[:bestIndex:bestIndex], candidates[bestIndex+1:]...)
+	}
+	return selection
+}
+
+// splitAssets separates the CodeQL bundles from the shared assets which are needed regardless of the languages in use.
+func (mapping *Mapping) splitAssets(assets []Asset) ([]candidate, []Asset) {
+	shared := []Asset{}
+	candidates := []candidate{}
+	for _, asset := range assets {
+		assetLanguages := mapping.languagesForAsset(asset.Name)
+		if assetLanguages == nil {
+			shared = append(shared, asset)
+			continue
+		}
+		languageSet := map[string]bool{}
+		for _, language := range assetLanguages {
+			languageSet[language] = true
+		}
+		candidates = append(candidates, candidate{asset: asset, languages: languageSet})
+	}
+	return candidates, shared
+}
+
+// FilterAssets selects the CodeQL bundles needed for the given languages, preferring bundles dedicated to a language over bundles that support every language. Assets which are not CodeQL bundles are always kept.
+func (mapping *Mapping) FilterAssets(assets []Asset, languages []string) ([]Asset, error) {
+	candidates, selected := mapping.splitAssets(assets)
+	if len(candidates) == 0 {
+		return selected, nil
+	}
+	included := map[int]bool{}
+	for _, language := range languages {
+		dedicated := []int{}
+		covering := []int{}
+		for index, candidate := range candidates {
+			if candidate.languages[language] && len(candidate.languages) == 1 {
+				dedicated = append(dedicated, index)
+			}
+			if candidate.covers(language) {
+				covering = append(covering, index)
+			}
+		}
+		if len(covering) == 0 {
+			return nil, errors.Errorf("None of the CodeQL bundles in this release support the language %s.", language)
+		}
+		if len(dedicated) == 0 {
+			dedicated = covering
+		}
+		for _, index := range dedicated {
+			included[index] = true
+		}
+	}
+	for index, candidate := range candidates {
+		if included[index] {
+			selected = append(selected, candidate.asset)
+		}
+	}
+	return selected, nil
+}
+
+// MinimizeAssets selects the smallest set of CodeQL bundle assets that together support all of the given languages. Assets which are not CodeQL bundles are always kept.
+func (mapping *Mapping) MinimizeAssets(assets []Asset, languages []string) ([]Asset, error) {
+	candidates, selected := mapping.splitAssets(assets)
+	if len(candidates) == 0 {
+		return selected, nil
+	}
+
+	var selection []candidate
+	if len(candidates) <= maximumExhaustiveCandidates {
+		selection = exhaustiveSelection(candidates, languages)
+	} else {
+		selection = greedySelection(candidates, languages)
+	}
+	if selection == nil {
+		return nil, errors.Errorf("No combination of the CodeQL bundles in this release supports all of the languages %s.", strings.Join(languages, ", "))
+	}
+	for _, candidate := range selection {
+		selected = append(selected, candidate.asset)
+	}
+	return selected, nil
+}
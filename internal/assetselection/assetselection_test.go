@@ -0,0 +1,104 @@
+package assetselection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var fullBundle = Asset{Name: "codeql-bundle.tar.gz", Size: 1000}
+var javaBundle = Asset{Name: "codeql-bundle-java.tar.gz", Size: 200}
+var pythonBundle = Asset{Name: "codeql-bundle-python.tar.gz", Size: 150}
+var cppBundle = Asset{Name: "codeql-bundle-cpp.tar.gz", Size: 700}
+var linuxBundle = Asset{Name: "codeql-bundle-linux64.tar.gz", Size: 600}
+var checksums = Asset{Name: "checksums.txt", Size: 1}
+
+func TestParseLanguages(t *testing.T) {
+	languages, err := DefaultMapping().ParseLanguages([]string{"Python", " java", "python", ""})
+	require.NoError(t, err)
+	require.Equal(t, []string{"java", "python"}, languages)
+
+	_, err = DefaultMapping().ParseLanguages([]string{"cobol"})
+	require.Error(t, err)
+}
+
+func TestMinimizeAssetsPrefersLanguageBundles(t *testing.T) {
+	assets, err := DefaultMapping().MinimizeAssets([]Asset{fullBundle, javaBundle, pythonBundle, cppBundle, linuxBundle, checksums}, []string{"java", "python"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []Asset{javaBundle, pythonBundle, linuxBundle, checksums}, assets)
+}
+
+func TestMinimizeAssetsPrefersFullBundleWhenCheaper(t *testing.T) {
+	assets, err := DefaultMapping().MinimizeAssets([]Asset{fullBundle, javaBundle, pythonBundle, cppBundle}, []string{"java", "python", "cpp"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []Asset{fullBundle}, assets)
+}
+
+func TestMinimizeAssetsFallsBackToFullBundle(t *testing.T) {
+	assets, err := DefaultMapping().MinimizeAssets([]Asset{fullBundle, javaBundle}, []string{"go"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []Asset{fullBundle}, assets)
+}
+
+func TestMinimizeAssetsErrorsIfUnsatisfiable(t *testing.T) {
+	_, err := DefaultMapping().MinimizeAssets([]Asset{javaBundle, checksums}, []string{"go"})
+	require.Error(t, err)
+}
+
+func TestMinimizeAssetsGreedy(t *testing.T) {
+	candidates := []candidate{}
+	for index := 0; index < maximumExhaustiveCandidates+1; index++ {
+		candidates = append(candidates, candidate{asset: Asset{Name: "other", Size: 1}, languages: map[string]bool{"ruby": true}})
+	}
+	candidates = append(candidates, candidate{asset: javaBundle, languages: map[string]bool{"java": true}})
+	selection := greedySelection(candidates, []string{"java", "ruby"})
+	require.Len(t, selection, 2)
+	require.Nil(t, greedySelection(candidates, []string{"go"}))
+}
+
+func TestFilterAssetsPrefersLanguageBundles(t *testing.T) {
+	javaZstdBundle := Asset{Name: "codeql-bundle-java.tar.zst", Size: 100}
+	assets, err := DefaultMapping().FilterAssets([]Asset{fullBundle, javaBundle, javaZstdBundle, pythonBundle, cppBundle, linuxBundle, checksums}, []string{"java", "python", "cpp"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []Asset{javaBundle, javaZstdBundle, pythonBundle, cppBundle, linuxBundle, checksums}, assets)
+}
+
+func TestFilterAssetsFallsBackToFullBundle(t *testing.T) {
+	assets, err := DefaultMapping().FilterAssets([]Asset{fullBundle, javaBundle, checksums}, []string{"go", "java"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []Asset{fullBundle, javaBundle, checksums}, assets)
+
+	_, err = DefaultMapping().FilterAssets([]Asset{javaBundle, checksums}, []string{"go"})
+	require.Error(t, err)
+}
+
+func TestCustomMapping(t *testing.T) {
+	mapping, err := ParseMapping([]byte(`{
+		"languages": ["java", "kotlin"],
+		"bundles": [{"pattern": "^codeql-bundle-jvm\\.tar\\.gz$", "languages": ["java", "kotlin"]}]
+	}`))
+	require.NoError(t, err)
+	jvmBundle := Asset{Name: "codeql-bundle-jvm.tar.gz", Size: 300}
+	assets, err := mapping.FilterAssets([]Asset{fullBundle, jvmBundle}, []string{"kotlin"})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []Asset{fullBundle, jvmBundle}, assets)
+
+	_, err = ParseMapping([]byte(`{"languages": ["java"], "bundles": [{"pattern": "(", "languages": ["java"]}]}`))
+	require.Error(t, err)
+}
+
+func TestAssetPatterns(t *testing.T) {
+	mapping := DefaultMapping()
+	require.True(t, mapping.IncludesAsset("codeql-bundle.tar.zst"))
+
+	require.NoError(t, mapping.SetAssetPatterns(nil, []string{"*.tar.zst", " "}))
+	require.True(t, mapping.IncludesAsset("codeql-bundle.tar.gz"))
+	require.False(t, mapping.IncludesAsset("codeql-bundle.tar.zst"))
+
+	require.NoError(t, mapping.SetAssetPatterns([]string{"codeql-bundle*"}, []string{"*-linux64.*"}))
+	require.True(t, mapping.IncludesAsset("codeql-bundle-java.tar.gz"))
+	require.False(t, mapping.IncludesAsset("codeql-bundle-linux64.tar.gz"))
+	require.False(t, mapping.IncludesAsset("checksums.txt"))
+
+	require.Error(t, mapping.SetAssetPatterns([]string{"["}, nil))
+}
@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionCreateOrganization  = "create_organization"
+	ActionImpersonateUser     = "impersonate_user"
+	ActionCreateRepository    = "create_repository"
+	ActionUpdateRepository    = "update_repository"
+	ActionReplaceTopics       = "replace_topics"
+	ActionCreateReference     = "create_reference"
+	ActionUpdateReference     = "update_reference"
+	ActionDeleteReference     = "delete_reference"
+	ActionCreateRelease       = "create_release"
+	ActionUpdateRelease       = "update_release"
+	ActionDeleteRelease       = "delete_release"
+	ActionUploadAsset         = "upload_asset"
+	ActionUpdateAsset         = "update_asset"
+	ActionDeleteAsset         = "delete_asset"
+	ActionUpdateActionsPolicy = "update_actions_policy"
+)
+
+// Entry is a single change made to the destination. Each entry includes the hash of the entry before it, so any entry that is later modified, removed or reordered breaks the chain.
+type Entry struct {
+	Time         time.Time         `json:"time"`
+	Actor        string            `json:"actor"`
+	Destination  string            `json:"destination"`
+	Action       string            `json:"action"`
+	Target       string            `json:"target"`
+	Details      map[string]string `json:"details,omitempty"`
+	PreviousHash string            `json:"previous_hash"`
+	Hash         string            `json:"hash"`
+}
+
+func (entry Entry) computeHash() (string, error) {
+	entry.Hash = ""
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return "", errors.Wrap(err, "Error converting audit log entry to JSON.")
+	}
+	hash := sha256.Sum256(entryJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Log appends entries to an audit log file. A nil log records nothing, so that callers do not need to check whether an audit log was asked for.
+type Log struct {
+	lock     sync.Mutex
+	file     *os.File
+	lastHash string
+	now      func() time.Time
+}
+
+// readEntries calls read for each entry in an audit log, in order.
+func readEntries(reader io.Reader, read func(line int, entry Entry) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := Entry{}
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return errors.Wrapf(err, "Error parsing line %d of audit log.", line)
+		}
+		err = read(line, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "Error reading audit log.")
+}
+
+// Open opens an audit log for appending, creating it if it does not exist. New entries continue the hash chain of any entries already in the file.
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error opening audit log.")
+	}
+	log := &Log{file: file, now: time.Now}
+	err = readEntries(file, func(line int, entry Entry) error {
+		log.lastHash = entry.Hash
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// Close closes the audit log file.
+func (log *Log) Close() error {
+	if log == nil {
+		return nil
+	}
+	return errors.Wrap(log.file.Close(), "Error closing audit log.")
+}
+
+// Record appends an entry to the audit log, and syncs it to disk before returning so that it is not lost if the tool is interrupted.
+func (log *Log) Record(actor string, destination string, action string, target string, details map[string]string) error {
+	if log == nil {
+		return nil
+	}
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	entry := Entry{
+		Time:         log.now().UTC(),
+		Actor:        actor,
+		Destination:  destination,
+		Action:       action,
+		Target:       target,
+		Details:      details,
+		PreviousHash: log.lastHash,
+	}
+	hash, err := entry.computeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "Error converting audit log entry to JSON.")
+	}
+	_, err = log.file.Write(append(entryJSON, '\n'))
+	if err != nil {
+		return errors.Wrap(err, "Error writing to audit log.")
+	}
+	err = log.file.Sync()
+	if err != nil {
+		return errors.Wrap(err, "Error writing to audit log.")
+	}
+	log.lastHash = hash
+	return nil
+}
+
+// Verify checks that no entry in an audit log has been modified, removed or reordered, returning the number of entries checked.
+func Verify(reader io.Reader) (int, error) {
+	count := 0
+	previousHash := ""
+	err := readEntries(reader, func(line int, entry Entry) error {
+		if entry.PreviousHash != previousHash {
+			return fmt.Errorf("The audit log entry on line %d does not follow the entry before it. Entries have been removed or reordered.", line)
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return err
+		}
+		if entry.Hash != hash {
+			return fmt.Errorf("The audit log entry on line %d has been modified.", line)
+		}
+		previousHash = entry.Hash
+		count++
+		return nil
+	})
+	return count, err
+}
+
+type contextKey struct{}
+
+// WithLog returns a context which carries the audit log to the code making changes.
+func WithLog(ctx context.Context, log *Log) context.Context {
+	return context.WithValue(ctx, contextKey{}, log)
+}
+
+// FromContext returns the audit log carried by the context, or nil if there is none.
+func FromContext(ctx context.Context) *Log {
+	log, _ := ctx.Value(contextKey{}).(*Log)
+	return log
+}
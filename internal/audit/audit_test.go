@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestLog(t *testing.T, path string) *Log {
+	log, err := Open(path)
+	require.NoError(t, err)
+	log.now = func() time.Time { return time.Date(2020, 7, 1, 9, 0, 0, 0, time.UTC) }
+	return log
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	path := filepath.Join(temporaryDirectory, "audit.jsonl")
+
+	log := openTestLog(t, path)
+	require.NoError(t, log.Record("user", "https://ghes.example.com", ActionCreateRepository, "github/codeql-action", nil))
+	require.NoError(t, log.Record("user", "https://ghes.example.com", ActionCreateReference, "github/codeql-action refs/heads/main", map[string]string{"current": "a"}))
+	require.NoError(t, log.Close())
+
+	// Reopening the log continues the chain.
+	log = openTestLog(t, path)
+	require.NoError(t, log.Record("user", "https://ghes.example.com", ActionUploadAsset, "github/codeql-action codeql-bundle-20200101/codeql-bundle.tar.gz", map[string]string{"size": "100"}))
+	require.NoError(t, log.Close())
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 3)
+	require.Contains(t, lines[0], `"previous_hash":""`)
+
+	count, err := Verify(bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	modified := strings.Replace(string(content), `"size":"100"`, `"size":"200"`, 1)
+	_, err = Verify(strings.NewReader(modified))
+	require.EqualError(t, err, "The audit log entry on line 3 has been modified.")
+
+	removed := lines[0] + "\n" + lines[2] + "\n"
+	_, err = Verify(strings.NewReader(removed))
+	require.EqualError(t, err, "The audit log entry on line 2 does not follow the entry before it. Entries have been removed or reordered.")
+}
+
+func TestNilLog(t *testing.T) {
+	var log *Log
+	require.NoError(t, log.Record("user", "https://ghes.example.com", ActionCreateRepository, "github/codeql-action", nil))
+	require.NoError(t, log.Close())
+}
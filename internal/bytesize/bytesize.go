@@ -0,0 +1,56 @@
+package bytesize
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const unit = 1024
+
+var sizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([kmg]?)(?:i?b)?$`)
+
+// Parse parses a size such as `100M` or `1.5G` into a number of bytes. Suffixes are powers of 1024, and may be followed by `B` or `iB`. An empty string is zero. Sizes too large to fit in 64 bits are rejected.
+func Parse(size string) (int64, error) {
+	normalized := strings.ToLower(strings.TrimSpace(size))
+	if normalized == "" {
+		return 0, nil
+	}
+	match := sizePattern.FindStringSubmatch(normalized)
+	if match == nil {
+		return 0, fmt.Errorf("Size %q is not a number of bytes.", size)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Error parsing size %q.", size)
+	}
+	switch match[2] {
+	case "k":
+		value *= unit
+	case "m":
+		value *= unit * unit
+	case "g":
+		value *= unit * unit * unit
+	}
+	if value >= math.MaxInt64 {
+		return 0, fmt.Errorf("Size %q is too large.", size)
+	}
+	return int64(value), nil
+}
+
+// Format formats a number of bytes for display, in binary units such as KiB and MiB.
+func Format(bytes int64) string {
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	divisor, exponent := int64(unit), 0
+	for remaining := bytes / unit; remaining >= unit; remaining /= unit {
+		divisor *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(divisor), "KMGTPE"[exponent])
+}
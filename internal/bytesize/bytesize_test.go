@@ -0,0 +1,35 @@
+package bytesize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	require.Equal(t, "512 B", Format(512))
+	require.Equal(t, "1.5 KiB", Format(1536))
+	require.Equal(t, "2.0 GiB", Format(2*1024*1024*1024))
+	require.Equal(t, "2.3 GiB", Format(2500000000))
+}
+
+func TestParse(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"":       0,
+		"0":      0,
+		"512":    512,
+		"500K":   500 * 1024,
+		"100M":   100 * 1024 * 1024,
+		"1.5m":   1536 * 1024,
+		"2 GiB":  2 * 1024 * 1024 * 1024,
+		" 10kb ": 10 * 1024,
+	} {
+		actual, err := Parse(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, actual, input)
+	}
+	for _, input := range []string{"lots", "-1", "10T", "99999999999G", "99999999999999999999"} {
+		_, err := Parse(input)
+		require.Error(t, err, input)
+	}
+}
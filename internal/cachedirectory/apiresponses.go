@@ -0,0 +1,71 @@
+package cachedirectory
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const apiResponsesDirectory = "api-responses"
+const apiResponseSuffix = ".json"
+
+// APIResponse is a response from the GitHub API stored with its ETag, so that the request can be made again conditionally and the stored response used if it has not changed. GitHub does not count conditional requests that find nothing has changed against the rate limit.
+type APIResponse struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+func apiResponseKey(key string) string {
+	return apiResponsesDirectory + "/" + key + apiResponseSuffix
+}
+
+// ReadAPIResponse returns the response stored under a key, or nil if there is none.
+func (cacheDirectory *CacheDirectory) ReadAPIResponse(key string) (*APIResponse, error) {
+	content, err := cacheDirectory.readFile(apiResponseKey(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading stored API response.")
+	}
+	response := APIResponse{}
+	err = json.Unmarshal(content, &response)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing stored API response.")
+	}
+	return &response, nil
+}
+
+func (cacheDirectory *CacheDirectory) WriteAPIResponse(key string, response APIResponse) error {
+	content, err := json.Marshal(response)
+	if err != nil {
+		return errors.Wrap(err, "Error converting API response to JSON.")
+	}
+	return cacheDirectory.writeFile(apiResponseKey(key), content)
+}
+
+// RemoveAPIResponsesExcept removes every stored response but those under the given keys, so that responses for releases that no longer exist do not build up.
+func (cacheDirectory *CacheDirectory) RemoveAPIResponsesExcept(keys map[string]bool) error {
+	entries, err := cacheDirectory.storage.list(apiResponsesDirectory)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error reading stored API responses.")
+	}
+	for _, entry := range entries {
+		key := strings.TrimSuffix(entry.name, apiResponseSuffix)
+		if entry.isDir || keys[key] {
+			continue
+		}
+		err := cacheDirectory.storage.remove(apiResponseKey(key))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Error removing stored API response.")
+		}
+	}
+	return nil
+}
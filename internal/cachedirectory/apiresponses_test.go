@@ -0,0 +1,35 @@
+package cachedirectory
+
+import (
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIResponses(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	response, err := cacheDirectory.ReadAPIResponse("first")
+	require.NoError(t, err)
+	require.Nil(t, response)
+	require.NoError(t, cacheDirectory.RemoveAPIResponsesExcept(map[string]bool{}))
+
+	first := APIResponse{ETag: `"1"`, Header: http.Header{"Content-Type": []string{"application/json"}}, Body: []byte("[]")}
+	require.NoError(t, cacheDirectory.WriteAPIResponse("first", first))
+	require.NoError(t, cacheDirectory.WriteAPIResponse("second", APIResponse{ETag: `"2"`, Body: []byte("{}")}))
+	response, err = cacheDirectory.ReadAPIResponse("first")
+	require.NoError(t, err)
+	require.Equal(t, &first, response)
+
+	require.NoError(t, cacheDirectory.RemoveAPIResponsesExcept(map[string]bool{"second": true}))
+	response, err = cacheDirectory.ReadAPIResponse("first")
+	require.NoError(t, err)
+	require.Nil(t, response)
+	response, err = cacheDirectory.ReadAPIResponse("second")
+	require.NoError(t, err)
+	require.Equal(t, `"2"`, response.ETag)
+}
@@ -0,0 +1,330 @@
+package cachedirectory
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const azureBlobScheme = "azblob://"
+
+const errorAzureBlobMissingContainer = "The Azure Blob Storage cache location must include a container name, for example `azblob://container/prefix`."
+const errorAzureBlobMissingAccount = "The `AZURE_STORAGE_ACCOUNT` environment variable must be set to use an Azure Blob Storage cache."
+
+const azureBlobAPIVersion = "2020-04-08"
+const azureStorageResource = "https://storage.azure.com/"
+const azureManagedIdentityEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+type azureBlobStorage struct {
+	client                  *http.Client
+	endpoint                *url.URL
+	container               string
+	prefix                  string
+	sasToken                url.Values
+	managedIdentityEndpoint string
+	managedIdentityClientID string
+
+	tokenLock   sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// newAzureBlobStorage creates Azure Blob Storage from a location of the form `azblob://container/prefix`.
+// The storage account is read from `AZURE_STORAGE_ACCOUNT`. If `AZURE_STORAGE_SAS_TOKEN` is set it is used to authenticate, otherwise a token is requested from the managed identity endpoint of the host.
+func newAzureBlobStorage(location string) (*azureBlobStorage, error) {
+	containerAndPrefix := strings.TrimPrefix(location, azureBlobScheme)
+	container := containerAndPrefix
+	prefix := ""
+	if index := strings.Index(containerAndPrefix, "/"); index != -1 {
+		container = containerAndPrefix[:index]
+		prefix = strings.Trim(containerAndPrefix[index+1:], "/")
+	}
+	if container == "" {
+		return nil, errors.New(errorAzureBlobMissingContainer)
+	}
+
+	endpointString := os.Getenv("AZURE_STORAGE_BLOB_ENDPOINT")
+	if endpointString == "" {
+		account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+		if account == "" {
+			return nil, errors.New(errorAzureBlobMissingAccount)
+		}
+		endpointString = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+	endpoint, err := url.Parse(strings.TrimRight(endpointString, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing Azure Blob Storage endpoint URL.")
+	}
+
+	var sasToken url.Values
+	if sasTokenString := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sasTokenString != "" {
+		sasToken, err = url.ParseQuery(strings.TrimPrefix(sasTokenString, "?"))
+		if err != nil {
+			return nil, errors.Wrap(err, "Error parsing Azure Blob Storage SAS token.")
+		}
+	}
+
+	return &azureBlobStorage{
+		client:                  http.DefaultClient,
+		endpoint:                endpoint,
+		container:               container,
+		prefix:                  prefix,
+		sasToken:                sasToken,
+		managedIdentityEndpoint: azureManagedIdentityEndpoint,
+		managedIdentityClientID: os.Getenv("AZURE_CLIENT_ID"),
+	}, nil
+}
+
+func (azureBlobStorage *azureBlobStorage) key(name string) string {
+	if azureBlobStorage.prefix == "" {
+		return name
+	}
+	if name == "" {
+		return azureBlobStorage.prefix
+	}
+	return azureBlobStorage.prefix + "/" + name
+}
+
+func (azureBlobStorage *azureBlobStorage) managedIdentityToken() (string, error) {
+	azureBlobStorage.tokenLock.Lock()
+	defer azureBlobStorage.tokenLock.Unlock()
+	if azureBlobStorage.token != "" && time.Now().Add(time.Minute).Before(azureBlobStorage.tokenExpiry) {
+		return azureBlobStorage.token, nil
+	}
+
+	query := url.Values{"api-version": []string{"2018-02-01"}, "resource": []string{azureStorageResource}}
+	if azureBlobStorage.managedIdentityClientID != "" {
+		query.Set("client_id", azureBlobStorage.managedIdentityClientID)
+	}
+	request, err := http.NewRequest(http.MethodGet, azureBlobStorage.managedIdentityEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Error constructing managed identity token request.")
+	}
+	request.Header.Set("Metadata", "true")
+	response, err := azureBlobStorage.client.Do(request)
+	if err != nil {
+		return "", errors.Wrap(err, "Error requesting managed identity token. If this machine does not have a managed identity, please set `AZURE_STORAGE_SAS_TOKEN` instead.")
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return "", errors.Errorf("Status code %d while requesting managed identity token.", response.StatusCode)
+	}
+	tokenResponse := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&tokenResponse)
+	if err != nil {
+		return "", errors.Wrap(err, "Error decoding managed identity token.")
+	}
+	expiresOn, err := strconv.ParseInt(tokenResponse.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", errors.Wrap(err, "Error decoding managed identity token expiry.")
+	}
+	azureBlobStorage.token = tokenResponse.AccessToken
+	azureBlobStorage.tokenExpiry = time.Unix(expiresOn, 0)
+	return azureBlobStorage.token, nil
+}
+
+func (azureBlobStorage *azureBlobStorage) do(method string, blob string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	blobURL := *azureBlobStorage.endpoint
+	blobURL.Path = blobURL.Path + "/" + azureBlobStorage.container
+	blobURL.RawPath = blobURL.EscapedPath()
+	if blob != "" {
+		blobURL.RawPath = blobURL.RawPath + "/" + uriEscapePath(blob)
+		blobURL.Path = blobURL.Path + "/" + blob
+	}
+	combinedQuery := url.Values{}
+	for key, values := range query {
+		combinedQuery[key] = values
+	}
+	for key, values := range azureBlobStorage.sasToken {
+		combinedQuery[key] = values
+	}
+	blobURL.RawQuery = combinedQuery.Encode()
+
+	request, err := http.NewRequest(method, blobURL.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error constructing Azure Blob Storage request.")
+	}
+	request.Header.Set("x-ms-version", azureBlobAPIVersion)
+	request.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	if body != nil {
+		request.ContentLength = size
+		request.Header.Set("x-ms-blob-type", "BlockBlob")
+	}
+	if azureBlobStorage.sasToken == nil {
+		token, err := azureBlobStorage.managedIdentityToken()
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := azureBlobStorage.client.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error sending Azure Blob Storage request.")
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, &os.PathError{Op: method, Path: azureBlobScheme + azureBlobStorage.container + "/" + blob, Err: os.ErrNotExist}
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		responseBody, _ := ioutil.ReadAll(response.Body)
+		return nil, errors.Errorf("Status code %d from Azure Blob Storage for %s %s: %s", response.StatusCode, method, blob, strings.TrimSpace(string(responseBody)))
+	}
+	return response, nil
+}
+
+func (azureBlobStorage *azureBlobStorage) checkParent() error {
+	return nil
+}
+
+func (azureBlobStorage *azureBlobStorage) create() error {
+	return nil
+}
+
+func (azureBlobStorage *azureBlobStorage) isEmpty() (bool, error) {
+	entries, err := azureBlobStorage.list("")
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+func (azureBlobStorage *azureBlobStorage) open(name string) (io.ReadCloser, error) {
+	response, err := azureBlobStorage.do(http.MethodGet, azureBlobStorage.key(name), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (azureBlobStorage *azureBlobStorage) write(name string, reader io.Reader, size int64) error {
+	if size == 0 {
+		reader = http.NoBody
+	}
+	response, err := azureBlobStorage.do(http.MethodPut, azureBlobStorage.key(name), nil, reader, size)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (azureBlobStorage *azureBlobStorage) size(name string) (int64, error) {
+	response, err := azureBlobStorage.do(http.MethodHead, azureBlobStorage.key(name), nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.ContentLength, nil
+}
+
+type azureBlobEnumerationResults struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string
+			Properties struct {
+				ContentLength int64 `xml:"Content-Length"`
+			}
+		}
+		BlobPrefix []struct {
+			Name string
+		}
+	}
+	NextMarker string
+}
+
+func (azureBlobStorage *azureBlobStorage) listBlobs(prefix string, delimiter string) (*azureBlobEnumerationResults, error) {
+	result := &azureBlobEnumerationResults{}
+	marker := ""
+	for {
+		query := url.Values{"restype": []string{"container"}, "comp": []string{"list"}, "prefix": []string{prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		response, err := azureBlobStorage.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		page := azureBlobEnumerationResults{}
+		err = xml.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error decoding Azure Blob Storage blob listing.")
+		}
+		result.Blobs.Blob = append(result.Blobs.Blob, page.Blobs.Blob...)
+		result.Blobs.BlobPrefix = append(result.Blobs.BlobPrefix, page.Blobs.BlobPrefix...)
+		if page.NextMarker == "" {
+			return result, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+func (azureBlobStorage *azureBlobStorage) list(directory string) ([]storageEntry, error) {
+	prefix := azureBlobStorage.key(directory)
+	if prefix != "" {
+		prefix += "/"
+	}
+	result, err := azureBlobStorage.listBlobs(prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	entries := []storageEntry{}
+	for _, blobPrefix := range result.Blobs.BlobPrefix {
+		entries = append(entries, storageEntry{name: strings.TrimSuffix(strings.TrimPrefix(blobPrefix.Name, prefix), "/"), isDir: true})
+	}
+	for _, blob := range result.Blobs.Blob {
+		entries = append(entries, storageEntry{name: strings.TrimPrefix(blob.Name, prefix), size: blob.Properties.ContentLength})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
+
+func (azureBlobStorage *azureBlobStorage) remove(name string) error {
+	response, err := azureBlobStorage.do(http.MethodDelete, azureBlobStorage.key(name), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (azureBlobStorage *azureBlobStorage) removeAll(name string) error {
+	prefix := azureBlobStorage.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	result, err := azureBlobStorage.listBlobs(prefix, "")
+	if err != nil {
+		return err
+	}
+	for _, blob := range result.Blobs.Blob {
+		response, err := azureBlobStorage.do(http.MethodDelete, blob.Name, nil, nil, 0)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+	}
+	return nil
+}
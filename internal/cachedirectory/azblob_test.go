@@ -0,0 +1,163 @@
+package cachedirectory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestAzureBlobStorage(t *testing.T, checkAuthorization func(request *http.Request)) (*azureBlobStorage, *mux.Router, map[string][]byte) {
+	blobs := map[string][]byte{}
+	azureTestServer, azureURL := test.GetTestHTTPServer(t)
+	handler := func(response http.ResponseWriter, request *http.Request) {
+		checkAuthorization(request)
+		require.Equal(t, azureBlobAPIVersion, request.Header.Get("x-ms-version"))
+		blob := mux.Vars(request)["blob"]
+		switch request.Method {
+		case http.MethodGet:
+			if request.URL.Query().Get("comp") == "list" {
+				prefix := request.URL.Query().Get("prefix")
+				delimiter := request.URL.Query().Get("delimiter")
+				names := []string{}
+				for name := range blobs {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				blobPrefixes := map[string]bool{}
+				body := "<EnumerationResults><Blobs>"
+				for _, name := range names {
+					if !strings.HasPrefix(name, prefix) {
+						continue
+					}
+					remainder := strings.TrimPrefix(name, prefix)
+					if delimiter != "" && strings.Contains(remainder, delimiter) {
+						blobPrefix := prefix + remainder[:strings.Index(remainder, delimiter)+1]
+						if !blobPrefixes[blobPrefix] {
+							blobPrefixes[blobPrefix] = true
+							body += fmt.Sprintf("<BlobPrefix><Name>%s</Name></BlobPrefix>", blobPrefix)
+						}
+						continue
+					}
+					body += fmt.Sprintf("<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length></Properties></Blob>", name, len(blobs[name]))
+				}
+				body += "</Blobs><NextMarker /></EnumerationResults>"
+				test.ServeHTTPResponseFromString(t, body, response)
+				return
+			}
+			if content, ok := blobs[blob]; ok {
+				test.ServeHTTPResponseFromString(t, string(content), response)
+			} else {
+				response.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodHead:
+			if content, ok := blobs[blob]; ok {
+				response.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			} else {
+				response.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			require.Equal(t, "BlockBlob", request.Header.Get("x-ms-blob-type"))
+			body, err := ioutil.ReadAll(request.Body)
+			require.NoError(t, err)
+			blobs[blob] = body
+			response.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			delete(blobs, blob)
+			response.WriteHeader(http.StatusAccepted)
+		}
+	}
+	azureTestServer.HandleFunc("/test-container", handler)
+	azureTestServer.HandleFunc("/test-container/{blob:.+}", handler)
+
+	endpoint, err := url.Parse(azureURL)
+	require.NoError(t, err)
+	return &azureBlobStorage{
+		client:                  http.DefaultClient,
+		endpoint:                endpoint,
+		container:               "test-container",
+		prefix:                  "cache",
+		managedIdentityEndpoint: azureURL + "/metadata/identity/oauth2/token",
+	}, azureTestServer, blobs
+}
+
+func TestParseAzureBlobLocation(t *testing.T) {
+	os.Setenv("AZURE_STORAGE_ACCOUNT", "account")
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2020-04-08&sig=signature")
+	t.Cleanup(func() {
+		os.Unsetenv("AZURE_STORAGE_ACCOUNT")
+		os.Unsetenv("AZURE_STORAGE_SAS_TOKEN")
+	})
+
+	storage, err := newAzureBlobStorage("azblob://container/some/prefix/")
+	require.NoError(t, err)
+	require.Equal(t, "https://account.blob.core.windows.net", storage.endpoint.String())
+	require.Equal(t, "container", storage.container)
+	require.Equal(t, "some/prefix", storage.prefix)
+	require.Equal(t, "signature", storage.sasToken.Get("sig"))
+
+	_, err = newAzureBlobStorage("azblob://")
+	require.EqualError(t, err, errorAzureBlobMissingContainer)
+}
+
+func TestAzureBlobCacheDirectoryWithSASToken(t *testing.T) {
+	storage, _, blobs := getTestAzureBlobStorage(t, func(request *http.Request) {
+		require.Equal(t, "signature", request.URL.Query().Get("sig"))
+		require.Empty(t, request.Header.Get("Authorization"))
+	})
+	storage.sasToken = url.Values{"sig": []string{"signature"}}
+	cacheDirectory := newRemoteCacheDirectory("azblob://test-container/cache", storage)
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Dir(cacheDirectory.GitPath()))
+	})
+
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	require.Equal(t, []byte(aVersion), blobs["cache/"+versionFileName])
+	require.NoError(t, cacheDirectory.WriteMetadata("a-release", []byte("{}")))
+	require.NoError(t, cacheDirectory.WriteAsset("a-release", "bundle.tar.gz", strings.NewReader("not a bundle"), 12))
+
+	releases, err := cacheDirectory.ListReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a-release"}, releases)
+	assets, err := cacheDirectory.ListAssets("a-release")
+	require.NoError(t, err)
+	require.Equal(t, []Asset{{Name: "bundle.tar.gz", Size: 12}}, assets)
+	size, err := cacheDirectory.AssetSize("a-release", "bundle.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, int64(12), size)
+
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aDifferentVersion))
+	require.Equal(t, map[string][]byte{"cache/" + versionFileName: []byte(aDifferentVersion)}, blobs)
+}
+
+func TestAzureBlobCacheDirectoryWithManagedIdentity(t *testing.T) {
+	storage, azureTestServer, _ := getTestAzureBlobStorage(t, func(request *http.Request) {
+		require.Equal(t, "Bearer managed-identity-token", request.Header.Get("Authorization"))
+	})
+	tokenRequests := 0
+	azureTestServer.HandleFunc("/metadata/identity/oauth2/token", func(response http.ResponseWriter, request *http.Request) {
+		require.Equal(t, "true", request.Header.Get("Metadata"))
+		require.Equal(t, azureStorageResource, request.URL.Query().Get("resource"))
+		tokenRequests++
+		test.ServeHTTPResponseFromObject(t, map[string]string{
+			"access_token": "managed-identity-token",
+			"expires_on":   strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+		}, response)
+	}).Methods("GET")
+	cacheDirectory := newRemoteCacheDirectory("azblob://test-container/cache", storage)
+
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(false, aVersion))
+	require.Equal(t, 1, tokenRequests)
+}
@@ -1,12 +1,16 @@
 package cachedirectory
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	usererrors "errors"
 	"io"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -17,16 +21,87 @@ const errorCacheParentDoesNotExist = "Cannot create cache directory because its
 const errorPushNonCache = "The cache directory you have provided does not appear to be valid. Please check it exists and that you have run the `pull` command to populate it."
 const errorCacheLocked = "The cache directory is locked, likely due to a `pull` command being interrupted. Please run `pull` again to ensure all required data is downloaded."
 
+const versionFileName = ".codeql-actions-sync-version"
+const lockFileName = ".codeql-actions-sync-lock"
+const destinationsFileName = ".codeql-actions-sync-destinations.json"
+const contentsManifestFileName = "manifest.json"
+const latestReleaseFileName = ".codeql-actions-sync-latest-release"
+const sourceRepositoryFileName = ".codeql-actions-sync-source-repository"
+const sourceKindFileName = ".codeql-actions-sync-source-kind"
+
+// SourceKindAction is the kind of a cache of the CodeQL Action and the CodeQL bundles it uses.
+const SourceKindAction = "action"
+
+// SourceKindCLI is the kind of a cache of the standalone CodeQL CLI releases.
+const SourceKindCLI = "cli"
+
+// SourceKindQueries is the kind of a cache of the CodeQL queries and libraries, which only has a Git repository and no releases.
+const SourceKindQueries = "queries"
+
 type CacheDirectory struct {
-	path string
+	path          string
+	storage       storage
+	gitPath       string
+	remote        bool
+	lockTimeout   time.Duration
+	workDirectory string
+}
+
+// Asset describes a release asset stored in the cache.
+type Asset struct {
+	Name string
+	Size int64
 }
 
 func NewCacheDirectory(path string) CacheDirectory {
+	path = filepath.Clean(path)
+	return CacheDirectory{
+		path:    path,
+		storage: &localStorage{path: path},
+		gitPath: filepath.Join(path, "git"),
+	}
+}
+
+// OpenCacheDirectory opens a cache from a location which can either be a local path or the URL of a supported remote backend, such as `s3://bucket/prefix` or `azblob://container/prefix`.
+func OpenCacheDirectory(location string) (CacheDirectory, error) {
+	if strings.HasPrefix(location, s3Scheme) {
+		storage, err := newS3Storage(location)
+		if err != nil {
+			return CacheDirectory{}, err
+		}
+		return newRemoteCacheDirectory(location, storage), nil
+	}
+	if strings.HasPrefix(location, azureBlobScheme) {
+		storage, err := newAzureBlobStorage(location)
+		if err != nil {
+			return CacheDirectory{}, err
+		}
+		return newRemoteCacheDirectory(location, storage), nil
+	}
+	return NewCacheDirectory(location), nil
+}
+
+func newRemoteCacheDirectory(location string, storage storage) CacheDirectory {
+	// Git needs a real filesystem, so for remote caches the repository is kept in a local working directory and synced to the remote as a single archive.
+	locationHash := sha256.Sum256([]byte(location))
+	workingPath := path.Join(os.TempDir(), "codeql-action-sync-"+hex.EncodeToString(locationHash[:8]))
 	return CacheDirectory{
-		path: filepath.Clean(path),
+		path:    location,
+		storage: storage,
+		gitPath: path.Join(workingPath, "git"),
+		remote:  true,
 	}
 }
 
+// IsRemote returns true if the cache is not stored on the local filesystem.
+func (cacheDirectory *CacheDirectory) IsRemote() bool {
+	return cacheDirectory.remote
+}
+
+func (cacheDirectory *CacheDirectory) String() string {
+	return cacheDirectory.path
+}
+
 func isEmptyOrNonExistentDirectory(path string) (bool, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -47,9 +122,16 @@ func isEmptyOrNonExistentDirectory(path string) (bool, error) {
 	return false, nil
 }
 
+func (cacheDirectory *CacheDirectory) readFile(name string) ([]byte, error) {
+	return readStorageFile(cacheDirectory.storage, name)
+}
+
+func (cacheDirectory *CacheDirectory) writeFile(name string, content []byte) error {
+	return cacheDirectory.storage.write(name, bytes.NewReader(content), int64(len(content)))
+}
+
 func (cacheDirectory *CacheDirectory) CheckOrCreateVersionFile(pull bool, version string) error {
-	cacheVersionFilePath := cacheDirectory.versionFilePath()
-	cacheVersionBytes, err := ioutil.ReadFile(cacheVersionFilePath)
+	cacheVersionBytes, err := cacheDirectory.readFile(versionFileName)
 	cacheVersionFileExists := !os.IsNotExist(err)
 	if err != nil && cacheVersionFileExists {
 		return errors.Wrap(err, "Could not read version file from cache directory.")
@@ -61,8 +143,7 @@ func (cacheDirectory *CacheDirectory) CheckOrCreateVersionFile(pull bool, versio
 	}
 
 	if pull {
-		cacheParentPath := filepath.Dir(cacheDirectory.path)
-		_, err := os.Stat(cacheParentPath)
+		err := cacheDirectory.storage.checkParent()
 		if err != nil {
 			if os.IsNotExist(err) {
 				return usererrors.New(errorCacheParentDoesNotExist)
@@ -71,22 +152,22 @@ func (cacheDirectory *CacheDirectory) CheckOrCreateVersionFile(pull bool, versio
 		}
 
 		if cacheVersionFileExists {
-			err := os.RemoveAll(cacheDirectory.path)
+			err := cacheDirectory.storage.removeAll("")
 			if err != nil {
 				return errors.Wrap(err, "Error removing outdated cache directory.")
 			}
 		}
 
-		isEmptyOrNonExistent, err := isEmptyOrNonExistentDirectory(cacheDirectory.path)
+		isEmptyOrNonExistent, err := cacheDirectory.storage.isEmpty()
 		if err != nil {
 			return err
 		}
 		if isEmptyOrNonExistent {
-			err := os.Mkdir(cacheDirectory.path, 0755)
+			err := cacheDirectory.storage.create()
 			if err != nil {
 				return errors.Wrap(err, "Could not create cache directory.")
 			}
-			err = ioutil.WriteFile(cacheVersionFilePath, []byte(version), 0644)
+			err = cacheDirectory.writeFile(versionFileName, []byte(version))
 			if err != nil {
 				return errors.Wrap(err, "Could not create cache version file.")
 			}
@@ -102,17 +183,16 @@ func (cacheDirectory *CacheDirectory) CheckOrCreateVersionFile(pull bool, versio
 }
 
 func (cacheDirectory *CacheDirectory) Lock() error {
-	file, err := os.Create(cacheDirectory.lockFilePath())
+	err := cacheDirectory.writeFile(lockFileName, []byte{})
 	if err != nil {
 		return errors.Wrap(err, "Error locking cache directory.")
 	}
-	defer file.Close()
 	// If the cache directory is already locked, it's not really a huge issue since the purpose of the lock is mostly to check whether a `pull` operation was interrupted before pushing.
 	return nil
 }
 
 func (cacheDirectory *CacheDirectory) Unlock() error {
-	err := os.Remove(cacheDirectory.lockFilePath())
+	err := cacheDirectory.storage.remove(lockFileName)
 	if err != nil {
 		return errors.Wrap(err, "Error unlocking cache directory.")
 	}
@@ -120,7 +200,7 @@ func (cacheDirectory *CacheDirectory) Unlock() error {
 }
 
 func (cacheDirectory *CacheDirectory) CheckLock() error {
-	_, err := os.Stat(cacheDirectory.lockFilePath())
+	_, err := cacheDirectory.storage.size(lockFileName)
 	if err == nil {
 		return usererrors.New(errorCacheLocked)
 	}
@@ -130,18 +210,170 @@ func (cacheDirectory *CacheDirectory) CheckLock() error {
 	return errors.Wrap(err, "Error checking if cache directory is locked.")
 }
 
-func (cacheDirectory *CacheDirectory) versionFilePath() string {
-	return path.Join(cacheDirectory.path, ".codeql-actions-sync-version")
+// ListReleases returns the names of all releases stored in the cache.
+func (cacheDirectory *CacheDirectory) ListReleases() ([]string, error) {
+	entries, err := cacheDirectory.storage.list("releases")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading releases.")
+	}
+	releases := []string{}
+	for _, entry := range entries {
+		if entry.isDir {
+			releases = append(releases, entry.name)
+		}
+	}
+	return releases, nil
+}
+
+// ListAssets returns all the assets stored in the cache for a release.
+func (cacheDirectory *CacheDirectory) ListAssets(release string) ([]Asset, error) {
+	entries, err := cacheDirectory.storage.list(assetsKey(release))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Asset{}, nil
+		}
+		return nil, errors.Wrap(err, "Error reading release assets.")
+	}
+	assets := []Asset{}
+	for _, entry := range entries {
+		if !entry.isDir {
+			assets = append(assets, Asset{Name: entry.name, Size: entry.size})
+		}
+	}
+	return assets, nil
+}
+
+// ReadMetadata returns the release metadata stored in the cache for a release.
+func (cacheDirectory *CacheDirectory) ReadMetadata(release string) ([]byte, error) {
+	return cacheDirectory.readFile(metadataKey(release))
+}
+
+func (cacheDirectory *CacheDirectory) WriteMetadata(release string, metadata []byte) error {
+	return cacheDirectory.writeFile(metadataKey(release), metadata)
 }
 
-func (cacheDirectory *CacheDirectory) lockFilePath() string {
-	return path.Join(cacheDirectory.path, ".codeql-actions-sync-lock")
+// AssetSize returns the size of a cached asset, or an error satisfying `os.IsNotExist` if it is not in the cache.
+func (cacheDirectory *CacheDirectory) AssetSize(release string, assetName string) (int64, error) {
+	return cacheDirectory.storage.size(assetKey(release, assetName))
+}
+
+func (cacheDirectory *CacheDirectory) OpenAsset(release string, assetName string) (io.ReadCloser, error) {
+	return cacheDirectory.storage.open(assetKey(release, assetName))
+}
+
+// WriteAsset streams an asset of a known size into the cache.
+func (cacheDirectory *CacheDirectory) WriteAsset(release string, assetName string, reader io.Reader, size int64) error {
+	return cacheDirectory.storage.write(assetKey(release, assetName), reader, size)
+}
+
+func (cacheDirectory *CacheDirectory) RemoveAsset(release string, assetName string) error {
+	err := cacheDirectory.storage.remove(assetKey(release, assetName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveRelease removes a release and all of its assets from the cache.
+func (cacheDirectory *CacheDirectory) RemoveRelease(release string) error {
+	return cacheDirectory.storage.removeAll(releaseKey(release))
+}
+
+// ReadDestinations returns the record of where the cache has previously been pushed to, as written by WriteDestinations. For a read-only cache that has not been pushed from with its work directory yet, the record left in the cache by pushes made before it was read-only is returned.
+func (cacheDirectory *CacheDirectory) ReadDestinations() ([]byte, error) {
+	destinations, err := readStorageFile(cacheDirectory.stateStorage(), destinationsFileName)
+	if os.IsNotExist(err) && cacheDirectory.IsReadOnly() {
+		return cacheDirectory.readFile(destinationsFileName)
+	}
+	return destinations, err
+}
+
+func (cacheDirectory *CacheDirectory) WriteDestinations(destinations []byte) error {
+	return cacheDirectory.stateStorage().write(destinationsFileName, bytes.NewReader(destinations), int64(len(destinations)))
+}
+
+// ReadContentsManifest returns the description of the cache contents written by WriteContentsManifest, which is meant for other tools as well as the sync tool.
+func (cacheDirectory *CacheDirectory) ReadContentsManifest() ([]byte, error) {
+	return cacheDirectory.readFile(contentsManifestFileName)
+}
+
+func (cacheDirectory *CacheDirectory) WriteContentsManifest(manifest []byte) error {
+	return cacheDirectory.writeFile(contentsManifestFileName, manifest)
+}
+
+// ReadLatestRelease returns the tag of the release the source marks as its latest, as written by WriteLatestRelease, or an empty string if the source has none or it is not known.
+func (cacheDirectory *CacheDirectory) ReadLatestRelease() (string, error) {
+	latestRelease, err := cacheDirectory.readFile(latestReleaseFileName)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(latestRelease)), nil
+}
+
+func (cacheDirectory *CacheDirectory) WriteLatestRelease(tag string) error {
+	return cacheDirectory.writeFile(latestReleaseFileName, []byte(tag))
+}
+
+// ReadSourceRepository returns the repository the cache was pulled from, such as `github/codeql-action`, as written by WriteSourceRepository, or an empty string if it is not known.
+func (cacheDirectory *CacheDirectory) ReadSourceRepository() (string, error) {
+	sourceRepository, err := cacheDirectory.readFile(sourceRepositoryFileName)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(sourceRepository)), nil
+}
+
+func (cacheDirectory *CacheDirectory) WriteSourceRepository(repository string) error {
+	return cacheDirectory.writeFile(sourceRepositoryFileName, []byte(repository))
+}
+
+// ReadSourceKind returns what the cache was pulled from, as written by WriteSourceKind. Caches pulled before the kind was recorded are always of the CodeQL Action.
+func (cacheDirectory *CacheDirectory) ReadSourceKind() (string, error) {
+	sourceKind, err := cacheDirectory.readFile(sourceKindFileName)
+	if os.IsNotExist(err) {
+		return SourceKindAction, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if kind := strings.TrimSpace(string(sourceKind)); kind != "" {
+		return kind, nil
+	}
+	return SourceKindAction, nil
+}
+
+func (cacheDirectory *CacheDirectory) WriteSourceKind(kind string) error {
+	return cacheDirectory.writeFile(sourceKindFileName, []byte(kind))
+}
+
+func releaseKey(release string) string {
+	return "releases/" + release
+}
+
+func assetsKey(release string) string {
+	return releaseKey(release) + "/assets"
+}
+
+func assetKey(release string, asset string) string {
+	return assetsKey(release) + "/" + asset
+}
+
+func metadataKey(release string) string {
+	return releaseKey(release) + "/metadata.json"
 }
 
 func (cacheDirectory *CacheDirectory) GitPath() string {
-	return path.Join(cacheDirectory.path, "git")
+	return cacheDirectory.gitPath
 }
 
+// The following paths are only meaningful for caches stored on the local filesystem.
+
 func (cacheDirectory *CacheDirectory) ReleasesPath() string {
 	return path.Join(cacheDirectory.path, "releases")
 }
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/github/codeql-action-sync/test"
@@ -99,3 +100,31 @@ func TestLocking(t *testing.T) {
 	require.NoError(t, cacheDirectory.Unlock())
 	require.NoError(t, cacheDirectory.CheckLock())
 }
+
+func TestSourceKind(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	kind, err := cacheDirectory.ReadSourceKind()
+	require.NoError(t, err)
+	require.Equal(t, SourceKindAction, kind)
+	require.NoError(t, cacheDirectory.WriteSourceKind(SourceKindCLI))
+	kind, err = cacheDirectory.ReadSourceKind()
+	require.NoError(t, err)
+	require.Equal(t, SourceKindCLI, kind)
+}
+
+func TestAssetOutsideCacheDirectory(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	outside := path.Join(temporaryDirectory, "outside")
+	err := cacheDirectory.WriteAsset("codeql-bundle-20200101", "../../../../outside", strings.NewReader("Outside."), 8)
+	require.EqualError(t, err, "The name releases/codeql-bundle-20200101/assets/../../../../outside refers to a file outside of the cache directory.")
+	require.NoFileExists(t, outside)
+
+	require.NoError(t, ioutil.WriteFile(outside, []byte("Outside."), 0644))
+	_, err = cacheDirectory.OpenAsset("../../..", "outside")
+	require.Error(t, err)
+	require.Error(t, cacheDirectory.RemoveAsset("../../..", "outside"))
+	require.FileExists(t, outside)
+}
@@ -0,0 +1,132 @@
+package cachedirectory
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Many releases have identical assets, so the content of each asset is stored once under its checksum and every release's copy is a hard link to it. Tools such as `tar` also keep hard-linked files once, so this shrinks archives of the cache too.
+const objectsDirectory = "objects/sha256"
+
+func objectKey(digest string) string {
+	return objectsDirectory + "/" + digest
+}
+
+// linker is implemented by cache backends which can make a file share stored content without copying it.
+type linker interface {
+	link(name string, digest string) error
+}
+
+// link makes the file name, which must have the given checksum, share its content with any other file with the same checksum.
+func (localStorage *localStorage) link(name string, digest string) error {
+	filePath, err := localStorage.resolve(name)
+	if err != nil {
+		return err
+	}
+	objectPath, err := localStorage.resolve(objectKey(digest))
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(objectPath), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Link(filePath, objectPath)
+	if err == nil || !os.IsExist(err) {
+		return err
+	}
+	fileStat, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	objectStat, err := os.Stat(objectPath)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(fileStat, objectStat) {
+		return nil
+	}
+	valid, err := hasDigest(objectPath, digest)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		// The stored content was changed in place through another release's copy of it, so the file replaces it.
+		return replaceWithLink(objectPath, filePath)
+	}
+	return replaceWithLink(filePath, objectPath)
+}
+
+func hasDigest(filePath string, digest string) (bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	actualDigest, err := Digest(file)
+	if err != nil {
+		return false, err
+	}
+	return actualDigest == digest, nil
+}
+
+// replaceWithLink replaces a file with a hard link to target. The link is made alongside the file and renamed over it, so that the file is never missing if this is interrupted.
+func replaceWithLink(filePath string, target string) error {
+	linkPath := filePath + ".link"
+	err := os.Remove(linkPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Link(target, linkPath)
+	if err != nil {
+		return err
+	}
+	return os.Rename(linkPath, filePath)
+}
+
+// DeduplicateAsset makes a cached asset share its content with any other asset in the cache that has the same checksum. Remote caches cannot link files, so for them it does nothing.
+func (cacheDirectory *CacheDirectory) DeduplicateAsset(release string, assetName string, digest string) error {
+	linker, ok := cacheDirectory.storage.(linker)
+	if !ok {
+		return nil
+	}
+	err := linker.link(assetKey(release, assetName), digest)
+	if err != nil {
+		return errors.Wrap(err, "Error linking cached asset to its content.")
+	}
+	return nil
+}
+
+// PruneAssetContent removes stored asset content that no asset in the manifest has any more, such as after an asset was replaced at its source.
+func (cacheDirectory *CacheDirectory) PruneAssetContent(manifest *Manifest) error {
+	if _, ok := cacheDirectory.storage.(linker); !ok {
+		return nil
+	}
+	entries, err := cacheDirectory.storage.list(objectsDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "Error listing stored asset content.")
+	}
+	manifest.lock.Lock()
+	used := map[string]bool{}
+	for _, assets := range manifest.Releases {
+		for _, entry := range assets {
+			used[entry.SHA256] = true
+		}
+	}
+	manifest.lock.Unlock()
+	for _, entry := range entries {
+		if entry.isDir || used[entry.name] {
+			continue
+		}
+		err := cacheDirectory.storage.remove(objectKey(entry.name))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Error removing unused asset content.")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package cachedirectory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestAsset(t *testing.T, cacheDirectory *CacheDirectory, manifest *Manifest, release string, content string) string {
+	require.NoError(t, cacheDirectory.WriteAsset(release, "codeql-bundle.tar.gz", strings.NewReader(content), int64(len(content))))
+	digest, err := Digest(strings.NewReader(content))
+	require.NoError(t, err)
+	manifest.Set(release, "codeql-bundle.tar.gz", ManifestEntry{Size: int64(len(content)), SHA256: digest})
+	return digest
+}
+
+func requireSameAssetFile(t *testing.T, cacheDirectory *CacheDirectory, release string, otherRelease string, same bool) {
+	stat, err := os.Stat(cacheDirectory.AssetPath(release, "codeql-bundle.tar.gz"))
+	require.NoError(t, err)
+	otherStat, err := os.Stat(cacheDirectory.AssetPath(otherRelease, "codeql-bundle.tar.gz"))
+	require.NoError(t, err)
+	require.Equal(t, same, os.SameFile(stat, otherStat))
+}
+
+func TestDeduplicateAsset(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	manifest := &Manifest{Releases: map[string]map[string]ManifestEntry{}}
+
+	digest := writeTestAsset(t, &cacheDirectory, manifest, "codeql-bundle-20200601", "This isn't really a CodeQL bundle!")
+	require.NoError(t, cacheDirectory.DeduplicateAsset("codeql-bundle-20200601", "codeql-bundle.tar.gz", digest))
+	writeTestAsset(t, &cacheDirectory, manifest, "codeql-bundle-20200630", "This isn't really a CodeQL bundle!")
+	requireSameAssetFile(t, &cacheDirectory, "codeql-bundle-20200601", "codeql-bundle-20200630", false)
+	require.NoError(t, cacheDirectory.DeduplicateAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz", digest))
+	requireSameAssetFile(t, &cacheDirectory, "codeql-bundle-20200601", "codeql-bundle-20200630", true)
+	// Doing it again changes nothing.
+	require.NoError(t, cacheDirectory.DeduplicateAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz", digest))
+	requireSameAssetFile(t, &cacheDirectory, "codeql-bundle-20200601", "codeql-bundle-20200630", true)
+
+	// Replacing one release's asset leaves the other release's asset alone.
+	writeTestAsset(t, &cacheDirectory, manifest, "codeql-bundle-20200630", "This isn't a CodeQL bundle either.")
+	test.RequireFileHasContent(t, "This isn't really a CodeQL bundle!", cacheDirectory.AssetPath("codeql-bundle-20200601", "codeql-bundle.tar.gz"))
+	test.RequireFileHasContent(t, "This isn't a CodeQL bundle either.", cacheDirectory.AssetPath("codeql-bundle-20200630", "codeql-bundle.tar.gz"))
+}
+
+func TestDeduplicateAssetReplacesChangedContent(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	manifest := &Manifest{Releases: map[string]map[string]ManifestEntry{}}
+
+	digest := writeTestAsset(t, &cacheDirectory, manifest, "codeql-bundle-20200601", "This isn't really a CodeQL bundle!")
+	require.NoError(t, cacheDirectory.DeduplicateAsset("codeql-bundle-20200601", "codeql-bundle.tar.gz", digest))
+	// Editing the asset in place also changes the stored content.
+	require.NoError(t, ioutil.WriteFile(cacheDirectory.AssetPath("codeql-bundle-20200601", "codeql-bundle.tar.gz"), []byte("Some nonsense."), 0644))
+
+	writeTestAsset(t, &cacheDirectory, manifest, "codeql-bundle-20200630", "This isn't really a CodeQL bundle!")
+	require.NoError(t, cacheDirectory.DeduplicateAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz", digest))
+	test.RequireFileHasContent(t, "This isn't really a CodeQL bundle!", cacheDirectory.AssetPath("codeql-bundle-20200630", "codeql-bundle.tar.gz"))
+	test.RequireFileHasContent(t, "This isn't really a CodeQL bundle!", path.Join(cacheDirectory.path, objectKey(digest)))
+}
+
+func TestPruneAssetContent(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	manifest := &Manifest{Releases: map[string]map[string]ManifestEntry{}}
+
+	// Nothing has been stored yet.
+	require.NoError(t, cacheDirectory.PruneAssetContent(manifest))
+
+	oldDigest := writeTestAsset(t, &cacheDirectory, manifest, "codeql-bundle-20200601", "This isn't really a CodeQL bundle!")
+	require.NoError(t, cacheDirectory.DeduplicateAsset("codeql-bundle-20200601", "codeql-bundle.tar.gz", oldDigest))
+	newDigest := writeTestAsset(t, &cacheDirectory, manifest, "codeql-bundle-20200601", "This isn't a CodeQL bundle either.")
+	require.NoError(t, cacheDirectory.DeduplicateAsset("codeql-bundle-20200601", "codeql-bundle.tar.gz", newDigest))
+
+	require.NoError(t, cacheDirectory.PruneAssetContent(manifest))
+	require.NoFileExists(t, path.Join(cacheDirectory.path, objectKey(oldDigest)))
+	require.FileExists(t, path.Join(cacheDirectory.path, objectKey(newDigest)))
+	test.RequireFileHasContent(t, "This isn't a CodeQL bundle either.", cacheDirectory.AssetPath("codeql-bundle-20200601", "codeql-bundle.tar.gz"))
+}
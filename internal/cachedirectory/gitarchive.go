@@ -0,0 +1,146 @@
+package cachedirectory
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const gitArchiveName = "git.tar"
+
+func writeGitArchive(gitPath string, writer io.Writer) error {
+	tarWriter := tar.NewWriter(writer)
+	err := filepath.Walk(gitPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(gitPath, filePath)
+		if err != nil {
+			return err
+		}
+		if relativePath == "." {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relativePath)
+		err = tarWriter.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+func readGitArchive(reader io.Reader, gitPath string) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(gitPath, filepath.FromSlash(header.Name))
+		relativePath, err := filepath.Rel(gitPath, targetPath)
+		if err != nil || strings.HasPrefix(relativePath, "..") {
+			return errors.Errorf("Invalid path %s in Git archive.", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(targetPath, 0755)
+			if err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(targetPath), 0755)
+			if err != nil {
+				return err
+			}
+			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tarReader)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// LoadGit makes the Git repository available at `GitPath()`. For remote caches the repository is downloaded and extracted to a local working directory. For local caches this does nothing.
+func (cacheDirectory *CacheDirectory) LoadGit() error {
+	if !cacheDirectory.IsRemote() {
+		return nil
+	}
+	err := os.RemoveAll(cacheDirectory.gitPath)
+	if err != nil {
+		return errors.Wrap(err, "Error removing existing local Git working directory.")
+	}
+	reader, err := cacheDirectory.storage.open(gitArchiveName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "Error opening Git archive from cache.")
+	}
+	defer reader.Close()
+	err = readGitArchive(reader, cacheDirectory.gitPath)
+	if err != nil {
+		return errors.Wrap(err, "Error extracting Git archive from cache.")
+	}
+	return nil
+}
+
+// StoreGit uploads the Git repository from the local working directory to a remote cache. For local caches this does nothing.
+func (cacheDirectory *CacheDirectory) StoreGit() error {
+	if !cacheDirectory.IsRemote() {
+		return nil
+	}
+	archiveFile, err := ioutil.TempFile("", "codeql-action-sync-git")
+	if err != nil {
+		return errors.Wrap(err, "Error creating temporary Git archive.")
+	}
+	defer os.Remove(archiveFile.Name())
+	defer archiveFile.Close()
+	err = writeGitArchive(cacheDirectory.gitPath, archiveFile)
+	if err != nil {
+		return errors.Wrap(err, "Error creating Git archive.")
+	}
+	size, err := archiveFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrap(err, "Error creating Git archive.")
+	}
+	_, err = archiveFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrap(err, "Error creating Git archive.")
+	}
+	err = cacheDirectory.storage.write(gitArchiveName, archiveFile, size)
+	if err != nil {
+		return errors.Wrap(err, "Error uploading Git archive to cache.")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package cachedirectory
+
+import (
+	"bytes"
+	"os"
+)
+
+const pushJournalFileName = ".codeql-actions-sync-push-journal.jsonl"
+
+// ReadPushJournal returns the journal of the operations made by pushes, one JSON record per line, or an error satisfying os.IsNotExist if there is none. Like the record of push destinations, it is kept in the work directory of a read-only cache.
+func (cacheDirectory *CacheDirectory) ReadPushJournal() ([]byte, error) {
+	return readStorageFile(cacheDirectory.stateStorage(), pushJournalFileName)
+}
+
+// AppendPushJournal adds a record to the end of the push journal. On the local filesystem the record is synced to disk before returning, so that it survives a crash or power loss straight afterwards. Remote storage cannot be appended to, so the whole journal is written again, which replaces it in one step.
+func (cacheDirectory *CacheDirectory) AppendPushJournal(record []byte) error {
+	storage := cacheDirectory.stateStorage()
+	if local, ok := storage.(*localStorage); ok {
+		journalPath, err := local.resolve(pushJournalFileName)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(journalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = file.Write(append(record, '\n'))
+		if err != nil {
+			return err
+		}
+		err = file.Sync()
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+	journal, err := readStorageFile(storage, pushJournalFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	journal = append(append(journal, record...), '\n')
+	return storage.write(pushJournalFileName, bytes.NewReader(journal), int64(len(journal)))
+}
+
+// WritePushJournal replaces the push journal, for example to leave out the records of a push that has finished. An empty journal is removed. On the local filesystem the new journal is written alongside and renamed into place, so that a crash leaves either the old journal or the new one.
+func (cacheDirectory *CacheDirectory) WritePushJournal(journal []byte) error {
+	storage := cacheDirectory.stateStorage()
+	if len(journal) == 0 {
+		err := storage.remove(pushJournalFileName)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if local, ok := storage.(*localStorage); ok {
+		journalPath, err := local.resolve(pushJournalFileName)
+		if err != nil {
+			return err
+		}
+		temporaryPath := journalPath + ".tmp"
+		file, err := os.Create(temporaryPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = file.Write(journal)
+		if err != nil {
+			return err
+		}
+		err = file.Sync()
+		if err != nil {
+			return err
+		}
+		err = file.Close()
+		if err != nil {
+			return err
+		}
+		return os.Rename(temporaryPath, journalPath)
+	}
+	return storage.write(pushJournalFileName, bytes.NewReader(journal), int64(len(journal)))
+}
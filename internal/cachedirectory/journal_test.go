@@ -0,0 +1,47 @@
+package cachedirectory
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+// remoteTestStorage hides that the storage is on the local filesystem, so that what is done for remote storage is tested.
+type remoteTestStorage struct {
+	storage
+}
+
+func testPushJournal(t *testing.T, cacheDirectory CacheDirectory) {
+	_, err := cacheDirectory.ReadPushJournal()
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, cacheDirectory.AppendPushJournal([]byte(`{"operation":"create-release"}`)))
+	require.NoError(t, cacheDirectory.AppendPushJournal([]byte(`{"operation":"create-release","completed":true}`)))
+	journal, err := cacheDirectory.ReadPushJournal()
+	require.NoError(t, err)
+	require.Equal(t, "{\"operation\":\"create-release\"}\n{\"operation\":\"create-release\",\"completed\":true}\n", string(journal))
+
+	require.NoError(t, cacheDirectory.WritePushJournal([]byte("{}\n")))
+	journal, err = cacheDirectory.ReadPushJournal()
+	require.NoError(t, err)
+	require.Equal(t, "{}\n", string(journal))
+
+	require.NoError(t, cacheDirectory.WritePushJournal(nil))
+	require.NoError(t, cacheDirectory.WritePushJournal(nil))
+	_, err = cacheDirectory.ReadPushJournal()
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPushJournal(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	testPushJournal(t, NewCacheDirectory(temporaryDirectory))
+	require.NoFileExists(t, path.Join(temporaryDirectory, pushJournalFileName+".tmp"))
+}
+
+func TestPushJournalInRemoteStorage(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	testPushJournal(t, newRemoteCacheDirectory("test://cache", remoteTestStorage{&localStorage{path: temporaryDirectory}}))
+}
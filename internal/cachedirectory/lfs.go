@@ -0,0 +1,33 @@
+package cachedirectory
+
+import (
+	"io"
+	"os"
+)
+
+// lfsObjectKey is where a Git LFS object is stored, by its SHA-256 object ID, in the same layout Git LFS uses.
+func lfsObjectKey(oid string) string {
+	return "lfs/objects/" + oid[0:2] + "/" + oid[2:4] + "/" + oid
+}
+
+// LFSObjectSize returns the size of a Git LFS object, or an error satisfying `os.IsNotExist` if it is not in the cache.
+func (cacheDirectory *CacheDirectory) LFSObjectSize(oid string) (int64, error) {
+	return cacheDirectory.storage.size(lfsObjectKey(oid))
+}
+
+func (cacheDirectory *CacheDirectory) OpenLFSObject(oid string) (io.ReadCloser, error) {
+	return cacheDirectory.storage.open(lfsObjectKey(oid))
+}
+
+// WriteLFSObject streams a Git LFS object of a known size into the cache.
+func (cacheDirectory *CacheDirectory) WriteLFSObject(oid string, reader io.Reader, size int64) error {
+	return cacheDirectory.storage.write(lfsObjectKey(oid), reader, size)
+}
+
+func (cacheDirectory *CacheDirectory) RemoveLFSObject(oid string) error {
+	err := cacheDirectory.storage.remove(lfsObjectKey(oid))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package cachedirectory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLFSObjects(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	oid := "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	_, err := cacheDirectory.LFSObjectSize(oid)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, cacheDirectory.WriteLFSObject(oid, strings.NewReader("content"), 7))
+	size, err := cacheDirectory.LFSObjectSize(oid)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), size)
+	require.FileExists(t, path.Join(temporaryDirectory, "cache", "lfs", "objects", "4d", "7a", oid))
+	reader, err := cacheDirectory.OpenLFSObject(oid)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, "content", string(content))
+
+	require.NoError(t, cacheDirectory.RemoveLFSObject(oid))
+	require.NoError(t, cacheDirectory.RemoveLFSObject(oid))
+	_, err = cacheDirectory.LFSObjectSize(oid)
+	require.True(t, os.IsNotExist(err))
+}
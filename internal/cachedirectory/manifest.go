@@ -0,0 +1,119 @@
+package cachedirectory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const manifestFileName = ".codeql-actions-sync-manifest.json"
+
+// ManifestEntry records the content of an asset in the cache, so that a changed or corrupted asset is noticed even if its size is the same.
+type ManifestEntry struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Source identifies the version of the asset that was downloaded, so that an asset replaced at the source is downloaded again.
+	Source string `json:"source,omitempty"`
+}
+
+// Manifest records the checksum of every asset in the cache. It is safe to use from multiple goroutines.
+type Manifest struct {
+	lock     sync.Mutex
+	Releases map[string]map[string]ManifestEntry `json:"releases"`
+}
+
+// Get returns the entry for an asset, and whether there is one.
+func (manifest *Manifest) Get(release string, assetName string) (ManifestEntry, bool) {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	entry, exists := manifest.Releases[release][assetName]
+	return entry, exists
+}
+
+func (manifest *Manifest) Set(release string, assetName string, entry ManifestEntry) {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	if manifest.Releases[release] == nil {
+		manifest.Releases[release] = map[string]ManifestEntry{}
+	}
+	manifest.Releases[release][assetName] = entry
+}
+
+// Remove forgets every asset of a release.
+func (manifest *Manifest) Remove(release string) {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	delete(manifest.Releases, release)
+}
+
+// ReadManifest returns the manifest of the cache, which is empty if none has been written yet.
+func (cacheDirectory *CacheDirectory) ReadManifest() (*Manifest, error) {
+	manifest := &Manifest{Releases: map[string]map[string]ManifestEntry{}}
+	manifestJSON, err := cacheDirectory.readFile(manifestFileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, errors.Wrap(err, "Error reading cache manifest.")
+	}
+	err = json.Unmarshal(manifestJSON, manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error decoding cache manifest.")
+	}
+	if manifest.Releases == nil {
+		manifest.Releases = map[string]map[string]ManifestEntry{}
+	}
+	return manifest, nil
+}
+
+func (cacheDirectory *CacheDirectory) WriteManifest(manifest *Manifest) error {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Wrap(err, "Error converting cache manifest to JSON.")
+	}
+	err = cacheDirectory.writeFile(manifestFileName, manifestJSON)
+	if err != nil {
+		return errors.Wrap(err, "Error writing cache manifest.")
+	}
+	return nil
+}
+
+// Digest returns the hex-encoded SHA-256 checksum of everything read from the reader.
+func Digest(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// AssetDigest reads a cached asset and returns its hex-encoded SHA-256 checksum. The checksum of a streamed asset is the one recorded when it was pulled.
+func (cacheDirectory *CacheDirectory) AssetDigest(release string, assetName string) (string, error) {
+	if digester, ok := cacheDirectory.storage.(digester); ok {
+		digest, known, err := digester.digest(assetKey(release, assetName))
+		if err != nil {
+			return "", errors.Wrap(err, "Error reading cached asset.")
+		}
+		if known {
+			return digest, nil
+		}
+	}
+	reader, err := cacheDirectory.OpenAsset(release, assetName)
+	if err != nil {
+		return "", errors.Wrap(err, "Error opening cached asset.")
+	}
+	defer reader.Close()
+	digest, err := Digest(reader)
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading cached asset.")
+	}
+	return digest, nil
+}
@@ -0,0 +1,34 @@
+package cachedirectory
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+
+	manifest, err := cacheDirectory.ReadManifest()
+	require.NoError(t, err)
+	_, exists := manifest.Get("a-release", "bundle.tar.gz")
+	require.False(t, exists)
+
+	require.NoError(t, cacheDirectory.WriteAsset("a-release", "bundle.tar.gz", strings.NewReader("Not a CodeQL bundle."), 20))
+	digest, err := cacheDirectory.AssetDigest("a-release", "bundle.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, "6f232d70c3d305f60866de6c2296e3d0c77a6da993e3c05b22ef716efe3e5006", digest)
+	manifest.Set("a-release", "bundle.tar.gz", ManifestEntry{Size: 20, SHA256: digest, Source: "1@2020-01-01T00:00:00Z"})
+	require.NoError(t, cacheDirectory.WriteManifest(manifest))
+
+	manifest, err = cacheDirectory.ReadManifest()
+	require.NoError(t, err)
+	entry, exists := manifest.Get("a-release", "bundle.tar.gz")
+	require.True(t, exists)
+	require.Equal(t, ManifestEntry{Size: 20, SHA256: digest, Source: "1@2020-01-01T00:00:00Z"}, entry)
+}
@@ -0,0 +1,103 @@
+package cachedirectory
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const packManifestsKey = "packs/manifests"
+const packManifestSuffix = ".json"
+
+// Pack identifies a version of a CodeQL pack in the cache, such as version 1.0.0 of `codeql/java-queries`.
+type Pack struct {
+	// Name is the scope and name of the pack, which is also its repository in a container registry.
+	Name    string
+	Version string
+}
+
+func (pack Pack) String() string {
+	return pack.Name + "@" + pack.Version
+}
+
+func packManifestKey(pack Pack) string {
+	return packManifestsKey + "/" + pack.Name + "/" + pack.Version + packManifestSuffix
+}
+
+// packBlobKey is where a blob of a pack is stored. Blobs are stored by digest, such as `sha256:...`, so that they are shared between versions and packs.
+func packBlobKey(digest string) string {
+	return "packs/blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// ListPacks returns each version of each CodeQL pack stored in the cache, sorted by name and version.
+func (cacheDirectory *CacheDirectory) ListPacks() ([]Pack, error) {
+	packs := []Pack{}
+	scopes, err := cacheDirectory.storage.list(packManifestsKey)
+	if os.IsNotExist(err) {
+		return packs, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading packs.")
+	}
+	for _, scope := range scopes {
+		if !scope.isDir {
+			continue
+		}
+		names, err := cacheDirectory.storage.list(packManifestsKey + "/" + scope.name)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading packs.")
+		}
+		for _, name := range names {
+			if !name.isDir {
+				continue
+			}
+			versions, err := cacheDirectory.storage.list(packManifestsKey + "/" + scope.name + "/" + name.name)
+			if err != nil {
+				return nil, errors.Wrap(err, "Error reading pack versions.")
+			}
+			for _, version := range versions {
+				if !version.isDir && strings.HasSuffix(version.name, packManifestSuffix) {
+					packs = append(packs, Pack{Name: scope.name + "/" + name.name, Version: strings.TrimSuffix(version.name, packManifestSuffix)})
+				}
+			}
+		}
+	}
+	sort.Slice(packs, func(i, j int) bool {
+		return packs[i].String() < packs[j].String()
+	})
+	return packs, nil
+}
+
+// ReadPackManifest returns the image manifest of a pack as it was pulled from the container registry.
+func (cacheDirectory *CacheDirectory) ReadPackManifest(pack Pack) ([]byte, error) {
+	return cacheDirectory.readFile(packManifestKey(pack))
+}
+
+func (cacheDirectory *CacheDirectory) WritePackManifest(pack Pack, manifest []byte) error {
+	return cacheDirectory.writeFile(packManifestKey(pack), manifest)
+}
+
+// PackBlobSize returns the size of a blob of a pack, or an error satisfying `os.IsNotExist` if it is not in the cache.
+func (cacheDirectory *CacheDirectory) PackBlobSize(digest string) (int64, error) {
+	return cacheDirectory.storage.size(packBlobKey(digest))
+}
+
+func (cacheDirectory *CacheDirectory) OpenPackBlob(digest string) (io.ReadCloser, error) {
+	return cacheDirectory.storage.open(packBlobKey(digest))
+}
+
+// WritePackBlob streams a blob of a pack of a known size into the cache.
+func (cacheDirectory *CacheDirectory) WritePackBlob(digest string, reader io.Reader, size int64) error {
+	return cacheDirectory.storage.write(packBlobKey(digest), reader, size)
+}
+
+func (cacheDirectory *CacheDirectory) RemovePackBlob(digest string) error {
+	err := cacheDirectory.storage.remove(packBlobKey(digest))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package cachedirectory
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacks(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	packs, err := cacheDirectory.ListPacks()
+	require.NoError(t, err)
+	require.Empty(t, packs)
+
+	require.NoError(t, cacheDirectory.WritePackManifest(Pack{Name: "codeql/java-queries", Version: "1.1.0"}, []byte("java 1.1.0")))
+	require.NoError(t, cacheDirectory.WritePackManifest(Pack{Name: "codeql/java-queries", Version: "1.0.0"}, []byte("java 1.0.0")))
+	require.NoError(t, cacheDirectory.WritePackManifest(Pack{Name: "codeql/cpp-queries", Version: "1.0.0"}, []byte("cpp 1.0.0")))
+	packs, err = cacheDirectory.ListPacks()
+	require.NoError(t, err)
+	require.Equal(t, []Pack{
+		{Name: "codeql/cpp-queries", Version: "1.0.0"},
+		{Name: "codeql/java-queries", Version: "1.0.0"},
+		{Name: "codeql/java-queries", Version: "1.1.0"},
+	}, packs)
+	manifest, err := cacheDirectory.ReadPackManifest(packs[2])
+	require.NoError(t, err)
+	require.Equal(t, "java 1.1.0", string(manifest))
+
+	digest := "sha256:0123456789abcdef"
+	_, err = cacheDirectory.PackBlobSize(digest)
+	require.Error(t, err)
+	require.NoError(t, cacheDirectory.WritePackBlob(digest, strings.NewReader("blob"), 4))
+	size, err := cacheDirectory.PackBlobSize(digest)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), size)
+	reader, err := cacheDirectory.OpenPackBlob(digest)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, "blob", string(content))
+	require.NoError(t, cacheDirectory.RemovePackBlob(digest))
+	require.NoError(t, cacheDirectory.RemovePackBlob(digest))
+	_, err = cacheDirectory.PackBlobSize(digest)
+	require.Error(t, err)
+}
@@ -0,0 +1,320 @@
+package cachedirectory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// partialAssetChunkSize is the granularity at which download progress is persisted. At most this much data is lost if a download is interrupted.
+const partialAssetChunkSize = 4 * 1024 * 1024
+
+const progressFileSuffix = ".progress.json"
+
+type assetProgress struct {
+	Size           int64     `json:"size"`
+	ChunkSize      int64     `json:"chunk_size"`
+	ChunkHashes    []string  `json:"chunk_hashes"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	BytesPerSecond float64   `json:"bytes_per_second"`
+}
+
+// PartialAsset is an asset download in progress. Data is written to a staging file and a checksum of each completed chunk is recorded, so that an interrupted download can be resumed after verifying the data already on disk.
+type PartialAsset struct {
+	cacheDirectory     *CacheDirectory
+	release            string
+	name               string
+	file               *os.File
+	progress           assetProgress
+	offset             int64
+	chunkHash          hash.Hash
+	chunkLength        int64
+	sessionStart       time.Time
+	sessionStartOffset int64
+}
+
+// PartialAssetStatus describes the progress of an interrupted or in-progress asset download.
+type PartialAssetStatus struct {
+	Release        string
+	Name           string
+	Size           int64
+	Downloaded     int64
+	BytesPerSecond float64
+	UpdatedAt      time.Time
+}
+
+// SupportsPartialAssets returns true if interrupted asset downloads can be resumed with this cache.
+func (cacheDirectory *CacheDirectory) SupportsPartialAssets() bool {
+	return !cacheDirectory.remote && !cacheDirectory.IsStreaming()
+}
+
+func (cacheDirectory *CacheDirectory) partialAssetsPath(release string) string {
+	return path.Join(cacheDirectory.ReleasePath(release), "partial")
+}
+
+func (cacheDirectory *CacheDirectory) partialAssetPath(release string, assetName string) string {
+	return path.Join(cacheDirectory.partialAssetsPath(release), assetName)
+}
+
+func readAssetProgress(progressPath string) (*assetProgress, error) {
+	progressBytes, err := ioutil.ReadFile(progressPath)
+	if err != nil {
+		return nil, err
+	}
+	progress := assetProgress{}
+	err = json.Unmarshal(progressBytes, &progress)
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// verifiedLength returns the length of the prefix of the file that matches the recorded chunk checksums.
+func verifiedLength(file *os.File, progress *assetProgress) (int, error) {
+	buffer := make([]byte, progress.ChunkSize)
+	for index, expectedHash := range progress.ChunkHashes {
+		_, err := io.ReadFull(file, buffer)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return index, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		actualHash := sha256.Sum256(buffer)
+		if hex.EncodeToString(actualHash[:]) != expectedHash {
+			return index, nil
+		}
+	}
+	return len(progress.ChunkHashes), nil
+}
+
+// OpenPartialAsset starts or resumes the download of an asset. Any previously downloaded data is verified and only the chunks which match their recorded checksums are kept.
+func (cacheDirectory *CacheDirectory) OpenPartialAsset(release string, assetName string, size int64) (*PartialAsset, error) {
+	if !cacheDirectory.SupportsPartialAssets() {
+		return nil, errors.New("Resumable downloads are not supported for remote caches.")
+	}
+	err := os.MkdirAll(cacheDirectory.partialAssetsPath(release), 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating partial assets directory.")
+	}
+	partialPath := cacheDirectory.partialAssetPath(release, assetName)
+	file, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error opening partial asset.")
+	}
+
+	progress := assetProgress{Size: size, ChunkSize: partialAssetChunkSize, ChunkHashes: []string{}}
+	existingProgress, err := readAssetProgress(partialPath + progressFileSuffix)
+	if err == nil && existingProgress.Size == size && existingProgress.ChunkSize == partialAssetChunkSize {
+		validChunks, err := verifiedLength(file, existingProgress)
+		if err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "Error verifying partial asset.")
+		}
+		progress = *existingProgress
+		progress.ChunkHashes = progress.ChunkHashes[:validChunks]
+	}
+
+	offset := int64(len(progress.ChunkHashes)) * progress.ChunkSize
+	err = file.Truncate(offset)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "Error truncating partial asset.")
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "Error seeking in partial asset.")
+	}
+
+	return &PartialAsset{
+		cacheDirectory:     cacheDirectory,
+		release:            release,
+		name:               assetName,
+		file:               file,
+		progress:           progress,
+		offset:             offset,
+		chunkHash:          sha256.New(),
+		sessionStart:       time.Now(),
+		sessionStartOffset: offset,
+	}, nil
+}
+
+// Offset returns the number of bytes of the asset which have already been downloaded.
+func (partialAsset *PartialAsset) Offset() int64 {
+	return partialAsset.offset
+}
+
+// Reset discards all previously downloaded data, for example if the server does not support resuming downloads.
+func (partialAsset *PartialAsset) Reset() error {
+	err := partialAsset.file.Truncate(0)
+	if err != nil {
+		return errors.Wrap(err, "Error truncating partial asset.")
+	}
+	_, err = partialAsset.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrap(err, "Error seeking in partial asset.")
+	}
+	partialAsset.progress.ChunkHashes = []string{}
+	partialAsset.offset = 0
+	partialAsset.sessionStartOffset = 0
+	partialAsset.chunkHash.Reset()
+	partialAsset.chunkLength = 0
+	return nil
+}
+
+func (partialAsset *PartialAsset) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		chunk := data
+		if remaining := partialAsset.progress.ChunkSize - partialAsset.chunkLength; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		count, err := partialAsset.file.Write(chunk)
+		partialAsset.chunkHash.Write(chunk[:count])
+		partialAsset.chunkLength += int64(count)
+		partialAsset.offset += int64(count)
+		written += count
+		if err != nil {
+			return written, err
+		}
+		if partialAsset.chunkLength == partialAsset.progress.ChunkSize {
+			err := partialAsset.checkpoint()
+			if err != nil {
+				return written, err
+			}
+		}
+		data = data[count:]
+	}
+	return written, nil
+}
+
+func (partialAsset *PartialAsset) checkpoint() error {
+	err := partialAsset.file.Sync()
+	if err != nil {
+		return errors.Wrap(err, "Error flushing partial asset.")
+	}
+	partialAsset.progress.ChunkHashes = append(partialAsset.progress.ChunkHashes, hex.EncodeToString(partialAsset.chunkHash.Sum(nil)))
+	partialAsset.chunkHash.Reset()
+	partialAsset.chunkLength = 0
+
+	now := time.Now()
+	elapsed := now.Sub(partialAsset.sessionStart).Seconds()
+	if elapsed > 0 {
+		partialAsset.progress.BytesPerSecond = float64(partialAsset.offset-partialAsset.sessionStartOffset) / elapsed
+	}
+	partialAsset.progress.UpdatedAt = now
+	progressBytes, err := json.Marshal(partialAsset.progress)
+	if err != nil {
+		return errors.Wrap(err, "Error converting download progress to JSON.")
+	}
+	progressPath := partialAsset.cacheDirectory.partialAssetPath(partialAsset.release, partialAsset.name) + progressFileSuffix
+	err = ioutil.WriteFile(progressPath+".tmp", progressBytes, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Error writing download progress.")
+	}
+	err = os.Rename(progressPath+".tmp", progressPath)
+	if err != nil {
+		return errors.Wrap(err, "Error writing download progress.")
+	}
+	return nil
+}
+
+// Close stops the download, keeping all verified data so that it can be resumed later.
+func (partialAsset *PartialAsset) Close() error {
+	return partialAsset.file.Close()
+}
+
+// Complete moves the fully downloaded asset into the cache.
+func (partialAsset *PartialAsset) Complete() error {
+	if partialAsset.offset != partialAsset.progress.Size {
+		partialAsset.file.Close()
+		return errors.Errorf("Downloaded %d bytes of asset %s but expected %d.", partialAsset.offset, partialAsset.name, partialAsset.progress.Size)
+	}
+	err := partialAsset.file.Sync()
+	if err != nil {
+		partialAsset.file.Close()
+		return errors.Wrap(err, "Error flushing partial asset.")
+	}
+	err = partialAsset.file.Close()
+	if err != nil {
+		return errors.Wrap(err, "Error closing partial asset.")
+	}
+	cacheDirectory := partialAsset.cacheDirectory
+	err = os.MkdirAll(cacheDirectory.AssetsPath(partialAsset.release), 0755)
+	if err != nil {
+		return errors.Wrap(err, "Error creating assets directory.")
+	}
+	partialPath := cacheDirectory.partialAssetPath(partialAsset.release, partialAsset.name)
+	err = os.Rename(partialPath, cacheDirectory.AssetPath(partialAsset.release, partialAsset.name))
+	if err != nil {
+		return errors.Wrap(err, "Error moving downloaded asset into cache.")
+	}
+	err = os.RemoveAll(partialPath + progressFileSuffix)
+	if err != nil {
+		return errors.Wrap(err, "Error removing download progress.")
+	}
+	// This only succeeds once there are no other downloads in progress for the release.
+	os.Remove(cacheDirectory.partialAssetsPath(partialAsset.release))
+	return nil
+}
+
+// ListPartialAssets returns the progress of all interrupted or in-progress asset downloads.
+func (cacheDirectory *CacheDirectory) ListPartialAssets() ([]PartialAssetStatus, error) {
+	statuses := []PartialAssetStatus{}
+	if !cacheDirectory.SupportsPartialAssets() {
+		return statuses, nil
+	}
+	releases, err := cacheDirectory.ListReleases()
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return statuses, nil
+		}
+		return nil, err
+	}
+	for _, release := range releases {
+		stats, err := ioutil.ReadDir(cacheDirectory.partialAssetsPath(release))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrap(err, "Error reading partial assets.")
+		}
+		for _, stat := range stats {
+			if !strings.HasSuffix(stat.Name(), progressFileSuffix) {
+				continue
+			}
+			assetName := strings.TrimSuffix(stat.Name(), progressFileSuffix)
+			progress, err := readAssetProgress(path.Join(cacheDirectory.partialAssetsPath(release), stat.Name()))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error reading download progress for asset %s.", assetName)
+			}
+			statuses = append(statuses, PartialAssetStatus{
+				Release:        release,
+				Name:           assetName,
+				Size:           progress.Size,
+				Downloaded:     int64(len(progress.ChunkHashes)) * progress.ChunkSize,
+				BytesPerSecond: progress.BytesPerSecond,
+				UpdatedAt:      progress.UpdatedAt,
+			})
+		}
+	}
+	return statuses, nil
+}
+
+// ETA returns the estimated time remaining for the download based on the most recently measured transfer rate, or zero if it is unknown.
+func (status *PartialAssetStatus) ETA() time.Duration {
+	if status.BytesPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(float64(status.Size-status.Downloaded) / status.BytesPerSecond * float64(time.Second))
+}
@@ -0,0 +1,79 @@
+package cachedirectory
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartialAssetResume(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	content := bytes.Repeat([]byte("0123456789abcdef"), partialAssetChunkSize/16*2+1)
+	size := int64(len(content))
+
+	partialAsset, err := cacheDirectory.OpenPartialAsset("a-release", "bundle.tar.gz", size)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), partialAsset.Offset())
+	// Write one and a half chunks. Only the first complete chunk should be kept.
+	_, err = partialAsset.Write(content[:partialAssetChunkSize*3/2])
+	require.NoError(t, err)
+	require.NoError(t, partialAsset.Close())
+
+	statuses, err := cacheDirectory.ListPartialAssets()
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, "bundle.tar.gz", statuses[0].Name)
+	require.Equal(t, int64(partialAssetChunkSize), statuses[0].Downloaded)
+	require.Equal(t, size, statuses[0].Size)
+
+	partialAsset, err = cacheDirectory.OpenPartialAsset("a-release", "bundle.tar.gz", size)
+	require.NoError(t, err)
+	require.Equal(t, int64(partialAssetChunkSize), partialAsset.Offset())
+	_, err = partialAsset.Write(content[partialAsset.Offset():])
+	require.NoError(t, err)
+	require.NoError(t, partialAsset.Complete())
+
+	test.RequireFileHasContent(t, string(content), cacheDirectory.AssetPath("a-release", "bundle.tar.gz"))
+	require.NoDirExists(t, cacheDirectory.partialAssetsPath("a-release"))
+	statuses, err = cacheDirectory.ListPartialAssets()
+	require.NoError(t, err)
+	require.Empty(t, statuses)
+}
+
+func TestPartialAssetCorruptionDetected(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	content := bytes.Repeat([]byte("x"), partialAssetChunkSize*2)
+	size := int64(len(content)) + 1
+
+	partialAsset, err := cacheDirectory.OpenPartialAsset("a-release", "bundle.tar.gz", size)
+	require.NoError(t, err)
+	_, err = partialAsset.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, partialAsset.Close())
+
+	// Corrupt the second chunk, so only the first should be kept.
+	file, err := os.OpenFile(cacheDirectory.partialAssetPath("a-release", "bundle.tar.gz"), os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteAt([]byte("y"), partialAssetChunkSize+10)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	partialAsset, err = cacheDirectory.OpenPartialAsset("a-release", "bundle.tar.gz", size)
+	require.NoError(t, err)
+	require.Equal(t, int64(partialAssetChunkSize), partialAsset.Offset())
+	require.NoError(t, partialAsset.Close())
+
+	// If the expected size changes then the download should start again.
+	partialAsset, err = cacheDirectory.OpenPartialAsset("a-release", "bundle.tar.gz", size+1)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), partialAsset.Offset())
+	require.Error(t, partialAsset.Complete())
+}
@@ -0,0 +1,79 @@
+package cachedirectory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const errorReadOnlyCache = "The cache is read-only, so %s cannot be written to it."
+
+// readOnlyStorage wraps the storage of a read-only cache, failing anything that would change it, so that a cache which must be left untouched is never written to by mistake.
+type readOnlyStorage struct {
+	storage
+}
+
+func (readOnlyStorage) create() error {
+	return fmt.Errorf(errorReadOnlyCache, "the cache directory")
+}
+
+func (readOnlyStorage) write(name string, reader io.Reader, size int64) error {
+	return fmt.Errorf(errorReadOnlyCache, name)
+}
+
+func (readOnlyStorage) remove(name string) error {
+	return fmt.Errorf(errorReadOnlyCache, name)
+}
+
+func (readOnlyStorage) removeAll(name string) error {
+	return fmt.Errorf(errorReadOnlyCache, name)
+}
+
+// SetReadOnly treats the cache as strictly read-only, for example when it is on a DVD or a write-blocked USB drive. The state that is otherwise recorded in the cache by `push`, `prune` and `diff`, which is the run lock and the record of where the cache has been pushed to, is kept in the work directory instead. If workDirectory is empty, a directory named after the cache in the system temporary directory is used.
+func (cacheDirectory *CacheDirectory) SetReadOnly(workDirectory string) error {
+	if workDirectory == "" {
+		locationHash := sha256.Sum256([]byte(cacheDirectory.path))
+		workDirectory = filepath.Join(os.TempDir(), "codeql-action-sync-work-"+hex.EncodeToString(locationHash[:8]))
+	}
+	workDirectory = filepath.Clean(workDirectory)
+	err := os.MkdirAll(workDirectory, 0755)
+	if err != nil {
+		return errors.Wrap(err, "Error creating work directory.")
+	}
+	cacheDirectory.storage = readOnlyStorage{cacheDirectory.storage}
+	cacheDirectory.workDirectory = workDirectory
+	return nil
+}
+
+// IsReadOnly returns true if the cache has been made read-only with SetReadOnly.
+func (cacheDirectory *CacheDirectory) IsReadOnly() bool {
+	return cacheDirectory.workDirectory != ""
+}
+
+// WorkDirectory returns the directory that the state of a read-only cache is kept in, or an empty string if the cache is not read-only.
+func (cacheDirectory *CacheDirectory) WorkDirectory() string {
+	return cacheDirectory.workDirectory
+}
+
+// stateStorage returns where the run lock and the record of push destinations are kept, which is the work directory for a read-only cache and otherwise the cache itself.
+func (cacheDirectory *CacheDirectory) stateStorage() storage {
+	if cacheDirectory.workDirectory != "" {
+		return &localStorage{path: cacheDirectory.workDirectory}
+	}
+	return cacheDirectory.storage
+}
+
+func readStorageFile(storage storage, name string) ([]byte, error) {
+	reader, err := storage.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
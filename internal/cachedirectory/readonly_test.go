@@ -0,0 +1,69 @@
+package cachedirectory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyCacheIsNotWritten(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	require.NoError(t, cacheDirectory.WriteAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", strings.NewReader("a bundle"), 8))
+
+	require.NoError(t, cacheDirectory.SetReadOnly(path.Join(temporaryDirectory, "work")))
+	require.True(t, cacheDirectory.IsReadOnly())
+	require.Equal(t, path.Join(temporaryDirectory, "work"), cacheDirectory.WorkDirectory())
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(false, aVersion))
+	size, err := cacheDirectory.AssetSize("codeql-bundle-20200101", "codeql-bundle.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, int64(8), size)
+
+	require.EqualError(t, cacheDirectory.WriteAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", strings.NewReader("a bundlf"), 8), "The cache is read-only, so releases/codeql-bundle-20200101/assets/codeql-bundle.tar.gz cannot be written to it.")
+	require.Error(t, cacheDirectory.RemoveRelease("codeql-bundle-20200101"))
+	require.Error(t, cacheDirectory.Lock())
+	content, err := ioutil.ReadFile(cacheDirectory.AssetPath("codeql-bundle-20200101", "codeql-bundle.tar.gz"))
+	require.NoError(t, err)
+	require.Equal(t, "a bundle", string(content))
+}
+
+func TestReadOnlyCacheKeepsStateInWorkDirectory(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	require.NoError(t, cacheDirectory.WriteDestinations([]byte("[]")))
+
+	workDirectory := path.Join(temporaryDirectory, "work")
+	require.NoError(t, cacheDirectory.SetReadOnly(workDirectory))
+
+	// Pushes made before the cache was read-only are still known.
+	destinations, err := cacheDirectory.ReadDestinations()
+	require.NoError(t, err)
+	require.Equal(t, "[]", string(destinations))
+	require.NoError(t, cacheDirectory.WriteDestinations([]byte(`[{"url": "https://ghes.example.com"}]`)))
+	destinations, err = cacheDirectory.ReadDestinations()
+	require.NoError(t, err)
+	require.Equal(t, `[{"url": "https://ghes.example.com"}]`, string(destinations))
+	content, err := ioutil.ReadFile(path.Join(temporaryDirectory, "cache", destinationsFileName))
+	require.NoError(t, err)
+	require.Equal(t, "[]", string(content))
+
+	runLock, err := cacheDirectory.AcquireRunLock()
+	require.NoError(t, err)
+	require.FileExists(t, path.Join(workDirectory, runLockFileName))
+	_, err = os.Stat(path.Join(temporaryDirectory, "cache", runLockFileName))
+	require.True(t, os.IsNotExist(err))
+
+	otherCacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, otherCacheDirectory.SetReadOnly(workDirectory))
+	_, err = otherCacheDirectory.AcquireRunLock()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), path.Join(workDirectory, runLockFileName))
+	require.NoError(t, runLock.Release())
+}
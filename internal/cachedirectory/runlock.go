@@ -0,0 +1,150 @@
+package cachedirectory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const runLockFileName = ".codeql-actions-sync-run-lock"
+
+// runLockPollInterval is how often a run waiting for the cache checks whether it has been released.
+var runLockPollInterval = time.Second
+
+// runLockHolder records which run of the sync tool holds the run lock, so that a user can tell whether it is still running.
+type runLockHolder struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+}
+
+// RunLock stops two runs of the sync tool using the same cache at the same time. Unlike Lock, which records that a pull has not finished, it is always released when the run ends.
+type RunLock struct {
+	cacheDirectory *CacheDirectory
+}
+
+// exclusiveCreator is implemented by cache backends which can create a file only if it does not already exist in a single operation.
+type exclusiveCreator interface {
+	createExclusive(name string, content []byte) error
+}
+
+func (localStorage *localStorage) createExclusive(name string, content []byte) error {
+	filePath, err := localStorage.resolve(name)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(content)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// SetLockTimeout sets how long AcquireRunLock waits for another run to finish with the cache before failing.
+func (cacheDirectory *CacheDirectory) SetLockTimeout(timeout time.Duration) {
+	cacheDirectory.lockTimeout = timeout
+}
+
+// createRunLockFile creates the run lock file, returning an error satisfying `os.IsExist` if another run already holds it.
+func (cacheDirectory *CacheDirectory) createRunLockFile(content []byte) error {
+	storage := cacheDirectory.stateStorage()
+	if creator, ok := storage.(exclusiveCreator); ok {
+		return creator.createExclusive(runLockFileName, content)
+	}
+	// Remote backends cannot create a file only if it does not exist, so there is a small window in which two runs starting at the same moment can both take the lock.
+	_, err := storage.size(runLockFileName)
+	if err == nil {
+		return os.ErrExist
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return storage.write(runLockFileName, bytes.NewReader(content), int64(len(content)))
+}
+
+func (cacheDirectory *CacheDirectory) runLockHolder() (runLockHolder, error) {
+	holder := runLockHolder{}
+	content, err := readStorageFile(cacheDirectory.stateStorage(), runLockFileName)
+	if err != nil {
+		return holder, err
+	}
+	err = json.Unmarshal(content, &holder)
+	return holder, err
+}
+
+func (cacheDirectory *CacheDirectory) runLockPath() string {
+	if cacheDirectory.IsReadOnly() {
+		return filepath.Join(cacheDirectory.workDirectory, runLockFileName)
+	}
+	if cacheDirectory.remote {
+		return cacheDirectory.path + "/" + runLockFileName
+	}
+	return path.Join(cacheDirectory.path, runLockFileName)
+}
+
+// AcquireRunLock takes the run lock of the cache, waiting up to the lock timeout for another run to release it.
+func (cacheDirectory *CacheDirectory) AcquireRunLock() (*RunLock, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "an unknown host"
+	}
+	content, err := json.Marshal(runLockHolder{PID: os.Getpid(), Hostname: hostname, Started: time.Now().UTC()})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error converting cache run lock to JSON.")
+	}
+	deadline := time.Now().Add(cacheDirectory.lockTimeout)
+	waiting := false
+	for {
+		err := cacheDirectory.createRunLockFile(content)
+		if err == nil {
+			return &RunLock{cacheDirectory: cacheDirectory}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "Error locking cache directory.")
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		if !waiting {
+			log.Infof("Waiting up to %s for another run of the sync tool to finish with the cache directory...", cacheDirectory.lockTimeout)
+			waiting = true
+		}
+		time.Sleep(runLockPollInterval)
+	}
+	holder, err := cacheDirectory.runLockHolder()
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The other run finished just after the lock was checked, so try again.
+			return cacheDirectory.AcquireRunLock()
+		}
+		return nil, fmt.Errorf("The cache directory is in use by another run of the sync tool. If no other run is using it, delete %s and try again.", cacheDirectory.runLockPath())
+	}
+	return nil, fmt.Errorf(
+		"The cache directory is in use by another run of the sync tool (process %d on %s, started %s). Wait for it to finish, or use `--lock-timeout` to wait for it automatically. If it is no longer running, delete %s and try again.",
+		holder.PID,
+		holder.Hostname,
+		holder.Started.Format(time.RFC3339),
+		cacheDirectory.runLockPath(),
+	)
+}
+
+// Release releases the run lock so that other runs can use the cache.
+func (runLock *RunLock) Release() error {
+	err := runLock.cacheDirectory.stateStorage().remove(runLockFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Error releasing cache directory lock.")
+	}
+	return nil
+}
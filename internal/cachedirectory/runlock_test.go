@@ -0,0 +1,54 @@
+package cachedirectory
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunLock(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+
+	runLock, err := cacheDirectory.AcquireRunLock()
+	require.NoError(t, err)
+
+	otherCacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	_, err = otherCacheDirectory.AcquireRunLock()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), fmt.Sprintf("process %d on", os.Getpid()))
+	require.Contains(t, err.Error(), path.Join(temporaryDirectory, "cache", runLockFileName))
+
+	require.NoError(t, runLock.Release())
+	otherRunLock, err := otherCacheDirectory.AcquireRunLock()
+	require.NoError(t, err)
+	require.NoError(t, otherRunLock.Release())
+}
+
+func TestRunLockWaitsForRelease(t *testing.T) {
+	runLockPollInterval = time.Millisecond
+	defer func() { runLockPollInterval = time.Second }()
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	runLock, err := cacheDirectory.AcquireRunLock()
+	require.NoError(t, err)
+
+	released := make(chan error)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		released <- runLock.Release()
+	}()
+	otherCacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	otherCacheDirectory.SetLockTimeout(time.Minute)
+	otherRunLock, err := otherCacheDirectory.AcquireRunLock()
+	require.NoError(t, err)
+	require.NoError(t, <-released)
+	require.NoError(t, otherRunLock.Release())
+}
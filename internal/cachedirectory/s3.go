@@ -0,0 +1,383 @@
+package cachedirectory
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const s3Scheme = "s3://"
+
+const errorS3MissingBucket = "The S3 cache location must include a bucket name, for example `s3://bucket/prefix`."
+const errorS3MissingCredentials = "The `AWS_ACCESS_KEY_ID` and `AWS_SECRET_ACCESS_KEY` environment variables must be set to use an S3 cache."
+
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+type s3Storage struct {
+	client          *http.Client
+	endpoint        *url.URL
+	pathStyle       bool
+	bucket          string
+	prefix          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	now             func() time.Time
+}
+
+func firstEnvironmentVariable(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// newS3Storage creates S3 storage from a location of the form `s3://bucket/prefix`.
+// Credentials and region are read from the standard AWS environment variables. Setting `AWS_ENDPOINT_URL` allows S3-compatible services to be used, in which case path-style addressing is used.
+func newS3Storage(location string) (*s3Storage, error) {
+	bucketAndPrefix := strings.TrimPrefix(location, s3Scheme)
+	bucket := bucketAndPrefix
+	prefix := ""
+	if index := strings.Index(bucketAndPrefix, "/"); index != -1 {
+		bucket = bucketAndPrefix[:index]
+		prefix = strings.Trim(bucketAndPrefix[index+1:], "/")
+	}
+	if bucket == "" {
+		return nil, errors.New(errorS3MissingBucket)
+	}
+
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New(errorS3MissingCredentials)
+	}
+	region := firstEnvironmentVariable("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	pathStyle := true
+	endpointString := firstEnvironmentVariable("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	if endpointString == "" {
+		pathStyle = false
+		endpointString = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	endpoint, err := url.Parse(strings.TrimRight(endpointString, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing S3 endpoint URL.")
+	}
+
+	return &s3Storage{
+		client:          http.DefaultClient,
+		endpoint:        endpoint,
+		pathStyle:       pathStyle,
+		bucket:          bucket,
+		prefix:          prefix,
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		now:             time.Now,
+	}, nil
+}
+
+func (s3Storage *s3Storage) key(name string) string {
+	if s3Storage.prefix == "" {
+		return name
+	}
+	if name == "" {
+		return s3Storage.prefix
+	}
+	return s3Storage.prefix + "/" + name
+}
+
+func (s3Storage *s3Storage) objectURL(key string, query url.Values) *url.URL {
+	objectURL := *s3Storage.endpoint
+	escapedKey := uriEscapePath(key)
+	if s3Storage.pathStyle {
+		objectURL.Path = "/" + s3Storage.bucket + "/" + key
+		objectURL.RawPath = "/" + uriEscapePath(s3Storage.bucket) + "/" + escapedKey
+	} else {
+		objectURL.Host = s3Storage.bucket + "." + objectURL.Host
+		objectURL.Path = "/" + key
+		objectURL.RawPath = "/" + escapedKey
+	}
+	if query != nil {
+		objectURL.RawQuery = s3CanonicalQuery(query)
+	}
+	return &objectURL
+}
+
+func (s3Storage *s3Storage) do(method string, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	request, err := http.NewRequest(method, s3Storage.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error constructing S3 request.")
+	}
+	payloadHash := s3EmptyPayloadHash
+	if body != nil {
+		request.ContentLength = size
+		payloadHash = s3UnsignedPayload
+	}
+	s3Storage.sign(request, payloadHash)
+	response, err := s3Storage.client.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error sending S3 request.")
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, &os.PathError{Op: method, Path: s3Scheme + s3Storage.bucket + "/" + key, Err: os.ErrNotExist}
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		responseBody, _ := ioutil.ReadAll(response.Body)
+		return nil, errors.Errorf("Status code %d from S3 for %s %s: %s", response.StatusCode, method, key, strings.TrimSpace(string(responseBody)))
+	}
+	return response, nil
+}
+
+func uriEscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for index, segment := range segments {
+		segments[index] = uriEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func uriEscape(value string) string {
+	var builder strings.Builder
+	for _, character := range []byte(value) {
+		if (character >= 'A' && character <= 'Z') || (character >= 'a' && character <= 'z') || (character >= '0' && character <= '9') || character == '-' || character == '_' || character == '.' || character == '~' {
+			builder.WriteByte(character)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", character)
+		}
+	}
+	return builder.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parameters := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parameters = append(parameters, uriEscape(key)+"="+uriEscape(value))
+		}
+	}
+	return strings.Join(parameters, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds an AWS Signature Version 4 `Authorization` header to the request.
+func (s3Storage *s3Storage) sign(request *http.Request, payloadHash string) {
+	now := s3Storage.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+	if s3Storage.sessionToken != "" {
+		request.Header.Set("x-amz-security-token", s3Storage.sessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, "x-amz-") || lowerName == "range" || lowerName == "content-type" || lowerName == "content-md5" {
+			headers[lowerName] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	headerNames := []string{}
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		s3CanonicalQuery(request.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + s3Storage.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s3Storage.secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s3Storage.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3Storage.accessKeyID, scope, signedHeaders, signature))
+}
+
+func (s3Storage *s3Storage) checkParent() error {
+	return nil
+}
+
+func (s3Storage *s3Storage) create() error {
+	return nil
+}
+
+func (s3Storage *s3Storage) isEmpty() (bool, error) {
+	entries, err := s3Storage.list("")
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+func (s3Storage *s3Storage) open(name string) (io.ReadCloser, error) {
+	response, err := s3Storage.do(http.MethodGet, s3Storage.key(name), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (s3Storage *s3Storage) write(name string, reader io.Reader, size int64) error {
+	if size == 0 {
+		// A zero-length body with a non-nil reader would be sent chunked, which S3 does not accept.
+		reader = http.NoBody
+	}
+	response, err := s3Storage.do(http.MethodPut, s3Storage.key(name), nil, reader, size)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (s3Storage *s3Storage) size(name string) (int64, error) {
+	response, err := s3Storage.do(http.MethodHead, s3Storage.key(name), nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.ContentLength, nil
+}
+
+type s3ListBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key  string
+		Size int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+func (s3Storage *s3Storage) listKeys(prefix string, delimiter string) (*s3ListBucketResult, error) {
+	result := &s3ListBucketResult{}
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": []string{"2"}, "prefix": []string{prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		response, err := s3Storage.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		page := s3ListBucketResult{}
+		err = xml.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error decoding S3 object listing.")
+		}
+		result.Contents = append(result.Contents, page.Contents...)
+		result.CommonPrefixes = append(result.CommonPrefixes, page.CommonPrefixes...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return result, nil
+		}
+		continuationToken = page.NextContinuationToken
+	}
+}
+
+func (s3Storage *s3Storage) list(directory string) ([]storageEntry, error) {
+	prefix := s3Storage.key(directory)
+	if prefix != "" {
+		prefix += "/"
+	}
+	result, err := s3Storage.listKeys(prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	entries := []storageEntry{}
+	for _, commonPrefix := range result.CommonPrefixes {
+		entries = append(entries, storageEntry{name: strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/"), isDir: true})
+	}
+	for _, content := range result.Contents {
+		entries = append(entries, storageEntry{name: strings.TrimPrefix(content.Key, prefix), size: content.Size})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
+
+func (s3Storage *s3Storage) remove(name string) error {
+	response, err := s3Storage.do(http.MethodDelete, s3Storage.key(name), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (s3Storage *s3Storage) removeAll(name string) error {
+	prefix := s3Storage.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	result, err := s3Storage.listKeys(prefix, "")
+	if err != nil {
+		return err
+	}
+	for _, content := range result.Contents {
+		response, err := s3Storage.do(http.MethodDelete, content.Key, nil, nil, 0)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package cachedirectory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const segmentedFileSuffix = ".segments"
+
+type assetSegment struct {
+	offset int64
+	length int64
+	sha256 string
+}
+
+// SegmentedAsset is an asset being downloaded in several segments at the same time, each written at its own offset in a staging file. The checksum of each segment is recorded as it is written and checked again once the asset has been reassembled.
+type SegmentedAsset struct {
+	cacheDirectory *CacheDirectory
+	release        string
+	name           string
+	size           int64
+	file           *os.File
+	lock           sync.Mutex
+	segments       []assetSegment
+}
+
+// CreateSegmentedAsset starts a segmented download of an asset. Segmented downloads cannot be resumed, so any data from an earlier download of the asset is discarded.
+func (cacheDirectory *CacheDirectory) CreateSegmentedAsset(release string, assetName string, size int64) (*SegmentedAsset, error) {
+	if !cacheDirectory.SupportsPartialAssets() {
+		return nil, errors.New("Segmented downloads are not supported for remote caches.")
+	}
+	err := os.MkdirAll(cacheDirectory.partialAssetsPath(release), 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating partial assets directory.")
+	}
+	partialPath := cacheDirectory.partialAssetPath(release, assetName)
+	for _, stalePath := range []string{partialPath, partialPath + progressFileSuffix} {
+		err = os.Remove(stalePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "Error removing earlier partial asset.")
+		}
+	}
+	file, err := os.OpenFile(partialPath+segmentedFileSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating segmented asset.")
+	}
+	err = file.Truncate(size)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "Error allocating segmented asset.")
+	}
+	return &SegmentedAsset{
+		cacheDirectory: cacheDirectory,
+		release:        release,
+		name:           assetName,
+		size:           size,
+		file:           file,
+	}, nil
+}
+
+// WriteSegment writes length bytes from the reader at the given offset. It is safe to write different segments at the same time.
+func (segmentedAsset *SegmentedAsset) WriteSegment(offset int64, length int64, reader io.Reader) error {
+	if offset < 0 || length < 0 || offset+length > segmentedAsset.size {
+		return errors.Errorf("The segment of %d bytes at offset %d is outside asset %s.", length, offset, segmentedAsset.name)
+	}
+	hash := sha256.New()
+	buffer := make([]byte, 32*1024)
+	position := offset
+	for position < offset+length {
+		toRead := buffer
+		if remaining := offset + length - position; int64(len(toRead)) > remaining {
+			toRead = toRead[:remaining]
+		}
+		count, err := reader.Read(toRead)
+		if count > 0 {
+			_, writeErr := segmentedAsset.file.WriteAt(toRead[:count], position)
+			if writeErr != nil {
+				return errors.Wrap(writeErr, "Error writing segmented asset.")
+			}
+			hash.Write(toRead[:count])
+			position += int64(count)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if position != offset+length {
+		return errors.Errorf("Downloaded %d bytes of the segment at offset %d of asset %s but expected %d.", position-offset, offset, segmentedAsset.name, length)
+	}
+	segmentedAsset.lock.Lock()
+	defer segmentedAsset.lock.Unlock()
+	segmentedAsset.segments = append(segmentedAsset.segments, assetSegment{offset: offset, length: length, sha256: hex.EncodeToString(hash.Sum(nil))})
+	return nil
+}
+
+// verify checks that the segments cover the whole asset and that the reassembled data matches the checksum of each segment as it was downloaded.
+func (segmentedAsset *SegmentedAsset) verify() error {
+	segments := append([]assetSegment{}, segmentedAsset.segments...)
+	sort.Slice(segments, func(i, j int) bool { return segments[i].offset < segments[j].offset })
+	expectedOffset := int64(0)
+	for _, segment := range segments {
+		if segment.offset != expectedOffset {
+			return errors.Errorf("The segments of asset %s do not cover bytes %d to %d.", segmentedAsset.name, expectedOffset, segment.offset)
+		}
+		hash := sha256.New()
+		_, err := io.Copy(hash, io.NewSectionReader(segmentedAsset.file, segment.offset, segment.length))
+		if err != nil {
+			return errors.Wrap(err, "Error reading segmented asset.")
+		}
+		if hex.EncodeToString(hash.Sum(nil)) != segment.sha256 {
+			return errors.Errorf("The segment at offset %d of asset %s does not match the checksum of the data downloaded.", segment.offset, segmentedAsset.name)
+		}
+		expectedOffset += segment.length
+	}
+	if expectedOffset != segmentedAsset.size {
+		return errors.Errorf("Downloaded %d bytes of asset %s but expected %d.", expectedOffset, segmentedAsset.name, segmentedAsset.size)
+	}
+	return nil
+}
+
+// Close stops the download and discards the data downloaded so far.
+func (segmentedAsset *SegmentedAsset) Close() error {
+	segmentedAsset.file.Close()
+	err := os.Remove(segmentedAsset.file.Name())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Error removing segmented asset.")
+	}
+	return nil
+}
+
+// Complete verifies the reassembled asset and moves it into the cache.
+func (segmentedAsset *SegmentedAsset) Complete() error {
+	err := segmentedAsset.verify()
+	if err != nil {
+		return err
+	}
+	err = segmentedAsset.file.Sync()
+	if err != nil {
+		return errors.Wrap(err, "Error flushing segmented asset.")
+	}
+	err = segmentedAsset.file.Close()
+	if err != nil {
+		return errors.Wrap(err, "Error closing segmented asset.")
+	}
+	cacheDirectory := segmentedAsset.cacheDirectory
+	err = os.MkdirAll(cacheDirectory.AssetsPath(segmentedAsset.release), 0755)
+	if err != nil {
+		return errors.Wrap(err, "Error creating assets directory.")
+	}
+	err = os.Rename(segmentedAsset.file.Name(), cacheDirectory.AssetPath(segmentedAsset.release, segmentedAsset.name))
+	if err != nil {
+		return errors.Wrap(err, "Error moving downloaded asset into cache.")
+	}
+	// This only succeeds once there are no other downloads in progress for the release.
+	os.Remove(cacheDirectory.partialAssetsPath(segmentedAsset.release))
+	return nil
+}
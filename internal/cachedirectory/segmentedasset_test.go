@@ -0,0 +1,49 @@
+package cachedirectory
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentedAsset(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	content := []byte("0123456789abcdef")
+
+	// An earlier download over a single connection is discarded.
+	partialAsset, err := cacheDirectory.OpenPartialAsset("a-release", "bundle.tar.gz", int64(len(content)))
+	require.NoError(t, err)
+	require.NoError(t, partialAsset.Close())
+
+	segmentedAsset, err := cacheDirectory.CreateSegmentedAsset("a-release", "bundle.tar.gz", int64(len(content)))
+	require.NoError(t, err)
+	// Segments can be written in any order.
+	require.NoError(t, segmentedAsset.WriteSegment(10, 6, bytes.NewReader(content[10:])))
+	require.NoError(t, segmentedAsset.WriteSegment(0, 10, bytes.NewReader(content[:10])))
+	require.NoError(t, segmentedAsset.Complete())
+	require.NoError(t, segmentedAsset.Close())
+
+	test.RequireFileHasContent(t, string(content), cacheDirectory.AssetPath("a-release", "bundle.tar.gz"))
+	require.NoDirExists(t, cacheDirectory.partialAssetsPath("a-release"))
+}
+
+func TestSegmentedAssetIncomplete(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+
+	segmentedAsset, err := cacheDirectory.CreateSegmentedAsset("a-release", "bundle.tar.gz", 16)
+	require.NoError(t, err)
+	require.EqualError(t, segmentedAsset.WriteSegment(8, 8, strings.NewReader("89ab")), "Downloaded 4 bytes of the segment at offset 8 of asset bundle.tar.gz but expected 8.")
+	require.NoError(t, segmentedAsset.WriteSegment(0, 8, strings.NewReader("01234567")))
+	require.EqualError(t, segmentedAsset.Complete(), "Downloaded 8 bytes of asset bundle.tar.gz but expected 16.")
+	require.NoError(t, segmentedAsset.Close())
+	require.NoFileExists(t, cacheDirectory.AssetPath("a-release", "bundle.tar.gz"))
+	require.NoFileExists(t, cacheDirectory.partialAssetPath("a-release", "bundle.tar.gz")+segmentedFileSuffix)
+}
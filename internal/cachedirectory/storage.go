@@ -0,0 +1,138 @@
+package cachedirectory
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const errorOutsideCache = "The name %s refers to a file outside of the cache directory."
+
+// storageEntry describes a single child of a directory within cache storage.
+type storageEntry struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+// storage is the interface implemented by each cache backend. Names are always slash-separated and relative to the root of the cache.
+// Implementations should return an error satisfying `os.IsNotExist` when a name does not exist.
+type storage interface {
+	checkParent() error
+	create() error
+	isEmpty() (bool, error)
+	open(name string) (io.ReadCloser, error)
+	write(name string, reader io.Reader, size int64) error
+	size(name string) (int64, error)
+	list(directory string) ([]storageEntry, error)
+	remove(name string) error
+	removeAll(name string) error
+}
+
+type localStorage struct {
+	path string
+}
+
+// resolve returns the path on the local filesystem of a name within the cache, or an error if the name refers to somewhere outside of the cache, such as a release asset named `../../x`.
+func (localStorage *localStorage) resolve(name string) (string, error) {
+	resolved := filepath.Join(localStorage.path, filepath.FromSlash(name))
+	relative, err := filepath.Rel(localStorage.path, resolved)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf(errorOutsideCache, name)
+	}
+	return resolved, nil
+}
+
+func (localStorage *localStorage) checkParent() error {
+	_, err := os.Stat(filepath.Dir(localStorage.path))
+	return err
+}
+
+func (localStorage *localStorage) create() error {
+	return os.Mkdir(localStorage.path, 0755)
+}
+
+func (localStorage *localStorage) isEmpty() (bool, error) {
+	return isEmptyOrNonExistentDirectory(localStorage.path)
+}
+
+func (localStorage *localStorage) open(name string) (io.ReadCloser, error) {
+	filePath, err := localStorage.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filePath)
+}
+
+func (localStorage *localStorage) write(name string, reader io.Reader, size int64) error {
+	filePath, err := localStorage.resolve(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+	// The file may be a hard link to content shared with other assets, so it is replaced rather than overwritten.
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, reader)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (localStorage *localStorage) size(name string) (int64, error) {
+	filePath, err := localStorage.resolve(name)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func (localStorage *localStorage) list(directory string) ([]storageEntry, error) {
+	directoryPath, err := localStorage.resolve(directory)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := ioutil.ReadDir(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+	entries := []storageEntry{}
+	for _, stat := range stats {
+		entries = append(entries, storageEntry{name: stat.Name(), size: stat.Size(), isDir: stat.IsDir()})
+	}
+	return entries, nil
+}
+
+func (localStorage *localStorage) remove(name string) error {
+	filePath, err := localStorage.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+func (localStorage *localStorage) removeAll(name string) error {
+	filePath, err := localStorage.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filePath)
+}
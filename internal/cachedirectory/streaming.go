@@ -0,0 +1,289 @@
+package cachedirectory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	usererrors "errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const errorStreamingRemoteCache = "Release assets can only be streamed with a local cache directory, as the Git repository is still kept in it."
+const errorNoAssetSource = "The asset %s of release %s was streamed rather than stored in the cache, so it can only be pushed by `sync --stream-assets`."
+const errorStreamedAssetChanged = "The asset %s of release %s changed at the source while it was being streamed. Run the sync again to pick up the new version."
+
+// streamChunkSize and streamChunks bound how much of an asset being streamed is held in memory, so that the download can run ahead of a slow upload without buffering a whole bundle.
+const streamChunkSize = 1024 * 1024
+const streamChunks = 8
+
+// maxStreamedAssetSize is the most a record of a streamed asset can take up, so that an asset stored in full is never read to find out whether it is one.
+const maxStreamedAssetSize = 1024
+
+// AssetSource opens an asset of a release at the source, for a cache whose assets are streamed rather than stored.
+type AssetSource func(release string, assetName string) (io.ReadCloser, error)
+
+// streamedAsset is stored in place of the content of a streamed asset.
+type streamedAsset struct {
+	Streamed bool   `json:"streamed"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// assetSourceHolder is shared by every copy of a streaming cache, so that the source set by `pull` is there for `push`.
+type assetSourceHolder struct {
+	lock sync.Mutex
+	open AssetSource
+}
+
+// digester is implemented by cache backends which know the checksum of an asset without reading it.
+type digester interface {
+	digest(name string) (string, bool, error)
+}
+
+// streamingStorage wraps the storage of a cache whose release assets are streamed from the source when they are read, rather than stored. Writing an asset only records its size and checksum, and everything else is stored as usual.
+type streamingStorage struct {
+	storage
+	source *assetSourceHolder
+}
+
+// SetStreaming stops release assets being stored in the cache. Each asset is still read in full when it is pulled, to record its checksum, and is then downloaded from the source again when it is pushed, passing through a bounded buffer on its way to the destination. Assets stored in full by an earlier pull are still read from the cache.
+func (cacheDirectory *CacheDirectory) SetStreaming() error {
+	if cacheDirectory.remote {
+		return usererrors.New(errorStreamingRemoteCache)
+	}
+	cacheDirectory.storage = streamingStorage{storage: cacheDirectory.storage, source: &assetSourceHolder{}}
+	return nil
+}
+
+// IsStreaming returns true if release assets are streamed rather than stored, as set with SetStreaming.
+func (cacheDirectory *CacheDirectory) IsStreaming() bool {
+	_, ok := cacheDirectory.storage.(streamingStorage)
+	return ok
+}
+
+// SetAssetSource sets where the assets of a streaming cache are read from. It does nothing for other caches.
+func (cacheDirectory *CacheDirectory) SetAssetSource(open AssetSource) {
+	streaming, ok := cacheDirectory.storage.(streamingStorage)
+	if !ok {
+		return
+	}
+	streaming.source.lock.Lock()
+	defer streaming.source.lock.Unlock()
+	streaming.source.open = open
+}
+
+// parseAssetKey returns the release and asset name of a key made by assetKey, and whether it is one.
+func parseAssetKey(name string) (string, string, bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "releases" || parts[2] != "assets" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+func isAssetsKey(directory string) bool {
+	parts := strings.Split(directory, "/")
+	return len(parts) == 3 && parts[0] == "releases" && parts[2] == "assets"
+}
+
+// readStreamedAsset returns the record of a streamed asset, or nil if the asset is stored in full.
+func (streaming streamingStorage) readStreamedAsset(name string, size int64) (*streamedAsset, error) {
+	if size > maxStreamedAssetSize {
+		return nil, nil
+	}
+	content, err := readStorageFile(streaming.storage, name)
+	if err != nil {
+		return nil, err
+	}
+	record := streamedAsset{}
+	if json.Unmarshal(content, &record) != nil || !record.Streamed {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (streaming streamingStorage) lookup(name string) (*streamedAsset, error) {
+	size, err := streaming.storage.size(name)
+	if err != nil {
+		return nil, err
+	}
+	return streaming.readStreamedAsset(name, size)
+}
+
+func (streaming streamingStorage) write(name string, reader io.Reader, size int64) error {
+	if _, _, ok := parseAssetKey(name); !ok {
+		return streaming.storage.write(name, reader, size)
+	}
+	hash := sha256.New()
+	written, err := io.Copy(hash, reader)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return errors.Errorf("Expected %d bytes of the asset but read %d.", size, written)
+	}
+	record, err := json.Marshal(streamedAsset{Streamed: true, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))})
+	if err != nil {
+		return err
+	}
+	return streaming.storage.write(name, bytes.NewReader(record), int64(len(record)))
+}
+
+func (streaming streamingStorage) size(name string) (int64, error) {
+	size, err := streaming.storage.size(name)
+	if err != nil {
+		return 0, err
+	}
+	if _, _, ok := parseAssetKey(name); !ok {
+		return size, nil
+	}
+	record, err := streaming.readStreamedAsset(name, size)
+	if err != nil || record == nil {
+		return size, err
+	}
+	return record.Size, nil
+}
+
+func (streaming streamingStorage) list(directory string) ([]storageEntry, error) {
+	entries, err := streaming.storage.list(directory)
+	if err != nil || !isAssetsKey(directory) {
+		return entries, err
+	}
+	for index, entry := range entries {
+		if entry.isDir {
+			continue
+		}
+		record, err := streaming.readStreamedAsset(directory+"/"+entry.name, entry.size)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			entries[index].size = record.Size
+		}
+	}
+	return entries, nil
+}
+
+func (streaming streamingStorage) open(name string) (io.ReadCloser, error) {
+	release, assetName, ok := parseAssetKey(name)
+	if !ok {
+		return streaming.storage.open(name)
+	}
+	record, err := streaming.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return streaming.storage.open(name)
+	}
+	streaming.source.lock.Lock()
+	open := streaming.source.open
+	streaming.source.lock.Unlock()
+	if open == nil {
+		return nil, fmt.Errorf(errorNoAssetSource, assetName, release)
+	}
+	reader, err := open(release, assetName)
+	if err != nil {
+		return nil, err
+	}
+	return newBoundedPipe(reader, streamChunkSize, streamChunks, func(size int64, digest string) error {
+		if size != record.Size || digest != record.SHA256 {
+			return fmt.Errorf(errorStreamedAssetChanged, assetName, release)
+		}
+		return nil
+	}), nil
+}
+
+func (streaming streamingStorage) digest(name string) (string, bool, error) {
+	record, err := streaming.lookup(name)
+	if err != nil || record == nil {
+		return "", false, err
+	}
+	return record.SHA256, true, nil
+}
+
+// boundedPipe reads from its source in the background, a chunk at a time, holding at most a fixed number of chunks that have not been read yet. Once the source is finished, check is given the size and checksum of everything read.
+type boundedPipe struct {
+	source    io.ReadCloser
+	chunks    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	current   []byte
+	hash      hash.Hash
+	read      int64
+	check     func(size int64, digest string) error
+}
+
+func newBoundedPipe(source io.ReadCloser, chunkSize int, chunks int, check func(size int64, digest string) error) *boundedPipe {
+	pipe := &boundedPipe{
+		source: source,
+		chunks: make(chan []byte, chunks),
+		done:   make(chan struct{}),
+		hash:   sha256.New(),
+		check:  check,
+	}
+	go pipe.fill(chunkSize)
+	return pipe
+}
+
+func (pipe *boundedPipe) fill(chunkSize int) {
+	// The error is set before the channel is closed, so it is seen by Read once the last chunk has been read.
+	defer close(pipe.chunks)
+	for {
+		chunk := make([]byte, chunkSize)
+		count, err := io.ReadFull(pipe.source, chunk)
+		if count > 0 {
+			select {
+			case pipe.chunks <- chunk[:count]:
+			case <-pipe.done:
+				return
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			pipe.err = err
+			return
+		}
+	}
+}
+
+func (pipe *boundedPipe) Read(buffer []byte) (int, error) {
+	for len(pipe.current) == 0 {
+		chunk, ok := <-pipe.chunks
+		if !ok {
+			if pipe.err != nil {
+				return 0, pipe.err
+			}
+			err := pipe.check(pipe.read, hex.EncodeToString(pipe.hash.Sum(nil)))
+			if err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		pipe.current = chunk
+	}
+	count := copy(buffer, pipe.current)
+	pipe.current = pipe.current[count:]
+	pipe.hash.Write(buffer[:count])
+	pipe.read += int64(count)
+	return count, nil
+}
+
+func (pipe *boundedPipe) Close() error {
+	var err error
+	pipe.closeOnce.Do(func() {
+		close(pipe.done)
+		err = pipe.source.Close()
+	})
+	return err
+}
@@ -0,0 +1,110 @@
+package cachedirectory
+
+import (
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamingCacheDoesNotStoreAssets(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	// An asset stored in full before the cache was streamed is still read from the cache.
+	require.NoError(t, cacheDirectory.WriteAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", strings.NewReader("a bundle"), 8))
+	require.NoError(t, cacheDirectory.SetStreaming())
+	require.True(t, cacheDirectory.IsStreaming())
+	require.False(t, cacheDirectory.SupportsPartialAssets())
+	content := strings.Repeat("a large bundle", 1000)
+	require.NoError(t, cacheDirectory.WriteAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz", strings.NewReader(content), int64(len(content))))
+
+	stored, err := ioutil.ReadFile(cacheDirectory.AssetPath("codeql-bundle-20200630", "codeql-bundle.tar.gz"))
+	require.NoError(t, err)
+	require.Less(t, len(stored), maxStreamedAssetSize)
+	assets, err := cacheDirectory.ListAssets("codeql-bundle-20200630")
+	require.NoError(t, err)
+	require.Equal(t, []Asset{{Name: "codeql-bundle.tar.gz", Size: int64(len(content))}}, assets)
+	size, err := cacheDirectory.AssetSize("codeql-bundle-20200101", "codeql-bundle.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, int64(8), size)
+	digest, err := cacheDirectory.AssetDigest("codeql-bundle-20200630", "codeql-bundle.tar.gz")
+	require.NoError(t, err)
+	expectedDigest, err := Digest(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, expectedDigest, digest)
+
+	_, err = cacheDirectory.OpenAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz")
+	require.EqualError(t, err, "The asset codeql-bundle.tar.gz of release codeql-bundle-20200630 was streamed rather than stored in the cache, so it can only be pushed by `sync --stream-assets`.")
+	reader, err := cacheDirectory.OpenAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, "a bundle", string(read))
+}
+
+func TestStreamingCacheReadsAssetsFromSource(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	require.NoError(t, cacheDirectory.SetStreaming())
+	content := strings.Repeat("a large bundle", 1000)
+	require.NoError(t, cacheDirectory.WriteAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz", strings.NewReader(content), int64(len(content))))
+
+	// The source is shared with copies of the cache, such as the one given to `push`.
+	pushCacheDirectory := cacheDirectory
+	source := content
+	cacheDirectory.SetAssetSource(func(release string, assetName string) (io.ReadCloser, error) {
+		require.Equal(t, "codeql-bundle-20200630", release)
+		require.Equal(t, "codeql-bundle.tar.gz", assetName)
+		return ioutil.NopCloser(strings.NewReader(source)), nil
+	})
+	reader, err := pushCacheDirectory.OpenAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, content, string(read))
+
+	// An asset replaced at the source since it was pulled is not passed off as the one that was.
+	source = strings.Repeat("another bundle", 1000)
+	reader, err = pushCacheDirectory.OpenAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	require.EqualError(t, err, "The asset codeql-bundle.tar.gz of release codeql-bundle-20200630 changed at the source while it was being streamed. Run the sync again to pick up the new version.")
+	require.NoError(t, reader.Close())
+}
+
+func TestBoundedPipe(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	pipe := newBoundedPipe(ioutil.NopCloser(strings.NewReader(content)), 7, 2, func(size int64, digest string) error {
+		require.Equal(t, int64(len(content)), size)
+		return nil
+	})
+	read, err := ioutil.ReadAll(pipe)
+	require.NoError(t, err)
+	require.Equal(t, content, string(read))
+	require.NoError(t, pipe.Close())
+
+	// Closing the pipe part of the way through stops the background read.
+	source, writer := io.Pipe()
+	pipe = newBoundedPipe(source, 7, 2, nil)
+	go writer.Write([]byte(content))
+	buffer := make([]byte, 3)
+	_, err = io.ReadFull(pipe, buffer)
+	require.NoError(t, err)
+	require.Equal(t, "012", string(buffer))
+	require.NoError(t, pipe.Close())
+	_, err = writer.Write([]byte("more"))
+	require.Equal(t, io.ErrClosedPipe, err)
+}
+
+func TestStreamingRemoteCache(t *testing.T) {
+	cacheDirectory := newRemoteCacheDirectory("s3://bucket/prefix", &localStorage{path: test.CreateTemporaryDirectory(t)})
+	require.EqualError(t, cacheDirectory.SetStreaming(), errorStreamingRemoteCache)
+}
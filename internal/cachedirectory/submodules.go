@@ -0,0 +1,43 @@
+package cachedirectory
+
+import (
+	"os"
+	"path"
+)
+
+const submodulesFileName = ".codeql-actions-sync-submodules.json"
+
+// ReadSubmodules returns the record of mirrored submodules written by WriteSubmodules, or nil if there is none.
+func (cacheDirectory *CacheDirectory) ReadSubmodules() ([]byte, error) {
+	submodules, err := cacheDirectory.readFile(submodulesFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return submodules, err
+}
+
+func (cacheDirectory *CacheDirectory) WriteSubmodules(submodules []byte) error {
+	return cacheDirectory.writeFile(submodulesFileName, submodules)
+}
+
+// RemoveSubmodules removes the record of mirrored submodules and the mirrors themselves.
+func (cacheDirectory *CacheDirectory) RemoveSubmodules() error {
+	err := cacheDirectory.storage.remove(submodulesFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if cacheDirectory.IsRemote() {
+		return nil
+	}
+	return os.RemoveAll(cacheDirectory.SubmodulesPath())
+}
+
+// SubmodulesPath is where the Git repositories of mirrored submodules are kept. Like the Git repository of the cache itself they need a real filesystem, so they are only kept for caches stored on the local filesystem.
+func (cacheDirectory *CacheDirectory) SubmodulesPath() string {
+	return path.Join(cacheDirectory.path, "submodules")
+}
+
+// SubmodulePath is where the Git repository of a mirrored submodule is kept, by a key that identifies it.
+func (cacheDirectory *CacheDirectory) SubmodulePath(key string) string {
+	return path.Join(cacheDirectory.SubmodulesPath(), key+".git")
+}
@@ -0,0 +1,33 @@
+package cachedirectory
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmodules(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, aVersion))
+	submodules, err := cacheDirectory.ReadSubmodules()
+	require.NoError(t, err)
+	require.Nil(t, submodules)
+
+	require.NoError(t, cacheDirectory.WriteSubmodules([]byte("{}")))
+	submodules, err = cacheDirectory.ReadSubmodules()
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(submodules))
+	require.Equal(t, path.Join(temporaryDirectory, "cache", "submodules", "key.git"), cacheDirectory.SubmodulePath("key"))
+	require.NoError(t, os.MkdirAll(cacheDirectory.SubmodulePath("key"), 0755))
+
+	require.NoError(t, cacheDirectory.RemoveSubmodules())
+	require.NoError(t, cacheDirectory.RemoveSubmodules())
+	submodules, err = cacheDirectory.ReadSubmodules()
+	require.NoError(t, err)
+	require.Nil(t, submodules)
+	require.NoDirExists(t, cacheDirectory.SubmodulesPath())
+}
@@ -0,0 +1,89 @@
+package concurrency
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Limits bounds how many operations of each kind may run at the same time.
+type Limits struct {
+	Downloads   int
+	Uploads     int
+	APIRequests int
+	// DownloadSegments is how many connections a large asset is downloaded over at the same time. Values below two download each asset over a single connection.
+	DownloadSegments int
+}
+
+// ForEach calls work for every index from 0 to count-1, running at most limit calls at the same time. Once a call fails no further work is started, and the first error is returned after all running calls have finished.
+func ForEach(count int, limit int, work func(index int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > count {
+		limit = count
+	}
+	indexes := make(chan int)
+	var lock sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return firstErr != nil
+	}
+	var workers sync.WaitGroup
+	for worker := 0; worker < limit; worker++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				err := work(index)
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for index := 0; index < count && !failed(); index++ {
+		indexes <- index
+	}
+	close(indexes)
+	workers.Wait()
+	return firstErr
+}
+
+// Transport is an HTTP transport which allows only a limited number of requests to be in progress at the same time. A request stops counting towards the limit once its response headers have been received.
+type Transport struct {
+	Base  http.RoundTripper
+	slots chan struct{}
+}
+
+// NewTransport creates a transport allowing at most limit simultaneous requests. If base is nil the default transport is used.
+func NewTransport(base http.RoundTripper, limit int) *Transport {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Transport{Base: base, slots: make(chan struct{}, limit)}
+}
+
+func (transport *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	select {
+	case transport.slots <- struct{}{}:
+	case <-request.Context().Done():
+		return nil, request.Context().Err()
+	}
+	defer func() { <-transport.slots }()
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(request)
+}
+
+// NewClient creates an HTTP client allowing at most limit simultaneous requests.
+func NewClient(limit int) *http.Client {
+	return &http.Client{Transport: NewTransport(nil, limit)}
+}
@@ -0,0 +1,76 @@
+package concurrency
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func recordMaximum(maximum *int32, current int32) {
+	for {
+		previous := atomic.LoadInt32(maximum)
+		if current <= previous || atomic.CompareAndSwapInt32(maximum, previous, current) {
+			return
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	var running, maximum int32
+	done := make([]bool, 10)
+	err := ForEach(len(done), 3, func(index int) error {
+		recordMaximum(&maximum, atomic.AddInt32(&running, 1))
+		time.Sleep(10 * time.Millisecond)
+		done[index] = true
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), maximum)
+	for _, itemDone := range done {
+		require.True(t, itemDone)
+	}
+}
+
+func TestForEachStopsOnError(t *testing.T) {
+	var calls int32
+	err := ForEach(100, 1, func(index int) error {
+		atomic.AddInt32(&calls, 1)
+		if index == 2 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	require.EqualError(t, err, "failed")
+	require.Equal(t, int32(3), calls)
+	require.NoError(t, ForEach(0, 4, func(index int) error { return nil }))
+}
+
+func TestTransport(t *testing.T) {
+	testServer, testURL := test.GetTestHTTPServer(t)
+	var running, maximum int32
+	testServer.HandleFunc("/slow", func(response http.ResponseWriter, request *http.Request) {
+		recordMaximum(&maximum, atomic.AddInt32(&running, 1))
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	})
+	client := NewClient(2)
+	var requests sync.WaitGroup
+	for index := 0; index < 8; index++ {
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			response, err := client.Get(testURL + "/slow")
+			require.NoError(t, err)
+			response.Body.Close()
+		}()
+	}
+	requests.Wait()
+	require.LessOrEqual(t, maximum, int32(2))
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package doctor
+
+import "syscall"
+
+// freeSpace returns the number of bytes available to the sync tool on the filesystem of a path.
+func freeSpace(path string) (uint64, error) {
+	stat := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package doctor
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the number of bytes available to the sync tool on the volume of a path.
+func freeSpace(path string) (uint64, error) {
+	pathPointer, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	err = windows.GetDiskFreeSpaceEx(pathPointer, &available, &total, &free)
+	if err != nil {
+		return 0, err
+	}
+	return available, nil
+}
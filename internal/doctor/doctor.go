@@ -0,0 +1,333 @@
+package doctor
+
+import (
+	"context"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/httptransport"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const errorProblemsFound = "The doctor found problems that will stop the sync tool from working. See the list above, most important first."
+
+// MinimumFreeSpace is the free space below which the disk of the cache is reported as nearly full. A CodeQL bundle for every platform is over a gigabyte, and a pull downloads a new one before removing the old.
+const MinimumFreeSpace = 5 * 1024 * 1024 * 1024
+
+// lowRateLimit is the number of API requests left below which a pull is likely to be rate limited before it finishes.
+const lowRateLimit = 20
+
+var kindDescriptions = map[string]string{
+	cachedirectory.SourceKindAction:  "CodeQL Action",
+	cachedirectory.SourceKindCLI:     "CodeQL CLI",
+	cachedirectory.SourceKindQueries: "CodeQL queries",
+}
+
+type severity int
+
+const (
+	// severityError is a problem that will make a pull or push fail.
+	severityError severity = iota
+	// severityWarning is a problem that may make a pull or push fail, or that needs attention first.
+	severityWarning
+)
+
+func (severity severity) String() string {
+	if severity == severityError {
+		return "error"
+	}
+	return "warning"
+}
+
+type problem struct {
+	severity severity
+	check    string
+	message  string
+}
+
+func newError(format string, arguments ...interface{}) problem {
+	return problem{severity: severityError, message: fmt.Sprintf(format, arguments...)}
+}
+
+func newWarning(format string, arguments ...interface{}) problem {
+	return problem{severity: severityWarning, message: fmt.Sprintf(format, arguments...)}
+}
+
+type doctor struct {
+	ctx              context.Context
+	writer           io.Writer
+	cacheLocation    string
+	sourceURL        string
+	sourceToken      string
+	destinationURL   string
+	destinationToken string
+	problems         []problem
+}
+
+// check runs a check, printing whether it passed, with any details it gives, and recording the problems it found.
+func (doctor *doctor) check(description string, run func() (string, []problem)) {
+	details, problems := run()
+	status := "PASS"
+	for _, problem := range problems {
+		if problem.severity == severityError {
+			status = "FAIL"
+			break
+		}
+		status = "WARN"
+	}
+	if details != "" {
+		fmt.Fprintf(doctor.writer, "[%s] %s: %s\n", status, description, details)
+	} else {
+		fmt.Fprintf(doctor.writer, "[%s] %s\n", status, description)
+	}
+	for _, problem := range problems {
+		problem.check = description
+		doctor.problems = append(doctor.problems, problem)
+	}
+}
+
+func (doctor *doctor) newClient(instanceURL string, token string) (*github.Client, error) {
+	httpClient := http.DefaultClient
+	if token != "" {
+		httpClient = oauth2.NewClient(doctor.ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	}
+	return githubapiutil.NewClient(instanceURL, httpClient)
+}
+
+// connectionProblem describes an error connecting to an instance, naming the proxy the connection was made through as it is the most likely cause.
+func connectionProblem(instanceURL string, err error) problem {
+	proxyURL, _ := httptransport.ProxyFor(githubapiutil.APIURL(instanceURL))
+	if proxyURL != nil {
+		return newError("Cannot connect to %s through the proxy %s. Check that the proxy allows the connection, or set `NO_PROXY` if it should be made directly: %v", instanceURL, proxyURL, err)
+	}
+	return newError("Cannot connect to %s. Check that it can be reached from this machine, or give a proxy with `--proxy`: %v", instanceURL, err)
+}
+
+func (doctor *doctor) checkProxy() (string, []problem) {
+	instances := []string{doctor.sourceURL}
+	if doctor.destinationURL != "" {
+		instances = append(instances, doctor.destinationURL)
+	}
+	descriptions := []string{}
+	problems := []problem{}
+	for _, instanceURL := range instances {
+		proxyURL, err := httptransport.ProxyFor(githubapiutil.APIURL(instanceURL))
+		if err != nil {
+			problems = append(problems, newError("The proxy for %s is not valid, so no connections can be made to it: %v", instanceURL, err))
+			continue
+		}
+		if proxyURL == nil {
+			descriptions = append(descriptions, instanceURL+" directly")
+		} else {
+			descriptions = append(descriptions, instanceURL+" through "+proxyURL.String())
+		}
+	}
+	return "connecting to " + strings.Join(descriptions, ", "), problems
+}
+
+func (doctor *doctor) checkSource() (string, []problem) {
+	client, err := doctor.newClient(doctor.sourceURL, doctor.sourceToken)
+	if err != nil {
+		return "", []problem{newError("The source URL %s is not valid: %v", doctor.sourceURL, err)}
+	}
+	rateLimits, response, err := client.RateLimits(doctor.ctx)
+	if err != nil {
+		if response == nil {
+			return "", []problem{connectionProblem(doctor.sourceURL, err)}
+		}
+		switch response.StatusCode {
+		case http.StatusUnauthorized:
+			return "", []problem{newError("The source token is not valid for %s. Check that it has not expired or been revoked, or leave out `--source-token`.", doctor.sourceURL)}
+		case http.StatusNotFound:
+			// GitHub Enterprise Server does not serve rate limits when they are turned off.
+			return "connected, API rate limiting is disabled", nil
+		}
+		return "", []problem{newError("Error checking the API of %s: %v", doctor.sourceURL, err)}
+	}
+	core := rateLimits.GetCore()
+	details := fmt.Sprintf("connected, %d of %d API requests left until %s", core.Remaining, core.Limit, core.Reset.Local().Format("15:04"))
+	if core.Remaining < lowRateLimit {
+		if doctor.sourceToken == "" {
+			return details, []problem{newWarning("Only %d unauthenticated API requests are left on %s, so a pull is likely to be rate limited. Give a token with `--source-token` to raise the limit.", core.Remaining, doctor.sourceURL)}
+		}
+		return details, []problem{newWarning("Only %d API requests are left for the source token on %s, so a pull is likely to be rate limited until %s.", core.Remaining, doctor.sourceURL, core.Reset.Local().Format("15:04"))}
+	}
+	return details, nil
+}
+
+func (doctor *doctor) checkDestination() (string, []problem) {
+	client, err := doctor.newClient(doctor.destinationURL, doctor.destinationToken)
+	if err != nil {
+		return "", []problem{newError("The destination URL %s is not valid: %v", doctor.destinationURL, err)}
+	}
+	_, response, err := client.APIMeta(doctor.ctx)
+	if err != nil {
+		if response == nil {
+			return "", []problem{connectionProblem(doctor.destinationURL, err)}
+		}
+		if response.StatusCode != http.StatusUnauthorized {
+			return "", []problem{newError("Error checking the API of %s. Check that it is the URL of a GitHub Enterprise Server instance: %v", doctor.destinationURL, err)}
+		}
+	}
+	if enterpriseVersion := response.Header.Get("X-GitHub-Enterprise-Version"); enterpriseVersion != "" {
+		return "connected to GitHub Enterprise Server " + enterpriseVersion, nil
+	}
+	return "connected", nil
+}
+
+func (doctor *doctor) checkDestinationToken() (string, []problem) {
+	if doctor.destinationToken == "" {
+		return "", []problem{newError("No destination token was given. Give one with `--destination-token`, or store one with the `login` command.")}
+	}
+	client, err := doctor.newClient(doctor.destinationURL, doctor.destinationToken)
+	if err != nil {
+		return "", []problem{newError("The destination URL %s is not valid: %v", doctor.destinationURL, err)}
+	}
+	user, response, err := client.Users.Get(doctor.ctx, "")
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusUnauthorized {
+			return "", []problem{newError("The destination token is not valid for %s. Check that it has not expired or been revoked.", doctor.destinationURL)}
+		}
+		if response == nil {
+			return "", []problem{connectionProblem(doctor.destinationURL, err)}
+		}
+		return "", []problem{newError("Error checking the destination token: %v", err)}
+	}
+	details := "authenticated as " + user.GetLogin()
+	if !githubapiutil.HasScopeInformation(response) {
+		if githubapiutil.IsFineGrainedToken(doctor.destinationToken) {
+			return details, []problem{newWarning("The destination token is a fine-grained personal access token, whose permissions cannot be checked in advance. It needs the `Administration: write`, `Contents: write` and `Workflows: write` repository permissions, and the organization must already exist with you as a member.")}
+		}
+		return details, nil
+	}
+	details += " with the scopes " + response.Header.Get("X-OAuth-Scopes")
+	problems := []problem{}
+	if !githubapiutil.HasAnyScope(response, "public_repo", "repo") {
+		problems = append(problems, newError("The destination token does not have the `public_repo` or `repo` scope, which is needed to create and update the destination repository."))
+	}
+	if !githubapiutil.HasAnyScope(response, "workflow") {
+		problems = append(problems, newError("The destination token does not have the `workflow` scope, which is needed to push the workflow files in the CodeQL Action repository."))
+	}
+	if !githubapiutil.HasAnyScope(response, "site_admin") {
+		problems = append(problems, newWarning("The destination token does not have the `site_admin` scope, which is needed to create the organization of the destination repository or push to it without being a member. Use `--no-site-admin` if the organization already exists."))
+	}
+	return details, problems
+}
+
+func (doctor *doctor) checkCache() (string, []problem) {
+	cacheDirectory, err := cachedirectory.OpenCacheDirectory(doctor.cacheLocation)
+	if err != nil {
+		return "", []problem{newError("The cache %s cannot be opened: %v", doctor.cacheLocation, err)}
+	}
+	if !cacheDirectory.IsRemote() {
+		if _, err := os.Stat(doctor.cacheLocation); os.IsNotExist(err) {
+			return "", []problem{newWarning("The cache %s does not exist yet. It is created by the `pull` command.", doctor.cacheLocation)}
+		}
+	}
+	err = cacheDirectory.CheckOrCreateVersionFile(false, version.Version())
+	if err != nil {
+		return "", []problem{newError("%v", err)}
+	}
+	problems := []problem{}
+	err = cacheDirectory.CheckLock()
+	if err != nil {
+		problems = append(problems, newWarning("%v", err))
+	}
+	partialAssets, err := cacheDirectory.ListPartialAssets()
+	if err != nil {
+		problems = append(problems, newError("The downloads in progress in the cache cannot be read: %v", err))
+	} else if len(partialAssets) != 0 {
+		problems = append(problems, newWarning("%d downloads in the cache are incomplete. Run `pull` again to finish them, or `status` to see their progress.", len(partialAssets)))
+	}
+	kind, err := cacheDirectory.ReadSourceKind()
+	if err != nil {
+		problems = append(problems, newError("The kind of source the cache was pulled from cannot be read: %v", err))
+	}
+	releases, err := cacheDirectory.ListReleases()
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		problems = append(problems, newError("The releases in the cache cannot be read: %v", err))
+	}
+	return fmt.Sprintf("%s, with %d releases of the %s", cacheDirectory.String(), len(releases), kindDescriptions[kind]), problems
+}
+
+// existingParent returns the path, or its closest parent if it does not exist yet, as that is the disk the cache will be created on.
+func existingParent(path string) string {
+	path, _ = filepath.Abs(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func (doctor *doctor) checkDiskSpace() (string, []problem) {
+	path := existingParent(doctor.cacheLocation)
+	free, err := freeSpace(path)
+	if err != nil {
+		return "", []problem{newWarning("The free space on the disk of the cache cannot be checked: %v", err)}
+	}
+	details := fmt.Sprintf("%.1f GiB free on the disk of %s", float64(free)/(1024*1024*1024), path)
+	if free < MinimumFreeSpace {
+		return details, []problem{newWarning("Less than %d GiB is free on the disk of the cache, which may not be enough to download a new CodeQL bundle. Free some space, or use `--languages` to only pull the bundles that are needed.", MinimumFreeSpace/(1024*1024*1024))}
+	}
+	return details, nil
+}
+
+// Run checks everything the sync tool needs to work: connections to the source and destination and the proxy they are made through, the tokens for each, the health of the cache and the free space on its disk. Each check is printed as it is run, followed by the problems found, most important first. An error is returned if any problem will stop a pull or push, but not if there are only warnings. The destination is only checked if its URL is given.
+func Run(ctx context.Context, cacheLocation string, sourceURL string, sourceToken string, destinationURL string, destinationToken string, writer io.Writer) error {
+	doctor := doctor{
+		ctx:              ctx,
+		writer:           writer,
+		cacheLocation:    cacheLocation,
+		sourceURL:        strings.TrimRight(sourceURL, "/"),
+		sourceToken:      sourceToken,
+		destinationURL:   strings.TrimRight(destinationURL, "/"),
+		destinationToken: destinationToken,
+	}
+	doctor.check("Proxy configuration", doctor.checkProxy)
+	doctor.check("Connection to "+doctor.sourceURL, doctor.checkSource)
+	if doctor.destinationURL != "" {
+		doctor.check("Connection to "+doctor.destinationURL, doctor.checkDestination)
+		doctor.check("Destination token", doctor.checkDestinationToken)
+	}
+	doctor.check("Cache", doctor.checkCache)
+	if !strings.Contains(cacheLocation, "://") {
+		doctor.check("Disk space", doctor.checkDiskSpace)
+	}
+
+	fmt.Fprintln(writer)
+	if len(doctor.problems) == 0 {
+		fmt.Fprintln(writer, "No problems found.")
+		return nil
+	}
+	// Checks are run in order of importance, so within each severity the problems keep that order.
+	sort.SliceStable(doctor.problems, func(i, j int) bool {
+		return doctor.problems[i].severity < doctor.problems[j].severity
+	})
+	fmt.Fprintln(writer, "Problems, most important first:")
+	hasErrors := false
+	for index, problem := range doctor.problems {
+		fmt.Fprintf(writer, "%d. [%s] %s: %s\n", index+1, problem.severity, problem.check, problem.message)
+		hasErrors = hasErrors || problem.severity == severityError
+	}
+	if hasErrors {
+		return usererrors.New(errorProblemsFound)
+	}
+	return nil
+}
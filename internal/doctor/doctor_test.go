@@ -0,0 +1,104 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestInstance starts a GitHub Enterprise Server instance that accepts the tokens in its map, each with the given scopes.
+func startTestInstance(t *testing.T, tokens map[string]string) *httptest.Server {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v3/rate_limit", func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte(`{"resources": {"core": {"limit": 60, "remaining": 5, "reset": 1700000000}}}`))
+	})
+	router.HandleFunc("/api/v3/meta", func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("X-GitHub-Enterprise-Version", "3.12.0")
+		response.Write([]byte(`{"installed_version": "3.12.0"}`))
+	})
+	router.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		for token, scopes := range tokens {
+			if request.Header.Get("Authorization") == "Bearer "+token {
+				response.Header().Set("X-OAuth-Scopes", scopes)
+				response.Write([]byte(`{"login": "octocat"}`))
+				return
+			}
+		}
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte(`{"message": "Bad credentials"}`))
+	})
+	return httptest.NewServer(router)
+}
+
+func TestRunWithoutProblems(t *testing.T) {
+	server := startTestInstance(t, map[string]string{"token": "repo, workflow, site_admin"})
+	defer server.Close()
+	cachePath := path.Join(test.CreateTemporaryDirectory(t), "cache")
+	cacheDirectory := cachedirectory.NewCacheDirectory(cachePath)
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, version.Version()))
+
+	output := bytes.Buffer{}
+	err := Run(context.Background(), cachePath, server.URL, "", server.URL, "token", &output)
+	require.NoError(t, err, output.String())
+	require.Contains(t, output.String(), "[PASS] Proxy configuration: connecting to "+server.URL+" directly")
+	require.Contains(t, output.String(), "[WARN] Connection to "+server.URL+": connected, 5 of 60 API requests left")
+	require.Contains(t, output.String(), "[PASS] Connection to "+server.URL+": connected to GitHub Enterprise Server 3.12.0")
+	require.Contains(t, output.String(), "[PASS] Destination token: authenticated as octocat with the scopes repo, workflow, site_admin")
+	require.Contains(t, output.String(), "[PASS] Cache: "+cachePath+", with 0 releases of the CodeQL Action")
+	require.Contains(t, output.String(), "Problems, most important first:\n1. [warning] Connection to "+server.URL+": Only 5 unauthenticated API requests are left")
+}
+
+func TestRunWithProblems(t *testing.T) {
+	server := startTestInstance(t, map[string]string{"token": "public_repo"})
+	defer server.Close()
+	cachePath := path.Join(test.CreateTemporaryDirectory(t), "cache")
+	cacheDirectory := cachedirectory.NewCacheDirectory(cachePath)
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, version.Version()))
+	require.NoError(t, cacheDirectory.Lock())
+
+	output := bytes.Buffer{}
+	err := Run(context.Background(), cachePath, server.URL, "wrong", server.URL, "token", &output)
+	require.EqualError(t, err, errorProblemsFound)
+	require.Contains(t, output.String(), "[FAIL] Destination token: authenticated as octocat with the scopes public_repo")
+	require.Contains(t, output.String(), "[WARN] Cache: ")
+	// Errors come before warnings, whichever check found them.
+	require.Contains(t, output.String(), "1. [error] Destination token: The destination token does not have the `workflow` scope")
+	require.Contains(t, output.String(), "2. [warning] Connection to "+server.URL+": Only 5 API requests are left for the source token")
+	require.Contains(t, output.String(), "3. [warning] Destination token: The destination token does not have the `site_admin` scope")
+	require.Contains(t, output.String(), "4. [warning] Cache: The cache directory is locked")
+}
+
+func TestRunInvalidToken(t *testing.T) {
+	server := startTestInstance(t, map[string]string{})
+	defer server.Close()
+	cachePath := path.Join(test.CreateTemporaryDirectory(t), "cache")
+
+	output := bytes.Buffer{}
+	err := Run(context.Background(), cachePath, server.URL, "", server.URL, "wrong", &output)
+	require.EqualError(t, err, errorProblemsFound)
+	require.Contains(t, output.String(), "1. [error] Destination token: The destination token is not valid for "+server.URL)
+	require.Contains(t, output.String(), "2. [warning] Connection to ")
+	require.Contains(t, output.String(), "3. [warning] Cache: The cache "+cachePath+" does not exist yet.")
+	require.Contains(t, output.String(), "] Disk space: ")
+}
+
+func TestRunCannotConnect(t *testing.T) {
+	server := startTestInstance(t, map[string]string{})
+	server.Close()
+	cachePath := path.Join(test.CreateTemporaryDirectory(t), "cache")
+
+	output := bytes.Buffer{}
+	err := Run(context.Background(), cachePath, server.URL, "", "", "", &output)
+	require.EqualError(t, err, errorProblemsFound)
+	require.Contains(t, output.String(), "1. [error] Connection to "+server.URL+": Cannot connect to "+server.URL+". Check that it can be reached from this machine, or give a proxy with `--proxy`")
+	require.NotContains(t, output.String(), "Destination token")
+}
@@ -0,0 +1,125 @@
+package exitcode
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/google/go-github/v32/github"
+)
+
+// Exit codes used by the sync tool, so that wrapper scripts and schedulers can tell failures that are worth retrying from those that need a person to look at them.
+const (
+	// Success means the command completed successfully.
+	Success = 0
+	// Failure means the command failed for a reason not covered by a more specific code, including invalid arguments.
+	Failure = 1
+	// Authentication means a token was missing, invalid, or lacked the permissions needed.
+	Authentication = 3
+	// RateLimited means GitHub.com or GitHub Enterprise Server refused a request because of API rate limiting.
+	RateLimited = 4
+	// Network means GitHub.com, GitHub Enterprise Server or remote cache storage could not be reached.
+	Network = 5
+	// CacheCorrupt means the cache could not be read, and should be pulled again.
+	CacheCorrupt = 6
+	// PartialSuccess means the command failed after already making some of its changes, so the cache or destination is in an intermediate state until the command is run again.
+	PartialSuccess = 7
+	// Differences means `diff` found differences between the cache and the destination.
+	Differences = 8
+)
+
+// Error attaches an exit code to an error.
+type Error struct {
+	code int
+	err  error
+}
+
+// WithCode returns an error that exits with the given code. If err is nil, nil is returned.
+func WithCode(err error, code int) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{code: code, err: err}
+}
+
+func (err *Error) Error() string {
+	return err.err.Error()
+}
+
+func (err *Error) Cause() error {
+	return err.err
+}
+
+func (err *Error) Unwrap() error {
+	return err.err
+}
+
+// Format formats the underlying error, so that stack traces added with github.com/pkg/errors are still printed for %+v.
+func (err *Error) Format(state fmt.State, verb rune) {
+	if formatter, ok := err.err.(fmt.Formatter); ok {
+		formatter.Format(state, verb)
+		return
+	}
+	fmt.Fprintf(state, "%"+string(verb), err.err)
+}
+
+// next returns the error wrapped by err, supporting both github.com/pkg/errors and the standard library.
+func next(err error) error {
+	switch wrapper := err.(type) {
+	case interface{ Cause() error }:
+		return wrapper.Cause()
+	case interface{ Unwrap() error }:
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
+// classify returns the exit code for a single error in a chain, or Failure if it is not recognized.
+func classify(err error) int {
+	switch typed := err.(type) {
+	case *Error:
+		if typed.code != PartialSuccess {
+			return typed.code
+		}
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return RateLimited
+	case *github.ErrorResponse:
+		if typed.Response != nil && (typed.Response.StatusCode == http.StatusUnauthorized || typed.Response.StatusCode == http.StatusForbidden) {
+			return Authentication
+		}
+	case *url.Error:
+		// A request failing is less specific than why it failed, for example because the proxy rejected its credentials.
+		if code := Code(typed.Err); code != Failure && code != PartialSuccess {
+			return code
+		}
+		return Network
+	case net.Error:
+		return Network
+	}
+	if err == transport.ErrAuthenticationRequired || err == transport.ErrAuthorizationFailed {
+		return Authentication
+	}
+	return Failure
+}
+
+// Code returns the exit code for an error. The most specific cause found wins, so for example a network error while pushing is reported as Network even though the destination was already partially updated.
+func Code(err error) int {
+	if err == nil {
+		return Success
+	}
+	partial := false
+	for current := err; current != nil; current = next(current) {
+		if code := classify(current); code != Failure {
+			return code
+		}
+		if coded, ok := current.(*Error); ok && coded.code == PartialSuccess {
+			partial = true
+		}
+	}
+	if partial {
+		return PartialSuccess
+	}
+	return Failure
+}
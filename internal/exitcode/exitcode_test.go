@@ -0,0 +1,48 @@
+package exitcode
+
+import (
+	usererrors "errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCode(t *testing.T) {
+	require.Equal(t, Success, Code(nil))
+	require.Equal(t, Failure, Code(usererrors.New("Something went wrong.")))
+	require.Equal(t, Authentication, Code(WithCode(usererrors.New("Bad token."), Authentication)))
+	require.Equal(t, Authentication, Code(errors.Wrap(WithCode(usererrors.New("Bad token."), Authentication), "Error pushing.")))
+	require.Equal(t, Authentication, Code(errors.Wrap(transport.ErrAuthenticationRequired, "Error pushing.")))
+	require.Equal(t, Authentication, Code(&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnauthorized}}))
+	require.Equal(t, Failure, Code(&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusInternalServerError}}))
+	require.Equal(t, RateLimited, Code(errors.Wrap(&github.RateLimitError{}, "Error listing releases.")))
+	require.Equal(t, RateLimited, Code(&github.AbuseRateLimitError{}))
+	require.Equal(t, Network, Code(errors.Wrap(&net.OpError{Op: "dial", Err: usererrors.New("connection refused")}, "Error getting release.")))
+	require.Equal(t, Network, Code(&url.Error{Op: "Get", URL: "https://github.com", Err: usererrors.New("EOF")}))
+	require.Equal(t, Authentication, Code(&url.Error{Op: "Get", URL: "https://github.com", Err: WithCode(usererrors.New("The proxy rejected the credentials."), Authentication)}))
+	require.Equal(t, CacheCorrupt, Code(WithCode(usererrors.New("Bad cache."), CacheCorrupt)))
+	require.Equal(t, Differences, Code(WithCode(usererrors.New("The cache and the destination differ."), Differences)))
+}
+
+func TestPartialSuccess(t *testing.T) {
+	require.Equal(t, PartialSuccess, Code(WithCode(errors.New("Error uploading release assets."), PartialSuccess)))
+	// A more specific cause takes precedence.
+	require.Equal(t, Network, Code(WithCode(errors.Wrap(&net.OpError{Op: "dial", Err: usererrors.New("connection refused")}, "Error uploading release assets."), PartialSuccess)))
+	require.Equal(t, Authentication, Code(WithCode(WithCode(usererrors.New("Bad token."), Authentication), PartialSuccess)))
+}
+
+func TestWithCode(t *testing.T) {
+	require.Nil(t, WithCode(nil, Network))
+	cause := usererrors.New("Something went wrong.")
+	err := WithCode(errors.Wrap(cause, "Error doing something."), Network)
+	require.EqualError(t, err, "Error doing something.: Something went wrong.")
+	require.Equal(t, cause, errors.Cause(err))
+	require.Contains(t, fmt.Sprintf("%+v", err), "TestWithCode")
+}
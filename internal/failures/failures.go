@@ -0,0 +1,99 @@
+package failures
+
+import (
+	"context"
+	usererrors "errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/report"
+	log "github.com/sirupsen/logrus"
+)
+
+// Failure is an error that stopped one release from being synced.
+type Failure struct {
+	Release string
+	Err     error
+}
+
+// Collector collects the releases that could not be synced when `--continue-on-error` is given, so that the rest of the run carries on and the failures are reported together at the end. A nil collector collects nothing, so the first failure stops the run as usual.
+type Collector struct {
+	lock     sync.Mutex
+	failures []Failure
+}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+type contextKey struct{}
+
+// WithCollector returns a context which carries the collector to the code doing the work.
+func WithCollector(ctx context.Context, collector *Collector) context.Context {
+	return context.WithValue(ctx, contextKey{}, collector)
+}
+
+// FromContext returns the collector carried by the context, or nil if there is none.
+func FromContext(ctx context.Context) *Collector {
+	collector, _ := ctx.Value(contextKey{}).(*Collector)
+	return collector
+}
+
+// Record records that a release could not be synced and returns nil so that the run carries on with the other releases, or returns the error unchanged if the context carries no collector. Cancelling the run is never recorded, as it stops everything else too.
+func Record(ctx context.Context, release string, err error) error {
+	collector := FromContext(ctx)
+	if err == nil || collector == nil || ctx.Err() != nil {
+		return err
+	}
+	log.Errorf("Could not sync release %s, continuing with the other releases: %s", release, err)
+	report.FromContext(ctx).RecordFailure(release, err.Error())
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	collector.failures = append(collector.failures, Failure{Release: release, Err: err})
+	return nil
+}
+
+// Failed reports whether a release could not be synced, so that later steps for it can be left out.
+func (collector *Collector) Failed(release string) bool {
+	if collector == nil {
+		return false
+	}
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	for _, failure := range collector.failures {
+		if failure.Release == release {
+			return true
+		}
+	}
+	return false
+}
+
+// Failures returns the failures recorded so far, ordered by release.
+func (collector *Collector) Failures() []Failure {
+	if collector == nil {
+		return nil
+	}
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	result := append([]Failure{}, collector.failures...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Release < result[j].Release
+	})
+	return result
+}
+
+// Err returns an error listing every release that could not be synced, or nil if there were none. Everything else was synced, so it exits with exitcode.PartialSuccess.
+func (collector *Collector) Err() error {
+	failures := collector.Failures()
+	if len(failures) == 0 {
+		return nil
+	}
+	lines := []string{fmt.Sprintf("%d release(s) could not be synced, but everything else was. Run the command again to retry them.", len(failures))}
+	for _, failure := range failures {
+		lines = append(lines, fmt.Sprintf("  %s: %s", failure.Release, failure.Err))
+	}
+	return exitcode.WithCode(usererrors.New(strings.Join(lines, "\n")), exitcode.PartialSuccess)
+}
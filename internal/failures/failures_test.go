@@ -0,0 +1,50 @@
+package failures
+
+import (
+	"context"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordWithoutCollector(t *testing.T) {
+	err := errors.New("Error downloading asset.")
+	require.Equal(t, err, Record(context.Background(), "codeql-bundle-20200101", err))
+	require.NoError(t, Record(context.Background(), "codeql-bundle-20200101", nil))
+	require.False(t, FromContext(context.Background()).Failed("codeql-bundle-20200101"))
+	require.NoError(t, FromContext(context.Background()).Err())
+}
+
+func TestRecord(t *testing.T) {
+	collector := NewCollector()
+	recorder := report.NewRecorder()
+	ctx := report.WithRecorder(WithCollector(context.Background(), collector), recorder)
+	require.NoError(t, collector.Err())
+
+	require.NoError(t, Record(ctx, "codeql-bundle-20200102", errors.New("Error uploading release assets.")))
+	require.NoError(t, Record(ctx, "codeql-bundle-20200101", errors.New("Error downloading asset.")))
+	require.NoError(t, Record(ctx, "codeql-bundle-20200103", nil))
+	require.True(t, collector.Failed("codeql-bundle-20200101"))
+	require.False(t, collector.Failed("codeql-bundle-20200103"))
+	require.Len(t, collector.Failures(), 2)
+	require.Equal(t, "codeql-bundle-20200101", collector.Failures()[0].Release)
+
+	err := collector.Err()
+	require.EqualError(t, err, "2 release(s) could not be synced, but everything else was. Run the command again to retry them.\n  codeql-bundle-20200101: Error downloading asset.\n  codeql-bundle-20200102: Error uploading release assets.")
+	require.Equal(t, exitcode.PartialSuccess, exitcode.Code(err))
+	releases := recorder.Document(notify.Summary{}).ReleaseOutcomes
+	require.Len(t, releases, 2)
+	require.Equal(t, report.OutcomeFailed, releases[0].Outcome)
+}
+
+func TestRecordCancelled(t *testing.T) {
+	collector := NewCollector()
+	ctx, cancel := context.WithCancel(WithCollector(context.Background(), collector))
+	cancel()
+	require.Error(t, Record(ctx, "codeql-bundle-20200101", context.Canceled))
+	require.Empty(t, collector.Failures())
+}
@@ -0,0 +1,58 @@
+package fips
+
+import (
+	"crypto/tls"
+	usererrors "errors"
+	"fmt"
+	"net/http"
+)
+
+const errorModuleNotInFIPSMode = "FIPS mode needs the Go Cryptographic Module to be in FIPS 140-3 mode, which it is not. Run the sync tool with the environment variable `GODEBUG=fips140=on`, or use a build of the sync tool made with `GOFIPS140=v1.0.0`."
+const errorNotAllowed = "%s is not allowed in FIPS mode, as it would use cryptography outside the FIPS 140-3 validated Go Cryptographic Module."
+
+var enabled = false
+
+// Enable turns on FIPS mode, in which every connection uses only FIPS-approved TLS versions, cipher suites and curves, and operations that would use cryptography outside the validated module fail. It fails if the Go Cryptographic Module itself is not in FIPS mode, as otherwise hashing and TLS would not use validated implementations.
+func Enable() error {
+	if !moduleEnabled() {
+		return usererrors.New(errorModuleNotInFIPSMode)
+	}
+	enabled = true
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.TLSClientConfig = TLSConfig()
+	}
+	return nil
+}
+
+// Enabled reports whether FIPS mode is on.
+func Enabled() bool {
+	return enabled
+}
+
+// ModuleEnabled reports whether the Go Cryptographic Module was put in FIPS mode when the sync tool started, either by a FIPS build or by `GODEBUG`.
+func ModuleEnabled() bool {
+	return moduleEnabled()
+}
+
+// Check returns an error if FIPS mode is on, for an operation that cannot be done with FIPS-approved cryptography.
+func Check(operation string) error {
+	if !enabled {
+		return nil
+	}
+	return fmt.Errorf(errorNotAllowed, operation)
+}
+
+// TLSConfig returns TLS settings restricted to FIPS-approved protocol versions, cipher suites and curves.
+func TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// TLS 1.3 cipher suites cannot be configured, but are all AES-GCM except for ChaCha20-Poly1305, which the Go Cryptographic Module does not offer in FIPS mode.
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+		CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521},
+	}
+}
@@ -0,0 +1,31 @@
+package fips
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	require.NoError(t, Check("Something"))
+	enabled = true
+	defer func() { enabled = false }()
+	require.EqualError(t, Check("Something"), "Something is not allowed in FIPS mode, as it would use cryptography outside the FIPS 140-3 validated Go Cryptographic Module.")
+}
+
+func TestEnableNeedsModule(t *testing.T) {
+	if ModuleEnabled() {
+		t.Skip("The Go Cryptographic Module is in FIPS mode.")
+	}
+	require.EqualError(t, Enable(), errorModuleNotInFIPSMode)
+	require.False(t, Enabled())
+}
+
+func TestTLSConfig(t *testing.T) {
+	config := TLSConfig()
+	require.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	for _, cipherSuite := range config.CipherSuites {
+		require.Contains(t, tls.CipherSuiteName(cipherSuite), "_GCM_")
+	}
+}
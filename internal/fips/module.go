@@ -0,0 +1,10 @@
+//go:build go1.24
+// +build go1.24
+
+package fips
+
+import "crypto/fips140"
+
+func moduleEnabled() bool {
+	return fips140.Enabled()
+}
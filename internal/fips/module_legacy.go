@@ -0,0 +1,9 @@
+//go:build !go1.24
+// +build !go1.24
+
+package fips
+
+// Go releases before 1.24 have no FIPS 140-3 mode.
+func moduleEnabled() bool {
+	return false
+}
@@ -0,0 +1,35 @@
+package githubapiutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+)
+
+const errorAssetSizeOverflow = "The release asset %s is %d bytes, which is too large for this build of the CodeQL Action sync tool. Use a 64-bit build instead."
+const errorAssetSizeOverflowInResponse = "A release asset is too large for this build of the CodeQL Action sync tool. Use a 64-bit build instead."
+
+// AssetSize returns the size of a release asset in bytes. go-github gives sizes as an `int`, which is widened here so that sizes are `int64` everywhere else in the sync tool.
+func AssetSize(asset *github.ReleaseAsset) int64 {
+	return int64(asset.GetSize())
+}
+
+// NewAssetSize converts a size in bytes to the `int` go-github uses for the size of a release asset. This only fails for assets of 2 GiB or more on 32-bit platforms, where an `int` cannot hold the size.
+func NewAssetSize(name string, size int64) (*int, error) {
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf(errorAssetSizeOverflow, name, size)
+	}
+	return github.Int(int(size)), nil
+}
+
+// AssetSizeError returns an error explaining what to do if a response from the API could not be decoded because the size of a release asset does not fit in an `int`, which only happens for assets of 2 GiB or more on 32-bit platforms. Otherwise it returns nil.
+func AssetSizeError(err error) error {
+	typeError, ok := errors.Cause(err).(*json.UnmarshalTypeError)
+	if !ok || !strings.HasPrefix(typeError.Value, "number") || !(typeError.Field == "size" || strings.HasSuffix(typeError.Field, ".size")) {
+		return nil
+	}
+	return errors.New(errorAssetSizeOverflowInResponse)
+}
@@ -0,0 +1,39 @@
+package githubapiutil
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssetSize(t *testing.T) {
+	require.Equal(t, int64(0), AssetSize(&github.ReleaseAsset{}))
+	require.Equal(t, int64(1234), AssetSize(&github.ReleaseAsset{Size: github.Int(1234)}))
+
+	size, err := NewAssetSize("codeql-bundle.tar.gz", 1234)
+	require.NoError(t, err)
+	require.Equal(t, 1234, *size)
+}
+
+func TestAssetSizeError(t *testing.T) {
+	require.Nil(t, AssetSizeError(nil))
+	require.Nil(t, AssetSizeError(errors.New("Some other error.")))
+
+	// A size too large even for a 64-bit `int` simulates what 32-bit builds see for assets of 2 GiB or more.
+	release := github.RepositoryRelease{}
+	err := json.Unmarshal([]byte(`{"tag_name": "codeql-bundle-20200101", "assets": [{"name": "codeql-bundle.tar.gz", "size": 100000000000000000000}]}`), &release)
+	require.Error(t, err)
+	require.EqualError(t, AssetSizeError(errors.Wrap(err, "Error loading CodeQL release information.")), errorAssetSizeOverflowInResponse)
+
+	assets := []*github.ReleaseAsset{}
+	err = json.Unmarshal([]byte(`[{"name": "codeql-bundle.tar.gz", "size": 100000000000000000000}]`), &assets)
+	require.Error(t, err)
+	require.EqualError(t, AssetSizeError(err), errorAssetSizeOverflowInResponse)
+
+	err = json.Unmarshal([]byte(`{"tag_name": 1}`), &release)
+	require.Error(t, err)
+	require.Nil(t, AssetSizeError(err))
+}
@@ -1,9 +1,12 @@
 package githubapiutil
 
 import (
+	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
 )
 
 const xOAuthScopesHeader = "X-OAuth-Scopes"
@@ -26,3 +29,96 @@ func HasAnyScope(response *github.Response, scopes ...string) bool {
 	}
 	return false
 }
+
+// HasScopeInformation reports whether the response lists the scopes of the token used, which is not the case for tokens that use fine-grained permissions instead.
+func HasScopeInformation(response *github.Response) bool {
+	return response != nil && len(response.Header.Values(xOAuthScopesHeader)) != 0
+}
+
+const fineGrainedTokenPrefix = "github_pat_"
+
+// IsFineGrainedToken reports whether a token is a fine-grained personal access token, which is granted permissions on particular repositories rather than scopes.
+func IsFineGrainedToken(token string) bool {
+	return strings.HasPrefix(token, fineGrainedTokenPrefix)
+}
+
+const xAcceptedGitHubPermissionsHeader = "X-Accepted-GitHub-Permissions"
+
+// AcceptedPermissions returns the fine-grained permissions that GitHub says a request needs, such as `contents: write`, or nil if the response does not say. If there is more than one way of granting access, each is returned, with the permissions of each separated by commas.
+func AcceptedPermissions(response *github.Response) []string {
+	if response == nil || response.Header.Get(xAcceptedGitHubPermissionsHeader) == "" {
+		return nil
+	}
+	result := []string{}
+	for _, alternative := range strings.Split(response.Header.Get(xAcceptedGitHubPermissionsHeader), ";") {
+		permissions := []string{}
+		for _, permission := range strings.FieldsFunc(alternative, isPermissionSeparator) {
+			split := strings.SplitN(strings.TrimSpace(permission), "=", 2)
+			if len(split) == 2 {
+				permissions = append(permissions, "`"+split[0]+": "+split[1]+"`")
+			}
+		}
+		if len(permissions) != 0 {
+			result = append(result, strings.Join(permissions, ", "))
+		}
+	}
+	return result
+}
+
+func isPermissionSeparator(character rune) bool {
+	return character == ',' || character == '&'
+}
+
+const gheDotComDomain = ".ghe.com"
+
+// IsGHEDotCom reports whether an instance is a GHE.com tenant of GitHub Enterprise Cloud with data residency, such as https://octocorp.ghe.com.
+func IsGHEDotCom(instanceURL string) bool {
+	parsedURL, err := url.Parse(instanceURL)
+	return err == nil && strings.HasSuffix(strings.ToLower(parsedURL.Hostname()), gheDotComDomain)
+}
+
+// apiHostURL returns the URL of a host alongside the instance, as used by GitHub.com and GHE.com for the API, or an empty string for GitHub Enterprise Server, which serves everything from the same host.
+func apiHostURL(instanceURL string, prefix string) string {
+	parsedURL, err := url.Parse(instanceURL)
+	if err != nil {
+		return ""
+	}
+	if !strings.EqualFold(parsedURL.Host, "github.com") && !IsGHEDotCom(instanceURL) {
+		return ""
+	}
+	return parsedURL.Scheme + "://" + prefix + "." + parsedURL.Host + "/"
+}
+
+// APIURL returns the base URL of the REST API of a GitHub instance. GitHub.com and GHE.com serve it from a separate host, while GitHub Enterprise Server serves it under /api/v3.
+func APIURL(instanceURL string) string {
+	instanceURL = strings.TrimRight(instanceURL, "/")
+	if hostURL := apiHostURL(instanceURL, "api"); hostURL != "" {
+		return hostURL
+	}
+	return instanceURL + "/api/v3/"
+}
+
+// UploadURL returns the base URL for uploading release assets to a GitHub instance, which is laid out in the same way as the REST API.
+func UploadURL(instanceURL string) string {
+	instanceURL = strings.TrimRight(instanceURL, "/")
+	if hostURL := apiHostURL(instanceURL, "uploads"); hostURL != "" {
+		return hostURL
+	}
+	return instanceURL + "/api/uploads/"
+}
+
+// NewClient creates an API client for GitHub.com, a GHE.com tenant or a GitHub Enterprise Server instance, given the URL of its web interface.
+func NewClient(instanceURL string, httpClient *http.Client) (*github.Client, error) {
+	baseURL, err := url.Parse(APIURL(instanceURL))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing API URL.")
+	}
+	uploadURL, err := url.Parse(UploadURL(instanceURL))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing upload URL.")
+	}
+	client := github.NewClient(httpClient)
+	client.BaseURL = baseURL
+	client.UploadURL = uploadURL
+	return client, nil
+}
@@ -0,0 +1,55 @@
+package httptransport
+
+import (
+	"crypto/tls"
+	usererrors "errors"
+	"net/http"
+	"time"
+)
+
+// DefaultMaxIdlePerHost is the number of idle connections kept open to each host. This is more than the Go default of 2 so that connections are reused when assets are uploaded or downloaded several at a time, rather than each transfer making a new connection, which is slow through a proxy that inspects TLS.
+const DefaultMaxIdlePerHost = 16
+
+const DefaultIdleTimeout = 90 * time.Second
+
+// Connections tunes how connections are reused between requests.
+type Connections struct {
+	// MaxIdlePerHost is the number of idle connections kept open to each host.
+	MaxIdlePerHost int
+	// IdleTimeout is how long an idle connection is kept open, or zero for no limit.
+	IdleTimeout time.Duration
+	// DisableKeepAlives makes a new connection for every request.
+	DisableKeepAlives bool
+	// DisableHTTP2 makes every connection use HTTP/1.1, for proxies that interfere with HTTP/2 streams.
+	DisableHTTP2 bool
+}
+
+func (connections Connections) validate() error {
+	if connections.MaxIdlePerHost < 0 || connections.IdleTimeout < 0 {
+		return usererrors.New("`--max-idle-connections` and `--idle-connection-timeout` cannot be negative.")
+	}
+	return nil
+}
+
+func (connections Connections) apply(transport *http.Transport) {
+	transport.MaxIdleConnsPerHost = connections.MaxIdlePerHost
+	if transport.MaxIdleConns != 0 && transport.MaxIdleConns < connections.MaxIdlePerHost {
+		transport.MaxIdleConns = connections.MaxIdlePerHost
+	}
+	transport.IdleConnTimeout = connections.IdleTimeout
+	transport.DisableKeepAlives = connections.DisableKeepAlives
+	if connections.DisableHTTP2 {
+		// An empty rather than nil map stops the transport from setting up HTTP/2 when connecting over TLS, and servers must not be offered HTTP/2 either.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if transport.TLSClientConfig != nil {
+			nextProtos := []string{}
+			for _, proto := range transport.TLSClientConfig.NextProtos {
+				if proto != "h2" {
+					nextProtos = append(nextProtos, proto)
+				}
+			}
+			transport.TLSClientConfig.NextProtos = nextProtos
+		}
+	}
+}
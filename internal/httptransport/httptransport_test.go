@@ -0,0 +1,96 @@
+package httptransport
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("Started."))
+		response.(http.Flusher).Flush()
+		if request.URL.Path == "/slow" {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-request.Context().Done():
+			}
+		}
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &requestTimeoutTransport{base: http.DefaultTransport, timeout: 200 * time.Millisecond}}
+
+	response, err := client.Get(server.URL + "/fast")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	require.Equal(t, "Started.", string(body))
+
+	// The deadline covers reading the body, not just waiting for the response to start.
+	response, err = client.Get(server.URL + "/slow")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(response.Body)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	response.Body.Close()
+}
+
+func TestInstall(t *testing.T) {
+	original := http.DefaultTransport
+	defer func() { http.DefaultTransport = original }()
+	transport := &http.Transport{MaxIdleConns: 100}
+	http.DefaultTransport = transport
+
+	connections := Connections{MaxIdlePerHost: 200, IdleTimeout: time.Minute, DisableKeepAlives: true}
+	require.NoError(t, Install(Timeouts{Connect: time.Second, TLSHandshake: 2 * time.Second, ResponseHeader: 3 * time.Second}, connections, Proxy{}, ProxyAuthentication{}))
+	require.Equal(t, transport, http.DefaultTransport)
+	require.Equal(t, 2*time.Second, transport.TLSHandshakeTimeout)
+	require.Equal(t, 3*time.Second, transport.ResponseHeaderTimeout)
+	require.NotNil(t, transport.DialContext)
+	require.Equal(t, 200, transport.MaxIdleConnsPerHost)
+	require.Equal(t, 200, transport.MaxIdleConns)
+	require.Equal(t, time.Minute, transport.IdleConnTimeout)
+	require.True(t, transport.DisableKeepAlives)
+
+	require.NoError(t, Install(Timeouts{Request: time.Hour}, Connections{MaxIdlePerHost: DefaultMaxIdlePerHost}, Proxy{}, ProxyAuthentication{}))
+	require.Equal(t, &requestTimeoutTransport{base: transport, timeout: time.Hour}, http.DefaultTransport)
+	require.Equal(t, 200, transport.MaxIdleConns)
+	require.False(t, transport.DisableKeepAlives)
+
+	require.Error(t, Install(Timeouts{}, Connections{}, Proxy{}, ProxyAuthentication{}))
+	http.DefaultTransport = transport
+	require.Error(t, Install(Timeouts{Connect: -time.Second}, Connections{}, Proxy{}, ProxyAuthentication{}))
+	require.Error(t, Install(Timeouts{}, Connections{MaxIdlePerHost: -1}, Proxy{}, ProxyAuthentication{}))
+}
+
+func TestDisableHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte(request.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	for _, disableHTTP2 := range []bool{false, true} {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.ForceAttemptHTTP2 = true
+		Connections{MaxIdlePerHost: DefaultMaxIdlePerHost, DisableHTTP2: disableHTTP2}.apply(transport)
+		response, err := (&http.Client{Transport: transport}).Get(server.URL)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		response.Body.Close()
+		if disableHTTP2 {
+			require.Equal(t, "HTTP/1.1", string(body))
+		} else {
+			require.Equal(t, "HTTP/2.0", string(body))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package httptransport
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	usererrors "errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+const ntlmSignature = "NTLMSSP\x00"
+
+const (
+	ntlmNegotiateMessage    = 1
+	ntlmChallengeMessage    = 2
+	ntlmAuthenticateMessage = 3
+)
+
+const (
+	ntlmNegotiateUnicode                 = 0x00000001
+	ntlmRequestTarget                    = 0x00000004
+	ntlmNegotiateNTLM                    = 0x00000200
+	ntlmNegotiateAlwaysSign              = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity = 0x00080000
+	ntlmNegotiateTargetInfo              = 0x00800000
+	ntlmNegotiate128                     = 0x20000000
+	ntlmNegotiate56                      = 0x80000000
+)
+
+const ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSessionSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+
+// ntlmAvTimestamp is the ID of the attribute of the target information holding the time on the server, which must be used in place of the time on the client when it is given.
+const ntlmAvTimestamp = 7
+
+// windowsEpochOffset is the number of 100 nanosecond intervals between the Windows epoch of 1601 and the Unix epoch.
+const windowsEpochOffset = 116444736000000000
+
+const errorInvalidNTLMChallenge = "The proxy sent an NTLM challenge that could not be understood."
+
+// ntlmAuthenticator authenticates with NTLMv2, as described in [MS-NLMP], using a username and password rather than the credentials of the logged-on user.
+type ntlmAuthenticator struct {
+	domain   string
+	user     string
+	password string
+	legs     int
+	// now and clientChallenge are only replaced by tests, which need fixed values to compare against.
+	now             func() time.Time
+	clientChallenge func() ([]byte, error)
+}
+
+// newNTLMAuthenticator takes a username either in the form DOMAIN\user or as user@domain, which Windows accepts without a separate domain.
+func newNTLMAuthenticator(username string, password string) *ntlmAuthenticator {
+	domain := ""
+	user := username
+	if index := strings.Index(username, `\`); index >= 0 {
+		domain = username[:index]
+		user = username[index+1:]
+	}
+	return &ntlmAuthenticator{
+		domain:   domain,
+		user:     user,
+		password: password,
+		now:      time.Now,
+		clientChallenge: func() ([]byte, error) {
+			challenge := make([]byte, 8)
+			_, err := rand.Read(challenge)
+			return challenge, err
+		},
+	}
+}
+
+func (authenticator *ntlmAuthenticator) next(challenge []byte) ([]byte, error) {
+	authenticator.legs++
+	switch authenticator.legs {
+	case 1:
+		return ntlmNegotiate(), nil
+	case 2:
+		return authenticator.authenticate(challenge)
+	default:
+		return nil, usererrors.New(errorProxyCredentialsRejected)
+	}
+}
+
+func (authenticator *ntlmAuthenticator) close() {}
+
+func ntlmNegotiate() []byte {
+	message := make([]byte, 32)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], ntlmNegotiateMessage)
+	binary.LittleEndian.PutUint32(message[12:], ntlmNegotiateFlags)
+	return message
+}
+
+func (authenticator *ntlmAuthenticator) authenticate(challenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || string(challenge[:8]) != ntlmSignature || binary.LittleEndian.Uint32(challenge[8:]) != ntlmChallengeMessage {
+		return nil, usererrors.New(errorInvalidNTLMChallenge)
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	if flags&ntlmNegotiateUnicode == 0 {
+		return nil, usererrors.New("The proxy does not support Unicode NTLM messages.")
+	}
+	serverChallenge := challenge[24:32]
+	targetInfo, ok := ntlmField(challenge, 40)
+	if !ok {
+		return nil, usererrors.New(errorInvalidNTLMChallenge)
+	}
+	clientChallenge, err := authenticator.clientChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, hasTimestamp := ntlmTimestamp(targetInfo)
+	if !hasTimestamp {
+		timestamp = make([]byte, 8)
+		now := authenticator.now()
+		binary.LittleEndian.PutUint64(timestamp, uint64(now.Unix()*10000000+int64(now.Nanosecond()/100)+windowsEpochOffset))
+	}
+	key := ntlmResponseKey(authenticator.user, authenticator.domain, authenticator.password)
+	ntResponse := ntlmV2Response(key, serverChallenge, clientChallenge, timestamp, targetInfo)
+	// When the server gives its time, it expects no LMv2 response, which would not be bound to the time.
+	lmResponse := make([]byte, 24)
+	if !hasTimestamp {
+		lmResponse = append(ntlmHMAC(key, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	fields := [][]byte{lmResponse, ntResponse, utf16LE(authenticator.domain), utf16LE(authenticator.user), {}, {}}
+	message := make([]byte, 64)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], ntlmAuthenticateMessage)
+	offset := len(message)
+	for index, field := range fields {
+		header := message[12+index*8:]
+		binary.LittleEndian.PutUint16(header, uint16(len(field)))
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(header[4:], uint32(offset))
+		offset += len(field)
+	}
+	binary.LittleEndian.PutUint32(message[60:], flags&ntlmNegotiateFlags)
+	for _, field := range fields {
+		message = append(message, field...)
+	}
+	return message, nil
+}
+
+// ntlmField returns the contents of the field of the message whose length and offset are at the given position.
+func ntlmField(message []byte, position int) ([]byte, bool) {
+	length := int(binary.LittleEndian.Uint16(message[position:]))
+	offset := int(binary.LittleEndian.Uint32(message[position+4:]))
+	if offset < 0 || offset+length > len(message) {
+		return nil, false
+	}
+	return message[offset : offset+length], true
+}
+
+// ntlmTimestamp finds the time on the server in the target information of a challenge.
+func ntlmTimestamp(targetInfo []byte) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if id == 0 || 4+length > len(targetInfo) {
+			break
+		}
+		if id == ntlmAvTimestamp && length == 8 {
+			return targetInfo[4:12], true
+		}
+		targetInfo = targetInfo[4+length:]
+	}
+	return nil, false
+}
+
+// ntlmResponseKey is the NTOWFv2 function of [MS-NLMP], which derives the key used for NTLMv2 responses from the password.
+func ntlmResponseKey(user string, domain string, password string) []byte {
+	hash := md4.New()
+	hash.Write(utf16LE(password))
+	return ntlmHMAC(hash.Sum(nil), utf16LE(strings.ToUpper(user)+domain))
+}
+
+func ntlmV2Response(key []byte, serverChallenge []byte, clientChallenge []byte, timestamp []byte, targetInfo []byte) []byte {
+	temp := bytes.Buffer{}
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(targetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+	proof := ntlmHMAC(key, serverChallenge, temp.Bytes())
+	return append(proof, temp.Bytes()...)
+}
+
+func ntlmHMAC(key []byte, messages ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, message := range messages {
+		mac.Write(message)
+	}
+	return mac.Sum(nil)
+}
+
+func utf16LE(value string) []byte {
+	units := utf16.Encode([]rune(value))
+	encoded := make([]byte, len(units)*2)
+	for index, unit := range units {
+		binary.LittleEndian.PutUint16(encoded[index*2:], unit)
+	}
+	return encoded
+}
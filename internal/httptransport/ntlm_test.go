@@ -0,0 +1,109 @@
+package httptransport
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The values in these tests are from section 4.2.4 of [MS-NLMP].
+var ntlmTestServerChallenge = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+var ntlmTestClientChallenge = []byte{0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}
+
+func ntlmTestTargetInfo() []byte {
+	targetInfo := []byte{}
+	for _, pair := range []struct {
+		id    uint16
+		value string
+	}{{2, "Domain"}, {1, "Server"}} {
+		value := utf16LE(pair.value)
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint16(header, pair.id)
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+		targetInfo = append(append(targetInfo, header...), value...)
+	}
+	return append(targetInfo, 0, 0, 0, 0)
+}
+
+// ntlmTestChallenge makes a challenge message as a proxy would send it.
+func ntlmTestChallenge(targetInfo []byte) []byte {
+	message := make([]byte, 48)
+	copy(message, ntlmSignature)
+	binary.LittleEndian.PutUint32(message[8:], ntlmChallengeMessage)
+	binary.LittleEndian.PutUint32(message[20:], ntlmNegotiateFlags)
+	copy(message[24:], ntlmTestServerChallenge)
+	binary.LittleEndian.PutUint16(message[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(message[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(message[44:], 48)
+	return append(message, targetInfo...)
+}
+
+func TestNTLMResponseKey(t *testing.T) {
+	require.Equal(t, "0c868a403bfd7a93a3001ef22ef02e3f", hex.EncodeToString(ntlmResponseKey("User", "Domain", "Password")))
+}
+
+func TestNTLMV2Response(t *testing.T) {
+	key := ntlmResponseKey("User", "Domain", "Password")
+	response := ntlmV2Response(key, ntlmTestServerChallenge, ntlmTestClientChallenge, make([]byte, 8), ntlmTestTargetInfo())
+	require.Equal(t, "68cd0ab851e51c96aabc927bebef6a1c", hex.EncodeToString(response[:16]))
+}
+
+func TestNTLMAuthenticator(t *testing.T) {
+	authenticator := newNTLMAuthenticator(`Domain\User`, "Password")
+	// The time in the test values is the Windows epoch.
+	authenticator.now = func() time.Time { return time.Unix(-windowsEpochOffset/10000000, 0) }
+	authenticator.clientChallenge = func() ([]byte, error) { return ntlmTestClientChallenge, nil }
+
+	negotiate, err := authenticator.next(nil)
+	require.NoError(t, err)
+	require.Equal(t, ntlmSignature, string(negotiate[:8]))
+	require.Equal(t, uint32(ntlmNegotiateMessage), binary.LittleEndian.Uint32(negotiate[8:]))
+
+	authenticate, err := authenticator.next(ntlmTestChallenge(ntlmTestTargetInfo()))
+	require.NoError(t, err)
+	require.Equal(t, uint32(ntlmAuthenticateMessage), binary.LittleEndian.Uint32(authenticate[8:]))
+	domain, ok := ntlmField(authenticate, 28)
+	require.True(t, ok)
+	require.Equal(t, utf16LE("Domain"), domain)
+	user, ok := ntlmField(authenticate, 36)
+	require.True(t, ok)
+	require.Equal(t, utf16LE("User"), user)
+	lmResponse, ok := ntlmField(authenticate, 12)
+	require.True(t, ok)
+	require.Equal(t, "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa", hex.EncodeToString(lmResponse))
+	ntResponse, ok := ntlmField(authenticate, 20)
+	require.True(t, ok)
+	require.Equal(t, "68cd0ab851e51c96aabc927bebef6a1c", hex.EncodeToString(ntResponse[:16]))
+
+	_, err = authenticator.next(ntlmTestChallenge(ntlmTestTargetInfo()))
+	require.EqualError(t, err, errorProxyCredentialsRejected)
+}
+
+func TestNTLMAuthenticatorUsesServerTime(t *testing.T) {
+	authenticator := newNTLMAuthenticator("user@example.com", "Password")
+	_, err := authenticator.next(nil)
+	require.NoError(t, err)
+	timestamp := []byte{7, 0, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0}
+	authenticate, err := authenticator.next(ntlmTestChallenge(timestamp))
+	require.NoError(t, err)
+	lmResponse, ok := ntlmField(authenticate, 12)
+	require.True(t, ok)
+	require.Equal(t, make([]byte, 24), lmResponse)
+	ntResponse, ok := ntlmField(authenticate, 20)
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, ntResponse[24:32])
+	domain, ok := ntlmField(authenticate, 28)
+	require.True(t, ok)
+	require.Empty(t, domain)
+}
+
+func TestNTLMAuthenticatorInvalidChallenge(t *testing.T) {
+	authenticator := newNTLMAuthenticator("user", "password")
+	_, err := authenticator.next(nil)
+	require.NoError(t, err)
+	_, err = authenticator.next([]byte("Not a challenge."))
+	require.EqualError(t, err, errorInvalidNTLMChallenge)
+}
@@ -0,0 +1,142 @@
+package httptransport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const errorPinMismatch = "The certificate of %s does not match any of the pins given with `--destination-pin`, so the connection was refused. If the certificate has been replaced, update the pins."
+const errorInvalidPin = "The pin %s given with `--destination-pin` is not valid. It should be sha256/ followed by the Base64 SHA-256 hash of a public key, or a file containing PEM certificates."
+
+const spkiPinPrefix = "sha256/"
+
+// installed is the transport configured by Install, which stays underneath any wrappers that replace the default transport.
+var installed *http.Transport
+
+// pins are the public keys and certificates a connection may present. A public key pin matches any certificate in a verified chain with that key, so it can pin an intermediate CA rather than the server itself. A certificate pin only matches the certificate the server presents.
+type pins struct {
+	publicKeys   [][]byte
+	certificates [][]byte
+}
+
+func parsePins(values []string) (pins, error) {
+	parsed := pins{}
+	for _, value := range values {
+		if strings.HasPrefix(value, spkiPinPrefix) {
+			// curl writes pins as sha256//, so both forms are accepted.
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimPrefix(value, spkiPinPrefix), "/"))
+			if err != nil || len(hash) != sha256.Size {
+				return pins{}, fmt.Errorf(errorInvalidPin, value)
+			}
+			parsed.publicKeys = append(parsed.publicKeys, hash)
+			continue
+		}
+		content, err := ioutil.ReadFile(value)
+		if err != nil {
+			return pins{}, errors.Wrapf(err, "Error reading pinned certificate %s.", value)
+		}
+		found := false
+		for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+				return pins{}, errors.Wrapf(err, "Error parsing pinned certificate %s.", value)
+			}
+			hash := sha256.Sum256(block.Bytes)
+			parsed.certificates = append(parsed.certificates, hash[:])
+			found = true
+		}
+		if !found {
+			return pins{}, fmt.Errorf(errorInvalidPin, value)
+		}
+	}
+	return parsed, nil
+}
+
+func containsHash(hashes [][]byte, hash []byte) bool {
+	for _, candidate := range hashes {
+		if bytes.Equal(candidate, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a verified connection presents a pinned certificate or public key. Only the certificate the server presents first is compared against certificate pins, as a server can send any other certificate without having its key.
+func (pins pins) matches(state tls.ConnectionState) bool {
+	if len(state.PeerCertificates) != 0 {
+		hash := sha256.Sum256(state.PeerCertificates[0].Raw)
+		if containsHash(pins.certificates, hash[:]) {
+			return true
+		}
+	}
+	for _, chain := range state.VerifiedChains {
+		for _, certificate := range chain {
+			hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+			if containsHash(pins.publicKeys, hash[:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesHost reports whether a server name is the host or one of its subdomains, such as the `uploads` and `containers` subdomains of GitHub Enterprise Server.
+func matchesHost(serverName string, host string) bool {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	host = strings.ToLower(host)
+	return serverName == host || strings.HasSuffix(serverName, "."+host)
+}
+
+func (pins pins) apply(transport *http.Transport, host string) {
+	config := &tls.Config{}
+	if transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	}
+	verifyConnection := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verifyConnection != nil {
+			err := verifyConnection(state)
+			if err != nil {
+				return err
+			}
+		}
+		if matchesHost(state.ServerName, host) && !pins.matches(state) {
+			return fmt.Errorf(errorPinMismatch, state.ServerName)
+		}
+		return nil
+	}
+	transport.TLSClientConfig = config
+}
+
+// Pin makes connections to a host and its subdomains fail unless they present one of the pinned certificates or public keys, in addition to the usual verification against the trusted CAs. Each pin is either `sha256/` followed by the Base64 SHA-256 hash of a DER-encoded public key, or a file of PEM certificates. It must be called after Install.
+func Pin(host string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	parsed, err := parsePins(values)
+	if err != nil {
+		return err
+	}
+	transport := installed
+	if transport == nil {
+		var ok bool
+		transport, ok = http.DefaultTransport.(*http.Transport)
+		if !ok {
+			return errors.New("The default HTTP transport has already been replaced, so certificates cannot be pinned.")
+		}
+	}
+	parsed.apply(transport, host)
+	return nil
+}
@@ -0,0 +1,86 @@
+package httptransport
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+// getPinnedClient returns a client that trusts the test server and sends every request to it, whatever its host.
+func getPinnedClient(t *testing.T, server *httptest.Server, host string, values []string) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+	parsed, err := parsePins(values)
+	require.NoError(t, err)
+	parsed.apply(transport, host)
+	return &http.Client{Transport: transport}
+}
+
+func spkiPin(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestPinning(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {}))
+	defer server.Close()
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	certificatePath := path.Join(temporaryDirectory, "certificate.pem")
+	require.NoError(t, ioutil.WriteFile(certificatePath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+	otherPin := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	for _, values := range [][]string{{spkiPin(server.Certificate())}, {otherPin, "sha256//" + spkiPin(server.Certificate())[len("sha256/"):]}, {certificatePath}} {
+		client := getPinnedClient(t, server, "example.com", values)
+		response, err := client.Get("https://example.com/")
+		require.NoError(t, err)
+		response.Body.Close()
+	}
+
+	client := getPinnedClient(t, server, "example.com", []string{otherPin})
+	_, err := client.Get("https://example.com/")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "The certificate of example.com does not match any of the pins given with `--destination-pin`")
+
+	// Other hosts are not pinned.
+	client = getPinnedClient(t, server, "ghes.example.org", []string{otherPin})
+	response, err := client.Get("https://example.com/")
+	require.NoError(t, err)
+	response.Body.Close()
+}
+
+func TestMatchesHost(t *testing.T) {
+	require.True(t, matchesHost("ghes.example.com", "ghes.example.com"))
+	require.True(t, matchesHost("containers.GHES.example.com.", "ghes.example.com"))
+	require.False(t, matchesHost("otherghes.example.com", "ghes.example.com"))
+	require.False(t, matchesHost("example.com", "ghes.example.com"))
+}
+
+func TestParsePinsInvalid(t *testing.T) {
+	_, err := parsePins([]string{"sha256/abc"})
+	require.EqualError(t, err, "The pin sha256/abc given with `--destination-pin` is not valid. It should be sha256/ followed by the Base64 SHA-256 hash of a public key, or a file containing PEM certificates.")
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	emptyPath := path.Join(temporaryDirectory, "empty.pem")
+	require.NoError(t, ioutil.WriteFile(emptyPath, []byte("not a certificate"), 0644))
+	_, err = parsePins([]string{emptyPath})
+	require.Error(t, err)
+	_, err = parsePins([]string{path.Join(temporaryDirectory, "missing.pem")})
+	require.Error(t, err)
+}
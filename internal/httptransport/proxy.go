@@ -0,0 +1,118 @@
+package httptransport
+
+import (
+	usererrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+	sshproxy "golang.org/x/net/proxy"
+)
+
+const errorInvalidProxyURL = "The proxy given with `--proxy` must be a URL such as socks5://localhost:1080 or http://proxy.example.com:8080."
+
+// Proxy chooses the proxy that connections are made through. Without a URL, `HTTPS_PROXY` and `HTTP_PROXY` are used as usual, falling back to `ALL_PROXY`, which is often set for SOCKS proxies and which the standard proxy support does not read.
+type Proxy struct {
+	// URL is the proxy that all connections are made through, in place of the proxy environment variables. `NO_PROXY` still applies.
+	URL string
+}
+
+func (proxy Proxy) parse() (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil || proxyURL.Host == "" {
+		return nil, usererrors.New(errorInvalidProxyURL)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return proxyURL, nil
+	}
+	return nil, fmt.Errorf("The proxy given with `--proxy` has the scheme %q, but only http, https, socks5 and socks5h are supported.", proxyURL.Scheme)
+}
+
+func (proxy Proxy) validate() error {
+	if proxy.URL == "" {
+		return nil
+	}
+	_, err := proxy.parse()
+	return err
+}
+
+// socks reports whether the proxy is a SOCKS proxy, which both resolves host names and authenticates itself, with the credentials in its URL.
+func (proxy Proxy) socks() bool {
+	if proxy.URL == "" {
+		return false
+	}
+	proxyURL, err := proxy.parse()
+	return err == nil && (proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h")
+}
+
+func (proxy Proxy) apply(transport *http.Transport) {
+	config := httpproxy.FromEnvironment()
+	if proxy.URL != "" {
+		config.HTTPProxy = proxy.URL
+		config.HTTPSProxy = proxy.URL
+		if proxy.socks() {
+			proxy.applySSH()
+		}
+	} else {
+		allProxy := os.Getenv("ALL_PROXY")
+		if allProxy == "" {
+			allProxy = os.Getenv("all_proxy")
+		}
+		if allProxy == "" {
+			return
+		}
+		if config.HTTPProxy == "" {
+			config.HTTPProxy = allProxy
+		}
+		if config.HTTPSProxy == "" {
+			config.HTTPSProxy = allProxy
+		}
+	}
+	proxyFunc := config.ProxyFunc()
+	transport.Proxy = func(request *http.Request) (*url.URL, error) {
+		return proxyFunc(request.URL)
+	}
+}
+
+// applySSH makes Git over SSH connect through the SOCKS proxy. Go-git does not use the transport for SSH and has no way to be given a dialer: it always dials through the proxy that `golang.org/x/net/proxy` reads from `ALL_PROXY`. That package reads the variable only once in the life of the process, so it is set just long enough to be read and then put back as it was, so that the rest of the process and any child processes still see the environment they were given. Because the value is kept, the proxy applies to every SSH connection the process makes, and this must happen before any Git operation over SSH.
+func (proxy Proxy) applySSH() {
+	previous, set := os.LookupEnv("ALL_PROXY")
+	os.Setenv("ALL_PROXY", proxy.URL)
+	sshproxy.FromEnvironment()
+	if set {
+		os.Setenv("ALL_PROXY", previous)
+	} else {
+		os.Unsetenv("ALL_PROXY")
+	}
+}
+
+// ProxyFor returns the proxy that a request to a URL is made through, or nil if it is made directly. Any password in the URL of the proxy is removed, so that it can be shown.
+func ProxyFor(requestURL string) (*url.URL, error) {
+	transport := installed
+	if transport == nil {
+		var ok bool
+		transport, ok = http.DefaultTransport.(*http.Transport)
+		if !ok {
+			return nil, nil
+		}
+	}
+	if transport.Proxy == nil {
+		return nil, nil
+	}
+	request, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	proxyURL, err := transport.Proxy(request)
+	if err != nil || proxyURL == nil {
+		return nil, err
+	}
+	shown := *proxyURL
+	if shown.User != nil {
+		shown.User = url.User(shown.User.Username())
+	}
+	return &shown, nil
+}
@@ -0,0 +1,283 @@
+package httptransport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/fips"
+	"github.com/pkg/errors"
+)
+
+const ProxyAuthenticationNTLM = "ntlm"
+const ProxyAuthenticationNegotiate = "negotiate"
+
+const errorProxyCredentialsRejected = "The proxy rejected the credentials the sync tool authenticated with."
+const errorIntegratedProxyAuthenticationNotSupported = "Authenticating with the proxy as the logged-on user, including with Kerberos, is only supported on Windows. Give `--proxy-username` and `--proxy-password` to authenticate with NTLM instead."
+
+// maxProxyAuthenticationLegs bounds the number of times the proxy may challenge a single connection, in case it keeps asking for more.
+const maxProxyAuthenticationLegs = 5
+
+// maxProxyResponseBodySize bounds how much of the body of a challenge from the proxy is read before it is discarded.
+const maxProxyResponseBodySize = 64 * 1024
+
+// ProxyAuthentication is how to authenticate with a proxy that needs Windows-integrated authentication, which the standard proxy support cannot do. NTLM and Negotiate authenticate connections rather than requests, so connections are tunnelled through the proxy with `CONNECT`, which is authenticated before the connection is used.
+type ProxyAuthentication struct {
+	// Scheme is ProxyAuthenticationNTLM or ProxyAuthenticationNegotiate, or empty to use the standard proxy support, which only handles Basic credentials given in the proxy URL.
+	Scheme string
+	// Username is either DOMAIN\user or user@domain. If it is empty, the credentials of the logged-on user are used, which is only supported on Windows.
+	Username string
+	Password string
+}
+
+// proxyAuthenticator produces the tokens sent to the proxy during the handshake for a single connection.
+type proxyAuthenticator interface {
+	// next returns the token to send, given the token the proxy challenged the previous one with, which is nil for the first.
+	next(challenge []byte) ([]byte, error)
+	close()
+}
+
+func (authentication ProxyAuthentication) validate() error {
+	switch authentication.Scheme {
+	case "":
+		if authentication.Username != "" || authentication.Password != "" {
+			return usererrors.New("`--proxy-username` and `--proxy-password` can only be used with `--proxy-auth`.")
+		}
+		return nil
+	case ProxyAuthenticationNTLM, ProxyAuthenticationNegotiate:
+	default:
+		return fmt.Errorf("Unknown proxy authentication scheme %q given with `--proxy-auth`, which must be %s or %s.", authentication.Scheme, ProxyAuthenticationNTLM, ProxyAuthenticationNegotiate)
+	}
+	if authentication.Username == "" {
+		if authentication.Password != "" {
+			return usererrors.New("`--proxy-password` needs `--proxy-username` to be given too.")
+		}
+		if !integratedProxyAuthenticationSupported {
+			return usererrors.New(errorIntegratedProxyAuthenticationNotSupported)
+		}
+		return nil
+	}
+	// The sync tool's own NTLM uses MD4 and MD5, while integrated authentication is done by Windows.
+	return fips.Check("NTLM proxy authentication")
+}
+
+// headerScheme is the name of the scheme in the `Proxy-Authorization` header.
+func (authentication ProxyAuthentication) headerScheme() string {
+	if authentication.Scheme == ProxyAuthenticationNegotiate {
+		return "Negotiate"
+	}
+	return "NTLM"
+}
+
+func (authentication ProxyAuthentication) newAuthenticator(proxyHost string) (proxyAuthenticator, error) {
+	if authentication.Username == "" {
+		return newIntegratedAuthenticator(authentication.headerScheme(), proxyHost)
+	}
+	// Negotiate falls back to NTLM when Kerberos cannot be used, and accepts NTLM tokens in its place.
+	return newNTLMAuthenticator(authentication.Username, authentication.Password), nil
+}
+
+// apply makes the transport connect through the proxy itself, authenticating each connection, in place of the standard proxy support.
+func (authentication ProxyAuthentication) apply(transport *http.Transport) {
+	if authentication.Scheme == "" || transport.Proxy == nil {
+		return
+	}
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	dialer := &proxyDialer{
+		authentication: authentication,
+		transport:      transport,
+		proxy:          transport.Proxy,
+		dial:           dial,
+		timeout:        transport.ResponseHeaderTimeout,
+	}
+	transport.Proxy = nil
+	transport.DialContext = dialer.dialContext
+}
+
+type proxyDialer struct {
+	authentication ProxyAuthentication
+	transport      *http.Transport
+	proxy          func(*http.Request) (*url.URL, error)
+	dial           func(ctx context.Context, network string, address string) (net.Conn, error)
+	// timeout bounds each response from the proxy during the handshake.
+	timeout time.Duration
+}
+
+// dialContext connects to the address through the proxy for it, or directly if it does not need a proxy. The transport does not say whether the connection is for HTTP or HTTPS, so the port is used to choose between `HTTP_PROXY` and `HTTPS_PROXY`.
+func (dialer *proxyDialer) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	scheme := "https"
+	if _, port, err := net.SplitHostPort(address); err == nil && port == "80" {
+		scheme = "http"
+	}
+	proxyURL, err := dialer.proxy(&http.Request{URL: &url.URL{Scheme: scheme, Host: address}})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dialer.dial(ctx, network, address)
+	}
+	conn, err := dialer.dialProxy(ctx, network, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	tunnel, err := dialer.connect(ctx, conn, proxyURL, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+func (dialer *proxyDialer) dialProxy(ctx context.Context, network string, proxyURL *url.URL) (net.Conn, error) {
+	port := proxyURL.Port()
+	switch proxyURL.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return nil, fmt.Errorf("The %s proxy at %s cannot be used with `--proxy-auth`, which only supports HTTP and HTTPS proxies.", proxyURL.Scheme, proxyURL.Host)
+	}
+	conn, err := dialer.dial(ctx, network, net.JoinHostPort(proxyURL.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "http" {
+		return conn, nil
+	}
+	config := &tls.Config{}
+	if dialer.transport.TLSClientConfig != nil {
+		config = dialer.transport.TLSClientConfig.Clone()
+	}
+	config.ServerName = proxyURL.Hostname()
+	config.NextProtos = nil
+	tlsConn := tls.Client(conn, config)
+	if dialer.transport.TLSHandshakeTimeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(dialer.transport.TLSHandshakeTimeout))
+	}
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "Error making a TLS connection to the proxy.")
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// connect asks the proxy to tunnel the connection to the address, authenticating with it as many times as it challenges the connection.
+func (dialer *proxyDialer) connect(ctx context.Context, conn net.Conn, proxyURL *url.URL, address string) (net.Conn, error) {
+	authenticator, err := dialer.authentication.newAuthenticator(proxyURL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	defer authenticator.close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	scheme := dialer.authentication.headerScheme()
+	reader := bufio.NewReader(conn)
+	var challenge []byte
+	for leg := 0; leg < maxProxyAuthenticationLegs; leg++ {
+		token, err := authenticator.next(challenge)
+		if err != nil {
+			return nil, exitcode.WithCode(err, exitcode.Authentication)
+		}
+		request := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: address},
+			Host:   address,
+			Header: http.Header{},
+		}
+		request.Header.Set("Proxy-Authorization", scheme+" "+base64.StdEncoding.EncodeToString(token))
+		if dialer.timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(dialer.timeout))
+		}
+		err = request.Write(conn)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error connecting through the proxy.")
+		}
+		response, err := http.ReadResponse(reader, request)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading the response of the proxy.")
+		}
+		if response.StatusCode == http.StatusOK {
+			conn.SetReadDeadline(time.Time{})
+			if reader.Buffered() > 0 {
+				return &bufferedConn{Conn: conn, reader: reader}, nil
+			}
+			return conn, nil
+		}
+		_, err = io.Copy(ioutil.Discard, io.LimitReader(response.Body, maxProxyResponseBodySize))
+		response.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading the response of the proxy.")
+		}
+		if response.StatusCode != http.StatusProxyAuthRequired {
+			return nil, fmt.Errorf("The proxy refused to connect to %s: %s", address, response.Status)
+		}
+		var offered bool
+		challenge, offered, err = proxyChallenge(response.Header, scheme)
+		if err != nil {
+			return nil, err
+		}
+		if !offered {
+			return nil, exitcode.WithCode(fmt.Errorf("The proxy does not accept %s authentication, only: %s", scheme, strings.Join(response.Header.Values("Proxy-Authenticate"), ", ")), exitcode.Authentication)
+		}
+		if challenge == nil {
+			return nil, exitcode.WithCode(usererrors.New(errorProxyCredentialsRejected), exitcode.Authentication)
+		}
+		if response.Close {
+			return nil, usererrors.New("The proxy closed the connection while authenticating it, so the handshake could not be finished. NTLM and Negotiate need the proxy to keep the connection open.")
+		}
+	}
+	return nil, exitcode.WithCode(usererrors.New(errorProxyCredentialsRejected), exitcode.Authentication)
+}
+
+// proxyChallenge finds the token sent by the proxy for the scheme. A scheme offered without a token means the proxy is asking to start again, having rejected the last token.
+func proxyChallenge(header http.Header, scheme string) ([]byte, bool, error) {
+	for _, value := range header.Values("Proxy-Authenticate") {
+		fields := strings.Fields(value)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], scheme) {
+			continue
+		}
+		if len(fields) == 1 {
+			return nil, true, nil
+		}
+		challenge, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, true, errors.Wrap(err, "Error decoding the challenge of the proxy.")
+		}
+		return challenge, true, nil
+	}
+	return nil, false, nil
+}
+
+// bufferedConn is a tunnelled connection that the start of what the destination sent may already have been read from while reading the response of the proxy.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
@@ -0,0 +1,218 @@
+package httptransport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/stretchr/testify/require"
+)
+
+// ntlmTestProxy is a proxy that only tunnels connections authenticated with NTLMv2 as Domain\User with the password "Password".
+type ntlmTestProxy struct {
+	t        *testing.T
+	listener net.Listener
+	// offered is the value of the `Proxy-Authenticate` header sent to start authentication.
+	offered string
+	mutex   sync.Mutex
+	tunnels int
+}
+
+func startNTLMTestProxy(t *testing.T, offered string) *ntlmTestProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	proxy := &ntlmTestProxy{t: t, listener: listener, offered: offered}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serve(conn)
+		}
+	}()
+	return proxy
+}
+
+func (proxy *ntlmTestProxy) url() *url.URL {
+	return &url.URL{Scheme: "http", Host: proxy.listener.Addr().String()}
+}
+
+func (proxy *ntlmTestProxy) close() {
+	proxy.listener.Close()
+}
+
+func (proxy *ntlmTestProxy) reply(conn net.Conn, status string, header string) {
+	response := "HTTP/1.1 " + status + "\r\n"
+	if header != "" {
+		response += "Proxy-Authenticate: " + header + "\r\n"
+	}
+	conn.Write([]byte(response + "Content-Length: 12\r\n\r\nUnavailable."))
+}
+
+func (proxy *ntlmTestProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	challenged := false
+	for {
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(request.Header.Get("Proxy-Authorization"))
+		if request.Method != http.MethodConnect || len(fields) != 2 || !strings.EqualFold(fields[0], strings.Fields(proxy.offered)[0]) {
+			proxy.reply(conn, "407 Proxy Authentication Required", proxy.offered)
+			continue
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[1])
+		require.NoError(proxy.t, err)
+		if !challenged {
+			require.Equal(proxy.t, ntlmNegotiate(), token)
+			challenged = true
+			proxy.reply(conn, "407 Proxy Authentication Required", fields[0]+" "+base64.StdEncoding.EncodeToString(ntlmTestChallenge(ntlmTestTargetInfo())))
+			continue
+		}
+		if !proxy.verify(token) {
+			proxy.reply(conn, "407 Proxy Authentication Required", proxy.offered)
+			return
+		}
+		target, err := net.Dial("tcp", request.Host)
+		require.NoError(proxy.t, err)
+		defer target.Close()
+		proxy.mutex.Lock()
+		proxy.tunnels++
+		proxy.mutex.Unlock()
+		conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+		go io.Copy(target, reader)
+		io.Copy(conn, target)
+		return
+	}
+}
+
+// verify checks the NTLMv2 response in the authenticate message in the same way as a domain controller would.
+func (proxy *ntlmTestProxy) verify(token []byte) bool {
+	user, ok := ntlmField(token, 36)
+	if !ok || !bytes.Equal(user, utf16LE("User")) {
+		return false
+	}
+	ntResponse, ok := ntlmField(token, 20)
+	if !ok || len(ntResponse) < 16 {
+		return false
+	}
+	key := ntlmResponseKey("User", "Domain", "Password")
+	return bytes.Equal(ntResponse[:16], ntlmHMAC(key, ntlmTestServerChallenge, ntResponse[16:]))
+}
+
+func (proxy *ntlmTestProxy) tunnelCount() int {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	return proxy.tunnels
+}
+
+func getThroughProxy(t *testing.T, proxy *ntlmTestProxy, authentication ProxyAuthentication, target string) (string, error) {
+	transport := &http.Transport{Proxy: http.ProxyURL(proxy.url())}
+	authentication.apply(transport)
+	defer transport.CloseIdleConnections()
+	response, err := (&http.Client{Transport: transport}).Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	return string(body), nil
+}
+
+func TestProxyAuthenticationNTLM(t *testing.T) {
+	proxy := startNTLMTestProxy(t, "NTLM")
+	defer proxy.close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("Through the proxy."))
+	}))
+	defer server.Close()
+
+	transport := server.Client().Transport.(*http.Transport)
+	transport.Proxy = http.ProxyURL(proxy.url())
+	ProxyAuthentication{Scheme: ProxyAuthenticationNTLM, Username: `Domain\User`, Password: "Password"}.apply(transport)
+	require.Nil(t, transport.Proxy)
+	for i := 0; i < 2; i++ {
+		response, err := server.Client().Get(server.URL)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, "Through the proxy.", string(body))
+	}
+	// The authenticated connection is reused rather than authenticated again.
+	require.Equal(t, 1, proxy.tunnelCount())
+}
+
+func TestProxyAuthenticationNegotiate(t *testing.T) {
+	proxy := startNTLMTestProxy(t, "Negotiate")
+	defer proxy.close()
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("Through the proxy."))
+	}))
+	defer server.Close()
+
+	body, err := getThroughProxy(t, proxy, ProxyAuthentication{Scheme: ProxyAuthenticationNegotiate, Username: `Domain\User`, Password: "Password"}, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "Through the proxy.", body)
+}
+
+func TestProxyAuthenticationRejected(t *testing.T) {
+	proxy := startNTLMTestProxy(t, "NTLM")
+	defer proxy.close()
+
+	_, err := getThroughProxy(t, proxy, ProxyAuthentication{Scheme: ProxyAuthenticationNTLM, Username: `Domain\User`, Password: "Wrong"}, "https://github.com")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), errorProxyCredentialsRejected)
+	require.Equal(t, exitcode.Authentication, exitcode.Code(err))
+	require.Equal(t, 0, proxy.tunnelCount())
+}
+
+func TestProxyAuthenticationSchemeNotOffered(t *testing.T) {
+	proxy := startNTLMTestProxy(t, `Basic realm="proxy"`)
+	defer proxy.close()
+
+	_, err := getThroughProxy(t, proxy, ProxyAuthentication{Scheme: ProxyAuthenticationNTLM, Username: `Domain\User`, Password: "Password"}, "https://github.com")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `The proxy does not accept NTLM authentication, only: Basic realm="proxy"`)
+}
+
+func TestProxyAuthenticationWithoutProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("Directly."))
+	}))
+	defer server.Close()
+
+	transport := &http.Transport{Proxy: func(*http.Request) (*url.URL, error) { return nil, nil }}
+	ProxyAuthentication{Scheme: ProxyAuthenticationNTLM, Username: `Domain\User`, Password: "Password"}.apply(transport)
+	response, err := (&http.Client{Transport: transport}).Get(server.URL)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Directly.", string(body))
+}
+
+func TestProxyAuthenticationValidate(t *testing.T) {
+	require.NoError(t, ProxyAuthentication{}.validate())
+	require.NoError(t, ProxyAuthentication{Scheme: ProxyAuthenticationNTLM, Username: "user@example.com", Password: "password"}.validate())
+	require.EqualError(t, ProxyAuthentication{Username: "user"}.validate(), "`--proxy-username` and `--proxy-password` can only be used with `--proxy-auth`.")
+	require.EqualError(t, ProxyAuthentication{Scheme: "basic"}.validate(), "Unknown proxy authentication scheme \"basic\" given with `--proxy-auth`, which must be ntlm or negotiate.")
+	require.EqualError(t, ProxyAuthentication{Scheme: ProxyAuthenticationNegotiate, Password: "password"}.validate(), "`--proxy-password` needs `--proxy-username` to be given too.")
+	if !integratedProxyAuthenticationSupported {
+		require.EqualError(t, ProxyAuthentication{Scheme: ProxyAuthenticationNegotiate}.validate(), errorIntegratedProxyAuthenticationNotSupported)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package httptransport
+
+import usererrors "errors"
+
+const integratedProxyAuthenticationSupported = false
+
+func newIntegratedAuthenticator(scheme string, proxyHost string) (proxyAuthenticator, error) {
+	return nil, usererrors.New(errorIntegratedProxyAuthenticationNotSupported)
+}
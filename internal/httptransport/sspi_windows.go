@@ -0,0 +1,155 @@
+//go:build windows
+// +build windows
+
+package httptransport
+
+import (
+	usererrors "errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const integratedProxyAuthenticationSupported = true
+
+const (
+	secpkgCredOutbound      = 2
+	securityNativeDrep      = 0x10
+	iscReqAllocateMemory    = 0x100
+	iscReqConnection        = 0x800
+	secbufferVersion        = 0
+	secbufferToken          = 2
+	secEOK                  = 0
+	secIContinueNeeded      = 0x00090312
+	secICompleteNeeded      = 0x00090313
+	secICompleteAndContinue = 0x00090314
+)
+
+var (
+	secur32                        = windows.NewLazySystemDLL("secur32.dll")
+	procAcquireCredentialsHandleW  = secur32.NewProc("AcquireCredentialsHandleW")
+	procInitializeSecurityContextW = secur32.NewProc("InitializeSecurityContextW")
+	procCompleteAuthToken          = secur32.NewProc("CompleteAuthToken")
+	procDeleteSecurityContext      = secur32.NewProc("DeleteSecurityContext")
+	procFreeCredentialsHandle      = secur32.NewProc("FreeCredentialsHandle")
+	procFreeContextBuffer          = secur32.NewProc("FreeContextBuffer")
+)
+
+type secHandle struct {
+	lower uintptr
+	upper uintptr
+}
+
+type secBuffer struct {
+	size       uint32
+	bufferType uint32
+	buffer     *byte
+}
+
+type secBufferDesc struct {
+	version uint32
+	count   uint32
+	buffers *secBuffer
+}
+
+// sspiAuthenticator authenticates as the logged-on user with the Security Support Provider Interface of Windows, which uses Kerberos for Negotiate where it can and NTLM otherwise.
+type sspiAuthenticator struct {
+	target      *uint16
+	credentials secHandle
+	context     secHandle
+	hasContext  bool
+	complete    bool
+}
+
+func newIntegratedAuthenticator(scheme string, proxyHost string) (proxyAuthenticator, error) {
+	target, err := windows.UTF16PtrFromString("HTTP/" + proxyHost)
+	if err != nil {
+		return nil, err
+	}
+	securityPackage, err := windows.UTF16PtrFromString(scheme)
+	if err != nil {
+		return nil, err
+	}
+	authenticator := &sspiAuthenticator{target: target}
+	var expiry int64
+	status, _, _ := procAcquireCredentialsHandleW.Call(
+		0,
+		uintptr(unsafe.Pointer(securityPackage)),
+		secpkgCredOutbound,
+		0,
+		0,
+		0,
+		0,
+		uintptr(unsafe.Pointer(&authenticator.credentials)),
+		uintptr(unsafe.Pointer(&expiry)),
+	)
+	if status != secEOK {
+		return nil, fmt.Errorf("Windows could not get the credentials of the logged-on user for %s proxy authentication (error 0x%08x).", scheme, uint32(status))
+	}
+	return authenticator, nil
+}
+
+func (authenticator *sspiAuthenticator) next(challenge []byte) ([]byte, error) {
+	if authenticator.complete {
+		return nil, usererrors.New(errorProxyCredentialsRejected)
+	}
+	var input *secBufferDesc
+	if len(challenge) > 0 {
+		input = &secBufferDesc{
+			version: secbufferVersion,
+			count:   1,
+			buffers: &secBuffer{size: uint32(len(challenge)), bufferType: secbufferToken, buffer: &challenge[0]},
+		}
+	}
+	outputBuffer := secBuffer{bufferType: secbufferToken}
+	output := &secBufferDesc{version: secbufferVersion, count: 1, buffers: &outputBuffer}
+	var context uintptr
+	if authenticator.hasContext {
+		context = uintptr(unsafe.Pointer(&authenticator.context))
+	}
+	var attributes uint32
+	var expiry int64
+	status, _, _ := procInitializeSecurityContextW.Call(
+		uintptr(unsafe.Pointer(&authenticator.credentials)),
+		context,
+		uintptr(unsafe.Pointer(authenticator.target)),
+		iscReqAllocateMemory|iscReqConnection,
+		0,
+		securityNativeDrep,
+		uintptr(unsafe.Pointer(input)),
+		0,
+		uintptr(unsafe.Pointer(&authenticator.context)),
+		uintptr(unsafe.Pointer(output)),
+		uintptr(unsafe.Pointer(&attributes)),
+		uintptr(unsafe.Pointer(&expiry)),
+	)
+	if outputBuffer.buffer != nil {
+		defer procFreeContextBuffer.Call(uintptr(unsafe.Pointer(outputBuffer.buffer)))
+	}
+	switch status {
+	case secEOK, secIContinueNeeded:
+	case secICompleteNeeded, secICompleteAndContinue:
+		completeStatus, _, _ := procCompleteAuthToken.Call(uintptr(unsafe.Pointer(&authenticator.context)), uintptr(unsafe.Pointer(output)))
+		if completeStatus != secEOK {
+			return nil, fmt.Errorf("Windows could not complete the token for proxy authentication (error 0x%08x).", uint32(completeStatus))
+		}
+	default:
+		return nil, fmt.Errorf("Windows could not make a token for proxy authentication (error 0x%08x).", uint32(status))
+	}
+	authenticator.hasContext = true
+	authenticator.complete = status == secEOK || status == secICompleteNeeded
+	if outputBuffer.buffer == nil {
+		return []byte{}, nil
+	}
+	token := make([]byte, outputBuffer.size)
+	copy(token, (*[1 << 30]byte)(unsafe.Pointer(outputBuffer.buffer))[:outputBuffer.size:outputBuffer.size])
+	return token, nil
+}
+
+func (authenticator *sspiAuthenticator) close() {
+	if authenticator.hasContext {
+		procDeleteSecurityContext.Call(uintptr(unsafe.Pointer(&authenticator.context)))
+	}
+	procFreeCredentialsHandle.Call(uintptr(unsafe.Pointer(&authenticator.credentials)))
+}
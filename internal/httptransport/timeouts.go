@@ -0,0 +1,75 @@
+package httptransport
+
+import (
+	"context"
+	usererrors "errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const DefaultConnect = 30 * time.Second
+const DefaultTLSHandshake = 10 * time.Second
+
+// DefaultResponseHeader is how long to wait for a response once a request has been sent. This does not include the time to send or receive the body, so it does not cut off large transfers, but stops a proxy that accepts connections and never answers from hanging a run forever.
+const DefaultResponseHeader = 5 * time.Minute
+
+// Timeouts bound how long each stage of an HTTP request may take. A zero timeout means no limit.
+type Timeouts struct {
+	Connect        time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	// Request bounds the whole of a request, including sending and receiving its body.
+	Request time.Duration
+}
+
+// requestTimeoutTransport gives each request a deadline that lasts until its response body has been read and closed.
+type requestTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelOnClose) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
+
+func (transport *requestTimeoutTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(request.Context(), transport.timeout)
+	response, err := transport.base.RoundTrip(request.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+func (timeouts Timeouts) validate() error {
+	if timeouts.Connect < 0 || timeouts.TLSHandshake < 0 || timeouts.ResponseHeader < 0 || timeouts.Request < 0 {
+		return usererrors.New("Timeouts given with `--connect-timeout`, `--tls-handshake-timeout`, `--response-header-timeout` and `--request-timeout` cannot be negative.")
+	}
+	return nil
+}
+
+// apply sets the timeouts on the transport, returning the transport to use in its place if the whole of each request is limited.
+func (timeouts Timeouts) apply(transport *http.Transport) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   timeouts.Connect,
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+	if timeouts.Request > 0 {
+		return &requestTimeoutTransport{base: transport, timeout: timeouts.Request}
+	}
+	return transport
+}
@@ -0,0 +1,157 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/pkg/errors"
+)
+
+const mediaType = "application/vnd.git-lfs+json"
+
+// batchSize is how many objects are asked about in each request to the batch API, which is the most Git LFS asks about at once.
+const batchSize = 100
+
+// Endpoint returns the Git LFS endpoint of a repository from its Git URL, in the same way as Git LFS.
+func Endpoint(gitURL string) string {
+	gitURL = strings.TrimRight(gitURL, "/")
+	if !strings.HasSuffix(gitURL, ".git") {
+		gitURL += ".git"
+	}
+	return gitURL + "/info/lfs"
+}
+
+type action struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+type batchObject struct {
+	Pointer
+	Actions map[string]action `json:"actions"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type client struct {
+	ctx        context.Context
+	httpClient *http.Client
+	endpoint   string
+	token      string
+}
+
+func checkResponse(response *http.Response, activity string, expected ...int) error {
+	for _, status := range expected {
+		if response.StatusCode == status {
+			return nil
+		}
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	errorResponse := struct {
+		Message string `json:"message"`
+	}{}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &errorResponse) == nil && errorResponse.Message != "" {
+		message = errorResponse.Message
+	}
+	err := fmt.Errorf("Error %s, as the Git LFS server returned %s.", activity, response.Status)
+	if message != "" {
+		err = fmt.Errorf("Error %s, as the Git LFS server returned %s: %s", activity, response.Status, message)
+	}
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		return exitcode.WithCode(err, exitcode.Authentication)
+	}
+	return err
+}
+
+// send sends a request to a location given by the batch API, with the headers it gave.
+func (client *client) send(method string, action action, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	request, err := http.NewRequest(method, action.Href, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating Git LFS request.")
+	}
+	request = request.WithContext(client.ctx)
+	for name, value := range action.Header {
+		request.Header.Set(name, value)
+	}
+	if body != nil {
+		request.ContentLength = size
+		if request.Header.Get("Content-Type") == "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error connecting to Git LFS server.")
+	}
+	return response, nil
+}
+
+// batch asks the batch API where to download or upload objects, with operation `download` or `upload`.
+func (client *client) batch(operation string, pointers []Pointer) ([]batchObject, error) {
+	objects := []batchObject{}
+	requested := map[Pointer]bool{}
+	for _, pointer := range pointers {
+		requested[pointer] = true
+	}
+	for start := 0; start < len(pointers); start += batchSize {
+		end := start + batchSize
+		if end > len(pointers) {
+			end = len(pointers)
+		}
+		body, err := json.Marshal(map[string]interface{}{
+			"operation": operation,
+			"transfers": []string{"basic"},
+			"objects":   pointers[start:end],
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "Error converting Git LFS request to JSON.")
+		}
+		request, err := http.NewRequest(http.MethodPost, client.endpoint+"/objects/batch", bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "Error creating Git LFS request.")
+		}
+		request = request.WithContext(client.ctx)
+		request.Header.Set("Accept", mediaType)
+		request.Header.Set("Content-Type", mediaType)
+		if client.token != "" {
+			request.SetBasicAuth("x-access-token", client.token)
+		}
+		response, err := client.httpClient.Do(request)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error connecting to Git LFS server.")
+		}
+		err = checkResponse(response, "asking where to "+operation+" Git LFS objects", http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		batchResponse := struct {
+			Objects []batchObject `json:"objects"`
+		}{}
+		err = json.NewDecoder(response.Body).Decode(&batchResponse)
+		response.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading Git LFS response.")
+		}
+		for _, object := range batchResponse.Objects {
+			if !requested[object.Pointer] {
+				return nil, fmt.Errorf("The Git LFS server replied about object %s, which was not asked about.", object.OID)
+			}
+			if object.Error != nil {
+				return nil, fmt.Errorf("The Git LFS server could not %s object %s: %s", operation, object.OID, object.Error.Message)
+			}
+		}
+		objects = append(objects, batchResponse.Objects...)
+	}
+	return objects, nil
+}
@@ -0,0 +1,147 @@
+package lfs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "token"
+
+// testServer is a Git LFS server for the repository `owner/repository` that only accepts the token "token", and gives out locations to transfer objects that need their own header.
+type testServer struct {
+	t         *testing.T
+	server    *httptest.Server
+	mutex     sync.Mutex
+	objects   map[string][]byte
+	downloads int
+	uploads   int
+	verified  int
+}
+
+func startTestServer(t *testing.T) *testServer {
+	server := &testServer{t: t, objects: map[string][]byte{}}
+	router := mux.NewRouter()
+	router.HandleFunc("/owner/repository.git/info/lfs/objects/batch", server.serveBatch).Methods(http.MethodPost)
+	router.HandleFunc("/objects/{oid}", server.serveObject).Methods(http.MethodGet, http.MethodPut)
+	router.HandleFunc("/verify", server.serveVerify).Methods(http.MethodPost)
+	server.server = httptest.NewServer(router)
+	return server
+}
+
+func (server *testServer) close() {
+	server.server.Close()
+}
+
+func (server *testServer) gitURL() string {
+	return server.server.URL + "/owner/repository.git"
+}
+
+func (server *testServer) action(path string) action {
+	return action{Href: server.server.URL + path, Header: map[string]string{"Authorization": "RemoteAuth transfer"}}
+}
+
+func (server *testServer) serveBatch(response http.ResponseWriter, request *http.Request) {
+	_, password, _ := request.BasicAuth()
+	if password != testToken {
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte(`{"message": "Bad credentials"}`))
+		return
+	}
+	require.Equal(server.t, mediaType, request.Header.Get("Content-Type"))
+	batchRequest := struct {
+		Operation string    `json:"operation"`
+		Objects   []Pointer `json:"objects"`
+	}{}
+	require.NoError(server.t, json.NewDecoder(request.Body).Decode(&batchRequest))
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	objects := []map[string]interface{}{}
+	for _, pointer := range batchRequest.Objects {
+		_, exists := server.objects[pointer.OID]
+		object := map[string]interface{}{"oid": pointer.OID, "size": pointer.Size}
+		switch {
+		case batchRequest.Operation == "download" && exists:
+			object["actions"] = map[string]action{"download": server.action("/objects/" + pointer.OID)}
+		case batchRequest.Operation == "download":
+			object["error"] = map[string]interface{}{"code": 404, "message": "Object does not exist"}
+		case !exists:
+			object["actions"] = map[string]action{"upload": server.action("/objects/" + pointer.OID), "verify": server.action("/verify")}
+		}
+		objects = append(objects, object)
+	}
+	response.Header().Set("Content-Type", mediaType)
+	json.NewEncoder(response).Encode(map[string]interface{}{"objects": objects})
+}
+
+func (server *testServer) serveObject(response http.ResponseWriter, request *http.Request) {
+	require.Equal(server.t, "RemoteAuth transfer", request.Header.Get("Authorization"))
+	oid := mux.Vars(request)["oid"]
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if request.Method == http.MethodPut {
+		require.Equal(server.t, "application/octet-stream", request.Header.Get("Content-Type"))
+		content, err := ioutil.ReadAll(request.Body)
+		require.NoError(server.t, err)
+		server.objects[oid] = content
+		server.uploads++
+		return
+	}
+	server.downloads++
+	response.Write(server.objects[oid])
+}
+
+func (server *testServer) serveVerify(response http.ResponseWriter, request *http.Request) {
+	require.Equal(server.t, "RemoteAuth transfer", request.Header.Get("Authorization"))
+	pointer := Pointer{}
+	require.NoError(server.t, json.NewDecoder(request.Body).Decode(&pointer))
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	require.Len(server.t, server.objects[pointer.OID], int(pointer.Size))
+	server.verified++
+}
+
+func TestEndpoint(t *testing.T) {
+	require.Equal(t, "https://github.com/github/codeql-action.git/info/lfs", Endpoint("https://github.com/github/codeql-action.git"))
+	require.Equal(t, "https://ghes.example.com/github/codeql-action.git/info/lfs", Endpoint("https://ghes.example.com/github/codeql-action/"))
+}
+
+func TestBatchWrongToken(t *testing.T) {
+	server := startTestServer(t)
+	defer server.close()
+	pointer, _ := pointerFor("content")
+	client := client{ctx: context.Background(), httpClient: http.DefaultClient, endpoint: Endpoint(server.gitURL()), token: "wrong"}
+	_, err := client.batch("download", []Pointer{pointer})
+	require.EqualError(t, err, "Error asking where to download Git LFS objects, as the Git LFS server returned 401 Unauthorized: Bad credentials")
+}
+
+func TestBatchObjectError(t *testing.T) {
+	server := startTestServer(t)
+	defer server.close()
+	pointer, _ := pointerFor("content")
+	client := client{ctx: context.Background(), httpClient: http.DefaultClient, endpoint: Endpoint(server.gitURL()), token: testToken}
+	_, err := client.batch("download", []Pointer{pointer})
+	require.EqualError(t, err, "The Git LFS server could not download object "+pointer.OID+": Object does not exist")
+}
+
+func TestBatchSplitsRequests(t *testing.T) {
+	server := startTestServer(t)
+	defer server.close()
+	pointers := []Pointer{}
+	for i := 0; i < batchSize+1; i++ {
+		pointer, _ := pointerFor(strings.Repeat("a", i))
+		pointers = append(pointers, pointer)
+	}
+	client := client{ctx: context.Background(), httpClient: http.DefaultClient, endpoint: Endpoint(server.gitURL()), token: testToken}
+	objects, err := client.batch("upload", pointers)
+	require.NoError(t, err)
+	require.Len(t, objects, batchSize+1)
+}
@@ -0,0 +1,197 @@
+package lfs
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+const pointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// maximumPointerSize is the largest file Git LFS reads as a pointer.
+const maximumPointerSize = 1024
+
+var oidPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Pointer is what Git LFS stores in the Git repository in place of a file, identifying the file by the SHA-256 hash of its contents.
+type Pointer struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// ParsePointer parses the contents of a file as a Git LFS pointer, returning false if it is not one.
+func ParsePointer(content []byte) (Pointer, bool) {
+	if len(content) > maximumPointerSize || !bytes.HasPrefix(content, []byte(pointerVersion+"\n")) {
+		return Pointer{}, false
+	}
+	pointer := Pointer{Size: -1}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		split := strings.SplitN(scanner.Text(), " ", 2)
+		if len(split) != 2 {
+			return Pointer{}, false
+		}
+		switch split[0] {
+		case "oid":
+			if !strings.HasPrefix(split[1], "sha256:") || !oidPattern.MatchString(strings.TrimPrefix(split[1], "sha256:")) {
+				return Pointer{}, false
+			}
+			pointer.OID = strings.TrimPrefix(split[1], "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(split[1], 10, 64)
+			if err != nil || size < 0 {
+				return Pointer{}, false
+			}
+			pointer.Size = size
+		}
+	}
+	if pointer.OID == "" || pointer.Size < 0 {
+		return Pointer{}, false
+	}
+	return pointer, true
+}
+
+// tracksFiles reports whether a `.gitattributes` file has any patterns that are stored with Git LFS.
+func tracksFiles(attributes string) bool {
+	for _, line := range strings.Split(attributes, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, attribute := range fields[1:] {
+			if attribute == "filter=lfs" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type finder struct {
+	gitRepository *git.Repository
+	seen          map[plumbing.Hash]bool
+	pointers      map[string]Pointer
+}
+
+// commit returns the commit a reference points to, through any annotated tags, or nil if it does not point to a commit.
+func (finder *finder) commit(hash plumbing.Hash) (*object.Commit, error) {
+	for {
+		gitObject, err := finder.gitRepository.Object(plumbing.AnyObject, hash)
+		if err != nil {
+			return nil, err
+		}
+		switch gitObject := gitObject.(type) {
+		case *object.Commit:
+			return gitObject, nil
+		case *object.Tag:
+			hash = gitObject.Target
+		default:
+			return nil, nil
+		}
+	}
+}
+
+func (finder *finder) tracksFiles(tree *object.Tree) (bool, error) {
+	file, err := tree.File(".gitattributes")
+	if err == object.ErrFileNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	attributes, err := file.Contents()
+	if err != nil {
+		return false, err
+	}
+	return tracksFiles(attributes), nil
+}
+
+func (finder *finder) walk(tree *object.Tree) error {
+	for _, entry := range tree.Entries {
+		if finder.seen[entry.Hash] {
+			continue
+		}
+		finder.seen[entry.Hash] = true
+		switch entry.Mode {
+		case filemode.Dir:
+			subtree, err := finder.gitRepository.TreeObject(entry.Hash)
+			if err != nil {
+				return err
+			}
+			err = finder.walk(subtree)
+			if err != nil {
+				return err
+			}
+		case filemode.Regular, filemode.Executable:
+			blob, err := finder.gitRepository.BlobObject(entry.Hash)
+			if err != nil {
+				return err
+			}
+			if blob.Size > maximumPointerSize {
+				continue
+			}
+			reader, err := blob.Reader()
+			if err != nil {
+				return err
+			}
+			content, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+			if pointer, ok := ParsePointer(content); ok {
+				finder.pointers[pointer.OID] = pointer
+			}
+		}
+	}
+	return nil
+}
+
+// FindPointers returns the Git LFS pointers in the branches and tags of a Git repository, sorted by object ID. Only commits whose root `.gitattributes` stores some files with Git LFS are searched, so that a repository that does not use Git LFS is not read in full.
+func FindPointers(gitRepository *git.Repository) ([]Pointer, error) {
+	finder := finder{gitRepository: gitRepository, seen: map[plumbing.Hash]bool{}, pointers: map[string]Pointer{}}
+	references, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() != plumbing.HashReference || !strings.HasPrefix(reference.Name().String(), "refs/") {
+			return nil
+		}
+		commit, err := finder.commit(reference.Hash())
+		if err != nil || commit == nil || finder.seen[commit.TreeHash] {
+			return err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+		tracks, err := finder.tracksFiles(tree)
+		if err != nil || !tracks {
+			return err
+		}
+		finder.seen[commit.TreeHash] = true
+		return finder.walk(tree)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error finding Git LFS files in Git repository cache.")
+	}
+	pointers := []Pointer{}
+	for _, pointer := range finder.pointers {
+		pointers = append(pointers, pointer)
+	}
+	sort.Slice(pointers, func(i, j int) bool {
+		return pointers[i].OID < pointers[j].OID
+	})
+	return pointers, nil
+}
@@ -0,0 +1,94 @@
+package lfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+const testAttributes = "*.zip filter=lfs diff=lfs merge=lfs -text\n"
+
+func pointerFor(content string) (Pointer, string) {
+	hash := sha256.Sum256([]byte(content))
+	pointer := Pointer{OID: hex.EncodeToString(hash[:]), Size: int64(len(content))}
+	return pointer, pointerVersion + "\noid sha256:" + pointer.OID + "\nsize " + strconv.FormatInt(pointer.Size, 10) + "\n"
+}
+
+// commitFiles commits files to a Git repository, creating it if needed.
+func commitFiles(t *testing.T, repositoryPath string, files map[string]string) *git.Repository {
+	repository, err := git.PlainOpen(repositoryPath)
+	if err == git.ErrRepositoryNotExists {
+		repository, err = git.PlainInit(repositoryPath, false)
+	}
+	require.NoError(t, err)
+	worktree, err := repository.Worktree()
+	require.NoError(t, err)
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(repositoryPath, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(repositoryPath, name), []byte(content), 0644))
+		_, err = worktree.Add(name)
+		require.NoError(t, err)
+	}
+	_, err = worktree.Commit("A commit.", &git.CommitOptions{Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}})
+	require.NoError(t, err)
+	return repository
+}
+
+// getTestCacheDirectory creates a cache whose Git repository has the given files.
+func getTestCacheDirectory(t *testing.T, files map[string]string) cachedirectory.CacheDirectory {
+	cacheDirectory := cachedirectory.NewCacheDirectory(filepath.Join(test.CreateTemporaryDirectory(t), "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, version.Version()))
+	commitFiles(t, cacheDirectory.GitPath(), files)
+	return cacheDirectory
+}
+
+func TestParsePointer(t *testing.T) {
+	expected, content := pointerFor("content")
+	pointer, ok := ParsePointer([]byte(content))
+	require.True(t, ok)
+	require.Equal(t, expected, pointer)
+	_, ok = ParsePointer([]byte("content"))
+	require.False(t, ok)
+	_, ok = ParsePointer([]byte(pointerVersion + "\noid sha256:abc\nsize 7\n"))
+	require.False(t, ok)
+	_, ok = ParsePointer([]byte(pointerVersion + "\noid sha256:" + expected.OID + "\n"))
+	require.False(t, ok)
+}
+
+func TestTracksFiles(t *testing.T) {
+	require.True(t, tracksFiles(testAttributes))
+	require.True(t, tracksFiles("\n# Comment\n*.bin   -text filter=lfs\n"))
+	require.False(t, tracksFiles("*.zip -text\n# *.bin filter=lfs\n\n"))
+}
+
+func TestFindPointers(t *testing.T) {
+	first, firstPointer := pointerFor("first")
+	second, secondPointer := pointerFor("second")
+	repositoryPath := filepath.Join(test.CreateTemporaryDirectory(t), "repository")
+	// Without a .gitattributes file that uses Git LFS, a file that looks like a pointer is left alone.
+	repository := commitFiles(t, repositoryPath, map[string]string{"first.zip": firstPointer})
+	pointers, err := FindPointers(repository)
+	require.NoError(t, err)
+	require.Empty(t, pointers)
+
+	repository = commitFiles(t, repositoryPath, map[string]string{".gitattributes": testAttributes, "directory/second.zip": secondPointer, "other.txt": "other"})
+	pointers, err = FindPointers(repository)
+	require.NoError(t, err)
+	expected := []Pointer{first, second}
+	if expected[0].OID > expected[1].OID {
+		expected = []Pointer{second, first}
+	}
+	require.Equal(t, expected, pointers)
+}
@@ -0,0 +1,137 @@
+package lfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/go-git/go-git/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const errorMissingFromDirectory = "The source directory does not have the Git LFS object %s. Run `git lfs fetch --all` in its Git repository, and then pull again."
+
+// isCached returns whether an object is already in the cache. A download that does not match its object ID is removed, and an interrupted one is too small, so only the size needs to be checked here.
+func isCached(cacheDirectory cachedirectory.CacheDirectory, pointer Pointer) (bool, error) {
+	size, err := cacheDirectory.LFSObjectSize(pointer.OID)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Error checking cached Git LFS object.")
+	}
+	return size == pointer.Size, nil
+}
+
+// findPointers finds the Git LFS pointers in the Git repository in the cache.
+func findPointers(cacheDirectory cachedirectory.CacheDirectory) ([]Pointer, error) {
+	gitRepository, err := git.PlainOpen(cacheDirectory.GitPath())
+	if err != nil {
+		return nil, exitcode.WithCode(errors.Wrap(err, "Error reading Git repository from cache."), exitcode.CacheCorrupt)
+	}
+	return FindPointers(gitRepository)
+}
+
+func missingObjects(cacheDirectory cachedirectory.CacheDirectory) ([]Pointer, error) {
+	pointers, err := findPointers(cacheDirectory)
+	if err != nil {
+		return nil, err
+	}
+	missing := []Pointer{}
+	for _, pointer := range pointers {
+		cached, err := isCached(cacheDirectory, pointer)
+		if err != nil {
+			return nil, err
+		}
+		if !cached {
+			missing = append(missing, pointer)
+		}
+	}
+	return missing, nil
+}
+
+// writeObject writes an object into the cache, checking it matches its object ID.
+func writeObject(cacheDirectory cachedirectory.CacheDirectory, pointer Pointer, reader io.Reader) error {
+	hash := sha256.New()
+	err := cacheDirectory.WriteLFSObject(pointer.OID, io.TeeReader(io.LimitReader(reader, pointer.Size), hash), pointer.Size)
+	if err != nil {
+		return errors.Wrap(err, "Error writing Git LFS object to cache.")
+	}
+	if oid := hex.EncodeToString(hash.Sum(nil)); oid != pointer.OID {
+		cacheDirectory.RemoveLFSObject(pointer.OID)
+		return fmt.Errorf("The download of Git LFS object %s does not match its object ID, but was %s.", pointer.OID, oid)
+	}
+	return nil
+}
+
+func (client *client) download(cacheDirectory cachedirectory.CacheDirectory, object batchObject) error {
+	download, ok := object.Actions["download"]
+	if !ok {
+		return fmt.Errorf("The Git LFS server did not say where to download object %s from.", object.OID)
+	}
+	response, err := client.send(http.MethodGet, download, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response, "downloading Git LFS object "+object.OID, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return writeObject(cacheDirectory, object.Pointer, response.Body)
+}
+
+// Pull downloads the Git LFS objects that the branches and tags in the cache refer to, so that they can be pushed to the destination along with the Git repository. Objects already in the cache are not downloaded again, and nothing is done if the Git repository does not use Git LFS.
+func Pull(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, gitURL string, token string) error {
+	missing, err := missingObjects(cacheDirectory)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	log.Infof("Pulling %d Git LFS objects...", len(missing))
+	client := client{ctx: ctx, httpClient: retry.NewClient(nil), endpoint: Endpoint(gitURL), token: token}
+	objects, err := client.batch("download", missing)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		err = client.download(cacheDirectory, object)
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("Finished pulling Git LFS objects!")
+	return nil
+}
+
+// PullFromDirectory copies the Git LFS objects that the branches and tags in the cache refer to from a local Git repository, where Git LFS keeps them in `lfs/objects`.
+func PullFromDirectory(cacheDirectory cachedirectory.CacheDirectory, gitPath string) error {
+	missing, err := missingObjects(cacheDirectory)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	log.Infof("Copying %d Git LFS objects...", len(missing))
+	for _, pointer := range missing {
+		file, err := os.Open(filepath.Join(gitPath, "lfs", "objects", pointer.OID[0:2], pointer.OID[2:4], pointer.OID))
+		if os.IsNotExist(err) {
+			return fmt.Errorf(errorMissingFromDirectory, pointer.OID)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Error reading Git LFS object from source directory.")
+		}
+		err = writeObject(cacheDirectory, pointer, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package lfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPull(t *testing.T) {
+	server := startTestServer(t)
+	defer server.close()
+	pointer, pointerContent := pointerFor("content")
+	server.objects[pointer.OID] = []byte("content")
+	cacheDirectory := getTestCacheDirectory(t, map[string]string{".gitattributes": testAttributes, "bundle.zip": pointerContent})
+
+	require.NoError(t, Pull(context.Background(), cacheDirectory, server.gitURL(), testToken))
+	reader, err := cacheDirectory.OpenLFSObject(pointer.OID)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, "content", string(content))
+	require.Equal(t, 1, server.downloads)
+
+	require.NoError(t, Pull(context.Background(), cacheDirectory, server.gitURL(), testToken))
+	require.Equal(t, 1, server.downloads)
+}
+
+func TestPullWrongContent(t *testing.T) {
+	server := startTestServer(t)
+	defer server.close()
+	pointer, pointerContent := pointerFor("content")
+	server.objects[pointer.OID] = []byte("CONTENT")
+	cacheDirectory := getTestCacheDirectory(t, map[string]string{".gitattributes": testAttributes, "bundle.zip": pointerContent})
+
+	err := Pull(context.Background(), cacheDirectory, server.gitURL(), testToken)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not match its object ID")
+	_, err = cacheDirectory.LFSObjectSize(pointer.OID)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPullFromDirectory(t *testing.T) {
+	pointer, pointerContent := pointerFor("content")
+	cacheDirectory := getTestCacheDirectory(t, map[string]string{".gitattributes": testAttributes, "bundle.zip": pointerContent})
+	gitPath := test.CreateTemporaryDirectory(t)
+	require.EqualError(t, PullFromDirectory(cacheDirectory, gitPath), "The source directory does not have the Git LFS object "+pointer.OID+". Run `git lfs fetch --all` in its Git repository, and then pull again.")
+
+	objectPath := filepath.Join(gitPath, "lfs", "objects", pointer.OID[0:2], pointer.OID[2:4], pointer.OID)
+	require.NoError(t, os.MkdirAll(filepath.Dir(objectPath), 0755))
+	require.NoError(t, ioutil.WriteFile(objectPath, []byte("content"), 0644))
+	require.NoError(t, PullFromDirectory(cacheDirectory, gitPath))
+	size, err := cacheDirectory.LFSObjectSize(pointer.OID)
+	require.NoError(t, err)
+	require.Equal(t, pointer.Size, size)
+}
+
+func TestPullWithoutLFS(t *testing.T) {
+	cacheDirectory := getTestCacheDirectory(t, map[string]string{"README.md": "Hello!"})
+	require.NoError(t, Pull(context.Background(), cacheDirectory, "https://invalid.example.com/owner/repository.git", ""))
+}
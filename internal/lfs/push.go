@@ -0,0 +1,90 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const errorNotCached = "The Git LFS object %s is not in the cache. Pull the cache again to download it."
+
+func (client *client) upload(cacheDirectory cachedirectory.CacheDirectory, object batchObject) error {
+	reader, err := cacheDirectory.OpenLFSObject(object.OID)
+	if err != nil {
+		return exitcode.WithCode(errors.Wrapf(err, "Error reading Git LFS object %s from cache.", object.OID), exitcode.CacheCorrupt)
+	}
+	defer reader.Close()
+	response, err := client.send(http.MethodPut, object.Actions["upload"], reader, object.Size, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response, "uploading Git LFS object "+object.OID, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	verify, ok := object.Actions["verify"]
+	if !ok {
+		return nil
+	}
+	body, err := json.Marshal(object.Pointer)
+	if err != nil {
+		return errors.Wrap(err, "Error converting Git LFS object to JSON.")
+	}
+	response, err = client.send(http.MethodPost, verify, bytes.NewReader(body), int64(len(body)), mediaType)
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response, "verifying Git LFS object "+object.OID, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// Push uploads the Git LFS objects that the branches and tags in the cache refer to, so that the files they replace can be checked out from the destination. Objects the destination already has are not uploaded again, and nothing is done if the Git repository does not use Git LFS.
+func Push(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, gitURL string, token string) error {
+	pointers, err := findPointers(cacheDirectory)
+	if err != nil || len(pointers) == 0 {
+		return err
+	}
+	for _, pointer := range pointers {
+		cached, err := isCached(cacheDirectory, pointer)
+		if err != nil {
+			return err
+		}
+		if !cached {
+			return exitcode.WithCode(fmt.Errorf(errorNotCached, pointer.OID), exitcode.CacheCorrupt)
+		}
+	}
+	client := client{ctx: ctx, httpClient: retry.NewClient(nil), endpoint: Endpoint(gitURL), token: token}
+	objects, err := client.batch("upload", pointers)
+	if err != nil {
+		return err
+	}
+	uploaded := 0
+	for _, object := range objects {
+		// The server leaves out the upload action for objects it already has.
+		if _, ok := object.Actions["upload"]; !ok {
+			continue
+		}
+		log.Debugf("Uploading Git LFS object %s...", object.OID)
+		err = client.upload(cacheDirectory, object)
+		if err != nil {
+			return err
+		}
+		uploaded++
+	}
+	log.Infof("Finished pushing Git LFS objects! %d were uploaded and the destination already had %d.", uploaded, len(pointers)-uploaded)
+	return nil
+}
@@ -0,0 +1,37 @@
+package lfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPush(t *testing.T) {
+	server := startTestServer(t)
+	defer server.close()
+	first, firstPointer := pointerFor("first")
+	second, secondPointer := pointerFor("second")
+	server.objects[second.OID] = []byte("second")
+	cacheDirectory := getTestCacheDirectory(t, map[string]string{".gitattributes": testAttributes, "first.zip": firstPointer, "second.zip": secondPointer})
+	require.NoError(t, cacheDirectory.WriteLFSObject(first.OID, strings.NewReader("first"), first.Size))
+	require.NoError(t, cacheDirectory.WriteLFSObject(second.OID, strings.NewReader("second"), second.Size))
+
+	require.NoError(t, Push(context.Background(), cacheDirectory, server.gitURL(), testToken))
+	require.Equal(t, "first", string(server.objects[first.OID]))
+	require.Equal(t, 1, server.uploads)
+	require.Equal(t, 1, server.verified)
+
+	require.NoError(t, Push(context.Background(), cacheDirectory, server.gitURL(), testToken))
+	require.Equal(t, 1, server.uploads)
+}
+
+func TestPushNotCached(t *testing.T) {
+	server := startTestServer(t)
+	defer server.close()
+	pointer, pointerContent := pointerFor("content")
+	cacheDirectory := getTestCacheDirectory(t, map[string]string{".gitattributes": testAttributes, "bundle.zip": pointerContent})
+	require.EqualError(t, Push(context.Background(), cacheDirectory, server.gitURL(), testToken), "The Git LFS object "+pointer.OID+" is not in the cache. Pull the cache again to download it.")
+	require.Equal(t, 0, server.uploads)
+}
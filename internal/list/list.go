@@ -0,0 +1,218 @@
+package list
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+)
+
+const OutputFormatText = "text"
+const OutputFormatJSON = "json"
+
+const errorUnknownOutputFormat = "The output format must be either `text` or `json`."
+
+type Asset struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Release struct {
+	Tag    string  `json:"tag"`
+	Assets []Asset `json:"assets"`
+}
+
+type Reference struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// Contents describes everything in the cache that would be pushed.
+type Contents struct {
+	Cache      string      `json:"cache"`
+	Releases   []Release   `json:"releases"`
+	References []Reference `json:"references"`
+}
+
+// Manifest describes the cache as of the last pull. It is written to `manifest.json` in the cache, so that other tools can find out what the cache contains without opening the Git repository or reading every asset.
+type Manifest struct {
+	ToolVersion string      `json:"tool_version"`
+	PulledAt    time.Time   `json:"pulled_at"`
+	Releases    []Release   `json:"releases"`
+	References  []Reference `json:"references"`
+}
+
+func assetChecksum(cacheDirectory cachedirectory.CacheDirectory, release string, asset string) (string, error) {
+	reader, err := cacheDirectory.OpenAsset(release, asset)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error calculating checksum of asset %s.", asset)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readReferences(cacheDirectory cachedirectory.CacheDirectory) ([]Reference, error) {
+	result := []Reference{}
+	err := cacheDirectory.LoadGit()
+	if err != nil {
+		return nil, err
+	}
+	repository, err := git.PlainOpen(cacheDirectory.GitPath())
+	if err != nil {
+		if err == git.ErrRepositoryNotExists {
+			return result, nil
+		}
+		return nil, errors.Wrap(err, "Error opening Git repository cache.")
+	}
+	references, err := repository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference {
+			result = append(result, Reference{Name: reference.Name().String(), Hash: reference.Hash().String()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	return result, nil
+}
+
+func readReleases(cacheDirectory cachedirectory.CacheDirectory, checksum func(release string, asset cachedirectory.Asset) (string, error)) ([]Release, error) {
+	result := []Release{}
+	releases, err := cacheDirectory.ListReleases()
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+	for _, releaseTag := range releases {
+		assets, err := cacheDirectory.ListAssets(releaseTag)
+		if err != nil {
+			return nil, err
+		}
+		release := Release{Tag: releaseTag, Assets: []Asset{}}
+		for _, asset := range assets {
+			assetChecksum, err := checksum(releaseTag, asset)
+			if err != nil {
+				return nil, err
+			}
+			release.Assets = append(release.Assets, Asset{Name: asset.Name, Size: asset.Size, SHA256: assetChecksum})
+		}
+		result = append(result, release)
+	}
+	return result, nil
+}
+
+// Read collects the releases, assets and Git references currently in the cache.
+func Read(cacheDirectory cachedirectory.CacheDirectory) (*Contents, error) {
+	contents := Contents{Cache: cacheDirectory.String()}
+	var err error
+	contents.Releases, err = readReleases(cacheDirectory, func(release string, asset cachedirectory.Asset) (string, error) {
+		return assetChecksum(cacheDirectory, release, asset.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	contents.References, err = readReferences(cacheDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return &contents, nil
+}
+
+// WriteManifest writes a Manifest describing the cache to `manifest.json` in the cache. The checksums of assets are taken from the checksums recorded when they were downloaded, so that every asset does not have to be read again.
+func WriteManifest(cacheDirectory cachedirectory.CacheDirectory, checksums *cachedirectory.Manifest, pulledAt time.Time) error {
+	manifest := Manifest{ToolVersion: version.Version(), PulledAt: pulledAt.UTC()}
+	var err error
+	manifest.Releases, err = readReleases(cacheDirectory, func(release string, asset cachedirectory.Asset) (string, error) {
+		entry, exists := checksums.Get(release, asset.Name)
+		if exists && entry.Size == asset.Size && entry.SHA256 != "" {
+			return entry.SHA256, nil
+		}
+		return assetChecksum(cacheDirectory, release, asset.Name)
+	})
+	if err != nil {
+		return err
+	}
+	manifest.References, err = readReferences(cacheDirectory)
+	if err != nil {
+		return err
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Error converting cache contents manifest to JSON.")
+	}
+	err = cacheDirectory.WriteContentsManifest(manifestJSON)
+	if err != nil {
+		return errors.Wrap(err, "Error writing cache contents manifest.")
+	}
+	return nil
+}
+
+// ReadManifest returns the Manifest written by the last successful pull, or nil if there has not been one.
+func ReadManifest(cacheDirectory cachedirectory.CacheDirectory) (*Manifest, error) {
+	manifestJSON, err := cacheDirectory.ReadContentsManifest()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading cache contents manifest.")
+	}
+	manifest := Manifest{}
+	err = json.Unmarshal(manifestJSON, &manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error decoding cache contents manifest.")
+	}
+	return &manifest, nil
+}
+
+func writeText(contents *Contents, writer io.Writer) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tabWriter, "Cache: %s\n", contents.Cache)
+	fmt.Fprintf(tabWriter, "\nReleases (%d):\n", len(contents.Releases))
+	for _, release := range contents.Releases {
+		fmt.Fprintf(tabWriter, "  %s\n", release.Tag)
+		for _, asset := range release.Assets {
+			fmt.Fprintf(tabWriter, "    %s\t%d\t%s\n", asset.Name, asset.Size, asset.SHA256)
+		}
+	}
+	fmt.Fprintf(tabWriter, "\nGit references (%d):\n", len(contents.References))
+	for _, reference := range contents.References {
+		fmt.Fprintf(tabWriter, "  %s\t%s\n", reference.Name, reference.Hash)
+	}
+	return tabWriter.Flush()
+}
+
+// List prints the contents of the cache in the given output format.
+func List(cacheDirectory cachedirectory.CacheDirectory, writer io.Writer, outputFormat string) error {
+	if outputFormat != OutputFormatText && outputFormat != OutputFormatJSON {
+		return errors.New(errorUnknownOutputFormat)
+	}
+	contents, err := Read(cacheDirectory)
+	if err != nil {
+		return err
+	}
+	if outputFormat == OutputFormatJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(contents)
+	}
+	return writeText(contents, writer)
+}
@@ -0,0 +1,95 @@
+package list
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+const aCommit = "b9f01aa2c50f49898d4c7845a66be8824499fe9d"
+
+func getTestCacheDirectory(t *testing.T) cachedirectory.CacheDirectory {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := cachedirectory.NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, "1.0.0"))
+	require.NoError(t, cacheDirectory.WriteMetadata("codeql-bundle-20200101", []byte("{}")))
+	require.NoError(t, cacheDirectory.WriteAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", strings.NewReader("a bundle"), 8))
+	repository, err := git.PlainInit(cacheDirectory.GitPath(), true)
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", plumbing.NewHash(aCommit))))
+	return cacheDirectory
+}
+
+func TestListText(t *testing.T) {
+	cacheDirectory := getTestCacheDirectory(t)
+	output := bytes.Buffer{}
+	require.NoError(t, List(cacheDirectory, &output, OutputFormatText))
+	require.Contains(t, output.String(), "Releases (1):\n  codeql-bundle-20200101\n    codeql-bundle.tar.gz  8  ")
+	require.Contains(t, output.String(), "Git references (1):\n  refs/heads/main  "+aCommit+"\n")
+}
+
+func TestListJSON(t *testing.T) {
+	cacheDirectory := getTestCacheDirectory(t)
+	output := bytes.Buffer{}
+	require.NoError(t, List(cacheDirectory, &output, OutputFormatJSON))
+	contents := Contents{}
+	require.NoError(t, json.Unmarshal(output.Bytes(), &contents))
+	require.Equal(t, []Release{{
+		Tag: "codeql-bundle-20200101",
+		Assets: []Asset{{
+			Name:   "codeql-bundle.tar.gz",
+			Size:   8,
+			SHA256: "cd98c950d629c66548537b4ecd68dab3e8cf59974ba6e40df5a1a7354b39db5f",
+		}},
+	}}, contents.Releases)
+	require.Equal(t, []Reference{{Name: "refs/heads/main", Hash: aCommit}}, contents.References)
+}
+
+func TestListEmptyCache(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := cachedirectory.NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	contents, err := Read(cacheDirectory)
+	require.NoError(t, err)
+	require.Empty(t, contents.Releases)
+	require.Empty(t, contents.References)
+
+	require.EqualError(t, List(cacheDirectory, &bytes.Buffer{}, "yaml"), errorUnknownOutputFormat)
+}
+
+func TestWriteManifest(t *testing.T) {
+	cacheDirectory := getTestCacheDirectory(t)
+	checksums := &cachedirectory.Manifest{Releases: map[string]map[string]cachedirectory.ManifestEntry{}}
+	// A recorded checksum is used rather than reading the asset again.
+	checksums.Set("codeql-bundle-20200101", "codeql-bundle.tar.gz", cachedirectory.ManifestEntry{Size: 8, SHA256: "a-recorded-checksum"})
+	pulledAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, WriteManifest(cacheDirectory, checksums, pulledAt))
+
+	manifestJSON, err := cacheDirectory.ReadContentsManifest()
+	require.NoError(t, err)
+	manifest := Manifest{}
+	require.NoError(t, json.Unmarshal(manifestJSON, &manifest))
+	require.Equal(t, pulledAt, manifest.PulledAt)
+	require.NotEmpty(t, manifest.ToolVersion)
+	require.Equal(t, []Release{{
+		Tag:    "codeql-bundle-20200101",
+		Assets: []Asset{{Name: "codeql-bundle.tar.gz", Size: 8, SHA256: "a-recorded-checksum"}},
+	}}, manifest.Releases)
+	require.Equal(t, []Reference{{Name: "refs/heads/main", Hash: aCommit}}, manifest.References)
+
+	// Without a recorded checksum, the asset is read.
+	checksums = &cachedirectory.Manifest{Releases: map[string]map[string]cachedirectory.ManifestEntry{}}
+	require.NoError(t, WriteManifest(cacheDirectory, checksums, pulledAt))
+	manifestJSON, err = cacheDirectory.ReadContentsManifest()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(manifestJSON, &manifest))
+	require.Equal(t, "cd98c950d629c66548537b4ecd68dab3e8cf59974ba6e40df5a1a7354b39db5f", manifest.Releases[0].Assets[0].SHA256)
+}
@@ -0,0 +1,181 @@
+package logfile
+
+import (
+	"bufio"
+	usererrors "errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/bytesize"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const errorInvalidSize = "The size `%s` given with `--log-file-max-size` is not valid. Sizes are given in bytes with an optional K, M or G suffix, for example `500K` or `100M`."
+const errorNegativeLimits = "`--log-file-max-age` and `--log-file-keep` cannot be negative."
+
+// DefaultMaxSize is the size a log file is rotated at if no other is given, which keeps a few days of a sync that runs every hour in each file.
+const DefaultMaxSize = "100M"
+
+// DefaultKeep is the number of rotated log files kept if no other number is given.
+const DefaultKeep = 5
+
+// ParseSize parses a size such as `100M` into a number of bytes. Suffixes are powers of 1024. An empty string or zero means no limit.
+func ParseSize(size string) (int64, error) {
+	value, err := bytesize.Parse(size)
+	if err != nil {
+		return 0, fmt.Errorf(errorInvalidSize, size)
+	}
+	return value, nil
+}
+
+// File is a log file that is rotated once it reaches a size or age, so that a sync run regularly on an appliance does not fill its disk. The file it is rotated to is named after it with `.1` added, and older ones are renumbered up to the number kept, after which they are removed.
+type File struct {
+	path   string
+	limits Limits
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	// startedAt is when the first entry was written to the current file, which can be before the sync tool started if it is appending to a log from an earlier run.
+	startedAt time.Time
+	now       func() time.Time
+}
+
+// Limits are when a log file is rotated and how many rotated files are kept. A zero size or age is not a limit.
+type Limits struct {
+	MaxSize int64
+	MaxAge  time.Duration
+	Keep    int
+}
+
+// Open opens a log file to append to, creating it if it does not exist.
+func Open(path string, limits Limits) (*File, error) {
+	if limits.MaxAge < 0 || limits.Keep < 0 {
+		return nil, usererrors.New(errorNegativeLimits)
+	}
+	file := &File{path: path, limits: limits, now: time.Now}
+	err := file.open()
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+var timestampPattern = regexp.MustCompile(`time="([^"]+)"`)
+
+// firstEntryTime returns the time of the first entry of a log file written by the sync tool, or the time it was last changed if that cannot be read.
+func firstEntryTime(file *os.File, info os.FileInfo) time.Time {
+	line, _ := bufio.NewReader(file).ReadString('\n')
+	if match := timestampPattern.FindStringSubmatch(line); match != nil {
+		if timestamp, err := time.Parse(time.RFC3339, match[1]); err == nil {
+			return timestamp
+		}
+	}
+	return info.ModTime()
+}
+
+func (file *File) open() error {
+	opened, err := os.OpenFile(file.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Error opening log file.")
+	}
+	info, err := opened.Stat()
+	if err != nil {
+		opened.Close()
+		return errors.Wrap(err, "Error opening log file.")
+	}
+	file.file = opened
+	file.size = info.Size()
+	file.startedAt = file.now()
+	if file.size > 0 {
+		file.startedAt = firstEntryTime(opened, info)
+	}
+	return nil
+}
+
+func (file *File) needsRotation(length int) bool {
+	if file.size == 0 {
+		return false
+	}
+	if file.limits.MaxSize > 0 && file.size+int64(length) > file.limits.MaxSize {
+		return true
+	}
+	return file.limits.MaxAge > 0 && file.now().Sub(file.startedAt) >= file.limits.MaxAge
+}
+
+func (file *File) rotatedPath(index int) string {
+	return fmt.Sprintf("%s.%d", file.path, index)
+}
+
+func (file *File) rotate() error {
+	err := file.file.Close()
+	if err != nil {
+		return errors.Wrap(err, "Error closing log file.")
+	}
+	err = os.Remove(file.rotatedPath(file.limits.Keep))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Error removing old log file.")
+	}
+	for index := file.limits.Keep - 1; index >= 1; index-- {
+		err = os.Rename(file.rotatedPath(index), file.rotatedPath(index+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Error rotating log file.")
+		}
+	}
+	if file.limits.Keep > 0 {
+		err = os.Rename(file.path, file.rotatedPath(1))
+	} else {
+		err = os.Remove(file.path)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error rotating log file.")
+	}
+	return file.open()
+}
+
+// Write appends to the log file, first rotating it if the data would take it over its size or it is too old. Each write should be a whole entry, so that entries are never split across files.
+func (file *File) Write(data []byte) (int, error) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	if file.needsRotation(len(data)) {
+		err := file.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	written, err := file.file.Write(data)
+	file.size += int64(written)
+	return written, err
+}
+
+func (file *File) Close() error {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	return file.file.Close()
+}
+
+// Hook is a logrus hook which writes every entry to a file as plain text with full timestamps, whatever is shown on the terminal.
+type Hook struct {
+	file      *File
+	formatter log.Formatter
+}
+
+func NewHook(file *File) *Hook {
+	return &Hook{file: file, formatter: &log.TextFormatter{DisableColors: true, FullTimestamp: true}}
+}
+
+func (hook *Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (hook *Hook) Fire(entry *log.Entry) error {
+	line, err := hook.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = hook.file.Write(line)
+	return err
+}
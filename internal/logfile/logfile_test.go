@@ -0,0 +1,103 @@
+package logfile
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func readLog(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestParseSize(t *testing.T) {
+	size, err := ParseSize("100M")
+	require.NoError(t, err)
+	require.Equal(t, int64(100*1024*1024), size)
+	size, err = ParseSize("512")
+	require.NoError(t, err)
+	require.Equal(t, int64(512), size)
+	size, err = ParseSize("")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), size)
+	_, err = ParseSize("lots")
+	require.EqualError(t, err, "The size `lots` given with `--log-file-max-size` is not valid. Sizes are given in bytes with an optional K, M or G suffix, for example `500K` or `100M`.")
+	_, err = ParseSize("99999999999G")
+	require.Error(t, err)
+}
+
+func TestRotateBySize(t *testing.T) {
+	logPath := path.Join(test.CreateTemporaryDirectory(t), "sync.log")
+	file, err := Open(logPath, Limits{MaxSize: 10, Keep: 2})
+	require.NoError(t, err)
+	defer file.Close()
+	for _, entry := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = file.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+	require.Equal(t, "fourth\n", readLog(t, logPath))
+	require.Equal(t, "third\n", readLog(t, logPath+".1"))
+	require.Equal(t, "second\n", readLog(t, logPath+".2"))
+	require.NoFileExists(t, logPath+".3")
+}
+
+func TestRotateByAge(t *testing.T) {
+	logPath := path.Join(test.CreateTemporaryDirectory(t), "sync.log")
+	now := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, ioutil.WriteFile(logPath, []byte(`time="2020-06-29T11:00:00Z" level=info msg="Yesterday."`+"\n"), 0644))
+	file, err := Open(logPath, Limits{MaxAge: 24 * time.Hour, Keep: 1})
+	require.NoError(t, err)
+	defer file.Close()
+	file.now = func() time.Time { return now }
+
+	// The age of a log from an earlier run is taken from its first entry.
+	_, err = file.Write([]byte("today\n"))
+	require.NoError(t, err)
+	require.Equal(t, "today\n", readLog(t, logPath))
+	require.Contains(t, readLog(t, logPath+".1"), "Yesterday.")
+
+	now = now.Add(23 * time.Hour)
+	_, err = file.Write([]byte("tonight\n"))
+	require.NoError(t, err)
+	require.Equal(t, "today\ntonight\n", readLog(t, logPath))
+}
+
+func TestRotateWithoutKeeping(t *testing.T) {
+	logPath := path.Join(test.CreateTemporaryDirectory(t), "sync.log")
+	file, err := Open(logPath, Limits{MaxSize: 5, Keep: 0})
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.Write([]byte("first\n"))
+	require.NoError(t, err)
+	_, err = file.Write([]byte("second\n"))
+	require.NoError(t, err)
+	require.Equal(t, "second\n", readLog(t, logPath))
+	require.NoFileExists(t, logPath+".1")
+}
+
+func TestOpenNegativeLimits(t *testing.T) {
+	_, err := Open(path.Join(test.CreateTemporaryDirectory(t), "sync.log"), Limits{Keep: -1})
+	require.EqualError(t, err, errorNegativeLimits)
+}
+
+func TestHook(t *testing.T) {
+	logPath := path.Join(test.CreateTemporaryDirectory(t), "sync.log")
+	file, err := Open(logPath, Limits{})
+	require.NoError(t, err)
+	defer file.Close()
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logger.AddHook(NewHook(file))
+	logger.Info("Pulling the CodeQL Action.")
+	content := readLog(t, logPath)
+	require.True(t, strings.HasPrefix(content, `time="`))
+	require.Contains(t, content, `level=info msg="Pulling the CodeQL Action."`)
+}
@@ -0,0 +1,122 @@
+package login
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// credentialUsername is the username tokens are stored under, so that they are kept apart from any password Git has stored for the same instance.
+const credentialUsername = "codeql-action-sync"
+
+// Storage is somewhere tokens are kept between runs of the sync tool.
+type Storage interface {
+	Save(instanceURL string, token Token) error
+	Token(instanceURL string) (string, error)
+}
+
+// CredentialHelper keeps tokens with a Git credential helper, such as osxkeychain, manager or libsecret, so that they are held in the credential store of the operating system.
+type CredentialHelper struct {
+	ctx     context.Context
+	command []string
+}
+
+// NewCredentialHelper uses the Git credential helper with the given name, which is run with `git credential-<name>` in the same way as Git does, or the given program if it is a path.
+func NewCredentialHelper(ctx context.Context, helper string) CredentialHelper {
+	command := strings.Fields(helper)
+	if len(command) != 0 && !strings.ContainsAny(command[0], `/\`) {
+		command = append([]string{"git", "credential-" + command[0]}, command[1:]...)
+	}
+	return CredentialHelper{ctx: ctx, command: command}
+}
+
+func credentialAttributes(instanceURL string) (map[string]string, error) {
+	parsedURL, err := url.Parse(NormalizeURL(instanceURL))
+	if err != nil || parsedURL.Host == "" {
+		return nil, errors.Errorf("The URL %s is not valid.", instanceURL)
+	}
+	return map[string]string{
+		"protocol": parsedURL.Scheme,
+		"host":     parsedURL.Host,
+		"username": credentialUsername,
+	}, nil
+}
+
+// run runs the credential helper with the given action, passing it the attributes and returning those it writes back, in the format described by `git help credential`.
+func (helper CredentialHelper) run(action string, attributes map[string]string) (map[string]string, error) {
+	if len(helper.command) == 0 {
+		return nil, errors.New("No credential helper was given.")
+	}
+	// The order of attributes does not matter to credential helpers, but keeping it stable makes them easier to debug.
+	input := bytes.Buffer{}
+	for _, key := range []string{"protocol", "host", "username", "password", "password_expiry_utc"} {
+		if value, ok := attributes[key]; ok {
+			fmt.Fprintf(&input, "%s=%s\n", key, value)
+		}
+	}
+	input.WriteString("\n")
+	command := exec.CommandContext(helper.ctx, helper.command[0], append(helper.command[1:], action)...)
+	command.Stdin = &input
+	stderr := bytes.Buffer{}
+	command.Stderr = &stderr
+	output, err := command.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error running credential helper %s: %s", strings.Join(helper.command, " "), strings.TrimSpace(stderr.String()))
+	}
+	result := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		split := strings.SplitN(line, "=", 2)
+		if len(split) == 2 {
+			result[split[0]] = split[1]
+		}
+	}
+	return result, nil
+}
+
+// Save asks the credential helper to store the token.
+func (helper CredentialHelper) Save(instanceURL string, token Token) error {
+	attributes, err := credentialAttributes(instanceURL)
+	if err != nil {
+		return err
+	}
+	attributes["password"] = token.AccessToken
+	if !token.ExpiresAt.IsZero() {
+		attributes["password_expiry_utc"] = strconv.FormatInt(token.ExpiresAt.Unix(), 10)
+	}
+	_, err = helper.run("store", attributes)
+	return err
+}
+
+// Token asks the credential helper for the token for an instance, returning an empty string if it has none or the one it has has expired.
+func (helper CredentialHelper) Token(instanceURL string) (string, error) {
+	attributes, err := credentialAttributes(instanceURL)
+	if err != nil {
+		return "", err
+	}
+	result, err := helper.run("get", attributes)
+	if err != nil {
+		return "", err
+	}
+	if expiry, ok := result["password_expiry_utc"]; ok {
+		seconds, err := strconv.ParseInt(expiry, 10, 64)
+		if err == nil && time.Now().After(time.Unix(seconds, 0)) {
+			log.Warnf("The token for %s held by the credential helper has expired. Run the login command again to get a new one.", NormalizeURL(instanceURL))
+			return "", nil
+		}
+	}
+	return result["password"], nil
+}
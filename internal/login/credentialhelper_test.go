@@ -0,0 +1,79 @@
+package login
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+const testCredentialsEnvironmentVariable = "CODEQL_ACTION_SYNC_TEST_CREDENTIALS"
+
+// TestCredentialHelperProcess is run as a credential helper by the other tests, keeping credentials in a JSON file.
+func TestCredentialHelperProcess(t *testing.T) {
+	credentialsPath := os.Getenv(testCredentialsEnvironmentVariable)
+	if credentialsPath == "" {
+		return
+	}
+	attributes := map[string]string{}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() && scanner.Text() != "" {
+		split := strings.SplitN(scanner.Text(), "=", 2)
+		attributes[split[0]] = split[1]
+	}
+	credentials := map[string]map[string]string{}
+	content, err := ioutil.ReadFile(credentialsPath)
+	if err == nil {
+		require.NoError(t, json.Unmarshal(content, &credentials))
+	}
+	key := attributes["protocol"] + "://" + attributes["username"] + "@" + attributes["host"]
+	switch os.Args[len(os.Args)-1] {
+	case "get":
+		for name, value := range credentials[key] {
+			fmt.Printf("%s=%s\n", name, value)
+		}
+	case "store":
+		credentials[key] = attributes
+		content, err := json.Marshal(credentials)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(credentialsPath, content, 0600))
+	}
+	os.Exit(0)
+}
+
+func TestCredentialHelper(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	require.NoError(t, os.Setenv(testCredentialsEnvironmentVariable, filepath.Join(temporaryDirectory, "credentials.json")))
+	t.Cleanup(func() {
+		os.Unsetenv(testCredentialsEnvironmentVariable)
+	})
+	helper := NewCredentialHelper(context.Background(), os.Args[0]+" -test.run=^TestCredentialHelperProcess$ --")
+
+	token, err := helper.Token("https://ghes.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "", token)
+
+	require.NoError(t, helper.Save("https://ghes.example.com/", Token{AccessToken: "ghes-token"}))
+	require.NoError(t, helper.Save("https://old-ghes.example.com", Token{AccessToken: "expired-token", ExpiresAt: time.Now().Add(-time.Hour)}))
+	token, err = helper.Token("https://ghes.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "ghes-token", token)
+	token, err = helper.Token("https://old-ghes.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "", token)
+}
+
+func TestNewCredentialHelper(t *testing.T) {
+	require.Equal(t, []string{"git", "credential-osxkeychain"}, NewCredentialHelper(context.Background(), "osxkeychain").command)
+	require.Equal(t, []string{"git", "credential-store", "--file", "credentials"}, NewCredentialHelper(context.Background(), "store --file credentials").command)
+	require.Equal(t, []string{"/usr/local/bin/helper", "--debug"}, NewCredentialHelper(context.Background(), "/usr/local/bin/helper --debug").command)
+}
@@ -0,0 +1,141 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultScopes are the scopes requested for an OAuth app, which are enough to push the CodeQL Action. GitHub Apps have their permissions configured with the app instead.
+var DefaultScopes = []string{"repo", "workflow"}
+
+// slowDownIncrease is how much longer to wait between polls each time the instance asks us to slow down, if it does not say.
+var slowDownIncrease = 5 * time.Second
+
+const errorCodeExpired = "The code expired before it was entered. Run the login command again."
+
+const grantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	Interval         int    `json:"interval"`
+}
+
+type loginService struct {
+	ctx         context.Context
+	httpClient  *http.Client
+	instanceURL string
+	clientID    string
+}
+
+func (loginService *loginService) post(path string, form url.Values, result interface{}) error {
+	request, err := http.NewRequestWithContext(loginService.ctx, http.MethodPost, loginService.instanceURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	response, err := loginService.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return errors.Errorf("%s does not support the device flow. It needs to be GitHub.com or GitHub Enterprise Server 3.1 or later, with the device flow turned on for the app.", loginService.instanceURL)
+	}
+	if response.StatusCode != http.StatusOK {
+		return errors.Errorf("Status code %d from %s.", response.StatusCode, request.URL)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func (loginService *loginService) requestDeviceCode(scopes []string) (deviceCode, error) {
+	result := deviceCode{}
+	err := loginService.post("/login/device/code", url.Values{"client_id": {loginService.clientID}, "scope": {strings.Join(scopes, " ")}}, &result)
+	if err != nil {
+		return deviceCode{}, errors.Wrap(err, "Error requesting a device code.")
+	}
+	return result, nil
+}
+
+// pollForToken waits for the user to enter the code, checking as often as the instance allows.
+func (loginService *loginService) pollForToken(code deviceCode) (Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	expiry := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for {
+		select {
+		case <-loginService.ctx.Done():
+			return Token{}, loginService.ctx.Err()
+		case <-time.After(interval):
+		}
+		result := tokenResponse{}
+		err := loginService.post("/login/oauth/access_token", url.Values{"client_id": {loginService.clientID}, "device_code": {code.DeviceCode}, "grant_type": {grantType}}, &result)
+		if err != nil {
+			return Token{}, errors.Wrap(err, "Error requesting a token.")
+		}
+		switch result.Error {
+		case "":
+			token := Token{AccessToken: result.AccessToken}
+			if result.ExpiresIn > 0 {
+				token.ExpiresAt = time.Now().UTC().Add(time.Duration(result.ExpiresIn) * time.Second)
+			}
+			return token, nil
+		case "authorization_pending":
+		case "slow_down":
+			if result.Interval > 0 {
+				interval = time.Duration(result.Interval) * time.Second
+			} else {
+				interval += slowDownIncrease
+			}
+		case "expired_token":
+			return Token{}, errors.New(errorCodeExpired)
+		case "access_denied":
+			return Token{}, errors.New("The request to log in was denied.")
+		default:
+			return Token{}, errors.Errorf("Error requesting a token: %s", result.ErrorDescription)
+		}
+		if time.Now().After(expiry) {
+			return Token{}, errors.New(errorCodeExpired)
+		}
+	}
+}
+
+// Login obtains a token for a GitHub instance with the OAuth device flow, asking the user to enter a code in their browser.
+func Login(ctx context.Context, instanceURL string, clientID string, scopes []string, writer io.Writer) (Token, error) {
+	loginService := loginService{
+		ctx:         ctx,
+		httpClient:  http.DefaultClient,
+		instanceURL: NormalizeURL(instanceURL),
+		clientID:    clientID,
+	}
+	code, err := loginService.requestDeviceCode(scopes)
+	if err != nil {
+		return Token{}, err
+	}
+	fmt.Fprintf(writer, "Open %s in your browser and enter the code %s.\n", code.VerificationURI, code.UserCode)
+	token, err := loginService.pollForToken(code)
+	if err != nil {
+		return Token{}, err
+	}
+	log.Infof("Logged in to %s.", loginService.instanceURL)
+	return token, nil
+}
@@ -0,0 +1,74 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogin(t *testing.T) {
+	defaultSlowDownIncrease := slowDownIncrease
+	slowDownIncrease = time.Millisecond
+	t.Cleanup(func() {
+		slowDownIncrease = defaultSlowDownIncrease
+	})
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/login/device/code", func(response http.ResponseWriter, request *http.Request) {
+		require.NoError(t, request.ParseForm())
+		require.Equal(t, "client-id", request.PostForm.Get("client_id"))
+		require.Equal(t, "repo workflow", request.PostForm.Get("scope"))
+		require.Equal(t, "application/json", request.Header.Get("Accept"))
+		test.ServeHTTPResponseFromObject(t, deviceCode{DeviceCode: "device-code", UserCode: "ABCD-1234", VerificationURI: githubURL + "/login/device", ExpiresIn: 900}, response)
+	}).Methods("POST")
+	polls := 0
+	githubTestServer.HandleFunc("/login/oauth/access_token", func(response http.ResponseWriter, request *http.Request) {
+		require.NoError(t, request.ParseForm())
+		require.Equal(t, "device-code", request.PostForm.Get("device_code"))
+		require.Equal(t, grantType, request.PostForm.Get("grant_type"))
+		polls++
+		switch polls {
+		case 1:
+			test.ServeHTTPResponseFromObject(t, tokenResponse{Error: "authorization_pending"}, response)
+		case 2:
+			test.ServeHTTPResponseFromObject(t, tokenResponse{Error: "slow_down"}, response)
+		default:
+			test.ServeHTTPResponseFromObject(t, tokenResponse{AccessToken: "user-token", ExpiresIn: 28800}, response)
+		}
+	}).Methods("POST")
+
+	output := bytes.Buffer{}
+	token, err := Login(context.Background(), githubURL+"/", "client-id", DefaultScopes, &output)
+	require.NoError(t, err)
+	require.Equal(t, "user-token", token.AccessToken)
+	require.WithinDuration(t, time.Now().Add(8*time.Hour), token.ExpiresAt, time.Minute)
+	require.Equal(t, 3, polls)
+	require.Equal(t, "Open "+githubURL+"/login/device in your browser and enter the code ABCD-1234.\n", output.String())
+}
+
+func TestLoginDenied(t *testing.T) {
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/login/device/code", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, deviceCode{DeviceCode: "device-code", UserCode: "ABCD-1234", ExpiresIn: 900}, response)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/login/oauth/access_token", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, tokenResponse{Error: "access_denied"}, response)
+	}).Methods("POST")
+
+	_, err := Login(context.Background(), githubURL, "client-id", DefaultScopes, &bytes.Buffer{})
+	require.EqualError(t, err, "The request to log in was denied.")
+}
+
+func TestLoginWithoutDeviceFlow(t *testing.T) {
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/login/device/code", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("POST")
+
+	_, err := Login(context.Background(), githubURL, "client-id", DefaultScopes, &bytes.Buffer{})
+	require.EqualError(t, err, "Error requesting a device code.: "+githubURL+" does not support the device flow. It needs to be GitHub.com or GitHub Enterprise Server 3.1 or later, with the device flow turned on for the app.")
+}
@@ -0,0 +1,105 @@
+package login
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Token is a token obtained by logging in, which may expire.
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Expired returns whether the token has an expiry time which has passed.
+func (token Token) Expired() bool {
+	return !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt)
+}
+
+// NormalizeURL returns the form of an instance URL that tokens are stored under, so that a trailing slash does not matter.
+func NormalizeURL(instanceURL string) string {
+	return strings.TrimRight(instanceURL, "/")
+}
+
+// DefaultStorePath returns where tokens are stored if no other location is given, within the configuration directory of the user.
+func DefaultStorePath() string {
+	configurationDirectory, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configurationDirectory, "codeql-action-sync", "tokens.json")
+}
+
+// Store is a file holding the tokens obtained by logging in, keyed by the URL of the instance they are for.
+type Store struct {
+	path string
+}
+
+func NewStore(path string) Store {
+	return Store{path: path}
+}
+
+func (store Store) read() (map[string]Token, error) {
+	tokens := map[string]Token{}
+	content, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading stored tokens.")
+	}
+	err = json.Unmarshal(content, &tokens)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing stored tokens.")
+	}
+	return tokens, nil
+}
+
+// Save stores the token for an instance, replacing any stored before. The file is only readable by the current user.
+func (store Store) Save(instanceURL string, token Token) error {
+	tokens, err := store.read()
+	if err != nil {
+		return err
+	}
+	tokens[NormalizeURL(instanceURL)] = token
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Error converting stored tokens to JSON.")
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0700)
+	if err != nil {
+		return errors.Wrap(err, "Error creating directory for stored tokens.")
+	}
+	err = ioutil.WriteFile(store.path, content, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Error writing stored tokens.")
+	}
+	return nil
+}
+
+// Token returns the stored token for an instance, or an empty string if there is none or it has expired.
+func (store Store) Token(instanceURL string) (string, error) {
+	if store.path == "" {
+		return "", nil
+	}
+	tokens, err := store.read()
+	if err != nil {
+		return "", err
+	}
+	token, ok := tokens[NormalizeURL(instanceURL)]
+	if !ok {
+		return "", nil
+	}
+	if token.Expired() {
+		log.Warnf("The stored token for %s expired at %s. Run the login command again to get a new one.", NormalizeURL(instanceURL), token.ExpiresAt.Format(time.RFC3339))
+		return "", nil
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,43 @@
+package login
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	storePath := filepath.Join(temporaryDirectory, "config", "tokens.json")
+	store := NewStore(storePath)
+
+	token, err := store.Token("https://github.com")
+	require.NoError(t, err)
+	require.Equal(t, "", token)
+
+	require.NoError(t, store.Save("https://github.com/", Token{AccessToken: "dotcom-token"}))
+	require.NoError(t, store.Save("https://ghes.example.com", Token{AccessToken: "ghes-token", ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.Save("https://old-ghes.example.com", Token{AccessToken: "expired-token", ExpiresAt: time.Now().Add(-time.Hour)}))
+	info, err := os.Stat(storePath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	token, err = store.Token("https://github.com")
+	require.NoError(t, err)
+	require.Equal(t, "dotcom-token", token)
+	token, err = store.Token("https://ghes.example.com/")
+	require.NoError(t, err)
+	require.Equal(t, "ghes-token", token)
+	token, err = store.Token("https://old-ghes.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "", token)
+
+	// Without a location to store tokens in, there are never any.
+	token, err = NewStore("").Token("https://github.com")
+	require.NoError(t, err)
+	require.Equal(t, "", token)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/pkg/errors"
+)
+
+// Release is the time spent syncing a release. Releases are synced concurrently and their assets are transferred alongside those of other releases, so this is the total time spent on each step for the release rather than the time from its first step to its last.
+type Release struct {
+	Tag             string  `json:"tag"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Document is the metrics of a run of the sync tool, for tracking the performance of syncs over time.
+type Document struct {
+	Command          string    `json:"command"`
+	Version          string    `json:"version"`
+	Success          bool      `json:"success"`
+	StartedAt        time.Time `json:"started_at"`
+	DurationSeconds  float64   `json:"duration_seconds"`
+	BytesDownloaded  int64     `json:"bytes_downloaded"`
+	BytesUploaded    int64     `json:"bytes_uploaded"`
+	APICalls         int64     `json:"api_calls"`
+	OtherRequests    int64     `json:"other_requests"`
+	Retries          int64     `json:"retries"`
+	RateLimitWaits   int64     `json:"rate_limit_waits"`
+	MaintenanceWaits int64     `json:"maintenance_waits"`
+	ReleaseDurations []Release `json:"release_durations"`
+}
+
+// Collector collects the metrics of a run. A nil collector collects nothing, so that callers do not need to check whether metrics were asked for.
+type Collector struct {
+	apiCalls         int64
+	otherRequests    int64
+	retries          int64
+	rateLimitWaits   int64
+	maintenanceWaits int64
+	lock             sync.Mutex
+	releases         map[string]time.Duration
+}
+
+func NewCollector() *Collector {
+	return &Collector{releases: map[string]time.Duration{}}
+}
+
+type contextKey struct{}
+
+// WithCollector returns a context which carries the collector to the code doing the work.
+func WithCollector(ctx context.Context, collector *Collector) context.Context {
+	return context.WithValue(ctx, contextKey{}, collector)
+}
+
+// FromContext returns the collector carried by the context, or nil if there is none.
+func FromContext(ctx context.Context) *Collector {
+	collector, _ := ctx.Value(contextKey{}).(*Collector)
+	return collector
+}
+
+// RecordRetry records a request or operation that is made again after failing with a transient error.
+func (collector *Collector) RecordRetry() {
+	if collector == nil {
+		return
+	}
+	atomic.AddInt64(&collector.retries, 1)
+}
+
+// RecordRateLimitWait records a wait for a secondary rate limit.
+func (collector *Collector) RecordRateLimitWait() {
+	if collector == nil {
+		return
+	}
+	atomic.AddInt64(&collector.rateLimitWaits, 1)
+}
+
+// RecordMaintenanceWait records a wait for GitHub Enterprise Server to come out of maintenance mode.
+func (collector *Collector) RecordMaintenanceWait() {
+	if collector == nil {
+		return
+	}
+	atomic.AddInt64(&collector.maintenanceWaits, 1)
+}
+
+// TimeRelease starts timing a step of syncing a release, and returns a function to call once the step is finished.
+func (collector *Collector) TimeRelease(tag string) func() {
+	if collector == nil {
+		return func() {}
+	}
+	startedAt := time.Now()
+	return func() {
+		duration := time.Since(startedAt)
+		collector.lock.Lock()
+		defer collector.lock.Unlock()
+		collector.releases[tag] += duration
+	}
+}
+
+// isAPIRequest returns whether a request is to the GitHub API, which the API client asks for the GitHub media types. Git operations, asset downloads and remote cache storage are counted separately.
+func isAPIRequest(request *http.Request) bool {
+	return strings.Contains(request.Header.Get("Accept"), "application/vnd.github")
+}
+
+type countingTransport struct {
+	base      http.RoundTripper
+	collector *Collector
+}
+
+func (transport *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if isAPIRequest(request) {
+		atomic.AddInt64(&transport.collector.apiCalls, 1)
+	} else {
+		atomic.AddInt64(&transport.collector.otherRequests, 1)
+	}
+	return transport.base.RoundTrip(request)
+}
+
+// Install counts all HTTP requests made through the default transport, in the same way as notify.InstallCounter.
+func (collector *Collector) Install() {
+	http.DefaultTransport = &countingTransport{base: http.DefaultTransport, collector: collector}
+}
+
+// Document combines what was collected with the summary of the run.
+func (collector *Collector) Document(summary notify.Summary) Document {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	document := Document{
+		Command:          summary.Command,
+		Version:          summary.Version,
+		Success:          summary.Success,
+		StartedAt:        summary.StartedAt,
+		DurationSeconds:  summary.DurationSeconds,
+		BytesDownloaded:  summary.BytesDownloaded,
+		BytesUploaded:    summary.BytesUploaded,
+		APICalls:         atomic.LoadInt64(&collector.apiCalls),
+		OtherRequests:    atomic.LoadInt64(&collector.otherRequests),
+		Retries:          atomic.LoadInt64(&collector.retries),
+		RateLimitWaits:   atomic.LoadInt64(&collector.rateLimitWaits),
+		MaintenanceWaits: atomic.LoadInt64(&collector.maintenanceWaits),
+		ReleaseDurations: []Release{},
+	}
+	for tag, duration := range collector.releases {
+		document.ReleaseDurations = append(document.ReleaseDurations, Release{Tag: tag, DurationSeconds: duration.Seconds()})
+	}
+	sort.Slice(document.ReleaseDurations, func(i, j int) bool {
+		return document.ReleaseDurations[i].Tag < document.ReleaseDurations[j].Tag
+	})
+	return document
+}
+
+func (document Document) Write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(document)
+	if err != nil {
+		return errors.Wrap(err, "Error writing metrics.")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilCollector(t *testing.T) {
+	collector := FromContext(context.Background())
+	require.Nil(t, collector)
+	collector.RecordRetry()
+	collector.RecordRateLimitWait()
+	collector.TimeRelease("codeql-bundle-20200101")()
+}
+
+func TestDocument(t *testing.T) {
+	collector := NewCollector()
+	ctx := WithCollector(context.Background(), collector)
+	require.Equal(t, collector, FromContext(ctx))
+
+	FromContext(ctx).RecordRetry()
+	FromContext(ctx).RecordRetry()
+	FromContext(ctx).RecordRateLimitWait()
+	collector.releases["codeql-bundle-20200630"] = 3 * time.Second
+	collector.releases["codeql-bundle-20200101"] = 1500 * time.Millisecond
+	FromContext(ctx).TimeRelease("codeql-bundle-20200101")()
+
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: &countingTransport{base: http.DefaultTransport, collector: collector}}
+	request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	request.Header.Set("Accept", "application/vnd.github.v3+json")
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	response, err = client.Get(server.URL)
+	require.NoError(t, err)
+	response.Body.Close()
+
+	startedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	document := collector.Document(notify.Summary{Command: "push", Version: "1.0.0", Success: true, StartedAt: startedAt, DurationSeconds: 10, BytesDownloaded: 100, BytesUploaded: 200})
+	require.Equal(t, "push", document.Command)
+	require.Equal(t, int64(100), document.BytesDownloaded)
+	require.Equal(t, int64(200), document.BytesUploaded)
+	require.Equal(t, int64(1), document.APICalls)
+	require.Equal(t, int64(1), document.OtherRequests)
+	require.Equal(t, int64(2), document.Retries)
+	require.Equal(t, int64(1), document.RateLimitWaits)
+	require.Len(t, document.ReleaseDurations, 2)
+	require.Equal(t, "codeql-bundle-20200101", document.ReleaseDurations[0].Tag)
+	require.GreaterOrEqual(t, document.ReleaseDurations[0].DurationSeconds, 1.5)
+	require.Equal(t, Release{Tag: "codeql-bundle-20200630", DurationSeconds: 3}, document.ReleaseDurations[1])
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, document.Write(&buffer))
+	parsed := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &parsed))
+	require.Equal(t, float64(1), parsed["api_calls"])
+	require.Equal(t, "2020-01-01T00:00:00Z", parsed["started_at"])
+}
@@ -0,0 +1,400 @@
+package migrationarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	usererrors "errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// FormatGHEMigrator is an archive in the format of GitHub migration archives, which `ghe-migrator` on GitHub Enterprise Server can import.
+const FormatGHEMigrator = "ghe-migrator"
+
+const errorUnknownFormat = "The export format must be `ghe-migrator`."
+const errorInvalidRepository = "The repository to export as must be given in the form `owner/name`."
+const errorNoGitRepository = "The cache does not have a Git repository to export. Run `pull` first."
+
+// schemaVersion is the version of the migration archive format that is written.
+const schemaVersion = "1.2.0"
+
+// archiveHost is the instance that URLs in the archive refer to. `ghe-migrator` maps them to the instance the archive is imported into.
+const archiveHost = "https://github.com"
+
+// ghostUser is the login that GitHub gives the author of anything whose author is not known, such as a release pulled from a local directory.
+const ghostUser = "ghost"
+
+// now is the time recorded as when everything in the archive was created, as the cache does not record when the repository was created.
+var now = time.Now
+
+type user struct {
+	Type      string    `json:"type"`
+	URL       string    `json:"url"`
+	Login     string    `json:"login"`
+	Name      string    `json:"name"`
+	Emails    []string  `json:"emails"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type organization struct {
+	Type        string   `json:"type"`
+	URL         string   `json:"url"`
+	Login       string   `json:"login"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []string `json:"members"`
+}
+
+type repository struct {
+	Type          string    `json:"type"`
+	URL           string    `json:"url"`
+	Owner         string    `json:"owner"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	Private       bool      `json:"private"`
+	HasIssues     bool      `json:"has_issues"`
+	HasWiki       bool      `json:"has_wiki"`
+	HasDownloads  bool      `json:"has_downloads"`
+	Labels        []string  `json:"labels"`
+	Collaborators []string  `json:"collaborators"`
+	CreatedAt     time.Time `json:"created_at"`
+	GitURL        string    `json:"git_url"`
+	DefaultBranch string    `json:"default_branch"`
+	Public        bool      `json:"public"`
+}
+
+type releaseAsset struct {
+	MediaType   string    `json:"media_type"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	User        string    `json:"user"`
+	AssetURL    string    `json:"asset_url"`
+}
+
+type release struct {
+	Type            string         `json:"type"`
+	URL             string         `json:"url"`
+	Repository      string         `json:"repository"`
+	User            string         `json:"user"`
+	Name            string         `json:"name"`
+	TagName         string         `json:"tag_name"`
+	Body            string         `json:"body"`
+	State           string         `json:"state"`
+	PendingTag      string         `json:"pending_tag"`
+	Prerelease      bool           `json:"prerelease"`
+	TargetCommitish string         `json:"target_commitish"`
+	ReleaseAssets   []releaseAsset `json:"release_assets"`
+	PublishedAt     *time.Time     `json:"published_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
+
+type exporter struct {
+	cacheDirectory cachedirectory.CacheDirectory
+	owner          string
+	name           string
+	writer         *tar.Writer
+	users          map[string]bool
+}
+
+func userURL(login string) string {
+	return archiveHost + "/" + login
+}
+
+func (exporter *exporter) repositoryURL() string {
+	return archiveHost + "/" + exporter.owner + "/" + exporter.name
+}
+
+// tarballURL refers to a file inside the archive.
+func tarballURL(name string) string {
+	return "tarball://root/" + name
+}
+
+func (exporter *exporter) writeFile(name string, size int64, mode int64, content io.Reader) error {
+	err := exporter.writer.WriteHeader(&tar.Header{Name: name, Size: size, Mode: mode, ModTime: now(), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(exporter.writer, content)
+	return err
+}
+
+func (exporter *exporter) writeJSON(name string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "Error converting %s to JSON.", name)
+	}
+	err = exporter.writeFile(name, int64(len(content)), 0644, bytes.NewReader(content))
+	if err != nil {
+		return errors.Wrapf(err, "Error writing %s to archive.", name)
+	}
+	return nil
+}
+
+// writeGit adds the Git repository cache to the archive as a bare repository.
+func (exporter *exporter) writeGit(gitPath string) error {
+	prefix := "repositories/" + exporter.owner + "/" + exporter.name + ".git"
+	return filepath.Walk(gitPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(gitPath, filePath)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(relative))
+		if info.IsDir() {
+			return exporter.writer.WriteHeader(&tar.Header{Name: name + "/", Mode: 0755, ModTime: info.ModTime(), Typeflag: tar.TypeDir})
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return exporter.writeFile(name, info.Size(), int64(info.Mode().Perm()), file)
+	})
+}
+
+func (exporter *exporter) author(metadata *github.RepositoryRelease) string {
+	login := metadata.GetAuthor().GetLogin()
+	if login == "" {
+		login = ghostUser
+	}
+	exporter.users[login] = true
+	return userURL(login)
+}
+
+// writeRelease adds the assets of a cached release to the archive and returns its description.
+func (exporter *exporter) writeRelease(releaseTag string) (*release, error) {
+	metadataJSON, err := exporter.cacheDirectory.ReadMetadata(releaseTag)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading metadata of release %s.", releaseTag)
+	}
+	metadata := github.RepositoryRelease{}
+	err = json.Unmarshal(metadataJSON, &metadata)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error decoding metadata of release %s.", releaseTag)
+	}
+	sourceAssets := map[string]*github.ReleaseAsset{}
+	for _, sourceAsset := range metadata.Assets {
+		sourceAssets[sourceAsset.GetName()] = sourceAsset
+	}
+	tagName := metadata.GetTagName()
+	if tagName == "" {
+		tagName = releaseTag
+	}
+	author := exporter.author(&metadata)
+	createdAt := metadata.GetCreatedAt().Time
+	if createdAt.IsZero() {
+		createdAt = now()
+	}
+	exported := &release{
+		Type:            "release",
+		URL:             exporter.repositoryURL() + "/releases/tag/" + tagName,
+		Repository:      exporter.repositoryURL(),
+		User:            author,
+		Name:            metadata.GetName(),
+		TagName:         tagName,
+		Body:            metadata.GetBody(),
+		State:           "published",
+		PendingTag:      tagName,
+		Prerelease:      metadata.GetPrerelease(),
+		TargetCommitish: metadata.GetTargetCommitish(),
+		ReleaseAssets:   []releaseAsset{},
+		CreatedAt:       createdAt,
+	}
+	if metadata.GetDraft() {
+		exported.State = "draft"
+	} else {
+		publishedAt := metadata.GetPublishedAt().Time
+		if publishedAt.IsZero() {
+			publishedAt = createdAt
+		}
+		exported.PublishedAt = &publishedAt
+	}
+
+	assets, err := exporter.cacheDirectory.ListAssets(releaseTag)
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range assets {
+		log.Debugf("Adding asset %s of release %s to the archive...", asset.Name, releaseTag)
+		name := "releases/" + exporter.owner + "/" + exporter.name + "/" + releaseTag + "/" + asset.Name
+		reader, err := exporter.cacheDirectory.OpenAsset(releaseTag, asset.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error opening cached asset.")
+		}
+		err = exporter.writeFile(name, asset.Size, 0644, reader)
+		reader.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error adding asset %s of release %s to the archive.", asset.Name, releaseTag)
+		}
+		contentType := sourceAssets[asset.Name].GetContentType()
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		exported.ReleaseAssets = append(exported.ReleaseAssets, releaseAsset{
+			MediaType:   "release_asset",
+			Name:        asset.Name,
+			ContentType: contentType,
+			Size:        asset.Size,
+			CreatedAt:   createdAt,
+			User:        author,
+			AssetURL:    tarballURL(name),
+		})
+	}
+	return exported, nil
+}
+
+func (exporter *exporter) export() error {
+	err := exporter.cacheDirectory.LoadGit()
+	if err != nil {
+		return err
+	}
+	gitRepository, err := git.PlainOpen(exporter.cacheDirectory.GitPath())
+	if err == git.ErrRepositoryNotExists {
+		return usererrors.New(errorNoGitRepository)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error opening Git repository cache.")
+	}
+	defaultBranch := "main"
+	head, err := gitRepository.Reference(plumbing.HEAD, false)
+	if err == nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		defaultBranch = head.Target().Short()
+	}
+	log.Debug("Adding the Git repository to the archive...")
+	err = exporter.writeGit(exporter.cacheDirectory.GitPath())
+	if err != nil {
+		return errors.Wrap(err, "Error adding the Git repository to the archive.")
+	}
+
+	releaseTags, err := exporter.cacheDirectory.ListReleases()
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+	sort.Strings(releaseTags)
+	releases := []*release{}
+	for _, releaseTag := range releaseTags {
+		exported, err := exporter.writeRelease(releaseTag)
+		if err != nil {
+			return err
+		}
+		releases = append(releases, exported)
+	}
+
+	createdAt := now()
+	exporter.users[ghostUser] = true
+	logins := []string{}
+	for login := range exporter.users {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	users := []user{}
+	for _, login := range logins {
+		users = append(users, user{Type: "user", URL: userURL(login), Login: login, Name: login, Emails: []string{}, CreatedAt: createdAt})
+	}
+	for _, file := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"schema.json", map[string]string{"version": schemaVersion}},
+		{"urls.json", map[string]interface{}{
+			"user":         "{scheme}://{host}/{user}",
+			"organization": "{scheme}://{host}/{organization}",
+			"repository":   "{scheme}://{host}/{owner}/{repository}",
+			"release":      "{scheme}://{host}/{owner}/{repository}/releases/tag/{release}",
+		}},
+		{"users_000001.json", users},
+		{"organizations_000001.json", []organization{{Type: "organization", URL: userURL(exporter.owner), Login: exporter.owner, Name: exporter.owner, Members: []string{}}}},
+		{"repositories_000001.json", []repository{{
+			Type:          "repository",
+			URL:           exporter.repositoryURL(),
+			Owner:         userURL(exporter.owner),
+			Name:          exporter.name,
+			Description:   "A mirror of the CodeQL Action, made by the CodeQL Action sync tool.",
+			HasDownloads:  true,
+			Labels:        []string{},
+			Collaborators: []string{},
+			CreatedAt:     createdAt,
+			GitURL:        tarballURL("repositories/" + exporter.owner + "/" + exporter.name + ".git"),
+			DefaultBranch: defaultBranch,
+			Public:        true,
+		}}},
+		{"releases_000001.json", releases},
+	} {
+		err := exporter.writeJSON(file.name, file.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export writes the Git repository and releases in the cache to an archive in the given format, as the repository `owner/name`.
+func Export(cacheDirectory cachedirectory.CacheDirectory, archivePath string, format string, repositoryName string) error {
+	if format != FormatGHEMigrator {
+		return usererrors.New(errorUnknownFormat)
+	}
+	parts := strings.Split(repositoryName, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return usererrors.New(errorInvalidRepository)
+	}
+	err := cacheDirectory.CheckOrCreateVersionFile(false, version.Version())
+	if err != nil {
+		return err
+	}
+	err = cacheDirectory.CheckLock()
+	if err != nil {
+		return err
+	}
+
+	// The archive is written next to where it is going, so that an interrupted export never leaves a partial archive behind.
+	file, err := ioutil.TempFile(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".*")
+	if err != nil {
+		return errors.Wrap(err, "Error creating archive.")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	compressor := gzip.NewWriter(file)
+	exporter := exporter{
+		cacheDirectory: cacheDirectory,
+		owner:          parts[0],
+		name:           parts[1],
+		writer:         tar.NewWriter(compressor),
+		users:          map[string]bool{},
+	}
+	err = exporter.export()
+	if err != nil {
+		return err
+	}
+	for _, closer := range []io.Closer{exporter.writer, compressor, file} {
+		err = closer.Close()
+		if err != nil {
+			return errors.Wrap(err, "Error writing archive.")
+		}
+	}
+	err = os.Rename(file.Name(), archivePath)
+	if err != nil {
+		return errors.Wrap(err, "Error writing archive.")
+	}
+	log.Infof("Exported the cache as %s to %s.", repositoryName, archivePath)
+	return nil
+}
@@ -0,0 +1,126 @@
+package migrationarchive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+const aCommit = "b9f01aa2c50f49898d4c7845a66be8824499fe9d"
+
+func getTestCacheDirectory(t *testing.T) cachedirectory.CacheDirectory {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := cachedirectory.NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, version.Version()))
+	require.NoError(t, cacheDirectory.WriteMetadata("codeql-bundle-20200101", []byte(`{"tag_name": "codeql-bundle-20200101", "name": "CodeQL Bundle", "body": "Bundle notes.", "target_commitish": "main", "author": {"login": "github-actions[bot]"}, "created_at": "2020-01-01T00:00:00Z", "published_at": "2020-01-02T00:00:00Z", "assets": [{"name": "codeql-bundle.tar.gz", "content_type": "application/gzip"}]}`)))
+	require.NoError(t, cacheDirectory.WriteAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", strings.NewReader("a bundle"), 8))
+	require.NoError(t, cacheDirectory.WriteMetadata("codeql-bundle-20200630", []byte(`{"tag_name": "codeql-bundle-20200630", "draft": true}`)))
+	repository, err := git.PlainInit(cacheDirectory.GitPath(), true)
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/v1", plumbing.NewHash(aCommit))))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/v1")))
+	return cacheDirectory
+}
+
+func readArchive(t *testing.T, archivePath string) map[string]string {
+	file, err := os.Open(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+	decompressor, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	reader := tar.NewReader(decompressor)
+	files := map[string]string{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+}
+
+func TestExport(t *testing.T) {
+	now = func() time.Time { return time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	cacheDirectory := getTestCacheDirectory(t)
+	archivePath := path.Join(test.CreateTemporaryDirectory(t), "codeql-action.tar.gz")
+	require.NoError(t, Export(cacheDirectory, archivePath, FormatGHEMigrator, "octo-org/codeql-action"))
+
+	files := readArchive(t, archivePath)
+	require.JSONEq(t, `{"version": "1.2.0"}`, files["schema.json"])
+	require.Contains(t, files, "repositories/octo-org/codeql-action.git/HEAD")
+	require.Equal(t, "ref: refs/heads/v1\n", files["repositories/octo-org/codeql-action.git/HEAD"])
+	require.Equal(t, "a bundle", files["releases/octo-org/codeql-action/codeql-bundle-20200101/codeql-bundle.tar.gz"])
+
+	repositories := []repository{}
+	require.NoError(t, json.Unmarshal([]byte(files["repositories_000001.json"]), &repositories))
+	require.Len(t, repositories, 1)
+	require.Equal(t, "https://github.com/octo-org/codeql-action", repositories[0].URL)
+	require.Equal(t, "https://github.com/octo-org", repositories[0].Owner)
+	require.Equal(t, "tarball://root/repositories/octo-org/codeql-action.git", repositories[0].GitURL)
+	require.Equal(t, "v1", repositories[0].DefaultBranch)
+
+	releases := []release{}
+	require.NoError(t, json.Unmarshal([]byte(files["releases_000001.json"]), &releases))
+	require.Len(t, releases, 2)
+	published := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, release{
+		Type:            "release",
+		URL:             "https://github.com/octo-org/codeql-action/releases/tag/codeql-bundle-20200101",
+		Repository:      "https://github.com/octo-org/codeql-action",
+		User:            "https://github.com/github-actions[bot]",
+		Name:            "CodeQL Bundle",
+		TagName:         "codeql-bundle-20200101",
+		Body:            "Bundle notes.",
+		State:           "published",
+		PendingTag:      "codeql-bundle-20200101",
+		TargetCommitish: "main",
+		ReleaseAssets: []releaseAsset{{
+			MediaType:   "release_asset",
+			Name:        "codeql-bundle.tar.gz",
+			ContentType: "application/gzip",
+			Size:        8,
+			CreatedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			User:        "https://github.com/github-actions[bot]",
+			AssetURL:    "tarball://root/releases/octo-org/codeql-action/codeql-bundle-20200101/codeql-bundle.tar.gz",
+		}},
+		PublishedAt: &published,
+		CreatedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, releases[0])
+	// Draft releases stay drafts, and a release without an author is attributed to the ghost user.
+	require.Equal(t, "draft", releases[1].State)
+	require.Nil(t, releases[1].PublishedAt)
+	require.Equal(t, "https://github.com/ghost", releases[1].User)
+
+	users := []user{}
+	require.NoError(t, json.Unmarshal([]byte(files["users_000001.json"]), &users))
+	require.Len(t, users, 2)
+	require.Equal(t, "ghost", users[0].Login)
+	require.Equal(t, "github-actions[bot]", users[1].Login)
+}
+
+func TestExportInvalidArguments(t *testing.T) {
+	cacheDirectory := getTestCacheDirectory(t)
+	archivePath := path.Join(test.CreateTemporaryDirectory(t), "codeql-action.tar.gz")
+	require.EqualError(t, Export(cacheDirectory, archivePath, "zip", "octo-org/codeql-action"), errorUnknownFormat)
+	require.EqualError(t, Export(cacheDirectory, archivePath, FormatGHEMigrator, "codeql-action"), errorInvalidRepository)
+	_, err := os.Stat(archivePath)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/pkg/errors"
+)
+
+const sendTimeout = 30 * time.Second
+
+// Summary describes the outcome of a run of the sync tool.
+type Summary struct {
+	Command               string    `json:"command"`
+	Version               string    `json:"version"`
+	Success               bool      `json:"success"`
+	ExitCode              int       `json:"exit_code"`
+	Error                 string    `json:"error,omitempty"`
+	StartedAt             time.Time `json:"started_at"`
+	FinishedAt            time.Time `json:"finished_at"`
+	DurationSeconds       float64   `json:"duration_seconds"`
+	Releases              []string  `json:"releases"`
+	Destination           string    `json:"destination,omitempty"`
+	DestinationFailedFast bool      `json:"destination_failed_fast,omitempty"`
+	NewReleases           []string  `json:"new_releases,omitempty"`
+	BytesDownloaded       int64     `json:"bytes_downloaded"`
+	BytesUploaded         int64     `json:"bytes_uploaded"`
+}
+
+type countingReadCloser struct {
+	reader io.ReadCloser
+	count  *int64
+}
+
+func (countingReadCloser *countingReadCloser) Read(data []byte) (int, error) {
+	count, err := countingReadCloser.reader.Read(data)
+	atomic.AddInt64(countingReadCloser.count, int64(count))
+	return count, err
+}
+
+func (countingReadCloser *countingReadCloser) Close() error {
+	return countingReadCloser.reader.Close()
+}
+
+func newCountingReadCloser(reader io.ReadCloser, count *int64) io.ReadCloser {
+	if reader == nil || reader == http.NoBody {
+		return reader
+	}
+	return &countingReadCloser{reader: reader, count: count}
+}
+
+// Counter is an HTTP transport which counts the bytes in request and response bodies.
+type Counter struct {
+	Base       http.RoundTripper
+	downloaded int64
+	uploaded   int64
+}
+
+func (counter *Counter) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		request = request.Clone(request.Context())
+		request.Body = newCountingReadCloser(request.Body, &counter.uploaded)
+		if getBody := request.GetBody; getBody != nil {
+			request.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return newCountingReadCloser(body, &counter.uploaded), nil
+			}
+		}
+	}
+	response, err := counter.Base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = newCountingReadCloser(response.Body, &counter.downloaded)
+	return response, nil
+}
+
+// Downloaded returns the number of bytes received so far.
+func (counter *Counter) Downloaded() int64 {
+	return atomic.LoadInt64(&counter.downloaded)
+}
+
+// Uploaded returns the number of bytes sent so far.
+func (counter *Counter) Uploaded() int64 {
+	return atomic.LoadInt64(&counter.uploaded)
+}
+
+// InstallCounter counts all HTTP traffic made through the default transport, which includes the GitHub API, Git operations over HTTPS and remote cache storage.
+func InstallCounter() *Counter {
+	counter := &Counter{Base: http.DefaultTransport}
+	http.DefaultTransport = counter
+	return counter
+}
+
+// NewSummary creates a summary of a run of the given command, which started at the given time and has just finished.
+func NewSummary(command string, startedAt time.Time, releases []string, counter *Counter, err error) Summary {
+	finishedAt := time.Now()
+	summary := Summary{
+		Command:         command,
+		Version:         version.Version(),
+		Success:         err == nil,
+		ExitCode:        exitcode.Code(err),
+		StartedAt:       startedAt.UTC(),
+		FinishedAt:      finishedAt.UTC(),
+		DurationSeconds: finishedAt.Sub(startedAt).Seconds(),
+		Releases:        releases,
+	}
+	if summary.Releases == nil {
+		summary.Releases = []string{}
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	if counter != nil {
+		summary.BytesDownloaded = counter.Downloaded()
+		summary.BytesUploaded = counter.Uploaded()
+	}
+	return summary
+}
+
+// Send posts the summary as JSON to the webhook URL.
+func Send(ctx context.Context, webhookURL string, summary Summary) error {
+	return postJSON(ctx, webhookURL, summary)
+}
+
+func postJSON(ctx context.Context, webhookURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "Error converting notification to JSON.")
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Error constructing webhook notification.")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "codeql-action-sync/"+version.Version())
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "Error sending webhook notification.")
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return errors.Errorf("Status code %d while sending webhook notification.", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/echo", func(response http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		test.ServeHTTPResponseFromString(t, string(body)+string(body), response)
+	}).Methods("POST")
+
+	counter := &Counter{Base: http.DefaultTransport}
+	client := http.Client{Transport: counter}
+	response, err := client.Post(githubURL+"/echo", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	require.Equal(t, "hellohello", string(body))
+	require.Equal(t, int64(5), counter.Uploaded())
+	require.Equal(t, int64(10), counter.Downloaded())
+}
+
+func TestSend(t *testing.T) {
+	githubTestServer, webhookURL := test.GetTestHTTPServer(t)
+	received := Summary{}
+	githubTestServer.HandleFunc("/hook", func(response http.ResponseWriter, request *http.Request) {
+		require.Equal(t, "application/json", request.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&received))
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/broken", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusInternalServerError)
+	}).Methods("POST")
+
+	summary := NewSummary("sync", time.Now().Add(-time.Minute), []string{"codeql-bundle-20200630"}, nil, errors.New("Something went wrong."))
+	require.NoError(t, Send(context.Background(), webhookURL+"/hook", summary))
+	require.Equal(t, "sync", received.Command)
+	require.False(t, received.Success)
+	require.Equal(t, "Something went wrong.", received.Error)
+	require.Equal(t, []string{"codeql-bundle-20200630"}, received.Releases)
+	require.InDelta(t, 60, received.DurationSeconds, 5)
+
+	require.Error(t, Send(context.Background(), webhookURL+"/broken", summary))
+}
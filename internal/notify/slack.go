@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type slackMessage struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+}
+
+// slackText describes the summary for a Slack message, or returns an empty string if there is nothing worth telling people about.
+func slackText(summary Summary) string {
+	duration := (time.Duration(summary.DurationSeconds) * time.Second).String()
+	if !summary.Success {
+		text := fmt.Sprintf(":x: The CodeQL Action sync tool `%s` command failed after %s: %s", summary.Command, duration, summary.Error)
+		if summary.DestinationFailedFast {
+			text += fmt.Sprintf("\n%s kept failing, so it was treated as down for the rest of the run.", summary.Destination)
+		}
+		return text
+	}
+	if len(summary.NewReleases) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(":white_check_mark: New CodeQL Action versions are available on %s, using the CodeQL bundles %s.", summary.Destination, strings.Join(summary.NewReleases, ", "))
+}
+
+// SendSlack posts a short message about the summary to a Slack incoming webhook, if the run failed or pushed new releases. If channel is not empty the message is posted there rather than to the default channel of the webhook.
+func SendSlack(ctx context.Context, webhookURL string, channel string, summary Summary) error {
+	text := slackText(summary)
+	if text == "" {
+		return nil
+	}
+	return postJSON(ctx, webhookURL, slackMessage{Channel: channel, Text: text})
+}
@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlackText(t *testing.T) {
+	summary := NewSummary("push", time.Now().Add(-90*time.Second), []string{"codeql-bundle-20200630"}, nil, nil)
+	require.Equal(t, "", slackText(summary))
+
+	summary.Destination = "https://ghes.example.com/github/codeql-action"
+	summary.NewReleases = []string{"codeql-bundle-20200630"}
+	require.Equal(t, ":white_check_mark: New CodeQL Action versions are available on https://ghes.example.com/github/codeql-action, using the CodeQL bundles codeql-bundle-20200630.", slackText(summary))
+
+	summary = NewSummary("sync", time.Now().Add(-90*time.Second), nil, nil, errors.New("Error doing Git fetch."))
+	require.Equal(t, ":x: The CodeQL Action sync tool `sync` command failed after 1m30s: Error doing Git fetch.", slackText(summary))
+
+	summary.Destination = "https://ghes.example.com/github/codeql-action"
+	summary.DestinationFailedFast = true
+	require.Equal(t, ":x: The CodeQL Action sync tool `sync` command failed after 1m30s: Error doing Git fetch.\nhttps://ghes.example.com/github/codeql-action kept failing, so it was treated as down for the rest of the run.", slackText(summary))
+}
+
+func TestSendSlack(t *testing.T) {
+	githubTestServer, webhookURL := test.GetTestHTTPServer(t)
+	messages := []slackMessage{}
+	githubTestServer.HandleFunc("/slack", func(response http.ResponseWriter, request *http.Request) {
+		message := slackMessage{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&message))
+		messages = append(messages, message)
+		test.ServeHTTPResponseFromString(t, "ok", response)
+	}).Methods("POST")
+
+	summary := NewSummary("push", time.Now(), []string{}, nil, nil)
+	require.NoError(t, SendSlack(context.Background(), webhookURL+"/slack", "#security", summary))
+	require.Empty(t, messages)
+
+	summary = NewSummary("push", time.Now(), []string{}, nil, errors.New("Something went wrong."))
+	require.NoError(t, SendSlack(context.Background(), webhookURL+"/slack", "#security", summary))
+	require.Len(t, messages, 1)
+	require.Equal(t, "#security", messages[0].Channel)
+	require.Contains(t, messages[0].Text, "Something went wrong.")
+}
@@ -0,0 +1,303 @@
+package packs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const errorInvalidPackReference = "The CodeQL pack %s given with `--packs` is not valid. It should be given as scope/name, optionally followed by @version."
+
+var packNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*/[a-z0-9][a-z0-9-]*$`)
+
+// descriptor refers to a blob from an image manifest.
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// manifest is the part of an image manifest that the sync tool needs to copy an image.
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+func (manifest *manifest) blobs() []descriptor {
+	return append([]descriptor{manifest.Config}, manifest.Layers...)
+}
+
+func parseManifest(content []byte) (*manifest, error) {
+	parsed := manifest{}
+	err := json.Unmarshal(content, &parsed)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing CodeQL pack manifest.")
+	}
+	if parsed.Config.Digest == "" {
+		return nil, fmt.Errorf("The CodeQL pack manifest has the media type %q, which is not an image manifest.", parsed.MediaType)
+	}
+	for _, blob := range parsed.blobs() {
+		if !strings.HasPrefix(blob.Digest, "sha256:") {
+			return nil, fmt.Errorf("The CodeQL pack manifest refers to %s, but only SHA-256 digests are supported.", blob.Digest)
+		}
+	}
+	return &parsed, nil
+}
+
+// parseReference splits a pack given as `scope/name@version` into its name and version, which is empty if not given.
+func parseReference(reference string) (string, string, error) {
+	name := reference
+	version := ""
+	if index := strings.LastIndex(reference, "@"); index >= 0 {
+		name = reference[:index]
+		version = reference[index+1:]
+		if version == "" {
+			return "", "", fmt.Errorf(errorInvalidPackReference, reference)
+		}
+	}
+	if !packNamePattern.MatchString(name) {
+		return "", "", fmt.Errorf(errorInvalidPackReference, reference)
+	}
+	return name, version, nil
+}
+
+// semanticVersion is a version such as 1.2.3 or 1.2.3-beta, as CodeQL packs are versioned.
+type semanticVersion struct {
+	numbers    [3]int
+	prerelease string
+}
+
+var semanticVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+func parseSemanticVersion(version string) (semanticVersion, bool) {
+	match := semanticVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return semanticVersion{}, false
+	}
+	parsed := semanticVersion{prerelease: match[4]}
+	for index := range parsed.numbers {
+		parsed.numbers[index], _ = strconv.Atoi(match[index+1])
+	}
+	return parsed, true
+}
+
+func (version semanticVersion) newerThan(other semanticVersion) bool {
+	for index := range version.numbers {
+		if version.numbers[index] != other.numbers[index] {
+			return version.numbers[index] > other.numbers[index]
+		}
+	}
+	// A prerelease comes before the release of the same version.
+	if (version.prerelease == "") != (other.prerelease == "") {
+		return version.prerelease == ""
+	}
+	return version.prerelease > other.prerelease
+}
+
+// latestVersion returns the newest tag that is a version and not a prerelease, or an empty string if there is none.
+func latestVersion(tags []string) string {
+	latest := ""
+	var latestParsed semanticVersion
+	for _, tag := range tags {
+		parsed, ok := parseSemanticVersion(tag)
+		if !ok || parsed.prerelease != "" {
+			continue
+		}
+		if latest == "" || parsed.newerThan(latestParsed) {
+			latest = tag
+			latestParsed = parsed
+		}
+	}
+	return latest
+}
+
+type pullService struct {
+	ctx            context.Context
+	cacheDirectory cachedirectory.CacheDirectory
+	registry       *registry
+}
+
+func (pullService *pullService) listTags(name string) ([]string, error) {
+	tags := []string{}
+	requestURL := "/v2/" + name + "/tags/list?n=1000"
+	for requestURL != "" {
+		response, err := pullService.registry.do(http.MethodGet, requestURL, repositoryScope(name, "pull"), nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		err = checkResponse(response, "listing the versions of CodeQL pack "+name, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		page := struct {
+			Tags []string `json:"tags"`
+		}{}
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading CodeQL pack versions.")
+		}
+		tags = append(tags, page.Tags...)
+		requestURL = nextPage(response)
+	}
+	return tags, nil
+}
+
+// nextPage returns the location of the next page of a paginated response, as given by its `Link` header, or an empty string if it is the last page.
+func nextPage(response *http.Response) string {
+	link := response.Header.Get("Link")
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start := strings.IndexByte(link, '<')
+	end := strings.IndexByte(link, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	next, err := response.Request.URL.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return next.String()
+}
+
+func (pullService *pullService) getManifest(name string, version string) ([]byte, error) {
+	header := http.Header{"Accept": []string{strings.Join(manifestMediaTypes, ", ")}}
+	response, err := pullService.registry.do(http.MethodGet, "/v2/"+name+"/manifests/"+url.PathEscape(version), repositoryScope(name, "pull"), header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, fmt.Errorf("The CodeQL pack %s@%s could not be found in the container registry.", name, version)
+	}
+	err = checkResponse(response, "getting CodeQL pack "+name+"@"+version, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading CodeQL pack manifest.")
+	}
+	return content, nil
+}
+
+// isCached returns whether a blob is already in the cache. A download that does not match its digest is removed, and an interrupted one is too small, so only the size needs to be checked here.
+func (pullService *pullService) isCached(blob descriptor) (bool, error) {
+	size, err := pullService.cacheDirectory.PackBlobSize(blob.Digest)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Error checking cached CodeQL pack.")
+	}
+	return size == blob.Size, nil
+}
+
+func (pullService *pullService) downloadBlob(name string, blob descriptor) error {
+	cached, err := pullService.isCached(blob)
+	if err != nil || cached {
+		return err
+	}
+	response, err := pullService.registry.do(http.MethodGet, "/v2/"+name+"/blobs/"+blob.Digest, repositoryScope(name, "pull"), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response, "downloading CodeQL pack "+name, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	hash := sha256.New()
+	err = pullService.cacheDirectory.WritePackBlob(blob.Digest, io.TeeReader(io.LimitReader(response.Body, blob.Size), hash), blob.Size)
+	if err != nil {
+		return errors.Wrap(err, "Error writing CodeQL pack to cache.")
+	}
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != blob.Digest {
+		pullService.cacheDirectory.RemovePackBlob(blob.Digest)
+		return fmt.Errorf("The download of %s from CodeQL pack %s does not match its digest, but was %s.", blob.Digest, name, digest)
+	}
+	return nil
+}
+
+func (pullService *pullService) pullPack(reference string) error {
+	name, version, err := parseReference(reference)
+	if err != nil {
+		return err
+	}
+	if version == "" {
+		tags, err := pullService.listTags(name)
+		if err != nil {
+			return err
+		}
+		version = latestVersion(tags)
+		if version == "" {
+			return fmt.Errorf("The CodeQL pack %s has no released versions in the container registry.", name)
+		}
+	}
+	pack := cachedirectory.Pack{Name: name, Version: version}
+	log.Infof("Pulling CodeQL pack %s...", pack)
+	content, err := pullService.getManifest(name, version)
+	if err != nil {
+		return err
+	}
+	parsed, err := parseManifest(content)
+	if err != nil {
+		return err
+	}
+	for _, blob := range parsed.blobs() {
+		err = pullService.downloadBlob(name, blob)
+		if err != nil {
+			return err
+		}
+	}
+	// The manifest is written last, so a pack is only listed in the cache once everything it refers to is there.
+	err = pullService.cacheDirectory.WritePackManifest(pack, content)
+	if err != nil {
+		return errors.Wrap(err, "Error writing CodeQL pack manifest to cache.")
+	}
+	return nil
+}
+
+// Pull pulls CodeQL packs from a container registry into the cache, so that they can be pushed to the registry of the destination. Each pack is given as `scope/name` for its latest version, or as `scope/name@version`. Without a token, only public packs can be pulled.
+func Pull(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, registryURL string, token string, references []string) error {
+	if len(references) == 0 {
+		return nil
+	}
+	for _, reference := range references {
+		_, _, err := parseReference(reference)
+		if err != nil {
+			return err
+		}
+	}
+	pullService := pullService{
+		ctx:            ctx,
+		cacheDirectory: cacheDirectory,
+		registry:       newRegistry(ctx, registryURL, retry.NewClient(nil), token),
+	}
+	for _, reference := range references {
+		err := pullService.pullPack(reference)
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("Finished pulling CodeQL packs!")
+	return nil
+}
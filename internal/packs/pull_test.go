@@ -0,0 +1,78 @@
+package packs
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestCacheDirectory(t *testing.T) cachedirectory.CacheDirectory {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := cachedirectory.NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, "1.0.0"))
+	return cacheDirectory
+}
+
+func TestParseReference(t *testing.T) {
+	name, version, err := parseReference("codeql/java-queries")
+	require.NoError(t, err)
+	require.Equal(t, "codeql/java-queries", name)
+	require.Equal(t, "", version)
+	name, version, err = parseReference("codeql/java-queries@1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, "codeql/java-queries", name)
+	require.Equal(t, "1.2.3", version)
+	for _, reference := range []string{"java-queries", "codeql/java-queries@", "codeql/Java-Queries", "codeql/java/queries"} {
+		_, _, err = parseReference(reference)
+		require.EqualError(t, err, "The CodeQL pack "+reference+" given with `--packs` is not valid. It should be given as scope/name, optionally followed by @version.")
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	require.Equal(t, "1.10.0", latestVersion([]string{"1.2.0", "1.10.0", "1.9.9", "latest", "2.0.0-beta"}))
+	require.Equal(t, "", latestVersion([]string{"latest", "1.0.0-beta"}))
+}
+
+func TestPullPacks(t *testing.T) {
+	registry := startTestRegistry(t)
+	defer registry.close()
+	registry.anonymous = true
+	registry.addPack("codeql/java-queries", "1.0.0", "java 1.0.0")
+	java := registry.addPack("codeql/java-queries", "1.1.0", "java 1.1.0")
+	registry.addPack("codeql/java-queries", "2.0.0-beta", "java 2.0.0-beta")
+	cpp := registry.addPack("codeql/cpp-queries", "1.0.0", "cpp 1.0.0")
+
+	cacheDirectory := getTestCacheDirectory(t)
+	err := Pull(context.Background(), cacheDirectory, registry.server.URL, "", []string{"codeql/java-queries", "codeql/cpp-queries@1.0.0"})
+	require.NoError(t, err)
+	packs, err := cacheDirectory.ListPacks()
+	require.NoError(t, err)
+	require.Equal(t, []cachedirectory.Pack{{Name: "codeql/cpp-queries", Version: "1.0.0"}, {Name: "codeql/java-queries", Version: "1.1.0"}}, packs)
+	manifest, err := cacheDirectory.ReadPackManifest(packs[1])
+	require.NoError(t, err)
+	require.Equal(t, java, manifest)
+	manifest, err = cacheDirectory.ReadPackManifest(packs[0])
+	require.NoError(t, err)
+	require.Equal(t, cpp, manifest)
+
+	// Pulling again uses the cached blobs.
+	registry.blobs = map[string][]byte{}
+	err = Pull(context.Background(), cacheDirectory, registry.server.URL, "", []string{"codeql/java-queries"})
+	require.NoError(t, err)
+}
+
+func TestPullPackNotFound(t *testing.T) {
+	registry := startTestRegistry(t)
+	defer registry.close()
+	registry.addPack("codeql/java-queries", "1.0.0", "java 1.0.0")
+
+	cacheDirectory := getTestCacheDirectory(t)
+	err := Pull(context.Background(), cacheDirectory, registry.server.URL, "password", []string{"codeql/java-queries@1.1.0"})
+	require.EqualError(t, err, "The CodeQL pack codeql/java-queries@1.1.0 could not be found in the container registry.")
+	err = Pull(context.Background(), cacheDirectory, registry.server.URL, "password", []string{"codeql/cpp-queries"})
+	require.EqualError(t, err, "The CodeQL pack codeql/cpp-queries has no released versions in the container registry.")
+}
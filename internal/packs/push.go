@@ -0,0 +1,138 @@
+package packs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type pushService struct {
+	ctx            context.Context
+	cacheDirectory cachedirectory.CacheDirectory
+	registry       *registry
+}
+
+func (pushService *pushService) hasBlob(name string, blob descriptor) (bool, error) {
+	response, err := pushService.registry.do(http.MethodHead, "/v2/"+name+"/blobs/"+blob.Digest, repositoryScope(name, "pull,push"), nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return false, nil
+	}
+	err = checkResponse(response, "checking CodeQL pack "+name, http.StatusOK)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	return true, nil
+}
+
+// uploadBlob uploads a blob from the cache in one request, once the registry has given a location to upload it to.
+func (pushService *pushService) uploadBlob(name string, blob descriptor) error {
+	exists, err := pushService.hasBlob(name, blob)
+	if err != nil || exists {
+		return err
+	}
+	scope := repositoryScope(name, "pull,push")
+	response, err := pushService.registry.do(http.MethodPost, "/v2/"+name+"/blobs/uploads/", scope, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response, "starting upload of CodeQL pack "+name, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	location, err := response.Request.URL.Parse(response.Header.Get("Location"))
+	if err != nil || response.Header.Get("Location") == "" {
+		return fmt.Errorf("The container registry did not say where to upload CodeQL pack %s.", name)
+	}
+	query := location.Query()
+	query.Set("digest", blob.Digest)
+	location.RawQuery = query.Encode()
+
+	reader, err := pushService.cacheDirectory.OpenPackBlob(blob.Digest)
+	if err != nil {
+		return exitcode.WithCode(errors.Wrapf(err, "Error reading %s of CodeQL pack %s from cache.", blob.Digest, name), exitcode.CacheCorrupt)
+	}
+	defer reader.Close()
+	header := http.Header{"Content-Type": []string{"application/octet-stream"}}
+	response, err = pushService.registry.do(http.MethodPut, location.String(), scope, header, reader, blob.Size)
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response, "uploading CodeQL pack "+name, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (pushService *pushService) pushPack(pack cachedirectory.Pack) error {
+	log.Infof("Pushing CodeQL pack %s...", pack)
+	content, err := pushService.cacheDirectory.ReadPackManifest(pack)
+	if err != nil {
+		return errors.Wrap(err, "Error reading CodeQL pack manifest from cache.")
+	}
+	parsed, err := parseManifest(content)
+	if err != nil {
+		return exitcode.WithCode(err, exitcode.CacheCorrupt)
+	}
+	for _, blob := range parsed.blobs() {
+		err = pushService.uploadBlob(pack.Name, blob)
+		if err != nil {
+			return err
+		}
+	}
+	// The manifest is pushed exactly as it was pulled, so that the pack has the same digest on the destination.
+	mediaType := parsed.MediaType
+	if mediaType == "" {
+		mediaType = manifestMediaTypes[0]
+	}
+	header := http.Header{"Content-Type": []string{mediaType}}
+	response, err := pushService.registry.do(http.MethodPut, "/v2/"+pack.Name+"/manifests/"+url.PathEscape(pack.Version), repositoryScope(pack.Name, "pull,push"), header, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	err = checkResponse(response, "pushing CodeQL pack "+pack.String(), http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// Push pushes the CodeQL packs in the cache to a container registry, such as the registry of a GitHub Enterprise Server instance, so that `codeql pack download` can download them from there. Nothing is done if the cache has no packs.
+func Push(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, registryURL string, token string) error {
+	packs, err := cacheDirectory.ListPacks()
+	if err != nil {
+		return err
+	}
+	if len(packs) == 0 {
+		return nil
+	}
+	pushService := pushService{
+		ctx:            ctx,
+		cacheDirectory: cacheDirectory,
+		registry:       newRegistry(ctx, registryURL, retry.NewClient(nil), token),
+	}
+	for _, pack := range packs {
+		err = pushService.pushPack(pack)
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Finished pushing %d CodeQL packs to %s!", len(packs), registryURL)
+	return nil
+}
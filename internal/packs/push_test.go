@@ -0,0 +1,51 @@
+package packs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushPacks(t *testing.T) {
+	source := startTestRegistry(t)
+	defer source.close()
+	source.anonymous = true
+	java := source.addPack("codeql/java-queries", "1.1.0", "java 1.1.0")
+	cpp := source.addPack("codeql/cpp-queries", "1.0.0", "cpp 1.0.0")
+	cacheDirectory := getTestCacheDirectory(t)
+	err := Pull(context.Background(), cacheDirectory, source.server.URL, "", []string{"codeql/java-queries", "codeql/cpp-queries"})
+	require.NoError(t, err)
+
+	destination := startTestRegistry(t)
+	defer destination.close()
+	err = Push(context.Background(), cacheDirectory, destination.server.URL, "password")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"codeql/java-queries:1.1.0": java, "codeql/cpp-queries:1.0.0": cpp}, destination.manifests)
+	require.Equal(t, source.blobs, destination.blobs)
+
+	// Pushing again does not upload blobs the registry already has.
+	err = Push(context.Background(), cacheDirectory, destination.server.URL, "password")
+	require.NoError(t, err)
+}
+
+func TestPushPacksWithoutToken(t *testing.T) {
+	source := startTestRegistry(t)
+	defer source.close()
+	source.anonymous = true
+	source.addPack("codeql/java-queries", "1.1.0", "java 1.1.0")
+	cacheDirectory := getTestCacheDirectory(t)
+	err := Pull(context.Background(), cacheDirectory, source.server.URL, "", []string{"codeql/java-queries"})
+	require.NoError(t, err)
+
+	destination := startTestRegistry(t)
+	defer destination.close()
+	destination.anonymous = true
+	err = Push(context.Background(), cacheDirectory, destination.server.URL, "")
+	require.EqualError(t, err, "Error starting upload of CodeQL pack codeql/java-queries, as the container registry returned 401 Unauthorized.")
+}
+
+func TestPushNoPacks(t *testing.T) {
+	cacheDirectory := getTestCacheDirectory(t)
+	require.NoError(t, Push(context.Background(), cacheDirectory, "http://invalid.example.com", "password"))
+}
@@ -0,0 +1,254 @@
+package packs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/pkg/errors"
+)
+
+// DefaultSourceRegistryURL is the container registry CodeQL packs are pulled from by default.
+const DefaultSourceRegistryURL = "https://ghcr.io"
+
+// manifestMediaTypes are the image manifests the sync tool understands, which are the ones CodeQL packs are published as.
+var manifestMediaTypes = []string{"application/vnd.oci.image.manifest.v1+json", "application/vnd.docker.distribution.manifest.v2+json"}
+
+// registryUsername is sent with a token when the registry asks for a username and password. Registries on GitHub instances only check the token.
+const registryUsername = "x-access-token"
+
+// RegistryURL returns the container registry of a GitHub instance. GitHub.com uses ghcr.io, while GHE.com and GitHub Enterprise Server serve it from the `containers` subdomain, which on GitHub Enterprise Server needs subdomain isolation to be enabled.
+func RegistryURL(instanceURL string) (string, error) {
+	parsedURL, err := url.Parse(strings.TrimRight(instanceURL, "/"))
+	if err != nil || parsedURL.Host == "" {
+		return "", fmt.Errorf("The instance URL %s is not valid.", instanceURL)
+	}
+	if strings.EqualFold(parsedURL.Host, "github.com") {
+		return DefaultSourceRegistryURL, nil
+	}
+	return parsedURL.Scheme + "://containers." + parsedURL.Host, nil
+}
+
+// registry is a client for the OCI distribution API of a container registry. It follows the challenges the registry sends to get a token for each repository it uses, as the API of GitHub does not accept its tokens directly.
+type registry struct {
+	ctx      context.Context
+	baseURL  string
+	client   *http.Client
+	password string
+	mutex    sync.Mutex
+	// tokens are the bearer tokens the registry has issued, by the scope they were requested for.
+	tokens map[string]string
+}
+
+func newRegistry(ctx context.Context, registryURL string, client *http.Client, token string) *registry {
+	return &registry{
+		ctx:      ctx,
+		baseURL:  strings.TrimRight(registryURL, "/"),
+		client:   client,
+		password: token,
+		tokens:   map[string]string{},
+	}
+}
+
+type registryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// checkResponse returns an error describing a response that does not have one of the expected statuses, closing its body.
+func checkResponse(response *http.Response, activity string, expected ...int) error {
+	for _, status := range expected {
+		if response.StatusCode == status {
+			return nil
+		}
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	errorResponse := struct {
+		Errors []registryError `json:"errors"`
+	}{}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &errorResponse) == nil && len(errorResponse.Errors) != 0 {
+		messages := []string{}
+		for _, registryError := range errorResponse.Errors {
+			messages = append(messages, registryError.Code+": "+registryError.Message)
+		}
+		message = strings.Join(messages, ", ")
+	}
+	err := fmt.Errorf("Error %s, as the container registry returned %s.", activity, response.Status)
+	if message != "" {
+		err = fmt.Errorf("Error %s, as the container registry returned %s: %s", activity, response.Status, message)
+	}
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		return exitcode.WithCode(err, exitcode.Authentication)
+	}
+	return err
+}
+
+// do sends a request for a repository of the registry, authenticating with a token for the scope, such as `repository:codeql/java-queries:pull`. A request whose body cannot be sent again is only sent once, so it should follow another request for the same scope, which will have got a token already.
+func (registry *registry) do(method string, requestURL string, scope string, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	if !strings.Contains(requestURL, "://") {
+		requestURL = registry.baseURL + requestURL
+	}
+	_, resendable := body.(*bytes.Reader)
+	resendable = resendable || body == nil
+	for attempt := 0; ; attempt++ {
+		if seeker, ok := body.(*bytes.Reader); ok {
+			seeker.Seek(0, io.SeekStart)
+		}
+		request, err := http.NewRequest(method, requestURL, body)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error creating container registry request.")
+		}
+		request = request.WithContext(registry.ctx)
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		if body != nil {
+			request.ContentLength = size
+		}
+		registry.mutex.Lock()
+		token, hasToken := registry.tokens[scope]
+		registry.mutex.Unlock()
+		if hasToken {
+			request.Header.Set("Authorization", token)
+		}
+		response, err := registry.client.Do(request)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error connecting to container registry.")
+		}
+		if response.StatusCode != http.StatusUnauthorized || attempt > 0 || !resendable {
+			return response, nil
+		}
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		err = registry.authenticate(challenge, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authenticate answers a challenge from the registry, either by getting a bearer token from the service it names, or by sending the token as a password.
+func (registry *registry) authenticate(challenge string, scope string) error {
+	scheme, parameters := parseChallenge(challenge)
+	var authorization string
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if registry.password == "" {
+			return exitcode.WithCode(fmt.Errorf("The container registry %s needs a token.", registry.baseURL), exitcode.Authentication)
+		}
+		request := http.Request{Header: http.Header{}}
+		request.SetBasicAuth(registryUsername, registry.password)
+		authorization = request.Header.Get("Authorization")
+	case "bearer":
+		token, err := registry.fetchToken(parameters, scope)
+		if err != nil {
+			return err
+		}
+		authorization = "Bearer " + token
+	default:
+		return exitcode.WithCode(fmt.Errorf("The container registry %s asked for authentication that the sync tool does not support: %s", registry.baseURL, challenge), exitcode.Authentication)
+	}
+	registry.mutex.Lock()
+	registry.tokens[scope] = authorization
+	registry.mutex.Unlock()
+	return nil
+}
+
+func (registry *registry) fetchToken(parameters map[string]string, scope string) (string, error) {
+	realm, err := url.Parse(parameters["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("The container registry %s asked for a token from an invalid location.", registry.baseURL)
+	}
+	query := realm.Query()
+	if parameters["service"] != "" {
+		query.Set("service", parameters["service"])
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	request, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Error creating container registry token request.")
+	}
+	request = request.WithContext(registry.ctx)
+	// Without a token, an anonymous token that can only pull public packs is asked for.
+	if registry.password != "" {
+		request.SetBasicAuth(registryUsername, registry.password)
+	}
+	response, err := registry.client.Do(request)
+	if err != nil {
+		return "", errors.Wrap(err, "Error connecting to container registry.")
+	}
+	err = checkResponse(response, "getting a container registry token", http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	tokenResponse := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&tokenResponse)
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading container registry token.")
+	}
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	if tokenResponse.AccessToken != "" {
+		return tokenResponse.AccessToken, nil
+	}
+	return "", exitcode.WithCode(fmt.Errorf("The container registry %s did not issue a token.", registry.baseURL), exitcode.Authentication)
+}
+
+// parseChallenge splits a `WWW-Authenticate` header such as `Bearer realm="https://ghcr.io/token",service="ghcr.io"` into its scheme and parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	parameters := map[string]string{}
+	challenge = strings.TrimSpace(challenge)
+	index := strings.IndexByte(challenge, ' ')
+	if index < 0 {
+		return challenge, parameters
+	}
+	scheme := challenge[:index]
+	rest := challenge[index+1:]
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		equals := strings.IndexByte(rest, '=')
+		if equals < 0 {
+			return scheme, parameters
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:equals]))
+		rest = rest[equals+1:]
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return scheme, parameters
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		parameters[name] = value
+	}
+}
+
+// repositoryScope is the scope of a token for the repository of a pack in the registry.
+func repositoryScope(name string, actions string) string {
+	return "repository:" + name + ":" + actions
+}
@@ -0,0 +1,176 @@
+package packs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+const testRegistryToken = "registry-token"
+
+// testRegistry is a container registry that issues bearer tokens to clients that authenticate with the password "password", or anonymous tokens that can only pull. It understands exactly as much of the distribution API as the sync tool uses.
+type testRegistry struct {
+	t         *testing.T
+	server    *httptest.Server
+	mutex     sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	// anonymous is whether anonymous tokens may pull.
+	anonymous bool
+}
+
+func startTestRegistry(t *testing.T) *testRegistry {
+	registry := &testRegistry{t: t, blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+	router := mux.NewRouter()
+	router.HandleFunc("/token", registry.serveToken)
+	router.PathPrefix("/v2/").HandlerFunc(registry.serveV2)
+	registry.server = httptest.NewServer(router)
+	return registry
+}
+
+func (registry *testRegistry) close() {
+	registry.server.Close()
+}
+
+func (registry *testRegistry) serveToken(response http.ResponseWriter, request *http.Request) {
+	_, password, ok := request.BasicAuth()
+	token := "anonymous"
+	if ok {
+		if password != "password" {
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token = testRegistryToken
+	}
+	require.Equal(registry.t, "test-registry", request.URL.Query().Get("service"))
+	json.NewEncoder(response).Encode(map[string]string{"token": token})
+}
+
+func (registry *testRegistry) addBlob(content string) descriptor {
+	digest := sha256.Sum256([]byte(content))
+	blob := descriptor{MediaType: "application/octet-stream", Digest: "sha256:" + hex.EncodeToString(digest[:]), Size: int64(len(content))}
+	registry.blobs[blob.Digest] = []byte(content)
+	return blob
+}
+
+// addPack adds a version of a pack with the given contents, returning its manifest.
+func (registry *testRegistry) addPack(name string, version string, content string) []byte {
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     manifestMediaTypes[0],
+		"config":        registry.addBlob(`{"name": "` + name + `"}`),
+		"layers":        []descriptor{registry.addBlob(content)},
+	})
+	require.NoError(registry.t, err)
+	registry.manifests[name+":"+version] = manifest
+	return manifest
+}
+
+func (registry *testRegistry) serveV2(response http.ResponseWriter, request *http.Request) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	authorization := request.Header.Get("Authorization")
+	push := request.Method != http.MethodGet && request.Method != http.MethodHead
+	if authorization != "Bearer "+testRegistryToken && (push || !registry.anonymous || authorization != "Bearer anonymous") {
+		response.Header().Set("WWW-Authenticate", `Bearer realm="`+registry.server.URL+`/token",service="test-registry"`)
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(request.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		name := strings.TrimSuffix(path, "/tags/list")
+		tags := []string{}
+		for key := range registry.manifests {
+			if strings.HasPrefix(key, name+":") {
+				tags = append(tags, strings.TrimPrefix(key, name+":"))
+			}
+		}
+		json.NewEncoder(response).Encode(map[string]interface{}{"name": name, "tags": tags})
+	case strings.Contains(path, "/manifests/"):
+		split := strings.SplitN(path, "/manifests/", 2)
+		key := split[0] + ":" + split[1]
+		if request.Method == http.MethodPut {
+			body, err := ioutil.ReadAll(request.Body)
+			require.NoError(registry.t, err)
+			require.Equal(registry.t, manifestMediaTypes[0], request.Header.Get("Content-Type"))
+			parsed, err := parseManifest(body)
+			require.NoError(registry.t, err)
+			for _, blob := range parsed.blobs() {
+				require.Contains(registry.t, registry.blobs, blob.Digest)
+			}
+			registry.manifests[key] = body
+			response.WriteHeader(http.StatusCreated)
+			return
+		}
+		manifest, ok := registry.manifests[key]
+		if !ok {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		response.Header().Set("Content-Type", manifestMediaTypes[0])
+		response.Write(manifest)
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		response.Header().Set("Location", "/v2/upload/session?state=1")
+		response.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(path, "upload/session"):
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(registry.t, err)
+		require.Equal(registry.t, "1", request.URL.Query().Get("state"))
+		digest := sha256.Sum256(body)
+		require.Equal(registry.t, "sha256:"+hex.EncodeToString(digest[:]), request.URL.Query().Get("digest"))
+		registry.blobs[request.URL.Query().Get("digest")] = body
+		response.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		blob, ok := registry.blobs[path[strings.LastIndex(path, "/")+1:]]
+		if !ok {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		response.Write(blob)
+	default:
+		response.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRegistryURL(t *testing.T) {
+	registryURL, err := RegistryURL("https://github.com")
+	require.NoError(t, err)
+	require.Equal(t, "https://ghcr.io", registryURL)
+	registryURL, err = RegistryURL("https://ghes.example.com/")
+	require.NoError(t, err)
+	require.Equal(t, "https://containers.ghes.example.com", registryURL)
+	registryURL, err = RegistryURL("https://octocorp.ghe.com")
+	require.NoError(t, err)
+	require.Equal(t, "https://containers.octocorp.ghe.com", registryURL)
+	_, err = RegistryURL("ghes.example.com")
+	require.Error(t, err)
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, parameters := parseChallenge(`Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:codeql/java-queries:pull"`)
+	require.Equal(t, "Bearer", scheme)
+	require.Equal(t, map[string]string{"realm": "https://ghcr.io/token", "service": "ghcr.io", "scope": "repository:codeql/java-queries:pull"}, parameters)
+	scheme, parameters = parseChallenge(`Basic realm=registry`)
+	require.Equal(t, "Basic", scheme)
+	require.Equal(t, map[string]string{"realm": "registry"}, parameters)
+}
+
+func TestRegistryWrongToken(t *testing.T) {
+	registry := startTestRegistry(t)
+	defer registry.close()
+
+	client := newRegistry(context.Background(), registry.server.URL, http.DefaultClient, "wrong")
+	_, err := client.do(http.MethodGet, "/v2/codeql/java-queries/tags/list", repositoryScope("codeql/java-queries", "pull"), nil, nil, 0)
+	require.EqualError(t, err, "Error getting a container registry token, as the container registry returned 401 Unauthorized.")
+}
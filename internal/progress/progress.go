@@ -0,0 +1,270 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/bytesize"
+)
+
+// terminalInterval is how often progress is redrawn when writing to a terminal.
+const terminalInterval = 500 * time.Millisecond
+
+// logInterval is how often a progress line is written when not writing to a terminal, such as in CI logs, where redrawing is not possible.
+const logInterval = 30 * time.Second
+
+type transfer struct {
+	name        string
+	size        int64
+	offset      int64
+	transferred int64
+	started     time.Time
+}
+
+// Reporter shows the progress of a set of transfers, both for each transfer and overall, including the transfer rate and estimated time remaining.
+type Reporter struct {
+	writer   io.Writer
+	verb     string
+	terminal bool
+	interval time.Duration
+	now      func() time.Time
+
+	lock        sync.Mutex
+	total       int64
+	transferred int64
+	resumed     int64
+	started     time.Time
+	active      []*transfer
+	lastLength  int
+	stop        chan struct{}
+	stopped     chan struct{}
+}
+
+func isTerminal(writer io.Writer) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// NewReporter creates a reporter that describes transfers with the given verb, for example "Downloading". If enabled is false nil is returned, which reports nothing.
+func NewReporter(writer io.Writer, verb string, enabled bool) *Reporter {
+	if !enabled {
+		return nil
+	}
+	terminal := isTerminal(writer)
+	interval := logInterval
+	if terminal {
+		interval = terminalInterval
+	}
+	return &Reporter{
+		writer:   writer,
+		verb:     verb,
+		terminal: terminal,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Start begins reporting progress towards the given total number of bytes.
+func (reporter *Reporter) Start(total int64) {
+	if reporter == nil {
+		return
+	}
+	reporter.lock.Lock()
+	reporter.total = total
+	reporter.started = reporter.now()
+	reporter.stop = make(chan struct{})
+	reporter.stopped = make(chan struct{})
+	reporter.lock.Unlock()
+	go func() {
+		defer close(reporter.stopped)
+		ticker := time.NewTicker(reporter.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reporter.draw()
+			case <-reporter.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting progress, drawing the final state if writing to a terminal.
+func (reporter *Reporter) Stop() {
+	if reporter == nil || reporter.stop == nil {
+		return
+	}
+	close(reporter.stop)
+	<-reporter.stopped
+	if !reporter.terminal {
+		return
+	}
+	reporter.draw()
+	fmt.Fprintln(reporter.writer)
+}
+
+// Reader counts the bytes read through it towards the progress of a transfer.
+type Reader struct {
+	reader   io.Reader
+	reporter *Reporter
+	transfer *transfer
+	once     sync.Once
+}
+
+// Track starts reporting progress for a transfer of the given size, of which the first offset bytes have already been transferred, for example by an earlier interrupted download. The returned reader must be closed once the transfer has finished.
+func (reporter *Reporter) Track(name string, reader io.Reader, size int64, offset int64) *Reader {
+	if reporter == nil {
+		return &Reader{reader: reader}
+	}
+	transfer := &transfer{name: name, size: size, offset: offset, transferred: offset, started: reporter.now()}
+	reporter.lock.Lock()
+	reporter.active = append(reporter.active, transfer)
+	reporter.transferred += offset
+	reporter.resumed += offset
+	reporter.lock.Unlock()
+	return &Reader{reader: reader, reporter: reporter, transfer: transfer}
+}
+
+func (reader *Reader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	if reader.reporter != nil && n > 0 {
+		reader.reporter.lock.Lock()
+		reader.transfer.transferred += int64(n)
+		reader.reporter.transferred += int64(n)
+		reader.reporter.lock.Unlock()
+	}
+	return n, err
+}
+
+// Close finishes reporting progress for the transfer. It does not close the underlying reader.
+func (reader *Reader) Close() error {
+	if reader.reporter == nil {
+		return nil
+	}
+	reader.once.Do(func() {
+		reader.reporter.finish(reader.transfer)
+	})
+	return nil
+}
+
+func (reporter *Reporter) finish(finished *transfer) {
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+	for index, transfer := range reporter.active {
+		if transfer == finished {
+			reporter.active = append(reporter.active[:index], reporter.active[index+1:]...)
+			break
+		}
+	}
+	// Anything not transferred was abandoned, so should no longer count towards the total.
+	reporter.total -= finished.size - finished.transferred
+	if !reporter.terminal {
+		elapsed := reporter.now().Sub(finished.started)
+		fmt.Fprintf(reporter.writer, "%s %s: %s in %s (%s).\n", reporter.verb, finished.name, bytesize.Format(finished.transferred-finished.offset), formatDuration(elapsed), formatRate(finished.transferred-finished.offset, elapsed))
+	}
+}
+
+// formatRate formats the rate at which a number of bytes were transferred over the given time.
+func formatRate(bytes int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "-"
+	}
+	return bytesize.Format(int64(float64(bytes)/elapsed.Seconds())) + "/s"
+}
+
+// formatDuration formats a duration for display, to the nearest second.
+func formatDuration(duration time.Duration) string {
+	if duration < time.Second {
+		return duration.Round(time.Millisecond).String()
+	}
+	return duration.Round(time.Second).String()
+}
+
+func estimate(remaining int64, transferred int64, elapsed time.Duration) string {
+	if transferred <= 0 || elapsed <= 0 {
+		return "unknown"
+	}
+	return formatDuration(time.Duration(float64(remaining) / float64(transferred) * float64(elapsed)))
+}
+
+func percentage(transferred int64, size int64) int64 {
+	if size <= 0 {
+		return 100
+	}
+	return transferred * 100 / size
+}
+
+func (reporter *Reporter) describeTransfer(transfer *transfer, now time.Time) string {
+	elapsed := now.Sub(transfer.started)
+	newlyTransferred := transfer.transferred - transfer.offset
+	return fmt.Sprintf("%s %s/%s (%d%%) at %s, ETA %s",
+		transfer.name,
+		bytesize.Format(transfer.transferred),
+		bytesize.Format(transfer.size),
+		percentage(transfer.transferred, transfer.size),
+		formatRate(newlyTransferred, elapsed),
+		estimate(transfer.size-transfer.transferred, newlyTransferred, elapsed),
+	)
+}
+
+func (reporter *Reporter) describeOverall(now time.Time) string {
+	elapsed := now.Sub(reporter.started)
+	newlyTransferred := reporter.transferred - reporter.resumed
+	return fmt.Sprintf("overall %s/%s (%d%%) at %s, ETA %s",
+		bytesize.Format(reporter.transferred),
+		bytesize.Format(reporter.total),
+		percentage(reporter.transferred, reporter.total),
+		formatRate(newlyTransferred, elapsed),
+		estimate(reporter.total-reporter.transferred, newlyTransferred, elapsed),
+	)
+}
+
+// lines describes the current progress, with the overall progress last.
+func (reporter *Reporter) lines() []string {
+	now := reporter.now()
+	lines := []string{}
+	for _, transfer := range reporter.active {
+		lines = append(lines, fmt.Sprintf("%s %s", reporter.verb, reporter.describeTransfer(transfer, now)))
+	}
+	return append(lines, reporter.describeOverall(now))
+}
+
+func (reporter *Reporter) draw() {
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+	lines := reporter.lines()
+	if !reporter.terminal {
+		for _, line := range lines {
+			fmt.Fprintln(reporter.writer, line)
+		}
+		return
+	}
+	// Only one line can be redrawn in place, so several simultaneous transfers are summarized.
+	var line string
+	switch len(reporter.active) {
+	case 0:
+		line = fmt.Sprintf("%s %s", reporter.verb, lines[len(lines)-1])
+	case 1:
+		line = fmt.Sprintf("%s - %s", lines[0], lines[1])
+	default:
+		line = fmt.Sprintf("%s %d assets - %s", reporter.verb, len(reporter.active), lines[len(lines)-1])
+	}
+	padding := ""
+	if len(line) < reporter.lastLength {
+		padding = strings.Repeat(" ", reporter.lastLength-len(line))
+	}
+	fmt.Fprintf(reporter.writer, "\r%s%s", line, padding)
+	reporter.lastLength = len(line)
+}
@@ -0,0 +1,52 @@
+package progress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporter(t *testing.T) {
+	output := bytes.Buffer{}
+	reporter := NewReporter(&output, "Downloading", true)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	reporter.now = func() time.Time { return now }
+	reporter.Start(3145728)
+	defer reporter.Stop()
+
+	first := reporter.Track("first.tar.gz", strings.NewReader(strings.Repeat("a", 1048576)), 2097152, 1048576)
+	second := reporter.Track("second.tar.gz", strings.NewReader(strings.Repeat("b", 1048576)), 1048576, 0)
+	now = now.Add(10 * time.Second)
+	_, err := ioutil.ReadAll(second)
+	require.NoError(t, err)
+
+	lines := reporter.lines()
+	require.Equal(t, []string{
+		"Downloading first.tar.gz 1.0 MiB/2.0 MiB (50%) at 0 B/s, ETA unknown",
+		"Downloading second.tar.gz 1.0 MiB/1.0 MiB (100%) at 102.4 KiB/s, ETA 0s",
+		"overall 2.0 MiB/3.0 MiB (66%) at 102.4 KiB/s, ETA 10s",
+	}, lines)
+
+	require.NoError(t, second.Close())
+	require.Equal(t, "Downloading second.tar.gz: 1.0 MiB in 10s (102.4 KiB/s).\n", output.String())
+
+	// Abandoned transfers no longer count towards the total.
+	require.NoError(t, first.Close())
+	require.Equal(t, []string{"overall 2.0 MiB/2.0 MiB (100%) at 102.4 KiB/s, ETA 0s"}, reporter.lines())
+}
+
+func TestDisabledReporter(t *testing.T) {
+	reporter := NewReporter(&bytes.Buffer{}, "Uploading", false)
+	require.Nil(t, reporter)
+	reporter.Start(10)
+	reader := reporter.Track("asset", strings.NewReader("content"), 7, 0)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "content", string(content))
+	require.NoError(t, reader.Close())
+	reporter.Stop()
+}
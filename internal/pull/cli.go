@@ -0,0 +1,52 @@
+package pull
+
+import (
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCLISourceRepository is the repository the standalone CodeQL CLI is pulled from by default.
+const DefaultCLISourceRepository = "github/codeql-cli-binaries"
+
+// findRecentReleases returns the most recent published releases of the source, newest first. Only the latest is included unless more are kept with `--keep-last`, and drafts and prereleases are skipped unless `--include-drafts` or `--include-prereleases` is given. Releases of the CodeQL CLI are not referenced from its Git repository, so they are listed from the API instead.
+func (pullService *pullService) findRecentReleases() ([]string, error) {
+	log.Debug("Finding recent CodeQL CLI releases...")
+	keepLast := pullService.retention.KeepLast
+	if keepLast < 1 {
+		keepLast = 1
+	}
+	releaseTags := []string{}
+	options := &github.ListOptions{PerPage: 100}
+	for {
+		var releases []*github.RepositoryRelease
+		var response *github.Response
+		err := retry.Do(pullService.ctx, "listing CodeQL CLI releases", func(attempt int) error {
+			var err error
+			releases, response, err = pullService.githubDotComClient.Repositories.ListReleases(pullService.ctx, pullService.sourceOwner, pullService.sourceRepository, options)
+			return err
+		})
+		if sizeErr := githubapiutil.AssetSizeError(err); sizeErr != nil {
+			return nil, sizeErr
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error listing CodeQL CLI releases.")
+		}
+		for _, release := range releases {
+			if kind, flag := pullService.skippedKind(release); kind != "" {
+				log.Debugf("Not considering CodeQL CLI release %s as it is %s. Use `%s` to pull it.", release.GetTagName(), kind, flag)
+				continue
+			}
+			releaseTags = append(releaseTags, release.GetTagName())
+			if len(releaseTags) == keepLast {
+				return releaseTags, nil
+			}
+		}
+		if response.NextPage == 0 {
+			return releaseTags, nil
+		}
+		options.Page = response.NextPage
+	}
+}
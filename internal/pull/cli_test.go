@@ -0,0 +1,86 @@
+package pull
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+const cliReleaseContent = "This isn't really a CodeQL CLI!"
+
+var cliReleases = []*github.RepositoryRelease{
+	{TagName: github.String("v2.3.0"), Draft: github.Bool(true)},
+	{
+		TagName: github.String("v2.2.0"),
+		Assets: []*github.ReleaseAsset{
+			{ID: github.Int64(1), Name: github.String("codeql-linux64.zip"), Size: github.Int(len(cliReleaseContent))},
+			{ID: github.Int64(2), Name: github.String("codeql-osx64.zip"), Size: github.Int(len(cliReleaseContent))},
+		},
+	},
+	{TagName: github.String("v2.2.0-beta"), Prerelease: github.Bool(true)},
+	{TagName: github.String("v2.1.0")},
+	{TagName: github.String("v2.0.0")},
+}
+
+func serveCLIReleases(t *testing.T) string {
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-cli-binaries/releases", func(response http.ResponseWriter, request *http.Request) {
+		// The releases are split over pages to check they are followed.
+		if request.URL.Query().Get("page") == "2" {
+			test.ServeHTTPResponseFromObject(t, cliReleases[3:], response)
+			return
+		}
+		response.Header().Set("Link", `<`+githubURL+`/api/v3/repos/github/codeql-cli-binaries/releases?page=2>; rel="next"`)
+		test.ServeHTTPResponseFromObject(t, cliReleases[:3], response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-cli-binaries/releases/tags/v2.2.0", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, cliReleases[1], response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-cli-binaries/releases/assets/{id}", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, cliReleaseContent, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	return githubURL
+}
+
+func getTestCLIPullService(t *testing.T, temporaryDirectory string, githubURL string) pullService {
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	pullService.sourceRepository = "codeql-cli-binaries"
+	pullService.sourceKind = cachedirectory.SourceKindCLI
+	return pullService
+}
+
+func TestFindRecentReleases(t *testing.T) {
+	pullService := getTestCLIPullService(t, test.CreateTemporaryDirectory(t), serveCLIReleases(t))
+	releases, err := pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2.2.0"}, releases)
+
+	pullService.retention.KeepLast = 3
+	releases, err = pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2.2.0", "v2.1.0", "v2.0.0"}, releases)
+
+	pullService.retention.KeepLast = 10
+	releases, err = pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2.2.0", "v2.1.0", "v2.0.0"}, releases)
+}
+
+func TestPullCLIReleases(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pullService := getTestCLIPullService(t, temporaryDirectory, serveCLIReleases(t))
+	require.NoError(t, pullService.cacheDirectory.WriteMetadata("some-codeql-version-on-main", []byte(`{"tag_name": "some-codeql-version-on-main"}`)))
+	pullService.kindChanged = true
+	require.NoError(t, pullService.pullReleases())
+
+	test.RequireFileHasContent(t, cliReleaseContent, pullService.cacheDirectory.AssetPath("v2.2.0", "codeql-linux64.zip"))
+	test.RequireFileHasContent(t, cliReleaseContent, pullService.cacheDirectory.AssetPath("v2.2.0", "codeql-osx64.zip"))
+	// The CodeQL bundles of a cache of the CodeQL Action are removed when it is pulled from the CodeQL CLI instead.
+	releases, err := pullService.cacheDirectory.ListReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2.2.0"}, releases)
+}
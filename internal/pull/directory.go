@@ -0,0 +1,303 @@
+package pull
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+)
+
+// directoryGitName is the Git repository of the CodeQL Action within a source directory. Every other directory is a release, named after its tag.
+const directoryGitName = "codeql-action.git"
+
+const errorInvalidDirectoryName = "The %s name `%s` in the source directory is not valid. Release tags and asset names must be a single file name, without `/`, `\\` or `..`."
+
+// directoryMetadataName is the release metadata within each release directory, in the same format as the GitHub API returns for a release.
+const directoryMetadataName = "metadata.json"
+
+// NewDirectorySource creates a source which reads the CodeQL Action and bundles from a local directory rather than a GitHub instance, for sites where files can only be brought in through a staging area. The directory contains a Git repository of the CodeQL Action named codeql-action.git, and a directory for each CodeQL bundle release named after its tag, containing the release assets and optionally a metadata.json describing the release.
+func NewDirectorySource(directory string) (Source, error) {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return Source{}, errors.Wrap(err, "Error finding source directory.")
+	}
+	gitPath := filepath.Join(directory, directoryGitName)
+	info, err := os.Stat(gitPath)
+	if err != nil || !info.IsDir() {
+		return Source{}, fmt.Errorf("The source directory %s does not contain a Git repository of the CodeQL Action named %s.", directory, directoryGitName)
+	}
+	// The repository may have been copied with its working tree.
+	if info, err := os.Stat(filepath.Join(gitPath, ".git")); err == nil && info.IsDir() {
+		gitPath = filepath.Join(gitPath, ".git")
+	}
+	return Source{GitURL: gitPath, Directory: directory}, nil
+}
+
+// gitRemote is the part of a Git remote that is used to pull, so that the Git repository in a source directory can be fetched from in a different way to a remote Git server.
+type gitRemote interface {
+	List(options *git.ListOptions) ([]*plumbing.Reference, error)
+	FetchContext(ctx context.Context, options *git.FetchOptions) error
+}
+
+// directoryRemote fetches from the Git repository in a source directory through go-git's own upload-pack server, so that the Git command line tools do not need to be installed. The server is only used for this repository, rather than being installed as the transport for every local repository.
+type directoryRemote struct {
+	storer   storage.Storer
+	source   *git.Repository
+	endpoint *transport.Endpoint
+}
+
+func newDirectoryRemote(storer storage.Storer, gitPath string) (*directoryRemote, error) {
+	source, err := git.PlainOpen(gitPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error opening Git repository in source directory.")
+	}
+	endpoint, err := transport.NewEndpoint(gitPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error opening Git repository in source directory.")
+	}
+	return &directoryRemote{storer: storer, source: source, endpoint: endpoint}, nil
+}
+
+// List returns the references of the Git repository in the source directory.
+func (remote *directoryRemote) List(options *git.ListOptions) ([]*plumbing.Reference, error) {
+	references, err := remote.source.References()
+	if err != nil {
+		return nil, err
+	}
+	defer references.Close()
+	result := []*plumbing.Reference{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		result = append(result, reference)
+		return nil
+	})
+	return result, err
+}
+
+// FetchContext fetches the references matching the reference specifications, forcing any updates. Shallow fetches are not supported.
+func (remote *directoryRemote) FetchContext(ctx context.Context, options *git.FetchOptions) error {
+	if options.Depth != 0 {
+		return errors.New("Shallow fetches are not supported from a source directory.")
+	}
+	sourceReferences, err := remote.List(nil)
+	if err != nil {
+		return err
+	}
+	request := packp.NewUploadPackRequest()
+	updates := map[plumbing.ReferenceName]plumbing.Hash{}
+	wanted := map[plumbing.Hash]bool{}
+	for _, reference := range sourceReferences {
+		if reference.Type() != plumbing.HashReference {
+			continue
+		}
+		for _, refSpec := range options.RefSpecs {
+			if !refSpec.Match(reference.Name()) {
+				continue
+			}
+			updates[refSpec.Dst(reference.Name())] = reference.Hash()
+			if remote.storer.HasEncodedObject(reference.Hash()) != nil && !wanted[reference.Hash()] {
+				wanted[reference.Hash()] = true
+				request.Wants = append(request.Wants, reference.Hash())
+			}
+		}
+	}
+	if len(request.Wants) != 0 {
+		// Objects reachable from what the cache already has are not sent again.
+		localReferences, err := remote.storer.IterReferences()
+		if err != nil {
+			return err
+		}
+		err = localReferences.ForEach(func(reference *plumbing.Reference) error {
+			if reference.Type() == plumbing.HashReference && remote.source.Storer.HasEncodedObject(reference.Hash()) == nil {
+				request.Haves = append(request.Haves, reference.Hash())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		session, err := server.NewServer(server.MapLoader{remote.endpoint.String(): remote.source.Storer}).NewUploadPackSession(remote.endpoint, nil)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		response, err := session.UploadPack(ctx, request)
+		if err != nil {
+			return err
+		}
+		defer response.Close()
+		err = packfile.UpdateObjectStorage(remote.storer, response)
+		if err != nil {
+			return err
+		}
+	}
+	for name, hash := range updates {
+		err := remote.storer.SetReference(plumbing.NewHashReference(name, hash))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDirectoryName checks that a release tag or asset name from the source directory is a single file name, so that it cannot refer to a file outside of the source directory or the cache.
+func checkDirectoryName(kind string, name string) error {
+	if name == "" || name == "." || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") || filepath.Base(name) != name {
+		return fmt.Errorf(errorInvalidDirectoryName, kind, name)
+	}
+	return nil
+}
+
+// directoryAssetPath returns the path of an asset of a release in the source directory.
+func (pullService *pullService) directoryAssetPath(releaseTag string, asset *github.ReleaseAsset) (string, error) {
+	err := checkDirectoryName("release", releaseTag)
+	if err != nil {
+		return "", err
+	}
+	err = checkDirectoryName("asset", asset.GetName())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pullService.sourceDirectory, releaseTag, asset.GetName()), nil
+}
+
+func (pullService *pullService) getRelease(releaseTag string) (*github.RepositoryRelease, error) {
+	if pullService.sourceDirectory != "" {
+		return pullService.readDirectoryRelease(releaseTag)
+	}
+	var release *github.RepositoryRelease
+	var response *github.Response
+	err := retry.Do(pullService.ctx, "loading release "+releaseTag, func(attempt int) error {
+		var err error
+		release, response, err = pullService.githubDotComClient.Repositories.GetReleaseByTag(pullService.ctx, pullService.sourceOwner, pullService.sourceRepository, releaseTag)
+		return err
+	})
+	if err != nil && pullService.includeDrafts && response != nil && response.StatusCode == http.StatusNotFound {
+		draftRelease, draftErr := pullService.findDraftRelease(releaseTag)
+		if draftErr != nil || draftRelease != nil {
+			return draftRelease, draftErr
+		}
+	}
+	if sizeErr := githubapiutil.AssetSizeError(err); sizeErr != nil {
+		return nil, sizeErr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading CodeQL release information.")
+	}
+	return release, nil
+}
+
+// readDirectoryRelease reads a release from the source directory. If the release has no metadata, or the metadata lists no assets, every file in the release directory is treated as an asset.
+func (pullService *pullService) readDirectoryRelease(releaseTag string) (*github.RepositoryRelease, error) {
+	err := checkDirectoryName("release", releaseTag)
+	if err != nil {
+		return nil, err
+	}
+	releasePath := filepath.Join(pullService.sourceDirectory, releaseTag)
+	files, err := ioutil.ReadDir(releasePath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("The source directory does not contain the CodeQL bundle release %s.", releaseTag)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading release from source directory.")
+	}
+
+	release := github.RepositoryRelease{}
+	metadata, err := ioutil.ReadFile(filepath.Join(releasePath, directoryMetadataName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "Error reading release metadata from source directory.")
+	}
+	if err == nil {
+		err = json.Unmarshal(metadata, &release)
+		if sizeErr := githubapiutil.AssetSizeError(err); sizeErr != nil {
+			return nil, sizeErr
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing release metadata for %s from source directory.", releaseTag)
+		}
+	}
+	if release.GetTagName() != "" && release.GetTagName() != releaseTag {
+		return nil, fmt.Errorf("The release metadata in the source directory for %s is for a different release, %s.", releaseTag, release.GetTagName())
+	}
+	release.TagName = github.String(releaseTag)
+	if release.Name == nil {
+		release.Name = github.String(releaseTag)
+	}
+
+	if len(release.Assets) == 0 {
+		for _, file := range files {
+			if file.Mode().IsRegular() && file.Name() != directoryMetadataName {
+				size, err := githubapiutil.NewAssetSize(file.Name(), file.Size())
+				if err != nil {
+					return nil, err
+				}
+				release.Assets = append(release.Assets, &github.ReleaseAsset{Name: github.String(file.Name()), Size: size})
+			}
+		}
+	}
+	for _, asset := range release.Assets {
+		assetPath, err := pullService.directoryAssetPath(releaseTag, asset)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(assetPath)
+		if err != nil {
+			return nil, fmt.Errorf("The asset %s of release %s is missing from the source directory.", asset.GetName(), releaseTag)
+		}
+		if info.Size() != githubapiutil.AssetSize(asset) {
+			return nil, fmt.Errorf("The asset %s of release %s in the source directory is %d bytes, but the release metadata says it should be %d bytes.", asset.GetName(), releaseTag, info.Size(), githubapiutil.AssetSize(asset))
+		}
+	}
+	return &release, nil
+}
+
+func (pullService *pullService) openDirectoryAsset(releaseTag string, asset *github.ReleaseAsset, offset int64) (io.ReadCloser, int64, error) {
+	assetPath, err := pullService.directoryAssetPath(releaseTag, asset)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := os.Open(assetPath)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Error opening asset in source directory.")
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return nil, 0, errors.Wrap(err, "Error reading asset in source directory.")
+	}
+	return file, offset, nil
+}
+
+func (pullService *pullService) directoryAssetDigest(releaseTag string, asset *github.ReleaseAsset) (string, error) {
+	assetPath, err := pullService.directoryAssetPath(releaseTag, asset)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(assetPath)
+	if err != nil {
+		return "", errors.Wrap(err, "Error opening asset in source directory.")
+	}
+	defer file.Close()
+	digest, err := cachedirectory.Digest(file)
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading asset in source directory.")
+	}
+	return digest, nil
+}
@@ -0,0 +1,113 @@
+package pull
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/assetselection"
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/concurrency"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestSourceDirectory(t *testing.T) string {
+	sourceDirectory := test.CreateTemporaryDirectory(t)
+	gitPath, err := filepath.Abs(initialActionRepository)
+	require.NoError(t, err)
+	require.NoError(t, os.Symlink(gitPath, filepath.Join(sourceDirectory, directoryGitName)))
+
+	// This release has no metadata, so every file is an asset.
+	require.NoError(t, os.Mkdir(filepath.Join(sourceDirectory, "some-codeql-version-on-main"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDirectory, "some-codeql-version-on-main", "codeql-bundle.tar.gz"), []byte(releaseSomeCodeQLVersionOnMainContent), 0644))
+
+	require.NoError(t, os.Mkdir(filepath.Join(sourceDirectory, "some-codeql-version-on-v1-and-v2"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDirectory, "some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz"), []byte(releaseSomeCodeQLVersionOnV1AndV2Content), 0644))
+	metadata, err := json.Marshal(releaseSomeCodeQLVersionOnV1AndV2)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDirectory, "some-codeql-version-on-v1-and-v2", directoryMetadataName), metadata, 0644))
+	return sourceDirectory
+}
+
+func TestNewDirectorySource(t *testing.T) {
+	sourceDirectory := createTestSourceDirectory(t)
+	source, err := NewDirectorySource(sourceDirectory)
+	require.NoError(t, err)
+	require.Equal(t, Source{GitURL: filepath.Join(sourceDirectory, directoryGitName), Directory: sourceDirectory}, source)
+
+	_, err = NewDirectorySource(test.CreateTemporaryDirectory(t))
+	require.Error(t, err)
+}
+
+func TestPullFromDirectory(t *testing.T) {
+	sourceDirectory := createTestSourceDirectory(t)
+	source, err := NewDirectorySource(sourceDirectory)
+	require.NoError(t, err)
+	cacheDirectory := cachedirectory.NewCacheDirectory(filepath.Join(test.CreateTemporaryDirectory(t), "cache"))
+	options := Options{
+		Source:          source,
+		LanguageMapping: assetselection.DefaultMapping(),
+		Languages:       []string{},
+		Limits:          concurrency.Limits{Downloads: 1, APIRequests: 1},
+	}
+	err = Pull(context.Background(), cacheDirectory, options)
+	require.NoError(t, err)
+	// Pulling again only updates the cache.
+	err = Pull(context.Background(), cacheDirectory, options)
+	require.NoError(t, err)
+	// The source directory is fetched from without changing the transport used for other local Git repositories.
+	require.Equal(t, file.DefaultClient, client.Protocols["file"])
+
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnMainContent, cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnV1AndV2Content, cacheDirectory.AssetPath("some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz"))
+	test.CheckExpectedReferencesInRepository(t, cacheDirectory.GitPath(), []string{
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning",
+	})
+}
+
+func TestReadDirectoryReleaseChecksSize(t *testing.T) {
+	sourceDirectory := createTestSourceDirectory(t)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDirectory, "some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz"), []byte("Truncated."), 0644))
+	pullService := pullService{sourceDirectory: sourceDirectory}
+	_, err := pullService.readDirectoryRelease("some-codeql-version-on-v1-and-v2")
+	require.EqualError(t, err, "The asset codeql-bundle.tar.gz of release some-codeql-version-on-v1-and-v2 in the source directory is 10 bytes, but the release metadata says it should be 67 bytes.")
+
+	_, err = pullService.readDirectoryRelease("some-other-version")
+	require.EqualError(t, err, "The source directory does not contain the CodeQL bundle release some-other-version.")
+}
+
+func TestReadDirectoryReleaseRejectsTraversal(t *testing.T) {
+	sourceDirectory := createTestSourceDirectory(t)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(filepath.Dir(sourceDirectory), "outside"), []byte("Outside."), 0644))
+	metadata, err := json.Marshal(github.RepositoryRelease{
+		TagName: github.String("some-codeql-version-on-main"),
+		Assets:  []*github.ReleaseAsset{{Name: github.String("../../outside"), Size: github.Int(8)}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDirectory, "some-codeql-version-on-main", directoryMetadataName), metadata, 0644))
+	pullService := pullService{sourceDirectory: sourceDirectory}
+	_, err = pullService.readDirectoryRelease("some-codeql-version-on-main")
+	require.EqualError(t, err, "The asset name `../../outside` in the source directory is not valid. Release tags and asset names must be a single file name, without `/`, `\\` or `..`.")
+
+	for _, releaseTag := range []string{"..", "../some-codeql-version-on-main", `..\outside`, "."} {
+		_, err = pullService.readDirectoryRelease(releaseTag)
+		require.Error(t, err, releaseTag)
+	}
+	_, _, err = pullService.openDirectoryAsset("some-codeql-version-on-main", &github.ReleaseAsset{Name: github.String("../../outside")}, 0)
+	require.Error(t, err)
+	_, err = pullService.directoryAssetDigest("..", &github.ReleaseAsset{Name: github.String("outside")})
+	require.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package pull
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	log "github.com/sirupsen/logrus"
+)
+
+// maximumStoredResponseSize is the largest API response that is stored for conditional requests. Release lists are much smaller than this, so it only stops something unexpected filling the cache.
+const maximumStoredResponseSize = 16 * 1024 * 1024
+
+// conditionalTransport stores JSON responses from the GitHub API with their ETags, and sends `If-None-Match` when the same request is made again. If GitHub replies that nothing has changed, the stored response is returned instead, and the request does not count against the rate limit. Problems reading or writing stored responses are logged and otherwise ignored, as the request can always be made in full.
+type conditionalTransport struct {
+	base           http.RoundTripper
+	cacheDirectory cachedirectory.CacheDirectory
+	mutex          sync.Mutex
+	used           map[string]bool
+}
+
+func newConditionalTransport(base http.RoundTripper, cacheDirectory cachedirectory.CacheDirectory) *conditionalTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &conditionalTransport{base: base, cacheDirectory: cacheDirectory, used: map[string]bool{}}
+}
+
+// responseKey identifies a request by its URL and the headers that change the response. The token is part of the key, as a different token may be able to see different releases, but only a hash of it is stored.
+func responseKey(request *http.Request) string {
+	hash := sha256.New()
+	io.WriteString(hash, request.URL.String()+"\n"+request.Header.Get("Accept")+"\n"+request.Header.Get("Authorization"))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (transport *conditionalTransport) use(key string) {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	transport.used[key] = true
+}
+
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || mediaType == "application/vnd.github+json")
+}
+
+func (transport *conditionalTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method != http.MethodGet || request.Header.Get("Range") != "" || request.Header.Get("If-None-Match") != "" {
+		return transport.base.RoundTrip(request)
+	}
+	key := responseKey(request)
+	stored, err := transport.cacheDirectory.ReadAPIResponse(key)
+	if err != nil {
+		log.Debugf("Ignoring stored response for %s: %s", request.URL.Redacted(), err)
+		stored = nil
+	}
+	if stored != nil && stored.ETag != "" {
+		request = request.Clone(request.Context())
+		request.Header.Set("If-None-Match", stored.ETag)
+	}
+	response, err := transport.base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotModified && stored != nil {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		log.Debugf("%s has not changed since the last pull, so the stored response was used.", request.URL.Redacted())
+		transport.use(key)
+		header := stored.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		// The stored headers describe the stored body, but the rate limit and other headers of the new response are the current ones.
+		for name, values := range response.Header {
+			if name != "Content-Length" {
+				header[name] = values
+			}
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         response.Proto,
+			ProtoMajor:    response.ProtoMajor,
+			ProtoMinor:    response.ProtoMinor,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(stored.Body)),
+			ContentLength: int64(len(stored.Body)),
+			Request:       response.Request,
+			TLS:           response.TLS,
+		}, nil
+	}
+
+	etag := response.Header.Get("ETag")
+	if response.StatusCode != http.StatusOK || etag == "" || !isJSON(response.Header) || response.ContentLength > maximumStoredResponseSize {
+		return response, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maximumStoredResponseSize+1))
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	if len(body) > maximumStoredResponseSize {
+		// The rest of the body is still returned, it just is not stored.
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+		return response, nil
+	}
+	response.Body.Close()
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	header := response.Header.Clone()
+	header.Del("Set-Cookie")
+	err = transport.cacheDirectory.WriteAPIResponse(key, cachedirectory.APIResponse{ETag: etag, Header: header, Body: body})
+	if err != nil {
+		log.Debugf("Could not store response for %s: %s", request.URL.Redacted(), err)
+		return response, nil
+	}
+	transport.use(key)
+	return response, nil
+}
+
+// removeUnused removes stored responses that were not used during this pull, such as those for releases that have been deleted or for a token that is no longer used.
+func (transport *conditionalTransport) removeUnused() error {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	return transport.cacheDirectory.RemoveAPIResponsesExcept(transport.used)
+}
@@ -0,0 +1,86 @@
+package pull
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalTransport(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := cachedirectory.NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, version.Version()))
+
+	body := `[{"tag_name": "codeql-bundle-20200101"}]`
+	etag := `"1"`
+	requests := 0
+	notModified := 0
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		requests++
+		response.Header().Set("X-RateLimit-Remaining", "100")
+		if request.Header.Get("If-None-Match") == etag {
+			notModified++
+			response.WriteHeader(http.StatusNotModified)
+			return
+		}
+		response.Header().Set("ETag", etag)
+		response.Header().Set("Content-Type", "application/json; charset=utf-8")
+		response.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	get := func(transport *conditionalTransport, token string) (*http.Response, string) {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/repos/github/codeql-action/releases", nil)
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "token "+token)
+		response, err := (&http.Client{Transport: transport}).Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		content, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		return response, string(content)
+	}
+
+	transport := newConditionalTransport(nil, cacheDirectory)
+	response, content := get(transport, "a")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, body, content)
+	require.Equal(t, 0, notModified)
+
+	transport = newConditionalTransport(nil, cacheDirectory)
+	response, content = get(transport, "a")
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, body, content)
+	require.Equal(t, "application/json; charset=utf-8", response.Header.Get("Content-Type"))
+	require.Equal(t, "100", response.Header.Get("X-RateLimit-Remaining"))
+	require.Equal(t, 1, notModified)
+
+	// A different token is a different request, as it might see different releases.
+	_, content = get(transport, "b")
+	require.Equal(t, body, content)
+	require.Equal(t, 1, notModified)
+
+	body = `[]`
+	etag = `"2"`
+	_, content = get(transport, "a")
+	require.Equal(t, body, content)
+	require.Equal(t, 1, notModified)
+	require.Equal(t, 4, requests)
+
+	// Responses that were not used are removed, so the next request with token b is made in full.
+	transport = newConditionalTransport(nil, cacheDirectory)
+	_, content = get(transport, "a")
+	require.Equal(t, body, content)
+	require.Equal(t, 2, notModified)
+	require.NoError(t, transport.removeUnused())
+	_, content = get(transport, "b")
+	require.Equal(t, body, content)
+	require.Equal(t, 2, notModified)
+}
@@ -3,47 +3,163 @@ package pull
 import (
 	"context"
 	"encoding/json"
+	usererrors "errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/github/codeql-action-sync/internal/actionconfiguration"
-	"github.com/mitchellh/ioprogress"
+	"github.com/github/codeql-action-sync/internal/assetselection"
 	"golang.org/x/oauth2"
 
 	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/concurrency"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/failures"
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/lfs"
+	"github.com/github/codeql-action-sync/internal/list"
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/github/codeql-action-sync/internal/progress"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/github/codeql-action-sync/internal/submodules"
 	"github.com/github/codeql-action-sync/internal/version"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/google/go-github/v32/github"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
 )
 
 const sourceOwner = "github"
 const sourceRepository = "codeql-action"
-const sourceURL = "https://github.com/" + sourceOwner + "/" + sourceRepository + ".git"
 
-var relevantReferences = regexp.MustCompile("^refs/(heads|tags)/(main|v\\d+)$")
+// DefaultSourceURL is the GitHub instance the CodeQL Action is pulled from by default.
+const DefaultSourceURL = "https://github.com"
 
-const defaultConfigurationPath = "src/defaults.json"
+// DefaultSourceRepository is the repository the CodeQL Action is pulled from by default.
+const DefaultSourceRepository = sourceOwner + "/" + sourceRepository
+
+// DefaultQueriesSourceRepository is the repository the CodeQL queries and libraries are pulled from by default.
+const DefaultQueriesSourceRepository = sourceOwner + "/codeql"
+
+// kindFlags are the flags that choose each kind of source other than the CodeQL Action, for error messages.
+var kindFlags = map[string]string{
+	cachedirectory.SourceKindCLI:     "--codeql-cli",
+	cachedirectory.SourceKindQueries: "--codeql-queries",
+}
+
+// Source identifies where the CodeQL Action and bundles are pulled from.
+type Source struct {
+	Owner      string
+	Repository string
+	GitURL     string
+	// APIURL is the base URL of the REST API, or empty to use GitHub.com.
+	APIURL string
+	// Directory is a local directory to read releases from instead of the API. See NewDirectorySource.
+	Directory string
+	// Kind is what the source holds, either cachedirectory.SourceKindAction if empty, cachedirectory.SourceKindCLI for the standalone CodeQL CLI releases, or cachedirectory.SourceKindQueries for the Git repository of the CodeQL queries and libraries.
+	Kind string
+}
+
+// NewSource creates a source for a copy of the CodeQL Action in the given repository on GitHub.com or any other GitHub instance, such as a GitHub Enterprise Server that the CodeQL Action has already been pushed to. This allows instances to be chained, for sites that can reach an intermediate instance but not GitHub.com.
+func NewSource(instanceURL string, repository string) (Source, error) {
+	repositorySplit := strings.Split(repository, "/")
+	if len(repositorySplit) != 2 || repositorySplit[0] == "" || repositorySplit[1] == "" {
+		return Source{}, fmt.Errorf("The source repository %s is not valid. It should be given as owner/name.", repository)
+	}
+	instanceURL = strings.TrimRight(instanceURL, "/")
+	if instanceURL == "" {
+		instanceURL = DefaultSourceURL
+	}
+	source := Source{
+		Owner:      repositorySplit[0],
+		Repository: repositorySplit[1],
+		GitURL:     instanceURL + "/" + repository + ".git",
+	}
+	if instanceURL != DefaultSourceURL {
+		source.APIURL = strings.TrimRight(githubapiutil.APIURL(instanceURL), "/")
+	}
+	return source, nil
+}
+
+const errorMinimizeTransferWithoutLanguages = "The `--minimize-transfer` flag requires the languages in use to be provided with the `--languages` flag."
+const errorGitDepthWithSourceDirectory = "The `--git-depth` flag cannot be used with `--source-directory`."
+const errorNegativeGitDepth = "The `--git-depth` flag must not be negative."
+const errorSubmodulesWithGitDepth = "The `--submodules` and `--git-depth` flags cannot be used together, as pulling submodules rewrites the history of the CodeQL Action."
+const errorSubmodulesWithRemoteCache = "The `--submodules` flag can only be used with a cache on the local filesystem."
+const errorKindWithSourceDirectory = "The `%s` flag cannot be used with `--source-directory`."
+const errorKindWithLanguages = "The `--languages` and `--minimize-transfer` flags cannot be used with `%s`, as they only choose between CodeQL bundles."
+const hintRateLimitedWithoutToken = "Requests made without a token are limited to 60 an hour by GitHub.com. Give a token with `--source-token` to raise the limit to 5,000 an hour."
+const hintNotFoundWithoutToken = "The source repository could not be found. If it is private, give a token that can read it with `--source-token`."
 
 type pullService struct {
-	ctx                context.Context
-	cacheDirectory     cachedirectory.CacheDirectory
-	gitCloneURL        string
+	ctx              context.Context
+	cacheDirectory   cachedirectory.CacheDirectory
+	gitCloneURL      string
+	sourceOwner      string
+	sourceRepository string
+	sourceDirectory  string
+	sourceKind       string
+	// kindChanged is set when the cache was last pulled from a different kind of source, whose releases must all be removed.
+	kindChanged        bool
 	githubDotComClient *github.Client
 	sourceToken        string
+	languageMapping    *assetselection.Mapping
+	languages          []string
+	minimizeTransfer   bool
+	gitDepth           int
+	refFilter          refFilter
+	signingKeys        openpgp.EntityList
+	verified           map[plumbing.Hash]bool
+	strict             bool
+	includePrereleases bool
+	includeDrafts      bool
+	retention          retention.Policy
+	cutoff             time.Time
+	concurrency        concurrency.Limits
+	showProgress       bool
+	downloadProgress   *progress.Reporter
+	manifest           *cachedirectory.Manifest
 }
 
+type assetDownload struct {
+	releaseTag string
+	asset      *github.ReleaseAsset
+	source     string
+}
+
+// gitPull is a Git fetch into the cache which has fetched the references needed to find the relevant releases, but not yet the rest.
+type gitPull struct {
+	fresh              bool
+	repository         *git.Repository
+	remote             gitRemote
+	credentials        *githttp.BasicAuth
+	previousReferences map[string]string
+	remoteReferences   []*plumbing.Reference
+}
+
+// pullGit updates the Git repository cache in one go.
 func (pullService *pullService) pullGit(fresh bool) error {
+	gitPull, err := pullService.startPullGit(fresh)
+	if err != nil {
+		return err
+	}
+	return pullService.finishPullGit(gitPull, pullService.gitProgress())
+}
+
+// startPullGit prunes references that no longer exist at the source or are excluded, and fetches `main` and the major versions of the CodeQL Action, so that the relevant releases can be found while the rest of the references are fetched by finishPullGit.
+func (pullService *pullService) startPullGit(fresh bool) (*gitPull, error) {
 	if fresh {
 		log.Debug("Pulling Git contents fresh...")
 	} else {
@@ -55,53 +171,87 @@ func (pullService *pullService) pullGit(fresh bool) error {
 	if fresh {
 		err := os.RemoveAll(gitPath)
 		if err != nil {
-			return errors.Wrap(err, "Error removing existing Git repository cache.")
+			return nil, errors.Wrap(err, "Error removing existing Git repository cache.")
 		}
 		localRepository, err = git.PlainInit(gitPath, true)
 		if err != nil {
-			return errors.Wrap(err, "Error initializing Git repository cache.")
+			return nil, errors.Wrap(err, "Error initializing Git repository cache.")
 		}
 	} else {
 		var err error
 		localRepository, err = git.PlainOpen(gitPath)
 		if err != nil {
-			return errors.Wrap(err, "Error opening Git repository cache.")
+			return nil, errors.Wrap(err, "Error opening Git repository cache.")
+		}
+		if pullService.gitDepth == 0 {
+			shallowCommits, err := localRepository.Storer.Shallow()
+			if err != nil {
+				return nil, errors.Wrap(err, "Error reading shallow commits of Git repository cache.")
+			}
+			// Go-git cannot deepen a shallow repository, so the full history has to be pulled again.
+			if len(shallowCommits) != 0 {
+				log.Info("The cache only has recent Git history as it was pulled with `--git-depth`, so pulling the full history...")
+				return pullService.startPullGit(true)
+			}
 		}
 	}
 
-	err := localRepository.DeleteRemote(git.DefaultRemoteName)
+	gitPull := gitPull{fresh: fresh, repository: localRepository}
+	// The references from before the pull are compared with those fetched to find rewrites, and to put back any that fail verification.
+	references, err := localRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error listing local references.")
+	}
+	gitPull.previousReferences, err = report.ReferenceHashes(references)
+	if err != nil {
+		return nil, err
+	}
+
+	err = localRepository.DeleteRemote(git.DefaultRemoteName)
 	if err != nil && err != git.ErrRemoteNotFound {
-		return errors.Wrap(err, "Error removing existing Git remote.")
+		return nil, errors.Wrap(err, "Error removing existing Git remote.")
 	}
 
-	remote := git.NewRemote(localRepository.Storer, &config.RemoteConfig{
-		Name: git.DefaultRemoteName,
-		URLs: []string{pullService.gitCloneURL},
-	})
+	if pullService.sourceDirectory != "" {
+		gitPull.remote, err = newDirectoryRemote(localRepository.Storer, pullService.gitCloneURL)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		gitPull.remote = git.NewRemote(localRepository.Storer, &config.RemoteConfig{
+			Name: git.DefaultRemoteName,
+			URLs: []string{pullService.gitCloneURL},
+		})
+	}
 
-	var credentials *githttp.BasicAuth
 	if pullService.sourceToken != "" {
-		credentials = &githttp.BasicAuth{
+		gitPull.credentials = &githttp.BasicAuth{
 			Username: "x-access-token",
 			Password: pullService.sourceToken,
 		}
 	}
 
-	remoteReferences, err := remote.List(&git.ListOptions{Auth: credentials})
+	err = retry.Do(pullService.ctx, "listing remote references", func(attempt int) error {
+		var err error
+		gitPull.remoteReferences, err = gitPull.remote.List(&git.ListOptions{Auth: gitPull.credentials})
+		return err
+	})
 	if err != nil {
-		return errors.Wrap(err, "Error listing remote references.")
+		return nil, errors.Wrap(err, "Error listing remote references.")
 	}
 	localReferences, err := localRepository.References()
 	if err != nil {
-		return errors.Wrap(err, "Error listing local references.")
+		return nil, errors.Wrap(err, "Error listing local references.")
 	}
 	localReferences.ForEach(func(localReference *plumbing.Reference) error {
 		if !strings.HasPrefix(localReference.Name().String(), "refs/") {
 			return nil
 		}
-		for _, remoteReference := range remoteReferences {
-			if remoteReference.Name().String() == localReference.Name().String() {
-				return nil
+		if pullService.refFilter.includes(localReference.Name()) {
+			for _, remoteReference := range gitPull.remoteReferences {
+				if remoteReference.Name().String() == localReference.Name().String() {
+					return nil
+				}
 			}
 		}
 		err := localRepository.Storer.RemoveReference(localReference.Name())
@@ -111,187 +261,830 @@ func (pullService *pullService) pullGit(fresh bool) error {
 		return nil
 	})
 
-	err = remote.FetchContext(pullService.ctx, &git.FetchOptions{
-		RemoteName: git.DefaultRemoteName,
-		RefSpecs: []config.RefSpec{
-			config.RefSpec("+refs/heads/*:refs/heads/*"),
-			config.RefSpec("+refs/tags/*:refs/tags/*"),
-		},
-		Progress: os.Stderr,
-		Tags:     git.NoTags,
-		Force:    true,
-		Auth:     credentials,
+	relevantRefSpecs := []config.RefSpec{}
+	for _, remoteReference := range gitPull.remoteReferences {
+		if actionconfiguration.IsRelevantReference(remoteReference.Name()) {
+			if !pullService.refFilter.includes(remoteReference.Name()) {
+				log.Warnf("The reference %s is excluded from the pull, so the CodeQL bundles it uses will not be pulled.", remoteReference.Name().String())
+				continue
+			}
+			name := remoteReference.Name().String()
+			relevantRefSpecs = append(relevantRefSpecs, config.RefSpec("+"+name+":"+name))
+		}
+	}
+	if len(relevantRefSpecs) != 0 {
+		log.Debug("Fetching the main and major version references...")
+		err = pullService.fetchGit(&gitPull, relevantRefSpecs, pullService.gitProgress())
+		if err != nil {
+			return nil, err
+		}
+		// These are verified straight away, as the releases to pull are found from them.
+		err = pullService.verifyReferences(&gitPull, actionconfiguration.IsRelevantReference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &gitPull, nil
+}
+
+// finishPullGit fetches every branch and tag of the source that is not excluded, which only transfers what startPullGit did not.
+func (pullService *pullService) finishPullGit(gitPull *gitPull, progress io.Writer) error {
+	refSpecs := pullService.refFilter.refSpecs(gitPull.remoteReferences)
+	if len(refSpecs) != 0 {
+		err := pullService.fetchGit(gitPull, refSpecs, progress)
+		if err != nil {
+			return err
+		}
+	}
+	err := pullService.verifyReferences(gitPull, nil)
+	if err != nil {
+		return err
+	}
+	err = pullService.checkRewrites(gitPull)
+	if err != nil {
+		return err
+	}
+	err = pullService.storeDefaultBranch(gitPull)
+	if err != nil {
+		return err
+	}
+	recorder := report.FromContext(pullService.ctx)
+	if recorder != nil {
+		references, err := gitPull.repository.References()
+		if err != nil {
+			return errors.Wrap(err, "Error listing local references.")
+		}
+		currentReferences, err := report.ReferenceHashes(references)
+		if err != nil {
+			return err
+		}
+		recorder.RecordReferences(gitPull.previousReferences, currentReferences)
+	}
+	return nil
+}
+
+// storeDefaultBranch points `HEAD` of the Git repository cache at the default branch of the source, so that `push` can make the same branch the default on the destination. If the source does not say which branch is its default, or that branch was not pulled, `HEAD` is left as it is.
+func (pullService *pullService) storeDefaultBranch(gitPull *gitPull) error {
+	for _, remoteReference := range gitPull.remoteReferences {
+		if remoteReference.Name() != plumbing.HEAD || remoteReference.Type() != plumbing.SymbolicReference {
+			continue
+		}
+		defaultBranch := remoteReference.Target()
+		_, err := gitPull.repository.Reference(defaultBranch, false)
+		if err == plumbing.ErrReferenceNotFound {
+			log.Debugf("Not recording %s as the default branch as it was not pulled.", defaultBranch.Short())
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "Error finding default branch.")
+		}
+		err = gitPull.repository.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, defaultBranch))
+		if err != nil {
+			return errors.Wrap(err, "Error recording default branch.")
+		}
+		return nil
+	}
+	return nil
+}
+
+func (pullService *pullService) fetchGit(gitPull *gitPull, refSpecs []config.RefSpec, progress io.Writer) error {
+	err := retry.Do(pullService.ctx, "doing Git fetch", func(attempt int) error {
+		err := gitPull.remote.FetchContext(pullService.ctx, &git.FetchOptions{
+			RemoteName: git.DefaultRemoteName,
+			RefSpecs:   refSpecs,
+			Progress:   progress,
+			Tags:       git.NoTags,
+			Force:      true,
+			Auth:       gitPull.credentials,
+			Depth:      pullService.gitDepth,
+		})
+		if err == git.NoErrAlreadyUpToDate {
+			return nil
+		}
+		return err
 	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
+	if err != nil {
 		return errors.Wrap(err, "Error doing Git fetch.")
 	}
 	return nil
 }
 
 func (pullService *pullService) findRelevantReleases() ([]string, error) {
+	switch pullService.sourceKind {
+	case cachedirectory.SourceKindCLI:
+		return pullService.findRecentReleases()
+	case cachedirectory.SourceKindQueries:
+		// Only the Git repository of the CodeQL queries is synced.
+		return []string{}, nil
+	}
 	log.Debug("Finding release references...")
 	localRepository, err := git.PlainOpen(pullService.cacheDirectory.GitPath())
 	if err != nil {
 		return []string{}, errors.Wrap(err, "Error opening Git repository cache.")
 	}
-	references, err := localRepository.References()
+	return actionconfiguration.BundleVersions(localRepository, pullService.retention.KeepLast)
+}
+
+// gitProgress is where Git writes its progress, or nil if progress is not being shown.
+func (pullService *pullService) gitProgress() io.Writer {
+	if !pullService.showProgress {
+		return nil
+	}
+	return os.Stderr
+}
+
+// openAssetDownload starts downloading an asset from the given offset. The returned offset is where the download actually starts, which may be zero if the server does not support resuming downloads.
+func (pullService *pullService) openAssetDownload(releaseTag string, asset *github.ReleaseAsset, offset int64) (io.ReadCloser, int64, error) {
+	if pullService.sourceDirectory != "" {
+		return pullService.openDirectoryAsset(releaseTag, asset, offset)
+	}
+	reader, redirectURL, err := pullService.githubDotComClient.Repositories.DownloadReleaseAsset(pullService.ctx, pullService.sourceOwner, pullService.sourceRepository, asset.GetID(), nil)
 	if err != nil {
-		return []string{}, errors.Wrap(err, "Error reading references from Git repository cache.")
+		return nil, 0, errors.Wrap(err, "Error downloading asset.")
 	}
-	defer references.Close()
-	releasesMap := map[string]bool{}
-	releases := []string{}
-	err = references.ForEach(func(reference *plumbing.Reference) error {
-		if relevantReferences.MatchString(reference.Name().String()) {
-			log.Debugf("Found %s.", reference.Name().String())
-			commit, err := localRepository.CommitObject(reference.Hash())
-			if err != nil {
-				return errors.Wrapf(err, "Error loading commit %s for reference %s.", reference.Hash(), reference.Name().String())
-			}
-			file, err := commit.File(defaultConfigurationPath)
-			if err != nil {
-				if err == object.ErrFileNotFound {
-					log.Debugf("Ignoring reference %s as it does not have a default configuration.", reference.Name().String())
-					return nil
-				}
-				return errors.Wrapf(err, "Error loading default configuration file from commit %s for reference %s.", reference.Hash(), reference.Name().String())
-			}
-			content, err := file.Contents()
-			if err != nil {
-				return errors.Wrapf(err, "Error reading default configuration file content from commit %s for reference %s.", reference.Hash(), reference.Name().String())
-			}
-			configuration, err := actionconfiguration.Parse(content)
-			if err != nil {
-				return err
-			}
-			if _, exists := releasesMap[configuration.BundleVersion]; !exists {
-				releasesMap[configuration.BundleVersion] = true
-				releases = append(releases, configuration.BundleVersion)
+	if reader != nil {
+		// The asset was served directly rather than via a redirect, so we have no way to request only part of it.
+		return reader, 0, nil
+	}
+	request, err := http.NewRequestWithContext(pullService.ctx, http.MethodGet, redirectURL, nil)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Error downloading asset.")
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, err := retry.NewClient(nil).Do(request)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Error downloading asset.")
+	}
+	if response.StatusCode >= 300 {
+		response.Body.Close()
+		return nil, 0, &retry.StatusError{StatusCode: response.StatusCode, Activity: "downloading asset"}
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		offset = 0
+	}
+	return response.Body, offset, nil
+}
+
+// openSourceAsset downloads an asset of a release that has already been pulled, so that the assets of a streaming cache can be read from the source again when they are pushed.
+func (pullService *pullService) openSourceAsset(releaseTag string, assetName string) (io.ReadCloser, error) {
+	release, err := pullService.cachedRelease(releaseTag)
+	if err != nil {
+		return nil, err
+	}
+	if release != nil {
+		for _, asset := range release.Assets {
+			if asset.GetName() == assetName {
+				var reader io.ReadCloser
+				err := retry.Do(pullService.ctx, "downloading asset "+assetName, func(attempt int) error {
+					var err error
+					reader, _, err = pullService.openAssetDownload(releaseTag, asset, 0)
+					return err
+				})
+				return reader, err
 			}
 		}
+	}
+	return nil, errors.Errorf("The asset %s of release %s is not in the cached release metadata.", assetName, releaseTag)
+}
+
+func (pullService *pullService) downloadAsset(releaseTag string, asset *github.ReleaseAsset) error {
+	if pullService.downloadsInSegments(asset) {
+		err := pullService.downloadAssetInSegments(releaseTag, asset)
+		if err != errRangesNotSupported {
+			return err
+		}
+		log.Debugf("The server does not support downloading part of asset %s, so downloading it over a single connection.", asset.GetName())
+	}
+	size := githubapiutil.AssetSize(asset)
+	if !pullService.cacheDirectory.SupportsPartialAssets() {
+		reader, _, err := pullService.openAssetDownload(releaseTag, asset, 0)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		progressReader := pullService.downloadProgress.Track(asset.GetName(), reader, size, 0)
+		defer progressReader.Close()
+		err = pullService.cacheDirectory.WriteAsset(releaseTag, asset.GetName(), progressReader, size)
+		if err != nil {
+			return errors.Wrap(err, "Error downloading asset.")
+		}
 		return nil
+	}
+
+	partialAsset, err := pullService.cacheDirectory.OpenPartialAsset(releaseTag, asset.GetName(), size)
+	if err != nil {
+		return err
+	}
+	defer partialAsset.Close()
+	if partialAsset.Offset() > 0 {
+		log.Debugf("Resuming download from byte %d of %d...", partialAsset.Offset(), size)
+	}
+	reader, offset, err := pullService.openAssetDownload(releaseTag, asset, partialAsset.Offset())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if offset != partialAsset.Offset() {
+		log.Debug("The server does not support resuming downloads. Starting again from the beginning.")
+		err = partialAsset.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	progressReader := pullService.downloadProgress.Track(asset.GetName(), reader, size, offset)
+	defer progressReader.Close()
+	_, err = io.Copy(partialAsset, progressReader)
+	if err != nil {
+		return errors.Wrap(err, "Error downloading asset.")
+	}
+	return partialAsset.Complete()
+}
+
+// assetSource identifies the version of an asset at the source. Assets on GitHub are identified by their ID and when they were last updated, as the API does not give their checksums, and assets in a source directory by their checksum.
+func (pullService *pullService) assetSource(releaseTag string, asset *github.ReleaseAsset) (string, error) {
+	if pullService.sourceDirectory != "" {
+		digest, err := pullService.directoryAssetDigest(releaseTag, asset)
+		if err != nil {
+			return "", err
+		}
+		return "sha256:" + digest, nil
+	}
+	return fmt.Sprintf("%d@%s", asset.GetID(), asset.GetUpdatedAt().UTC().Format(time.RFC3339)), nil
+}
+
+// isCached checks whether the cache already has the same version of an asset as the source. The size is compared first as it is cheap, then the cached asset is checked against the checksum recorded in the manifest when it was downloaded.
+func (pullService *pullService) isCached(releaseTag string, asset *github.ReleaseAsset, source string) (bool, error) {
+	cachedSize, err := pullService.cacheDirectory.AssetSize(releaseTag, asset.GetName())
+	if err != nil || cachedSize != githubapiutil.AssetSize(asset) {
+		return false, nil
+	}
+	entry, exists := pullService.manifest.Get(releaseTag, asset.GetName())
+	if !exists || entry.Size != cachedSize || entry.Source != source {
+		return false, nil
+	}
+	digest, err := pullService.cacheDirectory.AssetDigest(releaseTag, asset.GetName())
+	if err != nil {
+		return false, err
+	}
+	if digest != entry.SHA256 {
+		log.Warnf("The cached asset %s of release %s does not match the checksum recorded when it was downloaded, so it will be downloaded again.", asset.GetName(), releaseTag)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (pullService *pullService) recordDownload(download assetDownload) error {
+	digest, err := pullService.cacheDirectory.AssetDigest(download.releaseTag, download.asset.GetName())
+	if err != nil {
+		return err
+	}
+	pullService.manifest.Set(download.releaseTag, download.asset.GetName(), cachedirectory.ManifestEntry{
+		Size:   githubapiutil.AssetSize(download.asset),
+		SHA256: digest,
+		Source: download.source,
 	})
+	err = pullService.cacheDirectory.WriteManifest(pullService.manifest)
+	if err != nil {
+		return err
+	}
+	pullService.deduplicateAsset(download.releaseTag, download.asset.GetName(), digest)
+	return nil
+}
+
+// deduplicateAsset makes a cached asset share its content with identical assets of other releases. If this fails the asset is simply stored again, so it is only a warning.
+func (pullService *pullService) deduplicateAsset(releaseTag string, assetName string, digest string) {
+	err := pullService.cacheDirectory.DeduplicateAsset(releaseTag, assetName, digest)
+	if err != nil {
+		log.Warnf("Could not share the cached asset %s of release %s with identical assets of other releases: %s", assetName, releaseTag, err)
+	}
+}
+
+func (pullService *pullService) selectAssets(assets []*github.ReleaseAsset) ([]*github.ReleaseAsset, error) {
+	included := []*github.ReleaseAsset{}
+	for _, asset := range assets {
+		if pullService.languageMapping.IncludesAsset(asset.GetName()) {
+			included = append(included, asset)
+		} else {
+			log.Debugf("Skipping asset %s as it is excluded by `--include-assets` or `--exclude-assets`.", asset.GetName())
+		}
+	}
+	assets = included
+	if len(pullService.languages) == 0 {
+		return assets, nil
+	}
+	candidates := []assetselection.Asset{}
+	for _, asset := range assets {
+		candidates = append(candidates, assetselection.Asset{Name: asset.GetName(), Size: githubapiutil.AssetSize(asset)})
+	}
+	var selection []assetselection.Asset
+	var err error
+	if pullService.minimizeTransfer {
+		selection, err = pullService.languageMapping.MinimizeAssets(candidates, pullService.languages)
+	} else {
+		selection, err = pullService.languageMapping.FilterAssets(candidates, pullService.languages)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return releases, nil
+	selected := map[string]bool{}
+	for _, asset := range selection {
+		selected[asset.Name] = true
+	}
+	result := []*github.ReleaseAsset{}
+	for _, asset := range assets {
+		if selected[asset.GetName()] {
+			result = append(result, asset)
+		} else {
+			log.Debugf("Skipping asset %s as it is not needed for the languages %s.", asset.GetName(), strings.Join(pullService.languages, ", "))
+		}
+	}
+	return result, nil
+}
+
+func recordSkippedAssets(recorder *report.Recorder, releaseTag string, assets []*github.ReleaseAsset, selected []*github.ReleaseAsset) {
+	selectedNames := map[string]bool{}
+	for _, asset := range selected {
+		selectedNames[asset.GetName()] = true
+	}
+	for _, asset := range assets {
+		if !selectedNames[asset.GetName()] {
+			recorder.RecordAsset(releaseTag, asset.GetName(), githubapiutil.AssetSize(asset), report.OutcomeSkipped)
+		}
+	}
 }
 
 func (pullService *pullService) pullReleases() error {
-	log.Debug("Pulling CodeQL bundles...")
 	relevantReleases, err := pullService.findRelevantReleases()
 	if err != nil {
 		return err
 	}
+	return pullService.pullRelevantReleases(relevantReleases)
+}
 
-	for index, releaseTag := range relevantReleases {
+// pullRelevantReleases pulls the given releases into the cache, and removes releases that are no longer relevant if `--keep-last` is used or the cache was pulled from a different kind of source. It does not use the Git repository cache, so it can run while the Git fetch finishes.
+func (pullService *pullService) pullRelevantReleases(relevantReleases []string) error {
+	log.Debug("Pulling CodeQL bundles...")
+	releaseAssets := make([][]*github.ReleaseAsset, len(relevantReleases))
+	err := concurrency.ForEach(len(relevantReleases), pullService.concurrency.APIRequests, func(index int) error {
+		releaseTag := relevantReleases[index]
+		defer metrics.FromContext(pullService.ctx).TimeRelease(releaseTag)()
 		log.Debugf("Pulling CodeQL bundle %s (%d/%d)...", releaseTag, index+1, len(relevantReleases))
-		release, _, err := pullService.githubDotComClient.Repositories.GetReleaseByTag(pullService.ctx, sourceOwner, sourceRepository, releaseTag)
+		var err error
+		releaseAssets[index], err = pullService.pullReleaseMetadata(releaseTag)
+		return failures.Record(pullService.ctx, releaseTag, err)
+	})
+	if err != nil {
+		return err
+	}
+
+	pullService.manifest, err = pullService.cacheDirectory.ReadManifest()
+	if err != nil {
+		return err
+	}
+	downloads := []assetDownload{}
+	for index, assets := range releaseAssets {
+		releaseTag := relevantReleases[index]
+		releaseDownloads, err := pullService.findDownloads(releaseTag, assets)
+		err = failures.Record(pullService.ctx, releaseTag, err)
 		if err != nil {
-			return errors.Wrap(err, "Error loading CodeQL release information.")
+			return err
 		}
-		err = os.MkdirAll(pullService.cacheDirectory.ReleasePath(releaseTag), 0755)
+		downloads = append(downloads, releaseDownloads...)
+	}
+	totalSize := int64(0)
+	for _, download := range downloads {
+		totalSize += githubapiutil.AssetSize(download.asset)
+	}
+	pullService.downloadProgress = progress.NewReporter(os.Stderr, "Downloading", pullService.showProgress && len(downloads) > 0)
+	pullService.downloadProgress.Start(totalSize)
+	defer pullService.downloadProgress.Stop()
+	err = concurrency.ForEach(len(downloads), pullService.concurrency.Downloads, func(index int) error {
+		download := downloads[index]
+		defer metrics.FromContext(pullService.ctx).TimeRelease(download.releaseTag)()
+		return failures.Record(pullService.ctx, download.releaseTag, pullService.pullAsset(download))
+	})
+	if err != nil {
+		return err
+	}
+	err = pullService.removeSkippedReleases()
+	if err != nil {
+		return err
+	}
+	if pullService.retention.KeepLast > 0 || pullService.kindChanged {
+		err = pullService.removeOldReleases(relevantReleases)
 		if err != nil {
-			return errors.Wrap(err, "Error creating releases directory.")
+			return err
 		}
-		releaseMetadataPath := pullService.cacheDirectory.MetadataPath(releaseTag)
-		releaseJSON, err := json.Marshal(release)
+	}
+	return pullService.cacheDirectory.PruneAssetContent(pullService.manifest)
+}
+
+// pullReleaseMetadata writes the metadata of a release into the cache, and returns the assets of the release to pull, or none if the release is not pulled.
+func (pullService *pullService) pullReleaseMetadata(releaseTag string) ([]*github.ReleaseAsset, error) {
+	cachedRelease, err := pullService.cachedRelease(releaseTag)
+	if err != nil {
+		return nil, err
+	}
+	if cachedRelease != nil && retention.Excludes(pullService.cutoff, cachedRelease.PublishedAt) {
+		log.Debugf("Not checking CodeQL bundle %s again as it was published before %s.", releaseTag, pullService.cutoff.Format(time.RFC3339))
+		report.FromContext(pullService.ctx).RecordRelease(releaseTag, report.OutcomeSkipped)
+		return nil, nil
+	}
+	release, err := pullService.getRelease(releaseTag)
+	if err != nil {
+		return nil, err
+	}
+	if pullService.skipsRelease(releaseTag, release) {
+		return nil, nil
+	}
+	// Releases that were handled by the last pull are always in the cache, so only a date given with `--since` excludes a release that is not.
+	if cachedRelease == nil && !pullService.retention.LastSync && retention.Excludes(pullService.cutoff, release.PublishedAt) {
+		log.Debugf("Not pulling CodeQL bundle %s as it was published before %s.", releaseTag, pullService.cutoff.Format(time.RFC3339))
+		report.FromContext(pullService.ctx).RecordRelease(releaseTag, report.OutcomeSkipped)
+		return nil, nil
+	}
+	releaseJSON, err := json.Marshal(release)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error converting release to JSON.")
+	}
+	err = pullService.cacheDirectory.WriteMetadata(releaseTag, releaseJSON)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error writing release metadata.")
+	}
+	assets, err := pullService.selectAssets(release.Assets)
+	if err != nil {
+		return nil, err
+	}
+	recordSkippedAssets(report.FromContext(pullService.ctx), releaseTag, release.Assets, assets)
+	return assets, nil
+}
+
+// findDownloads returns the assets of a release that need to be downloaded, as they are not already in the cache.
+func (pullService *pullService) findDownloads(releaseTag string, assets []*github.ReleaseAsset) ([]assetDownload, error) {
+	recorder := report.FromContext(pullService.ctx)
+	downloads := []assetDownload{}
+	for _, asset := range assets {
+		source, err := pullService.assetSource(releaseTag, asset)
 		if err != nil {
-			return errors.Wrap(err, "Error converting release to JSON.")
+			return nil, err
 		}
-		err = ioutil.WriteFile(releaseMetadataPath, releaseJSON, 0644)
+		cached, err := pullService.isCached(releaseTag, asset, source)
 		if err != nil {
-			return errors.Wrap(err, "Error writing release metadata.")
+			return nil, err
+		}
+		if cached {
+			log.Debugf("Asset %s is already in cache.", asset.GetName())
+			// Caches pulled by older versions of the sync tool do not share identical assets yet.
+			entry, _ := pullService.manifest.Get(releaseTag, asset.GetName())
+			pullService.deduplicateAsset(releaseTag, asset.GetName(), entry.SHA256)
+			recorder.RecordAsset(releaseTag, asset.GetName(), githubapiutil.AssetSize(asset), report.OutcomeUnchanged)
+			continue
 		}
-		assetsPath := pullService.cacheDirectory.AssetsPath(releaseTag)
-		err = os.MkdirAll(assetsPath, 0755)
+		downloads = append(downloads, assetDownload{releaseTag: releaseTag, asset: asset, source: source})
+	}
+	return downloads, nil
+}
+
+// pullAsset downloads an asset into the cache, replacing any earlier copy.
+func (pullService *pullService) pullAsset(download assetDownload) error {
+	log.Debugf("Downloading asset %s...", download.asset.GetName())
+	err := pullService.cacheDirectory.RemoveAsset(download.releaseTag, download.asset.GetName())
+	if err != nil {
+		return errors.Wrap(err, "Error removing existing cached asset.")
+	}
+	// A partly downloaded asset is resumed by the next attempt where the cache supports it.
+	err = retry.Do(pullService.ctx, "downloading asset "+download.asset.GetName(), func(attempt int) error {
+		return pullService.downloadAsset(download.releaseTag, download.asset)
+	})
+	if err != nil {
+		return err
+	}
+	err = pullService.recordDownload(download)
+	if err != nil {
+		return err
+	}
+	report.FromContext(pullService.ctx).RecordAsset(download.releaseTag, download.asset.GetName(), githubapiutil.AssetSize(download.asset), report.OutcomeDownloaded)
+	return nil
+}
+
+// cachedRelease returns the metadata of a release from the last pull, or nil if the release is not in the cache.
+func (pullService *pullService) cachedRelease(releaseTag string) (*github.RepositoryRelease, error) {
+	metadata, err := pullService.cacheDirectory.ReadMetadata(releaseTag)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading cached release metadata.")
+	}
+	release := github.RepositoryRelease{}
+	err = json.Unmarshal(metadata, &release)
+	if err != nil {
+		// The release is pulled again to replace the corrupt metadata.
+		return nil, nil
+	}
+	return &release, nil
+}
+
+// removeOldReleases removes releases from the cache that were pulled before but are no longer among the most recent releases kept with `--keep-last`, so that the cache does not keep growing.
+func (pullService *pullService) removeOldReleases(relevantReleases []string) error {
+	relevant := map[string]bool{}
+	for _, releaseTag := range relevantReleases {
+		relevant[releaseTag] = true
+	}
+	cachedReleases, err := pullService.cacheDirectory.ListReleases()
+	if err != nil {
+		return err
+	}
+	recorder := report.FromContext(pullService.ctx)
+	for _, releaseTag := range cachedReleases {
+		if relevant[releaseTag] {
+			continue
+		}
+		log.Debugf("Removing release %s from the cache as it is no longer one of the most recent releases...", releaseTag)
+		err := pullService.cacheDirectory.RemoveRelease(releaseTag)
 		if err != nil {
-			return errors.Wrap(err, "Error creating assets directory.")
+			return errors.Wrapf(err, "Error removing release %s from the cache.", releaseTag)
 		}
-		for _, asset := range release.Assets {
-			log.Debugf("Downloading asset %s...", asset.GetName())
-			downloadPath := pullService.cacheDirectory.AssetPath(releaseTag, asset.GetName())
-			downloadPathStat, err := os.Stat(downloadPath)
-			if err == nil && downloadPathStat.Size() == int64(asset.GetSize()) {
-				log.Debug("Asset is already in cache.")
-				continue
-			}
-			err = os.RemoveAll(downloadPath)
-			if err != nil {
-				return errors.Wrap(err, "Error removing existing cached asset.")
-			}
-			reader, redirectURL, err := pullService.githubDotComClient.Repositories.DownloadReleaseAsset(pullService.ctx, sourceOwner, sourceRepository, asset.GetID(), http.DefaultClient)
-			if err != nil {
-				return errors.Wrap(err, "Error downloading asset.")
-			}
-			if reader == nil {
-				response, err := http.Get(redirectURL)
-				if err != nil {
-					return errors.Wrap(err, "Error downloading asset.")
-				}
-				if response.StatusCode >= 300 {
-					return errors.Wrapf(err, "Status code %d while downloading asset.", response.StatusCode)
-				}
-				reader = response.Body
-			}
-			defer reader.Close()
-			downloadFile, err := os.Create(downloadPath)
-			if err != nil {
-				return errors.Wrap(err, "Error creating cached asset file.")
-			}
-			defer downloadFile.Close()
-			progressReader := &ioprogress.Reader{
-				Reader:   reader,
-				Size:     int64(asset.GetSize()),
-				DrawFunc: ioprogress.DrawTerminalf(os.Stderr, ioprogress.DrawTextFormatBytes),
-			}
-			_, err = io.Copy(downloadFile, progressReader)
-			if err != nil {
-				return errors.Wrap(err, "Error downloading asset.")
-			}
+		pullService.manifest.Remove(releaseTag)
+		recorder.RecordRelease(releaseTag, report.OutcomeRemoved)
+	}
+	return pullService.cacheDirectory.WriteManifest(pullService.manifest)
+}
+
+// pullLatestRelease records which release the source marks as its latest, so that the same release can be marked as latest on the destination. A source directory has no latest release, so the destination's is worked out from the release dates instead. The CodeQL queries have no releases at all.
+func (pullService *pullService) pullLatestRelease() error {
+	latestRelease := ""
+	if pullService.sourceDirectory == "" && pullService.sourceKind != cachedirectory.SourceKindQueries {
+		var release *github.RepositoryRelease
+		var response *github.Response
+		err := retry.Do(pullService.ctx, "loading latest release", func(attempt int) error {
+			var err error
+			release, response, err = pullService.githubDotComClient.Repositories.GetLatestRelease(pullService.ctx, pullService.sourceOwner, pullService.sourceRepository)
+			return err
+		})
+		if err != nil && (response == nil || response.StatusCode != http.StatusNotFound) {
+			return errors.Wrap(err, "Error loading latest CodeQL release information.")
 		}
+		latestRelease = release.GetTagName()
+	}
+	err := pullService.cacheDirectory.WriteLatestRelease(latestRelease)
+	if err != nil {
+		return errors.Wrap(err, "Error writing latest release.")
 	}
 	return nil
 }
 
-func Pull(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, sourceToken string) error {
-	err := cacheDirectory.CheckOrCreateVersionFile(true, version.Version())
+// warnWithoutToken explains errors that pulling with a token would have avoided. Pulling from GitHub.com works without a token until the rate limit for unauthenticated requests is reached, or if the source repository is private.
+func warnWithoutToken(err error, source Source, sourceToken string) {
+	if err == nil || sourceToken != "" || source.Directory != "" {
+		return
+	}
+	switch exitcode.Code(err) {
+	case exitcode.RateLimited:
+		log.Warn(hintRateLimitedWithoutToken)
+		return
+	case exitcode.Authentication:
+		log.Warn(hintNotFoundWithoutToken)
+		return
+	}
+	if errorResponse, ok := errors.Cause(err).(*github.ErrorResponse); ok && errorResponse.Response != nil && errorResponse.Response.StatusCode == http.StatusNotFound {
+		log.Warn(hintNotFoundWithoutToken)
+	}
+}
+
+// Options are the settings for a pull, which mostly match the `pull` flags of the same names.
+type Options struct {
+	Source             Source
+	SourceToken        string
+	LanguageMapping    *assetselection.Mapping
+	Languages          []string
+	MinimizeTransfer   bool
+	GitDepth           int
+	SubmoduleDepth     int
+	IncludeRefs        []string
+	ExcludeRefs        []string
+	VerifySignatures   bool
+	SigningKeyFiles    []string
+	Strict             bool
+	IncludePrereleases bool
+	IncludeDrafts      bool
+	RetentionPolicy    retention.Policy
+	Repack             bool
+	Limits             concurrency.Limits
+	ShowProgress       bool
+}
+
+func Pull(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, options Options) (err error) {
+	defer func() {
+		warnWithoutToken(err, options.Source, options.SourceToken)
+	}()
+	languages, err := options.LanguageMapping.ParseLanguages(options.Languages)
 	if err != nil {
 		return err
 	}
+	if options.MinimizeTransfer && len(languages) == 0 {
+		return usererrors.New(errorMinimizeTransferWithoutLanguages)
+	}
+	if options.GitDepth < 0 {
+		return usererrors.New(errorNegativeGitDepth)
+	}
+	if options.GitDepth != 0 && options.Source.Directory != "" {
+		return usererrors.New(errorGitDepthWithSourceDirectory)
+	}
+	if options.SubmoduleDepth != 0 && options.GitDepth != 0 {
+		return usererrors.New(errorSubmodulesWithGitDepth)
+	}
+	if options.SubmoduleDepth != 0 && cacheDirectory.IsRemote() {
+		return usererrors.New(errorSubmodulesWithRemoteCache)
+	}
+	sourceKind := options.Source.Kind
+	if sourceKind == "" {
+		sourceKind = cachedirectory.SourceKindAction
+	}
+	if sourceKind != cachedirectory.SourceKindAction {
+		if options.Source.Directory != "" {
+			return fmt.Errorf(errorKindWithSourceDirectory, kindFlags[sourceKind])
+		}
+		if len(languages) != 0 || options.MinimizeTransfer {
+			return fmt.Errorf(errorKindWithLanguages, kindFlags[sourceKind])
+		}
+	}
+
+	err = cacheDirectory.CheckOrCreateVersionFile(true, version.Version())
+	if err != nil {
+		return err
+	}
+	runLock, err := cacheDirectory.AcquireRunLock()
+	if err != nil {
+		return err
+	}
+	defer runLock.Release()
+	lastSync := time.Time{}
+	if options.RetentionPolicy.LastSync {
+		previousManifest, err := list.ReadManifest(cacheDirectory)
+		if err != nil {
+			return err
+		}
+		if previousManifest != nil {
+			lastSync = previousManifest.PulledAt
+		}
+	}
+	previousKind, err := cacheDirectory.ReadSourceKind()
+	if err != nil {
+		return errors.Wrap(err, "Error reading source kind from cache.")
+	}
 	err = cacheDirectory.Lock()
 	if err != nil {
 		return err
 	}
 
-	var tokenClient *http.Client
-	if sourceToken != "" {
+	// The conditional transport is inside the OAuth transport, so that it can see which token a request was made with.
+	conditional := newConditionalTransport(concurrency.NewTransport(nil, options.Limits.APIRequests), cacheDirectory)
+	apiClient := retry.NewClient(conditional)
+	if options.SourceToken != "" {
 		tokenSource := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: sourceToken},
+			&oauth2.Token{AccessToken: options.SourceToken},
 		)
-		tokenClient = oauth2.NewClient(ctx, tokenSource)
+		apiClient = oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, apiClient), tokenSource)
+	}
+
+	githubDotComClient := github.NewClient(apiClient)
+	if options.Source.APIURL != "" {
+		githubDotComClient.BaseURL, err = url.Parse(strings.TrimRight(options.Source.APIURL, "/") + "/")
+		if err != nil {
+			return errors.Wrap(err, "Error parsing source API URL.")
+		}
+	}
+
+	var signingKeys openpgp.EntityList
+	if options.VerifySignatures || len(options.SigningKeyFiles) != 0 {
+		signingKeys, err = loadSigningKeys(ctx, options.Source, options.SigningKeyFiles)
+		if err != nil {
+			return err
+		}
 	}
 
 	pullService := pullService{
 		ctx:                ctx,
 		cacheDirectory:     cacheDirectory,
-		gitCloneURL:        sourceURL,
-		githubDotComClient: github.NewClient(tokenClient),
-		sourceToken:        sourceToken,
+		gitCloneURL:        options.Source.GitURL,
+		sourceOwner:        options.Source.Owner,
+		sourceRepository:   options.Source.Repository,
+		sourceDirectory:    options.Source.Directory,
+		sourceKind:         sourceKind,
+		kindChanged:        previousKind != sourceKind,
+		githubDotComClient: githubDotComClient,
+		sourceToken:        options.SourceToken,
+		languageMapping:    options.LanguageMapping,
+		languages:          languages,
+		minimizeTransfer:   options.MinimizeTransfer,
+		gitDepth:           options.GitDepth,
+		refFilter:          newRefFilter(options.IncludeRefs, options.ExcludeRefs),
+		signingKeys:        signingKeys,
+		verified:           map[plumbing.Hash]bool{},
+		strict:             options.Strict,
+		includePrereleases: options.IncludePrereleases,
+		includeDrafts:      options.IncludeDrafts,
+		retention:          options.RetentionPolicy,
+		cutoff:             options.RetentionPolicy.Cutoff(lastSync),
+		concurrency:        options.Limits,
+		showProgress:       options.ShowProgress,
 	}
 
-	err = pullService.pullGit(false)
+	cacheDirectory.SetAssetSource(pullService.openSourceAsset)
+
+	err = cacheDirectory.LoadGit()
+	if err != nil {
+		return err
+	}
+	// Fetching from the history as it was before submodules were rewritten only transfers what is new.
+	err = submodules.Restore(cacheDirectory)
+	if err != nil {
+		return err
+	}
+	gitPull, err := pullService.startPullGit(false)
+	if stopsPull(err) {
+		return err
+	}
 	if err != nil {
 		// If an error occurred updating the existing copy then try cloning fresh instead. An error is expected if the local cache does not yet exist, but even if it is corrupt in some way we can safely delete it and start again.
-		err := pullService.pullGit(true)
+		gitPull, err = pullService.startPullGit(true)
 		if err != nil {
 			return err
 		}
 	}
-	err = pullService.pullReleases()
+	// The relevant releases only depend on the references that have already been fetched, so they are pulled while the rest of the Git fetch carries on.
+	relevantReleases, releasesErr := pullService.findRelevantReleases()
+	gitErrors := make(chan error, 1)
+	go func() {
+		// Git progress would be mixed up with the download progress, so it is only shown before the downloads start.
+		gitErrors <- pullService.finishPullGit(gitPull, nil)
+	}()
+	if releasesErr == nil {
+		releasesErr = pullService.pullRelevantReleases(relevantReleases)
+	}
+	err = <-gitErrors
+	if stopsPull(err) {
+		return err
+	}
+	if err != nil && !gitPull.fresh {
+		log.Debugf("Error finishing the Git fetch, so pulling Git contents fresh: %s", err)
+		err = pullService.pullGit(true)
+	}
 	if err != nil {
 		return err
 	}
+	err = pullService.repackGit(options.Repack)
+	if err != nil {
+		return err
+	}
+	// Submodules are rewritten after repacking, which would otherwise remove the history from before they were rewritten.
+	err = submodules.Pull(ctx, cacheDirectory, options.Source.GitURL, options.SourceToken, options.SubmoduleDepth)
+	if err != nil {
+		return err
+	}
+	err = cacheDirectory.StoreGit()
+	if err != nil {
+		return err
+	}
+	if releasesErr != nil {
+		// The Git repository in the cache has already been updated, so it is out of step with the bundles until the pull is run again.
+		return exitcode.WithCode(releasesErr, exitcode.PartialSuccess)
+	}
+	if options.Source.Directory != "" {
+		err = lfs.PullFromDirectory(cacheDirectory, options.Source.GitURL)
+	} else {
+		err = lfs.Pull(ctx, cacheDirectory, options.Source.GitURL, options.SourceToken)
+	}
+	if err != nil {
+		return err
+	}
+	err = pullService.pullLatestRelease()
+	if err != nil {
+		log.Warnf("Could not find the latest release of the source, so the latest release of the destination will be worked out from release dates: %s", err)
+	}
+	sourceRepositoryName := ""
+	if options.Source.Owner != "" {
+		sourceRepositoryName = options.Source.Owner + "/" + options.Source.Repository
+	}
+	err = cacheDirectory.WriteSourceRepository(sourceRepositoryName)
+	if err != nil {
+		return errors.Wrap(err, "Error writing source repository.")
+	}
+	err = cacheDirectory.WriteSourceKind(sourceKind)
+	if err != nil {
+		return errors.Wrap(err, "Error writing source kind.")
+	}
+	err = list.WriteManifest(cacheDirectory, pullService.manifest, time.Now())
+	if err != nil {
+		return err
+	}
+	err = conditional.removeUnused()
+	if err != nil {
+		log.Debugf("Could not remove unused stored API responses: %s", err)
+	}
 
 	err = cacheDirectory.Unlock()
 	if err != nil {
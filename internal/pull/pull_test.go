@@ -1,14 +1,30 @@
 package pull
 
 import (
+	"bytes"
 	"context"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/github/codeql-action-sync/internal/assetselection"
 	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/concurrency"
+	"github.com/github/codeql-action-sync/internal/failures"
+	"github.com/github/codeql-action-sync/internal/retention"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
 	"github.com/github/codeql-action-sync/test"
@@ -60,7 +76,10 @@ func getTestPullService(t *testing.T, temporaryDirectory string, gitCloneURL str
 		ctx:                context.Background(),
 		cacheDirectory:     cacheDirectory,
 		gitCloneURL:        gitCloneURL,
+		sourceOwner:        "github",
+		sourceRepository:   "codeql-action",
 		githubDotComClient: githubDotComClient,
+		languageMapping:    assetselection.DefaultMapping(),
 	}
 }
 
@@ -71,9 +90,9 @@ func checkExpectedReferencesInCache(t *testing.T, cacheDirectory cachedirectory.
 	require.NoError(t, err)
 	actualReferences := []string{}
 	err = referenceIterator.ForEach(func(reference *plumbing.Reference) error {
-		referenceString := reference.String()
-		if referenceString != "ref: refs/heads/master HEAD" {
-			actualReferences = append(actualReferences, referenceString)
+		// `HEAD` only records the default branch, which is checked separately.
+		if reference.Name() != plumbing.HEAD {
+			actualReferences = append(actualReferences, reference.String())
 		}
 		return nil
 	})
@@ -96,6 +115,73 @@ func TestPullGitFresh(t *testing.T) {
 		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
 		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning",
 	})
+	// The default branch of the source is recorded as `HEAD`.
+	repository, err := git.PlainOpen(pullService.cacheDirectory.GitPath())
+	require.NoError(t, err)
+	head, err := repository.Reference(plumbing.HEAD, false)
+	require.NoError(t, err)
+	require.Equal(t, plumbing.NewBranchReferenceName("main"), head.Target())
+}
+
+func TestPullGitPreservesSignedTags(t *testing.T) {
+	sourcePath := path.Join(test.CreateTemporaryDirectory(t), "source")
+	tagHash := test.CreateRepositoryWithSignedTag(t, sourcePath, "v1.0.0")
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pullService := getTestPullService(t, temporaryDirectory, sourcePath, "")
+	require.NoError(t, pullService.pullGit(true))
+	test.CheckSignedTagInRepository(t, pullService.cacheDirectory.GitPath(), "v1.0.0", tagHash)
+}
+
+func TestPullGitExcludesReferences(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, "")
+	require.NoError(t, pullService.pullGit(true))
+
+	// References already in the cache are pruned once they are excluded.
+	pullService.refFilter = newRefFilter([]string{}, []string{"very-*", "a-ref-*", "refs/tags/an-ignored-tag-too"})
+	require.NoError(t, pullService.pullGit(false))
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+	})
+
+	// Excluded references are never fetched.
+	pullService.refFilter = newRefFilter([]string{"main", "v1", "very-ignored-branch"}, []string{})
+	require.NoError(t, pullService.pullGit(true))
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+	})
+}
+
+func TestStartPullGitFetchesRelevantReferencesFirst(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, "")
+	gitPull, err := pullService.startPullGit(true)
+	require.NoError(t, err)
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+	})
+	relevantReleases, err := pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"some-codeql-version-on-main", "some-codeql-version-on-v1-and-v2"}, relevantReleases)
+
+	require.NoError(t, pullService.finishPullGit(gitPull, nil))
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning",
+	})
 }
 
 func TestPullGitNotFreshReturnsErrorIfNoCache(t *testing.T) {
@@ -132,6 +218,63 @@ func TestPullGitNotFreshWithChanges(t *testing.T) {
 	})
 }
 
+// sourceRepositoryWithNewCommits copies the initial source repository and adds commits on top of `main`, so that a shallow pull leaves some of its history out.
+func sourceRepositoryWithNewCommits(t *testing.T, count int) (string, []plumbing.Hash) {
+	sourcePath := path.Join(test.CreateTemporaryDirectory(t), "source.git")
+	repository, err := git.PlainInit(sourcePath, true)
+	require.NoError(t, err)
+	remote, err := repository.CreateRemote(&config.RemoteConfig{Name: "initial", URLs: []string{initialActionRepository}})
+	require.NoError(t, err)
+	require.NoError(t, remote.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}}))
+	hashes := []plumbing.Hash{}
+	for index := 0; index < count; index++ {
+		reference, err := repository.Reference(plumbing.NewBranchReferenceName("main"), false)
+		require.NoError(t, err)
+		parent, err := repository.CommitObject(reference.Hash())
+		require.NoError(t, err)
+		signature := object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(int64(index), 0)}
+		commit := object.Commit{
+			Author:       signature,
+			Committer:    signature,
+			Message:      "A new commit.",
+			TreeHash:     parent.TreeHash,
+			ParentHashes: []plumbing.Hash{parent.Hash},
+		}
+		encodedObject := repository.Storer.NewEncodedObject()
+		require.NoError(t, commit.Encode(encodedObject))
+		hash, err := repository.Storer.SetEncodedObject(encodedObject)
+		require.NoError(t, err)
+		require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), hash)))
+		hashes = append(hashes, hash)
+	}
+	return sourcePath, hashes
+}
+
+func TestPullGitShallow(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	sourcePath, newCommits := sourceRepositoryWithNewCommits(t, 2)
+	pullService := getTestPullService(t, temporaryDirectory, sourcePath, "")
+	pullService.gitDepth = 1
+	require.NoError(t, pullService.pullGit(true))
+	repository, err := git.PlainOpen(pullService.cacheDirectory.GitPath())
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.HasEncodedObject(newCommits[1]))
+	require.Equal(t, plumbing.ErrObjectNotFound, repository.Storer.HasEncodedObject(newCommits[0]))
+	shallowCommits, err := repository.Storer.Shallow()
+	require.NoError(t, err)
+	require.Contains(t, shallowCommits, newCommits[1])
+
+	// Pulling without a depth replaces the shallow history with the full history.
+	pullService.gitDepth = 0
+	require.NoError(t, pullService.pullGit(false))
+	repository, err = git.PlainOpen(pullService.cacheDirectory.GitPath())
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.HasEncodedObject(newCommits[0]))
+	shallowCommits, err = repository.Storer.Shallow()
+	require.NoError(t, err)
+	require.Empty(t, shallowCommits)
+}
+
 func TestFindRelevantReleases(t *testing.T) {
 	temporaryDirectory := test.CreateTemporaryDirectory(t)
 	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, "")
@@ -162,6 +305,7 @@ func TestPullReleases(t *testing.T) {
 		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnV1AndV2Content, response)
 	}).Methods("GET").Headers("accept", "application/octet-stream")
 	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	pullService.concurrency = concurrency.Limits{Downloads: 4, APIRequests: 4}
 	err := pullService.pullGit(true)
 	require.NoError(t, err)
 	err = pullService.pullReleases()
@@ -190,3 +334,430 @@ func TestPullReleases(t *testing.T) {
 	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnMainContent, pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
 	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnV1AndV2Content, pullService.cacheDirectory.AssetPath("some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz"))
 }
+
+func TestPullReleasesIntoStreamingCache(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnMain, response)
+	}).Methods("GET")
+	downloads := 0
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		downloads++
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnMainContent, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.cacheDirectory.SetStreaming())
+	pullService.cacheDirectory.SetAssetSource(pullService.openSourceAsset)
+	require.NoError(t, pullService.pullGit(true))
+	require.NoError(t, pullService.pullRelevantReleases([]string{"some-codeql-version-on-main"}))
+	require.Equal(t, 1, downloads)
+	content, err := ioutil.ReadFile(pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+	require.NoError(t, err)
+	require.NotEqual(t, releaseSomeCodeQLVersionOnMainContent, string(content))
+
+	// The asset is downloaded again when it is read, such as to push it.
+	reader, err := pullService.cacheDirectory.OpenAsset("some-codeql-version-on-main", "codeql-bundle.tar.gz")
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, releaseSomeCodeQLVersionOnMainContent, string(content))
+	require.Equal(t, 2, downloads)
+
+	// Pulling again does not download an asset that has not changed.
+	require.NoError(t, pullService.pullRelevantReleases([]string{"some-codeql-version-on-main"}))
+	require.Equal(t, 2, downloads)
+}
+
+func TestPullReleasesContinueOnError(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnMain, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnMainContent, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnV1AndV2, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/2", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	err := pullService.pullGit(true)
+	require.NoError(t, err)
+	require.Error(t, pullService.pullReleases())
+
+	collector := failures.NewCollector()
+	pullService.ctx = failures.WithCollector(pullService.ctx, collector)
+	require.NoError(t, pullService.pullReleases())
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnMainContent, pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+	require.False(t, collector.Failed("some-codeql-version-on-main"))
+	require.True(t, collector.Failed("some-codeql-version-on-v1-and-v2"))
+	require.Error(t, collector.Err())
+}
+
+func TestPullReleasesRemovesOldReleasesWithKeepLast(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnMain, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnMainContent, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnV1AndV2, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnV1AndV2Content, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullGit(true))
+	// A release pulled before that no longer matters.
+	oldContent := "An old not-bundle."
+	require.NoError(t, pullService.cacheDirectory.WriteMetadata("some-old-codeql-version", []byte(`{"tag_name": "some-old-codeql-version"}`)))
+	require.NoError(t, pullService.cacheDirectory.WriteAsset("some-old-codeql-version", "codeql-bundle.tar.gz", strings.NewReader(oldContent), int64(len(oldContent))))
+
+	// Without `--keep-last` the old release is kept.
+	require.NoError(t, pullService.pullReleases())
+	releases, err := pullService.cacheDirectory.ListReleases()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"some-codeql-version-on-main", "some-codeql-version-on-v1-and-v2", "some-old-codeql-version"}, releases)
+
+	pullService.retention.KeepLast = 1
+	require.NoError(t, pullService.pullReleases())
+	releases, err = pullService.cacheDirectory.ListReleases()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"some-codeql-version-on-main", "some-codeql-version-on-v1-and-v2"}, releases)
+	manifest, err := pullService.cacheDirectory.ReadManifest()
+	require.NoError(t, err)
+	_, exists := manifest.Releases["some-old-codeql-version"]
+	require.False(t, exists)
+}
+
+func TestPullReleasesSince(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	releaseOnMain := releaseSomeCodeQLVersionOnMain
+	releaseOnMain.PublishedAt = &github.Timestamp{Time: time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)}
+	releaseOnV1AndV2 := releaseSomeCodeQLVersionOnV1AndV2
+	releaseOnV1AndV2.PublishedAt = &github.Timestamp{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseOnMain, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnMainContent, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseOnV1AndV2, response)
+	}).Methods("GET")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullGit(true))
+	pullService.retention = retention.Policy{Since: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}
+	pullService.cutoff = pullService.retention.Since
+	require.NoError(t, pullService.pullReleases())
+	releases, err := pullService.cacheDirectory.ListReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"some-codeql-version-on-main"}, releases)
+
+	// With `--since last-sync`, releases already in the cache are not checked again, but older releases that are not in the cache yet are still pulled.
+	githubTestServer, githubURL = test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseOnV1AndV2, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnV1AndV2Content, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	pullService = getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	pullService.retention = retention.Policy{LastSync: true}
+	pullService.cutoff = time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, pullService.pullReleases())
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnMainContent, pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnV1AndV2Content, pullService.cacheDirectory.AssetPath("some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz"))
+}
+
+func TestPullReleasesComparesChecksums(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	serveReleases := func(githubTestServer *mux.Router, redownloadedAsset string, content string) {
+		githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+			test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnMain, response)
+		}).Methods("GET")
+		githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+			test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnV1AndV2, response)
+		}).Methods("GET")
+		if redownloadedAsset != "" {
+			githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/"+redownloadedAsset, func(response http.ResponseWriter, request *http.Request) {
+				test.ServeHTTPResponseFromString(t, content, response)
+			}).Methods("GET").Headers("accept", "application/octet-stream")
+		}
+	}
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	serveReleases(githubTestServer, "1", releaseSomeCodeQLVersionOnMainContent)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnV1AndV2Content, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullGit(true))
+	require.NoError(t, pullService.pullReleases())
+
+	// Nothing has changed, so nothing is downloaded again.
+	githubTestServer, githubURL = test.GetTestHTTPServer(t)
+	serveReleases(githubTestServer, "", "")
+	pullService = getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullReleases())
+
+	// A corrupted asset of the same size is noticed by its checksum.
+	assetPath := pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz")
+	require.NoError(t, ioutil.WriteFile(assetPath, []byte(strings.Repeat("?", len(releaseSomeCodeQLVersionOnMainContent))), 0644))
+	githubTestServer, githubURL = test.GetTestHTTPServer(t)
+	serveReleases(githubTestServer, "1", releaseSomeCodeQLVersionOnMainContent)
+	pullService = getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullReleases())
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnMainContent, assetPath)
+
+	// An asset without a checksum in the manifest is downloaded again.
+	manifest, err := pullService.cacheDirectory.ReadManifest()
+	require.NoError(t, err)
+	delete(manifest.Releases, "some-codeql-version-on-v1-and-v2")
+	require.NoError(t, pullService.cacheDirectory.WriteManifest(manifest))
+	githubTestServer, githubURL = test.GetTestHTTPServer(t)
+	serveReleases(githubTestServer, "2", releaseSomeCodeQLVersionOnV1AndV2Content)
+	pullService = getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullReleases())
+	entry, exists := pullService.manifest.Get("some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz")
+	require.True(t, exists)
+	require.Equal(t, int64(len(releaseSomeCodeQLVersionOnV1AndV2Content)), entry.Size)
+}
+
+func TestPullReleasesResumesInterruptedDownload(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	largeContent := bytes.Repeat([]byte("Still not a CodeQL bundle. "), 200000)
+	largeRelease := github.RepositoryRelease{
+		TagName: github.String("some-codeql-version-on-main"),
+		Name:    github.String("some-codeql-version-on-main"),
+		Assets: []*github.ReleaseAsset{
+			&github.ReleaseAsset{
+				ID:   github.Int64(1),
+				Name: github.String("codeql-bundle.tar.gz"),
+				Size: github.Int(len(largeContent)),
+			},
+		},
+	}
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, largeRelease, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		http.Redirect(response, request, "/download/codeql-bundle.tar.gz", http.StatusFound)
+	}).Methods("GET")
+	requestedRanges := []string{}
+	githubTestServer.HandleFunc("/download/codeql-bundle.tar.gz", func(response http.ResponseWriter, request *http.Request) {
+		requestedRanges = append(requestedRanges, request.Header.Get("Range"))
+		http.ServeContent(response, request, "", time.Time{}, bytes.NewReader(largeContent))
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnV1AndV2, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnV1AndV2Content, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	err := pullService.pullGit(true)
+	require.NoError(t, err)
+
+	// Simulate a download that was interrupted part of the way through the second chunk.
+	partialAsset, err := pullService.cacheDirectory.OpenPartialAsset("some-codeql-version-on-main", "codeql-bundle.tar.gz", int64(len(largeContent)))
+	require.NoError(t, err)
+	_, err = partialAsset.Write(largeContent[:len(largeContent)-1000])
+	require.NoError(t, err)
+	require.NoError(t, partialAsset.Close())
+
+	err = pullService.pullReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes=4194304-"}, requestedRanges)
+	test.RequireFileHasContent(t, string(largeContent), pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnV1AndV2Content, pullService.cacheDirectory.AssetPath("some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz"))
+}
+
+func TestPullReleasesDownloadsInSegments(t *testing.T) {
+	defer func(threshold int64) { segmentedDownloadThreshold = threshold }(segmentedDownloadThreshold)
+	segmentedDownloadThreshold = 1000
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	largeContent := bytes.Repeat([]byte("Still not a CodeQL bundle. "), 100)
+	largeRelease := github.RepositoryRelease{
+		TagName: github.String("some-codeql-version-on-main"),
+		Name:    github.String("some-codeql-version-on-main"),
+		Assets: []*github.ReleaseAsset{
+			&github.ReleaseAsset{
+				ID:   github.Int64(1),
+				Name: github.String("codeql-bundle.tar.gz"),
+				Size: github.Int(len(largeContent)),
+			},
+		},
+	}
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, largeRelease, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		http.Redirect(response, request, "/download/codeql-bundle.tar.gz", http.StatusFound)
+	}).Methods("GET")
+	var lock sync.Mutex
+	requestedRanges := []string{}
+	supportsRanges := true
+	githubTestServer.HandleFunc("/download/codeql-bundle.tar.gz", func(response http.ResponseWriter, request *http.Request) {
+		lock.Lock()
+		requestedRanges = append(requestedRanges, request.Header.Get("Range"))
+		lock.Unlock()
+		if !supportsRanges {
+			response.Write(largeContent)
+			return
+		}
+		http.ServeContent(response, request, "", time.Time{}, bytes.NewReader(largeContent))
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnV1AndV2, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnV1AndV2Content, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	pullService.concurrency = concurrency.Limits{Downloads: 2, APIRequests: 2, DownloadSegments: 3}
+	require.NoError(t, pullService.pullGit(true))
+
+	require.NoError(t, pullService.pullReleases())
+	require.ElementsMatch(t, []string{"bytes=0-899", "bytes=900-1799", "bytes=1800-2699"}, requestedRanges)
+	test.RequireFileHasContent(t, string(largeContent), pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+	// The small asset is below the threshold, so it is downloaded over a single connection.
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnV1AndV2Content, pullService.cacheDirectory.AssetPath("some-codeql-version-on-v1-and-v2", "codeql-bundle.tar.gz"))
+
+	// If the server ignores the ranges, the asset is downloaded over a single connection instead.
+	require.NoError(t, pullService.cacheDirectory.RemoveAsset("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+	supportsRanges = false
+	requestedRanges = []string{}
+	require.NoError(t, pullService.pullReleases())
+	require.Contains(t, requestedRanges, "")
+	test.RequireFileHasContent(t, string(largeContent), pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+}
+
+func TestPullLatestRelease(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	latestRelease := &releaseSomeCodeQLVersionOnMain
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/latest", func(response http.ResponseWriter, request *http.Request) {
+		if latestRelease == nil {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		test.ServeHTTPResponseFromObject(t, latestRelease, response)
+	}).Methods("GET")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullLatestRelease())
+	tag, err := pullService.cacheDirectory.ReadLatestRelease()
+	require.NoError(t, err)
+	require.Equal(t, "some-codeql-version-on-main", tag)
+
+	// A source with no latest release clears what was recorded before.
+	latestRelease = nil
+	require.NoError(t, pullService.pullLatestRelease())
+	tag, err = pullService.cacheDirectory.ReadLatestRelease()
+	require.NoError(t, err)
+	require.Equal(t, "", tag)
+}
+
+func TestSelectAssets(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, "")
+	releaseAssets := []*github.ReleaseAsset{
+		&github.ReleaseAsset{Name: github.String("codeql-bundle.tar.gz"), Size: github.Int(1000)},
+		&github.ReleaseAsset{Name: github.String("codeql-bundle-python.tar.gz"), Size: github.Int(100)},
+		&github.ReleaseAsset{Name: github.String("codeql-bundle-java.tar.gz"), Size: github.Int(100)},
+	}
+
+	assets, err := pullService.selectAssets(releaseAssets)
+	require.NoError(t, err)
+	require.Len(t, assets, 3)
+
+	pullService.languages = []string{"go", "python"}
+	assets, err = pullService.selectAssets(releaseAssets)
+	require.NoError(t, err)
+	require.Len(t, assets, 2)
+	require.Equal(t, "codeql-bundle.tar.gz", assets[0].GetName())
+	require.Equal(t, "codeql-bundle-python.tar.gz", assets[1].GetName())
+
+	pullService.minimizeTransfer = true
+	pullService.languages = []string{"python"}
+	assets, err = pullService.selectAssets(releaseAssets)
+	require.NoError(t, err)
+	require.Len(t, assets, 1)
+	require.Equal(t, "codeql-bundle-python.tar.gz", assets[0].GetName())
+
+	// Assets excluded by name are not candidates for the languages, so the full bundle is used instead.
+	require.NoError(t, pullService.languageMapping.SetAssetPatterns(nil, []string{"*-python.*"}))
+	assets, err = pullService.selectAssets(releaseAssets)
+	require.NoError(t, err)
+	require.Len(t, assets, 1)
+	require.Equal(t, "codeql-bundle.tar.gz", assets[0].GetName())
+}
+
+func TestNewSource(t *testing.T) {
+	source, err := NewSource(DefaultSourceURL, DefaultSourceRepository)
+	require.NoError(t, err)
+	require.Equal(t, Source{Owner: "github", Repository: "codeql-action", GitURL: "https://github.com/github/codeql-action.git"}, source)
+
+	source, err = NewSource("https://ghes.example.com/", "mirrors/codeql-action")
+	require.NoError(t, err)
+	require.Equal(t, Source{Owner: "mirrors", Repository: "codeql-action", GitURL: "https://ghes.example.com/mirrors/codeql-action.git", APIURL: "https://ghes.example.com/api/v3"}, source)
+
+	source, err = NewSource("https://octocorp.ghe.com", "mirrors/codeql-action")
+	require.NoError(t, err)
+	require.Equal(t, Source{Owner: "mirrors", Repository: "codeql-action", GitURL: "https://octocorp.ghe.com/mirrors/codeql-action.git", APIURL: "https://api.octocorp.ghe.com"}, source)
+
+	_, err = NewSource("https://ghes.example.com", "codeql-action")
+	require.EqualError(t, err, "The source repository codeql-action is not valid. It should be given as owner/name.")
+}
+
+func TestWarnWithoutToken(t *testing.T) {
+	output := bytes.Buffer{}
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	source := Source{Owner: "github", Repository: "codeql-action"}
+
+	warnWithoutToken(errors.Wrap(&github.RateLimitError{}, "Error listing releases."), source, "")
+	require.Contains(t, output.String(), "Give a token with `--source-token` to raise the limit")
+
+	output.Reset()
+	notFound := &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}
+	warnWithoutToken(errors.Wrap(notFound, "Error listing releases."), source, "")
+	require.Contains(t, output.String(), "If it is private, give a token that can read it with `--source-token`.")
+
+	output.Reset()
+	warnWithoutToken(errors.Wrap(transport.ErrAuthenticationRequired, "Error listing remote references."), source, "")
+	require.Contains(t, output.String(), "If it is private")
+
+	// A token was given, or none was needed, so there is nothing to suggest.
+	output.Reset()
+	warnWithoutToken(errors.Wrap(&github.RateLimitError{}, "Error listing releases."), source, "abc123")
+	warnWithoutToken(errors.Wrap(notFound, "Error listing releases."), Source{Directory: "/staging"}, "")
+	warnWithoutToken(errors.New("Something went wrong."), source, "")
+	warnWithoutToken(nil, source, "")
+	require.Empty(t, output.String())
+}
+
+func TestPullQueriesHasNoReleases(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	// There is no API client, so this also checks that the API is not used.
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, "")
+	pullService.sourceKind = cachedirectory.SourceKindQueries
+	require.NoError(t, pullService.pullGit(true))
+	relevantReleases, err := pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.Empty(t, relevantReleases)
+	require.NoError(t, pullService.pullLatestRelease())
+	latestRelease, err := pullService.cacheDirectory.ReadLatestRelease()
+	require.NoError(t, err)
+	require.Equal(t, "", latestRelease)
+}
@@ -0,0 +1,80 @@
+package pull
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// refFilter selects which branches and tags of the source are pulled into the cache, from the patterns given with `--include-refs` and `--exclude-refs`.
+type refFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// globPattern converts a pattern in which `*` matches any characters, including `/` as it does in Git refspecs, and `?` matches any single character.
+func globPattern(pattern string) *regexp.Regexp {
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+	return regexp.MustCompile("^" + expression + "$")
+}
+
+func globPatterns(patterns []string) []*regexp.Regexp {
+	result := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			result = append(result, globPattern(pattern))
+		}
+	}
+	return result
+}
+
+func newRefFilter(include []string, exclude []string) refFilter {
+	return refFilter{include: globPatterns(include), exclude: globPatterns(exclude)}
+}
+
+// matchesAny returns whether any of the patterns matches a reference, either by its full name, such as `refs/heads/dependabot/npm`, or by its branch or tag name, such as `dependabot/npm`.
+func matchesAny(patterns []*regexp.Regexp, name plumbing.ReferenceName) bool {
+	shortName := strings.TrimPrefix(strings.TrimPrefix(name.String(), "refs/heads/"), "refs/tags/")
+	for _, pattern := range patterns {
+		if pattern.MatchString(name.String()) || pattern.MatchString(shortName) {
+			return true
+		}
+	}
+	return false
+}
+
+// active returns whether any patterns were given, as otherwise every branch and tag is pulled.
+func (filter refFilter) active() bool {
+	return len(filter.include) != 0 || len(filter.exclude) != 0
+}
+
+// includes returns whether a reference should be pulled. If no include patterns were given every reference is included unless it is excluded.
+func (filter refFilter) includes(name plumbing.ReferenceName) bool {
+	if len(filter.include) != 0 && !matchesAny(filter.include, name) {
+		return false
+	}
+	return !matchesAny(filter.exclude, name)
+}
+
+// refSpecs returns the refspecs to fetch every branch and tag of the source that is included. Without any patterns all of them are fetched with wildcards, and otherwise each included reference is fetched by name, so that excluded references are never transferred.
+func (filter refFilter) refSpecs(remoteReferences []*plumbing.Reference) []config.RefSpec {
+	if !filter.active() {
+		return []config.RefSpec{
+			config.RefSpec("+refs/heads/*:refs/heads/*"),
+			config.RefSpec("+refs/tags/*:refs/tags/*"),
+		}
+	}
+	refSpecs := []config.RefSpec{}
+	for _, remoteReference := range remoteReferences {
+		name := remoteReference.Name()
+		if (name.IsBranch() || name.IsTag()) && filter.includes(name) {
+			refSpecs = append(refSpecs, config.RefSpec("+"+name.String()+":"+name.String()))
+		}
+	}
+	return refSpecs
+}
@@ -0,0 +1,39 @@
+package pull
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefFilter(t *testing.T) {
+	filter := newRefFilter([]string{}, []string{"dependabot/*", "refs/heads/experiment-?"})
+	require.True(t, filter.includes("refs/heads/main"))
+	require.True(t, filter.includes("refs/tags/v1"))
+	require.False(t, filter.includes("refs/heads/dependabot/npm_and_yarn/lodash-4.17.21"))
+	require.False(t, filter.includes("refs/heads/experiment-1"))
+	require.True(t, filter.includes("refs/heads/experiment-10"))
+
+	filter = newRefFilter([]string{"main", "v*"}, []string{"v2"})
+	require.True(t, filter.includes("refs/heads/main"))
+	require.True(t, filter.includes("refs/heads/v1"))
+	require.True(t, filter.includes("refs/tags/v1.0.0"))
+	require.False(t, filter.includes("refs/tags/v2"))
+	require.False(t, filter.includes("refs/heads/feature"))
+
+	require.True(t, newRefFilter(nil, nil).includes("refs/heads/anything"))
+}
+
+func TestRefFilterRefSpecs(t *testing.T) {
+	remoteReferences := []*plumbing.Reference{
+		plumbing.NewSymbolicReference("HEAD", "refs/heads/main"),
+		plumbing.NewHashReference("refs/heads/main", plumbing.ZeroHash),
+		plumbing.NewHashReference("refs/heads/dependabot/github_actions/actions/checkout-2", plumbing.ZeroHash),
+		plumbing.NewHashReference("refs/tags/v1", plumbing.ZeroHash),
+		plumbing.NewHashReference("refs/pull/1/head", plumbing.ZeroHash),
+	}
+	require.Equal(t, []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}, newRefFilter(nil, nil).refSpecs(remoteReferences))
+	require.Equal(t, []config.RefSpec{"+refs/heads/main:refs/heads/main", "+refs/tags/v1:refs/tags/v1"}, newRefFilter(nil, []string{"dependabot/*"}).refSpecs(remoteReferences))
+}
@@ -0,0 +1,100 @@
+package pull
+
+import (
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// skippedKind returns why a release is not pulled, which is because it is a draft and `--include-drafts` was not given, or because it is a prerelease, such as a beta CodeQL bundle, and `--include-prereleases` was not given. The flag that would pull it is returned too. Both are empty if the release is pulled.
+func (pullService *pullService) skippedKind(release *github.RepositoryRelease) (string, string) {
+	if release.GetDraft() && !pullService.includeDrafts {
+		return "a draft", "--include-drafts"
+	}
+	if release.GetPrerelease() && !pullService.includePrereleases {
+		return "a prerelease", "--include-prereleases"
+	}
+	return "", ""
+}
+
+// skipsRelease returns whether a release is not pulled because it is a draft or a prerelease, logging why if so.
+func (pullService *pullService) skipsRelease(releaseTag string, release *github.RepositoryRelease) bool {
+	kind, flag := pullService.skippedKind(release)
+	if kind == "" {
+		return false
+	}
+	log.Warnf("Not pulling CodeQL bundle %s as it is %s. Use `%s` to pull it.", releaseTag, kind, flag)
+	report.FromContext(pullService.ctx).RecordRelease(releaseTag, report.OutcomeSkipped)
+	return true
+}
+
+// removeSkippedReleases removes drafts and prereleases from the cache that were pulled before with `--include-drafts` or `--include-prereleases`, so that a cache pulled without them only has the releases it would have had if they had never been given.
+func (pullService *pullService) removeSkippedReleases() error {
+	if pullService.includeDrafts && pullService.includePrereleases {
+		return nil
+	}
+	cachedReleases, err := pullService.cacheDirectory.ListReleases()
+	if err != nil {
+		return err
+	}
+	recorder := report.FromContext(pullService.ctx)
+	removed := false
+	for _, releaseTag := range cachedReleases {
+		cachedRelease, err := pullService.cachedRelease(releaseTag)
+		if err != nil {
+			return err
+		}
+		if cachedRelease == nil {
+			continue
+		}
+		kind, _ := pullService.skippedKind(cachedRelease)
+		if kind == "" {
+			continue
+		}
+		log.Debugf("Removing release %s from the cache as it is %s...", releaseTag, kind)
+		err = pullService.cacheDirectory.RemoveRelease(releaseTag)
+		if err != nil {
+			return errors.Wrapf(err, "Error removing release %s from the cache.", releaseTag)
+		}
+		pullService.manifest.Remove(releaseTag)
+		recorder.RecordRelease(releaseTag, report.OutcomeRemoved)
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return pullService.cacheDirectory.WriteManifest(pullService.manifest)
+}
+
+// findDraftRelease looks for a draft release with the given tag at the source, or returns nil if there is none. Draft releases cannot be fetched by their tag, and are only listed for tokens that can push to the source repository.
+func (pullService *pullService) findDraftRelease(releaseTag string) (*github.RepositoryRelease, error) {
+	log.Debugf("Looking for CodeQL bundle %s among the draft releases of the source...", releaseTag)
+	options := &github.ListOptions{PerPage: 100}
+	for {
+		var releases []*github.RepositoryRelease
+		var response *github.Response
+		err := retry.Do(pullService.ctx, "listing releases", func(attempt int) error {
+			var err error
+			releases, response, err = pullService.githubDotComClient.Repositories.ListReleases(pullService.ctx, pullService.sourceOwner, pullService.sourceRepository, options)
+			return err
+		})
+		if sizeErr := githubapiutil.AssetSizeError(err); sizeErr != nil {
+			return nil, sizeErr
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error listing releases.")
+		}
+		for _, release := range releases {
+			if release.GetDraft() && release.GetTagName() == releaseTag {
+				return release, nil
+			}
+		}
+		if response.NextPage == 0 {
+			return nil, nil
+		}
+		options.Page = response.NextPage
+	}
+}
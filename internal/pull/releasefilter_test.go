@@ -0,0 +1,91 @@
+package pull
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullReleasesSkipsPrereleases(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	releaseOnMain := releaseSomeCodeQLVersionOnMain
+	releaseOnMain.Prerelease = github.Bool(true)
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-main", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseOnMain, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnMainContent, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/some-codeql-version-on-v1-and-v2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, releaseSomeCodeQLVersionOnV1AndV2, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/assets/2", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromString(t, releaseSomeCodeQLVersionOnV1AndV2Content, response)
+	}).Methods("GET").Headers("accept", "application/octet-stream")
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, githubURL)
+	require.NoError(t, pullService.pullGit(true))
+
+	pullService.includePrereleases = true
+	require.NoError(t, pullService.pullReleases())
+	releases, err := pullService.cacheDirectory.ListReleases()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"some-codeql-version-on-main", "some-codeql-version-on-v1-and-v2"}, releases)
+	test.RequireFileHasContent(t, releaseSomeCodeQLVersionOnMainContent, pullService.cacheDirectory.AssetPath("some-codeql-version-on-main", "codeql-bundle.tar.gz"))
+
+	// Pulling without `--include-prereleases` removes the prerelease pulled before.
+	pullService.includePrereleases = false
+	require.NoError(t, pullService.pullReleases())
+	releases, err = pullService.cacheDirectory.ListReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"some-codeql-version-on-v1-and-v2"}, releases)
+	manifest, err := pullService.cacheDirectory.ReadManifest()
+	require.NoError(t, err)
+	_, exists := manifest.Releases["some-codeql-version-on-main"]
+	require.False(t, exists)
+}
+
+func TestFindRecentReleasesIncludesDraftsAndPrereleases(t *testing.T) {
+	pullService := getTestCLIPullService(t, test.CreateTemporaryDirectory(t), serveCLIReleases(t))
+	pullService.includePrereleases = true
+	pullService.retention.KeepLast = 3
+	releases, err := pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2.2.0", "v2.2.0-beta", "v2.1.0"}, releases)
+
+	pullService.includeDrafts = true
+	releases, err = pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2.3.0", "v2.2.0", "v2.2.0-beta"}, releases)
+}
+
+func TestGetReleaseFindsDrafts(t *testing.T) {
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	// Draft releases cannot be fetched by their tag.
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases/tags/codeql-bundle-20200630", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action/releases", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, []github.RepositoryRelease{
+			{TagName: github.String("codeql-bundle-20200101")},
+			{TagName: github.String("codeql-bundle-20200630"), Draft: github.Bool(true)},
+		}, response)
+	}).Methods("GET")
+	pullService := getTestPullService(t, test.CreateTemporaryDirectory(t), initialActionRepository, githubURL)
+	_, err := pullService.getRelease("codeql-bundle-20200630")
+	require.Error(t, err)
+
+	pullService.includeDrafts = true
+	release, err := pullService.getRelease("codeql-bundle-20200630")
+	require.NoError(t, err)
+	require.True(t, release.GetDraft())
+	kind, _ := pullService.skippedKind(release)
+	require.Empty(t, kind)
+	pullService.includeDrafts = false
+	kind, flag := pullService.skippedKind(release)
+	require.Equal(t, "a draft", kind)
+	require.Equal(t, "--include-drafts", flag)
+}
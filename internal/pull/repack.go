@@ -0,0 +1,79 @@
+package pull
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Each fetch adds a pack to the cache, and objects written outside a fetch are stored loose, so the cache is repacked once either builds up.
+const repackPackThreshold = 20
+const repackLooseObjectThreshold = 1000
+
+// needsRepack reports whether the Git repository in the cache has built up enough packs or loose objects to be worth repacking.
+func needsRepack(gitRepository *git.Repository) (bool, error) {
+	packedObjectStorer, isPacked := gitRepository.Storer.(storer.PackedObjectStorer)
+	looseObjectStorer, isLoose := gitRepository.Storer.(storer.LooseObjectStorer)
+	if !isPacked || !isLoose {
+		return false, nil
+	}
+	packs, err := packedObjectStorer.ObjectPacks()
+	if err != nil {
+		return false, errors.Wrap(err, "Error listing packs in Git repository cache.")
+	}
+	if len(packs) > repackPackThreshold {
+		return true, nil
+	}
+	looseObjects := 0
+	err = looseObjectStorer.ForEachObjectHash(func(plumbing.Hash) error {
+		looseObjects++
+		if looseObjects > repackLooseObjectThreshold {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "Error listing loose objects in Git repository cache.")
+	}
+	return looseObjects > repackLooseObjectThreshold, nil
+}
+
+// repackGit replaces the packs and loose objects in the Git repository in the cache with a single pack of the objects that are still reachable, if it needs it or force is set.
+func (pullService *pullService) repackGit(force bool) error {
+	gitRepository, err := git.PlainOpen(pullService.cacheDirectory.GitPath())
+	if err != nil {
+		return errors.Wrap(err, "Error opening Git repository cache.")
+	}
+	shallowCommits, err := gitRepository.Storer.Shallow()
+	if err != nil {
+		return errors.Wrap(err, "Error reading shallow commits of Git repository cache.")
+	}
+	if len(shallowCommits) != 0 {
+		// Go-git walks the full history of every reference to repack, which fails for a shallow repository.
+		if force {
+			log.Warn("The Git repository in the cache cannot be repacked as it was pulled with `--git-depth`.")
+		}
+		return nil
+	}
+	if !force {
+		needed, err := needsRepack(gitRepository)
+		if err != nil {
+			return err
+		}
+		if !needed {
+			return nil
+		}
+	}
+	log.Info("Repacking the Git repository in the cache...")
+	err = gitRepository.Prune(git.PruneOptions{Handler: gitRepository.DeleteObject})
+	if err != nil {
+		return errors.Wrap(err, "Error removing unreachable objects from Git repository cache.")
+	}
+	err = gitRepository.RepackObjects(&git.RepackConfig{})
+	if err != nil {
+		return errors.Wrap(err, "Error repacking Git repository cache.")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package pull
+
+import (
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/stretchr/testify/require"
+)
+
+func countPacks(t *testing.T, gitPath string) int {
+	repository, err := git.PlainOpen(gitPath)
+	require.NoError(t, err)
+	packs, err := repository.Storer.(storer.PackedObjectStorer).ObjectPacks()
+	require.NoError(t, err)
+	return len(packs)
+}
+
+func TestRepackGit(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pullService := getTestPullService(t, temporaryDirectory, initialActionRepository, "")
+	require.NoError(t, pullService.pullGit(true))
+	pullService = getTestPullService(t, temporaryDirectory, modifiedActionRepository, "")
+	require.NoError(t, pullService.pullGit(false))
+	// Each pull fetches the main and major version references before the rest, so it can add more than one pack.
+	packs := countPacks(t, pullService.cacheDirectory.GitPath())
+	require.Greater(t, packs, 1)
+
+	// A few packs are not enough to be repacked automatically.
+	require.NoError(t, pullService.repackGit(false))
+	require.Equal(t, packs, countPacks(t, pullService.cacheDirectory.GitPath()))
+
+	require.NoError(t, pullService.repackGit(true))
+	require.Equal(t, 1, countPacks(t, pullService.cacheDirectory.GitPath()))
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"33d42021633d74bcd0bf9c95e3d3159131a5faa7 refs/heads/v3",
+		"42d077b4730d1ba413f7bb7e0fa7c98653fb0c78 refs/heads/v4",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning/because-it-now-has-this-extra-bit",
+	})
+	// The repacked cache can still be updated.
+	require.NoError(t, pullService.pullGit(false))
+	relevantReleases, err := pullService.findRelevantReleases()
+	require.NoError(t, err)
+	require.NotEmpty(t, relevantReleases)
+}
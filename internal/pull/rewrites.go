@@ -0,0 +1,200 @@
+package pull
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/actionconfiguration"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const warningRewrittenReferences = "These Git references of the source have been rewritten in a way that changes releases already in the cache. Check that this is expected before pushing, or run with `--strict` to stop the pull instead:\n%s"
+const errorRewrittenReferences = "The pull was stopped, as these Git references of the source have been rewritten in a way that changes releases already in the cache:\n%s\nThe references have been left as they were in the cache. If the rewrites are expected, run again without `--strict` to pull them."
+
+// rewrittenReferencesError is returned with `--strict` when the Git fetch has rewritten the history of releases already in the cache. Like unverifiedReferencesError, pulling again from scratch would not help.
+type rewrittenReferencesError struct {
+	rewrites []string
+}
+
+func (err *rewrittenReferencesError) Error() string {
+	return fmt.Sprintf(errorRewrittenReferences, strings.Join(err.rewrites, "\n"))
+}
+
+// stopsPull returns whether an error from the Git fetch was raised on purpose to stop the pull, rather than being one that pulling again from scratch could get past.
+func stopsPull(err error) bool {
+	switch errors.Cause(err).(type) {
+	case *unverifiedReferencesError, *rewrittenReferencesError:
+		return true
+	}
+	return false
+}
+
+// isReleaseTag returns whether a reference is a tag of a single release, such as `v2.1.0`. Tags of major versions, such as `v2`, are moved with every release just like the major version branches.
+func isReleaseTag(name plumbing.ReferenceName) bool {
+	return name.IsTag() && !actionconfiguration.IsRelevantReference(name)
+}
+
+// peelCommit returns the commit that a commit or annotated tag points at, or false if it is not in the repository, as happens beyond the history of a shallow cache.
+func peelCommit(gitRepository *git.Repository, hash plumbing.Hash) (plumbing.Hash, bool, error) {
+	for {
+		encoded, err := gitRepository.Storer.EncodedObject(plumbing.AnyObject, hash)
+		if err == plumbing.ErrObjectNotFound {
+			return plumbing.ZeroHash, false, nil
+		}
+		if err != nil {
+			return plumbing.ZeroHash, false, errors.Wrapf(err, "Error reading Git object %s.", hash)
+		}
+		switch encoded.Type() {
+		case plumbing.CommitObject:
+			return hash, true, nil
+		case plumbing.TagObject:
+			tag, err := object.DecodeTag(gitRepository.Storer, encoded)
+			if err != nil {
+				return plumbing.ZeroHash, false, errors.Wrapf(err, "Error reading Git tag %s.", hash)
+			}
+			hash = tag.Target
+		default:
+			return plumbing.ZeroHash, false, nil
+		}
+	}
+}
+
+// ancestors adds the commits in the history of a commit or annotated tag to seen, and returns those that were not already in it.
+func ancestors(gitRepository *git.Repository, from plumbing.Hash, seen map[plumbing.Hash]bool) (map[plumbing.Hash]bool, error) {
+	added := map[plumbing.Hash]bool{}
+	start, ok, err := peelCommit(gitRepository, from)
+	if err != nil || !ok {
+		return added, err
+	}
+	pending := []plumbing.Hash{start}
+	for len(pending) != 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[hash] {
+			continue
+		}
+		commit, err := gitRepository.CommitObject(hash)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading Git commit %s.", hash)
+		}
+		seen[hash] = true
+		added[hash] = true
+		pending = append(pending, commit.ParentHashes...)
+	}
+	return added, nil
+}
+
+// checkRewrites compares the references in the cache from before the pull with those just fetched, and finds any rewrites that affect releases already in the cache: a release tag that has been moved or deleted at the source, or a branch or major version tag that has been force-pushed so that a release tag it used to contain is no longer in the history of any branch. Force-pushes that do not orphan any release tags are expected. The rewrites are logged as a warning, or with `--strict` the references involved are put back as they were and the pull is stopped. The objects from before the pull are still in the cache, as it is not repacked until after the fetch.
+func (pullService *pullService) checkRewrites(gitPull *gitPull) error {
+	references, err := gitPull.repository.References()
+	if err != nil {
+		return errors.Wrap(err, "Error listing local references.")
+	}
+	current := map[plumbing.ReferenceName]plumbing.Hash{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference {
+			current[reference.Name()] = reference.Hash()
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error listing local references.")
+	}
+	remote := map[plumbing.ReferenceName]bool{}
+	for _, reference := range gitPull.remoteReferences {
+		remote[reference.Name()] = true
+	}
+
+	rewrites := map[plumbing.ReferenceName]string{}
+	// releaseTags maps the commit each release tag pointed at before the pull to the tags pointing at it.
+	releaseTags := map[plumbing.Hash][]string{}
+	for previousName, previousHash := range gitPull.previousReferences {
+		name := plumbing.ReferenceName(previousName)
+		if !isReleaseTag(name) {
+			continue
+		}
+		hash, ok := current[name]
+		if !ok && !remote[name] {
+			rewrites[name] = "deleted from the source"
+			continue
+		}
+		if ok && hash.String() != previousHash {
+			rewrites[name] = fmt.Sprintf("moved from %s to %s", previousHash, hash)
+			continue
+		}
+		commit, ok, err := peelCommit(gitPull.repository, plumbing.NewHash(previousHash))
+		if err != nil {
+			return err
+		}
+		if ok {
+			releaseTags[commit] = append(releaseTags[commit], name.Short())
+		}
+	}
+
+	changed := []string{}
+	for previousName, previousHash := range gitPull.previousReferences {
+		name := plumbing.ReferenceName(previousName)
+		hash, ok := current[name]
+		if (name.IsBranch() || name.IsTag()) && !isReleaseTag(name) && ok && hash.String() != previousHash {
+			changed = append(changed, previousName)
+		}
+	}
+	// The history of a shallow cache is missing the commits between what was pulled before and what has been pulled now, so release tags would look orphaned when they are not.
+	if len(releaseTags) != 0 && len(changed) != 0 && pullService.gitDepth == 0 {
+		// A release tag is orphaned if its commit used to be in the history of a branch or major version tag, but is no longer in the history of any of them.
+		seen := map[plumbing.Hash]bool{}
+		for name, hash := range current {
+			if (name.IsBranch() || name.IsTag()) && !isReleaseTag(name) {
+				_, err := ancestors(gitPull.repository, hash, seen)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		sort.Strings(changed)
+		for _, previousName := range changed {
+			name := plumbing.ReferenceName(previousName)
+			previousHash := gitPull.previousReferences[previousName]
+			dropped, err := ancestors(gitPull.repository, plumbing.NewHash(previousHash), seen)
+			if err != nil {
+				return err
+			}
+			tags := []string{}
+			for commit := range dropped {
+				tags = append(tags, releaseTags[commit]...)
+			}
+			if len(tags) != 0 {
+				sort.Strings(tags)
+				rewrites[name] = fmt.Sprintf("rewritten from %s to %s, so %s is no longer in the history of any branch", previousHash, current[name], strings.Join(tags, ", "))
+			}
+		}
+	}
+	if len(rewrites) == 0 {
+		return nil
+	}
+
+	lines := []string{}
+	names := []plumbing.ReferenceName{}
+	for name, rewrite := range rewrites {
+		names = append(names, name)
+		lines = append(lines, fmt.Sprintf("  %s: %s", name, rewrite))
+	}
+	sort.Strings(lines)
+	if !pullService.strict {
+		log.Warnf(warningRewrittenReferences, strings.Join(lines, "\n"))
+		return nil
+	}
+	err = gitPull.restoreReferences(names)
+	if err != nil {
+		return err
+	}
+	return &rewrittenReferencesError{rewrites: lines}
+}
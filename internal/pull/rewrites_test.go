@@ -0,0 +1,90 @@
+package pull
+
+import (
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func tagSource(t *testing.T, repository *git.Repository, tag string, hash plumbing.Hash) {
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName(tag), hash)))
+}
+
+func TestPullGitWarnsOfOrphanedReleaseTags(t *testing.T) {
+	sourcePath := path.Join(test.CreateTemporaryDirectory(t), "source")
+	source, err := git.PlainInit(sourcePath, true)
+	require.NoError(t, err)
+	first := commitToSource(t, source, "main", "The first commit.\n", nil)
+	second := commitToSource(t, source, "main", "The second commit.\n", nil, first)
+	tagSource(t, source, "v1.0.0", first)
+	pullService := getTestPullService(t, test.CreateTemporaryDirectory(t), sourcePath, "")
+	require.NoError(t, pullService.pullGit(true))
+
+	// Moving on from the release is expected, but dropping it from the history is not.
+	third := commitToSource(t, source, "main", "The third commit.\n", nil, second)
+	recorder := report.NewRecorder()
+	hooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	defer log.StandardLogger().ReplaceHooks(hooks)
+	log.AddHook(recorder)
+	require.NoError(t, pullService.pullGit(false))
+	require.Empty(t, recorder.Document(notify.Summary{}).Warnings)
+
+	rewritten := commitToSource(t, source, "main", "A rewritten commit.\n", nil)
+	require.NoError(t, pullService.pullGit(false))
+	warnings := recorder.Document(notify.Summary{}).Warnings
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], "  refs/heads/main: rewritten from "+third.String()+" to "+rewritten.String()+", so v1.0.0 is no longer in the history of any branch")
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{
+		rewritten.String() + " refs/heads/main",
+		first.String() + " refs/tags/v1.0.0",
+	})
+}
+
+func TestPullGitStrictStopsOnRewrites(t *testing.T) {
+	sourcePath := path.Join(test.CreateTemporaryDirectory(t), "source")
+	source, err := git.PlainInit(sourcePath, true)
+	require.NoError(t, err)
+	first := commitToSource(t, source, "main", "The first commit.\n", nil)
+	second := commitToSource(t, source, "main", "The second commit.\n", nil, first)
+	commitToSource(t, source, "feature", "A feature.\n", nil)
+	tagSource(t, source, "v1.0.0", first)
+	tagSource(t, source, "v1.0.1", second)
+	tagSource(t, source, "v1.0.2", first)
+	tagSource(t, source, "v1", second)
+	pullService := getTestPullService(t, test.CreateTemporaryDirectory(t), sourcePath, "")
+	pullService.strict = true
+	require.NoError(t, pullService.pullGit(true))
+
+	rewritten := commitToSource(t, source, "main", "A rewritten commit.\n", nil)
+	// Force-pushing a branch or moving a major version tag is expected as long as no release tags are orphaned.
+	feature := commitToSource(t, source, "feature", "A rewritten feature.\n", nil)
+	tagSource(t, source, "v1", rewritten)
+	tagSource(t, source, "v1.0.1", rewritten)
+	require.NoError(t, source.Storer.RemoveReference(plumbing.NewTagReferenceName("v1.0.2")))
+	err = pullService.pullGit(false)
+	rewrites, ok := errors.Cause(err).(*rewrittenReferencesError)
+	require.True(t, ok, "Expected a rewritten references error, got %v.", err)
+	require.Equal(t, []string{
+		"  refs/heads/main: rewritten from " + second.String() + " to " + rewritten.String() + ", so v1.0.0 is no longer in the history of any branch",
+		"  refs/tags/v1.0.1: moved from " + second.String() + " to " + rewritten.String(),
+		"  refs/tags/v1.0.2: deleted from the source",
+	}, rewrites.rewrites)
+	require.True(t, stopsPull(err))
+	// Only the references involved in the rewrites are put back.
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{
+		feature.String() + " refs/heads/feature",
+		second.String() + " refs/heads/main",
+		rewritten.String() + " refs/tags/v1",
+		first.String() + " refs/tags/v1.0.0",
+		second.String() + " refs/tags/v1.0.1",
+		first.String() + " refs/tags/v1.0.2",
+	})
+}
@@ -0,0 +1,92 @@
+package pull
+
+import (
+	usererrors "errors"
+	"fmt"
+	"net/http"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/concurrency"
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+)
+
+// segmentedDownloadThreshold is the size from which assets are downloaded in segments when `--download-segments` is given. Smaller assets download quickly enough over one connection that the extra requests are not worth making.
+var segmentedDownloadThreshold int64 = 64 * 1024 * 1024
+
+var errRangesNotSupported = usererrors.New("The server does not support downloading part of an asset.")
+
+// downloadsInSegments returns whether an asset should be downloaded in segments. Only assets on a GitHub instance can be, and only into a cache which can reassemble them.
+func (pullService *pullService) downloadsInSegments(asset *github.ReleaseAsset) bool {
+	return pullService.concurrency.DownloadSegments > 1 &&
+		pullService.sourceDirectory == "" &&
+		pullService.cacheDirectory.SupportsPartialAssets() &&
+		githubapiutil.AssetSize(asset) >= segmentedDownloadThreshold
+}
+
+// downloadSegment downloads length bytes of an asset from the given offset and writes them into place in the segmented asset.
+func (pullService *pullService) downloadSegment(downloadURL string, segmentedAsset *cachedirectory.SegmentedAsset, name string, offset int64, length int64) error {
+	request, err := http.NewRequestWithContext(pullService.ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "Error downloading asset.")
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	response, err := retry.NewClient(nil).Do(request)
+	if err != nil {
+		return errors.Wrap(err, "Error downloading asset.")
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return &retry.StatusError{StatusCode: response.StatusCode, Activity: "downloading asset"}
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		return errRangesNotSupported
+	}
+	progressReader := pullService.downloadProgress.Track(name, response.Body, length, 0)
+	defer progressReader.Close()
+	err = segmentedAsset.WriteSegment(offset, length, progressReader)
+	if err != nil {
+		return errors.Wrap(err, "Error downloading asset.")
+	}
+	return nil
+}
+
+// downloadAssetInSegments downloads an asset over several connections at the same time, each requesting a different range of it, and reassembles it in the cache. If the server does not support ranges errRangesNotSupported is returned, so that the asset can be downloaded over a single connection instead.
+func (pullService *pullService) downloadAssetInSegments(releaseTag string, asset *github.ReleaseAsset) error {
+	reader, redirectURL, err := pullService.githubDotComClient.Repositories.DownloadReleaseAsset(pullService.ctx, pullService.sourceOwner, pullService.sourceRepository, asset.GetID(), nil)
+	if err != nil {
+		return errors.Wrap(err, "Error downloading asset.")
+	}
+	if reader != nil {
+		// The asset was served directly rather than via a redirect, so we have no URL to request ranges of it from.
+		reader.Close()
+		return errRangesNotSupported
+	}
+
+	size := githubapiutil.AssetSize(asset)
+	segments := pullService.concurrency.DownloadSegments
+	segmentSize := (size + int64(segments) - 1) / int64(segments)
+	segmentedAsset, err := pullService.cacheDirectory.CreateSegmentedAsset(releaseTag, asset.GetName(), size)
+	if err != nil {
+		return err
+	}
+	defer segmentedAsset.Close()
+	err = concurrency.ForEach(segments, segments, func(index int) error {
+		offset := int64(index) * segmentSize
+		length := segmentSize
+		if offset+length > size {
+			length = size - offset
+		}
+		if length <= 0 {
+			return nil
+		}
+		name := fmt.Sprintf("%s (%d/%d)", asset.GetName(), index+1, segments)
+		return pullService.downloadSegment(redirectURL, segmentedAsset, name, offset, length)
+	})
+	if err != nil {
+		return err
+	}
+	return segmentedAsset.Complete()
+}
@@ -0,0 +1,210 @@
+package pull
+
+import (
+	"bytes"
+	"context"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+)
+
+// webFlowKeyURL is where GitHub.com publishes the key it signs commits made on the web and by GitHub Actions with, including the merges and releases of the CodeQL Action.
+const webFlowKeyURL = DefaultSourceURL + "/web-flow.gpg"
+
+const errorUnreadableSigningKey = "The signing key %s could not be read as an armored OpenPGP public key: %s"
+const errorVerifySignaturesWithoutKeys = "The `--verify-signatures` flag needs the keys to trust to be given with `--signing-keys`, as only GitHub.com has a signing key the sync tool can download."
+const errorUnverifiedReferences = "The pull was stopped, as these Git references of the source are not signed with a trusted key:\n%s\nThe references have been left as they were in the cache. If their history has not been tampered with, add the key they are signed with to `--signing-keys`, or leave them out with `--exclude-refs`."
+
+// unverifiedReferencesError is returned when the history pulled for some references is not signed with a trusted key. Unlike other errors during the Git fetch, pulling again from scratch would not help.
+type unverifiedReferencesError struct {
+	failures []string
+}
+
+func (err *unverifiedReferencesError) Error() string {
+	return fmt.Sprintf(errorUnverifiedReferences, strings.Join(err.failures, "\n"))
+}
+
+func readSigningKeys(name string, reader io.Reader) (openpgp.EntityList, error) {
+	keys, err := openpgp.ReadArmoredKeyRing(reader)
+	if err != nil {
+		return nil, fmt.Errorf(errorUnreadableSigningKey, name, err)
+	}
+	return keys, nil
+}
+
+// loadSigningKeys returns the keys that commits and tags pulled with `--verify-signatures` must be signed with. These are read from the files given with `--signing-keys`, or if there are none the web-flow key of GitHub.com is downloaded.
+func loadSigningKeys(ctx context.Context, source Source, files []string) (openpgp.EntityList, error) {
+	keys := openpgp.EntityList{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf(errorUnreadableSigningKey, file, "the file does not exist")
+			}
+			return nil, errors.Wrap(err, "Error reading signing key.")
+		}
+		fileKeys, err := readSigningKeys(file, bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(files) != 0 {
+		return keys, nil
+	}
+	if source.Directory != "" || source.APIURL != "" {
+		return nil, usererrors.New(errorVerifySignaturesWithoutKeys)
+	}
+	log.Debugf("Downloading the signing key of GitHub.com from %s...", webFlowKeyURL)
+	var content []byte
+	err := retry.Do(ctx, "downloading the signing key of GitHub.com", func(attempt int) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, webFlowKeyURL, nil)
+		if err != nil {
+			return err
+		}
+		response, err := retry.NewClient(nil).Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("Unexpected status code %d.", response.StatusCode)
+		}
+		content, err = ioutil.ReadAll(response.Body)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error downloading the signing key of GitHub.com.")
+	}
+	return readSigningKeys(webFlowKeyURL, bytes.NewReader(content))
+}
+
+func checkSignature(keys openpgp.EntityList, encode func(plumbing.EncodedObject) error, signature string) (*openpgp.Entity, error) {
+	encoded := &plumbing.MemoryObject{}
+	err := encode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return openpgp.CheckArmoredDetachedSignature(keys, reader, strings.NewReader(signature))
+}
+
+// verifyObject checks that a commit, or an annotated tag, is signed with one of the signing keys. An annotated tag without a signature is trusted if the commit it points at is. The commit's hash covers its whole history, so checking the commit a reference points at checks everything pulled for it.
+func (pullService *pullService) verifyObject(gitRepository *git.Repository, hash plumbing.Hash) error {
+	if pullService.verified[hash] {
+		return nil
+	}
+	encoded, err := gitRepository.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading Git object %s.", hash)
+	}
+	var signer *openpgp.Entity
+	switch encoded.Type() {
+	case plumbing.CommitObject:
+		commit, err := object.DecodeCommit(gitRepository.Storer, encoded)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading Git commit %s.", hash)
+		}
+		if commit.PGPSignature == "" {
+			return fmt.Errorf("commit %s is not signed", hash)
+		}
+		signer, err = checkSignature(pullService.signingKeys, commit.EncodeWithoutSignature, commit.PGPSignature)
+		if err != nil {
+			return fmt.Errorf("commit %s has a signature that could not be verified: %s", hash, err)
+		}
+	case plumbing.TagObject:
+		tag, err := object.DecodeTag(gitRepository.Storer, encoded)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading Git tag %s.", hash)
+		}
+		if tag.PGPSignature == "" {
+			return pullService.verifyObject(gitRepository, tag.Target)
+		}
+		signer, err = checkSignature(pullService.signingKeys, tag.EncodeWithoutSignature, tag.PGPSignature)
+		if err != nil {
+			return fmt.Errorf("tag %s has a signature that could not be verified: %s", hash, err)
+		}
+	default:
+		return fmt.Errorf("%s is a %s, which cannot be signed", hash, encoded.Type())
+	}
+	log.Debugf("Git object %s is signed with key %s.", hash, signer.PrimaryKey.KeyIdString())
+	pullService.verified[hash] = true
+	return nil
+}
+
+// verifyReferences checks the signatures of the branches and tags changed by the Git fetch so far, for which include returns true. Any that fail are put back as they were before the pull, so that unverified history is never left in the cache for a push to pick up.
+func (pullService *pullService) verifyReferences(gitPull *gitPull, include func(plumbing.ReferenceName) bool) error {
+	if pullService.signingKeys == nil {
+		return nil
+	}
+	references, err := gitPull.repository.References()
+	if err != nil {
+		return errors.Wrap(err, "Error listing local references.")
+	}
+	failed := map[plumbing.ReferenceName]string{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		name := reference.Name()
+		if reference.Type() != plumbing.HashReference || !(name.IsBranch() || name.IsTag()) || (include != nil && !include(name)) {
+			return nil
+		}
+		if gitPull.previousReferences[name.String()] == reference.Hash().String() {
+			return nil
+		}
+		err := pullService.verifyObject(gitPull.repository, reference.Hash())
+		if err != nil {
+			failed[name] = err.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	lines := []string{}
+	names := []plumbing.ReferenceName{}
+	for name, reason := range failed {
+		names = append(names, name)
+		lines = append(lines, fmt.Sprintf("  %s: %s", name, reason))
+	}
+	err = gitPull.restoreReferences(names)
+	if err != nil {
+		return err
+	}
+	sort.Strings(lines)
+	return &unverifiedReferencesError{failures: lines}
+}
+
+// restoreReferences puts references back as they were before the pull, removing any that did not exist.
+func (gitPull *gitPull) restoreReferences(names []plumbing.ReferenceName) error {
+	for _, name := range names {
+		var err error
+		if previous, ok := gitPull.previousReferences[name.String()]; ok {
+			err = gitPull.repository.Storer.SetReference(plumbing.NewHashReference(name, plumbing.NewHash(previous)))
+		} else {
+			err = gitPull.repository.Storer.RemoveReference(name)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Error restoring reference %s.", name)
+		}
+	}
+	return nil
+}
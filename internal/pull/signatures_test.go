@@ -0,0 +1,163 @@
+package pull
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func createSigningKey(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", &packet.Config{RSABits: 1024})
+	require.NoError(t, err)
+	return entity
+}
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) []byte {
+	key := bytes.Buffer{}
+	writer, err := armor.Encode(&key, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(writer))
+	require.NoError(t, writer.Close())
+	return key.Bytes()
+}
+
+func sign(t *testing.T, entity *openpgp.Entity, encode func(plumbing.EncodedObject) error) string {
+	encoded := &plumbing.MemoryObject{}
+	require.NoError(t, encode(encoded))
+	reader, err := encoded.Reader()
+	require.NoError(t, err)
+	signature := bytes.Buffer{}
+	require.NoError(t, openpgp.ArmoredDetachSign(&signature, entity, reader, nil))
+	return signature.String()
+}
+
+// commitToSource adds a commit with the given parents to a branch of the source repository, signed with the given key unless it is nil.
+func commitToSource(t *testing.T, repository *git.Repository, branch string, message string, entity *openpgp.Entity, parents ...plumbing.Hash) plumbing.Hash {
+	signature := object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0).UTC()}
+	tree := repository.Storer.NewEncodedObject()
+	require.NoError(t, (&object.Tree{}).Encode(tree))
+	treeHash, err := repository.Storer.SetEncodedObject(tree)
+	require.NoError(t, err)
+	commit := &object.Commit{Author: signature, Committer: signature, Message: message, TreeHash: treeHash, ParentHashes: parents}
+	if entity != nil {
+		commit.PGPSignature = sign(t, entity, commit.EncodeWithoutSignature)
+	}
+	encoded := repository.Storer.NewEncodedObject()
+	require.NoError(t, commit.Encode(encoded))
+	hash, err := repository.Storer.SetEncodedObject(encoded)
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), hash)))
+	return hash
+}
+
+func getTestVerifyingPullService(t *testing.T, sourcePath string, entity *openpgp.Entity) pullService {
+	pullService := getTestPullService(t, test.CreateTemporaryDirectory(t), sourcePath, "")
+	pullService.signingKeys = openpgp.EntityList{entity}
+	pullService.verified = map[plumbing.Hash]bool{}
+	return pullService
+}
+
+func TestPullGitVerifiesSignatures(t *testing.T) {
+	entity := createSigningKey(t)
+	sourcePath := path.Join(test.CreateTemporaryDirectory(t), "source")
+	source, err := git.PlainInit(sourcePath, true)
+	require.NoError(t, err)
+	main := commitToSource(t, source, "main", "A signed commit.\n", entity)
+	commitToSource(t, source, "unsigned", "An unsigned commit.\n", nil)
+	commitToSource(t, source, "other-key", "A commit signed with another key.\n", createSigningKey(t))
+
+	pullService := getTestVerifyingPullService(t, sourcePath, entity)
+	err = pullService.pullGit(true)
+	unverified, ok := errors.Cause(err).(*unverifiedReferencesError)
+	require.True(t, ok, "Expected an unverified references error, got %v.", err)
+	require.Len(t, unverified.failures, 2)
+	require.Contains(t, unverified.failures[0], "refs/heads/other-key: commit")
+	require.Contains(t, unverified.failures[1], "refs/heads/unsigned: commit")
+	require.Contains(t, unverified.failures[1], "is not signed")
+	// Only the verified branch is left in the cache.
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{main.String() + " refs/heads/main"})
+}
+
+func TestPullGitRestoresUnverifiedReferences(t *testing.T) {
+	entity := createSigningKey(t)
+	sourcePath := path.Join(test.CreateTemporaryDirectory(t), "source")
+	source, err := git.PlainInit(sourcePath, true)
+	require.NoError(t, err)
+	signed := commitToSource(t, source, "main", "A signed commit.\n", entity)
+	pullService := getTestVerifyingPullService(t, sourcePath, entity)
+	require.NoError(t, pullService.pullGit(true))
+
+	// The branch used to find the releases is checked before anything else is pulled, and is put back as it was.
+	commitToSource(t, source, "main", "An unsigned commit.\n", nil)
+	err = pullService.pullGit(false)
+	_, ok := errors.Cause(err).(*unverifiedReferencesError)
+	require.True(t, ok, "Expected an unverified references error, got %v.", err)
+	test.CheckExpectedReferencesInRepository(t, pullService.cacheDirectory.GitPath(), []string{signed.String() + " refs/heads/main"})
+}
+
+func TestPullGitVerifiesTags(t *testing.T) {
+	entity := createSigningKey(t)
+	sourcePath := path.Join(test.CreateTemporaryDirectory(t), "source")
+	source, err := git.PlainInit(sourcePath, true)
+	require.NoError(t, err)
+	signed := commitToSource(t, source, "main", "A signed commit.\n", entity)
+	unsigned := commitToSource(t, source, "unsigned", "An unsigned commit.\n", nil)
+	require.NoError(t, source.Storer.RemoveReference("refs/heads/unsigned"))
+	tagger := object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0).UTC()}
+	for name, tag := range map[string]*object.Tag{
+		// A lightweight tag has its commit checked, and so does an annotated tag without a signature. A signed tag is trusted even if its commit is not signed.
+		"v1": nil,
+		"v2": {Name: "v2", Tagger: tagger, Message: "An unsigned tag.\n", TargetType: plumbing.CommitObject, Target: signed},
+		"v3": {Name: "v3", Tagger: tagger, Message: "A signed tag.\n", TargetType: plumbing.CommitObject, Target: unsigned},
+	} {
+		hash := signed
+		if tag != nil {
+			if tag.Target == unsigned {
+				tag.PGPSignature = sign(t, entity, tag.EncodeWithoutSignature)
+			}
+			encoded := source.Storer.NewEncodedObject()
+			require.NoError(t, tag.Encode(encoded))
+			hash, err = source.Storer.SetEncodedObject(encoded)
+			require.NoError(t, err)
+		}
+		require.NoError(t, source.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName(name), hash)))
+	}
+
+	pullService := getTestVerifyingPullService(t, sourcePath, entity)
+	require.NoError(t, pullService.pullGit(true))
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	entity := createSigningKey(t)
+	keyPath := path.Join(test.CreateTemporaryDirectory(t), "key.asc")
+	require.NoError(t, ioutil.WriteFile(keyPath, armoredPublicKey(t, entity), 0644))
+	keys, err := loadSigningKeys(context.Background(), Source{Directory: "source"}, []string{keyPath})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, entity.PrimaryKey.KeyId, keys[0].PrimaryKey.KeyId)
+
+	notKeyPath := path.Join(test.CreateTemporaryDirectory(t), "key.asc")
+	require.NoError(t, ioutil.WriteFile(notKeyPath, []byte("not a key"), 0644))
+	_, err = loadSigningKeys(context.Background(), Source{}, []string{notKeyPath})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not be read as an armored OpenPGP public key")
+
+	// Only GitHub.com has a key that can be downloaded.
+	_, err = loadSigningKeys(context.Background(), Source{Directory: "source"}, nil)
+	require.EqualError(t, err, errorVerifySignaturesWithoutKeys)
+	_, err = loadSigningKeys(context.Background(), Source{APIURL: "https://ghe.example.com/api/v3"}, nil)
+	require.EqualError(t, err, errorVerifySignaturesWithoutKeys)
+}
@@ -0,0 +1,138 @@
+package push
+
+import (
+	usererrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/audit"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ActionsPolicyOrganization = "organization"
+	ActionsPolicyEnterprise   = "enterprise"
+)
+
+// ActionsPolicy says which Actions policy to allow the destination repository in after pushing, so that workflows can use it straight away when only selected actions are allowed.
+type ActionsPolicy struct {
+	// Level is ActionsPolicyOrganization, ActionsPolicyEnterprise, or empty to leave the policies unchanged.
+	Level string
+	// Enterprise is the slug of the enterprise whose policy is updated when Level is ActionsPolicyEnterprise.
+	Enterprise string
+}
+
+// actionsPermissions is the part of the Actions permissions of an organization or enterprise that says which actions may be used. The version of the GitHub API client in use does not support these endpoints yet.
+type actionsPermissions struct {
+	AllowedActions string `json:"allowed_actions"`
+}
+
+// selectedActions are the actions that may be used when only selected actions are allowed. Fields that GitHub Enterprise Server does not return are left out when the policy is updated, so they are not changed.
+type selectedActions struct {
+	GithubOwnedAllowed *bool    `json:"github_owned_allowed,omitempty"`
+	VerifiedAllowed    *bool    `json:"verified_allowed,omitempty"`
+	PatternsAllowed    []string `json:"patterns_allowed"`
+}
+
+func checkActionsPolicy(policy ActionsPolicy) error {
+	switch policy.Level {
+	case "", ActionsPolicyOrganization:
+		return nil
+	case ActionsPolicyEnterprise:
+		if policy.Enterprise == "" {
+			return usererrors.New("`--enterprise` must be given to update the Actions policy of the enterprise.")
+		}
+		return nil
+	}
+	return fmt.Errorf("The Actions policy %s given with `--update-actions-policy` is not one of `%s` or `%s`.", policy.Level, ActionsPolicyOrganization, ActionsPolicyEnterprise)
+}
+
+// scope returns the token scope needed to update the Actions policy.
+func (policy ActionsPolicy) scope() string {
+	if policy.Level == ActionsPolicyEnterprise {
+		return "admin:enterprise"
+	}
+	return "admin:org"
+}
+
+// actionsPolicyTarget returns the organization or enterprise whose Actions policy is updated, its API path, and a name for it to show.
+func (pushService *pushService) actionsPolicyTarget() (string, string, string) {
+	if pushService.actionsPolicy.Level == ActionsPolicyEnterprise {
+		enterprise := pushService.actionsPolicy.Enterprise
+		return enterprise, "enterprises/" + enterprise, "the enterprise " + enterprise
+	}
+	organization := pushService.destinationRepositoryOwner
+	return organization, "orgs/" + organization, "the organization " + organization
+}
+
+// allowsRepository returns whether any of the patterns already allows every action in the destination repository.
+func allowsRepository(patterns []string, repository string) bool {
+	owner := strings.SplitN(repository, "/", 2)[0]
+	for _, pattern := range patterns {
+		switch strings.ToLower(strings.TrimSpace(pattern)) {
+		case "*", strings.ToLower(owner) + "/*", strings.ToLower(repository) + "@*", strings.ToLower(repository) + "/*":
+			return true
+		}
+	}
+	return false
+}
+
+// updateActionsPolicy adds the destination repository to the actions allowed by the Actions policy of its organization or the enterprise. Nothing is changed if the policy allows all actions, or only those defined in the enterprise, as these already include the destination repository.
+func (pushService *pushService) updateActionsPolicy() error {
+	if pushService.actionsPolicy.Level == "" {
+		return nil
+	}
+	target, path, name := pushService.actionsPolicyTarget()
+	log.Debugf("Checking the Actions policy of %s...", name)
+	permissions := actionsPermissions{}
+	response, err := pushService.actionsPolicyRequest(http.MethodGet, path+"/actions/permissions", nil, &permissions)
+	if err != nil {
+		return pushService.actionsPolicyError(response, err, name)
+	}
+	if permissions.AllowedActions != "selected" {
+		log.Debugf("The Actions policy of %s allows %s actions, so %s does not need to be added to it.", name, permissions.AllowedActions, pushService.destinationRepository())
+		return nil
+	}
+	selected := selectedActions{}
+	response, err = pushService.actionsPolicyRequest(http.MethodGet, path+"/actions/permissions/selected-actions", nil, &selected)
+	if err != nil {
+		return pushService.actionsPolicyError(response, err, name)
+	}
+	if allowsRepository(selected.PatternsAllowed, pushService.destinationRepository()) {
+		log.Debugf("The Actions policy of %s already allows %s.", name, pushService.destinationRepository())
+		return nil
+	}
+	pattern := pushService.destinationRepository() + "@*"
+	log.Infof("Allowing %s in the Actions policy of %s...", pattern, name)
+	selected.PatternsAllowed = append(selected.PatternsAllowed, pattern)
+	response, err = pushService.actionsPolicyRequest(http.MethodPut, path+"/actions/permissions/selected-actions", selected, nil)
+	if err != nil {
+		return pushService.actionsPolicyError(response, err, name)
+	}
+	return pushService.audit(audit.ActionUpdateActionsPolicy, target, map[string]string{"level": pushService.actionsPolicy.Level, "pattern": pattern})
+}
+
+func (pushService *pushService) actionsPolicyRequest(method string, path string, body interface{}, result interface{}) (*github.Response, error) {
+	var response *github.Response
+	err := retry.Do(pushService.ctx, "updating Actions policy", func(attempt int) error {
+		request, err := pushService.githubEnterpriseClient.NewRequest(method, path, body)
+		if err != nil {
+			return err
+		}
+		response, err = pushService.githubEnterpriseClient.Do(pushService.ctx, request, result)
+		return err
+	})
+	return response, err
+}
+
+func (pushService *pushService) actionsPolicyError(response *github.Response, err error, name string) error {
+	if response != nil && (response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusNotFound) {
+		return exitcode.WithCode(fmt.Errorf("You don't have permission to update the Actions policy of %s, so workflows may not be able to use %s until it is allowed there. Use a token with the `%s` scope, or allow it in the policy yourself.", name, pushService.destinationRepository(), pushService.actionsPolicy.scope()), exitcode.Authentication)
+	}
+	return errors.Wrap(err, "Error updating Actions policy.")
+}
@@ -0,0 +1,72 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckActionsPolicy(t *testing.T) {
+	require.NoError(t, checkActionsPolicy(ActionsPolicy{}))
+	require.NoError(t, checkActionsPolicy(ActionsPolicy{Level: ActionsPolicyOrganization}))
+	require.NoError(t, checkActionsPolicy(ActionsPolicy{Level: ActionsPolicyEnterprise, Enterprise: "octocorp"}))
+	require.EqualError(t, checkActionsPolicy(ActionsPolicy{Level: ActionsPolicyEnterprise}), "`--enterprise` must be given to update the Actions policy of the enterprise.")
+	require.EqualError(t, checkActionsPolicy(ActionsPolicy{Level: "repository"}), "The Actions policy repository given with `--update-actions-policy` is not one of `organization` or `enterprise`.")
+}
+
+func TestAllowsRepository(t *testing.T) {
+	require.True(t, allowsRepository([]string{"*"}, "github/codeql-action"))
+	require.True(t, allowsRepository([]string{"actions/*", "github/*"}, "github/codeql-action"))
+	require.True(t, allowsRepository([]string{"GitHub/CodeQL-Action@*"}, "github/codeql-action"))
+	require.False(t, allowsRepository([]string{"github/codeql-action@v1"}, "github/codeql-action"))
+	require.False(t, allowsRepository([]string{}, "github/codeql-action"))
+}
+
+func TestUpdateActionsPolicy(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, test.CreateTemporaryDirectory(t), githubEnterpriseURL)
+	allowedActions := "all"
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner/actions/permissions", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, actionsPermissions{AllowedActions: allowedActions}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner/actions/permissions/selected-actions", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, selectedActions{PatternsAllowed: []string{"actions/*"}}, response)
+	}).Methods("GET")
+	updates := []selectedActions{}
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner/actions/permissions/selected-actions", func(response http.ResponseWriter, request *http.Request) {
+		update := selectedActions{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&update))
+		updates = append(updates, update)
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("PUT")
+
+	// Nothing is changed unless asked to.
+	require.NoError(t, pushService.updateActionsPolicy())
+
+	pushService.actionsPolicy = ActionsPolicy{Level: ActionsPolicyOrganization}
+	require.NoError(t, pushService.updateActionsPolicy())
+	require.Empty(t, updates)
+
+	allowedActions = "selected"
+	require.NoError(t, pushService.updateActionsPolicy())
+	require.Len(t, updates, 1)
+	require.Equal(t, []string{"actions/*", "destination-repository-owner/destination-repository-name@*"}, updates[0].PatternsAllowed)
+	require.Nil(t, updates[0].GithubOwnedAllowed)
+}
+
+func TestUpdateEnterpriseActionsPolicyWithoutPermission(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, test.CreateTemporaryDirectory(t), githubEnterpriseURL)
+	pushService.actionsPolicy = ActionsPolicy{Level: ActionsPolicyEnterprise, Enterprise: "octocorp"}
+	githubTestServer.HandleFunc("/api/v3/enterprises/octocorp/actions/permissions", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusForbidden)
+	}).Methods("GET")
+
+	err := pushService.updateActionsPolicy()
+	require.EqualError(t, err, "You don't have permission to update the Actions policy of the enterprise octocorp, so workflows may not be able to use destination-repository-owner/destination-repository-name until it is allowed there. Use a token with the `admin:enterprise` scope, or allow it in the policy yourself.")
+	require.Equal(t, exitcode.Authentication, exitcode.Code(err))
+}
@@ -0,0 +1,262 @@
+package push
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+)
+
+// attestationAssetName is the release asset the provenance attestation of a release is attached as. It holds a single DSSE envelope, following the convention of SLSA provenance files.
+const attestationAssetName = "codeql-action-sync.intoto.jsonl"
+
+const inTotoStatementType = "https://in-toto.io/Statement/v0.1"
+const slsaProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+const attestationBuildType = DefaultRepositoryHomepage + "push@v1"
+const dssePayloadType = "application/vnd.in-toto+json"
+
+const errorAttestationKeyUnsupported = "The attestation key must be an unencrypted ECDSA, Ed25519 or RSA private key in PEM format."
+
+// Attestation configures the provenance attestations attached to each pushed release.
+type Attestation struct {
+	Enabled bool
+	// Key signs the attestations. If it is nil they are attached unsigned.
+	Key crypto.Signer
+}
+
+// The field names of attestations are set by the in-toto and SLSA specifications.
+
+type attestationSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type attestationMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+type provenanceInvocation struct {
+	Parameters  map[string]string `json:"parameters"`
+	Environment map[string]string `json:"environment"`
+}
+
+type provenancePredicate struct {
+	Builder    provenanceBuilder     `json:"builder"`
+	BuildType  string                `json:"buildType"`
+	Invocation provenanceInvocation  `json:"invocation"`
+	Materials  []attestationMaterial `json:"materials"`
+}
+
+type provenanceStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []attestationSubject `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     provenancePredicate  `json:"predicate"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+// LoadAttestationKey reads a private key to sign attestations with from a PEM file.
+func LoadAttestationKey(keyPath string) (crypto.Signer, error) {
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading attestation key.")
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, usererrors.New(errorAttestationKeyUnsupported)
+	}
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, usererrors.New(errorAttestationKeyUnsupported)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error parsing attestation key.")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, usererrors.New(errorAttestationKeyUnsupported)
+	}
+	return signer, nil
+}
+
+// attestationKeyID identifies the key attestations are signed with by the checksum of its public key, so that a verifier can tell which key to check a signature with.
+func attestationKeyID(key crypto.Signer) (string, error) {
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", errors.Wrap(err, "Error encoding public attestation key.")
+	}
+	hash := sha256.Sum256(publicKey)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// sourceRepositoryURL returns the URL of the repository a release was pulled from, worked out from the URL of the release itself.
+func sourceRepositoryURL(metadata *github.RepositoryRelease) string {
+	htmlURL := metadata.GetHTMLURL()
+	index := strings.Index(htmlURL, "/releases/")
+	if index == -1 {
+		return ""
+	}
+	return htmlURL[:index]
+}
+
+func findSourceAsset(metadata *github.RepositoryRelease, name string) *github.ReleaseAsset {
+	for _, asset := range metadata.Assets {
+		if asset.GetName() == name {
+			return asset
+		}
+	}
+	return nil
+}
+
+// provenanceStatement describes where the assets of a release came from. It has no timestamps or signatures, so it only changes when what is pushed does.
+func (pushService *pushService) provenanceStatement(metadata *github.RepositoryRelease, uploads []assetUpload) ([]byte, error) {
+	statement := provenanceStatement{
+		Type:          inTotoStatementType,
+		Subject:       []attestationSubject{},
+		PredicateType: slsaProvenancePredicateType,
+		Predicate: provenancePredicate{
+			Builder:   provenanceBuilder{ID: DefaultRepositoryHomepage},
+			BuildType: attestationBuildType,
+			Invocation: provenanceInvocation{
+				Parameters: map[string]string{
+					"release":     metadata.GetTagName(),
+					"destination": pushService.destinationURL + "/" + pushService.destinationRepository(),
+				},
+				Environment: map[string]string{
+					"syncToolVersion": version.Version(),
+					"syncToolCommit":  version.Commit(),
+				},
+			},
+			Materials: []attestationMaterial{},
+		},
+	}
+	tagHash, err := pushService.releaseTagHash(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if sourceURL := sourceRepositoryURL(metadata); sourceURL != "" && tagHash != "" {
+		statement.Predicate.Materials = append(statement.Predicate.Materials, attestationMaterial{
+			URI:    "git+" + sourceURL + "@refs/tags/" + metadata.GetTagName(),
+			Digest: map[string]string{"sha1": tagHash},
+		})
+	}
+	for _, upload := range uploads {
+		digest := map[string]string{"sha256": upload.digest}
+		statement.Subject = append(statement.Subject, attestationSubject{Name: upload.asset.Name, Digest: digest})
+		if sourceAsset := findSourceAsset(metadata, upload.asset.Name); sourceAsset != nil && sourceAsset.GetBrowserDownloadURL() != "" {
+			statement.Predicate.Materials = append(statement.Predicate.Materials, attestationMaterial{URI: sourceAsset.GetBrowserDownloadURL(), Digest: digest})
+		}
+	}
+	statementJSON, err := json.Marshal(statement)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error converting attestation to JSON.")
+	}
+	return statementJSON, nil
+}
+
+// dssePreAuthenticationEncoding returns what is signed for a DSSE envelope, which binds the payload type to the payload.
+func dssePreAuthenticationEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func signAttestation(key crypto.Signer, message []byte) ([]byte, error) {
+	if _, isEd25519 := key.(ed25519.PrivateKey); isEd25519 {
+		return key.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+	hash := sha256.Sum256(message)
+	return key.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+// attestationUpload wraps a provenance statement in a DSSE envelope, signed if there is a key, ready to upload as a release asset.
+func (pushService *pushService) attestationUpload(statement []byte) (assetUpload, error) {
+	envelope := dsseEnvelope{
+		PayloadType: dssePayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  []dsseSignature{},
+	}
+	if key := pushService.attestation.Key; key != nil {
+		keyID, err := attestationKeyID(key)
+		if err != nil {
+			return assetUpload{}, err
+		}
+		signature, err := signAttestation(key, dssePreAuthenticationEncoding(dssePayloadType, statement))
+		if err != nil {
+			return assetUpload{}, errors.Wrap(err, "Error signing attestation.")
+		}
+		envelope.Signatures = append(envelope.Signatures, dsseSignature{KeyID: keyID, Sig: base64.StdEncoding.EncodeToString(signature)})
+	}
+	envelopeJSON, err := json.Marshal(envelope)
+	if err != nil {
+		return assetUpload{}, errors.Wrap(err, "Error converting attestation to JSON.")
+	}
+	content := append(envelopeJSON, '\n')
+	digest, err := cachedirectory.Digest(bytes.NewReader(content))
+	if err != nil {
+		return assetUpload{}, err
+	}
+	return assetUpload{
+		asset:   cachedirectory.Asset{Name: attestationAssetName, Size: int64(len(content))},
+		digest:  digest,
+		content: content,
+	}, nil
+}
+
+// attestationDigestLine records the attestation in the digest of a release, so that turning attestations on or changing the key pushes the release again.
+func (pushService *pushService) attestationDigestLine(statement []byte) (string, error) {
+	keyID := ""
+	if pushService.attestation.Key != nil {
+		var err error
+		keyID, err = attestationKeyID(pushService.attestation.Key)
+		if err != nil {
+			return "", err
+		}
+	}
+	hash := sha256.Sum256(statement)
+	return fmt.Sprintf("attestation %s %s", hex.EncodeToString(hash[:]), keyID), nil
+}
+
+// openUpload opens the content to upload, which is either an asset from the cache or generated by the sync tool, such as an attestation.
+func (pushService *pushService) openUpload(upload assetUpload) (io.ReadCloser, error) {
+	if upload.content != nil {
+		return ioutil.NopCloser(bytes.NewReader(upload.content)), nil
+	}
+	return pushService.cacheDirectory.OpenAsset(upload.release.GetTagName(), upload.asset.Name)
+}
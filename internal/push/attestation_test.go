@@ -0,0 +1,144 @@
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestKey(t *testing.T, directory string, blockType string, der []byte) string {
+	keyPath := path.Join(directory, blockType+".pem")
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return keyPath
+}
+
+func TestLoadAttestationKey(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	require.NoError(t, err)
+	key, err := LoadAttestationKey(writeTestKey(t, temporaryDirectory, "PRIVATE KEY", der))
+	require.NoError(t, err)
+	require.Equal(t, ed25519Key, key)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalECPrivateKey(ecdsaKey)
+	require.NoError(t, err)
+	key, err = LoadAttestationKey(writeTestKey(t, temporaryDirectory, "EC PRIVATE KEY", der))
+	require.NoError(t, err)
+	require.Equal(t, ecdsaKey, key)
+
+	_, err = LoadAttestationKey(writeTestKey(t, temporaryDirectory, "PUBLIC KEY", der))
+	require.EqualError(t, err, errorAttestationKeyUnsupported)
+}
+
+func getTestAttestationRelease() (*github.RepositoryRelease, []assetUpload) {
+	metadata := &github.RepositoryRelease{
+		TagName: github.String("codeql-bundle-20200101"),
+		HTMLURL: github.String("https://github.com/github/codeql-action/releases/tag/codeql-bundle-20200101"),
+		Assets: []*github.ReleaseAsset{{
+			Name:               github.String("bundle.bin"),
+			BrowserDownloadURL: github.String("https://github.com/github/codeql-action/releases/download/codeql-bundle-20200101/bundle.bin"),
+		}},
+	}
+	uploads := []assetUpload{{asset: cachedirectory.Asset{Name: "bundle.bin", Size: 3}, digest: "a-checksum"}}
+	return metadata, uploads
+}
+
+func TestProvenanceStatement(t *testing.T) {
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	pushService.destinationURL = "https://ghe.example.com"
+	metadata, uploads := getTestAttestationRelease()
+	statementJSON, err := pushService.provenanceStatement(metadata, uploads)
+	require.NoError(t, err)
+	statement := provenanceStatement{}
+	require.NoError(t, json.Unmarshal(statementJSON, &statement))
+	require.Equal(t, inTotoStatementType, statement.Type)
+	require.Equal(t, slsaProvenancePredicateType, statement.PredicateType)
+	require.Equal(t, []attestationSubject{{Name: "bundle.bin", Digest: map[string]string{"sha256": "a-checksum"}}}, statement.Subject)
+	require.Equal(t, "https://ghe.example.com/destination-repository-owner/destination-repository-name", statement.Predicate.Invocation.Parameters["destination"])
+	require.Len(t, statement.Predicate.Materials, 2)
+	require.Equal(t, "git+https://github.com/github/codeql-action@refs/tags/codeql-bundle-20200101", statement.Predicate.Materials[0].URI)
+	require.Len(t, statement.Predicate.Materials[0].Digest["sha1"], 40)
+	require.Equal(t, attestationMaterial{URI: metadata.Assets[0].GetBrowserDownloadURL(), Digest: map[string]string{"sha256": "a-checksum"}}, statement.Predicate.Materials[1])
+
+	// The statement does not change between runs, so that releases are not pushed again needlessly.
+	otherStatementJSON, err := pushService.provenanceStatement(metadata, uploads)
+	require.NoError(t, err)
+	require.Equal(t, statementJSON, otherStatementJSON)
+}
+
+func TestAttestationUpload(t *testing.T) {
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	metadata, uploads := getTestAttestationRelease()
+	statement, err := pushService.provenanceStatement(metadata, uploads)
+	require.NoError(t, err)
+
+	upload, err := pushService.attestationUpload(statement)
+	require.NoError(t, err)
+	require.Equal(t, attestationAssetName, upload.asset.Name)
+	require.Equal(t, int64(len(upload.content)), upload.asset.Size)
+	envelope := dsseEnvelope{}
+	require.NoError(t, json.Unmarshal(upload.content, &envelope))
+	require.Empty(t, envelope.Signatures)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pushService.attestation = Attestation{Enabled: true, Key: privateKey}
+	upload, err = pushService.attestationUpload(statement)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(upload.content, &envelope))
+	require.Equal(t, dssePayloadType, envelope.PayloadType)
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	require.NoError(t, err)
+	require.Equal(t, statement, payload)
+	require.Len(t, envelope.Signatures, 1)
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	require.NoError(t, err)
+	require.True(t, ed25519.Verify(publicKey, dssePreAuthenticationEncoding(dssePayloadType, payload), signature))
+	keyID, err := attestationKeyID(privateKey)
+	require.NoError(t, err)
+	require.Equal(t, keyID, envelope.Signatures[0].KeyID)
+
+	reader, err := pushService.openUpload(upload)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, upload.content, content)
+}
+
+func TestReleaseDigestIncludesAttestation(t *testing.T) {
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	metadata, uploads := getTestAttestationRelease()
+	withoutAttestation, err := pushService.releaseDigest(metadata, uploads, nil)
+	require.NoError(t, err)
+	statement, err := pushService.provenanceStatement(metadata, uploads)
+	require.NoError(t, err)
+	withAttestation, err := pushService.releaseDigest(metadata, uploads, statement)
+	require.NoError(t, err)
+	require.NotEqual(t, withoutAttestation, withAttestation)
+
+	// Signing with a key also changes the digest, so that the signed attestation is pushed.
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pushService.attestation = Attestation{Enabled: true, Key: privateKey}
+	withSignedAttestation, err := pushService.releaseDigest(metadata, uploads, statement)
+	require.NoError(t, err)
+	require.NotEqual(t, withAttestation, withSignedAttestation)
+}
@@ -0,0 +1,141 @@
+package push
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/telemetry"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type enterpriseServerVersion struct {
+	major int
+	minor int
+}
+
+func (version enterpriseServerVersion) String() string {
+	return fmt.Sprintf("%d.%d", version.major, version.minor)
+}
+
+func (version enterpriseServerVersion) olderThan(other enterpriseServerVersion) bool {
+	return version.major < other.major || (version.major == other.major && version.minor < other.minor)
+}
+
+// minimumEnterpriseServerVersions maps each major version of the CodeQL Action to the oldest GitHub Enterprise Server release with the code scanning features that it, and the bundles it uses, rely on.
+var minimumEnterpriseServerVersions = map[string]enterpriseServerVersion{
+	"v1": {major: 2, minor: 22},
+	"v2": {major: 3, minor: 4},
+	"v3": {major: 3, minor: 11},
+}
+
+func parseEnterpriseServerVersion(version string) (enterpriseServerVersion, error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return enterpriseServerVersion{}, errors.Errorf("Unrecognized GitHub Enterprise Server version %s.", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return enterpriseServerVersion{}, errors.Errorf("Unrecognized GitHub Enterprise Server version %s.", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return enterpriseServerVersion{}, errors.Errorf("Unrecognized GitHub Enterprise Server version %s.", version)
+	}
+	return enterpriseServerVersion{major: major, minor: minor}, nil
+}
+
+type enterpriseMeta struct {
+	InstalledVersion string `json:"installed_version"`
+}
+
+// installedVersion returns the version reported by the destination, or an empty string if it does not report one, as is the case for GitHub.com.
+func (pushService *pushService) installedVersion() (string, error) {
+	request, err := pushService.githubEnterpriseClient.NewRequest(http.MethodGet, "meta", nil)
+	if err != nil {
+		return "", errors.Wrap(err, "Error constructing request for GitHub Enterprise Server version.")
+	}
+	meta := enterpriseMeta{}
+	response, err := pushService.githubEnterpriseClient.Do(pushService.ctx, request, &meta)
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "Error getting GitHub Enterprise Server version.")
+	}
+	telemetry.FromContext(pushService.ctx).RecordEnterpriseServerVersion(meta.InstalledVersion)
+	return meta.InstalledVersion, nil
+}
+
+// localActionVersions lists the major versions of the CodeQL Action in the cache.
+func (pushService *pushService) localActionVersions() ([]string, error) {
+	gitRepository, err := pushService.openGitRepository()
+	if err != nil {
+		return nil, err
+	}
+	references, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	versions := []string{}
+	seenVersions := map[string]bool{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		match := majorVersionReferencePattern.FindStringSubmatch(reference.Name().String())
+		if match != nil && !seenVersions[match[2]] {
+			seenVersions[match[2]] = true
+			versions = append(versions, match[2])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// checkCompatibility warns if the destination is too old to support some of the versions of the CodeQL Action being pushed, or fails if running in strict mode.
+func (pushService *pushService) checkCompatibility() error {
+	log.Debug("Checking GitHub Enterprise Server version...")
+	installedVersionString, err := pushService.installedVersion()
+	if err != nil {
+		return err
+	}
+	if installedVersionString == "" {
+		log.Debug("The destination did not report a GitHub Enterprise Server version. Skipping compatibility check.")
+		return nil
+	}
+	installedVersion, err := parseEnterpriseServerVersion(installedVersionString)
+	if err != nil {
+		return err
+	}
+	actionVersions, err := pushService.localActionVersions()
+	if err != nil {
+		return err
+	}
+	incompatible := []string{}
+	for _, actionVersion := range actionVersions {
+		minimumVersion, known := minimumEnterpriseServerVersions[actionVersion]
+		if !known {
+			log.Debugf("The minimum GitHub Enterprise Server version for CodeQL Action %s is not known.", actionVersion)
+			continue
+		}
+		if installedVersion.olderThan(minimumVersion) {
+			incompatible = append(incompatible, fmt.Sprintf("%s requires %s or later", actionVersion, minimumVersion))
+		}
+	}
+	if len(incompatible) == 0 {
+		log.Debugf("GitHub Enterprise Server %s supports all versions of the CodeQL Action being pushed.", installedVersionString)
+		return nil
+	}
+	message := fmt.Sprintf("GitHub Enterprise Server %s is too old for some versions of the CodeQL Action being pushed (%s). Workflows using these versions may fail to upload results.", installedVersionString, strings.Join(incompatible, ", "))
+	if pushService.strict {
+		return fmt.Errorf("%s Upgrade GitHub Enterprise Server, or run without `--strict` to push anyway.", message)
+	}
+	log.Warn(message)
+	return nil
+}
@@ -0,0 +1,61 @@
+package push
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/telemetry"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnterpriseServerVersion(t *testing.T) {
+	version, err := parseEnterpriseServerVersion("3.4.2")
+	require.NoError(t, err)
+	require.Equal(t, enterpriseServerVersion{major: 3, minor: 4}, version)
+	require.True(t, version.olderThan(enterpriseServerVersion{major: 3, minor: 11}))
+	require.False(t, version.olderThan(enterpriseServerVersion{major: 2, minor: 22}))
+
+	_, err = parseEnterpriseServerVersion("enterprise")
+	require.Error(t, err)
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, createTestActionCache(t), githubEnterpriseURL)
+
+	installedVersion := "3.2.0"
+	githubTestServer.HandleFunc("/api/v3/meta", func(response http.ResponseWriter, request *http.Request) {
+		if installedVersion == "" {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		test.ServeHTTPResponseFromObject(t, enterpriseMeta{InstalledVersion: installedVersion}, response)
+	}).Methods("GET")
+
+	require.NoError(t, pushService.checkCompatibility())
+	pushService.strict = true
+	err := pushService.checkCompatibility()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "GitHub Enterprise Server 3.2.0 is too old for some versions of the CodeQL Action being pushed (v2 requires 3.4 or later).")
+
+	installedVersion = "3.4.0"
+	require.NoError(t, pushService.checkCompatibility())
+
+	installedVersion = ""
+	require.NoError(t, pushService.checkCompatibility())
+}
+
+func TestInstalledVersionIsRecordedForTelemetry(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, createTestActionCache(t), githubEnterpriseURL)
+	recorder := telemetry.NewRecorder()
+	pushService.ctx = telemetry.WithRecorder(pushService.ctx, recorder)
+	githubTestServer.HandleFunc("/api/v3/meta", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, enterpriseMeta{InstalledVersion: "3.12.4"}, response)
+	}).Methods("GET")
+
+	require.NoError(t, pushService.checkCompatibility())
+	require.Equal(t, "3.12", recorder.Report("push", time.Minute, true).EnterpriseServerVersion)
+}
@@ -0,0 +1,98 @@
+package push
+
+import (
+	"fmt"
+
+	"github.com/github/codeql-action-sync/internal/audit"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// fallbackDefaultBranch is used for caches pulled by earlier versions of the sync tool, which did not record the default branch of the source.
+const fallbackDefaultBranch = "main"
+
+func hasBranch(gitRepository *git.Repository, branch plumbing.ReferenceName) (bool, error) {
+	_, err := gitRepository.Reference(branch, false)
+	if err == plumbing.ErrReferenceNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "Error finding branch %s in cache.", branch.Short())
+	}
+	return true, nil
+}
+
+// cachedDefaultBranch returns the default branch of the source, which `pull` records as `HEAD` of the Git repository cache, or an empty string if the cache has no suitable branch.
+func cachedDefaultBranch(gitRepository *git.Repository) (string, error) {
+	candidates := []plumbing.ReferenceName{}
+	head, err := gitRepository.Reference(plumbing.HEAD, false)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return "", errors.Wrap(err, "Error reading default branch from cache.")
+	}
+	if err == nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		candidates = append(candidates, head.Target())
+	}
+	candidates = append(candidates, plumbing.NewBranchReferenceName(fallbackDefaultBranch))
+	for _, candidate := range candidates {
+		exists, err := hasBranch(gitRepository, candidate)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return candidate.Short(), nil
+		}
+	}
+	return "", nil
+}
+
+// resolveDefaultBranch works out which branch to make the default branch of the destination repository: the one given with `--default-branch`, or otherwise the default branch of the source.
+func (pushService *pushService) resolveDefaultBranch() error {
+	gitRepository, err := pushService.openGitRepository()
+	if err != nil {
+		return err
+	}
+	if pushService.repositoryMetadata.DefaultBranch != "" {
+		exists, err := hasBranch(gitRepository, plumbing.NewBranchReferenceName(pushService.repositoryMetadata.DefaultBranch))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("The branch %s given with `--default-branch` is not in the cache.", pushService.repositoryMetadata.DefaultBranch)
+		}
+		pushService.defaultBranch = pushService.repositoryMetadata.DefaultBranch
+		return nil
+	}
+	pushService.defaultBranch, err = cachedDefaultBranch(gitRepository)
+	if err != nil {
+		return err
+	}
+	if pushService.defaultBranch == "" {
+		log.Warn("The cache does not have the default branch of the source, so the default branch of the destination repository will be left as it is.")
+	}
+	return nil
+}
+
+// updateDefaultBranch makes the destination repository use the chosen default branch. A repository only takes the first branch pushed to it as its default when it is created, so this is needed if the default branch changes later.
+func (pushService *pushService) updateDefaultBranch(repository *github.Repository) error {
+	if pushService.defaultBranch == "" || repository.GetDefaultBranch() == pushService.defaultBranch {
+		return nil
+	}
+	if pushService.noSiteAdmin && !repository.GetPermissions()["admin"] {
+		pushService.skipStep(fmt.Sprintf("making %s the default branch of %s, as you are not an admin of it", pushService.defaultBranch, repository.GetFullName()))
+		return nil
+	}
+	log.Debugf("Making %s the default branch of %s...", pushService.defaultBranch, repository.GetFullName())
+	_, response, err := pushService.githubEnterpriseClient.Repositories.Edit(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, &github.Repository{
+		DefaultBranch: github.String(pushService.defaultBranch),
+	})
+	if err != nil {
+		if permissionErr := permissionError(response, "set the default branch of the destination repository"); permissionErr != nil {
+			return permissionErr
+		}
+		return errors.Wrap(err, "Error setting default branch of destination repository.")
+	}
+	return pushService.audit(audit.ActionUpdateRepository, repository.GetFullName(), map[string]string{"default_branch": pushService.defaultBranch})
+}
@@ -0,0 +1,49 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveDefaultBranch(t *testing.T) {
+	pushService := getTestPushService(t, createTestActionCache(t), "")
+	require.NoError(t, pushService.resolveDefaultBranch())
+	require.Equal(t, "master", pushService.defaultBranch)
+
+	pushService.repositoryMetadata.DefaultBranch = "v1"
+	require.NoError(t, pushService.resolveDefaultBranch())
+	require.Equal(t, "v1", pushService.defaultBranch)
+
+	pushService.repositoryMetadata.DefaultBranch = "v2"
+	require.EqualError(t, pushService.resolveDefaultBranch(), "The branch v2 given with `--default-branch` is not in the cache.")
+}
+
+func TestUpdateDefaultBranch(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, createTestActionCache(t), githubEnterpriseURL)
+	pushService.defaultBranch = "v1"
+	edits := []github.Repository{}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		edit := github.Repository{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&edit))
+		edits = append(edits, edit)
+		test.ServeHTTPResponseFromObject(t, edit, response)
+	}).Methods("PATCH")
+
+	require.NoError(t, pushService.updateDefaultBranch(&github.Repository{DefaultBranch: github.String("v1")}))
+	require.Empty(t, edits)
+
+	require.NoError(t, pushService.updateDefaultBranch(&github.Repository{DefaultBranch: github.String("main")}))
+	require.Len(t, edits, 1)
+	require.Equal(t, "v1", edits[0].GetDefaultBranch())
+
+	// Without site admin access, only an admin of the repository can change its default branch.
+	pushService.noSiteAdmin = true
+	require.NoError(t, pushService.updateDefaultBranch(&github.Repository{DefaultBranch: github.String("main")}))
+	require.Len(t, edits, 1)
+}
@@ -0,0 +1,216 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// destination records a repository the cache has been pushed to, so that it can be found again by ID if it is later renamed or transferred.
+type destination struct {
+	URL                 string   `json:"url"`
+	RequestedRepository string   `json:"requested_repository"`
+	Repository          string   `json:"repository"`
+	ID                  int64    `json:"id"`
+	Releases            []string `json:"releases,omitempty"`
+	// AssetDigests maps the ID of each release asset the sync tool uploaded to the SHA-256 checksum of its content.
+	AssetDigests map[string]string `json:"asset_digests,omitempty"`
+	PushedAt     time.Time         `json:"pushed_at,omitempty"`
+}
+
+func (pushService *pushService) readDestinations() ([]destination, error) {
+	destinationsJSON, err := pushService.cacheDirectory.ReadDestinations()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []destination{}, nil
+		}
+		return nil, errors.Wrap(err, "Error reading previous push destinations.")
+	}
+	destinations := []destination{}
+	err = json.Unmarshal(destinationsJSON, &destinations)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error decoding previous push destinations.")
+	}
+	return destinations, nil
+}
+
+func (pushService *pushService) isRequestedDestination(destination destination) bool {
+	return destination.URL == pushService.destinationURL && strings.EqualFold(destination.RequestedRepository, pushService.requestedRepository)
+}
+
+func (pushService *pushService) destinationRepository() string {
+	return pushService.destinationRepositoryOwner + "/" + pushService.destinationRepositoryName
+}
+
+// useCanonicalRepository switches to the current name of the destination repository if it has been renamed or transferred.
+func (pushService *pushService) useCanonicalRepository(fullName string) {
+	if fullName == "" || strings.EqualFold(fullName, pushService.destinationRepository()) {
+		return
+	}
+	split := strings.SplitN(fullName, "/", 2)
+	if len(split) != 2 {
+		return
+	}
+	log.Warnf("The destination repository %s has been renamed or transferred to %s. Pushing to %s instead. Please update the destination repository you provide to the sync tool.", pushService.destinationRepository(), fullName, fullName)
+	pushService.destinationRepositoryOwner = split[0]
+	pushService.destinationRepositoryName = split[1]
+}
+
+// resolvePreviousDestination finds the repository that was previously pushed to under the requested name, in case it has since been renamed or transferred and the old name no longer redirects to it.
+func (pushService *pushService) resolvePreviousDestination() error {
+	destinations, err := pushService.readDestinations()
+	if err != nil {
+		return err
+	}
+	for _, destination := range destinations {
+		if !pushService.isRequestedDestination(destination) || destination.ID == 0 {
+			continue
+		}
+		var repository *github.Repository
+		err := retry.Do(pushService.ctx, "checking previous destination repository", func(attempt int) error {
+			var response *github.Response
+			var err error
+			repository, response, err = pushService.githubEnterpriseClient.Repositories.GetByID(pushService.ctx, destination.ID)
+			if err != nil && response != nil && response.StatusCode == http.StatusNotFound {
+				repository = nil
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "Error checking previous destination repository.")
+		}
+		if repository == nil {
+			log.Debugf("The repository previously pushed to as %s no longer exists.", destination.Repository)
+			return nil
+		}
+		pushService.useCanonicalRepository(repository.GetFullName())
+		return nil
+	}
+	return nil
+}
+
+// retentionCutoff returns the time before which releases are not pushed, and with `--since last-sync` the releases that the last successful push to the destination pushed.
+func (pushService *pushService) retentionCutoff() (time.Time, map[string]bool, error) {
+	previousReleases := map[string]bool{}
+	if !pushService.retention.LastSync {
+		return pushService.retention.Cutoff(time.Time{}), previousReleases, nil
+	}
+	destinations, err := pushService.readDestinations()
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	for _, destination := range destinations {
+		if pushService.isRequestedDestination(destination) {
+			for _, release := range destination.Releases {
+				previousReleases[release] = true
+			}
+			return pushService.retention.Cutoff(destination.PushedAt), previousReleases, nil
+		}
+	}
+	return time.Time{}, previousReleases, nil
+}
+
+// recordDestination stores the repository that was pushed to, so that future pushes can follow it if it is renamed or transferred.
+func (pushService *pushService) recordDestination(repositoryID int64) error {
+	destinations, err := pushService.readDestinations()
+	if err != nil {
+		return err
+	}
+	releases, alreadyPushed, err := pushService.selectReleases()
+	if err != nil {
+		return err
+	}
+	// Releases skipped with `--since last-sync` are still on the destination.
+	releases = append(releases, alreadyPushed...)
+	assetDigests := map[string]string{}
+	for id, digest := range pushService.recordedAssetDigests() {
+		assetDigests[strconv.FormatInt(id, 10)] = digest
+	}
+	updated := []destination{}
+	for _, destination := range destinations {
+		if !pushService.isRequestedDestination(destination) {
+			updated = append(updated, destination)
+		}
+	}
+	updated = append(updated, destination{
+		URL:                 pushService.destinationURL,
+		RequestedRepository: pushService.requestedRepository,
+		Repository:          pushService.destinationRepository(),
+		ID:                  repositoryID,
+		Releases:            releases,
+		AssetDigests:        assetDigests,
+		PushedAt:            pushService.startedAt,
+	})
+	destinationsJSON, err := json.Marshal(updated)
+	if err != nil {
+		return errors.Wrap(err, "Error converting push destinations to JSON.")
+	}
+	err = pushService.cacheDirectory.WriteDestinations(destinationsJSON)
+	if err != nil {
+		return errors.Wrap(err, "Error writing push destinations.")
+	}
+	return nil
+}
+
+// previousAssetDigests returns the checksums of the assets uploaded to the destination, either earlier in this run or by a previous push.
+func (pushService *pushService) previousAssetDigests() (map[int64]string, error) {
+	if pushService.assetDigests != nil {
+		return pushService.recordedAssetDigests(), nil
+	}
+	destinations, err := pushService.readDestinations()
+	if err != nil {
+		return nil, err
+	}
+	result := map[int64]string{}
+	for _, destination := range destinations {
+		if !pushService.isRequestedDestination(destination) {
+			continue
+		}
+		for id, digest := range destination.AssetDigests {
+			assetID, err := strconv.ParseInt(id, 10, 64)
+			if err == nil {
+				result[assetID] = digest
+			}
+		}
+	}
+	return result, nil
+}
+
+func (pushService *pushService) recordedAssetDigests() map[int64]string {
+	pushService.assetDigestsLock.Lock()
+	defer pushService.assetDigestsLock.Unlock()
+	result := map[int64]string{}
+	for id, digest := range pushService.assetDigests {
+		result[id] = digest
+	}
+	return result
+}
+
+// PushedReleases lists the CodeQL bundle releases that the cache last pushed to the given destination.
+func PushedReleases(cacheDirectory cachedirectory.CacheDirectory, destinationURL string, destinationRepository string) ([]string, error) {
+	pushService := pushService{
+		cacheDirectory:      cacheDirectory,
+		destinationURL:      strings.TrimRight(destinationURL, "/"),
+		requestedRepository: destinationRepository,
+	}
+	destinations, err := pushService.readDestinations()
+	if err != nil {
+		return nil, err
+	}
+	for _, destination := range destinations {
+		if pushService.isRequestedDestination(destination) {
+			return destination.Releases, nil
+		}
+	}
+	return []string{}, nil
+}
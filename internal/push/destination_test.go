@@ -0,0 +1,97 @@
+package push
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateRepositoryFollowsRename(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		http.Redirect(response, request, "/api/v3/repositories/42", http.StatusMovedPermanently)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repositories/42", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{ID: github.Int64(42), FullName: github.String("new-owner/new-name"), Homepage: github.String(DefaultRepositoryHomepage)}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/new-owner/new-name", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{ID: github.Int64(42), FullName: github.String("new-owner/new-name")}, response)
+	}).Methods("PATCH")
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+	require.Equal(t, "new-owner/new-name", pushService.destinationRepository())
+}
+
+func TestResolvePreviousDestinationRetries(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.ctx = retry.WithPolicy(pushService.ctx, retry.Policy{Retries: 2, Delay: time.Millisecond})
+	pushService.destinationURL = githubEnterpriseURL
+	pushService.requestedRepository = "destination-repository-owner/destination-repository-name"
+	require.NoError(t, os.MkdirAll(filepath.Join(temporaryDirectory, "releases", "codeql-bundle-20200101"), 0755))
+	require.NoError(t, pushService.recordDestination(42))
+
+	attempts := 0
+	githubTestServer.HandleFunc("/api/v3/repositories/42", func(response http.ResponseWriter, request *http.Request) {
+		attempts++
+		if attempts == 1 {
+			response.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		test.ServeHTTPResponseFromObject(t, github.Repository{ID: github.Int64(42), FullName: github.String("new-owner/new-name")}, response)
+	}).Methods("GET")
+	require.NoError(t, pushService.resolvePreviousDestination())
+	require.Equal(t, 2, attempts)
+	require.Equal(t, "new-owner/new-name", pushService.destinationRepository())
+}
+
+func TestResolvePreviousDestination(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.destinationURL = githubEnterpriseURL
+	pushService.requestedRepository = "destination-repository-owner/destination-repository-name"
+	require.NoError(t, os.MkdirAll(filepath.Join(temporaryDirectory, "releases", "codeql-bundle-20200101"), 0755))
+
+	// Nothing has been pushed yet, so there is nothing to resolve.
+	require.NoError(t, pushService.resolvePreviousDestination())
+	require.NoError(t, pushService.recordDestination(42))
+
+	githubTestServer.HandleFunc("/api/v3/repositories/42", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{ID: github.Int64(42), FullName: github.String("new-owner/new-name")}, response)
+	}).Methods("GET")
+	require.NoError(t, pushService.resolvePreviousDestination())
+	require.Equal(t, "new-owner/new-name", pushService.destinationRepository())
+
+	require.NoError(t, pushService.recordDestination(42))
+	destinations, err := pushService.readDestinations()
+	require.NoError(t, err)
+	require.Equal(t, []destination{{
+		URL:                 githubEnterpriseURL,
+		RequestedRepository: "destination-repository-owner/destination-repository-name",
+		Repository:          "new-owner/new-name",
+		ID:                  42,
+		Releases:            []string{"codeql-bundle-20200101"},
+	}}, destinations)
+
+	releases, err := PushedReleases(cachedirectory.NewCacheDirectory(temporaryDirectory), githubEnterpriseURL+"/", "destination-repository-owner/destination-repository-name")
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200101"}, releases)
+	releases, err = PushedReleases(cachedirectory.NewCacheDirectory(temporaryDirectory), githubEnterpriseURL, "other-owner/other-name")
+	require.NoError(t, err)
+	require.Empty(t, releases)
+}
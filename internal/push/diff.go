@@ -0,0 +1,381 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/github/codeql-action-sync/internal/assetselection"
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/fips"
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/github/codeql-action-sync/internal/sbom"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const DiffFormatText = "text"
+const DiffFormatJSON = "json"
+
+const errorUnknownDiffFormat = "The output format must be either `text` or `json`."
+const errorDifferencesFound = "The cache and the destination differ."
+
+// Kinds of difference between the cache and the destination.
+const (
+	// DifferenceMissing means something in the cache is not on the destination.
+	DifferenceMissing = "missing"
+	// DifferenceExtra means something on the destination is not in the cache.
+	DifferenceExtra = "extra"
+	// DifferenceChanged means a Git reference points to a different commit on the destination.
+	DifferenceChanged = "changed"
+	// DifferenceSize means an asset on the destination is a different size.
+	DifferenceSize = "size_mismatch"
+	// DifferenceDigest means an asset on the destination was uploaded by the sync tool with a different checksum.
+	DifferenceDigest = "digest_mismatch"
+	// DifferenceUnverified means an asset on the destination is the same size, but it was not uploaded by this cache so its checksum is not known.
+	DifferenceUnverified = "unverified"
+)
+
+type ReferenceDifference struct {
+	Name        string `json:"name"`
+	Difference  string `json:"difference"`
+	Cache       string `json:"cache,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+type ReleaseDifference struct {
+	Tag        string `json:"tag"`
+	Difference string `json:"difference"`
+}
+
+type AssetDifference struct {
+	Release           string `json:"release"`
+	Name              string `json:"name"`
+	Difference        string `json:"difference"`
+	CacheSize         int64  `json:"cache_size,omitempty"`
+	DestinationSize   int64  `json:"destination_size,omitempty"`
+	CacheSHA256       string `json:"cache_sha256,omitempty"`
+	DestinationSHA256 string `json:"destination_sha256,omitempty"`
+}
+
+// Differences describes everything that a push would change on the destination, and anything on the destination that is not in the cache.
+type Differences struct {
+	Cache       string                `json:"cache"`
+	Destination string                `json:"destination"`
+	References  []ReferenceDifference `json:"references"`
+	Releases    []ReleaseDifference   `json:"releases"`
+	Assets      []AssetDifference     `json:"assets"`
+}
+
+// Empty reports whether the cache and the destination are the same.
+func (differences *Differences) Empty() bool {
+	return len(differences.References) == 0 && len(differences.Releases) == 0 && len(differences.Assets) == 0
+}
+
+// referenceDifferences compares the Git references in the cache with those on the destination. References of pull requests on the destination are ignored. If include is not nil, references in the cache for which it returns false are treated as if they were not in the cache.
+func referenceDifferences(gitRepository *git.Repository, remoteReferences []*plumbing.Reference, include func(plumbing.ReferenceName) bool) ([]ReferenceDifference, error) {
+	cacheHashes := map[string]string{}
+	references, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference && strings.HasPrefix(reference.Name().String(), "refs/") && !reference.Hash().IsZero() && (include == nil || include(reference.Name())) {
+			cacheHashes[reference.Name().String()] = reference.Hash().String()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	destinationHashes := map[string]string{}
+	for _, reference := range remoteReferences {
+		if reference.Type() == plumbing.HashReference && strings.HasPrefix(reference.Name().String(), "refs/") && !strings.HasPrefix(reference.Name().String(), pullRequestReferencesPrefix) {
+			destinationHashes[reference.Name().String()] = reference.Hash().String()
+		}
+	}
+	result := []ReferenceDifference{}
+	for name, hash := range cacheHashes {
+		destinationHash, exists := destinationHashes[name]
+		if !exists {
+			result = append(result, ReferenceDifference{Name: name, Difference: DifferenceMissing, Cache: hash})
+		} else if destinationHash != hash {
+			result = append(result, ReferenceDifference{Name: name, Difference: DifferenceChanged, Cache: hash, Destination: destinationHash})
+		}
+	}
+	for name, hash := range destinationHashes {
+		if _, exists := cacheHashes[name]; !exists {
+			result = append(result, ReferenceDifference{Name: name, Difference: DifferenceExtra, Destination: hash})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func (pushService *pushService) diffReferences(repository *github.Repository) ([]ReferenceDifference, error) {
+	gitRepository, err := pushService.openGitRepository()
+	if err != nil {
+		return nil, err
+	}
+	remoteURL := repository.GetCloneURL()
+	if pushService.pushSSH {
+		remoteURL = repository.GetSSHURL()
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{remoteURL},
+	})
+	remoteReferences, err := listRemoteReferences(pushService.ctx, remote, pushService.gitCredentials())
+	if err != nil && err != transport.ErrEmptyRemoteRepository {
+		return nil, errors.Wrap(err, "Error listing remote references.")
+	}
+	return referenceDifferences(gitRepository, remoteReferences, pushService.referenceFilter())
+}
+
+// diffAssets compares the assets of a release in the cache with those of the same release on the destination. Bills of materials and attestations are generated by the sync tool rather than kept in the cache, so they are not compared.
+func (pushService *pushService) diffAssets(releaseName string, release *github.RepositoryRelease, uploadedDigests map[int64]string) ([]AssetDifference, error) {
+	assets, err := pushService.cacheDirectory.ListAssets(releaseName)
+	if err != nil {
+		return nil, err
+	}
+	selectedAssets, err := pushService.selectAssets(assets)
+	if err != nil {
+		return nil, err
+	}
+	existingAssets, err := pushService.listReleaseAssets(release)
+	if err != nil {
+		return nil, err
+	}
+	generated := map[string]bool{attestationAssetName: true}
+	cached := map[string]bool{}
+	result := []AssetDifference{}
+	for _, asset := range selectedAssets {
+		cached[asset.Name] = true
+		if sbom.IsBundle(asset.Name) {
+			generated[sbom.AssetName(asset.Name)] = true
+		}
+		digest, err := pushService.cachedAssetDigest(releaseName, asset)
+		if err != nil {
+			return nil, err
+		}
+		difference := AssetDifference{Release: releaseName, Name: asset.Name, CacheSize: asset.Size, CacheSHA256: digest}
+		existing := findExistingAsset(existingAssets, asset.Name)
+		if existing == nil {
+			difference.Difference = DifferenceMissing
+			result = append(result, difference)
+			continue
+		}
+		difference.DestinationSize = githubapiutil.AssetSize(existing)
+		difference.DestinationSHA256 = uploadedDigests[existing.GetID()]
+		if difference.DestinationSize != asset.Size {
+			difference.Difference = DifferenceSize
+		} else if difference.DestinationSHA256 == "" {
+			difference.Difference = DifferenceUnverified
+		} else if difference.DestinationSHA256 != digest {
+			difference.Difference = DifferenceDigest
+		} else {
+			continue
+		}
+		result = append(result, difference)
+	}
+	for _, existing := range existingAssets {
+		if !cached[existing.GetName()] && !generated[existing.GetName()] {
+			result = append(result, AssetDifference{Release: releaseName, Name: existing.GetName(), Difference: DifferenceExtra, DestinationSize: githubapiutil.AssetSize(existing), DestinationSHA256: uploadedDigests[existing.GetID()]})
+		}
+	}
+	return result, nil
+}
+
+func (pushService *pushService) diffReleases() ([]ReleaseDifference, []AssetDifference, error) {
+	selected, alreadyPushed, err := pushService.selectReleases()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Releases skipped with `--since last-sync` were already pushed, so they should still be on the destination.
+	releaseNames := append(selected, alreadyPushed...)
+	sort.Strings(releaseNames)
+	pushService.manifest, err = pushService.cacheDirectory.ReadManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+	uploadedDigests, err := pushService.previousAssetDigests()
+	if err != nil {
+		return nil, nil, err
+	}
+	destinationReleases, err := pushService.listDestinationReleases()
+	if err != nil {
+		return nil, nil, err
+	}
+	destinationReleasesMap := map[string]*github.RepositoryRelease{}
+	for _, release := range destinationReleases {
+		destinationReleasesMap[release.GetTagName()] = release
+	}
+	releaseDifferences := []ReleaseDifference{}
+	assetDifferences := []AssetDifference{}
+	cached := map[string]bool{}
+	for _, releaseName := range releaseNames {
+		cached[releaseName] = true
+		release := destinationReleasesMap[releaseName]
+		if release == nil {
+			releaseDifferences = append(releaseDifferences, ReleaseDifference{Tag: releaseName, Difference: DifferenceMissing})
+			continue
+		}
+		differences, err := pushService.diffAssets(releaseName, release, uploadedDigests)
+		if err != nil {
+			return nil, nil, err
+		}
+		assetDifferences = append(assetDifferences, differences...)
+	}
+	for _, release := range destinationReleases {
+		if !cached[release.GetTagName()] {
+			releaseDifferences = append(releaseDifferences, ReleaseDifference{Tag: release.GetTagName(), Difference: DifferenceExtra})
+		}
+	}
+	sort.Slice(releaseDifferences, func(i, j int) bool {
+		return releaseDifferences[i].Tag < releaseDifferences[j].Tag
+	})
+	return releaseDifferences, assetDifferences, nil
+}
+
+func writeDiffText(differences *Differences, writer io.Writer) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tabWriter, "Cache: %s\n", differences.Cache)
+	fmt.Fprintf(tabWriter, "Destination: %s\n", differences.Destination)
+	fmt.Fprintf(tabWriter, "\nGit references (%d):\n", len(differences.References))
+	for _, reference := range differences.References {
+		fmt.Fprintf(tabWriter, "  %s\t%s\t%s\t%s\n", reference.Name, reference.Difference, valueOrDash(reference.Cache), valueOrDash(reference.Destination))
+	}
+	fmt.Fprintf(tabWriter, "\nReleases (%d):\n", len(differences.Releases))
+	for _, release := range differences.Releases {
+		fmt.Fprintf(tabWriter, "  %s\t%s\n", release.Tag, release.Difference)
+	}
+	fmt.Fprintf(tabWriter, "\nAssets (%d):\n", len(differences.Assets))
+	for _, asset := range differences.Assets {
+		fmt.Fprintf(tabWriter, "  %s/%s\t%s\t%s\t%s\n", asset.Release, asset.Name, asset.Difference, assetSummary(asset.CacheSize, asset.CacheSHA256, asset.Difference != DifferenceExtra), assetSummary(asset.DestinationSize, asset.DestinationSHA256, asset.Difference != DifferenceMissing))
+	}
+	return tabWriter.Flush()
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func assetSummary(size int64, digest string, exists bool) string {
+	if !exists {
+		return "-"
+	}
+	return fmt.Sprintf("%d %s", size, valueOrDash(digest))
+}
+
+// Diff prints the differences between what a push would push from the cache and what is on the destination: Git references pointing to different commits, and releases and assets that are missing, extra or different. The API does not give the checksums of assets, so an asset on the destination is only compared by checksum if the sync tool recorded uploading it from this cache. If there are any differences, an error with the Differences exit code is returned.
+func Diff(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, destinationURL string, destinationToken string, destinationRepository string, languageMapping *assetselection.Mapping, languages []string, pushSSH bool, releaseRefsOnly bool, includeDrafts bool, retentionPolicy retention.Policy, writer io.Writer, outputFormat string) error {
+	if outputFormat != DiffFormatText && outputFormat != DiffFormatJSON {
+		return usererrors.New(errorUnknownDiffFormat)
+	}
+	languages, err := languageMapping.ParseLanguages(languages)
+	if err != nil {
+		return err
+	}
+	if pushSSH {
+		err = fips.Check("Reading Git references over SSH with `--push-ssh`")
+		if err != nil {
+			return err
+		}
+	}
+	err = cacheDirectory.LoadGit()
+	if err != nil {
+		return err
+	}
+
+	destinationURL = strings.TrimRight(destinationURL, "/")
+	token := oauth2.Token{AccessToken: destinationToken}
+	tokenClient := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, retry.NewClient(nil)), oauth2.StaticTokenSource(&token))
+	client, err := githubapiutil.NewClient(destinationURL, tokenClient)
+	if err != nil {
+		return errors.Wrap(err, "Error creating GitHub Enterprise client.")
+	}
+
+	destinationRepositorySplit := strings.Split(destinationRepository, "/")
+	pushService := pushService{
+		ctx:                        ctx,
+		cacheDirectory:             cacheDirectory,
+		githubEnterpriseClient:     client,
+		destinationURL:             destinationURL,
+		requestedRepository:        destinationRepository,
+		destinationRepositoryOwner: destinationRepositorySplit[0],
+		destinationRepositoryName:  destinationRepositorySplit[1],
+		destinationToken:           &token,
+		languageMapping:            languageMapping,
+		languages:                  languages,
+		pushSSH:                    pushSSH,
+		releaseRefsOnly:            releaseRefsOnly,
+		includeDrafts:              includeDrafts,
+		retention:                  retentionPolicy,
+	}
+	pushService.sourceKind, err = cacheDirectory.ReadSourceKind()
+	if err != nil {
+		return errors.Wrap(err, "Error reading source kind from cache.")
+	}
+	err = pushService.findSkippedDrafts(false)
+	if err != nil {
+		return err
+	}
+
+	err = pushService.resolvePreviousDestination()
+	if err != nil {
+		return err
+	}
+	repository, response, err := client.Repositories.Get(ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName)
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("The destination repository %s does not exist, so everything in the cache would be pushed.", destinationRepository)
+		}
+		return errors.Wrap(err, "Error checking if destination repository exists.")
+	}
+	pushService.useCanonicalRepository(repository.GetFullName())
+
+	differences := Differences{Cache: cacheDirectory.String(), Destination: destinationURL + "/" + pushService.destinationRepository()}
+	differences.References, err = pushService.diffReferences(repository)
+	if err != nil {
+		return err
+	}
+	differences.Releases, differences.Assets, err = pushService.diffReleases()
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == DiffFormatJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(differences)
+	} else {
+		err = writeDiffText(&differences, writer)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error writing differences.")
+	}
+	if !differences.Empty() {
+		return exitcode.WithCode(usererrors.New(errorDifferencesFound), exitcode.Differences)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package push
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v32/github"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferenceDifferences(t *testing.T) {
+	gitRepository, err := git.PlainOpen("./push_test/action-cache-initial/git")
+	require.NoError(t, err)
+	remoteReferences := []*plumbing.Reference{
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), plumbing.NewHash("b9f01aa2c50f49898d4c7845a66be8824499fe9d")),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("v1"), plumbing.NewHash("bd82b85707bc13904e3526517677039d4da4a9bb")),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("pushed-directly"), plumbing.NewHash("bd82b85707bc13904e3526517677039d4da4a9bb")),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("a-ref-that-will-need-pruning"), plumbing.NewHash("26936381e619a01122ea33993e3cebc474496805")),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("v3"), plumbing.NewHash("e529a54fad10a936308b2220e05f7f00757f8e7c")),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("very-ignored-branch"), plumbing.NewHash("bd82b85707bc13904e3526517677039d4da4a9bb")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("an-ignored-tag-too"), plumbing.NewHash("bd82b85707bc13904e3526517677039d4da4a9bb")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("codeql-bundle-20200101"), plumbing.NewHash("26936381e619a01122ea33993e3cebc474496805")),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v2"), plumbing.NewHash("26936381e619a01122ea33993e3cebc474496805")),
+	}
+	differences, err := referenceDifferences(gitRepository, remoteReferences, nil)
+	require.NoError(t, err)
+	require.Equal(t, []ReferenceDifference{
+		{Name: "refs/heads/pushed-directly", Difference: DifferenceExtra, Destination: "bd82b85707bc13904e3526517677039d4da4a9bb"},
+		{Name: "refs/heads/v1", Difference: DifferenceChanged, Cache: "26936381e619a01122ea33993e3cebc474496805", Destination: "bd82b85707bc13904e3526517677039d4da4a9bb"},
+		{Name: "refs/tags/codeql-bundle-20200630", Difference: DifferenceMissing, Cache: "26936381e619a01122ea33993e3cebc474496805"},
+	}, differences)
+}
+
+func sha256Hex(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestDiffReleases(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.destinationURL = githubEnterpriseURL
+	pushService.requestedRepository = pushService.destinationRepository()
+	cachedAssets := map[string]string{
+		"codeql-bundle.tar.gz":       "A CodeQL bundle.",
+		"codeql-bundle-linux.tar.gz": "A CodeQL bundle for Linux.",
+		"codeql-bundle-osx.tar.gz":   "A CodeQL bundle for macOS.",
+		"codeql-bundle-win.tar.gz":   "A CodeQL bundle for Windows.",
+	}
+	for _, releaseName := range []string{"codeql-bundle-20200101", "codeql-bundle-20200630"} {
+		require.NoError(t, pushService.cacheDirectory.WriteMetadata(releaseName, []byte(`{"tag_name": "`+releaseName+`"}`)))
+		for name, content := range cachedAssets {
+			require.NoError(t, pushService.cacheDirectory.WriteAsset(releaseName, name, strings.NewReader(content), int64(len(content))))
+		}
+	}
+	require.NoError(t, pushService.cacheDirectory.WriteDestinations([]byte(`[{"url": "`+githubEnterpriseURL+`", "requested_repository": "destination-repository-owner/destination-repository-name", "asset_digests": {"11": "`+sha256Hex("A CodeQL bundle.")+`", "12": "`+sha256Hex("Another CodeQL bundle for Linux.")+`"}}]`)))
+
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases", func(response http.ResponseWriter, request *http.Request) {
+		releases := []github.RepositoryRelease{}
+		if request.URL.Query().Get("page") == "1" {
+			releases = []github.RepositoryRelease{
+				{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200101")},
+				{ID: github.Int64(3), TagName: github.String("codeql-bundle-20191231")},
+			}
+		}
+		test.ServeHTTPResponseFromObject(t, releases, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/{id:[0-9]+}/assets", func(response http.ResponseWriter, request *http.Request) {
+		require.Equal(t, "1", mux.Vars(request)["id"])
+		assets := []github.ReleaseAsset{}
+		if request.URL.Query().Get("page") == "1" {
+			assets = []github.ReleaseAsset{
+				{ID: github.Int64(11), Name: github.String("codeql-bundle.tar.gz"), Size: github.Int(len("A CodeQL bundle."))},
+				{ID: github.Int64(12), Name: github.String("codeql-bundle-linux.tar.gz"), Size: github.Int(len("A CodeQL bundle for Linux."))},
+				{ID: github.Int64(13), Name: github.String("codeql-bundle-osx.tar.gz"), Size: github.Int(len("A CodeQL bundle for macOS.")), State: github.String("uploaded")},
+				{ID: github.Int64(14), Name: github.String("codeql-bundle.cdx.json"), Size: github.Int(100)},
+				{ID: github.Int64(15), Name: github.String("something-else.txt"), Size: github.Int(5)},
+			}
+		}
+		test.ServeHTTPResponseFromObject(t, assets, response)
+	}).Methods("GET")
+
+	releases, assets, err := pushService.diffReleases()
+	require.NoError(t, err)
+	require.Equal(t, []ReleaseDifference{
+		{Tag: "codeql-bundle-20191231", Difference: DifferenceExtra},
+		{Tag: "codeql-bundle-20200630", Difference: DifferenceMissing},
+	}, releases)
+	// Bundles are compared by checksum where it is known, and generated bills of materials are ignored.
+	require.ElementsMatch(t, []AssetDifference{
+		{Release: "codeql-bundle-20200101", Name: "codeql-bundle-linux.tar.gz", Difference: DifferenceDigest, CacheSize: 26, DestinationSize: 26, CacheSHA256: sha256Hex("A CodeQL bundle for Linux."), DestinationSHA256: sha256Hex("Another CodeQL bundle for Linux.")},
+		{Release: "codeql-bundle-20200101", Name: "codeql-bundle-osx.tar.gz", Difference: DifferenceUnverified, CacheSize: 26, DestinationSize: 26, CacheSHA256: sha256Hex("A CodeQL bundle for macOS.")},
+		{Release: "codeql-bundle-20200101", Name: "codeql-bundle-win.tar.gz", Difference: DifferenceMissing, CacheSize: 28, CacheSHA256: sha256Hex("A CodeQL bundle for Windows.")},
+		{Release: "codeql-bundle-20200101", Name: "something-else.txt", Difference: DifferenceExtra, DestinationSize: 5},
+	}, assets)
+}
+
+func TestWriteDiffText(t *testing.T) {
+	differences := Differences{
+		Cache:       "/cache",
+		Destination: "https://ghe.example.com/github/codeql-action",
+		References:  []ReferenceDifference{{Name: "refs/heads/main", Difference: DifferenceMissing, Cache: "b9f01aa2c50f49898d4c7845a66be8824499fe9d"}},
+		Releases:    []ReleaseDifference{},
+		Assets:      []AssetDifference{{Release: "codeql-bundle-20200101", Name: "codeql-bundle.tar.gz", Difference: DifferenceSize, CacheSize: 16, DestinationSize: 8, CacheSHA256: sha256Hex("A CodeQL bundle.")}},
+	}
+	output := bytes.Buffer{}
+	require.NoError(t, writeDiffText(&differences, &output))
+	require.Equal(t, `Cache: /cache
+Destination: https://ghe.example.com/github/codeql-action
+
+Git references (1):
+  refs/heads/main  missing  b9f01aa2c50f49898d4c7845a66be8824499fe9d  -
+
+Releases (0):
+
+Assets (1):
+  codeql-bundle-20200101/codeql-bundle.tar.gz  size_mismatch  16 `+sha256Hex("A CodeQL bundle.")+`  8 -
+`, output.String())
+	require.False(t, differences.Empty())
+	require.True(t, (&Differences{}).Empty())
+}
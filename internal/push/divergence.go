@@ -0,0 +1,68 @@
+package push
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+)
+
+// divergentReferences finds the references on the destination that would be changed by a push but point to objects the cache does not have. These can only have been pushed to the destination directly, so they would be lost.
+func divergentReferences(gitRepository *git.Repository, remoteReferences []*plumbing.Reference) ([]*plumbing.Reference, error) {
+	result := []*plumbing.Reference{}
+	for _, remoteReference := range remoteReferences {
+		if remoteReference.Type() != plumbing.HashReference || !strings.HasPrefix(remoteReference.Name().String(), "refs/") {
+			continue
+		}
+		localReference, err := gitRepository.Reference(remoteReference.Name(), false)
+		if err != nil && err != plumbing.ErrReferenceNotFound {
+			return nil, errors.Wrapf(err, "Error finding local reference %s.", remoteReference.Name())
+		}
+		if err == nil && localReference.Hash() == remoteReference.Hash() {
+			continue
+		}
+		err = gitRepository.Storer.HasEncodedObject(remoteReference.Hash())
+		if err == plumbing.ErrObjectNotFound {
+			result = append(result, remoteReference)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error checking for object %s in Git repository cache.", remoteReference.Hash())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+func (pushService *pushService) checkDivergence(gitRepository *git.Repository, remoteURL string, remoteReferences []*plumbing.Reference) error {
+	references, err := divergentReferences(gitRepository, remoteReferences)
+	if err != nil {
+		return err
+	}
+	// References that are left alone by the push, such as protected ones, cannot be lost.
+	divergent := []*plumbing.Reference{}
+	for _, reference := range references {
+		if _, err := gitRepository.Reference(reference.Name(), false); err == plumbing.ErrReferenceNotFound && !pushService.deletesReference(reference.Name(), false) {
+			continue
+		}
+		divergent = append(divergent, reference)
+	}
+	if len(divergent) == 0 {
+		return nil
+	}
+	lines := []string{}
+	for _, reference := range divergent {
+		lines = append(lines, fmt.Sprintf("  %s (%s)", reference.Name(), reference.Hash()))
+	}
+	return fmt.Errorf(
+		"The destination repository has commits that are not in the cache, which would be lost by pushing:\n%s\nThese were probably pushed to the destination directly. To review them, run `git fetch %s %s`. If they are not needed, re-run this command without `--safe-push` to overwrite them. Otherwise, keep a copy of them somewhere else first, as the sync tool keeps the destination the same as the CodeQL Action.",
+		strings.Join(lines, "\n"),
+		remoteURL,
+		divergent[0].Name(),
+	)
+}
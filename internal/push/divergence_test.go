@@ -0,0 +1,121 @@
+package push
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+// commitDirectlyToDestination adds a commit on top of `main` in the destination and points the given reference at it, as someone pushing to GitHub Enterprise Server would.
+func commitDirectlyToDestination(t *testing.T, destinationPath string, name plumbing.ReferenceName) plumbing.Hash {
+	repository, err := git.PlainOpen(destinationPath)
+	require.NoError(t, err)
+	reference, err := repository.Reference(plumbing.NewBranchReferenceName("main"), false)
+	require.NoError(t, err)
+	parent, err := repository.CommitObject(reference.Hash())
+	require.NoError(t, err)
+	signature := object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	commit := object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      "A local change.",
+		TreeHash:     parent.TreeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	}
+	encodedObject := repository.Storer.NewEncodedObject()
+	require.NoError(t, commit.Encode(encodedObject))
+	hash, err := repository.Storer.SetEncodedObject(encodedObject)
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(name, hash)))
+	return hash
+}
+
+func TestSafePushAllowsChangesFromTheCache(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	destinationPath := path.Join(temporaryDirectory, "target")
+	_, err := git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+	repository := github.Repository{
+		CloneURL: github.String(destinationPath),
+	}
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	pushService.safePush = true
+	require.NoError(t, pushService.pushGit(&repository, true))
+	require.NoError(t, pushService.pushGit(&repository, false))
+
+	// The modified cache deletes and creates references, but every commit on the destination is still known to it.
+	pushService = getTestPushService(t, "./push_test/action-cache-modified/", "")
+	pushService.safePush = true
+	require.NoError(t, pushService.pushGit(&repository, true))
+	require.NoError(t, pushService.pushGit(&repository, false))
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200101",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200630",
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning/because-it-now-has-this-extra-bit",
+	})
+}
+
+func TestSafePushRefusesToOverwriteCommitsNotInTheCache(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	destinationPath := path.Join(temporaryDirectory, "target")
+	_, err := git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+	repository := github.Repository{
+		CloneURL: github.String(destinationPath),
+	}
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	require.NoError(t, pushService.pushGit(&repository, true))
+	require.NoError(t, pushService.pushGit(&repository, false))
+
+	mainHash := commitDirectlyToDestination(t, destinationPath, plumbing.NewBranchReferenceName("main"))
+	featureHash := commitDirectlyToDestination(t, destinationPath, plumbing.NewBranchReferenceName("local-feature"))
+
+	pushService.safePush = true
+	err = pushService.pushGit(&repository, true)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "refs/heads/local-feature ("+featureHash.String()+")")
+	require.Contains(t, err.Error(), "refs/heads/main ("+mainHash.String()+")")
+	require.Contains(t, err.Error(), "git fetch "+destinationPath+" refs/heads/local-feature")
+	// Nothing has been changed on the destination.
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200101",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200630",
+		mainHash.String() + " refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning",
+		featureHash.String() + " refs/heads/local-feature",
+	})
+
+	// Without safe push the destination is overwritten as before.
+	pushService.safePush = false
+	require.NoError(t, pushService.pushGit(&repository, true))
+	require.NoError(t, pushService.pushGit(&repository, false))
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200101",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200630",
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning",
+	})
+}
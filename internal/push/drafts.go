@@ -0,0 +1,33 @@
+package push
+
+import (
+	"github.com/github/codeql-action-sync/internal/report"
+	log "github.com/sirupsen/logrus"
+)
+
+// findSkippedDrafts finds the draft releases in the cache, which were pulled with `--include-drafts`, so that they are only pushed if `--include-drafts` is given to the push too. Drafts that are pushed stay drafts on the destination.
+func (pushService *pushService) findSkippedDrafts(logSkipped bool) error {
+	pushService.skippedDrafts = map[string]bool{}
+	if pushService.includeDrafts {
+		return nil
+	}
+	releaseNames, err := pushService.cacheDirectory.ListReleases()
+	if err != nil {
+		return err
+	}
+	for _, releaseName := range releaseNames {
+		releaseMetadata, err := pushService.readReleaseMetadata(releaseName)
+		if err != nil {
+			return err
+		}
+		if !releaseMetadata.GetDraft() {
+			continue
+		}
+		pushService.skippedDrafts[releaseName] = true
+		if logSkipped {
+			log.Infof("Not pushing release %s as it is a draft. Use `--include-drafts` to push it as a draft release.", releaseName)
+			report.FromContext(pushService.ctx).RecordRelease(releaseName, report.OutcomeSkipped)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package push
+
+import (
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseNamesSkipsDrafts(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	require.NoError(t, pushService.cacheDirectory.WriteMetadata("codeql-bundle-20200101", []byte(`{"tag_name": "codeql-bundle-20200101"}`)))
+	require.NoError(t, pushService.cacheDirectory.WriteMetadata("codeql-bundle-20200630", []byte(`{"tag_name": "codeql-bundle-20200630", "draft": true}`)))
+
+	require.NoError(t, pushService.findSkippedDrafts(true))
+	releaseNames, err := pushService.releaseNames()
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200101"}, releaseNames)
+
+	pushService.includeDrafts = true
+	require.NoError(t, pushService.findSkippedDrafts(true))
+	releaseNames, err = pushService.releaseNames()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"codeql-bundle-20200101", "codeql-bundle-20200630"}, releaseNames)
+}
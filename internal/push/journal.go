@@ -0,0 +1,197 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Operations on the destination recorded in the push journal.
+const (
+	journalCreateRelease = "create_release"
+	journalUploadAsset   = "upload_asset"
+	journalMarkRelease   = "mark_release"
+)
+
+// journalEntry is a record in the push journal. An intent is recorded before each operation that changes the releases on the destination and a completion once it has been made, so that the next push can find and recover from any operation that a crash or power loss interrupted.
+type journalEntry struct {
+	Destination string `json:"destination"`
+	Operation   string `json:"operation"`
+	Completed   bool   `json:"completed,omitempty"`
+	Release     string `json:"release"`
+	ReleaseID   int64  `json:"release_id,omitempty"`
+	Asset       string `json:"asset,omitempty"`
+	AssetID     int64  `json:"asset_id,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+}
+
+func (entry journalEntry) key() string {
+	return entry.Operation + " " + entry.Release + " " + entry.Asset
+}
+
+func (pushService *pushService) journalDestination() string {
+	return pushService.destinationURL + " " + strings.ToLower(pushService.requestedRepository)
+}
+
+// journal records an intent or completion in the push journal, returning once it has been stored.
+func (pushService *pushService) journal(entry journalEntry) error {
+	entry.Destination = pushService.journalDestination()
+	record, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "Error converting push journal entry to JSON.")
+	}
+	pushService.journalLock.Lock()
+	defer pushService.journalLock.Unlock()
+	err = pushService.cacheDirectory.AppendPushJournal(record)
+	if err != nil {
+		return errors.Wrap(err, "Error writing push journal.")
+	}
+	return nil
+}
+
+// readJournal returns the entries in the push journal for every destination. A record that cannot be read, such as the last one if the sync tool was stopped while writing it, is left out.
+func (pushService *pushService) readJournal() ([]journalEntry, error) {
+	journal, err := pushService.cacheDirectory.ReadPushJournal()
+	if os.IsNotExist(err) {
+		return []journalEntry{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading push journal.")
+	}
+	entries := []journalEntry{}
+	for _, line := range bytes.Split(journal, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		entry := journalEntry{}
+		err := json.Unmarshal(line, &entry)
+		if err != nil {
+			log.Debugf("Ignoring unreadable push journal entry: %s", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (pushService *pushService) writeJournal(entries []journalEntry) error {
+	journal := []byte{}
+	for _, entry := range entries {
+		record, err := json.Marshal(entry)
+		if err != nil {
+			return errors.Wrap(err, "Error converting push journal entry to JSON.")
+		}
+		journal = append(append(journal, record...), '\n')
+	}
+	err := pushService.cacheDirectory.WritePushJournal(journal)
+	if err != nil {
+		return errors.Wrap(err, "Error writing push journal.")
+	}
+	return nil
+}
+
+// recoverJournal finds the operations of an earlier push to the destination that were interrupted, and puts the destination back into a state from which the releases can be pushed as usual. It returns the checksums of the assets that the earlier push finished uploading, which are otherwise only recorded once a push completes, so that they are not uploaded again.
+func (pushService *pushService) recoverJournal() (map[int64]string, error) {
+	entries, err := pushService.readJournal()
+	if err != nil {
+		return nil, err
+	}
+	destination := pushService.journalDestination()
+	kept := []journalEntry{}
+	latest := map[string]journalEntry{}
+	order := []string{}
+	for _, entry := range entries {
+		if entry.Destination != destination {
+			kept = append(kept, entry)
+			continue
+		}
+		if _, seen := latest[entry.key()]; !seen {
+			order = append(order, entry.key())
+		}
+		latest[entry.key()] = entry
+	}
+	uploadedDigests := map[int64]string{}
+	interrupted := []journalEntry{}
+	for _, key := range order {
+		entry := latest[key]
+		if !entry.Completed {
+			interrupted = append(interrupted, entry)
+		} else if entry.Operation == journalUploadAsset {
+			uploadedDigests[entry.AssetID] = entry.SHA256
+			kept = append(kept, entry)
+		}
+	}
+	if len(interrupted) > 0 {
+		log.Warnf("The last push to %s was interrupted, so %d unfinished operation(s) will be recovered before pushing again.", pushService.destinationRepository(), len(interrupted))
+	}
+	for _, entry := range interrupted {
+		switch entry.Operation {
+		case journalCreateRelease:
+			err = pushService.recoverReleaseCreation(entry)
+		case journalUploadAsset:
+			err = pushService.recoverAssetUpload(entry)
+		default:
+			// Marking a release replaces the whole marker, so it is made again from the start as usual.
+			log.Debugf("Marking release %s as up to date was interrupted, so it will be marked again.", entry.Release)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error recovering from interrupted push of release %s.", entry.Release)
+		}
+	}
+	// Only the uploads the earlier push finished are kept, in case this push is interrupted too.
+	err = pushService.writeJournal(kept)
+	if err != nil {
+		return nil, err
+	}
+	return uploadedDigests, nil
+}
+
+// recoverReleaseCreation deletes a release whose creation was interrupted, if it was created, so that it is created again from the start rather than being left half-created. The journal shows that there was no such release beforehand, so one without a marker can only have been created by the interrupted push.
+func (pushService *pushService) recoverReleaseCreation(entry journalEntry) error {
+	release, err := pushService.getDestinationRelease(entry.Release)
+	if err != nil || release == nil || releaseMarkerDigest(release.GetBody()) != "" {
+		return err
+	}
+	log.Infof("Deleting release %s, which the interrupted push only partly created, so that it is created again.", entry.Release)
+	return pushService.deleteRelease(release)
+}
+
+// recoverAssetUpload deletes whatever an interrupted upload left behind, which may be incomplete, so that the asset is uploaded again.
+func (pushService *pushService) recoverAssetUpload(entry journalEntry) error {
+	release := &github.RepositoryRelease{ID: github.Int64(entry.ReleaseID), TagName: github.String(entry.Release)}
+	existingAssets, err := pushService.listReleaseAssets(release)
+	if errorResponse, ok := errors.Cause(err).(*github.ErrorResponse); ok && errorResponse.Response != nil && errorResponse.Response.StatusCode == http.StatusNotFound {
+		// The release has been deleted since, taking the asset with it.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	existingAsset := findExistingAsset(existingAssets, entry.Asset)
+	if existingAsset == nil {
+		return nil
+	}
+	log.Infof("Deleting release asset %s of release %s, which the interrupted push may not have finished uploading, so that it is uploaded again.", entry.Asset, entry.Release)
+	return pushService.deleteReleaseAsset(release, existingAsset)
+}
+
+// clearJournal removes the entries for the destination from the push journal once a push to it has finished, as the record of the destination now covers everything they did.
+func (pushService *pushService) clearJournal() error {
+	entries, err := pushService.readJournal()
+	if err != nil {
+		return err
+	}
+	kept := []journalEntry{}
+	for _, entry := range entries {
+		if entry.Destination != pushService.journalDestination() {
+			kept = append(kept, entry)
+		}
+	}
+	return pushService.writeJournal(kept)
+}
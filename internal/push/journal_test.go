@@ -0,0 +1,114 @@
+package push
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/google/go-github/v32/github"
+)
+
+func TestRecoverJournalWithoutJournal(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	uploadedDigests, err := pushService.recoverJournal()
+	require.NoError(t, err)
+	require.Empty(t, uploadedDigests)
+}
+
+func TestRecoverJournalInterruptedUpload(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalUploadAsset, Release: "codeql-bundle-20200630", ReleaseID: 1, Asset: "codeql-bundle.tar.gz", SHA256: "first"}))
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalUploadAsset, Completed: true, Release: "codeql-bundle-20200630", ReleaseID: 1, Asset: "codeql-bundle.tar.gz", AssetID: 7, SHA256: "first"}))
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalUploadAsset, Release: "codeql-bundle-20200630", ReleaseID: 1, Asset: "codeql-bundle-linux64.tar.gz", SHA256: "second"}))
+
+	deleted := []string{}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Query().Get("page") != "1" {
+			test.ServeHTTPResponseFromObject(t, []github.ReleaseAsset{}, response)
+			return
+		}
+		test.ServeHTTPResponseFromObject(t, []github.ReleaseAsset{
+			{ID: github.Int64(7), Name: github.String("codeql-bundle.tar.gz")},
+			{ID: github.Int64(8), Name: github.String("codeql-bundle-linux64.tar.gz")},
+		}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/assets/{id:[0-9]+}", func(response http.ResponseWriter, request *http.Request) {
+		deleted = append(deleted, request.URL.Path)
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	uploadedDigests, err := pushService.recoverJournal()
+	require.NoError(t, err)
+	// Only the asset whose upload was interrupted is deleted, and the finished upload is not made again.
+	require.Equal(t, []string{"/api/v3/repos/destination-repository-owner/destination-repository-name/releases/assets/8"}, deleted)
+	require.Equal(t, map[int64]string{7: "first"}, uploadedDigests)
+
+	// The finished upload is kept in the journal in case this push is interrupted too.
+	entries, err := pushService.readJournal()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, int64(7), entries[0].AssetID)
+}
+
+func TestRecoverJournalInterruptedReleaseCreation(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalCreateRelease, Release: "codeql-bundle-20200101"}))
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalCreateRelease, Release: "codeql-bundle-20200630"}))
+
+	deleted := []string{}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/tags/codeql-bundle-20200101", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.RepositoryRelease{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200101")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/tags/codeql-bundle-20200630", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/{id:[0-9]+}", func(response http.ResponseWriter, request *http.Request) {
+		deleted = append(deleted, request.URL.Path)
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	uploadedDigests, err := pushService.recoverJournal()
+	require.NoError(t, err)
+	require.Empty(t, uploadedDigests)
+	require.Equal(t, []string{"/api/v3/repos/destination-repository-owner/destination-repository-name/releases/1"}, deleted)
+	_, err = pushService.cacheDirectory.ReadPushJournal()
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRecoverJournalKeepsMarkedRelease(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalCreateRelease, Release: "codeql-bundle-20200630"}))
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/tags/codeql-bundle-20200630", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.RepositoryRelease{ID: github.Int64(1), Body: github.String(withReleaseMarker("", strings.Repeat("0", 64)))}, response)
+	}).Methods("GET")
+	// No handler is registered for deleting the release, so recovering fails if it is deleted.
+	_, err := pushService.recoverJournal()
+	require.NoError(t, err)
+}
+
+func TestClearJournalKeepsOtherDestinations(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	pushService.destinationURL = "https://ghe.example.com"
+	pushService.requestedRepository = "first/repository"
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalUploadAsset, Completed: true, Release: "codeql-bundle-20200630", AssetID: 7}))
+	pushService.requestedRepository = "second/repository"
+	require.NoError(t, pushService.journal(journalEntry{Operation: journalUploadAsset, Completed: true, Release: "codeql-bundle-20200630", AssetID: 8}))
+
+	require.NoError(t, pushService.clearJournal())
+	entries, err := pushService.readJournal()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "https://ghe.example.com first/repository", entries[0].Destination)
+}
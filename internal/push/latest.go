@@ -0,0 +1,121 @@
+package push
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/github/codeql-action-sync/internal/audit"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// makeLatestRequest marks a release as the latest release of a repository. The version of the GitHub API client in use does not know about this field yet.
+type makeLatestRequest struct {
+	MakeLatest string `json:"make_latest"`
+}
+
+// latestRelease returns the tag of the release being pushed that should be the latest release of the destination, or an empty string if there is none. This is the release the source marks as latest if it has been pulled, and otherwise the most recently published release that is neither a draft nor a prerelease.
+func (pushService *pushService) latestRelease() (string, error) {
+	sourceLatestRelease, err := pushService.cacheDirectory.ReadLatestRelease()
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading latest release from cache.")
+	}
+	releaseNames, err := pushService.releaseNames()
+	if err != nil {
+		return "", err
+	}
+	var latest *github.RepositoryRelease
+	for _, releaseName := range releaseNames {
+		if releaseName == sourceLatestRelease {
+			return releaseName, nil
+		}
+		releaseMetadata, err := pushService.readReleaseMetadata(releaseName)
+		if err != nil {
+			return "", err
+		}
+		if releaseMetadata.GetDraft() || releaseMetadata.GetPrerelease() || releaseMetadata.PublishedAt == nil {
+			continue
+		}
+		if latest == nil || releaseMetadata.GetPublishedAt().After(latest.GetPublishedAt().Time) {
+			latest = releaseMetadata
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	return latest.GetTagName(), nil
+}
+
+// destinationLatestRelease returns the tag of the latest release of the destination, or an empty string if it has none.
+func (pushService *pushService) destinationLatestRelease() (string, error) {
+	var release *github.RepositoryRelease
+	err := retry.Do(pushService.ctx, "checking latest release", func(attempt int) error {
+		var response *github.Response
+		var err error
+		release, response, err = pushService.githubEnterpriseClient.Repositories.GetLatestRelease(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName)
+		if err != nil && response != nil && response.StatusCode == http.StatusNotFound {
+			release = nil
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "Error checking latest release.")
+	}
+	return release.GetTagName(), nil
+}
+
+// updateLatestRelease marks the same release as latest on the destination as on the source. Creating a release makes it the latest, so without this whichever release was pushed last would be shown as the latest, which some automation relies on.
+func (pushService *pushService) updateLatestRelease() error {
+	latestRelease, err := pushService.latestRelease()
+	if err != nil {
+		return err
+	}
+	if latestRelease == "" {
+		log.Debug("There is no latest release to mark on the destination.")
+		return nil
+	}
+	destinationLatestRelease, err := pushService.destinationLatestRelease()
+	if err != nil {
+		return err
+	}
+	if destinationLatestRelease == latestRelease {
+		log.Debugf("Release %s is already the latest release.", latestRelease)
+		return nil
+	}
+	release, err := pushService.getDestinationRelease(latestRelease)
+	if err != nil {
+		return err
+	}
+	if release == nil {
+		return fmt.Errorf("The release %s was not found on the destination, so it could not be marked as the latest release.", latestRelease)
+	}
+	log.Debugf("Marking release %s as the latest release...", latestRelease)
+	err = retry.Do(pushService.ctx, "marking release "+latestRelease+" as latest", func(attempt int) error {
+		request, err := pushService.githubEnterpriseClient.NewRequest(http.MethodPatch, fmt.Sprintf("repos/%s/%s/releases/%d", pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID()), makeLatestRequest{MakeLatest: "true"})
+		if err != nil {
+			return err
+		}
+		_, err = pushService.githubEnterpriseClient.Do(pushService.ctx, request, nil)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error marking release as latest.")
+	}
+	err = pushService.audit(audit.ActionUpdateRelease, pushService.destinationRepository()+" "+latestRelease, map[string]string{"id": strconv.FormatInt(release.GetID(), 10), "latest": "true"})
+	if err != nil {
+		return err
+	}
+	// Older versions of GitHub Enterprise Server ignore the request and work out the latest release for themselves.
+	destinationLatestRelease, err = pushService.destinationLatestRelease()
+	if err != nil {
+		return err
+	}
+	if destinationLatestRelease != latestRelease {
+		log.Warnf("The destination did not mark release %s as its latest release, possibly because this version of GitHub Enterprise Server does not support choosing the latest release.", latestRelease)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestLatestReleases(t *testing.T, cacheDirectory *cachedirectory.CacheDirectory) {
+	releases := []github.RepositoryRelease{
+		{TagName: github.String("codeql-bundle-20200101"), PublishedAt: &github.Timestamp{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{TagName: github.String("codeql-bundle-20200630"), PublishedAt: &github.Timestamp{Time: time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)}},
+		{TagName: github.String("codeql-bundle-20200701"), PublishedAt: &github.Timestamp{Time: time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)}, Prerelease: github.Bool(true)},
+	}
+	for _, release := range releases {
+		metadata, err := json.Marshal(release)
+		require.NoError(t, err)
+		require.NoError(t, cacheDirectory.WriteMetadata(release.GetTagName(), metadata))
+	}
+}
+
+func TestLatestRelease(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+
+	// Without a latest release from the source, the most recently published release that is not a prerelease is used.
+	writeTestLatestReleases(t, &pushService.cacheDirectory)
+	latestRelease, err := pushService.latestRelease()
+	require.NoError(t, err)
+	require.Equal(t, "codeql-bundle-20200630", latestRelease)
+
+	require.NoError(t, pushService.cacheDirectory.WriteLatestRelease("codeql-bundle-20200101"))
+	latestRelease, err = pushService.latestRelease()
+	require.NoError(t, err)
+	require.Equal(t, "codeql-bundle-20200101", latestRelease)
+
+	// A latest release that was not pulled is ignored.
+	require.NoError(t, pushService.cacheDirectory.WriteLatestRelease("codeql-bundle-20191231"))
+	latestRelease, err = pushService.latestRelease()
+	require.NoError(t, err)
+	require.Equal(t, "codeql-bundle-20200630", latestRelease)
+}
+
+func TestUpdateLatestRelease(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	writeTestLatestReleases(t, &pushService.cacheDirectory)
+	destinationLatestRelease := github.RepositoryRelease{ID: github.Int64(3), TagName: github.String("codeql-bundle-20200701")}
+	edits := 0
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/latest", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, destinationLatestRelease, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/tags/codeql-bundle-20200630", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.RepositoryRelease{ID: github.Int64(2), TagName: github.String("codeql-bundle-20200630")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/2", func(response http.ResponseWriter, request *http.Request) {
+		edit := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&edit))
+		// Only the latest release is changed, leaving the release notes and everything else alone.
+		require.Equal(t, map[string]interface{}{"make_latest": "true"}, edit)
+		edits++
+		destinationLatestRelease = github.RepositoryRelease{ID: github.Int64(2), TagName: github.String("codeql-bundle-20200630")}
+		test.ServeHTTPResponseFromObject(t, destinationLatestRelease, response)
+	}).Methods("PATCH")
+
+	require.NoError(t, pushService.updateLatestRelease())
+	require.Equal(t, 1, edits)
+
+	// The destination already has the right latest release, so nothing is changed.
+	require.NoError(t, pushService.updateLatestRelease())
+	require.Equal(t, 1, edits)
+}
@@ -0,0 +1,121 @@
+package push
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/audit"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/github/codeql-action-sync/internal/sbom"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The marker is an HTML comment at the end of the release notes, so it is not shown when they are rendered.
+const releaseMarkerPrefix = "<!-- codeql-action-sync-digest: "
+const releaseMarkerSuffix = " -->"
+
+var releaseMarkerPattern = regexp.MustCompile(`\n*` + regexp.QuoteMeta(releaseMarkerPrefix) + `([0-9a-f]{64})` + regexp.QuoteMeta(releaseMarkerSuffix) + `\s*$`)
+
+// releaseMarkerDigest returns the digest recorded in the marker of a release's notes, or an empty string if there is no marker.
+func releaseMarkerDigest(body string) string {
+	match := releaseMarkerPattern.FindStringSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func withoutReleaseMarker(body string) string {
+	return releaseMarkerPattern.ReplaceAllString(body, "")
+}
+
+// withReleaseMarker replaces any marker in the release notes with one recording the given digest.
+func withReleaseMarker(body string, digest string) string {
+	return withoutReleaseMarker(body) + "\n\n" + releaseMarkerPrefix + digest + releaseMarkerSuffix
+}
+
+// releaseTagHash returns what the tag of a release points to in the cache, or an empty string if the tag is not in the cache.
+func (pushService *pushService) releaseTagHash(metadata *github.RepositoryRelease) (string, error) {
+	gitRepository, err := pushService.openGitRepository()
+	if err != nil {
+		return "", err
+	}
+	tag, err := gitRepository.Reference(plumbing.NewTagReferenceName(metadata.GetTagName()), false)
+	if err == plumbing.ErrReferenceNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "Error finding tag for release %s.", metadata.GetTagName())
+	}
+	return tag.Hash().String(), nil
+}
+
+// releaseDigest summarises everything that is pushed for a release: its metadata, the commit its tag points to, the checksum of each asset and any bills of materials or attestation generated for it. If the digest in the marker on the destination matches, the release need not be pushed again.
+func (pushService *pushService) releaseDigest(metadata *github.RepositoryRelease, uploads []assetUpload, statement []byte) (string, error) {
+	tagHash, err := pushService.releaseTagHash(metadata)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{
+		"tag " + metadata.GetTagName() + " " + tagHash,
+		"name " + metadata.GetName(),
+		"body " + strconv.Quote(metadata.GetBody()),
+		fmt.Sprintf("draft %t prerelease %t", metadata.GetDraft(), metadata.GetPrerelease()),
+	}
+	assetLines := []string{}
+	for _, upload := range uploads {
+		assetLine := fmt.Sprintf("asset %s %d %s", upload.asset.Name, upload.asset.Size, upload.digest)
+		// Labels were not always pushed, so the digests of releases without them are left as they were.
+		if upload.label != "" {
+			assetLine += " label " + strconv.Quote(upload.label)
+		}
+		assetLines = append(assetLines, assetLine)
+	}
+	sort.Strings(assetLines)
+	if pushService.sbom {
+		// Bills of materials only depend on the bundles, which are already covered by their checksums.
+		assetLines = append(assetLines, "sbom "+sbom.Format)
+	}
+	if statement != nil {
+		attestationLine, err := pushService.attestationDigestLine(statement)
+		if err != nil {
+			return "", err
+		}
+		assetLines = append(assetLines, attestationLine)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(append(lines, assetLines...), "\n")))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// markRelease records the digest of a release on the destination once all of its assets have been pushed.
+func (pushService *pushService) markRelease(release *github.RepositoryRelease, body string, digest string) error {
+	log.Debugf("Marking release %s as up to date...", release.GetTagName())
+	entry := journalEntry{Operation: journalMarkRelease, Release: release.GetTagName(), ReleaseID: release.GetID()}
+	err := pushService.journal(entry)
+	if err != nil {
+		return err
+	}
+	err = retry.Do(pushService.ctx, "marking release "+release.GetTagName()+" as up to date", func(attempt int) error {
+		_, _, err := pushService.githubEnterpriseClient.Repositories.EditRelease(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID(), &github.RepositoryRelease{
+			Body: github.String(withReleaseMarker(body, digest)),
+		})
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error marking release as up to date.")
+	}
+	entry.Completed = true
+	err = pushService.journal(entry)
+	if err != nil {
+		return err
+	}
+	return pushService.audit(audit.ActionUpdateRelease, pushService.destinationRepository()+" "+release.GetTagName(), map[string]string{"id": strconv.FormatInt(release.GetID(), 10), "digest": digest})
+}
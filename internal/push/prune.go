@@ -0,0 +1,312 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	usererrors "errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/audit"
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const errorPruneWithoutPolicy = "The prune command needs `--keep-last` or `--since` to know which releases to keep."
+const errorPruneLastSync = "The prune command cannot be used with `--since " + retention.SinceLastSync + "`. Give a date instead."
+const errorPruneNotCreatedBySyncTool = "The destination repository was not created with the CodeQL Action sync tool. If you are sure you want to delete releases from it, re-run this command with the `--force` flag."
+
+// bundleReleasePrefix starts the tag of every CodeQL bundle release. Other releases on the destination are never pruned.
+const bundleReleasePrefix = "codeql-bundle"
+
+// pruneRepository checks that the destination repository exists and was pushed to by the sync tool, returning nil if there is nothing to prune.
+func (pushService *pushService) pruneRepository(force bool) (*github.Repository, error) {
+	user, response, err := pushService.githubEnterpriseClient.Users.Get(pushService.ctx, "")
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusUnauthorized {
+			return nil, exitcode.WithCode(usererrors.New(errorInvalidDestinationToken), exitcode.Authentication)
+		}
+		return nil, errors.Wrap(err, "Error getting current user.")
+	}
+	pushService.actor = user.GetLogin()
+	err = pushService.resolvePreviousDestination()
+	if err != nil {
+		return nil, err
+	}
+	repository, response, err := pushService.githubEnterpriseClient.Repositories.Get(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName)
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "Error checking if destination repository exists.")
+	}
+	pushService.useCanonicalRepository(repository.GetFullName())
+	if repository.GetHomepage() == DefaultRepositoryHomepage || force {
+		return repository, nil
+	}
+	// A repository created with a custom homepage is recognized by its ID from when the cache was pushed to it.
+	destinations, err := pushService.readDestinations()
+	if err != nil {
+		return nil, err
+	}
+	for _, destination := range destinations {
+		if pushService.isRequestedDestination(destination) && destination.ID == repository.GetID() {
+			return repository, nil
+		}
+	}
+	return nil, usererrors.New(errorPruneNotCreatedBySyncTool)
+}
+
+func (pushService *pushService) listDestinationReleases() ([]*github.RepositoryRelease, error) {
+	existingReleases := []*github.RepositoryRelease{}
+	for page := 1; ; page++ {
+		var releases []*github.RepositoryRelease
+		err := retry.Do(pushService.ctx, "fetching existing releases", func(attempt int) error {
+			var err error
+			releases, _, err = pushService.githubEnterpriseClient.Repositories.ListReleases(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, &github.ListOptions{Page: page})
+			return err
+		})
+		if sizeErr := githubapiutil.AssetSizeError(err); sizeErr != nil {
+			return nil, sizeErr
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error fetching existing releases.")
+		}
+		if len(releases) == 0 {
+			return existingReleases, nil
+		}
+		existingReleases = append(existingReleases, releases...)
+	}
+}
+
+// prunedReleases returns the CodeQL bundle or CodeQL CLI releases on the destination that the retention policy does not keep. The bundles that `main` and each major version of the CodeQL Action in the cache use now, or the latest CodeQL CLI release in the cache, are always kept, however old they are.
+func (pushService *pushService) prunedReleases(releases []*github.RepositoryRelease) ([]*github.RepositoryRelease, error) {
+	inUse, err := pushService.recentReleases(1)
+	if err != nil {
+		return nil, err
+	}
+	recent := inUse
+	if pushService.retention.KeepLast > 1 {
+		recent, err = pushService.recentReleases(pushService.retention.KeepLast)
+		if err != nil {
+			return nil, err
+		}
+	}
+	inUseMap := map[string]bool{}
+	for _, releaseName := range inUse {
+		inUseMap[releaseName] = true
+	}
+	recentMap := map[string]bool{}
+	for _, releaseName := range recent {
+		recentMap[releaseName] = true
+	}
+	pruned := []*github.RepositoryRelease{}
+	for _, release := range releases {
+		releaseName := release.GetTagName()
+		if !strings.HasPrefix(releaseName, pushService.releasePrefix()) || inUseMap[releaseName] {
+			continue
+		}
+		if pushService.retention.KeepLast > 0 && !recentMap[releaseName] {
+			log.Debugf("Pruning release %s as it is no longer one of the most recent releases.", releaseName)
+			pruned = append(pruned, release)
+			continue
+		}
+		if retention.Excludes(pushService.retention.Since, publicationDate(release)) {
+			log.Debugf("Pruning release %s as it was published before %s.", releaseName, pushService.retention.Since.Format(time.RFC3339))
+			pruned = append(pruned, release)
+		}
+	}
+	return pruned, nil
+}
+
+func (pushService *pushService) deleteRelease(release *github.RepositoryRelease) error {
+	log.Debugf("Deleting release %s...", release.GetTagName())
+	err := retry.Do(pushService.ctx, "deleting release "+release.GetTagName(), func(attempt int) error {
+		response, err := pushService.githubEnterpriseClient.Repositories.DeleteRelease(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID())
+		// If an earlier attempt timed out after the release was deleted, it is already gone.
+		if err != nil && attempt > 1 && response != nil && response.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error deleting release.")
+	}
+	report.FromContext(pushService.ctx).RecordRelease(release.GetTagName(), report.OutcomeRemoved)
+	return pushService.audit(audit.ActionDeleteRelease, pushService.destinationRepository()+" "+release.GetTagName(), map[string]string{"id": strconv.FormatInt(release.GetID(), 10)})
+}
+
+// deleteReleaseTag deletes the tag of a release, which is left behind when the release itself is deleted.
+func (pushService *pushService) deleteReleaseTag(release *github.RepositoryRelease) error {
+	referenceName := "refs/tags/" + release.GetTagName()
+	log.Debugf("Deleting Git reference %s...", referenceName)
+	err := retry.Do(pushService.ctx, "deleting Git reference "+referenceName, func(attempt int) error {
+		response, err := pushService.githubEnterpriseClient.Git.DeleteRef(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, referenceName)
+		// Deleting a Git reference that does not exist fails, including when an earlier attempt already deleted it.
+		if err != nil && response != nil && response.StatusCode == http.StatusUnprocessableEntity {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error deleting Git reference.")
+	}
+	return pushService.audit(audit.ActionDeleteReference, pushService.destinationRepository()+" "+referenceName, nil)
+}
+
+// forgetPrunedReleases removes pruned releases from the record of what was pushed to the destination, so that they are not reported as still being there.
+func (pushService *pushService) forgetPrunedReleases(pruned []*github.RepositoryRelease) error {
+	prunedMap := map[string]bool{}
+	for _, release := range pruned {
+		prunedMap[release.GetTagName()] = true
+	}
+	destinations, err := pushService.readDestinations()
+	if err != nil {
+		return err
+	}
+	for index, destination := range destinations {
+		if !pushService.isRequestedDestination(destination) {
+			continue
+		}
+		releases := []string{}
+		for _, releaseName := range destination.Releases {
+			if !prunedMap[releaseName] {
+				releases = append(releases, releaseName)
+			}
+		}
+		destinations[index].Releases = releases
+	}
+	destinationsJSON, err := json.Marshal(destinations)
+	if err != nil {
+		return errors.Wrap(err, "Error converting push destinations to JSON.")
+	}
+	err = pushService.cacheDirectory.WriteDestinations(destinationsJSON)
+	if err != nil {
+		return errors.Wrap(err, "Error writing push destinations.")
+	}
+	return nil
+}
+
+// pruneReleases deletes the given releases from the destination, and their tags if asked to.
+func (pushService *pushService) pruneReleases(pruned []*github.RepositoryRelease, deleteTags bool) error {
+	deleted := []*github.RepositoryRelease{}
+	var err error
+	for _, release := range pruned {
+		err = pushService.deleteRelease(release)
+		if err == nil && deleteTags {
+			err = pushService.deleteReleaseTag(release)
+		}
+		if err != nil {
+			break
+		}
+		deleted = append(deleted, release)
+	}
+	// The releases that were deleted are forgotten even if a later one could not be.
+	forgetErr := pushService.forgetPrunedReleases(deleted)
+	if err != nil {
+		return err
+	}
+	return forgetErr
+}
+
+// Prune deletes the CodeQL bundle releases on the destination that the retention policy no longer keeps, and optionally their tags. The cache is used to find which bundles the CodeQL Action uses, which are always kept.
+func Prune(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, destinationURL string, destinationToken string, destinationRepository string, retentionPolicy retention.Policy, force bool, deleteTags bool, dryRun bool) error {
+	if retentionPolicy.LastSync {
+		return usererrors.New(errorPruneLastSync)
+	}
+	if retentionPolicy.KeepLast == 0 && retentionPolicy.Since.IsZero() {
+		return usererrors.New(errorPruneWithoutPolicy)
+	}
+	err := cacheDirectory.CheckOrCreateVersionFile(false, version.Version())
+	if err != nil {
+		return err
+	}
+	runLock, err := cacheDirectory.AcquireRunLock()
+	if err != nil {
+		return err
+	}
+	defer runLock.Release()
+	err = cacheDirectory.CheckLock()
+	if err != nil {
+		return err
+	}
+	err = cacheDirectory.LoadGit()
+	if err != nil {
+		return err
+	}
+
+	destinationURL = strings.TrimRight(destinationURL, "/")
+	token := oauth2.Token{AccessToken: destinationToken}
+	tokenClient := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, retry.NewClient(nil)), oauth2.StaticTokenSource(&token))
+	client, err := githubapiutil.NewClient(destinationURL, tokenClient)
+	if err != nil {
+		return errors.Wrap(err, "Error creating GitHub Enterprise client.")
+	}
+
+	destinationRepositorySplit := strings.Split(destinationRepository, "/")
+	pushService := pushService{
+		ctx:                        ctx,
+		cacheDirectory:             cacheDirectory,
+		auditLog:                   audit.FromContext(ctx),
+		githubEnterpriseClient:     client,
+		destinationURL:             destinationURL,
+		requestedRepository:        destinationRepository,
+		destinationRepositoryOwner: destinationRepositorySplit[0],
+		destinationRepositoryName:  destinationRepositorySplit[1],
+		destinationToken:           &token,
+		retention:                  retentionPolicy,
+	}
+	pushService.sourceKind, err = cacheDirectory.ReadSourceKind()
+	if err != nil {
+		return errors.Wrap(err, "Error reading source kind from cache.")
+	}
+	if pushService.sourceKind == cachedirectory.SourceKindQueries {
+		log.Info("The cache holds the CodeQL queries and libraries, which have no releases, so there is nothing to prune.")
+		return nil
+	}
+
+	repository, err := pushService.pruneRepository(force)
+	if err != nil {
+		return err
+	}
+	if repository == nil {
+		log.Infof("The destination repository %s does not exist, so there is nothing to prune.", destinationRepository)
+		return nil
+	}
+	releases, err := pushService.listDestinationReleases()
+	if err != nil {
+		return err
+	}
+	pruned, err := pushService.prunedReleases(releases)
+	if err != nil {
+		return err
+	}
+	if len(pruned) == 0 {
+		log.Infof("There are no releases to prune from %s.", pushService.destinationRepository())
+		return nil
+	}
+	if dryRun {
+		for _, release := range pruned {
+			log.Infof("Would delete release %s.", release.GetTagName())
+		}
+		return nil
+	}
+	// From here on some releases may have been deleted, so any failure leaves the rest until prune is run again.
+	err = pushService.pruneReleases(pruned, deleteTags)
+	if err != nil {
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
+	}
+	log.Infof("Finished pruning %d releases from %s!", len(pruned), pushService.destinationRepository())
+	return nil
+}
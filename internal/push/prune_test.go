@@ -0,0 +1,137 @@
+package push
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestPruneGit creates a cache Git repository where `main` uses codeql-bundle-20200701 after codeql-bundle-20200630, and `v1` still uses codeql-bundle-20200101.
+func writeTestPruneGit(t *testing.T, gitPath string) {
+	repository, err := git.PlainInit(gitPath, false)
+	require.NoError(t, err)
+	worktree, err := repository.Worktree()
+	require.NoError(t, err)
+	commits := []plumbing.Hash{}
+	for _, bundleVersion := range []string{"codeql-bundle-20200101", "codeql-bundle-20200630", "codeql-bundle-20200701"} {
+		require.NoError(t, util.WriteFile(worktree.Filesystem, filepath.Join("src", "defaults.json"), []byte(`{"bundleVersion": "`+bundleVersion+`"}`), 0644))
+		_, err = worktree.Add("src/defaults.json")
+		require.NoError(t, err)
+		hash, err := worktree.Commit("Update defaults.", &git.CommitOptions{Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}})
+		require.NoError(t, err)
+		commits = append(commits, hash)
+	}
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), commits[2])))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("v1"), commits[0])))
+}
+
+func testPruneDestinationReleases() []*github.RepositoryRelease {
+	pushedAt := &github.Timestamp{Time: time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)}
+	return []*github.RepositoryRelease{
+		{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200101"), PublishedAt: pushedAt, Body: github.String("_Originally published on 2020-01-01 at 00:00 UTC._")},
+		{ID: github.Int64(2), TagName: github.String("codeql-bundle-20200630"), PublishedAt: pushedAt, Body: github.String("Some release notes.\n\n_Originally published on 2020-06-30 at 12:00 UTC._\n\n" + releaseMarkerPrefix + "0000000000000000000000000000000000000000000000000000000000000000" + releaseMarkerSuffix)},
+		{ID: github.Int64(3), TagName: github.String("codeql-bundle-20200701"), PublishedAt: pushedAt, Body: github.String("_Originally published on 2020-07-01 at 00:00 UTC._")},
+		{ID: github.Int64(4), TagName: github.String("codeql-bundle-20200501"), PublishedAt: pushedAt},
+		{ID: github.Int64(5), TagName: github.String("some-other-release"), PublishedAt: &github.Timestamp{Time: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+}
+
+func prunedReleaseNames(releases []*github.RepositoryRelease) []string {
+	releaseNames := []string{}
+	for _, release := range releases {
+		releaseNames = append(releaseNames, release.GetTagName())
+	}
+	return releaseNames
+}
+
+func TestPrunedReleases(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	writeTestPruneGit(t, pushService.cacheDirectory.GitPath())
+	releases := testPruneDestinationReleases()
+
+	pushService.retention = retention.Policy{KeepLast: 1}
+	pruned, err := pushService.prunedReleases(releases)
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200630", "codeql-bundle-20200501"}, prunedReleaseNames(pruned))
+
+	pushService.retention = retention.Policy{KeepLast: 2}
+	pruned, err = pushService.prunedReleases(releases)
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200501"}, prunedReleaseNames(pruned))
+
+	// The date in the release notes is used rather than the date the release was pushed, and bundles still in use are kept however old they are.
+	pushService.retention = retention.Policy{Since: time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)}
+	pruned, err = pushService.prunedReleases(releases)
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200630"}, prunedReleaseNames(pruned))
+}
+
+func TestPruneReleases(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.destinationURL = githubEnterpriseURL
+	pushService.requestedRepository = pushService.destinationRepository()
+	require.NoError(t, pushService.cacheDirectory.WriteDestinations([]byte(`[{"url": "`+githubEnterpriseURL+`", "requested_repository": "destination-repository-owner/destination-repository-name", "releases": ["codeql-bundle-20200630", "codeql-bundle-20200701"]}]`)))
+	deletedReleases := 0
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/2", func(response http.ResponseWriter, request *http.Request) {
+		deletedReleases++
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+	deletedTags := 0
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/git/refs/tags/codeql-bundle-20200630", func(response http.ResponseWriter, request *http.Request) {
+		deletedTags++
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	release := &github.RepositoryRelease{ID: github.Int64(2), TagName: github.String("codeql-bundle-20200630")}
+	require.NoError(t, pushService.pruneReleases([]*github.RepositoryRelease{release}, false))
+	require.Equal(t, 1, deletedReleases)
+	require.Equal(t, 0, deletedTags)
+	pushedReleases, err := PushedReleases(pushService.cacheDirectory, githubEnterpriseURL, pushService.destinationRepository())
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200701"}, pushedReleases)
+
+	require.NoError(t, pushService.pruneReleases([]*github.RepositoryRelease{release}, true))
+	require.Equal(t, 2, deletedReleases)
+	require.Equal(t, 1, deletedTags)
+}
+
+func TestPruneRepositoryNotCreatedBySyncTool(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{ID: github.Int64(42), FullName: github.String("destination-repository-owner/destination-repository-name")}, response)
+	}).Methods("GET")
+
+	_, err := pushService.pruneRepository(false)
+	require.EqualError(t, err, errorPruneNotCreatedBySyncTool)
+
+	repository, err := pushService.pruneRepository(true)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), repository.GetID())
+}
+
+func TestPruneNeedsPolicy(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	err := Prune(pushService.ctx, pushService.cacheDirectory, "https://ghe.example.com", "token", "destination-repository-owner/destination-repository-name", retention.Policy{}, false, false, false)
+	require.EqualError(t, err, errorPruneWithoutPolicy)
+	err = Prune(pushService.ctx, pushService.cacheDirectory, "https://ghe.example.com", "token", "destination-repository-owner/destination-repository-name", retention.Policy{LastSync: true}, false, false, false)
+	require.EqualError(t, err, errorPruneLastSync)
+}
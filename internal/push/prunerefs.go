@@ -0,0 +1,75 @@
+package push
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+)
+
+const errorKeptReferenceConflict = "The Git reference %s on the destination is kept, as it is protected with `--protected-refs` or `--prune-refs=false` was given, but Git cannot have both it and %s from the cache. Delete %s from the destination, or stop keeping it, and then push again."
+
+// protectedRefPatterns converts the patterns given with `--protected-refs`, in which `*` matches any characters, including `/`, and `?` matches any single character, as with `--include-refs`.
+func protectedRefPatterns(patterns []string) []*regexp.Regexp {
+	result := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		expression := regexp.QuoteMeta(pattern)
+		expression = strings.ReplaceAll(expression, `\*`, ".*")
+		expression = strings.ReplaceAll(expression, `\?`, ".")
+		result = append(result, regexp.MustCompile("^"+expression+"$"))
+	}
+	return result
+}
+
+// protectsReference returns whether a reference on the destination matches one of the patterns given with `--protected-refs`, either by its full name, such as `refs/heads/local-fixes`, or by its branch or tag name, such as `local-fixes`.
+func (pushService *pushService) protectsReference(name plumbing.ReferenceName) bool {
+	shortName := strings.TrimPrefix(strings.TrimPrefix(name.String(), "refs/heads/"), "refs/tags/")
+	for _, pattern := range pushService.protectedRefs {
+		if pattern.MatchString(name.String()) || pattern.MatchString(shortName) {
+			return true
+		}
+	}
+	return false
+}
+
+// deletesReference returns whether a push deletes a reference on the destination. References no longer in the cache, such as branches deleted or renamed upstream, are deleted unless `--prune-refs=false` is given, and branches and tags of the cache that are not pushed are deleted too. Protected references are never deleted.
+func (pushService *pushService) deletesReference(name plumbing.ReferenceName, inCache bool) bool {
+	if pushService.protectsReference(name) {
+		return false
+	}
+	if !inCache {
+		return !pushService.keepStaleRefs
+	}
+	return (name.IsBranch() || name.IsTag()) && !pushService.pushesReference(name)
+}
+
+// checkKeptReferences fails if a reference that is kept on the destination has a name that clashes with one from the cache, such as `refs/heads/feature` and `refs/heads/feature/v2` after a branch is renamed upstream, as the push would otherwise fail with an error from Git that does not say why.
+func (pushService *pushService) checkKeptReferences(gitRepository *git.Repository, keptReferences []plumbing.ReferenceName) error {
+	if len(keptReferences) == 0 {
+		return nil
+	}
+	references, err := gitRepository.References()
+	if err != nil {
+		return errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	defer references.Close()
+	return references.ForEach(func(reference *plumbing.Reference) error {
+		if (reference.Name().IsBranch() || reference.Name().IsTag()) && !pushService.pushesReference(reference.Name()) {
+			return nil
+		}
+		name := reference.Name().String()
+		for _, kept := range keptReferences {
+			if strings.HasPrefix(name, kept.String()+"/") || strings.HasPrefix(kept.String(), name+"/") {
+				return fmt.Errorf(errorKeptReferenceConflict, kept, name, kept)
+			}
+		}
+		return nil
+	})
+}
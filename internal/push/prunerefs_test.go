@@ -0,0 +1,89 @@
+package push
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+
+	"github.com/google/go-github/v32/github"
+)
+
+func TestDeletesReference(t *testing.T) {
+	pushService := pushService{protectedRefs: protectedRefPatterns([]string{"local/*", "refs/tags/internal-?"})}
+	require.True(t, pushService.deletesReference("refs/heads/renamed-upstream", false))
+	require.False(t, pushService.deletesReference("refs/heads/renamed-upstream", true))
+	require.False(t, pushService.deletesReference("refs/heads/local/fixes", false))
+	require.False(t, pushService.deletesReference("refs/tags/internal-1", false))
+	require.True(t, pushService.deletesReference("refs/tags/internal-10", false))
+
+	pushService.keepStaleRefs = true
+	require.False(t, pushService.deletesReference("refs/heads/renamed-upstream", false))
+
+	// Branches that are not pushed with `--release-refs-only` are still deleted, unless they are protected.
+	pushService.releaseRefsOnly = true
+	require.True(t, pushService.deletesReference("refs/heads/feature", true))
+	require.False(t, pushService.deletesReference("refs/heads/local/feature", true))
+	require.False(t, pushService.deletesReference("refs/heads/main", true))
+}
+
+func TestPushGitKeepsProtectedAndStaleRefs(t *testing.T) {
+	for _, keepStaleRefs := range []bool{false, true} {
+		temporaryDirectory := test.CreateTemporaryDirectory(t)
+		destinationPath := path.Join(temporaryDirectory, "target")
+		_, err := git.PlainInit(destinationPath, true)
+		require.NoError(t, err)
+		repository := github.Repository{
+			CloneURL: github.String(destinationPath),
+		}
+		pushService := getTestPushService(t, "./push_test/action-cache-modified/", "")
+		require.NoError(t, pushService.pushGit(&repository, false))
+		destination, err := git.PlainOpen(destinationPath)
+		require.NoError(t, err)
+		for _, name := range []plumbing.ReferenceName{"refs/heads/local/fixes", "refs/heads/deleted-upstream"} {
+			require.NoError(t, destination.Storer.SetReference(plumbing.NewHashReference(name, plumbing.NewHash("26936381e619a01122ea33993e3cebc474496805"))))
+		}
+
+		pushService.protectedRefs = protectedRefPatterns([]string{"local/*"})
+		pushService.keepStaleRefs = keepStaleRefs
+		require.NoError(t, pushService.pushGit(&repository, false))
+		expected := []string{
+			"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200101",
+			"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200630",
+			"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+			"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+			"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+			"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+			"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+			"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+			"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning/because-it-now-has-this-extra-bit",
+			"26936381e619a01122ea33993e3cebc474496805 refs/heads/local/fixes",
+		}
+		if keepStaleRefs {
+			expected = append(expected, "26936381e619a01122ea33993e3cebc474496805 refs/heads/deleted-upstream")
+		}
+		test.CheckExpectedReferencesInRepository(t, destinationPath, expected)
+	}
+}
+
+func TestPushGitKeptReferenceConflict(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	destinationPath := path.Join(temporaryDirectory, "target")
+	_, err := git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+	repository := github.Repository{
+		CloneURL: github.String(destinationPath),
+	}
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	require.NoError(t, pushService.pushGit(&repository, false))
+
+	// The branch has been renamed upstream to a name beneath its old one, so it cannot be pushed while the old one is kept.
+	pushService = getTestPushService(t, "./push_test/action-cache-modified/", "")
+	pushService.keepStaleRefs = true
+	err = pushService.pushGit(&repository, false)
+	require.EqualError(t, err, fmt.Sprintf(errorKeptReferenceConflict, "refs/heads/a-ref-that-will-need-pruning", "refs/heads/a-ref-that-will-need-pruning/because-it-now-has-this-extra-bit", "refs/heads/a-ref-that-will-need-pruning"))
+}
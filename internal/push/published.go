@@ -0,0 +1,39 @@
+package push
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// The API does not allow the date of a release to be set, so GitHub Enterprise Server shows the date the release was pushed. The date it was originally published is given at the end of the release notes instead.
+const publicationDateFormat = "2006-01-02 at 15:04 UTC"
+const publicationDatePrefix = "_Originally published on "
+const publicationDateSuffix = "._"
+
+var publicationDatePattern = regexp.MustCompile(`\n*` + regexp.QuoteMeta(publicationDatePrefix) + `(\d{4}-\d{2}-\d{2} at \d{2}:\d{2} UTC)` + regexp.QuoteMeta(publicationDateSuffix) + `\s*$`)
+
+// withPublicationDate adds the date a release was published at its source to the end of its release notes. A release pulled from an instance the sync tool pushed to was published there when it was pushed, but its notes already give the original date, which is kept.
+func withPublicationDate(body string, release *github.RepositoryRelease) string {
+	if release.PublishedAt == nil || publicationDatePattern.MatchString(body) {
+		return body
+	}
+	publicationDate := publicationDatePrefix + release.GetPublishedAt().UTC().Format(publicationDateFormat) + publicationDateSuffix
+	if body == "" {
+		return publicationDate
+	}
+	return body + "\n\n" + publicationDate
+}
+
+// publicationDate returns when a release on the destination was published at its source, as given by its release notes. Releases pushed before the date was added to the notes only have the date they were pushed.
+func publicationDate(release *github.RepositoryRelease) *github.Timestamp {
+	match := publicationDatePattern.FindStringSubmatch(withoutReleaseMarker(release.GetBody()))
+	if match != nil {
+		publishedAt, err := time.Parse(publicationDateFormat, match[1])
+		if err == nil {
+			return &github.Timestamp{Time: publishedAt}
+		}
+	}
+	return release.PublishedAt
+}
@@ -0,0 +1,47 @@
+package push
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPublicationDate(t *testing.T) {
+	release := &github.RepositoryRelease{PublishedAt: &github.Timestamp{Time: time.Date(2020, 6, 30, 17, 5, 0, 0, time.FixedZone("PDT", -7*60*60))}}
+	body := withPublicationDate("Some release notes.", release)
+	require.Equal(t, "Some release notes.\n\n_Originally published on 2020-07-01 at 00:05 UTC._", body)
+	require.Equal(t, "_Originally published on 2020-07-01 at 00:05 UTC._", withPublicationDate("", release))
+
+	// A release pulled from an instance the sync tool pushed to keeps its original date.
+	pushedRelease := &github.RepositoryRelease{PublishedAt: &github.Timestamp{Time: time.Date(2020, 8, 1, 9, 0, 0, 0, time.UTC)}}
+	require.Equal(t, body, withPublicationDate(body, pushedRelease))
+
+	require.Equal(t, "Some release notes.", withPublicationDate("Some release notes.", &github.RepositoryRelease{}))
+}
+
+func TestReadReleaseMetadataKeepsFlagsAndDate(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	metadata, err := json.Marshal(github.RepositoryRelease{
+		TagName:     github.String("codeql-bundle-20200630"),
+		Body:        github.String("Some release notes."),
+		Prerelease:  github.Bool(true),
+		Draft:       github.Bool(false),
+		PublishedAt: &github.Timestamp{Time: time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, pushService.cacheDirectory.WriteMetadata("codeql-bundle-20200630", metadata))
+
+	releaseMetadata, err := pushService.readReleaseMetadata("codeql-bundle-20200630")
+	require.NoError(t, err)
+	require.Equal(t, "Some release notes.\n\n_Originally published on 2020-06-30 at 12:00 UTC._", releaseMetadata.GetBody())
+	// The flags are sent when the release is created or updated, so they match the source.
+	request, err := json.Marshal(releaseMetadata)
+	require.NoError(t, err)
+	require.Contains(t, string(request), `"prerelease":true`)
+	require.Contains(t, string(request), `"draft":false`)
+}
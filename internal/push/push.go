@@ -6,64 +6,174 @@ import (
 	usererrors "errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 
 	"github.com/github/codeql-action-sync/internal/githubapiutil"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/github/codeql-action-sync/internal/assetselection"
+	"github.com/github/codeql-action-sync/internal/audit"
 	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/concurrency"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/failures"
+	"github.com/github/codeql-action-sync/internal/fips"
+	"github.com/github/codeql-action-sync/internal/lfs"
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/github/codeql-action-sync/internal/progress"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/internal/retry"
 	"github.com/github/codeql-action-sync/internal/version"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/google/go-github/v32/github"
-	"github.com/mitchellh/ioprogress"
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
 )
 
-const repositoryHomepage = "https://github.com/github/codeql-action-sync-tool/"
+// DefaultRepositoryHomepage is the homepage set on the destination repository unless another is given. It is also how repositories created by the sync tool are recognized.
+const DefaultRepositoryHomepage = "https://github.com/github/codeql-action-sync-tool/"
+
+// DefaultRepositoryDescription is the description set on a new destination repository unless another is given.
+const DefaultRepositoryDescription = "A mirror of the CodeQL Action from https://github.com/github/codeql-action, kept up to date by the CodeQL Action sync tool."
+
+// DefaultRepositoryTopics are the topics set on a new destination repository unless others are given.
+var DefaultRepositoryTopics = []string{"codeql", "code-scanning", "github-actions"}
+
+// RepositoryMetadata describes the destination repository, so that admins can tell what it is and who maintains it.
+type RepositoryMetadata struct {
+	// Description is left unchanged on an existing repository if empty, and set to the default for the kind of source on a new one.
+	Description string
+	// Homepage is DefaultRepositoryHomepage if empty.
+	Homepage string
+	// Topics replace those of the repository. If nil they are left unchanged on an existing repository, and set to the defaults for the kind of source on a new one.
+	Topics []string
+	// DefaultBranch is the default branch of the source recorded in the cache if empty.
+	DefaultBranch string
+	// Visibility is one of VisibilityPublic, VisibilityInternal or VisibilityPrivate, and VisibilityPublic if empty.
+	Visibility string
+	// UpdateVisibility applies Visibility to an existing repository too, rather than only when it is created.
+	UpdateVisibility bool
+}
+
+func (metadata RepositoryMetadata) homepage() string {
+	if metadata.Homepage == "" {
+		return DefaultRepositoryHomepage
+	}
+	return metadata.Homepage
+}
 
 const errorAlreadyExists = "The destination repository already exists, but it was not created with the CodeQL Action sync tool. If you are sure you want to push the CodeQL Action to it, re-run this command with the `--force` flag."
 const errorInvalidDestinationToken = "The destination token you've provided is not valid."
 
 type pushService struct {
-	ctx                        context.Context
-	cacheDirectory             cachedirectory.CacheDirectory
-	githubEnterpriseClient     *github.Client
-	destinationRepositoryName  string
-	destinationRepositoryOwner string
-	destinationToken           *oauth2.Token
-	actionsAdminUser           string
-	force                      bool
-	pushSSH                    bool
+	ctx                          context.Context
+	cacheDirectory               cachedirectory.CacheDirectory
+	githubEnterpriseClient       *github.Client
+	githubEnterpriseUploadClient *github.Client
+	destinationURL               string
+	requestedRepository          string
+	destinationRepositoryName    string
+	destinationRepositoryOwner   string
+	destinationOrganization      string
+	destinationToken             *oauth2.Token
+	actionsAdminUser             string
+	createOrganization           bool
+	organizationAdmin            string
+	noSiteAdmin                  bool
+	repositoryMetadata           RepositoryMetadata
+	actionsPolicy                ActionsPolicy
+	defaultBranch                string
+	languageMapping              *assetselection.Mapping
+	languages                    []string
+	force                        bool
+	safePush                     bool
+	releaseRefsOnly              bool
+	keepStaleRefs                bool
+	protectedRefs                []*regexp.Regexp
+	includeDrafts                bool
+	// skippedDrafts is the draft releases in the cache that are not pushed, as `--include-drafts` was not given.
+	skippedDrafts            map[string]bool
+	pushSSH                  bool
+	verifyUploads            bool
+	strict                   bool
+	attestation              Attestation
+	sbom                     bool
+	retention                retention.Policy
+	sourceKind               string
+	startedAt                time.Time
+	showProgress             bool
+	uploadProgress           *progress.Reporter
+	previousRemoteReferences map[string]string
+	manifest                 *cachedirectory.Manifest
+	assetDigestsLock         sync.Mutex
+	assetDigests             map[int64]string
+	journalLock              sync.Mutex
+	auditLog                 *audit.Log
+	actor                    string
+	concurrency              concurrency.Limits
+}
+
+type releaseMark struct {
+	release *github.RepositoryRelease
+	body    string
+	digest  string
+}
+
+type assetUpload struct {
+	release  *github.RepositoryRelease
+	existing *github.ReleaseAsset
+	asset    cachedirectory.Asset
+	digest   string
+	// label and contentType are those of the asset at the source, from the release metadata in the cache.
+	label       string
+	contentType string
+	// content is set for assets generated by the sync tool rather than read from the cache.
+	content []byte
 }
 
 func (pushService *pushService) createRepository() (*github.Repository, error) {
 	log.Debug("Ensuring repository exists...")
+	err := pushService.resolvePreviousDestination()
+	if err != nil {
+		return nil, err
+	}
+	err = pushService.checkTokenScopes()
+	if err != nil {
+		return nil, err
+	}
 	user, response, err := pushService.githubEnterpriseClient.Users.Get(pushService.ctx, "")
 	if err != nil {
 		if response != nil && response.StatusCode == http.StatusUnauthorized {
-			return nil, usererrors.New(errorInvalidDestinationToken)
+			return nil, exitcode.WithCode(usererrors.New(errorInvalidDestinationToken), exitcode.Authentication)
 		}
 		return nil, errors.Wrap(err, "Error getting current user.")
 	}
+	pushService.actor = user.GetLogin()
 
 	// When creating a repository we can either create it in a named organization or under the current user (represented in go-github by an empty string).
 	destinationOrganization := ""
 	if pushService.destinationRepositoryOwner != user.GetLogin() {
 		destinationOrganization = pushService.destinationRepositoryOwner
 	}
+	pushService.destinationOrganization = destinationOrganization
 
 	if destinationOrganization != "" {
 		_, response, err := pushService.githubEnterpriseClient.Organizations.Get(pushService.ctx, pushService.destinationRepositoryOwner)
@@ -71,30 +181,53 @@ func (pushService *pushService) createRepository() (*github.Repository, error) {
 			return nil, errors.Wrap(err, "Error checking if destination organization exists.")
 		}
 		if response != nil && response.StatusCode == http.StatusNotFound {
-			log.Debugf("The organization %s does not exist. Creating it...", pushService.destinationRepositoryOwner)
+			if pushService.noSiteAdmin {
+				return nil, fmt.Errorf("The destination organization %s does not exist, and only a site admin can create it.", pushService.destinationRepositoryOwner)
+			}
+			if !pushService.createOrganization {
+				return nil, fmt.Errorf("The destination organization %s does not exist. Create it, or run with `--create-org` to create it automatically, which needs a token with the `site_admin` scope.", pushService.destinationRepositoryOwner)
+			}
+			organizationAdmin := pushService.organizationAdmin
+			if organizationAdmin == "" {
+				organizationAdmin = user.GetLogin()
+			}
+			log.Debugf("The organization %s does not exist. Creating it with %s as its admin...", pushService.destinationRepositoryOwner, organizationAdmin)
 			_, _, err := pushService.githubEnterpriseClient.Admin.CreateOrg(pushService.ctx, &github.Organization{
 				Login: github.String(pushService.destinationRepositoryOwner),
 				Name:  github.String(pushService.destinationRepositoryOwner),
-			}, user.GetLogin())
+			}, organizationAdmin)
 			if err != nil {
 				if response != nil && response.StatusCode == http.StatusNotFound && !githubapiutil.HasAnyScope(response, "site_admin") {
-					return nil, usererrors.New("The destination token you have provided does not have the `site_admin` scope, so the destination organization cannot be created.")
+					return nil, exitcode.WithCode(usererrors.New("The destination token you have provided does not have the `site_admin` scope, so the destination organization cannot be created."), exitcode.Authentication)
 				}
 				return nil, errors.Wrap(err, "Error creating organization.")
 			}
+			err = pushService.audit(audit.ActionCreateOrganization, pushService.destinationRepositoryOwner, map[string]string{"admin": organizationAdmin})
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		_, response, err = pushService.githubEnterpriseClient.Organizations.GetOrgMembership(pushService.ctx, user.GetLogin(), pushService.destinationRepositoryOwner)
 		if err != nil && (response == nil || response.StatusCode != http.StatusNotFound) {
 			return nil, errors.Wrap(err, "Failed to check membership of destination organization.")
 		}
-		if err != nil && githubapiutil.HasAnyScope(response, "site_admin") {
+		if err != nil && githubapiutil.HasAnyScope(response, "site_admin") && !pushService.noSiteAdmin {
 			log.Debugf("No access to destination organization. Switching to impersonation token for %s...", pushService.actionsAdminUser)
-			impersonationToken, _, err := pushService.githubEnterpriseClient.Admin.CreateUserImpersonation(pushService.ctx, pushService.actionsAdminUser, &github.ImpersonateUserOptions{Scopes: []string{"public_repo", "workflow"}})
+			scopes := []string{pushService.repositoryScope(), "workflow"}
+			if pushService.actionsPolicy.Level != "" {
+				scopes = append(scopes, pushService.actionsPolicy.scope())
+			}
+			impersonationToken, _, err := pushService.githubEnterpriseClient.Admin.CreateUserImpersonation(pushService.ctx, pushService.actionsAdminUser, &github.ImpersonateUserOptions{Scopes: scopes})
 			if err != nil {
 				return nil, errors.Wrap(err, "Failed to impersonate Actions admin user.")
 			}
+			err = pushService.audit(audit.ActionImpersonateUser, pushService.actionsAdminUser, map[string]string{"scopes": strings.Join(scopes, ",")})
+			if err != nil {
+				return nil, err
+			}
 			pushService.destinationToken.AccessToken = impersonationToken.GetToken()
+			pushService.actor = pushService.actionsAdminUser + " (impersonated by " + user.GetLogin() + ")"
 		}
 	}
 
@@ -102,290 +235,1018 @@ func (pushService *pushService) createRepository() (*github.Repository, error) {
 	if err != nil && (response == nil || response.StatusCode != http.StatusNotFound) {
 		return nil, errors.Wrap(err, "Error checking if destination repository exists.")
 	}
-	if response.StatusCode != http.StatusNotFound && repositoryHomepage != repository.GetHomepage() && !pushService.force {
+	if err == nil {
+		// Requests for a repository that has been renamed or transferred are redirected to its new location.
+		pushService.useCanonicalRepository(repository.GetFullName())
+	}
+	createdBySyncTool := repository.GetHomepage() == DefaultRepositoryHomepage || repository.GetHomepage() == pushService.repositoryMetadata.homepage()
+	if response.StatusCode != http.StatusNotFound && !createdBySyncTool && !pushService.force {
 		return nil, errors.Errorf(errorAlreadyExists)
 	}
 	desiredRepositoryProperties := github.Repository{
 		Name:         github.String(pushService.destinationRepositoryName),
-		Homepage:     github.String(repositoryHomepage),
+		Homepage:     github.String(pushService.repositoryMetadata.homepage()),
 		HasIssues:    github.Bool(false),
 		HasProjects:  github.Bool(false),
 		HasPages:     github.Bool(false),
 		HasWiki:      github.Bool(false),
 		HasDownloads: github.Bool(false),
 		Archived:     github.Bool(false),
-		Private:      github.Bool(false),
+	}
+	metadata := pushService.repositoryMetadata
+	if response.StatusCode == http.StatusNotFound {
+		// The defaults are only set on a new repository, so that any changes made to an existing one are kept unless other metadata is given.
+		metadata = defaultRepositoryMetadata(pushService.sourceKind, metadata)
+	}
+	if metadata.Description != "" {
+		desiredRepositoryProperties.Description = github.String(metadata.Description)
+	}
+	if response.StatusCode == http.StatusNotFound || pushService.repositoryMetadata.UpdateVisibility {
+		setVisibility(&desiredRepositoryProperties, pushService.repositoryMetadata.visibility())
 	}
 	if response.StatusCode == http.StatusNotFound {
 		repository, response, err = pushService.githubEnterpriseClient.Repositories.Create(pushService.ctx, destinationOrganization, &desiredRepositoryProperties)
 		if err != nil {
-			if response.StatusCode == http.StatusNotFound && !githubapiutil.HasAnyScope(response, "public_repo", "repo") {
-				return nil, usererrors.New("The destination token you have provided does not have the `public_repo` scope.")
+			if permissionErr := permissionError(response, "create the destination repository"); permissionErr != nil {
+				return nil, permissionErr
+			}
+			if response.StatusCode == http.StatusNotFound && !githubapiutil.HasAnyScope(response, pushService.repositoryScopes()...) {
+				return nil, exitcode.WithCode(fmt.Errorf("The destination token you have provided does not have the `%s` scope.", pushService.repositoryScope()), exitcode.Authentication)
 			}
 			return nil, errors.Wrap(err, "Error creating destination repository.")
 		}
+		err = pushService.audit(audit.ActionCreateRepository, repository.GetFullName(), nil)
+		if err != nil {
+			return nil, err
+		}
+	} else if pushService.noSiteAdmin && !repository.GetPermissions()["admin"] {
+		pushService.skipStep(fmt.Sprintf("updating the settings and topics of %s, as you are not an admin of it", repository.GetFullName()))
+		return repository, nil
 	} else {
 		repository, response, err = pushService.githubEnterpriseClient.Repositories.Edit(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, &desiredRepositoryProperties)
 		if err != nil {
+			if permissionErr := permissionError(response, "update the destination repository"); permissionErr != nil {
+				return nil, permissionErr
+			}
 			if response.StatusCode == http.StatusNotFound {
-				if !githubapiutil.HasAnyScope(response, "public_repo", "repo") {
-					return nil, usererrors.New("The destination token you have provided does not have the `public_repo` scope.")
+				if !githubapiutil.HasAnyScope(response, pushService.repositoryScopes()...) {
+					return nil, exitcode.WithCode(fmt.Errorf("The destination token you have provided does not have the `%s` scope.", pushService.repositoryScope()), exitcode.Authentication)
 				} else {
-					return nil, fmt.Errorf("You don't have permission to update the repository at %s/%s. If you wish to update the bundled CodeQL Action please provide a token with the `site_admin` scope.", pushService.destinationRepositoryOwner, pushService.destinationRepositoryName)
+					return nil, exitcode.WithCode(fmt.Errorf("You don't have permission to update the repository at %s/%s. If you wish to update the bundled CodeQL Action please provide a token with the `site_admin` scope.", pushService.destinationRepositoryOwner, pushService.destinationRepositoryName), exitcode.Authentication)
 				}
 			}
 			return nil, errors.Wrap(err, "Error updating destination repository.")
 		}
+		err = pushService.audit(audit.ActionUpdateRepository, repository.GetFullName(), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if metadata.Topics != nil {
+		_, response, err = pushService.githubEnterpriseClient.Repositories.ReplaceAllTopics(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, metadata.Topics)
+		if err != nil {
+			if permissionErr := permissionError(response, "set the topics of the destination repository"); permissionErr != nil {
+				return nil, permissionErr
+			}
+			return nil, errors.Wrap(err, "Error setting topics of destination repository.")
+		}
+		err = pushService.audit(audit.ActionReplaceTopics, repository.GetFullName(), map[string]string{"topics": strings.Join(metadata.Topics, ",")})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return repository, nil
 }
 
+// gitProgress is where Git writes its progress, or nil if progress is not being shown.
+func (pushService *pushService) gitProgress() io.Writer {
+	if !pushService.showProgress {
+		return nil
+	}
+	return os.Stderr
+}
+
+// openGitRepository opens the Git repository in the cache. The cache has already been checked to exist, so failing to open it means it is corrupt.
+func (pushService *pushService) openGitRepository() (*git.Repository, error) {
+	gitRepository, err := git.PlainOpen(pushService.cacheDirectory.GitPath())
+	if err != nil {
+		return nil, exitcode.WithCode(errors.Wrap(err, "Error reading Git repository from cache."), exitcode.CacheCorrupt)
+	}
+	return gitRepository, nil
+}
+
+// gitCredentials returns the credentials to access the destination with Git. Over SSH the key from the environment is used instead.
+func (pushService *pushService) gitCredentials() transport.AuthMethod {
+	if pushService.pushSSH {
+		return nil
+	}
+	return &githttp.BasicAuth{
+		Username: "x-access-token",
+		Password: pushService.destinationToken.AccessToken,
+	}
+}
+
+// installGitTransport makes Git operations over HTTP wait for GitHub Enterprise Server to come out of maintenance mode, in the same way as API calls. Some of the requests go-git makes do not carry the context, so the retry policy is given to the transport directly.
+func (pushService *pushService) installGitTransport() {
+	policy := retry.FromContext(pushService.ctx)
+	gitClient := githttp.NewClient(&http.Client{Transport: &retry.Transport{Policy: &policy}})
+	client.InstallProtocol("http", gitClient)
+	client.InstallProtocol("https", gitClient)
+}
+
+// listRemoteReferences lists the references of a remote, retrying if it fails with a transient error.
+func listRemoteReferences(ctx context.Context, remote *git.Remote, credentials transport.AuthMethod) ([]*plumbing.Reference, error) {
+	var references []*plumbing.Reference
+	err := retry.Do(ctx, "listing remote references", func(attempt int) error {
+		var err error
+		references, err = remote.List(&git.ListOptions{Auth: credentials})
+		return err
+	})
+	return references, err
+}
+
 func (pushService *pushService) pushGit(repository *github.Repository, initialPush bool) error {
 	remoteURL := repository.GetCloneURL()
 	if pushService.pushSSH {
 		remoteURL = repository.GetSSHURL()
+	} else {
+		pushService.installGitTransport()
 	}
 	if initialPush {
 		log.Debugf("Pushing Git releases to %s...", remoteURL)
 	} else {
 		log.Debugf("Pushing Git references to %s...", remoteURL)
 	}
-	gitRepository, err := git.PlainOpen(pushService.cacheDirectory.GitPath())
+	gitRepository, err := pushService.openGitRepository()
 	if err != nil {
-		return errors.Wrap(err, "Error reading Git repository from cache.")
+		return err
 	}
 
-	remote := git.NewRemote(gitRepository.Storer, &config.RemoteConfig{
+	shallow, err := isShallow(gitRepository)
+	if err != nil {
+		return err
+	}
+	remote := git.NewRemote(pushStorer(gitRepository, shallow), &config.RemoteConfig{
 		Name: git.DefaultRemoteName,
 		URLs: []string{remoteURL},
 	})
 
-	credentials := &githttp.BasicAuth{
-		Username: "x-access-token",
-		Password: pushService.destinationToken.AccessToken,
-	}
-	if pushService.pushSSH {
-		// Use the SSH key from the environment.
-		credentials = nil
-	}
+	credentials := pushService.gitCredentials()
 
 	refSpecBatches := [][]config.RefSpec{}
-	remoteReferences, err := remote.List(&git.ListOptions{Auth: credentials})
+	remoteReferences, err := listRemoteReferences(pushService.ctx, remote, credentials)
 	if err != nil && err != transport.ErrEmptyRemoteRepository {
 		return errors.Wrap(err, "Error listing remote references.")
 	}
+	if pushService.safePush {
+		err = pushService.checkDivergence(gitRepository, remoteURL, remoteReferences)
+		if err != nil {
+			return err
+		}
+	}
+	if shallow {
+		err = checkShallowHistory(gitRepository, remoteReferences)
+		if err != nil {
+			return err
+		}
+	}
+	recorder := report.FromContext(pushService.ctx)
+	tracksReferences := recorder != nil || pushService.auditLog != nil
+	var previousReferences map[string]string
+	if tracksReferences {
+		previousReferences, err = report.ReferenceHashes(storer.NewReferenceSliceIter(remoteReferences))
+		if err != nil {
+			return err
+		}
+		if initialPush {
+			pushService.previousRemoteReferences = previousReferences
+		}
+	}
 	deleteRefSpecs := []config.RefSpec{}
+	keptReferences := []plumbing.ReferenceName{}
 	for _, remoteReference := range remoteReferences {
 		_, err := gitRepository.Reference(remoteReference.Name(), false)
 		if err != nil && err != plumbing.ErrReferenceNotFound {
 			return errors.Wrapf(err, "Error finding local reference %s.", remoteReference.Name())
 		}
-		if err == plumbing.ErrReferenceNotFound {
-			deleteRefSpecs = append(deleteRefSpecs, config.RefSpec(":"+remoteReference.Name().String()))
+		name := remoteReference.Name()
+		if pushService.deletesReference(name, err != plumbing.ErrReferenceNotFound) {
+			deleteRefSpecs = append(deleteRefSpecs, config.RefSpec(":"+name.String()))
+		} else if err == plumbing.ErrReferenceNotFound && strings.HasPrefix(name.String(), "refs/") {
+			keptReferences = append(keptReferences, name)
 		}
 	}
+	err = pushService.checkKeptReferences(gitRepository, keptReferences)
+	if err != nil {
+		return err
+	}
 	refSpecBatches = append(refSpecBatches, deleteRefSpecs)
 
 	if initialPush {
-		releasePathStats, err := ioutil.ReadDir(pushService.cacheDirectory.ReleasesPath())
+		releases, err := pushService.releaseNames()
 		if err != nil {
-			return errors.Wrap(err, "Error reading releases.")
+			return err
 		}
 		initialRefSpecs := []config.RefSpec{}
-		for _, releasePathStat := range releasePathStats {
-			initialRefSpecs = append(initialRefSpecs, config.RefSpec("+refs/tags/"+releasePathStat.Name()+":refs/tags/"+releasePathStat.Name()))
+		for _, release := range releases {
+			initialRefSpecs = append(initialRefSpecs, config.RefSpec("+refs/tags/"+release+":refs/tags/"+release))
 		}
 		refSpecBatches = append(refSpecBatches, initialRefSpecs)
 	} else {
-		// We've got to push `main` on its own, so that it will be made the default branch if the repository has just been created. We then push everything else afterwards.
-		refSpecBatches = append(refSpecBatches,
-			[]config.RefSpec{
-				config.RefSpec("+refs/heads/main:refs/heads/main"),
-			},
-			[]config.RefSpec{
-				config.RefSpec("+refs/*:refs/*"),
-			},
-		)
+		// We've got to push the default branch on its own, so that it will be made the default branch if the repository has just been created. We then push everything else afterwards.
+		if pushService.defaultBranch != "" {
+			defaultBranch := plumbing.NewBranchReferenceName(pushService.defaultBranch).String()
+			refSpecBatches = append(refSpecBatches, []config.RefSpec{
+				config.RefSpec("+" + defaultBranch + ":" + defaultBranch),
+			})
+		}
+		refSpecs, err := pushService.pushRefSpecs(gitRepository)
+		if err != nil {
+			return err
+		}
+		refSpecBatches = append(refSpecBatches, refSpecs)
 	}
 	for _, refSpecs := range refSpecBatches {
 		if len(refSpecs) != 0 {
-			err = remote.PushContext(pushService.ctx, &git.PushOptions{
-				RefSpecs: refSpecs,
-				Auth:     credentials,
-				Progress: os.Stderr,
-			})
+			err = streamPush(pushService.ctx, gitRepository, pushStorer(gitRepository, shallow), remoteURL, credentials, refSpecs, pushService.gitProgress())
 			if err != nil && errors.Cause(err) != git.NoErrAlreadyUpToDate {
 				return errors.Wrap(err, "Error pushing Action to GitHub Enterprise Server.")
 			}
 		}
 	}
 
+	if tracksReferences {
+		remoteReferences, err := listRemoteReferences(pushService.ctx, remote, credentials)
+		if err != nil {
+			return errors.Wrap(err, "Error listing remote references.")
+		}
+		currentReferences, err := report.ReferenceHashes(storer.NewReferenceSliceIter(remoteReferences))
+		if err != nil {
+			return err
+		}
+		err = pushService.auditReferences(report.Differences(previousReferences, currentReferences))
+		if err != nil {
+			return err
+		}
+		if !initialPush {
+			recorder.RecordReferences(pushService.previousRemoteReferences, currentReferences)
+		}
+	}
+	return nil
+}
+
+func (pushService *pushService) audit(action string, target string, details map[string]string) error {
+	return pushService.auditLog.Record(pushService.actor, pushService.destinationURL, action, target, details)
+}
+
+func (pushService *pushService) auditReferences(differences []report.Reference) error {
+	for _, difference := range differences {
+		action := audit.ActionUpdateReference
+		if difference.Previous == "" {
+			action = audit.ActionCreateReference
+		} else if difference.Current == "" {
+			action = audit.ActionDeleteReference
+		}
+		err := pushService.audit(action, pushService.destinationRepository()+" "+difference.Name, map[string]string{"previous": difference.Previous, "current": difference.Current})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (pushService *pushService) createOrUpdateRelease(releaseName string) (*github.RepositoryRelease, error) {
+func (pushService *pushService) readReleaseMetadata(releaseName string) (*github.RepositoryRelease, error) {
 	releaseMetadata := github.RepositoryRelease{}
-	releaseMetadataPath := pushService.cacheDirectory.MetadataPath(releaseName)
-	releaseMetadataFile, err := ioutil.ReadFile(releaseMetadataPath)
+	releaseMetadataFile, err := pushService.cacheDirectory.ReadMetadata(releaseName)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error reading release metadata.")
 	}
 	err = json.Unmarshal([]byte(releaseMetadataFile), &releaseMetadata)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error converting release from JSON.")
+		return nil, exitcode.WithCode(errors.Wrap(err, "Error converting release from JSON."), exitcode.CacheCorrupt)
 	}
 	// Some of our target commitishes are invalid as they point to `main` which we've not pushed yet.
 	releaseMetadata.TargetCommitish = nil
+	// A release pulled from an instance the sync tool pushed to carries that instance's marker, which does not apply here.
+	if releaseMetadata.Body != nil {
+		releaseMetadata.Body = github.String(withoutReleaseMarker(releaseMetadata.GetBody()))
+	}
+	if releaseMetadata.PublishedAt != nil {
+		releaseMetadata.Body = github.String(withPublicationDate(releaseMetadata.GetBody(), &releaseMetadata))
+	}
+	return &releaseMetadata, nil
+}
 
-	release, response, err := pushService.githubEnterpriseClient.Repositories.GetReleaseByTag(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, releaseMetadata.GetTagName())
-	if err != nil && response.StatusCode != http.StatusNotFound {
+// getDestinationRelease returns the release with the given tag on the destination, or nil if there is none.
+func (pushService *pushService) getDestinationRelease(tag string) (*github.RepositoryRelease, error) {
+	var release *github.RepositoryRelease
+	err := retry.Do(pushService.ctx, "checking for existing release "+tag, func(attempt int) error {
+		var err error
+		release, err = pushService.findDestinationRelease(tag)
+		return err
+	})
+	if sizeErr := githubapiutil.AssetSizeError(err); sizeErr != nil {
+		return nil, sizeErr
+	}
+	if err != nil {
 		return nil, errors.Wrap(err, "Error checking for existing CodeQL release.")
 	}
+	return release, nil
+}
+
+// findDestinationRelease makes a single request for the release with the given tag on the destination, returning nil if there is none. It is not retried, so that it can be used within an operation that is.
+func (pushService *pushService) findDestinationRelease(tag string) (*github.RepositoryRelease, error) {
+	release, response, err := pushService.githubEnterpriseClient.Repositories.GetReleaseByTag(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, tag)
+	if err != nil && response != nil && response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return release, err
+}
+
+func (pushService *pushService) createOrUpdateRelease(releaseName string, releaseMetadata *github.RepositoryRelease, release *github.RepositoryRelease) (*github.RepositoryRelease, error) {
 	if release == nil {
 		log.Debugf("Creating release %s...", releaseMetadata.GetTagName())
-		release, _, err := pushService.githubEnterpriseClient.Repositories.CreateRelease(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, &releaseMetadata)
+		err := pushService.journal(journalEntry{Operation: journalCreateRelease, Release: releaseMetadata.GetTagName()})
 		if err != nil {
+			return nil, err
+		}
+		var response *github.Response
+		err = retry.Do(pushService.ctx, "creating release "+releaseName, func(attempt int) error {
+			if attempt > 1 {
+				// An earlier attempt may have created the release before failing. This is already being retried, so the check is not retried on its own.
+				existingRelease, err := pushService.findDestinationRelease(releaseMetadata.GetTagName())
+				if err != nil || existingRelease != nil {
+					release = existingRelease
+					return err
+				}
+			}
+			var err error
+			release, response, err = pushService.githubEnterpriseClient.Repositories.CreateRelease(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, releaseMetadata)
+			return err
+		})
+		if err != nil {
+			if permissionErr := permissionError(response, "create releases"); permissionErr != nil {
+				return nil, permissionErr
+			}
 			return nil, errors.Wrap(err, "Error creating release.")
 		}
+		err = pushService.journal(journalEntry{Operation: journalCreateRelease, Completed: true, Release: releaseMetadata.GetTagName(), ReleaseID: release.GetID()})
+		if err != nil {
+			return nil, err
+		}
+		report.FromContext(pushService.ctx).RecordRelease(releaseName, report.OutcomeCreated)
+		err = pushService.audit(audit.ActionCreateRelease, pushService.destinationRepository()+" "+releaseName, map[string]string{"id": strconv.FormatInt(release.GetID(), 10)})
+		if err != nil {
+			return nil, err
+		}
 		return release, nil
 	}
-	release, _, err = pushService.githubEnterpriseClient.Repositories.EditRelease(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID(), &releaseMetadata)
+	log.Debugf("Updating release %s...", releaseMetadata.GetTagName())
+	var response *github.Response
+	err := retry.Do(pushService.ctx, "updating release "+releaseName, func(attempt int) error {
+		var err error
+		release, response, err = pushService.githubEnterpriseClient.Repositories.EditRelease(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID(), releaseMetadata)
+		return err
+	})
 	if err != nil {
-		log.Debugf("Updating release %s...", releaseMetadata.GetTagName())
+		if permissionErr := permissionError(response, "update releases"); permissionErr != nil {
+			return nil, permissionErr
+		}
 		return nil, errors.Wrap(err, "Error updating release.")
 	}
+	report.FromContext(pushService.ctx).RecordRelease(releaseName, report.OutcomeUpdated)
+	err = pushService.audit(audit.ActionUpdateRelease, pushService.destinationRepository()+" "+releaseName, map[string]string{"id": strconv.FormatInt(release.GetID(), 10)})
+	if err != nil {
+		return nil, err
+	}
 	return release, nil
 }
 
-func (pushService *pushService) uploadReleaseAsset(release *github.RepositoryRelease, assetPathStat os.FileInfo, reader io.Reader) (*github.ReleaseAsset, *github.Response, error) {
+func (pushService *pushService) uploadReleaseAsset(release *github.RepositoryRelease, asset cachedirectory.Asset, label string, contentType string, reader io.Reader) (*github.ReleaseAsset, *github.Response, error) {
 	// This is technically already part of the go-github library, but we re-implement it here since otherwise we can't get a progress bar.
-	url := fmt.Sprintf("repos/%s/%s/releases/%d/assets?name=%s", pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID(), url.QueryEscape(assetPathStat.Name()))
+	// The reader is streamed as the request body rather than read into memory, so uploading a large bundle needs no more memory than a small one.
+	query := "name=" + url.QueryEscape(asset.Name)
+	if label != "" {
+		query += "&label=" + url.QueryEscape(label)
+	}
+	url := fmt.Sprintf("repos/%s/%s/releases/%d/assets?%s", pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID(), query)
 
-	mediaType := mime.TypeByExtension(filepath.Ext(assetPathStat.Name()))
-	request, err := pushService.githubEnterpriseClient.NewUploadRequest(url, reader, assetPathStat.Size(), mediaType)
+	// The content type of the source is kept, as it is only guessed from the name if not known.
+	mediaType := contentType
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(filepath.Ext(asset.Name))
+	}
+	request, err := pushService.githubEnterpriseClient.NewUploadRequest(url, reader, asset.Size, mediaType)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "Error constructing upload request.")
 	}
 
-	asset := &github.ReleaseAsset{}
-	response, err := pushService.githubEnterpriseClient.Do(pushService.ctx, request, asset)
+	uploadedAsset := &github.ReleaseAsset{}
+	response, err := pushService.githubEnterpriseUploadClient.Do(pushService.ctx, request, uploadedAsset)
 	if err != nil {
 		return nil, response, errors.Wrap(err, "Error uploading release asset.")
 	}
-	return asset, response, nil
+	return uploadedAsset, response, nil
 }
 
-func (pushService *pushService) createOrUpdateReleaseAsset(release *github.RepositoryRelease, existingAssets []*github.ReleaseAsset, assetPathStat os.FileInfo) error {
+func findExistingAsset(existingAssets []*github.ReleaseAsset, name string) *github.ReleaseAsset {
 	for _, existingAsset := range existingAssets {
-		if existingAsset.GetName() == assetPathStat.Name() {
-			if int64(existingAsset.GetSize()) == assetPathStat.Size() {
-				return nil
-			}
+		if existingAsset.GetName() == name {
+			return existingAsset
 		}
 	}
-	log.Debugf("Uploading release asset %s...", assetPathStat.Name())
-	assetFile, err := os.Open(pushService.cacheDirectory.AssetPath(release.GetTagName(), assetPathStat.Name()))
-	defer assetFile.Close()
-	progressReader := &ioprogress.Reader{
-		Reader:   assetFile,
-		Size:     assetPathStat.Size(),
-		DrawFunc: ioprogress.DrawTerminalf(os.Stderr, ioprogress.DrawTextFormatBytes),
+	return nil
+}
+
+// needsUpload checks whether the asset on the destination may differ from the cached one. The API does not give the checksums of assets, so an asset is only known to be the same if it has the same size and the sync tool recorded uploading it with the same checksum.
+func needsUpload(upload assetUpload, uploadedDigests map[int64]string) bool {
+	if upload.existing == nil || githubapiutil.AssetSize(upload.existing) != upload.asset.Size {
+		return true
 	}
+	return uploadedDigests[upload.existing.GetID()] != upload.digest
+}
+
+// cachedAssetDigest returns the checksum of a cached asset, as recorded in the manifest when it was pulled.
+func (pushService *pushService) cachedAssetDigest(releaseName string, asset cachedirectory.Asset) (string, error) {
+	entry, exists := pushService.manifest.Get(releaseName, asset.Name)
+	if exists && entry.Size == asset.Size {
+		return entry.SHA256, nil
+	}
+	return pushService.cacheDirectory.AssetDigest(releaseName, asset.Name)
+}
+
+func (pushService *pushService) recordAssetDigest(assetID int64, digest string) {
+	pushService.assetDigestsLock.Lock()
+	defer pushService.assetDigestsLock.Unlock()
+	pushService.assetDigests[assetID] = digest
+}
+
+func (pushService *pushService) listReleaseAssets(release *github.RepositoryRelease) ([]*github.ReleaseAsset, error) {
+	existingAssets := []*github.ReleaseAsset{}
+	for page := 1; ; page++ {
+		var assets []*github.ReleaseAsset
+		err := retry.Do(pushService.ctx, "fetching existing release assets", func(attempt int) error {
+			var err error
+			assets, _, err = pushService.githubEnterpriseClient.Repositories.ListReleaseAssets(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID(), &github.ListOptions{Page: page})
+			return err
+		})
+		if sizeErr := githubapiutil.AssetSizeError(err); sizeErr != nil {
+			return nil, sizeErr
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error fetching existing release assets.")
+		}
+		if len(assets) == 0 {
+			return existingAssets, nil
+		}
+		existingAssets = append(existingAssets, assets...)
+	}
+}
+
+func (pushService *pushService) deleteReleaseAsset(release *github.RepositoryRelease, existingAsset *github.ReleaseAsset) error {
+	err := retry.Do(pushService.ctx, "removing release asset "+existingAsset.GetName(), func(attempt int) error {
+		response, err := pushService.githubEnterpriseClient.Repositories.DeleteReleaseAsset(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, existingAsset.GetID())
+		// If an earlier attempt timed out after the asset was deleted, it is already gone.
+		if err != nil && attempt > 1 && response != nil && response.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
 	if err != nil {
-		return errors.Wrap(err, "Error opening release asset.")
+		return err
 	}
-	_, _, err = pushService.uploadReleaseAsset(release, assetPathStat, progressReader)
+	pushService.forgetAssetDigest(existingAsset.GetID())
+	return pushService.audit(audit.ActionDeleteAsset, pushService.destinationRepository()+" "+release.GetTagName()+"/"+existingAsset.GetName(), map[string]string{"id": strconv.FormatInt(existingAsset.GetID(), 10)})
+}
+
+// updateReleaseAssetLabel gives an asset that is already on the destination the label it has at the source. Its content type cannot be changed without uploading it again.
+func (pushService *pushService) updateReleaseAssetLabel(upload assetUpload) error {
+	log.Debugf("Updating the label of release asset %s...", upload.asset.Name)
+	err := retry.Do(pushService.ctx, "updating release asset "+upload.asset.Name, func(attempt int) error {
+		_, _, err := pushService.githubEnterpriseClient.Repositories.EditReleaseAsset(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, upload.existing.GetID(), &github.ReleaseAsset{
+			Name:  github.String(upload.asset.Name),
+			Label: github.String(upload.label),
+		})
+		return err
+	})
 	if err != nil {
-		return errors.Wrap(err, "Error uploading release asset.")
+		return errors.Wrap(err, "Error updating release asset.")
 	}
-	return nil
+	report.FromContext(pushService.ctx).RecordAsset(upload.release.GetTagName(), upload.asset.Name, upload.asset.Size, report.OutcomeUpdated)
+	return pushService.audit(audit.ActionUpdateAsset, pushService.destinationRepository()+" "+upload.release.GetTagName()+"/"+upload.asset.Name, map[string]string{"id": strconv.FormatInt(upload.existing.GetID(), 10), "label": upload.label})
 }
 
-func (pushService *pushService) pushReleases() error {
-	log.Debugf("Pushing CodeQL bundles...")
-	releasesPath := pushService.cacheDirectory.ReleasesPath()
+func (pushService *pushService) forgetAssetDigest(assetID int64) {
+	pushService.assetDigestsLock.Lock()
+	defer pushService.assetDigestsLock.Unlock()
+	delete(pushService.assetDigests, assetID)
+}
 
-	releasePathStats, err := ioutil.ReadDir(releasesPath)
+func (pushService *pushService) createOrUpdateReleaseAsset(upload assetUpload, uploadedDigests map[int64]string) error {
+	recorder := report.FromContext(pushService.ctx)
+	release := upload.release
+	asset := upload.asset
+	if !needsUpload(upload, uploadedDigests) {
+		pushService.recordAssetDigest(upload.existing.GetID(), upload.digest)
+		if upload.existing.GetLabel() != upload.label {
+			return pushService.updateReleaseAssetLabel(upload)
+		}
+		recorder.RecordAsset(release.GetTagName(), asset.Name, asset.Size, report.OutcomeUnchanged)
+		return nil
+	}
+	if upload.existing != nil {
+		log.Debugf("Removing outdated release asset %s...", asset.Name)
+		err := pushService.deleteReleaseAsset(release, upload.existing)
+		if err != nil {
+			return errors.Wrap(err, "Error removing outdated release asset.")
+		}
+	}
+	log.Debugf("Uploading release asset %s...", asset.Name)
+	entry := journalEntry{Operation: journalUploadAsset, Release: release.GetTagName(), ReleaseID: release.GetID(), Asset: asset.Name, SHA256: upload.digest}
+	err := pushService.journal(entry)
 	if err != nil {
-		return errors.Wrap(err, "Error reading releases.")
+		return err
 	}
-	for _, releasePathStat := range releasePathStats {
-		releaseName := releasePathStat.Name()
-		release, err := pushService.createOrUpdateRelease(releaseName)
+	var uploadedAsset *github.ReleaseAsset
+	err = retry.Do(pushService.ctx, "uploading release asset "+asset.Name, func(attempt int) error {
+		if attempt > 1 {
+			// An upload that failed part of the way through can leave an incomplete asset behind, which would stop it being uploaded again.
+			existingAssets, err := pushService.listReleaseAssets(release)
+			if err != nil {
+				return err
+			}
+			if incompleteAsset := findExistingAsset(existingAssets, asset.Name); incompleteAsset != nil {
+				err := pushService.deleteReleaseAsset(release, incompleteAsset)
+				if err != nil {
+					return errors.Wrap(err, "Error removing incomplete release asset.")
+				}
+			}
+		}
+		assetReader, err := pushService.openUpload(upload)
+		if err != nil {
+			return errors.Wrap(err, "Error opening release asset.")
+		}
+		defer assetReader.Close()
+		progressReader := pushService.uploadProgress.Track(asset.Name, assetReader, asset.Size, 0)
+		defer progressReader.Close()
+		uploadedAsset, _, err = pushService.uploadReleaseAsset(release, asset, upload.label, upload.contentType, progressReader)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error uploading release asset.")
+	}
+	entry.Completed, entry.AssetID = true, uploadedAsset.GetID()
+	err = pushService.journal(entry)
+	if err != nil {
+		return err
+	}
+	pushService.recordAssetDigest(uploadedAsset.GetID(), upload.digest)
+	err = pushService.audit(audit.ActionUploadAsset, pushService.destinationRepository()+" "+release.GetTagName()+"/"+asset.Name, map[string]string{"id": strconv.FormatInt(uploadedAsset.GetID(), 10), "size": strconv.FormatInt(asset.Size, 10), "sha256": upload.digest})
+	if err != nil {
+		return err
+	}
+	if pushService.verifyUploads {
+		err = pushService.verifyUploadedAsset(upload, uploadedAsset)
 		if err != nil {
 			return err
 		}
+	}
+	recorder.RecordAsset(release.GetTagName(), asset.Name, asset.Size, report.OutcomeUploaded)
+	return nil
+}
+
+func (pushService *pushService) selectAssets(assets []cachedirectory.Asset) ([]cachedirectory.Asset, error) {
+	included := []cachedirectory.Asset{}
+	for _, asset := range assets {
+		if pushService.languageMapping.IncludesAsset(asset.Name) {
+			included = append(included, asset)
+		} else {
+			log.Debugf("Skipping asset %s as it is excluded by `--include-assets` or `--exclude-assets`.", asset.Name)
+		}
+	}
+	assets = included
+	if len(pushService.languages) == 0 {
+		return assets, nil
+	}
+	candidates := []assetselection.Asset{}
+	for _, asset := range assets {
+		candidates = append(candidates, assetselection.Asset{Name: asset.Name, Size: asset.Size})
+	}
+	selection, err := pushService.languageMapping.FilterAssets(candidates, pushService.languages)
+	if err != nil {
+		return nil, err
+	}
+	selected := map[string]bool{}
+	for _, asset := range selection {
+		selected[asset.Name] = true
+	}
+	result := []cachedirectory.Asset{}
+	for _, asset := range assets {
+		if selected[asset.Name] {
+			result = append(result, asset)
+		} else {
+			log.Debugf("Skipping asset %s as it is not needed for the languages %s.", asset.Name, strings.Join(pushService.languages, ", "))
+		}
+	}
+	return result, nil
+}
 
-		existingAssets := []*github.ReleaseAsset{}
-		for page := 1; ; page++ {
-			assets, _, err := pushService.githubEnterpriseClient.Repositories.ListReleaseAssets(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, release.GetID(), &github.ListOptions{Page: page})
+func recordSkippedAssets(recorder *report.Recorder, releaseName string, assets []cachedirectory.Asset, selected []cachedirectory.Asset) {
+	selectedNames := map[string]bool{}
+	for _, asset := range selected {
+		selectedNames[asset.Name] = true
+	}
+	for _, asset := range assets {
+		if !selectedNames[asset.Name] {
+			recorder.RecordAsset(releaseName, asset.Name, asset.Size, report.OutcomeSkipped)
+		}
+	}
+}
+
+// selectReleases returns the releases in the cache to push, and those left out because the last successful push already pushed them. Releases excluded by `--keep-last` or `--since` are left out, even if they are still in the cache.
+func (pushService *pushService) selectReleases() ([]string, []string, error) {
+	releaseNames, err := pushService.cacheDirectory.ListReleases()
+	if err != nil {
+		return nil, nil, err
+	}
+	kept := map[string]bool{}
+	if pushService.retention.KeepLast > 0 {
+		keptReleases, err := pushService.recentReleases(pushService.retention.KeepLast)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, releaseName := range keptReleases {
+			kept[releaseName] = true
+		}
+	}
+	cutoff, previousReleases, err := pushService.retentionCutoff()
+	if err != nil {
+		return nil, nil, err
+	}
+	selected := []string{}
+	alreadyPushed := []string{}
+	for _, releaseName := range releaseNames {
+		if pushService.skippedDrafts[releaseName] {
+			log.Debugf("Not pushing release %s as it is a draft.", releaseName)
+			continue
+		}
+		if pushService.retention.KeepLast > 0 && !kept[releaseName] {
+			log.Debugf("Not pushing release %s as it is no longer one of the most recent releases.", releaseName)
+			continue
+		}
+		if !cutoff.IsZero() && (!pushService.retention.LastSync || previousReleases[releaseName]) {
+			releaseMetadata, err := pushService.readReleaseMetadata(releaseName)
 			if err != nil {
-				return errors.Wrap(err, "Error fetching existing release assets.")
+				return nil, nil, err
 			}
-			if len(assets) == 0 {
-				break
+			if retention.Excludes(cutoff, releaseMetadata.PublishedAt) {
+				log.Debugf("Not pushing release %s as it was published before %s.", releaseName, cutoff.Format(time.RFC3339))
+				if pushService.retention.LastSync {
+					alreadyPushed = append(alreadyPushed, releaseName)
+				}
+				continue
 			}
-			existingAssets = append(existingAssets, assets...)
 		}
+		selected = append(selected, releaseName)
+	}
+	return selected, alreadyPushed, nil
+}
+
+func (pushService *pushService) releaseNames() ([]string, error) {
+	releaseNames, _, err := pushService.selectReleases()
+	return releaseNames, err
+}
 
-		assetsPath := pushService.cacheDirectory.AssetsPath(releaseName)
-		assetPathStats, err := ioutil.ReadDir(assetsPath)
+// prepareRelease creates or updates a release on the destination, and returns the assets to upload to it and how to mark it once they are uploaded. Nothing is returned for a release that is already up to date.
+func (pushService *pushService) prepareRelease(releaseName string) ([]assetUpload, *releaseMark, error) {
+	recorder := report.FromContext(pushService.ctx)
+	releaseMetadata, err := pushService.readReleaseMetadata(releaseName)
+	if err != nil {
+		return nil, nil, err
+	}
+	assets, err := pushService.cacheDirectory.ListAssets(releaseName)
+	if err != nil {
+		return nil, nil, err
+	}
+	selectedAssets, err := pushService.selectAssets(assets)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordSkippedAssets(recorder, releaseName, assets, selectedAssets)
+	sourceAssets := map[string]*github.ReleaseAsset{}
+	for _, sourceAsset := range releaseMetadata.Assets {
+		sourceAssets[sourceAsset.GetName()] = sourceAsset
+	}
+	uploads := []assetUpload{}
+	for _, asset := range selectedAssets {
+		digest, err := pushService.cachedAssetDigest(releaseName, asset)
 		if err != nil {
-			return errors.Wrap(err, "Error reading release assets.")
+			return nil, nil, err
 		}
-		for _, assetPathStat := range assetPathStats {
-			err := pushService.createOrUpdateReleaseAsset(release, existingAssets, assetPathStat)
-			if err != nil {
-				return errors.Wrap(err, "Error uploading release assets.")
-			}
+		sourceAsset := sourceAssets[asset.Name]
+		uploads = append(uploads, assetUpload{asset: asset, digest: digest, label: sourceAsset.GetLabel(), contentType: sourceAsset.GetContentType()})
+	}
+	var statement []byte
+	if pushService.attestation.Enabled {
+		statement, err = pushService.provenanceStatement(releaseMetadata, uploads)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
+	digest, err := pushService.releaseDigest(releaseMetadata, uploads, statement)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return nil
+	existingRelease, err := pushService.findDestinationRelease(releaseMetadata.GetTagName())
+	if err != nil {
+		return nil, nil, err
+	}
+	if existingRelease != nil && releaseMarkerDigest(existingRelease.GetBody()) == digest {
+		log.Debugf("Release %s is already up to date.", releaseName)
+		recorder.RecordRelease(releaseName, report.OutcomeUnchanged)
+		for _, upload := range uploads {
+			recorder.RecordAsset(releaseName, upload.asset.Name, upload.asset.Size, report.OutcomeUnchanged)
+		}
+		return nil, nil, nil
+	}
+	release, err := pushService.createOrUpdateRelease(releaseName, releaseMetadata, existingRelease)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pushService.sbom {
+		sbomUploads, err := pushService.sbomUploads(releaseName, uploads)
+		if err != nil {
+			return nil, nil, err
+		}
+		uploads = append(uploads, sbomUploads...)
+	}
+	if statement != nil {
+		attestationUpload, err := pushService.attestationUpload(statement)
+		if err != nil {
+			return nil, nil, err
+		}
+		uploads = append(uploads, attestationUpload)
+	}
+
+	existingAssets, err := pushService.listReleaseAssets(release)
+	if err != nil {
+		return nil, nil, err
+	}
+	for index := range uploads {
+		uploads[index].release = release
+		uploads[index].existing = findExistingAsset(existingAssets, uploads[index].asset.Name)
+	}
+	return uploads, &releaseMark{release: release, body: releaseMetadata.GetBody(), digest: digest}, nil
+}
+
+func (pushService *pushService) pushReleases() error {
+	log.Debugf("Pushing CodeQL bundles...")
+
+	releaseNames, err := pushService.releaseNames()
+	if err != nil {
+		return err
+	}
+	pushService.manifest, err = pushService.cacheDirectory.ReadManifest()
+	if err != nil {
+		return err
+	}
+	uploadedDigests, err := pushService.previousAssetDigests()
+	if err != nil {
+		return err
+	}
+	// This is done before anything is pushed, so that nothing left behind by an interrupted push is mistaken for part of this one.
+	recoveredDigests, err := pushService.recoverJournal()
+	if err != nil {
+		return err
+	}
+	for id, digest := range recoveredDigests {
+		uploadedDigests[id] = digest
+	}
+	pushService.assetDigests = map[int64]string{}
+	for id, digest := range uploadedDigests {
+		pushService.assetDigests[id] = digest
+	}
+	releaseUploads := make([][]assetUpload, len(releaseNames))
+	releaseMarks := make([]*releaseMark, len(releaseNames))
+	err = concurrency.ForEach(len(releaseNames), pushService.concurrency.APIRequests, func(index int) error {
+		releaseName := releaseNames[index]
+		defer metrics.FromContext(pushService.ctx).TimeRelease(releaseName)()
+		if failures.FromContext(pushService.ctx).Failed(releaseName) {
+			log.Debugf("Not pushing release %s as it could not be pulled.", releaseName)
+			return nil
+		}
+		var err error
+		releaseUploads[index], releaseMarks[index], err = pushService.prepareRelease(releaseName)
+		return failures.Record(pushService.ctx, releaseName, err)
+	})
+	if err != nil {
+		return err
+	}
+
+	uploads := []assetUpload{}
+	for _, assetUploads := range releaseUploads {
+		uploads = append(uploads, assetUploads...)
+	}
+	totalSize := int64(0)
+	for _, upload := range uploads {
+		if needsUpload(upload, uploadedDigests) {
+			totalSize += upload.asset.Size
+		}
+	}
+	pushService.checkStorage(uploads, uploadedDigests, totalSize)
+	pushService.uploadProgress = progress.NewReporter(os.Stderr, "Uploading", pushService.showProgress && totalSize > 0)
+	pushService.uploadProgress.Start(totalSize)
+	defer pushService.uploadProgress.Stop()
+	err = concurrency.ForEach(len(uploads), pushService.concurrency.Uploads, func(index int) error {
+		upload := uploads[index]
+		defer metrics.FromContext(pushService.ctx).TimeRelease(upload.release.GetTagName())()
+		err := pushService.createOrUpdateReleaseAsset(upload, uploadedDigests)
+		if err != nil {
+			return failures.Record(pushService.ctx, upload.release.GetTagName(), errors.Wrap(err, "Error uploading release assets."))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Releases are only marked once all their assets are pushed, so that an interrupted push is finished next time.
+	return concurrency.ForEach(len(releaseMarks), pushService.concurrency.APIRequests, func(index int) error {
+		mark := releaseMarks[index]
+		// A release with an asset that could not be uploaded is left unmarked, so that it is pushed again next time.
+		if mark == nil || failures.FromContext(pushService.ctx).Failed(mark.release.GetTagName()) {
+			return nil
+		}
+		defer metrics.FromContext(pushService.ctx).TimeRelease(mark.release.GetTagName())()
+		return failures.Record(pushService.ctx, mark.release.GetTagName(), pushService.markRelease(mark.release, mark.body, mark.digest))
+	})
 }
 
-func Push(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, destinationURL string, destinationToken string, destinationRepository string, actionsAdminUser string, force bool, pushSSH bool) error {
-	err := cacheDirectory.CheckOrCreateVersionFile(false, version.Version())
+// Options are the settings for a push, which mostly match the `push` flags of the same names.
+type Options struct {
+	DestinationURL        string
+	DestinationToken      string
+	DestinationRepository string
+	ActionsAdminUser      string
+	CreateOrganization    bool
+	OrganizationAdmin     string
+	NoSiteAdmin           bool
+	RepositoryMetadata    RepositoryMetadata
+	ActionsPolicy         ActionsPolicy
+	LanguageMapping       *assetselection.Mapping
+	Languages             []string
+	Force                 bool
+	SafePush              bool
+	ReleaseRefsOnly       bool
+	PruneRefs             bool
+	ProtectedRefs         []string
+	IncludeDrafts         bool
+	PushSSH               bool
+	Verify                bool
+	VerifyUploads         bool
+	Strict                bool
+	Attestation           Attestation
+	SBOM                  bool
+	RetentionPolicy       retention.Policy
+	Limits                concurrency.Limits
+	ShowProgress          bool
+}
+
+func Push(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, options Options) error {
+	languages, err := options.LanguageMapping.ParseLanguages(options.Languages)
+	if err != nil {
+		return err
+	}
+	err = checkVisibility(options.RepositoryMetadata.Visibility)
 	if err != nil {
 		return err
 	}
+	err = checkActionsPolicy(options.ActionsPolicy)
+	if err != nil {
+		return err
+	}
+	if options.PushSSH {
+		// Go-git uses the SSH implementation from `golang.org/x/crypto`, which is not part of the validated module.
+		err = fips.Check("Pushing over SSH with `--push-ssh`")
+		if err != nil {
+			return err
+		}
+	}
+	err = cacheDirectory.CheckOrCreateVersionFile(false, version.Version())
+	if err != nil {
+		return err
+	}
+	runLock, err := cacheDirectory.AcquireRunLock()
+	if err != nil {
+		return err
+	}
+	defer runLock.Release()
 	err = cacheDirectory.CheckLock()
 	if err != nil {
 		return err
 	}
 
-	destinationURL = strings.TrimRight(destinationURL, "/")
-	token := oauth2.Token{AccessToken: destinationToken}
+	options.DestinationURL = strings.TrimRight(options.DestinationURL, "/")
+	if githubapiutil.IsGHEDotCom(options.DestinationURL) && !options.NoSiteAdmin {
+		// There are no site admins on GHE.com, so organizations are created and managed by enterprise owners instead.
+		log.Debugf("%s is a GHE.com tenant, so site admin access will not be used.", options.DestinationURL)
+		options.NoSiteAdmin = true
+	}
+	token := oauth2.Token{AccessToken: options.DestinationToken}
 	tokenSource := oauth2.StaticTokenSource(
 		&token,
 	)
-	tokenClient := oauth2.NewClient(ctx, tokenSource)
-	client, err := github.NewEnterpriseClient(destinationURL+"/api/v3", destinationURL+"/api/uploads", tokenClient)
+	tokenClient := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, retry.NewClient(concurrency.NewTransport(nil, options.Limits.APIRequests))), tokenSource)
+	client, err := githubapiutil.NewClient(options.DestinationURL, tokenClient)
+	if err != nil {
+		return errors.Wrap(err, "Error creating GitHub Enterprise client.")
+	}
+	// Uploads are made with a separate client so that they do not count towards the limit on simultaneous API requests.
+	uploadClient, err := githubapiutil.NewClient(options.DestinationURL, oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, retry.NewClient(nil)), tokenSource))
 	if err != nil {
 		return errors.Wrap(err, "Error creating GitHub Enterprise client.")
 	}
 
-	destinationRepositorySplit := strings.Split(destinationRepository, "/")
+	destinationRepositorySplit := strings.Split(options.DestinationRepository, "/")
 	destinationRepositoryOwner := destinationRepositorySplit[0]
 	destinationRepositoryName := destinationRepositorySplit[1]
 
 	pushService := pushService{
-		ctx:                        ctx,
-		cacheDirectory:             cacheDirectory,
-		githubEnterpriseClient:     client,
-		destinationRepositoryOwner: destinationRepositoryOwner,
-		destinationRepositoryName:  destinationRepositoryName,
-		destinationToken:           &token,
-		actionsAdminUser:           actionsAdminUser,
-		force:                      force,
-		pushSSH:                    pushSSH,
+		ctx:                          ctx,
+		cacheDirectory:               cacheDirectory,
+		auditLog:                     audit.FromContext(ctx),
+		githubEnterpriseClient:       client,
+		githubEnterpriseUploadClient: uploadClient,
+		destinationURL:               options.DestinationURL,
+		requestedRepository:          options.DestinationRepository,
+		destinationRepositoryOwner:   destinationRepositoryOwner,
+		destinationRepositoryName:    destinationRepositoryName,
+		destinationToken:             &token,
+		actionsAdminUser:             options.ActionsAdminUser,
+		createOrganization:           options.CreateOrganization,
+		organizationAdmin:            options.OrganizationAdmin,
+		noSiteAdmin:                  options.NoSiteAdmin,
+		repositoryMetadata:           options.RepositoryMetadata,
+		actionsPolicy:                options.ActionsPolicy,
+		languageMapping:              options.LanguageMapping,
+		languages:                    languages,
+		force:                        options.Force,
+		safePush:                     options.SafePush,
+		releaseRefsOnly:              options.ReleaseRefsOnly,
+		keepStaleRefs:                !options.PruneRefs,
+		protectedRefs:                protectedRefPatterns(options.ProtectedRefs),
+		includeDrafts:                options.IncludeDrafts,
+		pushSSH:                      options.PushSSH,
+		verifyUploads:                options.VerifyUploads,
+		strict:                       options.Strict,
+		attestation:                  options.Attestation,
+		sbom:                         options.SBOM,
+		retention:                    options.RetentionPolicy,
+		startedAt:                    time.Now().UTC(),
+		showProgress:                 options.ShowProgress,
+		concurrency:                  options.Limits,
+	}
+
+	err = cacheDirectory.LoadGit()
+	if err != nil {
+		return err
+	}
+	pushService.sourceKind, err = cacheDirectory.ReadSourceKind()
+	if err != nil {
+		return errors.Wrap(err, "Error reading source kind from cache.")
+	}
+	err = pushService.findSkippedDrafts(true)
+	if err != nil {
+		return err
+	}
+	// Only the CodeQL Action has a minimum GitHub Enterprise Server version.
+	if pushService.isAction() {
+		err = pushService.checkCompatibility()
+		if err != nil {
+			return err
+		}
+	}
+	err = pushService.resolveDefaultBranch()
+	if err != nil {
+		return err
 	}
 
 	repository, err := pushService.createRepository()
 	if err != nil {
 		return err
 	}
+	// Git LFS objects are uploaded before any Git content, in the same way as Git LFS does, so that nothing on the destination refers to files it does not have.
+	err = lfs.Push(pushService.ctx, pushService.cacheDirectory, repository.GetCloneURL(), pushService.destinationToken.AccessToken)
+	if err != nil {
+		return err
+	}
+	err = pushService.pushSubmodules(repository)
+	if err != nil {
+		return err
+	}
 
 	// "He was going to live forever, or die in the attempt." - Catch-22, Joseph Heller
 	// We can't push the releases first because you can't create tags in an empty Git repository.
@@ -396,14 +1257,48 @@ func Push(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, des
 	if err != nil {
 		return err
 	}
+	// From here on the destination has been partly updated, so any failure leaves it in an intermediate state until the push is run again.
 	err = pushService.pushReleases()
 	if err != nil {
-		return err
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
 	}
 	err = pushService.pushGit(repository, false)
 	if err != nil {
-		return err
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
+	}
+	err = pushService.updateDefaultBranch(repository)
+	if err != nil {
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
+	}
+	// Only the CodeQL Action is an action that the Actions policy needs to allow.
+	if pushService.isAction() {
+		err = pushService.updateActionsPolicy()
+		if err != nil {
+			return exitcode.WithCode(err, exitcode.PartialSuccess)
+		}
+	}
+	err = pushService.checkPushedReferences()
+	if err != nil {
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
+	}
+	err = pushService.updateLatestRelease()
+	if err != nil {
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
+	}
+	err = pushService.recordDestination(repository.GetID())
+	if err != nil {
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
+	}
+	err = pushService.clearJournal()
+	if err != nil {
+		return exitcode.WithCode(err, exitcode.PartialSuccess)
+	}
+	if options.Verify && pushService.isAction() {
+		err = pushService.verifyActions()
+		if err != nil {
+			return exitcode.WithCode(err, exitcode.PartialSuccess)
+		}
 	}
-	log.Infof("Finished pushing CodeQL Action to %s!", destinationRepository)
+	log.Infof("Finished pushing CodeQL Action to %s!", pushService.destinationRepository())
 	return nil
 }
@@ -3,16 +3,30 @@ package push
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"path"
+	"runtime"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/github/codeql-action-sync/internal/assetselection"
+	"github.com/github/codeql-action-sync/internal/audit"
 	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/failures"
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/github/codeql-action-sync/internal/progress"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/internal/retry"
 	"github.com/github/codeql-action-sync/test"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
@@ -22,6 +36,10 @@ import (
 
 func getTestPushService(t *testing.T, cacheDirectoryString string, githubEnterpriseURL string) pushService {
 	cacheDirectory := cachedirectory.NewCacheDirectory(cacheDirectoryString)
+	if strings.HasPrefix(cacheDirectoryString, "./push_test/") {
+		// Pushing records a journal in the cache, which is kept in a temporary work directory rather than written into the test data.
+		require.NoError(t, cacheDirectory.SetReadOnly(test.CreateTemporaryDirectory(t)))
+	}
 	var githubEnterpriseClient *github.Client
 	if githubEnterpriseURL != "" {
 		client, err := github.NewEnterpriseClient(githubEnterpriseURL+"/api/v3", githubEnterpriseURL+"/api/uploads", &http.Client{})
@@ -32,15 +50,25 @@ func getTestPushService(t *testing.T, cacheDirectoryString string, githubEnterpr
 	}
 	token := oauth2.Token{AccessToken: "token"}
 	return pushService{
-		ctx:                        context.Background(),
-		cacheDirectory:             cacheDirectory,
-		githubEnterpriseClient:     githubEnterpriseClient,
-		destinationRepositoryOwner: "destination-repository-owner",
-		destinationRepositoryName:  "destination-repository-name",
-		destinationToken:           &token,
+		ctx:                          context.Background(),
+		cacheDirectory:               cacheDirectory,
+		githubEnterpriseClient:       githubEnterpriseClient,
+		githubEnterpriseUploadClient: githubEnterpriseClient,
+		destinationRepositoryOwner:   "destination-repository-owner",
+		destinationRepositoryName:    "destination-repository-name",
+		destinationToken:             &token,
+		createOrganization:           true,
+		languageMapping:              assetselection.DefaultMapping(),
 	}
 }
 
+// serveTestTopics accepts the topics set on a new destination repository.
+func serveTestTopics(t *testing.T, githubTestServer *mux.Router) {
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/topics", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, map[string][]string{}, response)
+	}).Methods("PUT")
+}
+
 func TestCreateRepositoryWhenUserIsOwner(t *testing.T) {
 	temporaryDirectory := test.CreateTemporaryDirectory(t)
 	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
@@ -54,6 +82,7 @@ func TestCreateRepositoryWhenUserIsOwner(t *testing.T) {
 	githubTestServer.HandleFunc("/api/v3/user/repos", func(response http.ResponseWriter, request *http.Request) {
 		test.ServeHTTPResponseFromObject(t, github.Repository{}, response)
 	}).Methods("POST")
+	serveTestTopics(t, githubTestServer)
 	_, err := pushService.createRepository()
 	require.NoError(t, err)
 }
@@ -66,7 +95,7 @@ func TestUpdateRepositoryWhenUserIsOwner(t *testing.T) {
 		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
 	}).Methods("GET")
 	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
-		test.ServeHTTPResponseFromObject(t, github.Repository{Homepage: github.String(repositoryHomepage)}, response)
+		test.ServeHTTPResponseFromObject(t, github.Repository{Homepage: github.String(DefaultRepositoryHomepage)}, response)
 	}).Methods("GET")
 	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
 		test.ServeHTTPResponseFromObject(t, github.Repository{}, response)
@@ -120,10 +149,56 @@ func TestCreateOrganizationAndRepositoryWhenOrganizationIsOwner(t *testing.T) {
 	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner/repos", func(response http.ResponseWriter, request *http.Request) {
 		test.ServeHTTPResponseFromObject(t, github.Repository{}, response)
 	}).Methods("POST")
+	serveTestTopics(t, githubTestServer)
 	_, err := pushService.createRepository()
 	require.NoError(t, err)
 }
 
+func TestCreateOrganizationWithConfiguredAdmin(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.organizationAdmin = "organization-admin"
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("user")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/admin/organizations", func(response http.ResponseWriter, request *http.Request) {
+		body := struct {
+			Admin string `json:"admin"`
+		}{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&body))
+		require.Equal(t, "organization-admin", body.Admin)
+		test.ServeHTTPResponseFromObject(t, github.Organization{}, response)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner/repos", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{}, response)
+	}).Methods("POST")
+	serveTestTopics(t, githubTestServer)
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+}
+
+func TestMissingOrganizationIsNotCreatedWhenDisabled(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.createOrganization = false
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("user")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	_, err := pushService.createRepository()
+	require.EqualError(t, err, "The destination organization destination-repository-owner does not exist. Create it, or run with `--create-org` to create it automatically, which needs a token with the `site_admin` scope.")
+}
+
 func TestPushGit(t *testing.T) {
 	temporaryDirectory := test.CreateTemporaryDirectory(t)
 	destinationPath := path.Join(temporaryDirectory, "target")
@@ -184,12 +259,28 @@ func TestPushGit(t *testing.T) {
 	})
 }
 
+func TestPushGitPreservesSignedTags(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	tagHash := test.CreateRepositoryWithSignedTag(t, pushService.cacheDirectory.GitPath(), "v1.0.0")
+	destinationPath := path.Join(temporaryDirectory, "target")
+	_, err := git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+	repository := github.Repository{
+		CloneURL: github.String(destinationPath),
+	}
+
+	require.NoError(t, pushService.pushGit(&repository, false))
+	test.CheckSignedTagInRepository(t, destinationPath, "v1.0.0", tagHash)
+}
+
 func TestPushReleases(t *testing.T) {
 	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
 	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
 	existingReleases := map[string]github.RepositoryRelease{}
 	existingAssets := map[int][]github.ReleaseAsset{}
 	existingAssetBodys := map[int]map[string][]byte{}
+	uploadCount := 0
 	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/tags/{tag}", func(response http.ResponseWriter, request *http.Request) {
 		vars := mux.Vars(request)
 		if value, ok := existingReleases[vars["tag"]]; ok {
@@ -223,6 +314,7 @@ func TestPushReleases(t *testing.T) {
 			Name: github.String(assetName),
 		}
 		existingAssets[releaseID] = append(existingAssets[releaseID], asset)
+		uploadCount++
 		if existingAssetBodys[releaseID] == nil {
 			existingAssetBodys[releaseID] = map[string][]byte{}
 		}
@@ -230,6 +322,591 @@ func TestPushReleases(t *testing.T) {
 		require.NoError(t, err)
 		test.ServeHTTPResponseFromObject(t, asset, response)
 	}).Methods("POST")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/{id:[0-9]+}", func(response http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+		releaseID, err := strconv.ParseInt(vars["id"], 10, 64)
+		require.NoError(t, err)
+		edit := github.RepositoryRelease{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&edit))
+		for tag, release := range existingReleases {
+			if release.GetID() == releaseID {
+				release.Body = edit.Body
+				existingReleases[tag] = release
+				test.ServeHTTPResponseFromObject(t, release, response)
+				return
+			}
+		}
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("PATCH")
 	err := pushService.pushReleases()
 	require.NoError(t, err)
+	for _, release := range existingReleases {
+		require.NotEmpty(t, releaseMarkerDigest(release.GetBody()))
+	}
+
+	// The releases are marked as up to date, so pushing again only reads them.
+	require.Equal(t, 2, uploadCount)
+	err = pushService.pushReleases()
+	require.NoError(t, err)
+	require.Equal(t, 2, uploadCount)
+}
+
+func TestPushReleasesContinueOnError(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	collector := failures.NewCollector()
+	pushService.ctx = failures.WithCollector(pushService.ctx, collector)
+	releaseIDs := map[string]int64{"codeql-bundle-20200101": 1, "codeql-bundle-20200630": 2}
+	marked := []int64{}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/tags/{tag}", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases", func(response http.ResponseWriter, request *http.Request) {
+		release := github.RepositoryRelease{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&release))
+		release.ID = github.Int64(releaseIDs[release.GetTagName()])
+		test.ServeHTTPResponseFromObject(t, release, response)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/{id:[0-9]+}/assets", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, []github.ReleaseAsset{}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/uploads/repos/destination-repository-owner/destination-repository-name/releases/{id:[0-9]+}/assets", func(response http.ResponseWriter, request *http.Request) {
+		if mux.Vars(request)["id"] == "1" {
+			response.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		_, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		test.ServeHTTPResponseFromObject(t, github.ReleaseAsset{ID: github.Int64(rand.Int63()), Name: github.String(request.URL.Query().Get("name"))}, response)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/{id:[0-9]+}", func(response http.ResponseWriter, request *http.Request) {
+		releaseID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+		require.NoError(t, err)
+		marked = append(marked, releaseID)
+		test.ServeHTTPResponseFromObject(t, github.RepositoryRelease{ID: github.Int64(releaseID)}, response)
+	}).Methods("PATCH")
+
+	require.NoError(t, pushService.pushReleases())
+	require.True(t, collector.Failed("codeql-bundle-20200101"))
+	require.False(t, collector.Failed("codeql-bundle-20200630"))
+	// The release whose asset could not be uploaded is not marked as up to date, so it is pushed again next time.
+	require.Equal(t, []int64{2}, marked)
+}
+
+func TestPushReleasesPreservesReleaseNotes(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	body := "## CodeQL bundle\n\nBundles CodeQL CLI v2.2.1, including a new `--threads` option."
+	metadata, err := json.Marshal(github.RepositoryRelease{TagName: github.String("codeql-bundle-20200630"), Name: github.String("CodeQL Bundle"), Body: github.String(body)})
+	require.NoError(t, err)
+	require.NoError(t, pushService.cacheDirectory.WriteMetadata("codeql-bundle-20200630", metadata))
+
+	releaseMetadata, err := pushService.readReleaseMetadata("codeql-bundle-20200630")
+	require.NoError(t, err)
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases", func(response http.ResponseWriter, request *http.Request) {
+		release := github.RepositoryRelease{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&release))
+		require.Equal(t, body, release.GetBody())
+		release.ID = github.Int64(1)
+		test.ServeHTTPResponseFromObject(t, release, response)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/1", func(response http.ResponseWriter, request *http.Request) {
+		edit := github.RepositoryRelease{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&edit))
+		require.Equal(t, body, withoutReleaseMarker(edit.GetBody()))
+		require.Equal(t, strings.Repeat("0123456789abcdef", 4), releaseMarkerDigest(edit.GetBody()))
+		test.ServeHTTPResponseFromObject(t, edit, response)
+	}).Methods("PATCH")
+	release, err := pushService.createOrUpdateRelease("codeql-bundle-20200630", releaseMetadata, nil)
+	require.NoError(t, err)
+	require.NoError(t, pushService.markRelease(release, releaseMetadata.GetBody(), strings.Repeat("0123456789abcdef", 4)))
+}
+
+func TestReleaseNamesKeepLast(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	repository, err := git.PlainInit(pushService.cacheDirectory.GitPath(), true)
+	require.NoError(t, err)
+	remote, err := repository.CreateRemote(&config.RemoteConfig{Name: "initial", URLs: []string{"./push_test/action-cache-initial/git"}})
+	require.NoError(t, err)
+	require.NoError(t, remote.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}}))
+	for _, releaseName := range []string{"codeql-bundle-20200101", "some-codeql-version-on-main", "some-codeql-version-on-v1-and-v2"} {
+		require.NoError(t, pushService.cacheDirectory.WriteMetadata(releaseName, []byte(`{"tag_name": "`+releaseName+`"}`)))
+	}
+
+	releaseNames, err := pushService.releaseNames()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"codeql-bundle-20200101", "some-codeql-version-on-main", "some-codeql-version-on-v1-and-v2"}, releaseNames)
+
+	// The old release is still in the cache, but is no longer used by any major version of the CodeQL Action.
+	pushService.retention.KeepLast = 1
+	releaseNames, err = pushService.releaseNames()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"some-codeql-version-on-main", "some-codeql-version-on-v1-and-v2"}, releaseNames)
+}
+
+func TestSelectReleasesSince(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	pushService.destinationURL = "https://ghe.example.com"
+	pushService.requestedRepository = pushService.destinationRepository()
+	writeTestLatestReleases(t, &pushService.cacheDirectory)
+
+	pushService.retention = retention.Policy{Since: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}
+	releaseNames, alreadyPushed, err := pushService.selectReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200630", "codeql-bundle-20200701"}, releaseNames)
+	require.Empty(t, alreadyPushed)
+
+	// Without a previous push to the destination, everything is pushed.
+	pushService.retention = retention.Policy{LastSync: true}
+	releaseNames, _, err = pushService.selectReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200101", "codeql-bundle-20200630", "codeql-bundle-20200701"}, releaseNames)
+
+	// Only releases that the last push pushed and were published before it are left out.
+	destinations, err := json.Marshal([]destination{{
+		URL:                 pushService.destinationURL,
+		RequestedRepository: pushService.requestedRepository,
+		Releases:            []string{"codeql-bundle-20200101", "codeql-bundle-20200701"},
+		PushedAt:            time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC),
+	}})
+	require.NoError(t, err)
+	require.NoError(t, pushService.cacheDirectory.WriteDestinations(destinations))
+	releaseNames, alreadyPushed, err = pushService.selectReleases()
+	require.NoError(t, err)
+	require.Equal(t, []string{"codeql-bundle-20200630", "codeql-bundle-20200701"}, releaseNames)
+	require.Equal(t, []string{"codeql-bundle-20200101"}, alreadyPushed)
+}
+
+func TestReleaseMarker(t *testing.T) {
+	digest := strings.Repeat("0123456789abcdef", 4)
+	require.Equal(t, "", releaseMarkerDigest("Some release notes."))
+	body := withReleaseMarker("Some release notes.", digest)
+	require.Equal(t, "Some release notes.\n\n<!-- codeql-action-sync-digest: "+digest+" -->", body)
+	require.Equal(t, digest, releaseMarkerDigest(body))
+	require.Equal(t, "Some release notes.", withoutReleaseMarker(body))
+	otherDigest := strings.Repeat("fedcba9876543210", 4)
+	require.Equal(t, otherDigest, releaseMarkerDigest(withReleaseMarker(body, otherDigest)))
+}
+
+func TestNeedsUpload(t *testing.T) {
+	asset := cachedirectory.Asset{Name: "bundle.bin", Size: 100}
+	existing := &github.ReleaseAsset{ID: github.Int64(7), Name: github.String("bundle.bin"), Size: github.Int(100)}
+	require.True(t, needsUpload(assetUpload{asset: asset, digest: "digest"}, map[int64]string{}))
+	require.True(t, needsUpload(assetUpload{asset: asset, existing: &github.ReleaseAsset{ID: github.Int64(7), Size: github.Int(99)}, digest: "digest"}, map[int64]string{7: "digest"}))
+	require.True(t, needsUpload(assetUpload{asset: asset, existing: existing, digest: "digest"}, map[int64]string{}))
+	require.True(t, needsUpload(assetUpload{asset: asset, existing: existing, digest: "digest"}, map[int64]string{7: "different-digest"}))
+	require.False(t, needsUpload(assetUpload{asset: asset, existing: existing, digest: "digest"}, map[int64]string{7: "digest"}))
+}
+
+func TestReplaceChangedReleaseAsset(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	pushService.uploadProgress = progress.NewReporter(ioutil.Discard, "Uploading", false)
+	pushService.assetDigests = map[int64]string{}
+	assets, err := pushService.cacheDirectory.ListAssets("codeql-bundle-20200101")
+	require.NoError(t, err)
+	require.Len(t, assets, 1)
+	digest, err := pushService.cacheDirectory.AssetDigest("codeql-bundle-20200101", assets[0].Name)
+	require.NoError(t, err)
+	deleted := false
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/assets/7", func(response http.ResponseWriter, request *http.Request) {
+		deleted = true
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+	githubTestServer.HandleFunc("/api/uploads/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		require.True(t, deleted)
+		test.ServeHTTPResponseFromObject(t, github.ReleaseAsset{ID: github.Int64(8), Name: github.String(assets[0].Name)}, response)
+	}).Methods("POST")
+	upload := assetUpload{
+		release:  &github.RepositoryRelease{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200101")},
+		existing: &github.ReleaseAsset{ID: github.Int64(7), Name: github.String(assets[0].Name), Size: github.Int(int(assets[0].Size))},
+		asset:    assets[0],
+		digest:   digest,
+	}
+	err = pushService.createOrUpdateReleaseAsset(upload, map[int64]string{7: "a-checksum-of-different-content"})
+	require.NoError(t, err)
+	require.Equal(t, map[int64]string{8: digest}, pushService.recordedAssetDigests())
+}
+
+func TestCreateReleaseRetriesOnce(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	pushService.ctx = retry.WithPolicy(pushService.ctx, retry.Policy{Retries: 2, Delay: time.Millisecond})
+	creates := 0
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases", func(response http.ResponseWriter, request *http.Request) {
+		creates++
+		response.WriteHeader(http.StatusBadGateway)
+	}).Methods("POST")
+	checks := 0
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/tags/codeql-bundle-20200101", func(response http.ResponseWriter, request *http.Request) {
+		checks++
+		response.WriteHeader(http.StatusBadGateway)
+	}).Methods("GET")
+	_, err := pushService.createOrUpdateRelease("codeql-bundle-20200101", &github.RepositoryRelease{TagName: github.String("codeql-bundle-20200101")}, nil)
+	require.Error(t, err)
+	// Each retry checks whether an earlier attempt created the release, without retrying that check on its own.
+	require.Equal(t, 1, creates)
+	require.Equal(t, 2, checks)
+}
+
+func TestRetryFailedReleaseAssetUpload(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	pushService.ctx = retry.WithPolicy(pushService.ctx, retry.Policy{Retries: 2, Delay: time.Millisecond})
+	pushService.uploadProgress = progress.NewReporter(ioutil.Discard, "Uploading", false)
+	pushService.assetDigests = map[int64]string{}
+	assets, err := pushService.cacheDirectory.ListAssets("codeql-bundle-20200101")
+	require.NoError(t, err)
+	require.Len(t, assets, 1)
+	attempts := 0
+	githubTestServer.HandleFunc("/api/uploads/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		attempts++
+		_, err := io.Copy(ioutil.Discard, request.Body)
+		require.NoError(t, err)
+		if attempts == 1 {
+			response.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		test.ServeHTTPResponseFromObject(t, github.ReleaseAsset{ID: github.Int64(8), Name: github.String(assets[0].Name)}, response)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		// The failed upload left an incomplete asset behind, which must be removed before trying again.
+		if request.URL.Query().Get("page") == "1" {
+			test.ServeHTTPResponseFromObject(t, []github.ReleaseAsset{{ID: github.Int64(7), Name: github.String(assets[0].Name)}}, response)
+			return
+		}
+		test.ServeHTTPResponseFromObject(t, []github.ReleaseAsset{}, response)
+	}).Methods("GET")
+	deleted := false
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/assets/7", func(response http.ResponseWriter, request *http.Request) {
+		deleted = true
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+	upload := assetUpload{
+		release: &github.RepositoryRelease{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200101")},
+		asset:   assets[0],
+		digest:  "a-checksum",
+	}
+	err = pushService.createOrUpdateReleaseAsset(upload, map[int64]string{})
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+	require.True(t, deleted)
+	require.Equal(t, map[int64]string{8: "a-checksum"}, pushService.recordedAssetDigests())
+}
+
+func TestFailedReleaseAssetUploadGivesUp(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	pushService.ctx = retry.WithPolicy(pushService.ctx, retry.Policy{Retries: 2, Delay: time.Millisecond})
+	pushService.uploadProgress = progress.NewReporter(ioutil.Discard, "Uploading", false)
+	pushService.assetDigests = map[int64]string{}
+	assets, err := pushService.cacheDirectory.ListAssets("codeql-bundle-20200101")
+	require.NoError(t, err)
+	attempts := 0
+	githubTestServer.HandleFunc("/api/uploads/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		attempts++
+		_, err := io.Copy(ioutil.Discard, request.Body)
+		require.NoError(t, err)
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}).Methods("POST")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, []github.ReleaseAsset{}, response)
+	}).Methods("GET")
+	upload := assetUpload{
+		release: &github.RepositoryRelease{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200101")},
+		asset:   assets[0],
+		digest:  "a-checksum",
+	}
+	err = pushService.createOrUpdateReleaseAsset(upload, map[int64]string{})
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+}
+
+type repeatingReader struct{}
+
+func (repeatingReader) Read(data []byte) (int, error) {
+	for index := range data {
+		data[index] = 'x'
+	}
+	return len(data), nil
+}
+
+func TestUploadReleaseAssetStreams(t *testing.T) {
+	const size = 256 * 1024 * 1024
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	received := int64(0)
+	githubTestServer.HandleFunc("/api/uploads/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		count, err := io.Copy(ioutil.Discard, request.Body)
+		require.NoError(t, err)
+		received = count
+		test.ServeHTTPResponseFromObject(t, github.ReleaseAsset{ID: github.Int64(1)}, response)
+	}).Methods("POST")
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	_, _, err := pushService.uploadReleaseAsset(&github.RepositoryRelease{ID: github.Int64(1)}, cachedirectory.Asset{Name: "codeql-bundle.tar.gz", Size: size}, "", "", io.LimitReader(repeatingReader{}, size))
+	require.NoError(t, err)
+	runtime.ReadMemStats(&after)
+	require.Equal(t, int64(size), received)
+	// The asset is streamed rather than read into memory, so far less is allocated than the size of the asset.
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(size/8))
+}
+
+func TestUploadReleaseAssetKeepsLabelAndContentType(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	var query url.Values
+	var contentType string
+	githubTestServer.HandleFunc("/api/uploads/repos/destination-repository-owner/destination-repository-name/releases/1/assets", func(response http.ResponseWriter, request *http.Request) {
+		query, contentType = request.URL.Query(), request.Header.Get("Content-Type")
+		test.ServeHTTPResponseFromObject(t, github.ReleaseAsset{ID: github.Int64(1)}, response)
+	}).Methods("POST")
+
+	_, _, err := pushService.uploadReleaseAsset(&github.RepositoryRelease{ID: github.Int64(1)}, cachedirectory.Asset{Name: "codeql-bundle.tar.gz", Size: 3}, "CodeQL bundle & extractors", "application/x-gzip", strings.NewReader("abc"))
+	require.NoError(t, err)
+	require.Equal(t, "codeql-bundle.tar.gz", query.Get("name"))
+	require.Equal(t, "CodeQL bundle & extractors", query.Get("label"))
+	require.Equal(t, "application/x-gzip", contentType)
+
+	_, _, err = pushService.uploadReleaseAsset(&github.RepositoryRelease{ID: github.Int64(1)}, cachedirectory.Asset{Name: "codeql-bundle.json", Size: 2}, "", "", strings.NewReader("{}"))
+	require.NoError(t, err)
+	_, hasLabel := query["label"]
+	require.False(t, hasLabel)
+	// Without a content type from the source, it is guessed from the name.
+	require.Equal(t, "application/json", contentType)
+}
+
+func TestCreateOrUpdateReleaseAssetUpdatesLabel(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	pushService.assetDigests = map[int64]string{}
+	edited := github.ReleaseAsset{}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/assets/7", func(response http.ResponseWriter, request *http.Request) {
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&edited))
+		test.ServeHTTPResponseFromObject(t, edited, response)
+	}).Methods("PATCH")
+
+	// The asset is already up to date, so only its label is changed rather than uploading it again.
+	upload := assetUpload{
+		release:  &github.RepositoryRelease{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200630")},
+		existing: &github.ReleaseAsset{ID: github.Int64(7), Name: github.String("codeql-bundle.tar.gz"), Size: github.Int(3), Label: github.String("An old label")},
+		asset:    cachedirectory.Asset{Name: "codeql-bundle.tar.gz", Size: 3},
+		digest:   "digest",
+		label:    "A new label",
+	}
+	require.NoError(t, pushService.createOrUpdateReleaseAsset(upload, map[int64]string{7: "digest"}))
+	require.Equal(t, "codeql-bundle.tar.gz", edited.GetName())
+	require.Equal(t, "A new label", edited.GetLabel())
+}
+
+func TestNeedsUploadLargeAsset(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skip("The API cannot give sizes of 2 GiB or more on 32-bit platforms.")
+	}
+	// The size of a simulated asset larger than 2 GiB is compared without being truncated.
+	var size int64 = 5 * 1024 * 1024 * 1024
+	upload := assetUpload{asset: cachedirectory.Asset{Name: "codeql-bundle.tar.gz", Size: size}, existing: &github.ReleaseAsset{ID: github.Int64(1), Size: github.Int(int(size))}, digest: "digest"}
+	require.False(t, needsUpload(upload, map[int64]string{1: "digest"}))
+	upload.existing.Size = github.Int(int(size - 4*1024*1024*1024))
+	require.True(t, needsUpload(upload, map[int64]string{1: "digest"}))
+}
+
+func TestSelectAssets(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	pushService.languages = []string{"java"}
+	assets, err := pushService.selectAssets([]cachedirectory.Asset{
+		{Name: "codeql-bundle.tar.gz", Size: 1000},
+		{Name: "codeql-bundle-java.tar.gz", Size: 100},
+		{Name: "codeql-bundle-python.tar.gz", Size: 100},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cachedirectory.Asset{{Name: "codeql-bundle-java.tar.gz", Size: 100}}, assets)
+
+	pushService.languages = nil
+	require.NoError(t, pushService.languageMapping.SetAssetPatterns([]string{"*.tar.gz"}, nil))
+	assets, err = pushService.selectAssets([]cachedirectory.Asset{
+		{Name: "codeql-bundle.tar.gz", Size: 1000},
+		{Name: "codeql-bundle.tar.zst", Size: 800},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cachedirectory.Asset{{Name: "codeql-bundle.tar.gz", Size: 1000}}, assets)
+}
+
+func TestCreateRepositoryIsAudited(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	auditLogPath := path.Join(temporaryDirectory, "audit.jsonl")
+	auditLog, err := audit.Open(auditLogPath)
+	require.NoError(t, err)
+	pushService.auditLog = auditLog
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/user/repos", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{FullName: github.String("destination-repository-owner/destination-repository-name")}, response)
+	}).Methods("POST")
+	serveTestTopics(t, githubTestServer)
+	_, err = pushService.createRepository()
+	require.NoError(t, err)
+	require.NoError(t, auditLog.Close())
+
+	content, err := ioutil.ReadFile(auditLogPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	entry := audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "destination-repository-owner", entry.Actor)
+	require.Equal(t, audit.ActionCreateRepository, entry.Action)
+	require.Equal(t, "destination-repository-owner/destination-repository-name", entry.Target)
+	// The default topics are set on the new repository.
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Equal(t, audit.ActionReplaceTopics, entry.Action)
+}
+
+func TestCreateRepositorySetsMetadata(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.repositoryMetadata = RepositoryMetadata{
+		Description: "Maintained by the platform team.",
+		Homepage:    "https://wiki.example.com/codeql",
+		Topics:      []string{"codeql", "mirror"},
+	}
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/user/repos", func(response http.ResponseWriter, request *http.Request) {
+		repository := github.Repository{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&repository))
+		require.Equal(t, "Maintained by the platform team.", repository.GetDescription())
+		require.Equal(t, "https://wiki.example.com/codeql", repository.GetHomepage())
+		test.ServeHTTPResponseFromObject(t, repository, response)
+	}).Methods("POST")
+	topicsReplaced := false
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/topics", func(response http.ResponseWriter, request *http.Request) {
+		topics := map[string][]string{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&topics))
+		require.Equal(t, []string{"codeql", "mirror"}, topics["names"])
+		topicsReplaced = true
+		test.ServeHTTPResponseFromObject(t, topics, response)
+	}).Methods("PUT")
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+	require.True(t, topicsReplaced)
+}
+
+func TestCreateRepositorySetsDefaultMetadata(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/user/repos", func(response http.ResponseWriter, request *http.Request) {
+		repository := github.Repository{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&repository))
+		require.Equal(t, DefaultRepositoryDescription, repository.GetDescription())
+		test.ServeHTTPResponseFromObject(t, repository, response)
+	}).Methods("POST")
+	topicsReplaced := false
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/topics", func(response http.ResponseWriter, request *http.Request) {
+		topics := map[string][]string{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&topics))
+		require.Equal(t, DefaultRepositoryTopics, topics["names"])
+		topicsReplaced = true
+		test.ServeHTTPResponseFromObject(t, topics, response)
+	}).Methods("PUT")
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+	require.True(t, topicsReplaced)
+}
+
+func TestUpdateRepositoryKeepsMetadata(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{Homepage: github.String(DefaultRepositoryHomepage), Description: github.String("Edited by an admin.")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		// An existing repository keeps the description and topics an admin has given it, as none were given to the sync tool.
+		repository := github.Repository{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&repository))
+		require.Nil(t, repository.Description)
+		test.ServeHTTPResponseFromObject(t, repository, response)
+	}).Methods("PATCH")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/topics", func(response http.ResponseWriter, request *http.Request) {
+		t.Error("The topics of an existing repository were replaced.")
+	}).Methods("PUT")
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+}
+
+func TestUpdateRepositoryWithCustomHomepage(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.repositoryMetadata = RepositoryMetadata{Homepage: "https://wiki.example.com/codeql"}
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{Homepage: github.String("https://wiki.example.com/codeql")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{}, response)
+	}).Methods("PATCH")
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+}
+
+func TestUpdateRepositoryWithoutSiteAdminSkipsSettings(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, createTestActionCache(t), githubEnterpriseURL)
+	pushService.noSiteAdmin = true
+	recorder := report.NewRecorder()
+	pushService.ctx = report.WithRecorder(pushService.ctx, recorder)
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		// Even with the `site_admin` scope, the Actions admin user is not impersonated.
+		response.Header().Set("X-OAuth-Scopes", "repo, workflow, site_admin")
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("other-user")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Organization{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner/memberships/other-user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Membership{State: github.String("active")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{
+			FullName:    github.String("destination-repository-owner/destination-repository-name"),
+			Homepage:    github.String(DefaultRepositoryHomepage),
+			Permissions: &map[string]bool{"admin": false, "push": true, "pull": true},
+		}, response)
+	}).Methods("GET")
+
+	repository, err := pushService.createRepository()
+	require.NoError(t, err)
+	require.Equal(t, "destination-repository-owner/destination-repository-name", repository.GetFullName())
+	require.Equal(t, []string{"updating the settings and topics of destination-repository-owner/destination-repository-name, as you are not an admin of it"}, recorder.Document(notify.Summary{}).SkippedSteps)
 }
@@ -0,0 +1,70 @@
+package push
+
+import (
+	"fmt"
+
+	"github.com/github/codeql-action-sync/internal/bytesize"
+	"github.com/google/go-github/v32/github"
+	log "github.com/sirupsen/logrus"
+)
+
+// maximumAssetSize is the size from which GitHub rejects release assets. The release upload API takes each asset as the body of a single request and has no way to upload one in parts, so these assets cannot be pushed in chunks either.
+const maximumAssetSize = 2 * 1024 * 1024 * 1024
+
+// remainingStorage returns how many more bytes the owner of the destination repository can store, or -1 if its storage is not limited. GitHub Enterprise Server does not normally limit storage, in which case the owner has no plan.
+func (pushService *pushService) remainingStorage() (int64, error) {
+	var plan *github.Plan
+	diskUsage := 0
+	if pushService.destinationOrganization != "" {
+		organization, _, err := pushService.githubEnterpriseClient.Organizations.Get(pushService.ctx, pushService.destinationOrganization)
+		if err != nil {
+			return -1, err
+		}
+		plan, diskUsage = organization.GetPlan(), organization.GetDiskUsage()
+	} else {
+		// The plan of a user is only shown to the user themselves.
+		user, _, err := pushService.githubEnterpriseClient.Users.Get(pushService.ctx, "")
+		if err != nil {
+			return -1, err
+		}
+		plan, diskUsage = user.GetPlan(), user.GetDiskUsage()
+	}
+	if plan.GetSpace() <= 0 {
+		return -1, nil
+	}
+	// Both the space of a plan and the disk usage are in kilobytes.
+	remaining := (int64(plan.GetSpace()) - int64(diskUsage)) * 1024
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, nil
+}
+
+// storageWarnings explains why the planned uploads are likely to be rejected part of the way through, given how much storage the owner of the destination repository has left, or -1 if it is not limited.
+func storageWarnings(uploads []assetUpload, uploadedDigests map[int64]string, totalSize int64, remaining int64, owner string) []string {
+	warnings := []string{}
+	for _, upload := range uploads {
+		if upload.asset.Size >= maximumAssetSize && needsUpload(upload, uploadedDigests) {
+			warnings = append(warnings, fmt.Sprintf("The asset %s of release %s is %s, but GitHub does not accept release assets of %s or more, so uploading it is likely to fail.", upload.asset.Name, upload.release.GetTagName(), bytesize.Format(upload.asset.Size), bytesize.Format(maximumAssetSize)))
+		}
+	}
+	if remaining >= 0 && totalSize > remaining {
+		warnings = append(warnings, fmt.Sprintf("The release assets to upload total %s, but %s only has %s of its storage quota left, so the upload is likely to fail part of the way through. Free up storage or raise the quota of %s before pushing again.", bytesize.Format(totalSize), owner, bytesize.Format(remaining), owner))
+	}
+	return warnings
+}
+
+// checkStorage warns before any release assets are uploaded if GitHub is likely to reject some of them, rather than leaving the upload to fail part of the way through. The upload is still attempted, as the limits of GitHub Enterprise Server can be configured differently.
+func (pushService *pushService) checkStorage(uploads []assetUpload, uploadedDigests map[int64]string, totalSize int64) {
+	if totalSize == 0 {
+		return
+	}
+	remaining, err := pushService.remainingStorage()
+	if err != nil {
+		log.Debugf("Could not find the storage quota of %s, so the upload will not be checked against it: %s", pushService.destinationRepositoryOwner, err)
+		remaining = -1
+	}
+	for _, warning := range storageWarnings(uploads, uploadedDigests, totalSize, remaining, pushService.destinationRepositoryOwner) {
+		log.Warn(warning)
+	}
+}
@@ -0,0 +1,52 @@
+package push
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemainingStorage(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.destinationOrganization = "destination-repository-owner"
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Organization{Plan: &github.Plan{Space: github.Int(1000)}, DiskUsage: github.Int(400)}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+
+	remaining, err := pushService.remainingStorage()
+	require.NoError(t, err)
+	require.Equal(t, int64(600*1024), remaining)
+
+	// A user without a plan has no storage limit.
+	pushService.destinationOrganization = ""
+	remaining, err = pushService.remainingStorage()
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), remaining)
+}
+
+func TestStorageWarnings(t *testing.T) {
+	release := &github.RepositoryRelease{TagName: github.String("codeql-bundle-20200101")}
+	uploads := []assetUpload{
+		{release: release, asset: cachedirectory.Asset{Name: "codeql-bundle.tar.gz", Size: 100}},
+		{release: release, asset: cachedirectory.Asset{Name: "huge.tar.gz", Size: maximumAssetSize}},
+		{release: release, asset: cachedirectory.Asset{Name: "uploaded.tar.gz", Size: maximumAssetSize}, existing: &github.ReleaseAsset{ID: github.Int64(1), Size: github.Int(maximumAssetSize)}, digest: "digest"},
+	}
+	uploadedDigests := map[int64]string{1: "digest"}
+
+	require.Equal(t, []string{
+		"The asset huge.tar.gz of release codeql-bundle-20200101 is 2.0 GiB, but GitHub does not accept release assets of 2.0 GiB or more, so uploading it is likely to fail.",
+	}, storageWarnings(uploads, uploadedDigests, maximumAssetSize+100, -1, "owner"))
+	require.Equal(t, []string{
+		"The release assets to upload total 1.0 KiB, but owner only has 512 B of its storage quota left, so the upload is likely to fail part of the way through. Free up storage or raise the quota of owner before pushing again.",
+	}, storageWarnings(uploads[:1], uploadedDigests, 1024, 512, "owner"))
+	require.Empty(t, storageWarnings(uploads[:1], uploadedDigests, 100, 512, "owner"))
+}
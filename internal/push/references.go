@@ -0,0 +1,79 @@
+package push
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// pullRequestReferencesPrefix is where GitHub keeps the references of pull requests, which it manages itself and are never pushed.
+const pullRequestReferencesPrefix = "refs/pull/"
+
+// destinationReferences lists the Git references of the destination with the API. This is separate from the Git protocol used to push, so it shows what the destination ended up with rather than what the push reported.
+func (pushService *pushService) destinationReferences() ([]*plumbing.Reference, error) {
+	result := []*plumbing.Reference{}
+	for page := 1; page != 0; {
+		var references []*github.Reference
+		var response *github.Response
+		err := retry.Do(pushService.ctx, "listing Git references", func(attempt int) error {
+			// The version of the GitHub API client in use only supports listing matching references, which older versions of GitHub Enterprise Server do not.
+			request, err := pushService.githubEnterpriseClient.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/git/refs?per_page=100&page=%d", pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, page), nil)
+			if err != nil {
+				return err
+			}
+			references = nil
+			response, err = pushService.githubEnterpriseClient.Do(pushService.ctx, request, &references)
+			return err
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "Error listing Git references on the destination.")
+		}
+		for _, reference := range references {
+			result = append(result, plumbing.NewHashReference(plumbing.ReferenceName(reference.GetRef()), plumbing.NewHash(reference.GetObject().GetSHA())))
+		}
+		page = response.NextPage
+	}
+	return result, nil
+}
+
+// checkPushedReferences fails if the Git references of the destination do not match the cache exactly once everything has been pushed. A push over an unreliable network can report success without every reference having been updated.
+func (pushService *pushService) checkPushedReferences() error {
+	log.Debug("Checking Git references on the destination...")
+	gitRepository, err := pushService.openGitRepository()
+	if err != nil {
+		return err
+	}
+	remoteReferences, err := pushService.destinationReferences()
+	if err != nil {
+		return err
+	}
+	differences, err := referenceDifferences(gitRepository, remoteReferences, pushService.referenceFilter())
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, difference := range differences {
+		switch difference.Difference {
+		case DifferenceMissing:
+			lines = append(lines, fmt.Sprintf("  %s is missing (%s in the cache)", difference.Name, difference.Cache))
+		case DifferenceChanged:
+			lines = append(lines, fmt.Sprintf("  %s is stale (%s on the destination, %s in the cache)", difference.Name, difference.Destination, difference.Cache))
+		case DifferenceExtra:
+			_, err := gitRepository.Reference(plumbing.ReferenceName(difference.Name), false)
+			if !pushService.deletesReference(plumbing.ReferenceName(difference.Name), err == nil) {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("  %s was not deleted (%s on the destination)", difference.Name, difference.Destination))
+		}
+	}
+	if len(lines) != 0 {
+		return fmt.Errorf("The Git references on the destination do not match the cache after pushing:\n%s\nRun the push again to finish it.", strings.Join(lines, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package push
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPushedReferences(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", githubEnterpriseURL)
+	references := map[string]string{
+		"refs/heads/a-ref-that-will-need-pruning": "26936381e619a01122ea33993e3cebc474496805",
+		"refs/heads/main":                         "b9f01aa2c50f49898d4c7845a66be8824499fe9d",
+		"refs/heads/v1":                           "26936381e619a01122ea33993e3cebc474496805",
+		"refs/heads/v3":                           "e529a54fad10a936308b2220e05f7f00757f8e7c",
+		"refs/heads/very-ignored-branch":          "bd82b85707bc13904e3526517677039d4da4a9bb",
+		"refs/tags/an-ignored-tag-too":            "bd82b85707bc13904e3526517677039d4da4a9bb",
+		"refs/tags/codeql-bundle-20200101":        "26936381e619a01122ea33993e3cebc474496805",
+		"refs/tags/codeql-bundle-20200630":        "26936381e619a01122ea33993e3cebc474496805",
+		"refs/tags/v2":                            "26936381e619a01122ea33993e3cebc474496805",
+		// GitHub adds references for pull requests itself.
+		"refs/pull/1/head": "bd82b85707bc13904e3526517677039d4da4a9bb",
+	}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/git/refs", func(response http.ResponseWriter, request *http.Request) {
+		result := []github.Reference{}
+		for name, hash := range references {
+			// The references are split across two pages, with the usual pagination links.
+			if (name < "refs/tags/") == (request.URL.Query().Get("page") == "1") {
+				result = append(result, github.Reference{Ref: github.String(name), Object: &github.GitObject{SHA: github.String(hash)}})
+			}
+		}
+		if request.URL.Query().Get("page") == "1" {
+			response.Header().Set("Link", `<`+githubEnterpriseURL+`/api/v3/repos/destination-repository-owner/destination-repository-name/git/refs?per_page=100&page=2>; rel="next"`)
+		}
+		test.ServeHTTPResponseFromObject(t, result, response)
+	}).Methods("GET")
+
+	require.NoError(t, pushService.checkPushedReferences())
+
+	references["refs/heads/main"] = "26936381e619a01122ea33993e3cebc474496805"
+	delete(references, "refs/tags/v2")
+	references["refs/heads/pushed-directly"] = "bd82b85707bc13904e3526517677039d4da4a9bb"
+	require.EqualError(t, pushService.checkPushedReferences(), `The Git references on the destination do not match the cache after pushing:
+  refs/heads/main is stale (26936381e619a01122ea33993e3cebc474496805 on the destination, b9f01aa2c50f49898d4c7845a66be8824499fe9d in the cache)
+  refs/heads/pushed-directly was not deleted (bd82b85707bc13904e3526517677039d4da4a9bb on the destination)
+  refs/tags/v2 is missing (26936381e619a01122ea33993e3cebc474496805 in the cache)
+Run the push again to finish it.`)
+}
@@ -0,0 +1,62 @@
+package push
+
+import (
+	"regexp"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+)
+
+// releaseBranches are the branches pushed with `--release-refs-only`: `main`, major versions such as `v3` which workflows use the CodeQL Action at, and release branches such as `releases/v3`.
+var releaseBranches = regexp.MustCompile(`^refs/heads/(main|v\d+|releases/v.*)$`)
+
+// isReleaseReference returns whether a reference is pushed with `--release-refs-only`. All tags are pushed, as each release of the CodeQL Action and of the CodeQL bundle has one.
+func isReleaseReference(name plumbing.ReferenceName) bool {
+	return name.IsTag() || releaseBranches.MatchString(name.String())
+}
+
+// pushesReference returns whether a branch or tag in the cache is pushed to the destination. Other references, such as those of pull requests, are handled by the destination itself.
+func (pushService *pushService) pushesReference(name plumbing.ReferenceName) bool {
+	if !pushService.releaseRefsOnly {
+		return true
+	}
+	if pushService.defaultBranch != "" && name == plumbing.NewBranchReferenceName(pushService.defaultBranch) {
+		return true
+	}
+	return isReleaseReference(name)
+}
+
+// referenceFilter returns the filter for referenceDifferences, which is nil when every reference is pushed.
+func (pushService *pushService) referenceFilter() func(plumbing.ReferenceName) bool {
+	if !pushService.releaseRefsOnly {
+		return nil
+	}
+	return func(name plumbing.ReferenceName) bool {
+		return !(name.IsBranch() || name.IsTag()) || pushService.pushesReference(name)
+	}
+}
+
+// pushRefSpecs returns the refspecs to push every reference in the cache that is pushed. Without `--release-refs-only` everything is pushed with a wildcard, and otherwise each reference is pushed by name so that other branches never reach the destination.
+func (pushService *pushService) pushRefSpecs(gitRepository *git.Repository) ([]config.RefSpec, error) {
+	if !pushService.releaseRefsOnly {
+		return []config.RefSpec{config.RefSpec("+refs/*:refs/*")}, nil
+	}
+	references, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	refSpecs := []config.RefSpec{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		name := reference.Name()
+		if reference.Type() == plumbing.HashReference && (name.IsBranch() || name.IsTag()) && pushService.pushesReference(name) {
+			refSpecs = append(refSpecs, config.RefSpec("+"+name.String()+":"+name.String()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	return refSpecs, nil
+}
@@ -0,0 +1,70 @@
+package push
+
+import (
+	"path"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsReleaseReference(t *testing.T) {
+	require.True(t, isReleaseReference(plumbing.NewBranchReferenceName("main")))
+	require.True(t, isReleaseReference(plumbing.NewBranchReferenceName("v3")))
+	require.True(t, isReleaseReference(plumbing.NewBranchReferenceName("releases/v3")))
+	require.True(t, isReleaseReference(plumbing.NewTagReferenceName("an-ignored-tag-too")))
+	require.False(t, isReleaseReference(plumbing.NewBranchReferenceName("very-ignored-branch")))
+	require.False(t, isReleaseReference(plumbing.NewBranchReferenceName("dependabot/npm_and_yarn/v3")))
+}
+
+func TestPushGitReleaseRefsOnly(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	destinationPath := path.Join(temporaryDirectory, "target")
+	_, err := git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+	repository := github.Repository{
+		CloneURL: github.String(destinationPath),
+	}
+
+	// Branches pushed before `--release-refs-only` was used are deleted.
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	require.NoError(t, pushService.pushGit(&repository, true))
+	require.NoError(t, pushService.pushGit(&repository, false))
+
+	pushService.releaseRefsOnly = true
+	require.NoError(t, pushService.pushGit(&repository, true))
+	require.NoError(t, pushService.pushGit(&repository, false))
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200101",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200630",
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+	})
+
+	// A default branch given with `--default-branch` is always pushed.
+	pushService.defaultBranch = "very-ignored-branch"
+	require.NoError(t, pushService.pushGit(&repository, false))
+	gitRepository, err := git.PlainOpen(destinationPath)
+	require.NoError(t, err)
+	_, err = gitRepository.Reference(plumbing.NewBranchReferenceName("very-ignored-branch"), false)
+	require.NoError(t, err)
+
+	localRepository, err := git.PlainOpen("./push_test/action-cache-initial/git")
+	require.NoError(t, err)
+	remoteReferences, err := gitRepository.References()
+	require.NoError(t, err)
+	references := []*plumbing.Reference{}
+	require.NoError(t, remoteReferences.ForEach(func(reference *plumbing.Reference) error {
+		references = append(references, reference)
+		return nil
+	}))
+	differences, err := referenceDifferences(localRepository, references, pushService.referenceFilter())
+	require.NoError(t, err)
+	require.Empty(t, differences)
+}
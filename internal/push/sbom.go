@@ -0,0 +1,40 @@
+package push
+
+import (
+	"bytes"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/sbom"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// sbomUploads generates a bill of materials for each CodeQL bundle being pushed with a release, ready to upload as release assets alongside them.
+func (pushService *pushService) sbomUploads(releaseName string, uploads []assetUpload) ([]assetUpload, error) {
+	sbomUploads := []assetUpload{}
+	for _, upload := range uploads {
+		if !sbom.IsBundle(upload.asset.Name) {
+			continue
+		}
+		log.Debugf("Generating bill of materials for %s of release %s...", upload.asset.Name, releaseName)
+		reader, err := pushService.cacheDirectory.OpenAsset(releaseName, upload.asset.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error opening release asset.")
+		}
+		content, err := sbom.Generate(upload.asset.Name, releaseName, upload.digest, reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		digest, err := cachedirectory.Digest(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		sbomUploads = append(sbomUploads, assetUpload{
+			asset:   cachedirectory.Asset{Name: sbom.AssetName(upload.asset.Name), Size: int64(len(content))},
+			digest:  digest,
+			content: content,
+		})
+	}
+	return sbomUploads, nil
+}
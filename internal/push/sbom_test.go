@@ -0,0 +1,42 @@
+package push
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/sbom"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSBOMUploads(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	pushService := getTestPushService(t, temporaryDirectory, "")
+	bundle := bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(&bundle)
+	tarWriter := tar.NewWriter(gzipWriter)
+	extractor := "name: javascript\nversion: 1.22.1\n"
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "codeql/javascript/codeql-extractor.yml", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(extractor))}))
+	_, err := tarWriter.Write([]byte(extractor))
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	require.NoError(t, pushService.cacheDirectory.WriteAsset("codeql-bundle-20200630", "codeql-bundle.tar.gz", bytes.NewReader(bundle.Bytes()), int64(bundle.Len())))
+
+	uploads := []assetUpload{
+		{asset: cachedirectory.Asset{Name: "codeql-bundle.tar.gz", Size: int64(bundle.Len())}, digest: "a-checksum"},
+		{asset: cachedirectory.Asset{Name: "codeql-runner-linux", Size: 3}, digest: "another-checksum"},
+	}
+	sbomUploads, err := pushService.sbomUploads("codeql-bundle-20200630", uploads)
+	require.NoError(t, err)
+	require.Len(t, sbomUploads, 1)
+	require.Equal(t, "codeql-bundle.cdx.json", sbomUploads[0].asset.Name)
+	require.Equal(t, int64(len(sbomUploads[0].content)), sbomUploads[0].asset.Size)
+	bom := sbom.BOM{}
+	require.NoError(t, json.Unmarshal(sbomUploads[0].content, &bom))
+	require.Equal(t, []sbom.Component{{Type: "application", Name: "javascript", Version: "1.22.1", Description: "CodeQL extractor"}}, bom.Components)
+}
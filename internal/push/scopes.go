@@ -0,0 +1,211 @@
+package push
+
+import (
+	usererrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/report"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const workflowsDirectory = ".github/workflows"
+
+// containsWorkflows reports whether any reference in the cache has workflow files, which can only be pushed with the `workflow` scope.
+func (pushService *pushService) containsWorkflows() (bool, error) {
+	gitRepository, err := pushService.openGitRepository()
+	if err != nil {
+		return false, err
+	}
+	references, err := gitRepository.References()
+	if err != nil {
+		return false, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	found := false
+	seenCommits := map[plumbing.Hash]bool{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() != plumbing.HashReference || seenCommits[reference.Hash()] {
+			return nil
+		}
+		seenCommits[reference.Hash()] = true
+		commit, err := gitRepository.CommitObject(reference.Hash())
+		if err != nil {
+			// Tags may point to objects other than commits, which cannot contain workflows.
+			return nil
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return errors.Wrapf(err, "Error reading tree for reference %s.", reference.Name())
+		}
+		_, err = tree.FindEntry(workflowsDirectory)
+		if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Error reading tree for reference %s.", reference.Name())
+		}
+		found = true
+		return storer.ErrStop
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// Whether the destination organization can be pushed to without site admin access.
+const (
+	organizationAccessible = iota
+	organizationMissing
+	organizationNotMember
+)
+
+// organizationAccess checks whether the user can push to the destination organization without site admin access, which would otherwise be needed to create it or to impersonate the Actions admin user.
+func (pushService *pushService) organizationAccess(user *github.User) (int, error) {
+	if pushService.destinationRepositoryOwner == user.GetLogin() {
+		return organizationAccessible, nil
+	}
+	_, organizationResponse, err := pushService.githubEnterpriseClient.Organizations.Get(pushService.ctx, pushService.destinationRepositoryOwner)
+	if err != nil && (organizationResponse == nil || organizationResponse.StatusCode != http.StatusNotFound) {
+		return 0, errors.Wrap(err, "Error checking if destination organization exists.")
+	}
+	if err != nil {
+		return organizationMissing, nil
+	}
+	_, membershipResponse, err := pushService.githubEnterpriseClient.Organizations.GetOrgMembership(pushService.ctx, user.GetLogin(), pushService.destinationRepositoryOwner)
+	if err != nil && (membershipResponse == nil || membershipResponse.StatusCode != http.StatusNotFound) {
+		return 0, errors.Wrap(err, "Failed to check membership of destination organization.")
+	}
+	if err != nil {
+		return organizationNotMember, nil
+	}
+	return organizationAccessible, nil
+}
+
+// checkTokenScopes reports every scope the destination token is missing before anything is changed on the destination, rather than failing part way through the push.
+func (pushService *pushService) checkTokenScopes() error {
+	log.Debug("Checking destination token scopes...")
+	user, response, err := pushService.githubEnterpriseClient.Users.Get(pushService.ctx, "")
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusUnauthorized {
+			return exitcode.WithCode(usererrors.New(errorInvalidDestinationToken), exitcode.Authentication)
+		}
+		return errors.Wrap(err, "Error getting current user.")
+	}
+	if pushService.noSiteAdmin {
+		err := pushService.checkWithoutSiteAdmin(user)
+		if err != nil {
+			return err
+		}
+	}
+	if !githubapiutil.HasScopeInformation(response) {
+		if githubapiutil.IsFineGrainedToken(pushService.destinationToken.AccessToken) {
+			return pushService.checkFineGrainedToken(user)
+		}
+		log.Debug("The destination token does not have scopes. Skipping token scope check.")
+		return nil
+	}
+
+	missingScopes := []string{}
+	if !githubapiutil.HasAnyScope(response, pushService.repositoryScopes()...) {
+		missingScopes = append(missingScopes, fmt.Sprintf("`%s` is needed to create and update the destination repository.", pushService.repositoryScope()))
+	}
+	if pushService.actionsPolicy.Level != "" && !githubapiutil.HasAnyScope(response, pushService.actionsPolicy.scope()) {
+		missingScopes = append(missingScopes, fmt.Sprintf("`%s` is needed to update the Actions policy with `--update-actions-policy`.", pushService.actionsPolicy.scope()))
+	}
+	needsWorkflow, err := pushService.containsWorkflows()
+	if err != nil {
+		return err
+	}
+	if needsWorkflow && !githubapiutil.HasAnyScope(response, "workflow") {
+		missingScopes = append(missingScopes, "`workflow` is needed to push the workflow files in the CodeQL Action repository.")
+	}
+	if !pushService.noSiteAdmin && !githubapiutil.HasAnyScope(response, "site_admin") {
+		access, err := pushService.organizationAccess(user)
+		if err != nil {
+			return err
+		}
+		switch access {
+		case organizationMissing:
+			// If the organization is not to be created then the push fails with a clearer error once it is found to be missing.
+			if pushService.createOrganization {
+				missingScopes = append(missingScopes, fmt.Sprintf("`site_admin` is needed to create the organization %s, as it does not exist yet.", pushService.destinationRepositoryOwner))
+			}
+		case organizationNotMember:
+			missingScopes = append(missingScopes, fmt.Sprintf("`site_admin` is needed to push to the organization %s, as you are not a member of it.", pushService.destinationRepositoryOwner))
+		}
+	}
+	if len(missingScopes) > 0 {
+		return exitcode.WithCode(fmt.Errorf("The destination token you have provided is missing scopes needed for this push. Nothing has been changed on GitHub Enterprise Server.\n  %s", strings.Join(missingScopes, "\n  ")), exitcode.Authentication)
+	}
+	return nil
+}
+
+// checkFineGrainedToken reports anything a fine-grained personal access token cannot do for this push before anything is changed on the destination. The permissions of a fine-grained token cannot be listed, so any that are missing are reported as the requests needing them fail instead. Fine-grained tokens can never use the site admin API, so the organization must already exist and have the user as a member.
+func (pushService *pushService) checkFineGrainedToken(user *github.User) error {
+	log.Debug("The destination token is a fine-grained personal access token, so its permissions will be checked as they are used.")
+	if pushService.noSiteAdmin {
+		// The organization has already been checked.
+		return nil
+	}
+	access, err := pushService.organizationAccess(user)
+	if err != nil {
+		return err
+	}
+	problem := ""
+	switch access {
+	case organizationMissing:
+		// If the organization is not to be created then the push fails with a clearer error once it is found to be missing.
+		if pushService.createOrganization {
+			problem = fmt.Sprintf("Fine-grained personal access tokens cannot create organizations, and the organization %s does not exist yet. Create it first, or use a classic token with the `site_admin` scope.", pushService.destinationRepositoryOwner)
+		}
+	case organizationNotMember:
+		problem = fmt.Sprintf("Fine-grained personal access tokens cannot impersonate the Actions admin user, and %s is not a member of the organization %s. Add them to it, or use a classic token with the `site_admin` scope.", user.GetLogin(), pushService.destinationRepositoryOwner)
+	}
+	if problem != "" {
+		return exitcode.WithCode(fmt.Errorf("The destination token you have provided cannot be used for this push. Nothing has been changed on GitHub Enterprise Server.\n  %s", problem), exitcode.Authentication)
+	}
+	return nil
+}
+
+// checkWithoutSiteAdmin fails before anything is changed on the destination if the push cannot be done without site admin access, as given by `--no-site-admin`.
+func (pushService *pushService) checkWithoutSiteAdmin(user *github.User) error {
+	log.Info("Running without site admin access. The destination organization will not be created and the Actions admin user will not be impersonated.")
+	access, err := pushService.organizationAccess(user)
+	if err != nil {
+		return err
+	}
+	switch access {
+	case organizationMissing:
+		return exitcode.WithCode(fmt.Errorf("The destination organization %s does not exist, and only a site admin can create it. Nothing has been changed on GitHub Enterprise Server. Ask a site admin to create it, or run without `--no-site-admin`.", pushService.destinationRepositoryOwner), exitcode.Authentication)
+	case organizationNotMember:
+		return exitcode.WithCode(fmt.Errorf("You are not a member of the destination organization %s, and only a site admin can push to it by impersonating the Actions admin user. Nothing has been changed on GitHub Enterprise Server. Ask an owner of %s to add %s to it, or run without `--no-site-admin`.", pushService.destinationRepositoryOwner, pushService.destinationRepositoryOwner, user.GetLogin()), exitcode.Authentication)
+	}
+	return nil
+}
+
+// skipStep records a step of the push that was skipped as it needs more access than the destination token has, so that it can be done separately.
+func (pushService *pushService) skipStep(step string) {
+	log.Warnf("Skipped %s.", step)
+	report.FromContext(pushService.ctx).RecordSkippedStep(step)
+}
+
+// permissionError describes the fine-grained permissions a failed request needed, if GitHub says what they are, or returns nil otherwise.
+func permissionError(response *github.Response, action string) error {
+	if response == nil || (response.StatusCode != http.StatusForbidden && response.StatusCode != http.StatusNotFound) {
+		return nil
+	}
+	acceptedPermissions := githubapiutil.AcceptedPermissions(response)
+	if len(acceptedPermissions) == 0 {
+		return nil
+	}
+	return exitcode.WithCode(fmt.Errorf("The destination token you have provided does not have the permissions needed to %s. Grant it %s on the destination repository.", action, strings.Join(acceptedPermissions, " or ")), exitcode.Authentication)
+}
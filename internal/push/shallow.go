@@ -0,0 +1,72 @@
+package push
+
+import (
+	usererrors "errors"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/pkg/errors"
+)
+
+const errorShallowHistoryMissing = "The cache was pulled with `--git-depth`, so it does not have the older Git history of the CodeQL Action, and the destination repository does not have it either. Pull the cache again without `--git-depth` and push it once, after which caches pulled with `--git-depth` can be pushed to this destination."
+
+// unshallowStorer hides the shallow commits of a cache pulled with `--git-depth` when pushing. Otherwise the push assumes the destination already has every shallow commit, including new ones at the tips of branches, and leaves them out of what it pushes.
+type unshallowStorer struct {
+	storage.Storer
+}
+
+func (unshallowStorer) Shallow() ([]plumbing.Hash, error) {
+	return nil, nil
+}
+
+// isShallow reports whether the Git repository in the cache only has recent history.
+func isShallow(gitRepository *git.Repository) (bool, error) {
+	shallowCommits, err := gitRepository.Storer.Shallow()
+	if err != nil {
+		return false, errors.Wrap(err, "Error reading shallow commits of Git repository cache.")
+	}
+	return len(shallowCommits) != 0, nil
+}
+
+// pushStorer returns the storage to push the Git repository in the cache from.
+func pushStorer(gitRepository *git.Repository, shallow bool) storage.Storer {
+	if shallow {
+		return unshallowStorer{Storer: gitRepository.Storer}
+	}
+	return gitRepository.Storer
+}
+
+// checkShallowHistory makes sure that the destination has the history left out of a shallow cache, by walking back from every reference in the cache in the same way go-git does when pushing. This finds out before anything on the destination is changed, rather than part of the way through a push.
+func checkShallowHistory(gitRepository *git.Repository, remoteReferences []*plumbing.Reference) error {
+	remoteHashes := []plumbing.Hash{}
+	for _, remoteReference := range remoteReferences {
+		if remoteReference.Type() == plumbing.HashReference {
+			remoteHashes = append(remoteHashes, remoteReference.Hash())
+		}
+	}
+	localReferences, err := gitRepository.References()
+	if err != nil {
+		return errors.Wrap(err, "Error listing local references.")
+	}
+	localHashes := []plumbing.Hash{}
+	err = localReferences.ForEach(func(localReference *plumbing.Reference) error {
+		if localReference.Type() == plumbing.HashReference && strings.HasPrefix(localReference.Name().String(), "refs/") {
+			localHashes = append(localHashes, localReference.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error listing local references.")
+	}
+	_, err = revlist.Objects(unshallowStorer{Storer: gitRepository.Storer}, localHashes, remoteHashes)
+	if err == plumbing.ErrObjectNotFound {
+		return usererrors.New(errorShallowHistoryMissing)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error finding Git objects to push.")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package push
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+// getShallowTestPushService copies the initial cache and adds a commit on top of `main` with the given parent, marking it as the end of a shallow history, as a pull with `--git-depth` would.
+func getShallowTestPushService(t *testing.T, parent plumbing.Hash) (*pushService, plumbing.Hash) {
+	cachePath := path.Join(test.CreateTemporaryDirectory(t), "cache")
+	pushService := getTestPushService(t, cachePath, "")
+	repository, err := git.PlainInit(pushService.cacheDirectory.GitPath(), true)
+	require.NoError(t, err)
+	remote, err := repository.CreateRemote(&config.RemoteConfig{Name: "initial", URLs: []string{"./push_test/action-cache-initial/git"}})
+	require.NoError(t, err)
+	require.NoError(t, remote.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}}))
+	main, err := repository.CommitObject(plumbing.NewHash("b9f01aa2c50f49898d4c7845a66be8824499fe9d"))
+	require.NoError(t, err)
+	signature := object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}
+	commit := object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      "A new commit.",
+		TreeHash:     main.TreeHash,
+		ParentHashes: []plumbing.Hash{parent},
+	}
+	encodedObject := repository.Storer.NewEncodedObject()
+	require.NoError(t, commit.Encode(encodedObject))
+	hash, err := repository.Storer.SetEncodedObject(encodedObject)
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), hash)))
+	require.NoError(t, repository.Storer.SetShallow([]plumbing.Hash{hash}))
+	return &pushService, hash
+}
+
+func pushInitialCacheToNewDestination(t *testing.T) (github.Repository, string) {
+	destinationPath := path.Join(test.CreateTemporaryDirectory(t), "target")
+	_, err := git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+	repository := github.Repository{
+		CloneURL: github.String(destinationPath),
+	}
+	pushService := getTestPushService(t, "./push_test/action-cache-initial/", "")
+	require.NoError(t, pushService.pushGit(&repository, true))
+	require.NoError(t, pushService.pushGit(&repository, false))
+	return repository, destinationPath
+}
+
+func TestPushShallowCache(t *testing.T) {
+	repository, destinationPath := pushInitialCacheToNewDestination(t)
+	pushService, newCommit := getShallowTestPushService(t, plumbing.NewHash("b9f01aa2c50f49898d4c7845a66be8824499fe9d"))
+	require.NoError(t, pushService.pushGit(&repository, false))
+	destination, err := git.PlainOpen(destinationPath)
+	require.NoError(t, err)
+	reference, err := destination.Reference(plumbing.NewBranchReferenceName("main"), false)
+	require.NoError(t, err)
+	require.Equal(t, newCommit, reference.Hash())
+	_, err = destination.CommitObject(newCommit)
+	require.NoError(t, err)
+}
+
+func TestPushShallowCacheWithoutHistoryOnDestination(t *testing.T) {
+	repository, destinationPath := pushInitialCacheToNewDestination(t)
+	pushService, _ := getShallowTestPushService(t, plumbing.NewHash("0123456789012345678901234567890123456789"))
+	err := pushService.pushGit(&repository, false)
+	require.EqualError(t, err, errorShallowHistoryMissing)
+	// Nothing has been changed on the destination.
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200101",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/codeql-bundle-20200630",
+		"b9f01aa2c50f49898d4c7845a66be8824499fe9d refs/heads/main",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/v1",
+		"e529a54fad10a936308b2220e05f7f00757f8e7c refs/heads/v3",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/heads/very-ignored-branch",
+		"bd82b85707bc13904e3526517677039d4da4a9bb refs/tags/an-ignored-tag-too",
+		"26936381e619a01122ea33993e3cebc474496805 refs/tags/v2",
+		"26936381e619a01122ea33993e3cebc474496805 refs/heads/a-ref-that-will-need-pruning",
+	})
+}
@@ -0,0 +1,108 @@
+package push
+
+import (
+	"sort"
+
+	"github.com/github/codeql-action-sync/internal/actionconfiguration"
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/google/go-github/v32/github"
+)
+
+// DefaultDestinationRepository is the repository the CodeQL Action is pushed to unless another is given.
+const DefaultDestinationRepository = "github/codeql-action"
+
+// DefaultCLIDestinationRepository is the repository the standalone CodeQL CLI is pushed to unless another is given, matching where it is published on GitHub.com.
+const DefaultCLIDestinationRepository = "github/codeql-cli-binaries"
+
+// DefaultCLIRepositoryDescription is the description set on a new destination repository of the CodeQL CLI unless another is given.
+const DefaultCLIRepositoryDescription = "A mirror of the CodeQL CLI from https://github.com/github/codeql-cli-binaries, kept up to date by the CodeQL Action sync tool."
+
+// defaultTopicsWithoutActions are the topics set on a new destination repository of anything other than the CodeQL Action unless others are given, as it is not an action.
+var defaultTopicsWithoutActions = []string{"codeql", "code-scanning"}
+
+// DefaultQueriesDestinationRepository is the repository the CodeQL queries and libraries are pushed to unless another is given, matching where they are published on GitHub.com.
+const DefaultQueriesDestinationRepository = "github/codeql"
+
+// DefaultQueriesRepositoryDescription is the description set on a new destination repository of the CodeQL queries and libraries unless another is given.
+const DefaultQueriesRepositoryDescription = "A mirror of the CodeQL queries and libraries from https://github.com/github/codeql, kept up to date by the CodeQL Action sync tool."
+
+// cliReleasePrefix starts the tag of every CodeQL CLI release. Other releases on the destination are never pruned.
+const cliReleasePrefix = "v"
+
+// isAction returns whether the cache holds the CodeQL Action, rather than another repository synced with the same machinery.
+func (pushService *pushService) isAction() bool {
+	return pushService.sourceKind == "" || pushService.sourceKind == cachedirectory.SourceKindAction
+}
+
+// defaultDestinationRepository returns the repository a cache of the given kind is pushed to unless another is given.
+func defaultDestinationRepository(sourceKind string) string {
+	switch sourceKind {
+	case cachedirectory.SourceKindCLI:
+		return DefaultCLIDestinationRepository
+	case cachedirectory.SourceKindQueries:
+		return DefaultQueriesDestinationRepository
+	}
+	return DefaultDestinationRepository
+}
+
+// releasePrefix starts the tag of every release that the sync tool manages on the destination.
+func (pushService *pushService) releasePrefix() string {
+	if pushService.sourceKind == cachedirectory.SourceKindCLI {
+		return cliReleasePrefix
+	}
+	return bundleReleasePrefix
+}
+
+// recentReleases returns the releases in the cache that `--keep-last` keeps. For the CodeQL Action these are the most recent bundles used by `main` and each major version. The releases of the CodeQL CLI are not referenced from its Git repository, so the most recently published are kept instead.
+func (pushService *pushService) recentReleases(keepLast int) ([]string, error) {
+	if pushService.isAction() {
+		gitRepository, err := pushService.openGitRepository()
+		if err != nil {
+			return nil, err
+		}
+		return actionconfiguration.BundleVersions(gitRepository, keepLast)
+	}
+	releaseNames, err := pushService.cacheDirectory.ListReleases()
+	if err != nil {
+		return nil, err
+	}
+	releases := []*github.RepositoryRelease{}
+	for _, releaseName := range releaseNames {
+		release, err := pushService.readReleaseMetadata(releaseName)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].GetPublishedAt().After(releases[j].GetPublishedAt().Time)
+	})
+	recent := []string{}
+	for index := 0; index < len(releases) && index < keepLast; index++ {
+		recent = append(recent, releases[index].GetTagName())
+	}
+	return recent, nil
+}
+
+// defaultRepositoryMetadata fills in the description and topics of a new destination repository that were not given with the defaults for the kind of source in the cache. Anything given explicitly is kept.
+func defaultRepositoryMetadata(sourceKind string, metadata RepositoryMetadata) RepositoryMetadata {
+	if metadata.Description == "" {
+		switch sourceKind {
+		case cachedirectory.SourceKindCLI:
+			metadata.Description = DefaultCLIRepositoryDescription
+		case cachedirectory.SourceKindQueries:
+			metadata.Description = DefaultQueriesRepositoryDescription
+		default:
+			metadata.Description = DefaultRepositoryDescription
+		}
+	}
+	if metadata.Topics == nil {
+		switch sourceKind {
+		case cachedirectory.SourceKindCLI, cachedirectory.SourceKindQueries:
+			metadata.Topics = defaultTopicsWithoutActions
+		default:
+			metadata.Topics = DefaultRepositoryTopics
+		}
+	}
+	return metadata
+}
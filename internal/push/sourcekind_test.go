@@ -0,0 +1,81 @@
+package push
+
+import (
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/retention"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCLIReleases adds CodeQL CLI releases to the cache, which are not in the order they were published in.
+func writeTestCLIReleases(t *testing.T, cacheDirectory cachedirectory.CacheDirectory) {
+	for _, release := range []struct{ tag, publishedAt string }{
+		{"v2.1.0", "2020-06-01T00:00:00Z"},
+		{"v2.2.0", "2020-07-01T00:00:00Z"},
+		{"v2.0.0", "2020-05-01T00:00:00Z"},
+	} {
+		require.NoError(t, cacheDirectory.WriteMetadata(release.tag, []byte(`{"tag_name": "`+release.tag+`", "published_at": "`+release.publishedAt+`"}`)))
+	}
+}
+
+func TestReleaseNamesKeepLastForCLI(t *testing.T) {
+	pushService := getTestPushService(t, test.CreateTemporaryDirectory(t), "")
+	pushService.sourceKind = cachedirectory.SourceKindCLI
+	writeTestCLIReleases(t, pushService.cacheDirectory)
+
+	releaseNames, err := pushService.releaseNames()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"v2.0.0", "v2.1.0", "v2.2.0"}, releaseNames)
+
+	// There is no Git repository to find releases in, so the most recently published are kept.
+	pushService.retention.KeepLast = 2
+	releaseNames, err = pushService.releaseNames()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"v2.1.0", "v2.2.0"}, releaseNames)
+}
+
+func TestPrunedReleasesForCLI(t *testing.T) {
+	pushService := getTestPushService(t, test.CreateTemporaryDirectory(t), "")
+	pushService.sourceKind = cachedirectory.SourceKindCLI
+	writeTestCLIReleases(t, pushService.cacheDirectory)
+	releases := []*github.RepositoryRelease{
+		{TagName: github.String("v2.2.0")},
+		{TagName: github.String("v2.1.0")},
+		{TagName: github.String("v2.0.0")},
+		{TagName: github.String("codeql-bundle-20200101")},
+	}
+
+	pushService.retention = retention.Policy{KeepLast: 1}
+	pruned, err := pushService.prunedReleases(releases)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2.1.0", "v2.0.0"}, prunedReleaseNames(pruned))
+}
+
+func TestCLIRepositoryMetadata(t *testing.T) {
+	metadata := defaultRepositoryMetadata(cachedirectory.SourceKindCLI, RepositoryMetadata{})
+	require.Equal(t, DefaultCLIRepositoryDescription, metadata.Description)
+	require.Equal(t, defaultTopicsWithoutActions, metadata.Topics)
+
+	metadata = defaultRepositoryMetadata(cachedirectory.SourceKindCLI, RepositoryMetadata{Description: "Maintained by the security team.", Topics: []string{"codeql"}})
+	require.Equal(t, "Maintained by the security team.", metadata.Description)
+	require.Equal(t, []string{"codeql"}, metadata.Topics)
+}
+
+func TestActionRepositoryMetadata(t *testing.T) {
+	metadata := defaultRepositoryMetadata(cachedirectory.SourceKindAction, RepositoryMetadata{})
+	require.Equal(t, DefaultRepositoryDescription, metadata.Description)
+	require.Equal(t, DefaultRepositoryTopics, metadata.Topics)
+
+	// Topics given as an empty list remove all of them.
+	metadata = defaultRepositoryMetadata(cachedirectory.SourceKindAction, RepositoryMetadata{Topics: []string{}})
+	require.Equal(t, []string{}, metadata.Topics)
+}
+
+func TestQueriesRepositoryMetadata(t *testing.T) {
+	metadata := defaultRepositoryMetadata(cachedirectory.SourceKindQueries, RepositoryMetadata{})
+	require.Equal(t, DefaultQueriesRepositoryDescription, metadata.Description)
+	require.Equal(t, defaultTopicsWithoutActions, metadata.Topics)
+}
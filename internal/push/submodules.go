@@ -0,0 +1,131 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/audit"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/submodules"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const errorSubmoduleAlreadyExists = "The repository %s already exists, but it was not created with the CodeQL Action sync tool to mirror the submodule %s. If you are sure you want to push the submodule to it, re-run this command with the `--force` flag."
+const errorSubmoduleIsDestination = "The submodule %s would be mirrored to %s, which is the destination repository itself."
+
+// createSubmoduleRepository ensures the repository a submodule is mirrored to exists, alongside the destination repository. Mirrors are recognized by having the URL of the submodule as their homepage.
+func (pushService *pushService) createSubmoduleRepository(repository *github.Repository, submodule submodules.Repository) (*github.Repository, error) {
+	owner := repository.GetOwner().GetLogin()
+	fullName := owner + "/" + submodule.Name
+	if strings.EqualFold(submodule.Name, repository.GetName()) {
+		return nil, fmt.Errorf(errorSubmoduleIsDestination, submodule.URL, fullName)
+	}
+	mirror, response, err := pushService.githubEnterpriseClient.Repositories.Get(pushService.ctx, owner, submodule.Name)
+	if err != nil && (response == nil || response.StatusCode != http.StatusNotFound) {
+		return nil, errors.Wrap(err, "Error checking if submodule repository exists.")
+	}
+	if err == nil {
+		if mirror.GetHomepage() != submodule.URL && !pushService.force {
+			return nil, fmt.Errorf(errorSubmoduleAlreadyExists, mirror.GetFullName(), submodule.URL)
+		}
+		return mirror, nil
+	}
+	destinationOrganization := ""
+	if repository.GetOwner().GetType() == "Organization" {
+		destinationOrganization = owner
+	}
+	properties := github.Repository{
+		Name:         github.String(submodule.Name),
+		Description:  github.String("A mirror of " + submodule.URL + ", a submodule of " + repository.GetFullName() + ", kept up to date by the CodeQL Action sync tool."),
+		Homepage:     github.String(submodule.URL),
+		HasIssues:    github.Bool(false),
+		HasProjects:  github.Bool(false),
+		HasPages:     github.Bool(false),
+		HasWiki:      github.Bool(false),
+		HasDownloads: github.Bool(false),
+	}
+	setVisibility(&properties, pushService.repositoryMetadata.visibility())
+	mirror, response, err = pushService.githubEnterpriseClient.Repositories.Create(pushService.ctx, destinationOrganization, &properties)
+	if err != nil {
+		if permissionErr := permissionError(response, "create the repository of a submodule"); permissionErr != nil {
+			return nil, permissionErr
+		}
+		return nil, errors.Wrapf(err, "Error creating repository %s for submodule %s.", fullName, submodule.URL)
+	}
+	err = pushService.audit(audit.ActionCreateRepository, mirror.GetFullName(), map[string]string{"submodule": submodule.URL})
+	if err != nil {
+		return nil, err
+	}
+	return mirror, nil
+}
+
+// pushMirror makes the branches and tags of a repository match those of a mirror in the cache.
+func pushMirror(ctx context.Context, mirrorPath string, remoteURL string, credentials transport.AuthMethod, progress io.Writer) error {
+	gitRepository, err := git.PlainOpen(mirrorPath)
+	if err != nil {
+		return exitcode.WithCode(errors.Wrap(err, "Error reading mirror of submodule from cache."), exitcode.CacheCorrupt)
+	}
+	remote := git.NewRemote(gitRepository.Storer, &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{remoteURL},
+	})
+	remoteReferences, err := listRemoteReferences(ctx, remote, credentials)
+	if err != nil && err != transport.ErrEmptyRemoteRepository {
+		return errors.Wrap(err, "Error listing remote references.")
+	}
+	deleteRefSpecs := []config.RefSpec{}
+	for _, remoteReference := range remoteReferences {
+		name := remoteReference.Name()
+		if !name.IsBranch() && !name.IsTag() {
+			continue
+		}
+		if _, err := gitRepository.Storer.Reference(name); err != nil {
+			deleteRefSpecs = append(deleteRefSpecs, config.RefSpec(":"+name.String()))
+		}
+	}
+	for _, refSpecs := range [][]config.RefSpec{deleteRefSpecs, submodules.MirrorRefSpecs()} {
+		if len(refSpecs) == 0 {
+			continue
+		}
+		err = streamPush(ctx, gitRepository, gitRepository.Storer, remoteURL, credentials, refSpecs, progress)
+		if err != nil && errors.Cause(err) != git.NoErrAlreadyUpToDate {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushSubmodules pushes the mirrors of the submodules pulled with `--submodules`, so that the rewritten `.gitmodules` files of the destination repository never point at a repository that does not exist.
+func (pushService *pushService) pushSubmodules(repository *github.Repository) error {
+	record, err := submodules.Read(pushService.cacheDirectory)
+	if err != nil || record == nil {
+		return err
+	}
+	if !pushService.pushSSH {
+		pushService.installGitTransport()
+	}
+	for _, submodule := range record.Repositories {
+		mirror, err := pushService.createSubmoduleRepository(repository, submodule)
+		if err != nil {
+			return err
+		}
+		remoteURL := mirror.GetCloneURL()
+		if pushService.pushSSH {
+			remoteURL = mirror.GetSSHURL()
+		}
+		log.Debugf("Pushing submodule %s to %s...", submodule.URL, remoteURL)
+		err = pushMirror(pushService.ctx, submodules.Path(pushService.cacheDirectory, submodule), remoteURL, pushService.gitCredentials(), pushService.gitProgress())
+		if err != nil {
+			return errors.Wrapf(err, "Error pushing submodule %s to GitHub Enterprise Server.", submodule.URL)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/submodules"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func testDestinationRepository() *github.Repository {
+	return &github.Repository{
+		Name:     github.String("destination-repository-name"),
+		FullName: github.String("destination-repository-owner/destination-repository-name"),
+		Owner:    &github.User{Login: github.String("destination-repository-owner"), Type: github.String("Organization")},
+	}
+}
+
+func TestCreateSubmoduleRepository(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	submodule := submodules.Repository{Name: "library", URL: "https://github.com/owner/library.git", Depth: 1}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/library", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/orgs/destination-repository-owner/repos", func(response http.ResponseWriter, request *http.Request) {
+		properties := github.Repository{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&properties))
+		require.Equal(t, "library", properties.GetName())
+		require.Equal(t, submodule.URL, properties.GetHomepage())
+		properties.FullName = github.String("destination-repository-owner/library")
+		test.ServeHTTPResponseFromObject(t, properties, response)
+	}).Methods("POST")
+	mirror, err := pushService.createSubmoduleRepository(testDestinationRepository(), submodule)
+	require.NoError(t, err)
+	require.Equal(t, "destination-repository-owner/library", mirror.GetFullName())
+}
+
+func TestCreateSubmoduleRepositoryAlreadyExists(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	submodule := submodules.Repository{Name: "library", URL: "https://github.com/owner/library.git", Depth: 1}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/library", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{FullName: github.String("destination-repository-owner/library")}, response)
+	}).Methods("GET")
+	_, err := pushService.createSubmoduleRepository(testDestinationRepository(), submodule)
+	require.EqualError(t, err, "The repository destination-repository-owner/library already exists, but it was not created with the CodeQL Action sync tool to mirror the submodule https://github.com/owner/library.git. If you are sure you want to push the submodule to it, re-run this command with the `--force` flag.")
+	pushService.force = true
+	_, err = pushService.createSubmoduleRepository(testDestinationRepository(), submodule)
+	require.NoError(t, err)
+
+	_, err = pushService.createSubmoduleRepository(testDestinationRepository(), submodules.Repository{Name: "destination-repository-name", URL: "https://github.com/owner/destination-repository-name"})
+	require.Error(t, err)
+}
+
+// storeTestCommit stores a commit of an empty tree.
+func storeTestCommit(t *testing.T, repository *git.Repository) plumbing.Hash {
+	store := func(encodable interface {
+		Encode(plumbing.EncodedObject) error
+	}) plumbing.Hash {
+		encoded := repository.Storer.NewEncodedObject()
+		require.NoError(t, encodable.Encode(encoded))
+		hash, err := repository.Storer.SetEncodedObject(encoded)
+		require.NoError(t, err)
+		return hash
+	}
+	signature := object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}
+	return store(&object.Commit{Author: signature, Committer: signature, Message: "A commit.", TreeHash: store(&object.Tree{})})
+}
+
+func TestPushMirror(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	mirrorPath := path.Join(temporaryDirectory, "mirror.git")
+	mirror, err := git.PlainInit(mirrorPath, true)
+	require.NoError(t, err)
+	commit := storeTestCommit(t, mirror)
+	require.NoError(t, mirror.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, commit)))
+	require.NoError(t, mirror.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("other"), commit)))
+	require.NoError(t, mirror.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName("v1"), commit)))
+	destinationPath := path.Join(temporaryDirectory, "destination.git")
+	_, err = git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+
+	require.NoError(t, pushMirror(context.Background(), mirrorPath, destinationPath, nil, nil))
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{
+		commit.String() + " refs/heads/master",
+		commit.String() + " refs/heads/other",
+		commit.String() + " refs/tags/v1",
+	})
+
+	require.NoError(t, mirror.Storer.RemoveReference(plumbing.NewBranchReferenceName("other")))
+	require.NoError(t, pushMirror(context.Background(), mirrorPath, destinationPath, nil, nil))
+	require.NoError(t, pushMirror(context.Background(), mirrorPath, destinationPath, nil, nil))
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{
+		commit.String() + " refs/heads/master",
+		commit.String() + " refs/tags/v1",
+	})
+}
@@ -0,0 +1,57 @@
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/pkg/errors"
+)
+
+const errorTemplateWithoutSource = "The destination repository %s is a template, but the cache does not record which repository it was pulled from. Pull it again from a GitHub instance with this version of the sync tool."
+
+// isDestinationTemplate returns whether a destination repository is a template to be resolved with ResolveDestinationRepository rather than a name.
+func isDestinationTemplate(destinationRepository string) bool {
+	return strings.Contains(destinationRepository, "{{")
+}
+
+// ResolveDestinationRepository resolves a destination repository given as a template, such as `{{.owner}}/{{.name}}-mirror`, using the owner and name of the repository the cache was pulled from. The default destination of the CodeQL Action becomes the default for the kind of source in the cache, such as DefaultCLIDestinationRepository for a cache of the CodeQL CLI. Other names that are not templates are returned unchanged.
+func ResolveDestinationRepository(cacheDirectory cachedirectory.CacheDirectory, destinationRepository string) (string, error) {
+	if destinationRepository == DefaultDestinationRepository {
+		sourceKind, err := cacheDirectory.ReadSourceKind()
+		if err != nil {
+			return "", errors.Wrap(err, "Error reading source kind from cache.")
+		}
+		return defaultDestinationRepository(sourceKind), nil
+	}
+	if !isDestinationTemplate(destinationRepository) {
+		return destinationRepository, nil
+	}
+	parsedTemplate, err := template.New("destination-repository").Option("missingkey=error").Parse(destinationRepository)
+	if err != nil {
+		return "", fmt.Errorf("The destination repository template %s is not valid: %s", destinationRepository, err)
+	}
+	sourceRepository, err := cacheDirectory.ReadSourceRepository()
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading source repository from cache.")
+	}
+	sourceRepositorySplit := strings.Split(sourceRepository, "/")
+	if len(sourceRepositorySplit) != 2 {
+		return "", fmt.Errorf(errorTemplateWithoutSource, destinationRepository)
+	}
+	resolved := bytes.Buffer{}
+	err = parsedTemplate.Execute(&resolved, map[string]string{
+		"owner": sourceRepositorySplit[0],
+		"name":  sourceRepositorySplit[1],
+	})
+	if err != nil {
+		return "", fmt.Errorf("The destination repository template %s could not be resolved: %s", destinationRepository, err)
+	}
+	resolvedSplit := strings.Split(resolved.String(), "/")
+	if len(resolvedSplit) != 2 || resolvedSplit[0] == "" || resolvedSplit[1] == "" {
+		return "", fmt.Errorf("The destination repository template %s resolves to %s, which is not valid. It should resolve to owner/name.", destinationRepository, resolved.String())
+	}
+	return resolved.String(), nil
+}
@@ -0,0 +1,56 @@
+package push
+
+import (
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveDestinationRepository(t *testing.T) {
+	cacheDirectory := cachedirectory.NewCacheDirectory(test.CreateTemporaryDirectory(t))
+
+	resolved, err := ResolveDestinationRepository(cacheDirectory, "github/codeql-action")
+	require.NoError(t, err)
+	require.Equal(t, "github/codeql-action", resolved)
+
+	_, err = ResolveDestinationRepository(cacheDirectory, "{{.owner}}/{{.name}}-mirror")
+	require.EqualError(t, err, "The destination repository {{.owner}}/{{.name}}-mirror is a template, but the cache does not record which repository it was pulled from. Pull it again from a GitHub instance with this version of the sync tool.")
+
+	require.NoError(t, cacheDirectory.WriteSourceRepository("github/codeql-action"))
+	resolved, err = ResolveDestinationRepository(cacheDirectory, "{{.owner}}/{{.name}}-mirror")
+	require.NoError(t, err)
+	require.Equal(t, "github/codeql-action-mirror", resolved)
+	resolved, err = ResolveDestinationRepository(cacheDirectory, "actions-beta/{{.name}}")
+	require.NoError(t, err)
+	require.Equal(t, "actions-beta/codeql-action", resolved)
+
+	_, err = ResolveDestinationRepository(cacheDirectory, "{{.owner}}/{{.channel}}")
+	require.Error(t, err)
+	_, err = ResolveDestinationRepository(cacheDirectory, "{{.owner}}-{{.name}}")
+	require.EqualError(t, err, "The destination repository template {{.owner}}-{{.name}} resolves to github-codeql-action, which is not valid. It should resolve to owner/name.")
+	_, err = ResolveDestinationRepository(cacheDirectory, "{{.owner")
+	require.Error(t, err)
+}
+
+func TestResolveDestinationRepositoryForCLI(t *testing.T) {
+	cacheDirectory := cachedirectory.NewCacheDirectory(test.CreateTemporaryDirectory(t))
+	require.NoError(t, cacheDirectory.WriteSourceKind(cachedirectory.SourceKindCLI))
+
+	resolved, err := ResolveDestinationRepository(cacheDirectory, DefaultDestinationRepository)
+	require.NoError(t, err)
+	require.Equal(t, DefaultCLIDestinationRepository, resolved)
+	resolved, err = ResolveDestinationRepository(cacheDirectory, "tools/codeql-cli")
+	require.NoError(t, err)
+	require.Equal(t, "tools/codeql-cli", resolved)
+}
+
+func TestResolveDestinationRepositoryForQueries(t *testing.T) {
+	cacheDirectory := cachedirectory.NewCacheDirectory(test.CreateTemporaryDirectory(t))
+	require.NoError(t, cacheDirectory.WriteSourceKind(cachedirectory.SourceKindQueries))
+
+	resolved, err := ResolveDestinationRepository(cacheDirectory, DefaultDestinationRepository)
+	require.NoError(t, err)
+	require.Equal(t, DefaultQueriesDestinationRepository, resolved)
+}
@@ -0,0 +1,396 @@
+package push
+
+import (
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// noThin is advertised by servers that cannot accept deltas against objects that are not in the pack.
+const noThin = capability.Capability("no-thin")
+
+// thinPackWriter writes a pack of Git objects straight to the destination, one object at a time. go-git builds the whole pack in memory and searches for new deltas between every object, which takes a long time and a lot of memory for a repository the size of the CodeQL Action. Instead, the deltas already in the cache are sent as they are, and when their base is an object the destination already has it is left out, making a thin pack that the destination completes itself.
+type thinPackWriter struct {
+	objects storer.EncodedObjectStorer
+	deltas  storer.DeltaObjectStorer
+	// known are the objects the destination already has, which deltas may refer to without them being in the pack.
+	known   map[plumbing.Hash]bool
+	pushed  map[plumbing.Hash]bool
+	written map[plumbing.Hash]bool
+	// waiting are the deltas whose base is in the pack but has not been written yet, by base, so that no delta is written before its base and a chain of deltas cannot loop back on itself.
+	waiting    map[plumbing.Hash][]plumbing.Hash
+	writer     io.Writer
+	compressor *zlib.Writer
+}
+
+func newThinPackWriter(objects storer.EncodedObjectStorer, hashes []plumbing.Hash, known map[plumbing.Hash]bool) *thinPackWriter {
+	deltas, _ := objects.(storer.DeltaObjectStorer)
+	pushed := make(map[plumbing.Hash]bool, len(hashes))
+	for _, hash := range hashes {
+		pushed[hash] = true
+	}
+	return &thinPackWriter{
+		objects: objects,
+		deltas:  deltas,
+		known:   known,
+		pushed:  pushed,
+		written: make(map[plumbing.Hash]bool, len(hashes)),
+		waiting: map[plumbing.Hash][]plumbing.Hash{},
+	}
+}
+
+func writeObjectHeader(writer io.Writer, objectType plumbing.ObjectType, size int64) error {
+	header := []byte{byte(objectType)<<4 | byte(size&0x0f)}
+	for size >>= 4; size != 0; size >>= 7 {
+		header[len(header)-1] |= 0x80
+		header = append(header, byte(size&0x7f))
+	}
+	_, err := writer.Write(header)
+	return err
+}
+
+func (packWriter *thinPackWriter) writeContent(object plumbing.EncodedObject) error {
+	reader, err := object.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	packWriter.compressor.Reset(packWriter.writer)
+	_, err = io.Copy(packWriter.compressor, reader)
+	if err != nil {
+		return err
+	}
+	return packWriter.compressor.Close()
+}
+
+func (packWriter *thinPackWriter) writeFull(hash plumbing.Hash) error {
+	object, err := packWriter.objects.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return err
+	}
+	err = writeObjectHeader(packWriter.writer, object.Type(), object.Size())
+	if err != nil {
+		return err
+	}
+	return packWriter.writeContent(object)
+}
+
+func (packWriter *thinPackWriter) writeDelta(delta plumbing.DeltaObject) error {
+	err := writeObjectHeader(packWriter.writer, plumbing.REFDeltaObject, delta.Size())
+	if err != nil {
+		return err
+	}
+	base := delta.BaseHash()
+	_, err = packWriter.writer.Write(base[:])
+	if err != nil {
+		return err
+	}
+	return packWriter.writeContent(delta)
+}
+
+// markWritten records that an object has been written, and then writes any deltas that were waiting for it.
+func (packWriter *thinPackWriter) markWritten(hash plumbing.Hash) error {
+	packWriter.written[hash] = true
+	dependents := packWriter.waiting[hash]
+	delete(packWriter.waiting, hash)
+	for _, dependent := range dependents {
+		err := packWriter.writeObject(dependent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (packWriter *thinPackWriter) writeObject(hash plumbing.Hash) error {
+	if packWriter.written[hash] {
+		return nil
+	}
+	if packWriter.deltas != nil {
+		object, err := packWriter.deltas.DeltaObject(plumbing.AnyObject, hash)
+		if err != nil {
+			return err
+		}
+		if delta, ok := object.(plumbing.DeltaObject); ok {
+			base := delta.BaseHash()
+			if packWriter.written[base] || packWriter.known[base] {
+				err = packWriter.writeDelta(delta)
+				if err != nil {
+					return err
+				}
+				return packWriter.markWritten(hash)
+			}
+			if packWriter.pushed[base] {
+				packWriter.waiting[base] = append(packWriter.waiting[base], hash)
+				return nil
+			}
+		}
+	}
+	err := packWriter.writeFull(hash)
+	if err != nil {
+		return err
+	}
+	return packWriter.markWritten(hash)
+}
+
+// write writes a pack of the given objects, with its header and checksum.
+func (packWriter *thinPackWriter) write(output io.Writer, hashes []plumbing.Hash) error {
+	checksum := sha1.New()
+	packWriter.writer = io.MultiWriter(output, checksum)
+	packWriter.compressor = zlib.NewWriter(packWriter.writer)
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(hashes)))
+	_, err := packWriter.writer.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		err = packWriter.writeObject(hash)
+		if err != nil {
+			return err
+		}
+	}
+	// Anything still waiting is part of a loop of deltas, which can happen when the cache has the same objects in more than one pack, so it is written in full.
+	for len(packWriter.waiting) != 0 {
+		for base, dependents := range packWriter.waiting {
+			delete(packWriter.waiting, base)
+			for _, hash := range dependents {
+				if packWriter.written[hash] {
+					continue
+				}
+				err = packWriter.writeFull(hash)
+				if err != nil {
+					return err
+				}
+				err = packWriter.markWritten(hash)
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+	}
+	if len(packWriter.written) != len(hashes) {
+		return errors.Errorf("Wrote %d of %d Git objects to the pack.", len(packWriter.written), len(hashes))
+	}
+	_, err = output.Write(checksum.Sum(nil))
+	return err
+}
+
+// referenceUpdates works out the commands to send for a set of refspecs in the same way as go-git does. Every refspec the sync tool pushes is either forced or a deletion, so fast-forwards are not checked.
+func referenceUpdates(gitRepository *git.Repository, refSpecs []config.RefSpec, remoteReferences storer.ReferenceStorer) ([]*packp.Command, error) {
+	localReferences := []*plumbing.Reference{}
+	iterator, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	err = iterator.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference {
+			localReferences = append(localReferences, reference)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	commands := []*packp.Command{}
+	for _, refSpec := range refSpecs {
+		if refSpec.IsDelete() {
+			remoteReference, err := remoteReferences.Reference(refSpec.Dst(""))
+			if err == plumbing.ErrReferenceNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if remoteReference.Type() == plumbing.HashReference {
+				commands = append(commands, &packp.Command{Name: remoteReference.Name(), Old: remoteReference.Hash(), New: plumbing.ZeroHash})
+			}
+			continue
+		}
+		if !refSpec.IsForceUpdate() {
+			return nil, errors.Errorf("Refspec %s is not forced.", refSpec)
+		}
+		for _, localReference := range localReferences {
+			if !refSpec.Match(localReference.Name()) {
+				continue
+			}
+			command := &packp.Command{Name: refSpec.Dst(localReference.Name()), New: localReference.Hash()}
+			remoteReference, err := remoteReferences.Reference(command.Name)
+			if err == nil {
+				if remoteReference.Type() != plumbing.HashReference {
+					continue
+				}
+				command.Old = remoteReference.Hash()
+			} else if err != plumbing.ErrReferenceNotFound {
+				return nil, err
+			}
+			if command.Old != command.New {
+				commands = append(commands, command)
+			}
+		}
+	}
+	return commands, nil
+}
+
+// objectsToPush finds the objects the destination needs for the given commands, and the objects it already has, which may be used as the bases of deltas. If the objects the destination has cannot all be found in the cache, the pack is not made thin.
+func objectsToPush(objects storer.EncodedObjectStorer, commands []*packp.Command, remoteReferences storer.ReferenceStorer, shallow []plumbing.Hash) ([]plumbing.Hash, map[plumbing.Hash]bool, error) {
+	wants := []plumbing.Hash{}
+	for _, command := range commands {
+		if command.Action() != packp.Delete {
+			wants = append(wants, command.New)
+		}
+	}
+	if len(wants) == 0 {
+		return nil, nil, nil
+	}
+	haves := append([]plumbing.Hash{}, shallow...)
+	iterator, err := remoteReferences.IterReferences()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = iterator.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference && objects.HasEncodedObject(reference.Hash()) == nil {
+			haves = append(haves, reference.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	knownHashes, err := revlist.Objects(objects, haves, nil)
+	if err != nil {
+		log.Debugf("Not sending a thin pack, as the objects the destination has could not all be found: %s", err)
+		hashes, err := revlist.Objects(objects, wants, haves)
+		return hashes, nil, err
+	}
+	known := make(map[plumbing.Hash]bool, len(knownHashes))
+	for _, hash := range knownHashes {
+		known[hash] = true
+	}
+	hashes, err := revlist.Objects(objects, wants, knownHashes)
+	return hashes, known, err
+}
+
+// streamPush pushes a batch of refspecs to a remote, streaming a thin pack of the objects it needs. It returns git.NoErrAlreadyUpToDate if there is nothing to push, like go-git. A push that fails with a transient error is started again, which is safe as the references of the remote are listed afresh each time.
+func streamPush(ctx context.Context, gitRepository *git.Repository, gitStorer storage.Storer, remoteURL string, credentials transport.AuthMethod, refSpecs []config.RefSpec, progress io.Writer) error {
+	return retry.Do(ctx, "pushing Git references", func(attempt int) error {
+		return streamPushOnce(ctx, gitRepository, gitStorer, remoteURL, credentials, refSpecs, progress)
+	})
+}
+
+func streamPushOnce(ctx context.Context, gitRepository *git.Repository, gitStorer storage.Storer, remoteURL string, credentials transport.AuthMethod, refSpecs []config.RefSpec, progress io.Writer) (err error) {
+	endpoint, err := transport.NewEndpoint(remoteURL)
+	if err != nil {
+		return err
+	}
+	gitClient, err := client.NewClient(endpoint)
+	if err != nil {
+		return err
+	}
+	session, err := gitClient.NewReceivePackSession(endpoint, credentials)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := session.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	advertised, err := session.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+	remoteReferences, err := advertised.AllReferences()
+	if err != nil {
+		return err
+	}
+	request := packp.NewReferenceUpdateRequestFromCapabilities(advertised.Capabilities)
+	if progress != nil {
+		request.Progress = progress
+		if advertised.Capabilities.Supports(capability.Sideband64k) {
+			request.Capabilities.Set(capability.Sideband64k)
+		} else if advertised.Capabilities.Supports(capability.Sideband) {
+			request.Capabilities.Set(capability.Sideband)
+		}
+	}
+	request.Commands, err = referenceUpdates(gitRepository, refSpecs, remoteReferences)
+	if err != nil {
+		return err
+	}
+	if len(request.Commands) == 0 {
+		return git.NoErrAlreadyUpToDate
+	}
+	allDelete := true
+	for _, command := range request.Commands {
+		if command.Action() != packp.Delete {
+			allDelete = false
+		}
+	}
+	for _, command := range request.Commands {
+		if command.Action() == packp.Delete && !advertised.Capabilities.Supports(capability.DeleteRefs) {
+			return git.ErrDeleteRefNotSupported
+		}
+	}
+
+	shallow, err := gitStorer.Shallow()
+	if err != nil {
+		return err
+	}
+	hashes, known, err := objectsToPush(gitRepository.Storer, request.Commands, remoteReferences, shallow)
+	if err != nil {
+		return errors.Wrap(err, "Error finding Git objects to push.")
+	}
+	if advertised.Capabilities.Supports(noThin) {
+		known = nil
+	}
+
+	done := make(chan error, 1)
+	if allDelete {
+		close(done)
+	} else {
+		log.Debugf("Sending %d Git objects...", len(hashes))
+		reader, writer := io.Pipe()
+		request.Packfile = reader
+		go func() {
+			err := newThinPackWriter(gitRepository.Storer, hashes, known).write(writer, hashes)
+			if err != nil {
+				writer.CloseWithError(err)
+				done <- err
+				return
+			}
+			done <- writer.Close()
+		}()
+	}
+	status, err := session.ReceivePack(ctx, request)
+	if err != nil {
+		if request.Packfile != nil {
+			request.Packfile.Close()
+		}
+		return err
+	}
+	err = <-done
+	if err != nil {
+		return err
+	}
+	return status.Error()
+}
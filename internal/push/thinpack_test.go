@@ -0,0 +1,128 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// createPackedRepository creates a repository with two commits of a file, the second removing lines from the first so that it is packed as a delta of it, and returns it with both commits.
+func createPackedRepository(t *testing.T) (*git.Repository, plumbing.Hash, plumbing.Hash) {
+	repositoryPath := path.Join(test.CreateTemporaryDirectory(t), "repository")
+	repository, err := git.PlainInit(repositoryPath, false)
+	require.NoError(t, err)
+	worktree, err := repository.Worktree()
+	require.NoError(t, err)
+	lines := []string{}
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, strings.Repeat("line ", i%20))
+	}
+	signature := &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}
+	commits := []plumbing.Hash{}
+	for _, content := range []string{strings.Join(lines, "\n"), strings.Join(lines[10:], "\n")} {
+		require.NoError(t, ioutil.WriteFile(path.Join(repositoryPath, "file.txt"), []byte(content), 0644))
+		_, err = worktree.Add("file.txt")
+		require.NoError(t, err)
+		commit, err := worktree.Commit("A commit.", &git.CommitOptions{Author: signature})
+		require.NoError(t, err)
+		commits = append(commits, commit)
+	}
+	require.NoError(t, repository.RepackObjects(&git.RepackConfig{}))
+	repository, err = git.PlainOpen(repositoryPath)
+	require.NoError(t, err)
+	return repository, commits[0], commits[1]
+}
+
+func writeTestPack(t *testing.T, repository *git.Repository, hashes []plumbing.Hash, known map[plumbing.Hash]bool) []byte {
+	pack := bytes.Buffer{}
+	require.NoError(t, newThinPackWriter(repository.Storer, hashes, known).write(&pack, hashes))
+	return pack.Bytes()
+}
+
+// readTestPack reads a pack into a new repository that already has the given objects, and checks it then has all of the pushed objects.
+func readTestPack(t *testing.T, repository *git.Repository, pack []byte, existing []plumbing.Hash, pushed []plumbing.Hash) {
+	storage := memory.NewStorage()
+	for _, hash := range existing {
+		object, err := repository.Storer.EncodedObject(plumbing.AnyObject, hash)
+		require.NoError(t, err)
+		_, err = storage.SetEncodedObject(object)
+		require.NoError(t, err)
+	}
+	require.NoError(t, packfile.UpdateObjectStorage(storage, bytes.NewReader(pack)))
+	for _, hash := range pushed {
+		require.NoError(t, storage.HasEncodedObject(hash))
+	}
+}
+
+func TestThinPackWriterFullPack(t *testing.T) {
+	repository, _, second := createPackedRepository(t)
+	hashes, err := revlist.Objects(repository.Storer, []plumbing.Hash{second}, nil)
+	require.NoError(t, err)
+	require.Len(t, hashes, 6)
+	readTestPack(t, repository, writeTestPack(t, repository, hashes, nil), nil, hashes)
+}
+
+func TestThinPackWriterThinPack(t *testing.T) {
+	repository, first, second := createPackedRepository(t)
+	known, err := revlist.Objects(repository.Storer, []plumbing.Hash{first}, nil)
+	require.NoError(t, err)
+	hashes, err := revlist.Objects(repository.Storer, []plumbing.Hash{second}, known)
+	require.NoError(t, err)
+	require.Len(t, hashes, 3)
+	knownSet := map[plumbing.Hash]bool{}
+	for _, hash := range known {
+		knownSet[hash] = true
+	}
+
+	thinPack := writeTestPack(t, repository, hashes, knownSet)
+	fullPack := writeTestPack(t, repository, hashes, nil)
+	require.Less(t, len(thinPack), len(fullPack))
+	readTestPack(t, repository, thinPack, known, hashes)
+	readTestPack(t, repository, fullPack, known, hashes)
+}
+
+func TestWriteObjectHeader(t *testing.T) {
+	header := bytes.Buffer{}
+	require.NoError(t, writeObjectHeader(&header, plumbing.BlobObject, 15))
+	require.Equal(t, []byte{0x3f}, header.Bytes())
+	header.Reset()
+	require.NoError(t, writeObjectHeader(&header, plumbing.REFDeltaObject, 1000))
+	require.Equal(t, []byte{0xf8, 0x3e}, header.Bytes())
+}
+
+func TestStreamPushThinPack(t *testing.T) {
+	repository, first, second := createPackedRepository(t)
+	destinationPath := path.Join(test.CreateTemporaryDirectory(t), "target")
+	_, err := git.PlainInit(destinationPath, true)
+	require.NoError(t, err)
+	refSpecs := []config.RefSpec{"+refs/heads/*:refs/heads/*"}
+
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, first)))
+	require.NoError(t, streamPush(context.Background(), repository, repository.Storer, destinationPath, nil, refSpecs, nil))
+	require.Equal(t, git.NoErrAlreadyUpToDate, streamPush(context.Background(), repository, repository.Storer, destinationPath, nil, refSpecs, nil))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, second)))
+	require.NoError(t, streamPush(context.Background(), repository, repository.Storer, destinationPath, nil, refSpecs, nil))
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{second.String() + " refs/heads/master"})
+	destination, err := git.PlainOpen(destinationPath)
+	require.NoError(t, err)
+	_, err = revlist.Objects(destination.Storer, []plumbing.Hash{second}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, streamPush(context.Background(), repository, repository.Storer, destinationPath, nil, []config.RefSpec{"+refs/heads/master:refs/heads/other"}, nil))
+	require.NoError(t, streamPush(context.Background(), repository, repository.Storer, destinationPath, nil, []config.RefSpec{":refs/heads/other"}, nil))
+	test.CheckExpectedReferencesInRepository(t, destinationPath, []string{second.String() + " refs/heads/master"})
+}
@@ -0,0 +1,74 @@
+package push
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/retry"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// downloadedAsset describes the content of an asset as served back by the destination.
+type downloadedAsset struct {
+	size   int64
+	digest string
+}
+
+// downloadUploadedAsset downloads an asset from the destination, keeping only its size and checksum.
+func (pushService *pushService) downloadUploadedAsset(uploadedAsset *github.ReleaseAsset) (downloadedAsset, error) {
+	var result downloadedAsset
+	err := retry.Do(pushService.ctx, "downloading release asset "+uploadedAsset.GetName(), func(attempt int) error {
+		// Downloads are made with the upload client so that they do not count towards the limit on simultaneous API requests.
+		reader, _, err := pushService.githubEnterpriseUploadClient.Repositories.DownloadReleaseAsset(pushService.ctx, pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, uploadedAsset.GetID(), http.DefaultClient)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		hash := sha256.New()
+		size, err := io.Copy(hash, reader)
+		if err != nil {
+			return err
+		}
+		result = downloadedAsset{size: size, digest: hex.EncodeToString(hash.Sum(nil))}
+		return nil
+	})
+	if err != nil {
+		return downloadedAsset{}, errors.Wrap(err, "Error downloading uploaded release asset.")
+	}
+	return result, nil
+}
+
+// verifyUploadedAsset checks that an asset reads back from the destination exactly as it was uploaded, since a proxy between the sync tool and GitHub Enterprise Server can truncate an upload without either side reporting an error. An asset that does not match is removed so that the next push uploads it again.
+func (pushService *pushService) verifyUploadedAsset(upload assetUpload, uploadedAsset *github.ReleaseAsset) error {
+	release := upload.release
+	asset := upload.asset
+	log.Debugf("Verifying uploaded release asset %s...", asset.Name)
+	mismatch := ""
+	if githubapiutil.AssetSize(uploadedAsset) != asset.Size {
+		mismatch = fmt.Sprintf("the destination reports a size of %d bytes rather than %d", githubapiutil.AssetSize(uploadedAsset), asset.Size)
+	} else {
+		downloaded, err := pushService.downloadUploadedAsset(uploadedAsset)
+		if err != nil {
+			return err
+		}
+		if downloaded.size != asset.Size {
+			mismatch = fmt.Sprintf("%d bytes were downloaded rather than %d", downloaded.size, asset.Size)
+		} else if downloaded.digest != upload.digest {
+			mismatch = fmt.Sprintf("the downloaded content has the SHA-256 checksum %s rather than %s", downloaded.digest, upload.digest)
+		}
+	}
+	if mismatch == "" {
+		return nil
+	}
+	err := pushService.deleteReleaseAsset(release, uploadedAsset)
+	if err != nil {
+		return errors.Wrap(err, "Error removing release asset that failed verification.")
+	}
+	return fmt.Errorf("The release asset %s/%s does not match the cache after uploading: %s. It has been removed so that the next push uploads it again.", release.GetTagName(), asset.Name, mismatch)
+}
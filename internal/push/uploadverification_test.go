@@ -0,0 +1,50 @@
+package push
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyUploadedAsset(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, "", githubEnterpriseURL)
+	content := "A CodeQL bundle."
+	served := content
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/assets/11", func(response http.ResponseWriter, request *http.Request) {
+		require.Equal(t, "application/octet-stream", request.Header.Get("Accept"))
+		response.Write([]byte(served))
+	}).Methods("GET")
+	deletedAssets := 0
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/releases/assets/11", func(response http.ResponseWriter, request *http.Request) {
+		deletedAssets++
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	upload := assetUpload{
+		release: &github.RepositoryRelease{ID: github.Int64(1), TagName: github.String("codeql-bundle-20200101")},
+		asset:   cachedirectory.Asset{Name: "codeql-bundle.tar.gz", Size: int64(len(content))},
+		digest:  sha256Hex(content),
+	}
+	uploadedAsset := &github.ReleaseAsset{ID: github.Int64(11), Name: github.String("codeql-bundle.tar.gz"), Size: github.Int(len(content))}
+	require.NoError(t, pushService.verifyUploadedAsset(upload, uploadedAsset))
+	require.Equal(t, 0, deletedAssets)
+
+	served = "A CodeQL"
+	require.EqualError(t, pushService.verifyUploadedAsset(upload, uploadedAsset), "The release asset codeql-bundle-20200101/codeql-bundle.tar.gz does not match the cache after uploading: 8 bytes were downloaded rather than 16. It has been removed so that the next push uploads it again.")
+	require.Equal(t, 1, deletedAssets)
+
+	served = "A CodeQL bundle!"
+	require.EqualError(t, pushService.verifyUploadedAsset(upload, uploadedAsset), "The release asset codeql-bundle-20200101/codeql-bundle.tar.gz does not match the cache after uploading: the downloaded content has the SHA-256 checksum "+sha256Hex(served)+" rather than "+sha256Hex(content)+". It has been removed so that the next push uploads it again.")
+	require.Equal(t, 2, deletedAssets)
+
+	// If the destination already reports the wrong size, there is no need to download the asset.
+	served = ""
+	truncatedAsset := &github.ReleaseAsset{ID: github.Int64(11), Name: github.String("codeql-bundle.tar.gz"), Size: github.Int(8)}
+	require.EqualError(t, pushService.verifyUploadedAsset(upload, truncatedAsset), "The release asset codeql-bundle-20200101/codeql-bundle.tar.gz does not match the cache after uploading: the destination reports a size of 8 bytes rather than 16. It has been removed so that the next push uploads it again.")
+	require.Equal(t, 3, deletedAssets)
+}
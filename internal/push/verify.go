@@ -0,0 +1,133 @@
+package push
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// actionEntryPoints are the actions within the CodeQL Action repository that workflows refer to, for example `github/codeql-action/init@v1`.
+var actionEntryPoints = []string{"init", "autobuild", "analyze", "upload-sarif"}
+
+var majorVersionReferencePattern = regexp.MustCompile(`^refs/(heads|tags)/(v[0-9]+)$`)
+
+var runsKeyPattern = regexp.MustCompile(`(?m)^runs:`)
+
+type actionReference struct {
+	version    string
+	entryPoint string
+	blobHash   plumbing.Hash
+}
+
+func (actionReference actionReference) String() string {
+	return fmt.Sprintf("%s@%s", actionReference.entryPoint, actionReference.version)
+}
+
+// localActionReferences finds every entry point in the cache that a workflow could refer to by a major version.
+func (pushService *pushService) localActionReferences() ([]actionReference, error) {
+	gitRepository, err := pushService.openGitRepository()
+	if err != nil {
+		return nil, err
+	}
+	references, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	result := []actionReference{}
+	seenVersions := map[string]bool{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		match := majorVersionReferencePattern.FindStringSubmatch(reference.Name().String())
+		if match == nil || reference.Type() != plumbing.HashReference || seenVersions[match[2]] {
+			return nil
+		}
+		// As with Actions, a tag takes precedence over a branch of the same name.
+		if match[1] == "heads" {
+			_, err := gitRepository.Reference(plumbing.NewTagReferenceName(match[2]), false)
+			if err == nil {
+				return nil
+			}
+		}
+		seenVersions[match[2]] = true
+		commit, err := gitRepository.CommitObject(reference.Hash())
+		if err != nil {
+			return errors.Wrapf(err, "Error reading commit for reference %s.", reference.Name())
+		}
+		for _, entryPoint := range actionEntryPoints {
+			file, err := commit.File(entryPoint + "/action.yml")
+			if err == object.ErrFileNotFound {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "Error reading %s/action.yml at %s.", entryPoint, match[2])
+			}
+			result = append(result, actionReference{version: match[2], entryPoint: entryPoint, blobHash: file.Hash})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifyActionReference checks that the destination resolves an action reference to the same metadata as the cache, and that the metadata can be used by Actions.
+func (pushService *pushService) verifyActionReference(reference actionReference) error {
+	content, _, response, err := pushService.githubEnterpriseClient.Repositories.GetContents(
+		pushService.ctx,
+		pushService.destinationRepositoryOwner,
+		pushService.destinationRepositoryName,
+		reference.entryPoint+"/action.yml",
+		&github.RepositoryContentGetOptions{Ref: reference.version},
+	)
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return errors.New("the action metadata could not be found")
+		}
+		return errors.Wrap(err, "Error fetching action metadata.")
+	}
+	if content == nil {
+		return errors.New("the action metadata is not a file")
+	}
+	if content.GetSHA() != reference.blobHash.String() {
+		return errors.Errorf("the action metadata resolves to %s but %s was expected", content.GetSHA(), reference.blobHash.String())
+	}
+	metadata, err := content.GetContent()
+	if err != nil {
+		return errors.Wrap(err, "Error decoding action metadata.")
+	}
+	if !runsKeyPattern.MatchString(metadata) {
+		return errors.New("the action metadata does not define how the action runs")
+	}
+	return nil
+}
+
+// verifyActions confirms that the destination can resolve each of the major version references to the CodeQL Action that workflows use.
+func (pushService *pushService) verifyActions() error {
+	log.Debug("Verifying pushed actions...")
+	references, err := pushService.localActionReferences()
+	if err != nil {
+		return err
+	}
+	failures := []string{}
+	for _, reference := range references {
+		uses := fmt.Sprintf("%s/%s/%s", pushService.destinationRepositoryOwner, pushService.destinationRepositoryName, reference)
+		err := pushService.verifyActionReference(reference)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", uses, err))
+			continue
+		}
+		log.Debugf("Verified %s.", uses)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("The destination could not resolve some of the pushed actions:\n  %s", strings.Join(failures, "\n  "))
+	}
+	log.Infof("Verified %d action references.", len(references))
+	return nil
+}
@@ -0,0 +1,82 @@
+package push
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+const initActionMetadata = "name: 'CodeQL: Init'\nruns:\n  using: 'node12'\n  main: '../lib/init-action.js'\n"
+
+func createTestActionCache(t *testing.T) string {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	gitPath := filepath.Join(temporaryDirectory, "git")
+	repository, err := git.PlainInit(gitPath, false)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(gitPath, "init"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(gitPath, "init", "action.yml"), []byte(initActionMetadata), 0644))
+	worktree, err := repository.Worktree()
+	require.NoError(t, err)
+	_, err = worktree.Add("init/action.yml")
+	require.NoError(t, err)
+	commit, err := worktree.Commit("Add init action.", &git.CommitOptions{Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}})
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/v1", commit)))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference("refs/tags/v2", commit)))
+	return temporaryDirectory
+}
+
+func TestVerifyActions(t *testing.T) {
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, createTestActionCache(t), githubEnterpriseURL)
+
+	references, err := pushService.localActionReferences()
+	require.NoError(t, err)
+	require.Len(t, references, 2)
+	blobHash := references[0].blobHash.String()
+
+	served := map[string]string{"v1": blobHash, "v2": blobHash}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name/contents/init/action.yml", func(response http.ResponseWriter, request *http.Request) {
+		sha, ok := served[request.URL.Query().Get("ref")]
+		if !ok {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		test.ServeHTTPResponseFromObject(t, github.RepositoryContent{
+			Type:     github.String("file"),
+			Encoding: github.String("base64"),
+			SHA:      github.String(sha),
+			Content:  github.String(base64.StdEncoding.EncodeToString([]byte(initActionMetadata))),
+		}, response)
+	}).Methods("GET")
+	require.NoError(t, pushService.verifyActions())
+
+	served["v2"] = "0000000000000000000000000000000000000000"
+	delete(served, "v1")
+	err = pushService.verifyActions()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "destination-repository-owner/destination-repository-name/init@v1: the action metadata could not be found")
+	require.Contains(t, err.Error(), "destination-repository-owner/destination-repository-name/init@v2: the action metadata resolves to 0000000000000000000000000000000000000000")
+}
+
+func TestLocalActionReferencesPrefersTags(t *testing.T) {
+	cache := createTestActionCache(t)
+	repository, err := git.PlainOpen(filepath.Join(cache, "git"))
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/v2", plumbing.NewHash("0000000000000000000000000000000000000000"))))
+	pushService := getTestPushService(t, cache, "")
+	references, err := pushService.localActionReferences()
+	require.NoError(t, err)
+	require.Len(t, references, 2)
+}
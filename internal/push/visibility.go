@@ -0,0 +1,57 @@
+package push
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	VisibilityPublic   = "public"
+	VisibilityInternal = "internal"
+	VisibilityPrivate  = "private"
+)
+
+// checkVisibility checks that a visibility given with `--destination-visibility` is one GitHub Enterprise Server understands.
+func checkVisibility(visibility string) error {
+	switch visibility {
+	case "", VisibilityPublic, VisibilityInternal, VisibilityPrivate:
+		return nil
+	}
+	return fmt.Errorf("The visibility %s given with `--destination-visibility` is not one of `%s`, `%s` or `%s`.", visibility, VisibilityPublic, VisibilityInternal, VisibilityPrivate)
+}
+
+func (metadata RepositoryMetadata) visibility() string {
+	if metadata.Visibility == "" {
+		return VisibilityPublic
+	}
+	return metadata.Visibility
+}
+
+// repositoryScope returns the token scope needed to create and update the destination repository, as only public repositories can be managed with `public_repo`.
+func (pushService *pushService) repositoryScope() string {
+	if pushService.repositoryMetadata.visibility() == VisibilityPublic {
+		return "public_repo"
+	}
+	return "repo"
+}
+
+// repositoryScopes returns the scopes that grant repositoryScope.
+func (pushService *pushService) repositoryScopes() []string {
+	if pushService.repositoryMetadata.visibility() == VisibilityPublic {
+		return []string{"public_repo", "repo"}
+	}
+	return []string{"repo"}
+}
+
+// setVisibility sets the visibility of the destination repository in the properties it is created or updated with. Public and private repositories are set with `private`, which older versions of GitHub Enterprise Server also understand, and only internal ones need `visibility`.
+func setVisibility(properties *github.Repository, visibility string) {
+	switch visibility {
+	case VisibilityInternal:
+		properties.Visibility = github.String(VisibilityInternal)
+	case VisibilityPrivate:
+		properties.Private = github.Bool(true)
+	default:
+		properties.Private = github.Bool(false)
+	}
+}
@@ -0,0 +1,77 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckVisibility(t *testing.T) {
+	for _, visibility := range []string{"", VisibilityPublic, VisibilityInternal, VisibilityPrivate} {
+		require.NoError(t, checkVisibility(visibility))
+	}
+	require.EqualError(t, checkVisibility("secret"), "The visibility secret given with `--destination-visibility` is not one of `public`, `internal` or `private`.")
+}
+
+func TestCreateRepositoryWithVisibility(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.repositoryMetadata = RepositoryMetadata{Visibility: VisibilityInternal}
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+	var created *github.Repository
+	githubTestServer.HandleFunc("/api/v3/user/repos", func(response http.ResponseWriter, request *http.Request) {
+		created = &github.Repository{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(created))
+		test.ServeHTTPResponseFromObject(t, created, response)
+	}).Methods("POST")
+	serveTestTopics(t, githubTestServer)
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+	require.NotNil(t, created)
+	require.Equal(t, VisibilityInternal, created.GetVisibility())
+	require.Nil(t, created.Private)
+	require.Equal(t, "repo", pushService.repositoryScope())
+}
+
+func TestUpdateRepositoryVisibility(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	githubTestServer, githubEnterpriseURL := test.GetTestHTTPServer(t)
+	pushService := getTestPushService(t, temporaryDirectory, githubEnterpriseURL)
+	pushService.repositoryMetadata = RepositoryMetadata{Visibility: VisibilityPrivate}
+	githubTestServer.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.User{Login: github.String("destination-repository-owner")}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.Repository{Homepage: github.String(DefaultRepositoryHomepage)}, response)
+	}).Methods("GET")
+	edits := []github.Repository{}
+	githubTestServer.HandleFunc("/api/v3/repos/destination-repository-owner/destination-repository-name", func(response http.ResponseWriter, request *http.Request) {
+		edit := github.Repository{}
+		require.NoError(t, json.NewDecoder(request.Body).Decode(&edit))
+		edits = append(edits, edit)
+		test.ServeHTTPResponseFromObject(t, edit, response)
+	}).Methods("PATCH")
+
+	// The visibility of an existing repository is left alone unless asked to update it.
+	_, err := pushService.createRepository()
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+	require.Nil(t, edits[0].Private)
+	require.Nil(t, edits[0].Visibility)
+
+	pushService.repositoryMetadata.UpdateVisibility = true
+	_, err = pushService.createRepository()
+	require.NoError(t, err)
+	require.Len(t, edits, 2)
+	require.True(t, edits[1].GetPrivate())
+}
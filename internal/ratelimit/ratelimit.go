@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/bytesize"
+)
+
+const errorInvalidRate = "The rate `%s` is not valid. Rates are given in bytes per second with an optional K, M or G suffix, for example `500K` or `10M`."
+
+// ParseRate parses a rate such as `10M` into a number of bytes per second. Suffixes are powers of 1024. An empty string or zero means unlimited.
+func ParseRate(rate string) (int64, error) {
+	value, err := bytesize.Parse(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(rate)), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf(errorInvalidRate, rate)
+	}
+	return value, nil
+}
+
+// Limiter is a token bucket which allows an average number of bytes per second, with bursts of up to one second's worth of data.
+type Limiter struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLimiter creates a limiter for the given number of bytes per second, or returns nil if the rate is unlimited.
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+func (limiter *Limiter) burst() int {
+	return int(limiter.rate)
+}
+
+// reserve takes tokens for the given number of bytes and returns how long to wait before they may be transferred.
+func (limiter *Limiter) reserve(bytes int) time.Duration {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	now := limiter.now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.rate {
+		limiter.tokens = limiter.rate
+	}
+	limiter.last = now
+	limiter.tokens -= float64(bytes)
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+}
+
+// Wait blocks until the given number of bytes may be transferred.
+func (limiter *Limiter) Wait(ctx context.Context, bytes int) error {
+	delay := limiter.reserve(bytes)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type limitedReadCloser struct {
+	ctx     context.Context
+	reader  io.ReadCloser
+	limiter *Limiter
+}
+
+func (limitedReadCloser *limitedReadCloser) Read(data []byte) (int, error) {
+	if burst := limitedReadCloser.limiter.burst(); len(data) > burst {
+		data = data[:burst]
+	}
+	count, err := limitedReadCloser.reader.Read(data)
+	if count > 0 {
+		waitErr := limitedReadCloser.limiter.Wait(limitedReadCloser.ctx, count)
+		if waitErr != nil {
+			return count, waitErr
+		}
+	}
+	return count, err
+}
+
+func (limitedReadCloser *limitedReadCloser) Close() error {
+	return limitedReadCloser.reader.Close()
+}
+
+// NewReadCloser wraps a reader so that data is read from it no faster than the limiter allows. If the limiter is nil the reader is returned unchanged.
+func NewReadCloser(ctx context.Context, reader io.ReadCloser, limiter *Limiter) io.ReadCloser {
+	if limiter == nil || reader == nil || reader == http.NoBody {
+		return reader
+	}
+	return &limitedReadCloser{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+// Transport is an HTTP transport which throttles request bodies to the upload limiter and response bodies to the download limiter.
+type Transport struct {
+	Base     http.RoundTripper
+	Download *Limiter
+	Upload   *Limiter
+}
+
+func (transport *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	if transport.Upload != nil && request.Body != nil {
+		request = request.Clone(ctx)
+		request.Body = NewReadCloser(ctx, request.Body, transport.Upload)
+		if getBody := request.GetBody; getBody != nil {
+			request.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return NewReadCloser(ctx, body, transport.Upload), nil
+			}
+		}
+	}
+	response, err := transport.Base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = NewReadCloser(ctx, response.Body, transport.Download)
+	return response, nil
+}
+
+// Install throttles all HTTP traffic made through the default transport, which includes the GitHub API, Git operations over HTTPS and remote cache storage.
+func Install(downloadBytesPerSecond int64, uploadBytesPerSecond int64) {
+	download := NewLimiter(downloadBytesPerSecond)
+	upload := NewLimiter(uploadBytesPerSecond)
+	if download == nil && upload == nil {
+		return
+	}
+	http.DefaultTransport = &Transport{Base: http.DefaultTransport, Download: download, Upload: upload}
+}
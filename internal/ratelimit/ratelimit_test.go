@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"":        0,
+		"0":       0,
+		"512":     512,
+		"500K":    500 * 1024,
+		"10MB/s":  10 * 1024 * 1024,
+		"1.5m":    1536 * 1024,
+		"2 GiB/s": 2 * 1024 * 1024 * 1024,
+	} {
+		actual, err := ParseRate(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, actual, input)
+	}
+	_, err := ParseRate("fast")
+	require.Error(t, err)
+	_, err = ParseRate("99999999999G/s")
+	require.Error(t, err)
+}
+
+func TestLimiterReserve(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewLimiter(1000)
+	limiter.now = func() time.Time { return now }
+	limiter.last = now
+	require.Equal(t, time.Duration(0), limiter.reserve(1000))
+	require.Equal(t, 500*time.Millisecond, limiter.reserve(500))
+	now = now.Add(time.Second)
+	require.Equal(t, time.Duration(0), limiter.reserve(500))
+	now = now.Add(time.Hour)
+	require.Equal(t, time.Duration(0), limiter.reserve(1000))
+	require.Equal(t, time.Second, limiter.reserve(1000))
+	require.Nil(t, NewLimiter(0))
+}
+
+func TestTransport(t *testing.T) {
+	testServer, testURL := test.GetTestHTTPServer(t)
+	content := bytes.Repeat([]byte("x"), 1500)
+	testServer.HandleFunc("/echo", func(response http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		response.Write(body)
+	}).Methods("POST")
+
+	client := http.Client{Transport: &Transport{Base: http.DefaultTransport, Download: NewLimiter(1000), Upload: NewLimiter(100000)}}
+	start := time.Now()
+	response, err := client.Post(testURL+"/echo", "application/octet-stream", bytes.NewReader(content))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, content, body)
+	require.True(t, time.Since(start) >= 400*time.Millisecond)
+}
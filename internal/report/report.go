@@ -0,0 +1,223 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Outcomes of syncing a release or asset.
+const (
+	OutcomeDownloaded = "downloaded"
+	OutcomeUploaded   = "uploaded"
+	OutcomeCreated    = "created"
+	OutcomeUpdated    = "updated"
+	OutcomeUnchanged  = "unchanged"
+	OutcomeSkipped    = "skipped"
+	OutcomeRemoved    = "removed"
+	OutcomeFailed     = "failed"
+)
+
+type Asset struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	Outcome string `json:"outcome"`
+}
+
+type Release struct {
+	Tag     string  `json:"tag"`
+	Outcome string  `json:"outcome,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Reference describes a Git reference that was changed. Previous is empty for references that were created, and Current is empty for references that were deleted.
+type Reference struct {
+	Name     string `json:"name"`
+	Previous string `json:"previous,omitempty"`
+	Current  string `json:"current,omitempty"`
+}
+
+// Document is the machine-readable result of a run of the sync tool.
+type Document struct {
+	notify.Summary
+	ReleaseOutcomes []Release   `json:"release_outcomes"`
+	References      []Reference `json:"references"`
+	Warnings        []string    `json:"warnings"`
+	SkippedSteps    []string    `json:"skipped_steps"`
+}
+
+// Recorder collects what happened during a run. A nil recorder records nothing, so that callers do not need to check whether a report was asked for.
+type Recorder struct {
+	lock         sync.Mutex
+	releases     []*Release
+	references   []Reference
+	warnings     []string
+	skippedSteps []string
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+type contextKey struct{}
+
+// WithRecorder returns a context which carries the recorder to the code doing the work.
+func WithRecorder(ctx context.Context, recorder *Recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, recorder)
+}
+
+// FromContext returns the recorder carried by the context, or nil if there is none.
+func FromContext(ctx context.Context) *Recorder {
+	recorder, _ := ctx.Value(contextKey{}).(*Recorder)
+	return recorder
+}
+
+func (recorder *Recorder) release(tag string) *Release {
+	for _, release := range recorder.releases {
+		if release.Tag == tag {
+			return release
+		}
+	}
+	release := &Release{Tag: tag, Assets: []Asset{}}
+	recorder.releases = append(recorder.releases, release)
+	return release
+}
+
+// RecordRelease records what happened to a release as a whole.
+func (recorder *Recorder) RecordRelease(tag string, outcome string) {
+	if recorder == nil {
+		return
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.release(tag).Outcome = outcome
+}
+
+// RecordFailure records that a release could not be synced, and why.
+func (recorder *Recorder) RecordFailure(tag string, message string) {
+	if recorder == nil {
+		return
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	release := recorder.release(tag)
+	release.Outcome = OutcomeFailed
+	release.Error = message
+}
+
+// RecordAsset records what happened to an asset of a release.
+func (recorder *Recorder) RecordAsset(tag string, name string, size int64, outcome string) {
+	if recorder == nil {
+		return
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	release := recorder.release(tag)
+	release.Assets = append(release.Assets, Asset{Name: name, Size: size, Outcome: outcome})
+}
+
+// RecordReferences records the differences between two sets of Git references, each mapping a reference name to its hash.
+func (recorder *Recorder) RecordReferences(previous map[string]string, current map[string]string) {
+	if recorder == nil {
+		return
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.references = append(recorder.references, Differences(previous, current)...)
+}
+
+// Differences lists the references that differ between two sets of Git references, each mapping a reference name to its hash, sorted by name.
+func Differences(previous map[string]string, current map[string]string) []Reference {
+	differences := []Reference{}
+	for name, hash := range current {
+		if previous[name] != hash {
+			differences = append(differences, Reference{Name: name, Previous: previous[name], Current: hash})
+		}
+	}
+	for name, hash := range previous {
+		if _, exists := current[name]; !exists {
+			differences = append(differences, Reference{Name: name, Previous: hash})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Name < differences[j].Name
+	})
+	return differences
+}
+
+// RecordSkippedStep records a step that was left out because the sync tool did not have the access needed for it, so that it can be done separately.
+func (recorder *Recorder) RecordSkippedStep(step string) {
+	if recorder == nil {
+		return
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.skippedSteps = append(recorder.skippedSteps, step)
+}
+
+// Levels makes the recorder a logrus hook that collects warnings.
+func (recorder *Recorder) Levels() []log.Level {
+	return []log.Level{log.WarnLevel}
+}
+
+func (recorder *Recorder) Fire(entry *log.Entry) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.warnings = append(recorder.warnings, entry.Message)
+	return nil
+}
+
+// Document combines what was recorded with the summary of the run.
+func (recorder *Recorder) Document(summary notify.Summary) Document {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	document := Document{
+		Summary:         summary,
+		ReleaseOutcomes: []Release{},
+		References:      append([]Reference{}, recorder.references...),
+		Warnings:        append([]string{}, recorder.warnings...),
+		SkippedSteps:    append([]string{}, recorder.skippedSteps...),
+	}
+	for _, release := range recorder.releases {
+		document.ReleaseOutcomes = append(document.ReleaseOutcomes, *release)
+	}
+	sort.Slice(document.References, func(i, j int) bool {
+		return document.References[i].Name < document.References[j].Name
+	})
+	return document
+}
+
+func (document Document) Write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(document)
+	if err != nil {
+		return errors.Wrap(err, "Error writing run summary.")
+	}
+	return nil
+}
+
+// ReferenceHashes maps the name of each reference under refs/ to the hash it points to, for use with RecordReferences.
+func ReferenceHashes(references storer.ReferenceIter) (map[string]string, error) {
+	result := map[string]string{}
+	err := references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference && strings.HasPrefix(reference.Name().String(), "refs/") {
+			result[reference.Name().String()] = reference.Hash().String()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading Git references.")
+	}
+	return result, nil
+}
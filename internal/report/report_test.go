@@ -0,0 +1,91 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilRecorder(t *testing.T) {
+	recorder := FromContext(context.Background())
+	require.Nil(t, recorder)
+	recorder.RecordRelease("codeql-bundle-20200101", OutcomeCreated)
+	recorder.RecordAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", 1, OutcomeUploaded)
+	recorder.RecordReferences(map[string]string{}, map[string]string{"refs/heads/main": "a"})
+	recorder.RecordFailure("codeql-bundle-20200101", "Error downloading asset.")
+}
+
+func TestRecordFailure(t *testing.T) {
+	recorder := NewRecorder()
+	recorder.RecordAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", 100, OutcomeUnchanged)
+	recorder.RecordFailure("codeql-bundle-20200101", "Error downloading asset.")
+	require.Equal(t, []Release{
+		{
+			Tag:     "codeql-bundle-20200101",
+			Outcome: OutcomeFailed,
+			Error:   "Error downloading asset.",
+			Assets:  []Asset{{Name: "codeql-bundle.tar.gz", Size: 100, Outcome: OutcomeUnchanged}},
+		},
+	}, recorder.Document(notify.Summary{Command: "pull"}).ReleaseOutcomes)
+}
+
+func TestDocument(t *testing.T) {
+	recorder := NewRecorder()
+	ctx := WithRecorder(context.Background(), recorder)
+	require.Equal(t, recorder, FromContext(ctx))
+
+	FromContext(ctx).RecordRelease("codeql-bundle-20200101", OutcomeCreated)
+	FromContext(ctx).RecordAsset("codeql-bundle-20200101", "codeql-bundle.tar.gz", 100, OutcomeUploaded)
+	FromContext(ctx).RecordAsset("codeql-bundle-20200101", "codeql-bundle-linux64.tar.gz", 50, OutcomeSkipped)
+	FromContext(ctx).RecordReferences(
+		map[string]string{"refs/heads/main": "a", "refs/heads/v1": "b", "refs/heads/removed": "c"},
+		map[string]string{"refs/heads/main": "d", "refs/heads/v1": "b", "refs/heads/added": "e"},
+	)
+	logger := log.New()
+	logger.AddHook(recorder)
+	logger.SetOutput(&bytes.Buffer{})
+	logger.Warn("Something looks wrong.")
+	logger.Info("Everything is fine.")
+
+	document := recorder.Document(notify.Summary{Command: "push", Success: true})
+	require.Equal(t, []Release{
+		{
+			Tag:     "codeql-bundle-20200101",
+			Outcome: OutcomeCreated,
+			Assets: []Asset{
+				{Name: "codeql-bundle.tar.gz", Size: 100, Outcome: OutcomeUploaded},
+				{Name: "codeql-bundle-linux64.tar.gz", Size: 50, Outcome: OutcomeSkipped},
+			},
+		},
+	}, document.ReleaseOutcomes)
+	require.Equal(t, []Reference{
+		{Name: "refs/heads/added", Current: "e"},
+		{Name: "refs/heads/main", Previous: "a", Current: "d"},
+		{Name: "refs/heads/removed", Previous: "c"},
+	}, document.References)
+	require.Equal(t, []string{"Something looks wrong."}, document.Warnings)
+
+	output := bytes.Buffer{}
+	require.NoError(t, document.Write(&output))
+	decoded := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(output.Bytes(), &decoded))
+	require.Equal(t, "push", decoded["command"])
+	require.Equal(t, true, decoded["success"])
+	require.Len(t, decoded["release_outcomes"], 1)
+	require.Len(t, decoded["references"], 3)
+}
+
+func TestReferenceHashes(t *testing.T) {
+	main := plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("1111111111111111111111111111111111111111"))
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")
+	hashes, err := ReferenceHashes(storer.NewReferenceSliceIter([]*plumbing.Reference{main, head}))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"refs/heads/main": "1111111111111111111111111111111111111111"}, hashes)
+}
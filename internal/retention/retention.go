@@ -0,0 +1,60 @@
+package retention
+
+import (
+	usererrors "errors"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// SinceLastSync is the value of `--since` that excludes releases which were already handled by the last successful run.
+const SinceLastSync = "last-sync"
+
+const errorNegativeKeepLast = "The `--keep-last` flag must not be negative."
+const errorInvalidSince = "The `--since` flag must be a date such as 2020-06-30, a time such as 2020-06-30T12:00:00Z, or `" + SinceLastSync + "`."
+
+// Policy limits which CodeQL bundle releases are pulled and pushed. The zero value handles every release.
+type Policy struct {
+	// KeepLast limits `main` and each major version of the CodeQL Action to this many of their most recent bundles, if it is more than zero.
+	KeepLast int
+	// Since excludes releases published before this time, if it is set.
+	Since time.Time
+	// LastSync excludes releases that were already handled by the last successful run and were published before it.
+	LastSync bool
+}
+
+// NewPolicy creates a policy from the values of the `--keep-last` and `--since` flags.
+func NewPolicy(keepLast int, since string) (Policy, error) {
+	if keepLast < 0 {
+		return Policy{}, usererrors.New(errorNegativeKeepLast)
+	}
+	policy := Policy{KeepLast: keepLast}
+	switch since {
+	case "":
+	case SinceLastSync:
+		policy.LastSync = true
+	default:
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			sinceTime, err = time.Parse("2006-01-02", since)
+		}
+		if err != nil {
+			return Policy{}, usererrors.New(errorInvalidSince)
+		}
+		policy.Since = sinceTime
+	}
+	return policy, nil
+}
+
+// Cutoff returns the time before which releases are excluded, given when the last successful run was, or the zero time if releases are not excluded by date.
+func (policy Policy) Cutoff(lastSync time.Time) time.Time {
+	if policy.LastSync {
+		return lastSync
+	}
+	return policy.Since
+}
+
+// Excludes reports whether a release is excluded because it was published before the cutoff. Releases with no known publication date are never excluded, as they cannot be told apart from new ones.
+func Excludes(cutoff time.Time, publishedAt *github.Timestamp) bool {
+	return !cutoff.IsZero() && publishedAt != nil && publishedAt.Before(cutoff)
+}
@@ -0,0 +1,47 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicy(t *testing.T) {
+	policy, err := NewPolicy(0, "")
+	require.NoError(t, err)
+	require.Equal(t, Policy{}, policy)
+
+	policy, err = NewPolicy(2, "2020-06-30")
+	require.NoError(t, err)
+	require.Equal(t, Policy{KeepLast: 2, Since: time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)}, policy)
+
+	policy, err = NewPolicy(0, "2020-06-30T12:00:00+02:00")
+	require.NoError(t, err)
+	require.True(t, policy.Since.Equal(time.Date(2020, 6, 30, 10, 0, 0, 0, time.UTC)))
+
+	policy, err = NewPolicy(0, SinceLastSync)
+	require.NoError(t, err)
+	require.Equal(t, Policy{LastSync: true}, policy)
+
+	_, err = NewPolicy(-1, "")
+	require.EqualError(t, err, errorNegativeKeepLast)
+	_, err = NewPolicy(0, "last week")
+	require.EqualError(t, err, errorInvalidSince)
+}
+
+func TestExcludes(t *testing.T) {
+	lastSync := time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)
+	older := &github.Timestamp{Time: lastSync.Add(-time.Hour)}
+	newer := &github.Timestamp{Time: lastSync.Add(time.Hour)}
+
+	require.False(t, Excludes(Policy{}.Cutoff(lastSync), older))
+	require.True(t, Excludes(Policy{LastSync: true}.Cutoff(lastSync), older))
+	require.False(t, Excludes(Policy{LastSync: true}.Cutoff(lastSync), newer))
+	// Without a previous run nothing is excluded.
+	require.False(t, Excludes(Policy{LastSync: true}.Cutoff(time.Time{}), older))
+	require.True(t, Excludes(Policy{Since: lastSync}.Cutoff(time.Time{}), older))
+	// Releases with no date are always handled.
+	require.False(t, Excludes(Policy{Since: lastSync}.Cutoff(time.Time{}), nil))
+}
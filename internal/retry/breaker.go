@@ -0,0 +1,79 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultBreakerThreshold = 5
+
+const errorBreakerOpen = "Not %s, as %s has failed %d requests in a row even after retrying them, so it is treated as down for the rest of the run. Run the command again once it is back."
+
+// Breaker stops requests to a destination that keeps failing, so that once it is down the rest of the run fails straight away rather than retrying every remaining request in turn. It opens after a number of requests in a row fail with a transient error even after being retried, and stays open for the rest of the run. Any request that gets a response, even an error, closes the count again. A nil breaker never opens.
+type Breaker struct {
+	lock      sync.Mutex
+	name      string
+	threshold int
+	failures  int
+	open      bool
+}
+
+// NewBreaker creates a breaker for the destination with the given name, which opens after threshold requests in a row fail. If threshold is less than 1 nil is returned, so the breaker never opens.
+func NewBreaker(name string, threshold int) *Breaker {
+	if threshold < 1 {
+		return nil
+	}
+	return &Breaker{name: name, threshold: threshold}
+}
+
+type breakerContextKey struct{}
+
+// WithBreaker returns a context whose requests are counted by the breaker, and fail straight away once it opens.
+func WithBreaker(ctx context.Context, breaker *Breaker) context.Context {
+	return context.WithValue(ctx, breakerContextKey{}, breaker)
+}
+
+func breakerFromContext(ctx context.Context) *Breaker {
+	breaker, _ := ctx.Value(breakerContextKey{}).(*Breaker)
+	return breaker
+}
+
+// Open reports whether the breaker has opened, so that the destination is being failed fast.
+func (breaker *Breaker) Open() bool {
+	if breaker == nil {
+		return false
+	}
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	return breaker.open
+}
+
+// check returns an error if the breaker is open, describing the request that is not being made.
+func (breaker *Breaker) check(description string) error {
+	if !breaker.Open() {
+		return nil
+	}
+	return exitcode.WithCode(fmt.Errorf(errorBreakerOpen, description, breaker.name, breaker.threshold), exitcode.Network)
+}
+
+// record counts the outcome of a request once it has been retried for as long as the policy allows.
+func (breaker *Breaker) record(err error) {
+	if breaker == nil {
+		return
+	}
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	if err == nil || !IsTransient(err) {
+		breaker.failures = 0
+		return
+	}
+	breaker.failures++
+	if breaker.failures >= breaker.threshold && !breaker.open {
+		breaker.open = true
+		log.Errorf("%s has failed %d requests in a row even after retrying them, so the rest of the requests to it will fail straight away.", breaker.name, breaker.failures)
+	}
+}
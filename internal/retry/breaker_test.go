@@ -0,0 +1,52 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	breaker := NewBreaker("https://ghes.example.com", 2)
+	ctx := WithBreaker(WithPolicy(context.Background(), Policy{Retries: 1, Delay: time.Millisecond}), breaker)
+	attempts := 0
+	failing := func(attempt int) error {
+		attempts++
+		return errorWithStatus(http.StatusBadGateway)
+	}
+	require.Error(t, Do(ctx, "testing", failing))
+	// A request that gets a response, even an error, shows the destination is up.
+	require.Error(t, Do(ctx, "testing", func(attempt int) error {
+		return errorWithStatus(http.StatusUnprocessableEntity)
+	}))
+	require.Error(t, Do(ctx, "testing", failing))
+	require.False(t, breaker.Open())
+	require.Error(t, Do(ctx, "testing", failing))
+	require.True(t, breaker.Open())
+	require.Equal(t, 6, attempts)
+
+	// Once open, requests fail without being made.
+	err := Do(ctx, "uploading release asset", failing)
+	require.EqualError(t, err, "Not uploading release asset, as https://ghes.example.com has failed 2 requests in a row even after retrying them, so it is treated as down for the rest of the run. Run the command again once it is back.")
+	require.Equal(t, exitcode.Network, exitcode.Code(err))
+	require.Equal(t, 6, attempts)
+	require.NoError(t, Do(WithBreaker(ctx, nil), "testing", func(attempt int) error {
+		return nil
+	}))
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	breaker := NewBreaker("https://ghes.example.com", 0)
+	require.Nil(t, breaker)
+	ctx := WithBreaker(WithPolicy(context.Background(), Policy{Retries: 0}), breaker)
+	for i := 0; i < 10; i++ {
+		require.Error(t, Do(ctx, "testing", func(attempt int) error {
+			return errorWithStatus(http.StatusBadGateway)
+		}))
+	}
+	require.False(t, breaker.Open())
+}
@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/google/go-github/v32/github"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultMaintenanceWindow = 30 * time.Minute
+
+// maxMaintenanceBodySize bounds how much of a response is read to check whether it is the maintenance page.
+const maxMaintenanceBodySize = 64 * 1024
+
+// isMaintenanceResponse reports whether the response is the one GitHub Enterprise Server gives while it is in maintenance mode: a 503 whose body mentions maintenance. The start of the body is read and put back, so the response can still be used.
+func isMaintenanceResponse(response *http.Response) bool {
+	if response == nil || response.StatusCode != http.StatusServiceUnavailable || response.Body == nil {
+		return false
+	}
+	start, err := ioutil.ReadAll(io.LimitReader(response.Body, maxMaintenanceBodySize))
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(start), response.Body), response.Body}
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(start)), "maintenance")
+}
+
+// IsMaintenance reports whether an error is a response from GitHub Enterprise Server saying that it is in maintenance mode.
+func IsMaintenance(err error) bool {
+	for current := err; current != nil; current = next(current) {
+		if errorResponse, ok := current.(*github.ErrorResponse); ok {
+			return isMaintenanceResponse(errorResponse.Response)
+		}
+	}
+	return false
+}
+
+// maintenanceWaiter waits with a backoff for GitHub Enterprise Server to come out of maintenance mode, for up to the maintenance window of the policy from the first time it is found in maintenance.
+type maintenanceWaiter struct {
+	window   time.Duration
+	delay    time.Duration
+	maxDelay time.Duration
+	deadline time.Time
+}
+
+func newMaintenanceWaiter(policy Policy) *maintenanceWaiter {
+	delay := policy.Delay
+	if delay <= 0 {
+		delay = DefaultDelay
+	}
+	return &maintenanceWaiter{window: policy.MaintenanceWindow, delay: delay, maxDelay: policy.maxDelay()}
+}
+
+// enabled reports whether maintenance mode is waited for at all. Without a window, maintenance responses are treated like any other transient error.
+func (waiter *maintenanceWaiter) enabled() bool {
+	return waiter.window > 0
+}
+
+// wait waits before the next attempt, or returns an error if the window has run out. The activity describes what was being done, for example "while uploading release asset".
+func (waiter *maintenanceWaiter) wait(ctx context.Context, activity string) error {
+	now := time.Now()
+	if waiter.deadline.IsZero() {
+		waiter.deadline = now.Add(waiter.window)
+	}
+	remaining := waiter.deadline.Sub(now)
+	if remaining <= 0 {
+		return exitcode.WithCode(fmt.Errorf("GitHub Enterprise Server was still in maintenance mode after waiting %s %s. Run the command again once maintenance is over, or wait for longer with `--maintenance-window`.", waiter.window, activity), exitcode.Network)
+	}
+	delay := waiter.delay
+	if delay > remaining {
+		delay = remaining
+	}
+	metrics.FromContext(ctx).RecordMaintenanceWait()
+	log.Warnf("GitHub Enterprise Server is in maintenance mode %s, waiting %s before trying again.", activity, delay)
+	err := sleep(ctx, delay)
+	if err != nil {
+		return err
+	}
+	waiter.delay *= 2
+	if waiter.delay > waiter.maxDelay {
+		waiter.delay = waiter.maxDelay
+	}
+	return nil
+}
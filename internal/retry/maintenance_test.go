@@ -0,0 +1,112 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+const maintenancePage = "<html><body>GitHub Enterprise Server is currently under maintenance.</body></html>"
+
+func maintenanceError() error {
+	response := &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(bytes.NewBufferString(maintenancePage))}
+	return errors.Wrap(&github.ErrorResponse{Response: response}, "Error making request.")
+}
+
+func maintenanceContext(window time.Duration) context.Context {
+	return WithPolicy(context.Background(), Policy{Retries: 1, Delay: time.Millisecond, MaintenanceWindow: window})
+}
+
+func TestIsMaintenance(t *testing.T) {
+	err := maintenanceError()
+	require.True(t, IsMaintenance(err))
+	// The body is put back after it is checked.
+	require.True(t, IsMaintenance(err))
+	require.False(t, IsMaintenance(errorWithStatus(http.StatusServiceUnavailable)))
+	require.False(t, IsMaintenance(errors.New("Not a response.")))
+	require.False(t, IsMaintenance(nil))
+}
+
+func TestDoWaitsForMaintenance(t *testing.T) {
+	attempts := 0
+	err := Do(maintenanceContext(time.Minute), "testing", func(attempt int) error {
+		attempts++
+		if attempt < 4 {
+			return maintenanceError()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, attempts)
+}
+
+func TestDoGivesUpAfterMaintenanceWindow(t *testing.T) {
+	err := Do(maintenanceContext(10*time.Millisecond), "testing", func(attempt int) error {
+		return maintenanceError()
+	})
+	require.EqualError(t, err, "GitHub Enterprise Server was still in maintenance mode after waiting 10ms while testing. Run the command again once maintenance is over, or wait for longer with `--maintenance-window`.")
+	require.Equal(t, exitcode.Network, exitcode.Code(err))
+}
+
+func TestDoWithoutMaintenanceWindow(t *testing.T) {
+	attempts := 0
+	err := Do(maintenanceContext(0), "testing", func(attempt int) error {
+		attempts++
+		return maintenanceError()
+	})
+	require.Error(t, err)
+	require.Equal(t, 2, attempts)
+}
+
+func TestTransportWaitsForMaintenance(t *testing.T) {
+	testServer, testURL := test.GetTestHTTPServer(t)
+	bodies := []string{}
+	testServer.HandleFunc("/maintenance", func(response http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			response.WriteHeader(http.StatusServiceUnavailable)
+			_, err = response.Write([]byte(maintenancePage))
+			require.NoError(t, err)
+			return
+		}
+		test.ServeHTTPResponseFromString(t, "OK", response)
+	})
+	policy := Policy{Delay: time.Millisecond, MaintenanceWindow: time.Minute}
+	client := &http.Client{Transport: &Transport{Policy: &policy}}
+	response, err := client.Post(testURL+"/maintenance", "text/plain", strings.NewReader("A request body."))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, []string{"A request body.", "A request body.", "A request body."}, bodies)
+}
+
+func TestTransportLeavesOtherUnavailableResponses(t *testing.T) {
+	testServer, testURL := test.GetTestHTTPServer(t)
+	requests := 0
+	testServer.HandleFunc("/unavailable", func(response http.ResponseWriter, request *http.Request) {
+		requests++
+		response.WriteHeader(http.StatusServiceUnavailable)
+		_, err := response.Write([]byte("No healthy upstream."))
+		require.NoError(t, err)
+	})
+	response, err := NewClient(nil).Get(testURL + "/unavailable")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, "No healthy upstream.", string(body))
+	require.Equal(t, 1, requests)
+}
@@ -0,0 +1,199 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/google/go-github/v32/github"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultRetries = 3
+const DefaultDelay = 2 * time.Second
+
+// DefaultMaxDelay bounds the delay between attempts, however many retries are allowed.
+const DefaultMaxDelay = time.Minute
+
+// transientStatusCodes are the responses which are likely to succeed if the request is made again, typically because a load balancer or proxy in front of GitHub Enterprise Server could not reach it.
+var transientStatusCodes = map[int]bool{
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// Policy describes how an operation that fails with a transient error is retried. The delay doubles after each attempt, up to MaxDelay.
+type Policy struct {
+	Retries  int
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// MaxTime bounds how long is spent retrying an operation, from when it first fails. No retry is started that would wait past it. If it is zero, only Retries limits the retries.
+	MaxTime time.Duration
+	// MaintenanceWindow is how long to wait for GitHub Enterprise Server to come out of maintenance mode before giving up, without using up any retries. If it is zero, maintenance mode is retried like any other transient error.
+	MaintenanceWindow time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{Retries: DefaultRetries, Delay: DefaultDelay, MaxDelay: DefaultMaxDelay, MaintenanceWindow: DefaultMaintenanceWindow}
+}
+
+// maxDelay returns the longest delay between attempts, which is DefaultMaxDelay for a policy that does not set one.
+func (policy Policy) maxDelay() time.Duration {
+	if policy.MaxDelay <= 0 {
+		return DefaultMaxDelay
+	}
+	return policy.MaxDelay
+}
+
+type contextKey struct{}
+
+// WithPolicy returns a context which carries the retry policy to the code making requests.
+func WithPolicy(ctx context.Context, policy Policy) context.Context {
+	return context.WithValue(ctx, contextKey{}, policy)
+}
+
+// FromContext returns the retry policy carried by the context, or the default policy if there is none.
+func FromContext(ctx context.Context) Policy {
+	policy, ok := ctx.Value(contextKey{}).(Policy)
+	if !ok {
+		return DefaultPolicy()
+	}
+	return policy
+}
+
+func hasPolicy(ctx context.Context) bool {
+	_, ok := ctx.Value(contextKey{}).(Policy)
+	return ok
+}
+
+// StatusError is returned for a response with an unexpected status code to a request that is not made through the GitHub API client, such as the download of a release asset, so that it can be retried if the status code is transient.
+type StatusError struct {
+	StatusCode int
+	// Activity describes what the request was for, for example "downloading asset".
+	Activity string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("Status code %d while %s.", err.StatusCode, err.Activity)
+}
+
+// next returns the error wrapped by err, supporting both github.com/pkg/errors and the standard library, as well as the errors go-git wraps responses in.
+func next(err error) error {
+	switch wrapper := err.(type) {
+	case *plumbing.UnexpectedError:
+		return wrapper.Err
+	case interface{ Cause() error }:
+		return wrapper.Cause()
+	case interface{ Unwrap() error }:
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
+// isTransientNetworkError reports whether an error is a connection that timed out, was reset or was closed part of the way through a response, as happens when a proxy or load balancer drops a connection.
+func isTransientNetworkError(err error) bool {
+	if err == io.ErrUnexpectedEOF || err == syscall.ECONNRESET || err == syscall.ECONNABORTED || err == syscall.EPIPE {
+		return true
+	}
+	networkError, ok := err.(net.Error)
+	return ok && networkError.Timeout()
+}
+
+// IsTransient reports whether an error is worth retrying: a response from GitHub, a download or a Git operation with a status code that is likely to succeed if the request is made again, or a dropped connection.
+func IsTransient(err error) bool {
+	for current := err; current != nil; current = next(current) {
+		switch response := current.(type) {
+		case *github.ErrorResponse:
+			return response.Response != nil && transientStatusCodes[response.Response.StatusCode]
+		case *StatusError:
+			return transientStatusCodes[response.StatusCode]
+		case *githttp.Err:
+			return response.Response != nil && transientStatusCodes[response.StatusCode()]
+		}
+		if isTransientNetworkError(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do runs the operation, running it again after a delay if it fails with a transient error, until it succeeds, fails with another error or runs out of retries. If GitHub says a secondary rate limit has been exceeded, Do waits for as long as it asks without using up a retry, and if GitHub Enterprise Server is in maintenance mode Do waits for up to the maintenance window for it to finish. The attempt number, starting from 1, is passed to the operation. If the context carries a breaker that has opened, the operation is not run at all.
+func Do(ctx context.Context, description string, operation func(attempt int) error) error {
+	breaker := breakerFromContext(ctx)
+	err := breaker.check(description)
+	if err != nil {
+		return err
+	}
+	err = do(ctx, description, operation)
+	if ctx.Err() == nil {
+		breaker.record(err)
+	}
+	return err
+}
+
+func do(ctx context.Context, description string, operation func(attempt int) error) error {
+	policy := FromContext(ctx)
+	delay := policy.Delay
+	if delay > policy.maxDelay() {
+		delay = policy.maxDelay()
+	}
+	retries := 0
+	waits := 0
+	var firstFailure time.Time
+	maintenance := newMaintenanceWaiter(policy)
+	for attempt := 1; ; attempt++ {
+		err := operation(attempt)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if firstFailure.IsZero() {
+			firstFailure = time.Now()
+		}
+		if maintenance.enabled() && IsMaintenance(err) {
+			err = maintenance.wait(ctx, "while "+description)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if rateLimitDelay, limited := SecondaryRateLimitDelay(err); limited && waits < maxSecondaryRateLimitWaits {
+			waits++
+			metrics.FromContext(ctx).RecordRateLimitWait()
+			log.Warnf("Secondary rate limit exceeded while %s, waiting %s before continuing.", description, rateLimitDelay)
+			err = sleep(ctx, rateLimitDelay)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !IsTransient(err) || retries >= policy.Retries {
+			return err
+		}
+		if policy.MaxTime > 0 && time.Since(firstFailure)+delay > policy.MaxTime {
+			log.Warnf("Transient error while %s, but not retrying as it has been retried for as long as `--retry-max-time` allows.", description)
+			return err
+		}
+		retries++
+		metrics.FromContext(ctx).RecordRetry()
+		log.Warnf("Transient error while %s, retrying in %s (%d/%d): %s", description, delay, retries, policy.Retries, err)
+		err = sleep(ctx, delay)
+		if err != nil {
+			return err
+		}
+		delay *= 2
+		if delay > policy.maxDelay() {
+			delay = policy.maxDelay()
+		}
+	}
+}
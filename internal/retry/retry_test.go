@@ -0,0 +1,121 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/github/codeql-action-sync/internal/notify"
+	"github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func errorWithStatus(statusCode int) error {
+	return errors.Wrap(&github.ErrorResponse{Response: &http.Response{StatusCode: statusCode}}, "Error making request.")
+}
+
+func testContext() context.Context {
+	return WithPolicy(context.Background(), Policy{Retries: 2, Delay: time.Millisecond})
+}
+
+func TestIsTransient(t *testing.T) {
+	require.True(t, IsTransient(errorWithStatus(http.StatusBadGateway)))
+	require.True(t, IsTransient(errorWithStatus(http.StatusServiceUnavailable)))
+	require.False(t, IsTransient(errorWithStatus(http.StatusNotFound)))
+	require.False(t, IsTransient(errors.New("Not a response.")))
+	require.False(t, IsTransient(nil))
+}
+
+func TestIsTransientOutsideTheAPI(t *testing.T) {
+	require.True(t, IsTransient(errors.Wrap(&StatusError{StatusCode: http.StatusBadGateway, Activity: "downloading asset"}, "Error downloading asset.")))
+	require.False(t, IsTransient(&StatusError{StatusCode: http.StatusForbidden, Activity: "downloading asset"}))
+	require.True(t, IsTransient(errors.Wrap(plumbing.NewUnexpectedError(&githttp.Err{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}), "Error doing Git fetch.")))
+	require.False(t, IsTransient(plumbing.NewUnexpectedError(&githttp.Err{Response: &http.Response{StatusCode: http.StatusTeapot}})))
+	require.True(t, IsTransient(errors.Wrap(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, "Error downloading asset.")))
+	require.True(t, IsTransient(errors.Wrap(io.ErrUnexpectedEOF, "Error downloading asset.")))
+	require.False(t, IsTransient(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}))
+}
+
+func TestDoCapsDelay(t *testing.T) {
+	start := time.Now()
+	ctx := WithPolicy(context.Background(), Policy{Retries: 3, Delay: time.Hour, MaxDelay: time.Millisecond})
+	err := Do(ctx, "testing", func(attempt int) error {
+		return errorWithStatus(http.StatusBadGateway)
+	})
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(time.Minute))
+}
+
+func TestDoGivesUpAfterMaxTime(t *testing.T) {
+	attempts := 0
+	ctx := WithPolicy(context.Background(), Policy{Retries: 10, Delay: 20 * time.Millisecond, MaxTime: 50 * time.Millisecond})
+	err := Do(ctx, "testing", func(attempt int) error {
+		attempts++
+		return errorWithStatus(http.StatusBadGateway)
+	})
+	require.Error(t, err)
+	// The retries after 20ms and 40ms more would take it past 50ms.
+	require.Equal(t, 2, attempts)
+}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	attempts := []int{}
+	err := Do(testContext(), "testing", func(attempt int) error {
+		attempts = append(attempts, attempt)
+		if attempt == 1 {
+			return errorWithStatus(http.StatusBadGateway)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, attempts)
+}
+
+func TestDoRecordsRetries(t *testing.T) {
+	collector := metrics.NewCollector()
+	ctx := metrics.WithCollector(testContext(), collector)
+	err := Do(ctx, "testing", func(attempt int) error {
+		return errorWithStatus(http.StatusGatewayTimeout)
+	})
+	require.Error(t, err)
+	require.Equal(t, int64(2), collector.Document(notify.Summary{}).Retries)
+}
+
+func TestDoDoesNotRetryOtherErrors(t *testing.T) {
+	attempts := 0
+	err := Do(testContext(), "testing", func(attempt int) error {
+		attempts++
+		return errorWithStatus(http.StatusUnprocessableEntity)
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestDoGivesUpAfterRetries(t *testing.T) {
+	attempts := 0
+	err := Do(testContext(), "testing", func(attempt int) error {
+		attempts++
+		return errorWithStatus(http.StatusGatewayTimeout)
+	})
+	require.Error(t, err)
+	require.True(t, IsTransient(err))
+	require.Equal(t, 3, attempts)
+}
+
+func TestDoWithoutRetries(t *testing.T) {
+	attempts := 0
+	err := Do(WithPolicy(context.Background(), Policy{Retries: 0}), "testing", func(attempt int) error {
+		attempts++
+		return errorWithStatus(http.StatusBadGateway)
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
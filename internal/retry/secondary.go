@@ -0,0 +1,128 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/metrics"
+	"github.com/google/go-github/v32/github"
+	log "github.com/sirupsen/logrus"
+)
+
+const retryAfterHeader = "Retry-After"
+
+// maxSecondaryRateLimitWaits bounds how many times a single operation waits for a secondary rate limit, so that a server which never lets it through cannot stall a run forever.
+const maxSecondaryRateLimitWaits = 10
+
+// secondaryRateLimitDelay reports whether the response says that a secondary rate limit (previously known as abuse detection) has been exceeded, and if so how long to wait before making the request again.
+func secondaryRateLimitDelay(response *http.Response) (time.Duration, bool) {
+	if response == nil || (response.StatusCode != http.StatusForbidden && response.StatusCode != http.StatusTooManyRequests) {
+		return 0, false
+	}
+	retryAfter := response.Header.Get(retryAfterHeader)
+	if retryAfter == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(retryAfter)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// SecondaryRateLimitDelay reports whether an error is a response from GitHub saying that a secondary rate limit has been exceeded, and if so how long to wait before trying again.
+func SecondaryRateLimitDelay(err error) (time.Duration, bool) {
+	for current := err; current != nil; current = next(current) {
+		switch errorResponse := current.(type) {
+		case *github.AbuseRateLimitError:
+			if errorResponse.RetryAfter != nil {
+				return *errorResponse.RetryAfter, true
+			}
+			return secondaryRateLimitDelay(errorResponse.Response)
+		case *github.ErrorResponse:
+			return secondaryRateLimitDelay(errorResponse.Response)
+		}
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Transport is an HTTP transport which, when GitHub responds that a secondary rate limit has been exceeded, waits for as long as the response asks and then makes the request again. It also waits for GitHub Enterprise Server to come out of maintenance mode. Requests with a body that cannot be read again, such as asset uploads, are left for Do to retry.
+type Transport struct {
+	Base http.RoundTripper
+	// Policy is used for requests whose context does not carry a retry policy, such as those made by go-git to list references. If it is nil, the default policy is used.
+	Policy *Policy
+}
+
+// NewTransport creates a transport which waits out secondary rate limits. If base is nil the default transport is used.
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base}
+}
+
+func (transport *Transport) policy(ctx context.Context) Policy {
+	if transport.Policy != nil && !hasPolicy(ctx) {
+		return *transport.Policy
+	}
+	return FromContext(ctx)
+}
+
+func (transport *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx := request.Context()
+	maintenance := newMaintenanceWaiter(transport.policy(ctx))
+	replayable := request.Body == nil || request.GetBody != nil
+	for waits := 0; ; {
+		response, err := base.RoundTrip(request)
+		if err != nil {
+			return nil, err
+		}
+		if replayable && maintenance.enabled() && isMaintenanceResponse(response) {
+			response.Body.Close()
+			err = maintenance.wait(ctx, "for "+request.Method+" "+request.URL.Path)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			delay, limited := secondaryRateLimitDelay(response)
+			if !limited || waits >= maxSecondaryRateLimitWaits || !replayable {
+				return response, nil
+			}
+			waits++
+			response.Body.Close()
+			metrics.FromContext(ctx).RecordRateLimitWait()
+			log.Warnf("Secondary rate limit exceeded for %s %s, waiting %s before continuing.", request.Method, request.URL.Path, delay)
+			err = sleep(ctx, delay)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request = request.Clone(ctx)
+			request.Body = body
+		}
+	}
+}
+
+// NewClient creates an HTTP client which waits out secondary rate limits, making requests through the given transport.
+func NewClient(base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: NewTransport(base)}
+}
@@ -0,0 +1,85 @@
+package retry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func secondaryRateLimitError(retryAfter string) error {
+	response := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	response.Header.Set(retryAfterHeader, retryAfter)
+	return errors.Wrap(&github.ErrorResponse{Response: response}, "Error making request.")
+}
+
+func TestSecondaryRateLimitDelay(t *testing.T) {
+	delay, limited := SecondaryRateLimitDelay(secondaryRateLimitError("30"))
+	require.True(t, limited)
+	require.Equal(t, 30*time.Second, delay)
+	retryAfter := 10 * time.Second
+	delay, limited = SecondaryRateLimitDelay(&github.AbuseRateLimitError{RetryAfter: &retryAfter})
+	require.True(t, limited)
+	require.Equal(t, 10*time.Second, delay)
+	_, limited = SecondaryRateLimitDelay(errorWithStatus(http.StatusForbidden))
+	require.False(t, limited)
+	_, limited = SecondaryRateLimitDelay(secondaryRateLimitError("soon"))
+	require.False(t, limited)
+}
+
+func TestDoWaitsForSecondaryRateLimit(t *testing.T) {
+	attempts := 0
+	err := Do(WithPolicy(context.Background(), Policy{Retries: 0}), "testing", func(attempt int) error {
+		attempts++
+		if attempt < 3 {
+			return secondaryRateLimitError("0")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+}
+
+func TestTransportWaitsForSecondaryRateLimit(t *testing.T) {
+	testServer, testURL := test.GetTestHTTPServer(t)
+	bodies := []string{}
+	testServer.HandleFunc("/limited", func(response http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			response.Header().Set(retryAfterHeader, "0")
+			response.WriteHeader(http.StatusForbidden)
+			return
+		}
+		test.ServeHTTPResponseFromString(t, "OK", response)
+	})
+	response, err := NewClient(nil).Post(testURL+"/limited", "text/plain", strings.NewReader("A request body."))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, []string{"A request body.", "A request body."}, bodies)
+}
+
+func TestTransportLeavesUnrepeatableRequestsToDo(t *testing.T) {
+	testServer, testURL := test.GetTestHTTPServer(t)
+	requests := 0
+	testServer.HandleFunc("/limited", func(response http.ResponseWriter, request *http.Request) {
+		requests++
+		response.Header().Set(retryAfterHeader, "0")
+		response.WriteHeader(http.StatusForbidden)
+	})
+	// A reader which is not a well-known type cannot be read again, so the request cannot be made again by the transport.
+	response, err := NewClient(nil).Post(testURL+"/limited", "text/plain", ioutil.NopCloser(strings.NewReader("An upload.")))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	require.Equal(t, 1, requests)
+}
@@ -0,0 +1,208 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/pkg/errors"
+)
+
+const bomFormat = "CycloneDX"
+const specVersion = "1.4"
+
+// Format names the format bills of materials are generated in, so that a change of format can be noticed.
+const Format = bomFormat + " " + specVersion
+
+const typeApplication = "application"
+const typeLibrary = "library"
+
+const packFileName = "qlpack.yml"
+const extractorFileName = "codeql-extractor.yml"
+
+// The field names are set by the CycloneDX specification.
+
+type Hash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type Component struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	Hashes      []Hash `json:"hashes,omitempty"`
+}
+
+type Tool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Metadata struct {
+	Tools     []Tool    `json:"tools"`
+	Component Component `json:"component"`
+}
+
+// BOM is a CycloneDX software bill of materials describing the contents of a CodeQL bundle.
+type BOM struct {
+	BOMFormat   string      `json:"bomFormat"`
+	SpecVersion string      `json:"specVersion"`
+	Version     int         `json:"version"`
+	Metadata    Metadata    `json:"metadata"`
+	Components  []Component `json:"components"`
+}
+
+// IsBundle reports whether a release asset is a CodeQL bundle that a bill of materials can be generated for.
+func IsBundle(assetName string) bool {
+	return strings.HasPrefix(assetName, "codeql-bundle") && strings.HasSuffix(assetName, ".tar.gz")
+}
+
+// AssetName returns the name of the release asset the bill of materials of a bundle is attached as.
+func AssetName(bundleName string) string {
+	return strings.TrimSuffix(bundleName, ".tar.gz") + ".cdx.json"
+}
+
+// yamlFields reads the top-level scalar fields of a pack or extractor definition. These files are simple enough that a full YAML parser is not needed for the few fields used here.
+func yamlFields(reader io.Reader) (map[string]string, error) {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' {
+			continue
+		}
+		separator := strings.Index(line, ":")
+		if separator == -1 {
+			continue
+		}
+		value := strings.TrimSpace(line[separator+1:])
+		if comment := strings.Index(value, " #"); comment != -1 {
+			value = strings.TrimSpace(value[:comment])
+		}
+		fields[strings.TrimSpace(line[:separator])] = strings.Trim(value, "\"'")
+	}
+	return fields, scanner.Err()
+}
+
+func fileHash(reader io.Reader) ([]Hash, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, reader)
+	if err != nil {
+		return nil, err
+	}
+	return []Hash{{Algorithm: "SHA-256", Content: hex.EncodeToString(hash.Sum(nil))}}, nil
+}
+
+// component returns the component described by a file in the bundle, or nil if the file does not describe one. The CLI is found by its launcher at the top of the bundle, extractors by their definition in a directory at the top of the bundle and packs by their definition anywhere under `qlpacks`.
+func component(name string, reader io.Reader) (*Component, error) {
+	parts := strings.Split(strings.TrimPrefix(path.Clean(name), "./"), "/")
+	if len(parts) < 2 || parts[0] != "codeql" {
+		return nil, nil
+	}
+	fileName := parts[len(parts)-1]
+	switch {
+	case len(parts) == 2 && (fileName == "codeql" || fileName == "codeql.exe"):
+		hashes, err := fileHash(reader)
+		if err != nil {
+			return nil, err
+		}
+		return &Component{Type: typeApplication, Name: "codeql", Description: "CodeQL CLI", Hashes: hashes}, nil
+	case len(parts) == 3 && fileName == extractorFileName:
+		fields, err := yamlFields(reader)
+		if err != nil {
+			return nil, err
+		}
+		extractorName := fields["name"]
+		if extractorName == "" {
+			extractorName = parts[1]
+		}
+		return &Component{Type: typeApplication, Name: extractorName, Version: fields["version"], Description: "CodeQL extractor"}, nil
+	case len(parts) > 3 && parts[1] == "qlpacks" && fileName == packFileName:
+		fields, err := yamlFields(reader)
+		if err != nil {
+			return nil, err
+		}
+		if fields["name"] == "" {
+			return nil, nil
+		}
+		return &Component{Type: typeLibrary, Name: fields["name"], Version: fields["version"], Description: "CodeQL pack"}, nil
+	}
+	return nil, nil
+}
+
+// Generate reads a CodeQL bundle and returns a bill of materials listing the CLI, extractors and packs it contains. Components are sorted and nothing random or time-dependent is included, so the same bundle always gives the same bill of materials for a version of the sync tool.
+func Generate(bundleName string, releaseTag string, digest string, reader io.Reader) ([]byte, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading bundle %s.", bundleName)
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	components := []Component{}
+	seen := map[string]bool{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading bundle %s.", bundleName)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		found, err := component(header.Name, tarReader)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading %s from bundle %s.", header.Name, bundleName)
+		}
+		if found == nil {
+			continue
+		}
+		key := found.Type + " " + found.Name + " " + found.Version
+		if !seen[key] {
+			seen[key] = true
+			components = append(components, *found)
+		}
+	}
+	sort.Slice(components, func(i, j int) bool {
+		if components[i].Type != components[j].Type {
+			return components[i].Type < components[j].Type
+		}
+		if components[i].Name != components[j].Name {
+			return components[i].Name < components[j].Name
+		}
+		return components[i].Version < components[j].Version
+	})
+	bom := BOM{
+		BOMFormat:   bomFormat,
+		SpecVersion: specVersion,
+		Version:     1,
+		Metadata: Metadata{
+			Tools: []Tool{{Vendor: "GitHub", Name: "codeql-action-sync", Version: version.Version()}},
+			Component: Component{
+				Type:        typeApplication,
+				Name:        bundleName,
+				Version:     releaseTag,
+				Description: "CodeQL bundle",
+				Hashes:      []Hash{{Algorithm: "SHA-256", Content: digest}},
+			},
+		},
+		Components: components,
+	}
+	bomJSON, err := json.MarshalIndent(bom, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error converting bill of materials to JSON.")
+	}
+	return append(bomJSON, '\n'), nil
+}
@@ -0,0 +1,76 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func getTestBundle(t *testing.T, files map[string]string) []byte {
+	buffer := bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "codeql/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tarWriter.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	return buffer.Bytes()
+}
+
+func TestGenerate(t *testing.T) {
+	bundle := getTestBundle(t, map[string]string{
+		"codeql/codeql":                                        "#!/bin/sh\n",
+		"codeql/javascript/codeql-extractor.yml":               "name: \"javascript\"\nversion: 1.22.1\ncolumn_kind: \"utf16\"\nfile_types:\n  - name: javascript\n",
+		"codeql/javascript/tools/autobuild.sh":                 "#!/bin/sh\n",
+		"codeql/qlpacks/codeql-javascript/qlpack.yml":          "name: codeql-javascript # The standard queries.\nversion: 0.0.1\nlibraryPathDependencies: codeql-javascript-lib\n",
+		"codeql/qlpacks/codeql-javascript/upgrades/qlpack.yml": "version: 0.0.1\n",
+		"codeql/qlpacks/codeql-go/qlpack.yml":                  "name: 'codeql-go'\n",
+		"codeql/README.md":                                     "Not a component.",
+	})
+	bomJSON, err := Generate("codeql-bundle.tar.gz", "codeql-bundle-20200630", "a-checksum", bytes.NewReader(bundle))
+	require.NoError(t, err)
+	bom := BOM{}
+	require.NoError(t, json.Unmarshal(bomJSON, &bom))
+	require.Equal(t, "CycloneDX", bom.BOMFormat)
+	require.Equal(t, "1.4", bom.SpecVersion)
+	require.Equal(t, Component{
+		Type:        "application",
+		Name:        "codeql-bundle.tar.gz",
+		Version:     "codeql-bundle-20200630",
+		Description: "CodeQL bundle",
+		Hashes:      []Hash{{Algorithm: "SHA-256", Content: "a-checksum"}},
+	}, bom.Metadata.Component)
+	require.Equal(t, []Component{
+		{Type: "application", Name: "codeql", Description: "CodeQL CLI", Hashes: []Hash{{Algorithm: "SHA-256", Content: "a8076d3d28d21e02012b20eaf7dbf75409a6277134439025f282e368e3305abf"}}},
+		{Type: "application", Name: "javascript", Version: "1.22.1", Description: "CodeQL extractor"},
+		{Type: "library", Name: "codeql-go", Description: "CodeQL pack"},
+		{Type: "library", Name: "codeql-javascript", Version: "0.0.1", Description: "CodeQL pack"},
+	}, bom.Components)
+
+	// The same bundle always gives the same bill of materials.
+	otherBOMJSON, err := Generate("codeql-bundle.tar.gz", "codeql-bundle-20200630", "a-checksum", bytes.NewReader(bundle))
+	require.NoError(t, err)
+	require.Equal(t, bomJSON, otherBOMJSON)
+}
+
+func TestGenerateNotABundle(t *testing.T) {
+	_, err := Generate("codeql-bundle.tar.gz", "codeql-bundle-20200630", "a-checksum", strings.NewReader("This isn't really a CodeQL bundle!"))
+	require.Error(t, err)
+}
+
+func TestIsBundle(t *testing.T) {
+	require.True(t, IsBundle("codeql-bundle.tar.gz"))
+	require.True(t, IsBundle("codeql-bundle-linux64.tar.gz"))
+	require.False(t, IsBundle("codeql-runner-linux"))
+	require.False(t, IsBundle(AssetName("codeql-bundle-linux64.tar.gz")))
+	require.Equal(t, "codeql-bundle-linux64.cdx.json", AssetName("codeql-bundle-linux64.tar.gz"))
+}
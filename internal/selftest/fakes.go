@@ -0,0 +1,534 @@
+package selftest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/google/go-github/v32/github"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const sourceRepository = "github/codeql-action"
+const destinationOwner = "selftest"
+const destinationRepository = destinationOwner + "/codeql-action"
+const enterpriseServerVersion = "3.11.0"
+
+const uploadPackService = "git-upload-pack"
+const receivePackService = "git-receive-pack"
+
+// fakeGitHub serves just enough of the GitHub REST API and the Git smart HTTP protocol for the sync tool to pull from it as if it were GitHub.com and push to it as if it were GitHub Enterprise Server.
+type fakeGitHub struct {
+	lock                      sync.Mutex
+	url                       string
+	sourceRepositoryPath      string
+	destinationRepositoryPath string
+	bundleName                string
+	bundleContent             []byte
+	bundleVersion             string
+	destinationCreated        bool
+	destinationTopics         []string
+	destinationHomepage       string
+	nextID                    int64
+	releases                  map[string]*github.RepositoryRelease
+	latestRelease             string
+	assets                    map[int64][]*github.ReleaseAsset
+	uploads                   map[string][]byte
+	repositoryLookups         int
+}
+
+func newFakeGitHub(sourceRepositoryPath string, destinationRepositoryPath string, bundleVersion string, bundleName string, bundleContent []byte) *fakeGitHub {
+	return &fakeGitHub{
+		sourceRepositoryPath:      sourceRepositoryPath,
+		destinationRepositoryPath: destinationRepositoryPath,
+		bundleVersion:             bundleVersion,
+		bundleName:                bundleName,
+		bundleContent:             bundleContent,
+		nextID:                    100,
+		releases:                  map[string]*github.RepositoryRelease{},
+		assets:                    map[int64][]*github.ReleaseAsset{},
+		uploads:                   map[string][]byte{},
+	}
+}
+
+func (fake *fakeGitHub) router() *mux.Router {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/git/{repository}.git/info/refs", fake.serveGitReferences).Methods(http.MethodGet)
+	router.HandleFunc("/git/{repository}.git/"+uploadPackService, fake.serveGitUploadPack).Methods(http.MethodPost)
+	router.HandleFunc("/git/{repository}.git/"+receivePackService, fake.serveGitReceivePack).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/v3/repos/"+sourceRepository+"/releases/tags/{tag}", fake.serveSourceRelease).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/repos/"+sourceRepository+"/releases/latest", func(response http.ResponseWriter, request *http.Request) {
+		serveJSON(response, http.StatusOK, fake.sourceRelease())
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/repos/"+sourceRepository+"/releases/assets/1", func(response http.ResponseWriter, request *http.Request) {
+		http.Redirect(response, request, fake.url+"/downloads/"+fake.bundleName, http.StatusFound)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/downloads/{name}", fake.serveDownload).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/v3/user", func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("X-OAuth-Scopes", "public_repo, workflow")
+		serveJSON(response, http.StatusOK, github.User{Login: github.String(destinationOwner)})
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/meta", func(response http.ResponseWriter, request *http.Request) {
+		serveJSON(response, http.StatusOK, map[string]string{"installed_version": enterpriseServerVersion})
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/user/repos", fake.createDestinationRepository).Methods(http.MethodPost)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository, fake.serveDestinationRepository).Methods(http.MethodGet, http.MethodPatch)
+	router.HandleFunc("/api/v3/repositories/{id:[0-9]+}", fake.serveDestinationRepositoryByID).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/topics", fake.replaceDestinationTopics).Methods(http.MethodPut)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/releases/tags/{tag}", fake.serveDestinationRelease).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/releases/latest", fake.serveDestinationLatestRelease).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/releases", fake.createDestinationRelease).Methods(http.MethodPost)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/releases/{id:[0-9]+}", fake.editDestinationRelease).Methods(http.MethodPatch)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/releases/{id:[0-9]+}/assets", fake.serveDestinationAssets).Methods(http.MethodGet)
+	router.HandleFunc("/api/uploads/repos/"+destinationRepository+"/releases/{id:[0-9]+}/assets", fake.uploadDestinationAsset).Methods(http.MethodPost)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/contents/{path:.+}", fake.serveDestinationContents).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/repos/"+destinationRepository+"/git/refs", fake.serveDestinationReferences).Methods(http.MethodGet)
+
+	router.NotFoundHandler = http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		serveJSON(response, http.StatusNotFound, github.ErrorResponse{Message: fmt.Sprintf("The self-test server does not support %s %s.", request.Method, request.URL.Path)})
+	})
+	return router
+}
+
+func serveJSON(response http.ResponseWriter, statusCode int, object interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(statusCode)
+	json.NewEncoder(response).Encode(object)
+}
+
+func serveNotFound(response http.ResponseWriter) {
+	serveJSON(response, http.StatusNotFound, github.ErrorResponse{Message: "Not Found"})
+}
+
+func serveError(response http.ResponseWriter, err error) {
+	serveJSON(response, http.StatusInternalServerError, github.ErrorResponse{Message: err.Error()})
+}
+
+func (fake *fakeGitHub) sourceRelease() *github.RepositoryRelease {
+	return &github.RepositoryRelease{
+		ID:      github.Int64(1),
+		TagName: github.String(fake.bundleVersion),
+		Name:    github.String(fake.bundleVersion),
+		Assets: []*github.ReleaseAsset{
+			{
+				ID:   github.Int64(1),
+				Name: github.String(fake.bundleName),
+				Size: github.Int(len(fake.bundleContent)),
+			},
+		},
+	}
+}
+
+func (fake *fakeGitHub) serveSourceRelease(response http.ResponseWriter, request *http.Request) {
+	if mux.Vars(request)["tag"] != fake.bundleVersion {
+		serveNotFound(response)
+		return
+	}
+	serveJSON(response, http.StatusOK, fake.sourceRelease())
+}
+
+func (fake *fakeGitHub) serveDownload(response http.ResponseWriter, request *http.Request) {
+	if mux.Vars(request)["name"] != fake.bundleName {
+		serveNotFound(response)
+		return
+	}
+	// Serving the content this way supports range requests, so resuming interrupted downloads is exercised too.
+	http.ServeContent(response, request, fake.bundleName, time.Time{}, bytes.NewReader(fake.bundleContent))
+}
+
+func (fake *fakeGitHub) destinationRepository() *github.Repository {
+	return &github.Repository{
+		ID:       github.Int64(1),
+		Name:     github.String("codeql-action"),
+		FullName: github.String(destinationRepository),
+		CloneURL: github.String(fake.url + "/git/destination.git"),
+		Homepage: github.String(fake.destinationHomepage),
+	}
+}
+
+func (fake *fakeGitHub) createDestinationRepository(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	properties := github.Repository{}
+	err := json.NewDecoder(request.Body).Decode(&properties)
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	fake.destinationCreated = true
+	fake.destinationHomepage = properties.GetHomepage()
+	repository := fake.destinationRepository()
+	serveJSON(response, http.StatusCreated, repository)
+}
+
+func (fake *fakeGitHub) serveDestinationRepository(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if !fake.destinationCreated {
+		serveNotFound(response)
+		return
+	}
+	serveJSON(response, http.StatusOK, fake.destinationRepository())
+}
+
+// serveDestinationRepositoryByID serves the lookup a push makes to follow a destination repository it has pushed to before, in case it has been renamed.
+func (fake *fakeGitHub) serveDestinationRepositoryByID(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.repositoryLookups++
+	if !fake.destinationCreated || mux.Vars(request)["id"] != strconv.FormatInt(fake.destinationRepository().GetID(), 10) {
+		serveNotFound(response)
+		return
+	}
+	serveJSON(response, http.StatusOK, fake.destinationRepository())
+}
+
+func (fake *fakeGitHub) replaceDestinationTopics(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	topics := struct {
+		Names []string `json:"names"`
+	}{}
+	err := json.NewDecoder(request.Body).Decode(&topics)
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	fake.destinationTopics = topics.Names
+	serveJSON(response, http.StatusOK, topics)
+}
+
+func (fake *fakeGitHub) serveDestinationRelease(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	release, exists := fake.releases[mux.Vars(request)["tag"]]
+	if !exists {
+		serveNotFound(response)
+		return
+	}
+	serveJSON(response, http.StatusOK, release)
+}
+
+func (fake *fakeGitHub) createDestinationRelease(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	release := github.RepositoryRelease{}
+	err := json.NewDecoder(request.Body).Decode(&release)
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	fake.nextID++
+	release.ID = github.Int64(fake.nextID)
+	release.Assets = nil
+	fake.releases[release.GetTagName()] = &release
+	// As on GitHub, a newly created release becomes the latest.
+	fake.latestRelease = release.GetTagName()
+	serveJSON(response, http.StatusCreated, release)
+}
+
+func (fake *fakeGitHub) serveDestinationLatestRelease(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	release, exists := fake.releases[fake.latestRelease]
+	if !exists {
+		serveNotFound(response)
+		return
+	}
+	serveJSON(response, http.StatusOK, release)
+}
+
+func (fake *fakeGitHub) findDestinationRelease(request *http.Request) *github.RepositoryRelease {
+	id, _ := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+	for _, release := range fake.releases {
+		if release.GetID() == id {
+			return release
+		}
+	}
+	return nil
+}
+
+func (fake *fakeGitHub) editDestinationRelease(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	release := fake.findDestinationRelease(request)
+	if release == nil {
+		serveNotFound(response)
+		return
+	}
+	edit := struct {
+		MakeLatest string `json:"make_latest"`
+	}{}
+	err := json.NewDecoder(request.Body).Decode(&edit)
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	if edit.MakeLatest == "true" {
+		fake.latestRelease = release.GetTagName()
+	}
+	serveJSON(response, http.StatusOK, release)
+}
+
+func (fake *fakeGitHub) serveDestinationAssets(response http.ResponseWriter, request *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	release := fake.findDestinationRelease(request)
+	if release == nil {
+		serveNotFound(response)
+		return
+	}
+	page := request.URL.Query().Get("page")
+	if page != "" && page != "1" {
+		serveJSON(response, http.StatusOK, []*github.ReleaseAsset{})
+		return
+	}
+	assets := fake.assets[release.GetID()]
+	if assets == nil {
+		assets = []*github.ReleaseAsset{}
+	}
+	serveJSON(response, http.StatusOK, assets)
+}
+
+func (fake *fakeGitHub) uploadDestinationAsset(response http.ResponseWriter, request *http.Request) {
+	content, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	release := fake.findDestinationRelease(request)
+	if release == nil {
+		serveNotFound(response)
+		return
+	}
+	name := request.URL.Query().Get("name")
+	fake.nextID++
+	asset := &github.ReleaseAsset{
+		ID:   github.Int64(fake.nextID),
+		Name: github.String(name),
+		Size: github.Int(len(content)),
+	}
+	fake.assets[release.GetID()] = append(fake.assets[release.GetID()], asset)
+	fake.uploads[release.GetTagName()+"/"+name] = content
+	serveJSON(response, http.StatusCreated, asset)
+}
+
+func (fake *fakeGitHub) uploadedAsset(release string, name string) ([]byte, bool) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	content, exists := fake.uploads[release+"/"+name]
+	return content, exists
+}
+
+func (fake *fakeGitHub) serveDestinationContents(response http.ResponseWriter, request *http.Request) {
+	path := mux.Vars(request)["path"]
+	reference := request.URL.Query().Get("ref")
+	repository, err := git.PlainOpen(fake.destinationRepositoryPath)
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	var commit *object.Commit
+	for _, name := range []plumbing.ReferenceName{plumbing.NewTagReferenceName(reference), plumbing.NewBranchReferenceName(reference)} {
+		resolved, err := repository.Reference(name, true)
+		if err != nil {
+			continue
+		}
+		commit, err = repository.CommitObject(resolved.Hash())
+		if err == nil {
+			break
+		}
+	}
+	if commit == nil {
+		serveNotFound(response)
+		return
+	}
+	file, err := commit.File(path)
+	if err == object.ErrFileNotFound {
+		serveNotFound(response)
+		return
+	}
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	content, err := file.Contents()
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	serveJSON(response, http.StatusOK, github.RepositoryContent{
+		Type:     github.String("file"),
+		Name:     github.String(file.Name),
+		Path:     github.String(path),
+		SHA:      github.String(file.Hash.String()),
+		Encoding: github.String("base64"),
+		Content:  github.String(base64.StdEncoding.EncodeToString([]byte(content))),
+	})
+}
+
+func (fake *fakeGitHub) serveDestinationReferences(response http.ResponseWriter, request *http.Request) {
+	repository, err := git.PlainOpen(fake.destinationRepositoryPath)
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	references, err := repository.References()
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	result := []*github.Reference{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference {
+			result = append(result, &github.Reference{
+				Ref:    github.String(reference.Name().String()),
+				Object: &github.GitObject{SHA: github.String(reference.Hash().String())},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		serveError(response, err)
+		return
+	}
+	serveJSON(response, http.StatusOK, result)
+}
+
+// repositoryLoader makes the go-git server operate on a single repository on disk, whatever endpoint is requested.
+type repositoryLoader string
+
+func (loader repositoryLoader) Load(endpoint *transport.Endpoint) (storer.Storer, error) {
+	repository, err := git.PlainOpen(string(loader))
+	if err != nil {
+		return nil, err
+	}
+	return repository.Storer, nil
+}
+
+func (fake *fakeGitHub) gitTransport(request *http.Request) (transport.Transport, *transport.Endpoint, error) {
+	var repositoryPath string
+	switch mux.Vars(request)["repository"] {
+	case "source":
+		repositoryPath = fake.sourceRepositoryPath
+	case "destination":
+		repositoryPath = fake.destinationRepositoryPath
+	default:
+		return nil, nil, transport.ErrRepositoryNotFound
+	}
+	endpoint, err := transport.NewEndpoint(fake.url + request.URL.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return server.NewServer(repositoryLoader(repositoryPath)), endpoint, nil
+}
+
+func (fake *fakeGitHub) serveGitReferences(response http.ResponseWriter, request *http.Request) {
+	service := request.URL.Query().Get("service")
+	gitTransport, endpoint, err := fake.gitTransport(request)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusNotFound)
+		return
+	}
+	var session transport.Session
+	switch service {
+	case uploadPackService:
+		session, err = gitTransport.NewUploadPackSession(endpoint, nil)
+	case receivePackService:
+		session, err = gitTransport.NewReceivePackSession(endpoint, nil)
+	default:
+		http.Error(response, "Only the smart HTTP protocol is supported.", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.Close()
+	advertisedReferences, err := session.AdvertisedReferences()
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	advertisedReferences.Prefix = [][]byte{[]byte("# service=" + service), pktline.Flush}
+	response.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+	response.Header().Set("Cache-Control", "no-cache")
+	advertisedReferences.Encode(response)
+}
+
+func (fake *fakeGitHub) serveGitUploadPack(response http.ResponseWriter, request *http.Request) {
+	gitTransport, endpoint, err := fake.gitTransport(request)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusNotFound)
+		return
+	}
+	session, err := gitTransport.NewUploadPackSession(endpoint, nil)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.Close()
+	uploadPackRequest := packp.NewUploadPackRequest()
+	err = uploadPackRequest.Decode(request.Body)
+	if err != nil {
+		http.Error(response, errors.Wrap(err, "Error decoding upload-pack request.").Error(), http.StatusBadRequest)
+		return
+	}
+	uploadPackResponse, err := session.UploadPack(request.Context(), uploadPackRequest)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer uploadPackResponse.Close()
+	response.Header().Set("Content-Type", "application/x-"+uploadPackService+"-result")
+	uploadPackResponse.Encode(response)
+}
+
+func (fake *fakeGitHub) serveGitReceivePack(response http.ResponseWriter, request *http.Request) {
+	gitTransport, endpoint, err := fake.gitTransport(request)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusNotFound)
+		return
+	}
+	session, err := gitTransport.NewReceivePackSession(endpoint, nil)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.Close()
+	referenceUpdateRequest := packp.NewReferenceUpdateRequest()
+	err = referenceUpdateRequest.Decode(request.Body)
+	if err != nil {
+		http.Error(response, errors.Wrap(err, "Error decoding receive-pack request.").Error(), http.StatusBadRequest)
+		return
+	}
+	reportStatus, err := session.ReceivePack(request.Context(), referenceUpdateRequest)
+	response.Header().Set("Content-Type", "application/x-"+receivePackService+"-result")
+	if reportStatus != nil {
+		reportStatus.Encode(response)
+		return
+	}
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,297 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/assetselection"
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/concurrency"
+	"github.com/github/codeql-action-sync/internal/pull"
+	"github.com/github/codeql-action-sync/internal/push"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+const errorSelfTestFailed = "The self-test failed. See the output above for the step that failed."
+
+const bundleVersion = "codeql-bundle-selftest"
+const bundleName = "codeql-bundle.tar.gz"
+
+// The bundle is large enough that downloads are checkpointed and uploads take more than one write.
+var bundleContent = bytes.Repeat([]byte("CodeQL Action sync tool self-test bundle.\n"), 150000)
+
+var fixtureFiles = map[string]string{
+	"src/defaults.json": "{\"bundleVersion\": \"" + bundleVersion + "\"}\n",
+	"init/action.yml":   "name: 'CodeQL: Init'\nruns:\n  using: 'node12'\n  main: '../lib/init-action.js'\n",
+}
+
+var fixtureReferences = []plumbing.ReferenceName{
+	plumbing.NewBranchReferenceName("main"),
+	plumbing.NewBranchReferenceName("v1"),
+	plumbing.NewTagReferenceName(bundleVersion),
+}
+
+type selfTest struct {
+	ctx                       context.Context
+	writer                    io.Writer
+	sourceRepositoryPath      string
+	destinationRepositoryPath string
+	pulledCachePath           string
+	importedCachePath         string
+	fake                      *fakeGitHub
+	server                    *httptest.Server
+}
+
+func (selfTest *selfTest) step(description string, run func() error) bool {
+	fmt.Fprintf(selfTest.writer, "Running: %s...\n", description)
+	err := run()
+	if err != nil {
+		fmt.Fprintf(selfTest.writer, "[FAIL] %s: %+v\n", description, err)
+		return false
+	}
+	fmt.Fprintf(selfTest.writer, "[PASS] %s\n", description)
+	return true
+}
+
+func (selfTest *selfTest) createFixtures() error {
+	repository, err := git.PlainInit(selfTest.sourceRepositoryPath, false)
+	if err != nil {
+		return errors.Wrap(err, "Error initializing fixture source repository.")
+	}
+	err = repository.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	if err != nil {
+		return errors.Wrap(err, "Error setting default branch of fixture source repository.")
+	}
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return errors.Wrap(err, "Error opening fixture source worktree.")
+	}
+	for path, content := range fixtureFiles {
+		absolutePath := filepath.Join(selfTest.sourceRepositoryPath, filepath.FromSlash(path))
+		err := os.MkdirAll(filepath.Dir(absolutePath), 0755)
+		if err != nil {
+			return errors.Wrap(err, "Error creating fixture directory.")
+		}
+		err = ioutil.WriteFile(absolutePath, []byte(content), 0644)
+		if err != nil {
+			return errors.Wrap(err, "Error writing fixture file.")
+		}
+		_, err = worktree.Add(path)
+		if err != nil {
+			return errors.Wrap(err, "Error staging fixture file.")
+		}
+	}
+	commit, err := worktree.Commit("Self-test fixture.", &git.CommitOptions{
+		Author: &object.Signature{Name: "CodeQL Action Sync Tool", Email: "selftest@localhost", When: time.Now()},
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error committing fixture files.")
+	}
+	for _, reference := range fixtureReferences {
+		err := repository.Storer.SetReference(plumbing.NewHashReference(reference, commit))
+		if err != nil {
+			return errors.Wrapf(err, "Error creating fixture reference %s.", reference)
+		}
+	}
+
+	_, err = git.PlainInit(selfTest.destinationRepositoryPath, true)
+	if err != nil {
+		return errors.Wrap(err, "Error initializing fixture destination repository.")
+	}
+	return nil
+}
+
+func (selfTest *selfTest) startServer() error {
+	selfTest.fake = newFakeGitHub(selfTest.sourceRepositoryPath, selfTest.destinationRepositoryPath, bundleVersion, bundleName, bundleContent)
+	selfTest.server = httptest.NewServer(selfTest.fake.router())
+	selfTest.fake.url = selfTest.server.URL
+	return nil
+}
+
+func (selfTest *selfTest) pull() error {
+	source := pull.Source{
+		Owner:      "github",
+		Repository: "codeql-action",
+		GitURL:     selfTest.server.URL + "/git/source.git",
+		APIURL:     selfTest.server.URL + "/api/v3",
+	}
+	cacheDirectory := cachedirectory.NewCacheDirectory(selfTest.pulledCachePath)
+	return pull.Pull(selfTest.ctx, cacheDirectory, pull.Options{
+		Source:          source,
+		LanguageMapping: assetselection.DefaultMapping(),
+		Languages:       []string{},
+		Limits:          concurrency.Limits{Downloads: 1, Uploads: 1, APIRequests: 1},
+	})
+}
+
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func copyFile(sourcePath string, destinationPath string, mode os.FileMode) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.OpenFile(destinationPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
+
+// transferCache copies the cache as a user would when moving it to a machine that can access GitHub Enterprise Server, then checks nothing was changed on the way.
+func (selfTest *selfTest) transferCache() error {
+	return filepath.Walk(selfTest.pulledCachePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "Error reading pulled cache.")
+		}
+		relativePath, err := filepath.Rel(selfTest.pulledCachePath, path)
+		if err != nil {
+			return errors.Wrap(err, "Error finding path within pulled cache.")
+		}
+		destinationPath := filepath.Join(selfTest.importedCachePath, relativePath)
+		if info.IsDir() {
+			return errors.Wrap(os.MkdirAll(destinationPath, info.Mode().Perm()|0700), "Error creating directory in imported cache.")
+		}
+		err = copyFile(path, destinationPath, info.Mode().Perm())
+		if err != nil {
+			return errors.Wrapf(err, "Error copying %s to imported cache.", relativePath)
+		}
+		expectedHash, err := hashFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "Error hashing %s in pulled cache.", relativePath)
+		}
+		actualHash, err := hashFile(destinationPath)
+		if err != nil {
+			return errors.Wrapf(err, "Error hashing %s in imported cache.", relativePath)
+		}
+		if !bytes.Equal(expectedHash, actualHash) {
+			return errors.Errorf("The file %s was modified while being copied. This may be caused by anti-virus software or a faulty filesystem.", relativePath)
+		}
+		return nil
+	})
+}
+
+func (selfTest *selfTest) push() error {
+	cacheDirectory := cachedirectory.NewCacheDirectory(selfTest.importedCachePath)
+	return push.Push(selfTest.ctx, cacheDirectory, push.Options{
+		DestinationURL:        selfTest.server.URL,
+		DestinationToken:      "selftest-token",
+		DestinationRepository: destinationRepository,
+		ActionsAdminUser:      "actions-admin",
+		LanguageMapping:       assetselection.DefaultMapping(),
+		Languages:             []string{},
+		SafePush:              true,
+		PruneRefs:             true,
+		ProtectedRefs:         []string{},
+		Verify:                true,
+		Strict:                true,
+		Limits:                concurrency.Limits{Downloads: 1, Uploads: 1, APIRequests: 1},
+	})
+}
+
+func (selfTest *selfTest) checkDestination() error {
+	sourceRepository, err := git.PlainOpen(selfTest.sourceRepositoryPath)
+	if err != nil {
+		return errors.Wrap(err, "Error opening fixture source repository.")
+	}
+	destinationRepository, err := git.PlainOpen(selfTest.destinationRepositoryPath)
+	if err != nil {
+		return errors.Wrap(err, "Error opening fixture destination repository.")
+	}
+	for _, name := range fixtureReferences {
+		expected, err := sourceRepository.Reference(name, false)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading source reference %s.", name)
+		}
+		actual, err := destinationRepository.Reference(name, false)
+		if err != nil {
+			return errors.Wrapf(err, "The reference %s was not pushed to the destination.", name)
+		}
+		if actual.Hash() != expected.Hash() {
+			return errors.Errorf("The reference %s was pushed as %s but %s was expected.", name, actual.Hash(), expected.Hash())
+		}
+	}
+	uploaded, exists := selfTest.fake.uploadedAsset(bundleVersion, bundleName)
+	if !exists {
+		return errors.Errorf("The asset %s was not uploaded to the destination.", bundleName)
+	}
+	if !bytes.Equal(uploaded, bundleContent) {
+		return errors.Errorf("The asset %s was corrupted in transit: %d bytes were uploaded but %d were expected.", bundleName, len(uploaded), len(bundleContent))
+	}
+	return nil
+}
+
+func newSelfTest(ctx context.Context, temporaryDirectory string, writer io.Writer) selfTest {
+	return selfTest{
+		ctx:                       ctx,
+		writer:                    writer,
+		sourceRepositoryPath:      filepath.Join(temporaryDirectory, "source"),
+		destinationRepositoryPath: filepath.Join(temporaryDirectory, "destination"),
+		pulledCachePath:           filepath.Join(temporaryDirectory, "pulled-cache"),
+		importedCachePath:         filepath.Join(temporaryDirectory, "imported-cache"),
+	}
+}
+
+// Run exercises the whole pull, transfer and push process against a fake GitHub.com and GitHub Enterprise Server running locally, writing the result of each step to the writer. Files are created within the given working directory, or the system temporary directory if it is empty, and removed afterwards.
+func Run(ctx context.Context, workDirectory string, writer io.Writer) error {
+	if workDirectory != "" {
+		err := os.MkdirAll(workDirectory, 0755)
+		if err != nil {
+			return errors.Wrap(err, "Error creating self-test working directory.")
+		}
+	}
+	temporaryDirectory, err := ioutil.TempDir(workDirectory, "codeql-action-sync-selftest")
+	if err != nil {
+		return errors.Wrap(err, "Error creating self-test working directory.")
+	}
+	defer os.RemoveAll(temporaryDirectory)
+
+	selfTest := newSelfTest(ctx, temporaryDirectory, writer)
+	defer func() {
+		if selfTest.server != nil {
+			selfTest.server.Close()
+		}
+	}()
+
+	passed := selfTest.step("Create fixture repositories in "+temporaryDirectory, selfTest.createFixtures) &&
+		selfTest.step("Start local fake GitHub.com and GitHub Enterprise Server", selfTest.startServer) &&
+		selfTest.step("Pull the CodeQL Action and bundle", selfTest.pull) &&
+		selfTest.step("Copy the cache to simulate transferring it", selfTest.transferCache) &&
+		selfTest.step("Push the CodeQL Action and bundle", selfTest.push) &&
+		selfTest.step("Check the pushed repository and release assets", selfTest.checkDestination)
+	if !passed {
+		return usererrors.New(errorSelfTestFailed)
+	}
+	fmt.Fprintln(writer, "Self-test passed.")
+	return nil
+}
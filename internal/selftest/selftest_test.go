@@ -0,0 +1,43 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/push"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	output := bytes.Buffer{}
+	err := Run(context.Background(), temporaryDirectory, &output)
+	require.NoError(t, err, output.String())
+	require.Contains(t, output.String(), "[PASS] Check the pushed repository and release assets")
+	require.Contains(t, output.String(), "Self-test passed.")
+}
+
+func TestPushRecordsDestination(t *testing.T) {
+	selfTest := newSelfTest(context.Background(), test.CreateTemporaryDirectory(t), &bytes.Buffer{})
+	require.NoError(t, selfTest.createFixtures())
+	require.NoError(t, selfTest.startServer())
+	defer selfTest.server.Close()
+	require.NoError(t, selfTest.pull())
+	require.NoError(t, selfTest.transferCache())
+	require.NoError(t, selfTest.push())
+
+	// The destination is recorded under the URL and repository the push was given, so that the next push can find it again.
+	cacheDirectory := cachedirectory.NewCacheDirectory(selfTest.importedCachePath)
+	releases, err := push.PushedReleases(cacheDirectory, selfTest.server.URL, destinationRepository)
+	require.NoError(t, err)
+	require.Equal(t, []string{bundleVersion}, releases)
+	require.Equal(t, 0, selfTest.fake.repositoryLookups)
+
+	// The next push looks the repository up by ID, in case it has been renamed or transferred since.
+	require.NoError(t, selfTest.push())
+	require.Equal(t, 1, selfTest.fake.repositoryLookups)
+	require.NoError(t, selfTest.checkDestination())
+}
@@ -0,0 +1,50 @@
+package status
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/bytesize"
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+)
+
+func formatPartialAsset(partialAsset cachedirectory.PartialAssetStatus) string {
+	percentage := 0.0
+	if partialAsset.Size > 0 {
+		percentage = float64(partialAsset.Downloaded) / float64(partialAsset.Size) * 100
+	}
+	line := fmt.Sprintf("%s: %.1f%% (%s of %s)", partialAsset.Name, percentage, bytesize.Format(partialAsset.Downloaded), bytesize.Format(partialAsset.Size))
+	if eta := partialAsset.ETA(); eta > 0 {
+		line += fmt.Sprintf(", %s/s, ETA %s", bytesize.Format(int64(partialAsset.BytesPerSecond)), eta.Round(time.Second))
+	}
+	if !partialAsset.UpdatedAt.IsZero() {
+		line += fmt.Sprintf(", last progress %s", partialAsset.UpdatedAt.Local().Format(time.RFC1123))
+	}
+	return line
+}
+
+// Status prints a summary of the state of the cache, including the progress of any interrupted downloads.
+func Status(cacheDirectory cachedirectory.CacheDirectory, writer io.Writer) error {
+	fmt.Fprintf(writer, "Cache: %s\n", cacheDirectory.String())
+	err := cacheDirectory.CheckLock()
+	if err != nil {
+		fmt.Fprintln(writer, "State: incomplete (a `pull` is in progress or was interrupted)")
+	} else {
+		fmt.Fprintln(writer, "State: ready to push")
+	}
+
+	partialAssets, err := cacheDirectory.ListPartialAssets()
+	if err != nil {
+		return err
+	}
+	if len(partialAssets) == 0 {
+		fmt.Fprintln(writer, "No downloads in progress.")
+		return nil
+	}
+	fmt.Fprintln(writer, "Downloads in progress:")
+	for _, partialAsset := range partialAssets {
+		fmt.Fprintf(writer, "  %s/%s\n", partialAsset.Release, formatPartialAsset(partialAsset))
+	}
+	return nil
+}
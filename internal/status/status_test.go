@@ -0,0 +1,41 @@
+package status
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatPartialAsset(t *testing.T) {
+	line := formatPartialAsset(cachedirectory.PartialAssetStatus{
+		Name:           "codeql-bundle.tar.gz",
+		Size:           4096,
+		Downloaded:     1024,
+		BytesPerSecond: 1024,
+	})
+	require.Equal(t, "codeql-bundle.tar.gz: 25.0% (1.0 KiB of 4.0 KiB), 1.0 KiB/s, ETA 3s", line)
+}
+
+func TestStatus(t *testing.T) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	cacheDirectory := cachedirectory.NewCacheDirectory(path.Join(temporaryDirectory, "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, "1.0.0"))
+	require.NoError(t, cacheDirectory.Lock())
+	partialAsset, err := cacheDirectory.OpenPartialAsset("a-release", "bundle.tar.gz", 100*1024*1024)
+	require.NoError(t, err)
+	_, err = partialAsset.Write(bytes.Repeat([]byte("x"), 5*1024*1024))
+	require.NoError(t, err)
+	require.NoError(t, partialAsset.Close())
+
+	var output bytes.Buffer
+	require.NoError(t, Status(cacheDirectory, &output))
+	lines := strings.Split(output.String(), "\n")
+	require.Contains(t, lines[1], "incomplete")
+	require.Equal(t, "Downloads in progress:", lines[2])
+	require.True(t, strings.HasPrefix(lines[3], "  a-release/bundle.tar.gz: 4.0% (4.0 MiB of 100.0 MiB)"), lines[3])
+}
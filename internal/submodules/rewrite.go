@@ -0,0 +1,305 @@
+package submodules
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	formatconfig "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+// rewriter rewrites the history of a repository so that its submodules point at their mirrors. The same history is always rewritten to the same commits, so that pulling again does not change what has already been pushed. Signatures of rewritten commits and tags are dropped, as they would no longer be valid.
+type rewriter struct {
+	gitRepository *git.Repository
+	baseURL       string
+	// urls maps each submodule URL to rewrite, without any `.git` suffix, to the URL it is rewritten to.
+	urls map[string]string
+	// gitlinks maps commits of submodules to the commits they were rewritten to in the mirrors.
+	gitlinks map[plumbing.Hash]plumbing.Hash
+	// objects maps each commit and annotated tag that has been visited to its rewritten hash.
+	objects map[plumbing.Hash]plumbing.Hash
+	// verified is the rewritten objects from an earlier pull that have been checked to still exist, as they are removed if the cache is repacked.
+	verified  map[plumbing.Hash]bool
+	rootTrees map[plumbing.Hash]plumbing.Hash
+	trees     map[plumbing.Hash]plumbing.Hash
+	modules   map[plumbing.Hash]plumbing.Hash
+}
+
+func newRewriter(gitRepository *git.Repository, baseURL string, urls map[string]string, gitlinks map[plumbing.Hash]plumbing.Hash, previous map[string]string) *rewriter {
+	objects := map[plumbing.Hash]plumbing.Hash{}
+	for from, to := range previous {
+		objects[plumbing.NewHash(from)] = plumbing.NewHash(to)
+	}
+	return &rewriter{
+		gitRepository: gitRepository,
+		baseURL:       baseURL,
+		urls:          urls,
+		gitlinks:      gitlinks,
+		objects:       objects,
+		verified:      map[plumbing.Hash]bool{},
+		rootTrees:     map[plumbing.Hash]plumbing.Hash{},
+		trees:         map[plumbing.Hash]plumbing.Hash{},
+		modules:       map[plumbing.Hash]plumbing.Hash{},
+	}
+}
+
+// changed returns the objects that were rewritten to different hashes.
+func (rewriter *rewriter) changed() map[plumbing.Hash]plumbing.Hash {
+	changed := map[plumbing.Hash]plumbing.Hash{}
+	for from, to := range rewriter.objects {
+		if from != to {
+			changed[from] = to
+		}
+	}
+	return changed
+}
+
+// rewritten returns what an object has already been rewritten to, if it has.
+func (rewriter *rewriter) rewritten(hash plumbing.Hash) (plumbing.Hash, bool) {
+	to, ok := rewriter.objects[hash]
+	if !ok || to == hash || rewriter.verified[to] {
+		return to, ok
+	}
+	if rewriter.gitRepository.Storer.HasEncodedObject(to) != nil {
+		delete(rewriter.objects, hash)
+		return plumbing.ZeroHash, false
+	}
+	rewriter.verified[to] = true
+	return to, true
+}
+
+func (rewriter *rewriter) store(encodable interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	encoded := rewriter.gitRepository.Storer.NewEncodedObject()
+	err := encodable.Encode(encoded)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return rewriter.gitRepository.Storer.SetEncodedObject(encoded)
+}
+
+// rewriteModules rewrites the URLs in a `.gitmodules` file. A file that cannot be read is left as it is, as Git would not be able to use it either.
+func (rewriter *rewriter) rewriteModules(hash plumbing.Hash) (plumbing.Hash, error) {
+	if to, ok := rewriter.modules[hash]; ok {
+		return to, nil
+	}
+	rewriter.modules[hash] = hash
+	blob, err := rewriter.gitRepository.BlobObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	modules := formatconfig.New()
+	if formatconfig.NewDecoder(bytes.NewReader(content)).Decode(modules) != nil {
+		return hash, nil
+	}
+	changed := false
+	for _, subsection := range modules.Section("submodule").Subsections {
+		submoduleURL := subsection.Option("url")
+		if to, ok := rewriter.urls[normalize(resolve(rewriter.baseURL, submoduleURL))]; ok && to != submoduleURL {
+			subsection.SetOption("url", to)
+			changed = true
+		}
+	}
+	if !changed {
+		return hash, nil
+	}
+	rewrittenContent := bytes.Buffer{}
+	err = formatconfig.NewEncoder(&rewrittenContent).Encode(modules)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	encoded := rewriter.gitRepository.Storer.NewEncodedObject()
+	encoded.SetType(plumbing.BlobObject)
+	writer, err := encoded.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	_, err = writer.Write(rewrittenContent.Bytes())
+	writer.Close()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	to, err := rewriter.gitRepository.Storer.SetEncodedObject(encoded)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	rewriter.modules[hash] = to
+	return to, nil
+}
+
+// rewriteTree rewrites the `.gitmodules` file at the root of a commit, and the commits submodules point at anywhere in it if any of the submodules were rewritten themselves.
+func (rewriter *rewriter) rewriteTree(hash plumbing.Hash, root bool) (plumbing.Hash, error) {
+	memo := rewriter.trees
+	if root {
+		memo = rewriter.rootTrees
+	}
+	if to, ok := memo[hash]; ok {
+		return to, nil
+	}
+	tree, err := rewriter.gitRepository.TreeObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	entries := make([]object.TreeEntry, len(tree.Entries))
+	changed := false
+	for index, entry := range tree.Entries {
+		switch {
+		case root && entry.Name == modulesFile && (entry.Mode == filemode.Regular || entry.Mode == filemode.Executable):
+			entry.Hash, err = rewriter.rewriteModules(entry.Hash)
+		case entry.Mode == filemode.Submodule:
+			if to, ok := rewriter.gitlinks[entry.Hash]; ok {
+				entry.Hash = to
+			}
+		case entry.Mode == filemode.Dir && len(rewriter.gitlinks) != 0:
+			entry.Hash, err = rewriter.rewriteTree(entry.Hash, false)
+		}
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		changed = changed || entry.Hash != tree.Entries[index].Hash
+		entries[index] = entry
+	}
+	to := hash
+	if changed {
+		to, err = rewriter.store(&object.Tree{Entries: entries})
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	memo[hash] = to
+	return to, nil
+}
+
+// rewriteCommit rewrites a commit and its history. The history is walked without recursion, as it can be much deeper than the stack.
+func (rewriter *rewriter) rewriteCommit(hash plumbing.Hash) (plumbing.Hash, error) {
+	stack := []plumbing.Hash{hash}
+	for len(stack) != 0 {
+		current := stack[len(stack)-1]
+		if _, ok := rewriter.rewritten(current); ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		commit, err := rewriter.gitRepository.CommitObject(current)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		parents := make([]plumbing.Hash, len(commit.ParentHashes))
+		pending := false
+		for index, parent := range commit.ParentHashes {
+			to, ok := rewriter.rewritten(parent)
+			if !ok {
+				stack = append(stack, parent)
+				pending = true
+			}
+			parents[index] = to
+		}
+		if pending {
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		tree, err := rewriter.rewriteTree(commit.TreeHash, true)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		changed := tree != commit.TreeHash
+		for index, parent := range parents {
+			changed = changed || parent != commit.ParentHashes[index]
+		}
+		to := current
+		if changed {
+			commit.TreeHash = tree
+			commit.ParentHashes = parents
+			commit.PGPSignature = ""
+			to, err = rewriter.store(commit)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+		rewriter.objects[current] = to
+	}
+	to, _ := rewriter.rewritten(hash)
+	return to, nil
+}
+
+// rewriteObject rewrites what a reference points to. Only commits and annotated tags are rewritten.
+func (rewriter *rewriter) rewriteObject(hash plumbing.Hash) (plumbing.Hash, error) {
+	if to, ok := rewriter.rewritten(hash); ok {
+		return to, nil
+	}
+	encoded, err := rewriter.gitRepository.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	switch encoded.Type() {
+	case plumbing.CommitObject:
+		return rewriter.rewriteCommit(hash)
+	case plumbing.TagObject:
+		tag, err := object.DecodeTag(rewriter.gitRepository.Storer, encoded)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		target, err := rewriter.rewriteObject(tag.Target)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		to := hash
+		if target != tag.Target {
+			tag.Target = target
+			tag.PGPSignature = ""
+			to, err = rewriter.store(tag)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+		rewriter.objects[hash] = to
+		return to, nil
+	}
+	return hash, nil
+}
+
+// rewriteReferences points every branch and tag of the repository at its rewritten history.
+func (rewriter *rewriter) rewriteReferences() error {
+	references, err := rewriter.gitRepository.References()
+	if err != nil {
+		return errors.Wrap(err, "Error reading Git references.")
+	}
+	updated := []*plumbing.Reference{}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() != plumbing.HashReference || !strings.HasPrefix(reference.Name().String(), "refs/") {
+			return nil
+		}
+		to, err := rewriter.rewriteObject(reference.Hash())
+		if err != nil {
+			return errors.Wrapf(err, "Error rewriting %s.", reference.Name())
+		}
+		if to != reference.Hash() {
+			updated = append(updated, plumbing.NewHashReference(reference.Name(), to))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, reference := range updated {
+		err = rewriter.gitRepository.Storer.SetReference(reference)
+		if err != nil {
+			return errors.Wrapf(err, "Error updating %s.", reference.Name())
+		}
+	}
+	return nil
+}
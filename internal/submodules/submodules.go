@@ -0,0 +1,477 @@
+package submodules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	formatconfig "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// modulesFile is where Git records the URLs of submodules, at the root of the repository.
+const modulesFile = ".gitmodules"
+
+// mirrorRefSpecs are the references fetched into mirrors and pushed from them.
+var mirrorRefSpecs = []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// MirrorRefSpecs returns the refspecs to push a mirror to the destination with.
+func MirrorRefSpecs() []config.RefSpec {
+	return append([]config.RefSpec{}, mirrorRefSpecs...)
+}
+
+var unsafeNameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Repository is a submodule mirrored into the cache.
+type Repository struct {
+	// Name is the name of the repository the submodule is mirrored to on the destination, which has the same owner as the CodeQL Action.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Depth is 1 for submodules of the Git repository in the cache, 2 for their submodules, and so on.
+	Depth int `json:"depth"`
+}
+
+// Rewrite is how the history of a repository was rewritten so that its submodules point at their mirrors.
+type Rewrite struct {
+	// URLs maps each submodule URL that is rewritten, without any `.git` suffix, to the URL it is rewritten to.
+	URLs map[string]string `json:"urls"`
+	// Objects maps each commit and annotated tag that has been visited to its rewritten hash, which is the same if it did not need rewriting, so that the next pull can undo the rewriting and only visit new commits.
+	Objects map[string]string `json:"objects"`
+}
+
+// Record is what a pull stores about the submodules it mirrored.
+type Record struct {
+	Repositories []Repository `json:"repositories"`
+	// Rewrites is keyed by the key of each rewritten repository, with the Git repository of the cache itself under an empty key.
+	Rewrites map[string]*Rewrite `json:"rewrites"`
+}
+
+// normalize removes the parts of a URL that do not change which repository it refers to.
+func normalize(repositoryURL string) string {
+	return strings.TrimSuffix(strings.TrimRight(repositoryURL, "/"), ".git")
+}
+
+// key identifies a mirror in the cache by its URL, so that it does not move if its name on the destination changes.
+func key(repositoryURL string) string {
+	hash := sha256.Sum256([]byte(normalize(repositoryURL)))
+	return hex.EncodeToString(hash[:8])
+}
+
+// Path returns where the Git repository of a mirrored submodule is kept in the cache.
+func Path(cacheDirectory cachedirectory.CacheDirectory, repository Repository) string {
+	return cacheDirectory.SubmodulePath(key(repository.URL))
+}
+
+// resolve resolves a submodule URL relative to the URL of its superproject, in the same way as Git.
+func resolve(baseURL string, submoduleURL string) string {
+	if !strings.HasPrefix(submoduleURL, "./") && !strings.HasPrefix(submoduleURL, "../") {
+		return submoduleURL
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	for {
+		if strings.HasPrefix(submoduleURL, "./") {
+			submoduleURL = submoduleURL[2:]
+		} else if strings.HasPrefix(submoduleURL, "../") {
+			submoduleURL = submoduleURL[3:]
+			if index := strings.LastIndexAny(baseURL, "/:"); index > 0 {
+				baseURL = baseURL[:index]
+			}
+		} else {
+			return baseURL + "/" + submoduleURL
+		}
+	}
+}
+
+// name returns the last part of a URL, without any `.git` suffix.
+func name(repositoryURL string) string {
+	repositoryURL = strings.Replace(normalize(repositoryURL), ":", "/", -1)
+	return unsafeNameCharacters.ReplaceAllString(path.Base(repositoryURL), "-")
+}
+
+// assignNames names the mirror of each submodule after its repository, adding the owner of the repository if more than one submodule has the same name.
+func assignNames(repositories []Repository) {
+	counts := map[string]int{}
+	for _, repository := range repositories {
+		counts[name(repository.URL)]++
+	}
+	for index, repository := range repositories {
+		repositories[index].Name = name(repository.URL)
+		if counts[repositories[index].Name] > 1 {
+			owner := path.Dir(strings.Replace(normalize(repository.URL), ":", "/", -1))
+			repositories[index].Name = name(owner) + "-" + repositories[index].Name
+		}
+	}
+}
+
+// parseModules returns the URLs of the submodules in a `.gitmodules` file, in the order they are listed.
+func parseModules(content []byte) ([]string, error) {
+	modules := formatconfig.New()
+	err := formatconfig.NewDecoder(bytes.NewReader(content)).Decode(modules)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	for _, subsection := range modules.Section("submodule").Subsections {
+		if submoduleURL := subsection.Option("url"); submoduleURL != "" {
+			urls = append(urls, submoduleURL)
+		}
+	}
+	return urls, nil
+}
+
+// peel returns the commit a reference points to, through any annotated tags, or nil if it does not point to a commit.
+func peel(gitRepository *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	for {
+		gitObject, err := gitRepository.Object(plumbing.AnyObject, hash)
+		if err != nil {
+			return nil, err
+		}
+		switch gitObject := gitObject.(type) {
+		case *object.Commit:
+			return gitObject, nil
+		case *object.Tag:
+			hash = gitObject.Target
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// find returns the URLs of the submodules listed in the `.gitmodules` file of any branch or tag of a repository, resolved against the URL of the repository itself.
+func find(gitRepository *git.Repository, repositoryURL string) ([]string, error) {
+	found := map[string]string{}
+	references, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading Git references.")
+	}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() != plumbing.HashReference || !strings.HasPrefix(reference.Name().String(), "refs/") {
+			return nil
+		}
+		commit, err := peel(gitRepository, reference.Hash())
+		if err != nil || commit == nil {
+			return err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+		file, err := tree.File(modulesFile)
+		if err == object.ErrFileNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		urls, err := parseModules([]byte(content))
+		if err != nil {
+			log.Debugf("Ignoring the unreadable %s file of %s: %s", modulesFile, reference.Name(), err)
+			return nil
+		}
+		for _, submoduleURL := range urls {
+			resolved := resolve(repositoryURL, submoduleURL)
+			found[normalize(resolved)] = resolved
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error finding submodules.")
+	}
+	urls := []string{}
+	for _, submoduleURL := range found {
+		urls = append(urls, submoduleURL)
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// Read returns the record written by the last pull that mirrored submodules, or nil if there is none.
+func Read(cacheDirectory cachedirectory.CacheDirectory) (*Record, error) {
+	recordJSON, err := cacheDirectory.ReadSubmodules()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading submodules from cache.")
+	}
+	if recordJSON == nil {
+		return nil, nil
+	}
+	record := Record{}
+	err = json.Unmarshal(recordJSON, &record)
+	if err != nil {
+		return nil, exitcode.WithCode(errors.Wrap(err, "Error decoding submodules from cache."), exitcode.CacheCorrupt)
+	}
+	return &record, nil
+}
+
+// gitPath returns where the repository with a key is kept in the cache.
+func gitPath(cacheDirectory cachedirectory.CacheDirectory, repositoryKey string) string {
+	if repositoryKey == "" {
+		return cacheDirectory.GitPath()
+	}
+	return cacheDirectory.SubmodulePath(repositoryKey)
+}
+
+// Restore points the references of the Git repository in the cache and of the mirrors back at the commits from before they were rewritten, so that fetching from the source only transfers what is new.
+func Restore(cacheDirectory cachedirectory.CacheDirectory) error {
+	record, err := Read(cacheDirectory)
+	if err != nil || record == nil {
+		return err
+	}
+	for repositoryKey, rewrite := range record.Rewrites {
+		gitRepository, err := git.PlainOpen(gitPath(cacheDirectory, repositoryKey))
+		if err == git.ErrRepositoryNotExists {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "Error opening Git repository cache.")
+		}
+		original := map[plumbing.Hash]plumbing.Hash{}
+		for from, to := range rewrite.Objects {
+			original[plumbing.NewHash(to)] = plumbing.NewHash(from)
+		}
+		references, err := gitRepository.References()
+		if err != nil {
+			return errors.Wrap(err, "Error reading Git references.")
+		}
+		err = references.ForEach(func(reference *plumbing.Reference) error {
+			if hash, ok := original[reference.Hash()]; ok && hash != reference.Hash() && reference.Type() == plumbing.HashReference {
+				return gitRepository.Storer.SetReference(plumbing.NewHashReference(reference.Name(), hash))
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "Error restoring Git references from before submodules were rewritten.")
+		}
+	}
+	return nil
+}
+
+// credentials returns the credentials to fetch a submodule with. The source token is only sent to the host of the source.
+func credentials(sourceURL string, sourceToken string, submoduleURL string) transport.AuthMethod {
+	if sourceToken == "" {
+		return nil
+	}
+	source, err := url.Parse(sourceURL)
+	if err != nil {
+		return nil
+	}
+	submodule, err := url.Parse(submoduleURL)
+	if err != nil || submodule.Scheme != source.Scheme || submodule.Host != source.Host || (submodule.Scheme != "http" && submodule.Scheme != "https") {
+		return nil
+	}
+	return &githttp.BasicAuth{Username: "x-access-token", Password: sourceToken}
+}
+
+// fetchMirror brings a mirror of a submodule up to date with every branch and tag of the submodule.
+func fetchMirror(ctx context.Context, mirrorPath string, submoduleURL string, auth transport.AuthMethod) (*git.Repository, error) {
+	gitRepository, err := git.PlainOpen(mirrorPath)
+	if err == git.ErrRepositoryNotExists {
+		gitRepository, err = git.PlainInit(mirrorPath, true)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening mirror of submodule %s.", submoduleURL)
+	}
+	remote, err := gitRepository.CreateRemoteAnonymous(&config.RemoteConfig{Name: "anonymous", URLs: []string{submoduleURL}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error fetching submodule %s.", submoduleURL)
+	}
+	remoteReferences, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil && err != transport.ErrEmptyRemoteRepository {
+		return nil, errors.Wrapf(err, "Error listing references of submodule %s.", submoduleURL)
+	}
+	remoteNames := map[plumbing.ReferenceName]bool{}
+	for _, remoteReference := range remoteReferences {
+		remoteNames[remoteReference.Name()] = true
+	}
+	localReferences, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading Git references.")
+	}
+	err = localReferences.ForEach(func(reference *plumbing.Reference) error {
+		if (reference.Name().IsBranch() || reference.Name().IsTag()) && !remoteNames[reference.Name()] {
+			return gitRepository.Storer.RemoveReference(reference.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error removing deleted references of submodule %s.", submoduleURL)
+	}
+	if len(remoteReferences) == 0 {
+		return gitRepository, nil
+	}
+	err = remote.FetchContext(ctx, &git.FetchOptions{RefSpecs: mirrorRefSpecs, Auth: auth, Tags: git.NoTags, Force: true})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, errors.Wrapf(err, "Error fetching submodule %s.", submoduleURL)
+	}
+	return gitRepository, nil
+}
+
+// removeStaleMirrors removes mirrors of submodules that are no longer mirrored.
+func removeStaleMirrors(cacheDirectory cachedirectory.CacheDirectory, repositories []Repository) error {
+	current := map[string]bool{}
+	for _, repository := range repositories {
+		current[path.Base(Path(cacheDirectory, repository))] = true
+	}
+	entries, err := ioutil.ReadDir(cacheDirectory.SubmodulesPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error listing mirrored submodules.")
+	}
+	for _, entry := range entries {
+		if !current[entry.Name()] {
+			err := os.RemoveAll(path.Join(cacheDirectory.SubmodulesPath(), entry.Name()))
+			if err != nil {
+				return errors.Wrap(err, "Error removing mirror of submodule that is no longer used.")
+			}
+		}
+	}
+	return nil
+}
+
+// Pull mirrors the submodules of the Git repository in the cache, and their submodules up to depth levels down, then rewrites their histories so that `.gitmodules` files and submodule commits point at the mirrors as they will be on the destination. With a depth of 0 the submodules are only reported, and any earlier mirrors are removed.
+func Pull(ctx context.Context, cacheDirectory cachedirectory.CacheDirectory, sourceURL string, sourceToken string, depth int) error {
+	gitRepository, err := git.PlainOpen(cacheDirectory.GitPath())
+	if err != nil {
+		return errors.Wrap(err, "Error opening Git repository cache.")
+	}
+	found, err := find(gitRepository, sourceURL)
+	if err != nil {
+		return err
+	}
+	if depth == 0 {
+		if len(found) != 0 {
+			log.Warnf("The source repository has submodules, which will not be available on the destination unless they are mirrored with `--submodules`: %s", strings.Join(found, ", "))
+		}
+		err = cacheDirectory.RemoveSubmodules()
+		if err != nil {
+			return errors.Wrap(err, "Error removing mirrored submodules from cache.")
+		}
+		return nil
+	}
+	previous, err := Read(cacheDirectory)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		previous = &Record{}
+	}
+
+	repositories := []Repository{}
+	gitRepositories := map[string]*git.Repository{"": gitRepository}
+	children := map[string][]string{"": found}
+	seen := map[string]bool{normalize(sourceURL): true}
+	enqueue := func(urls []string, depth int) {
+		for _, submoduleURL := range urls {
+			if !seen[normalize(submoduleURL)] {
+				seen[normalize(submoduleURL)] = true
+				repositories = append(repositories, Repository{URL: submoduleURL, Depth: depth})
+			}
+		}
+	}
+	enqueue(found, 1)
+	for index := 0; index < len(repositories); index++ {
+		repository := repositories[index]
+		log.Infof("Pulling submodule %s...", repository.URL)
+		mirror, err := fetchMirror(ctx, Path(cacheDirectory, repository), repository.URL, credentials(sourceURL, sourceToken, repository.URL))
+		if err != nil {
+			return err
+		}
+		gitRepositories[key(repository.URL)] = mirror
+		if repository.Depth < depth {
+			found, err := find(mirror, repository.URL)
+			if err != nil {
+				return err
+			}
+			children[key(repository.URL)] = found
+			enqueue(found, repository.Depth+1)
+		}
+	}
+	assignNames(repositories)
+	names := map[string]string{}
+	for _, repository := range repositories {
+		names[key(repository.URL)] = repository.Name
+	}
+
+	record := Record{Repositories: repositories, Rewrites: map[string]*Rewrite{}}
+	rewritten := map[string]map[plumbing.Hash]plumbing.Hash{}
+	reset := map[string]bool{}
+	// Deeper submodules are rewritten first, so that the rewritten commits their superprojects should point at are known.
+	for index := len(repositories) - 1; index >= -1; index-- {
+		repositoryKey, repositoryURL := "", sourceURL
+		if index >= 0 {
+			repositoryKey, repositoryURL = key(repositories[index].URL), repositories[index].URL
+		}
+		urls := map[string]string{}
+		gitlinks := map[plumbing.Hash]plumbing.Hash{}
+		childReset := false
+		for _, submoduleURL := range children[repositoryKey] {
+			submoduleKey := key(submoduleURL)
+			if _, mirrored := gitRepositories[submoduleKey]; !mirrored {
+				continue
+			}
+			urls[normalize(submoduleURL)] = "../" + names[submoduleKey] + ".git"
+			for from, to := range rewritten[submoduleKey] {
+				gitlinks[from] = to
+			}
+			childReset = childReset || reset[submoduleKey]
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		// What was rewritten before can only be reused if it would be rewritten in the same way now.
+		previousObjects := map[string]string{}
+		if previousRewrite := previous.Rewrites[repositoryKey]; previousRewrite != nil && !childReset && reflect.DeepEqual(previousRewrite.URLs, urls) {
+			previousObjects = previousRewrite.Objects
+		} else {
+			reset[repositoryKey] = true
+		}
+		rewriter := newRewriter(gitRepositories[repositoryKey], repositoryURL, urls, gitlinks, previousObjects)
+		err := rewriter.rewriteReferences()
+		if err != nil {
+			return errors.Wrapf(err, "Error rewriting submodules of %s.", repositoryURL)
+		}
+		rewritten[repositoryKey] = rewriter.changed()
+		record.Rewrites[repositoryKey] = &Rewrite{URLs: urls, Objects: map[string]string{}}
+		for from, to := range rewriter.objects {
+			record.Rewrites[repositoryKey].Objects[from.String()] = to.String()
+		}
+	}
+
+	err = removeStaleMirrors(cacheDirectory, repositories)
+	if err != nil {
+		return err
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "Error converting submodules to JSON.")
+	}
+	err = cacheDirectory.WriteSubmodules(recordJSON)
+	if err != nil {
+		return errors.Wrap(err, "Error writing submodules to cache.")
+	}
+	return nil
+}
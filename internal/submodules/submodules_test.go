@@ -0,0 +1,191 @@
+package submodules
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func storeObject(t *testing.T, repository *git.Repository, encodable interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	encoded := repository.Storer.NewEncodedObject()
+	require.NoError(t, encodable.Encode(encoded))
+	hash, err := repository.Storer.SetEncodedObject(encoded)
+	require.NoError(t, err)
+	return hash
+}
+
+func storeBlob(t *testing.T, repository *git.Repository, content string) plumbing.Hash {
+	encoded := repository.Storer.NewEncodedObject()
+	encoded.SetType(plumbing.BlobObject)
+	writer, err := encoded.Writer()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	hash, err := repository.Storer.SetEncodedObject(encoded)
+	require.NoError(t, err)
+	return hash
+}
+
+// storeCommit stores a commit of a tree with a file, and optionally a `.gitmodules` file and a submodule, and points `master` at it.
+func storeCommit(t *testing.T, repository *git.Repository, modules string, gitlink plumbing.Hash, parents ...plumbing.Hash) plumbing.Hash {
+	entries := []object.TreeEntry{}
+	if modules != "" {
+		entries = append(entries, object.TreeEntry{Name: modulesFile, Mode: filemode.Regular, Hash: storeBlob(t, repository, modules)})
+	}
+	entries = append(entries, object.TreeEntry{Name: "file.txt", Mode: filemode.Regular, Hash: storeBlob(t, repository, "content")})
+	if !gitlink.IsZero() {
+		entries = append(entries, object.TreeEntry{Name: "submodule", Mode: filemode.Submodule, Hash: gitlink})
+	}
+	signature := object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0).UTC()}
+	hash := storeObject(t, repository, &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      "A commit.",
+		TreeHash:     storeObject(t, repository, &object.Tree{Entries: entries}),
+		ParentHashes: parents,
+	})
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, hash)))
+	return hash
+}
+
+func modules(submoduleURL string) string {
+	return "[submodule \"submodule\"]\n\tpath = submodule\n\turl = " + submoduleURL + "\n"
+}
+
+func createCache(t *testing.T) (cachedirectory.CacheDirectory, *git.Repository) {
+	cacheDirectory := cachedirectory.NewCacheDirectory(path.Join(test.CreateTemporaryDirectory(t), "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, version.Version()))
+	repository, err := git.PlainInit(cacheDirectory.GitPath(), true)
+	require.NoError(t, err)
+	return cacheDirectory, repository
+}
+
+func master(t *testing.T, gitPath string) plumbing.Hash {
+	repository, err := git.PlainOpen(gitPath)
+	require.NoError(t, err)
+	reference, err := repository.Reference(plumbing.Master, true)
+	require.NoError(t, err)
+	return reference.Hash()
+}
+
+func readModules(t *testing.T, gitPath string) string {
+	repository, err := git.PlainOpen(gitPath)
+	require.NoError(t, err)
+	commit, err := repository.CommitObject(master(t, gitPath))
+	require.NoError(t, err)
+	file, err := commit.File(modulesFile)
+	require.NoError(t, err)
+	content, err := file.Contents()
+	require.NoError(t, err)
+	return content
+}
+
+func TestResolve(t *testing.T) {
+	require.Equal(t, "https://github.com/owner/repository", resolve("https://github.com/github/codeql-action.git", "https://github.com/owner/repository"))
+	require.Equal(t, "https://github.com/github/other.git", resolve("https://github.com/github/codeql-action.git", "../other.git"))
+	require.Equal(t, "https://github.com/owner/other", resolve("https://github.com/github/codeql-action/", "../../owner/other"))
+	require.Equal(t, "https://github.com/github/codeql-action/nested", resolve("https://github.com/github/codeql-action", "./nested"))
+	require.Equal(t, "git@github.com:github/other", resolve("git@github.com:github/codeql-action", "../other"))
+}
+
+func TestAssignNames(t *testing.T) {
+	repositories := []Repository{
+		{URL: "https://github.com/first/library.git"},
+		{URL: "https://github.com/second/library"},
+		{URL: "git@github.com:owner/other.git"},
+	}
+	assignNames(repositories)
+	require.Equal(t, "first-library", repositories[0].Name)
+	require.Equal(t, "second-library", repositories[1].Name)
+	require.Equal(t, "other", repositories[2].Name)
+}
+
+func TestPullWithoutSubmodules(t *testing.T) {
+	cacheDirectory, repository := createCache(t)
+	first := storeCommit(t, repository, "", plumbing.ZeroHash)
+	require.NoError(t, Pull(context.Background(), cacheDirectory, "https://github.com/github/codeql-action.git", "", 1))
+	require.Equal(t, first, master(t, cacheDirectory.GitPath()))
+	record, err := Read(cacheDirectory)
+	require.NoError(t, err)
+	require.Empty(t, record.Repositories)
+	require.Empty(t, record.Rewrites)
+}
+
+func TestPullSubmodules(t *testing.T) {
+	sourcePath := test.CreateTemporaryDirectory(t)
+	grandchild, err := git.PlainInit(path.Join(sourcePath, "grandchild"), true)
+	require.NoError(t, err)
+	grandchildCommit := storeCommit(t, grandchild, "", plumbing.ZeroHash)
+	child, err := git.PlainInit(path.Join(sourcePath, "child"), true)
+	require.NoError(t, err)
+	childCommit := storeCommit(t, child, modules("../grandchild"), grandchildCommit)
+
+	cacheDirectory, repository := createCache(t)
+	first := storeCommit(t, repository, "", plumbing.ZeroHash)
+	second := storeCommit(t, repository, modules("./child"), childCommit, first)
+	sourceURL := sourcePath
+
+	// Only the submodules of the repository itself are pulled with a depth of 1, so the commit of the child is not rewritten.
+	require.NoError(t, Pull(context.Background(), cacheDirectory, sourceURL, "", 1))
+	record, err := Read(cacheDirectory)
+	require.NoError(t, err)
+	require.Equal(t, []Repository{{Name: "child", URL: path.Join(sourcePath, "child"), Depth: 1}}, record.Repositories)
+	rewritten := master(t, cacheDirectory.GitPath())
+	require.NotEqual(t, second, rewritten)
+	require.Equal(t, "[submodule \"submodule\"]\n\tpath = submodule\n\turl = ../child.git\n", readModules(t, cacheDirectory.GitPath()))
+	commit, err := repository.CommitObject(rewritten)
+	require.NoError(t, err)
+	require.Equal(t, []plumbing.Hash{first}, commit.ParentHashes)
+	require.Equal(t, childCommit, master(t, Path(cacheDirectory, record.Repositories[0])))
+
+	// Pulling again rewrites the history in the same way.
+	require.NoError(t, Restore(cacheDirectory))
+	require.Equal(t, second, master(t, cacheDirectory.GitPath()))
+	require.NoError(t, Pull(context.Background(), cacheDirectory, sourceURL, "", 1))
+	require.Equal(t, rewritten, master(t, cacheDirectory.GitPath()))
+
+	// With a depth of 2 the child is rewritten too, and the submodule commit of the repository points at its rewritten commit.
+	require.NoError(t, Restore(cacheDirectory))
+	require.NoError(t, Pull(context.Background(), cacheDirectory, sourceURL, "", 2))
+	record, err = Read(cacheDirectory)
+	require.NoError(t, err)
+	require.Len(t, record.Repositories, 2)
+	require.Equal(t, Repository{Name: "grandchild", URL: path.Join(sourcePath, "grandchild"), Depth: 2}, record.Repositories[1])
+	childMirror := Path(cacheDirectory, record.Repositories[0])
+	rewrittenChild := master(t, childMirror)
+	require.NotEqual(t, childCommit, rewrittenChild)
+	require.Equal(t, "[submodule \"submodule\"]\n\tpath = submodule\n\turl = ../grandchild.git\n", readModules(t, childMirror))
+	tree, err := repository.TreeObject(mustCommit(t, repository, master(t, cacheDirectory.GitPath())).TreeHash)
+	require.NoError(t, err)
+	entry, err := tree.FindEntry("submodule")
+	require.NoError(t, err)
+	require.Equal(t, rewrittenChild, entry.Hash)
+
+	// Mirroring stops and the mirrors are removed with a depth of 0.
+	require.NoError(t, Restore(cacheDirectory))
+	require.Equal(t, second, master(t, cacheDirectory.GitPath()))
+	require.NoError(t, Pull(context.Background(), cacheDirectory, sourceURL, "", 0))
+	record, err = Read(cacheDirectory)
+	require.NoError(t, err)
+	require.Nil(t, record)
+	require.NoDirExists(t, cacheDirectory.SubmodulesPath())
+}
+
+func mustCommit(t *testing.T, repository *git.Repository, hash plumbing.Hash) *object.Commit {
+	commit, err := repository.CommitObject(hash)
+	require.NoError(t, err)
+	return commit
+}
@@ -0,0 +1,129 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/pkg/errors"
+)
+
+const sendTimeout = 10 * time.Second
+
+// durationBuckets are the upper bounds of the ranges a run's duration is reported in, so that the exact time of a run, which could be matched against logs on the receiving end, is never sent.
+var durationBuckets = []struct {
+	limit time.Duration
+	name  string
+}{
+	{time.Minute, "under 1m"},
+	{5 * time.Minute, "1m-5m"},
+	{15 * time.Minute, "5m-15m"},
+	{time.Hour, "15m-1h"},
+	{4 * time.Hour, "1h-4h"},
+}
+
+const longestDurationBucket = "over 4h"
+
+// Report is sent once a command finishes. It only holds what is needed to decide which versions of GitHub Enterprise Server to keep supporting and which commands are slow, and nothing that identifies the user, the machine or the instances synced, such as host names, repository names, tokens or error messages.
+type Report struct {
+	Version string `json:"version"`
+	Command string `json:"command"`
+	Success bool   `json:"success"`
+	// EnterpriseServerVersion is the feature release of the destination such as 3.12, without its patch version, or empty if the command did not find it.
+	EnterpriseServerVersion string `json:"enterprise_server_version,omitempty"`
+	Duration                string `json:"duration"`
+}
+
+// Recorder records what is learned about the destination during a command. A nil recorder records nothing, so that callers do not need to check whether telemetry is on.
+type Recorder struct {
+	lock                    sync.Mutex
+	enterpriseServerVersion string
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+type contextKey struct{}
+
+// WithRecorder returns a context which carries the recorder to the code doing the work.
+func WithRecorder(ctx context.Context, recorder *Recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, recorder)
+}
+
+// FromContext returns the recorder carried by the context, or nil if there is none.
+func FromContext(ctx context.Context) *Recorder {
+	recorder, _ := ctx.Value(contextKey{}).(*Recorder)
+	return recorder
+}
+
+var featureReleasePattern = regexp.MustCompile(`^(\d+\.\d+)(\.|$)`)
+
+// RecordEnterpriseServerVersion records the version of GitHub Enterprise Server a command pushed to. Only its feature release is kept.
+func (recorder *Recorder) RecordEnterpriseServerVersion(enterpriseServerVersion string) {
+	if recorder == nil {
+		return
+	}
+	match := featureReleasePattern.FindStringSubmatch(enterpriseServerVersion)
+	if match == nil {
+		return
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.enterpriseServerVersion = match[1]
+}
+
+func durationBucket(duration time.Duration) string {
+	for _, bucket := range durationBuckets {
+		if duration < bucket.limit {
+			return bucket.name
+		}
+	}
+	return longestDurationBucket
+}
+
+// Report creates the report of a command that took the given time.
+func (recorder *Recorder) Report(command string, duration time.Duration, success bool) Report {
+	report := Report{
+		Version:  version.Version(),
+		Command:  command,
+		Success:  success,
+		Duration: durationBucket(duration),
+	}
+	if recorder != nil {
+		recorder.lock.Lock()
+		report.EnterpriseServerVersion = recorder.enterpriseServerVersion
+		recorder.lock.Unlock()
+	}
+	return report
+}
+
+// Send posts the report as JSON to the telemetry endpoint.
+func Send(ctx context.Context, endpointURL string, report Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return errors.Wrap(err, "Error converting telemetry to JSON.")
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Error constructing telemetry request.")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "codeql-action-sync/"+version.Version())
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "Error sending telemetry.")
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return errors.Errorf("Status code %d while sending telemetry.", response.StatusCode)
+	}
+	return nil
+}
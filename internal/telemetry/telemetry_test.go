@@ -0,0 +1,64 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurationBucket(t *testing.T) {
+	require.Equal(t, "under 1m", durationBucket(30*time.Second))
+	require.Equal(t, "1m-5m", durationBucket(time.Minute))
+	require.Equal(t, "15m-1h", durationBucket(59*time.Minute))
+	require.Equal(t, "over 4h", durationBucket(10*time.Hour))
+}
+
+func TestRecordEnterpriseServerVersion(t *testing.T) {
+	recorder := NewRecorder()
+	require.Equal(t, "", recorder.Report("push", time.Second, true).EnterpriseServerVersion)
+	recorder.RecordEnterpriseServerVersion("3.12.4")
+	require.Equal(t, "3.12", recorder.Report("push", time.Second, true).EnterpriseServerVersion)
+	recorder.RecordEnterpriseServerVersion("not a version")
+	require.Equal(t, "3.12", recorder.Report("push", time.Second, true).EnterpriseServerVersion)
+
+	// A nil recorder records nothing, but can still report.
+	var nilRecorder *Recorder
+	nilRecorder.RecordEnterpriseServerVersion("3.12.4")
+	require.Equal(t, Report{Version: "development", Command: "pull", Success: false, Duration: "5m-15m"}, nilRecorder.Report("pull", 10*time.Minute, false))
+}
+
+func TestSend(t *testing.T) {
+	testServer, endpointURL := test.GetTestHTTPServer(t)
+	var received map[string]interface{}
+	testServer.HandleFunc("/telemetry", func(response http.ResponseWriter, request *http.Request) {
+		require.Equal(t, "application/json", request.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(request.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(body, &received))
+		response.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+	testServer.HandleFunc("/broken", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusInternalServerError)
+	}).Methods("POST")
+
+	recorder := NewRecorder()
+	recorder.RecordEnterpriseServerVersion("3.12.4")
+	report := recorder.Report("sync", 2*time.Minute, true)
+	require.NoError(t, Send(context.Background(), endpointURL+"/telemetry", report))
+	// Nothing but these fields is ever sent.
+	require.Equal(t, map[string]interface{}{
+		"version":                   "development",
+		"command":                   "sync",
+		"success":                   true,
+		"enterprise_server_version": "3.12",
+		"duration":                  "1m-5m",
+	}, received)
+
+	require.Error(t, Send(context.Background(), endpointURL+"/broken", report))
+}
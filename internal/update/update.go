@@ -0,0 +1,273 @@
+package update
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/github/codeql-action-sync/internal/githubapiutil"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// DefaultSourceURL is where releases of the sync tool are published.
+const DefaultSourceURL = "https://github.com"
+
+// DefaultSourceRepository is the repository releases of the sync tool are published in.
+const DefaultSourceRepository = "github/codeql-action-sync-tool"
+
+const executableName = "codeql-action-sync"
+
+const errorDevelopmentBuild = "This is a development build of the sync tool, so cannot be updated. Download a release instead."
+const errorNoChecksum = "The release does not include a checksum for the sync tool, so it cannot be verified."
+const errorChecksumMismatch = "The downloaded sync tool does not match the checksum published with the release. It may have been corrupted or tampered with in transit, so has not been installed."
+
+type updateService struct {
+	ctx             context.Context
+	githubClient    *github.Client
+	ownerName       string
+	repositoryName  string
+	executablePath  string
+	operatingSystem string
+	architecture    string
+	currentVersion  string
+	writer          io.Writer
+}
+
+func (updateService *updateService) getRelease(requestedVersion string) (*github.RepositoryRelease, error) {
+	if requestedVersion == "" {
+		release, _, err := updateService.githubClient.Repositories.GetLatestRelease(updateService.ctx, updateService.ownerName, updateService.repositoryName)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error getting the latest release of the sync tool.")
+		}
+		return release, nil
+	}
+	release, response, err := updateService.githubClient.Repositories.GetReleaseByTag(updateService.ctx, updateService.ownerName, updateService.repositoryName, requestedVersion)
+	if err != nil && response != nil && response.StatusCode == http.StatusNotFound && !strings.HasPrefix(requestedVersion, "v") {
+		release, _, err = updateService.githubClient.Repositories.GetReleaseByTag(updateService.ctx, updateService.ownerName, updateService.repositoryName, "v"+requestedVersion)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting release %s of the sync tool.", requestedVersion)
+	}
+	return release, nil
+}
+
+func findAsset(release *github.RepositoryRelease, suffix string) *github.ReleaseAsset {
+	for _, asset := range release.Assets {
+		if strings.HasSuffix(asset.GetName(), suffix) {
+			return asset
+		}
+	}
+	return nil
+}
+
+func (updateService *updateService) downloadAsset(asset *github.ReleaseAsset) ([]byte, error) {
+	reader, _, err := updateService.githubClient.Repositories.DownloadReleaseAsset(updateService.ctx, updateService.ownerName, updateService.repositoryName, asset.GetID(), http.DefaultClient)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error downloading %s.", asset.GetName())
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error downloading %s.", asset.GetName())
+	}
+	return content, nil
+}
+
+// expectedChecksum finds the checksum of the named file in a checksums file, which has a line of the form `<sha256>  <name>` for each file.
+func expectedChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", usererrors.New(errorNoChecksum)
+}
+
+// extractExecutable finds the sync tool executable within a release archive.
+func (updateService *updateService) extractExecutable(archive []byte) ([]byte, error) {
+	name := executableName
+	if updateService.operatingSystem == "windows" {
+		name += ".exe"
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading release archive.")
+	}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, errors.Errorf("The release archive does not contain %s.", name)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading release archive.")
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == name {
+			content, err := ioutil.ReadAll(tarReader)
+			if err != nil {
+				return nil, errors.Wrap(err, "Error reading release archive.")
+			}
+			return content, nil
+		}
+	}
+}
+
+// replaceExecutable swaps the running executable for a new one. The running executable is moved aside first, as on Windows it cannot be overwritten while running.
+func (updateService *updateService) replaceExecutable(content []byte) error {
+	directory := filepath.Dir(updateService.executablePath)
+	newFile, err := ioutil.TempFile(directory, "."+executableName+"-update-")
+	if err != nil {
+		return errors.Wrap(err, "Error creating new executable.")
+	}
+	newPath := newFile.Name()
+	defer os.Remove(newPath)
+	_, err = newFile.Write(content)
+	if err != nil {
+		newFile.Close()
+		return errors.Wrap(err, "Error writing new executable.")
+	}
+	err = newFile.Close()
+	if err != nil {
+		return errors.Wrap(err, "Error writing new executable.")
+	}
+	err = os.Chmod(newPath, 0755)
+	if err != nil {
+		return errors.Wrap(err, "Error making new executable executable.")
+	}
+
+	oldPath := updateService.executablePath + ".old"
+	os.Remove(oldPath)
+	err = os.Rename(updateService.executablePath, oldPath)
+	if err != nil {
+		return errors.Wrap(err, "Error moving old executable aside.")
+	}
+	err = os.Rename(newPath, updateService.executablePath)
+	if err != nil {
+		os.Rename(oldPath, updateService.executablePath)
+		return errors.Wrap(err, "Error installing new executable.")
+	}
+	// This fails on Windows as the old executable is still running, in which case it is removed by the next update instead.
+	os.Remove(oldPath)
+	return nil
+}
+
+func (updateService *updateService) update(requestedVersion string, checkOnly bool) error {
+	if updateService.currentVersion == "development" {
+		return usererrors.New(errorDevelopmentBuild)
+	}
+	release, err := updateService.getRelease(requestedVersion)
+	if err != nil {
+		return err
+	}
+	releaseVersion := strings.TrimPrefix(release.GetTagName(), "v")
+	if releaseVersion == updateService.currentVersion {
+		fmt.Fprintf(updateService.writer, "The sync tool is already at version %s.\n", updateService.currentVersion)
+		return nil
+	}
+	if checkOnly {
+		fmt.Fprintf(updateService.writer, "Version %s of the sync tool is available. The current version is %s.\n", releaseVersion, updateService.currentVersion)
+		return nil
+	}
+
+	archiveSuffix := fmt.Sprintf("_%s_%s.tar.gz", updateService.operatingSystem, updateService.architecture)
+	archiveAsset := findAsset(release, archiveSuffix)
+	if archiveAsset == nil {
+		return fmt.Errorf("Release %s of the sync tool does not include a build for %s/%s.", release.GetTagName(), updateService.operatingSystem, updateService.architecture)
+	}
+	checksumsAsset := findAsset(release, "checksums.txt")
+	if checksumsAsset == nil {
+		return usererrors.New(errorNoChecksum)
+	}
+	log.Debugf("Downloading %s...", checksumsAsset.GetName())
+	checksums, err := updateService.downloadAsset(checksumsAsset)
+	if err != nil {
+		return err
+	}
+	checksum, err := expectedChecksum(checksums, archiveAsset.GetName())
+	if err != nil {
+		return err
+	}
+	log.Debugf("Downloading %s...", archiveAsset.GetName())
+	archive, err := updateService.downloadAsset(archiveAsset)
+	if err != nil {
+		return err
+	}
+	actualChecksum := sha256.Sum256(archive)
+	if hex.EncodeToString(actualChecksum[:]) != checksum {
+		return usererrors.New(errorChecksumMismatch)
+	}
+	executable, err := updateService.extractExecutable(archive)
+	if err != nil {
+		return err
+	}
+	err = updateService.replaceExecutable(executable)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(updateService.writer, "Updated the sync tool from version %s to %s.\n", updateService.currentVersion, releaseVersion)
+	return nil
+}
+
+// Update replaces the running sync tool with the given version, or the latest release if no version is given, after verifying it against the checksums published with the release. Releases are found in the given repository on GitHub.com or a GitHub Enterprise Server mirror. If checkOnly is true, only whether a different version is available is reported.
+func Update(ctx context.Context, sourceURL string, sourceToken string, sourceRepository string, requestedVersion string, checkOnly bool, writer io.Writer) error {
+	executablePath, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "Error finding own executable path.")
+	}
+	executablePath, err = filepath.EvalSymlinks(executablePath)
+	if err != nil {
+		return errors.Wrap(err, "Error finding own executable path.")
+	}
+
+	httpClient := http.DefaultClient
+	if sourceToken != "" {
+		tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: sourceToken})
+		httpClient = oauth2.NewClient(ctx, tokenSource)
+	}
+	githubClient := github.NewClient(httpClient)
+	sourceURL = strings.TrimRight(sourceURL, "/")
+	if sourceURL != "" && sourceURL != DefaultSourceURL {
+		githubClient.BaseURL, err = url.Parse(githubapiutil.APIURL(sourceURL))
+		if err != nil {
+			return errors.Wrap(err, "Error parsing source URL.")
+		}
+	}
+
+	repositorySplit := strings.Split(sourceRepository, "/")
+	if len(repositorySplit) != 2 {
+		return fmt.Errorf("The source repository %s is not valid. It should be given as owner/name.", sourceRepository)
+	}
+
+	updateService := updateService{
+		ctx:             ctx,
+		githubClient:    githubClient,
+		ownerName:       repositorySplit[0],
+		repositoryName:  repositorySplit[1],
+		executablePath:  executablePath,
+		operatingSystem: runtime.GOOS,
+		architecture:    runtime.GOARCH,
+		currentVersion:  version.Version(),
+		writer:          writer,
+	}
+	return updateService.update(requestedVersion, checkOnly)
+}
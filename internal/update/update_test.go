@@ -0,0 +1,132 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/codeql-action-sync/test"
+	"github.com/google/go-github/v32/github"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+const archiveName = "codeql-action-sync_1.1.0_linux_amd64.tar.gz"
+
+func createArchive(t *testing.T, executable string) []byte {
+	buffer := bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range map[string]string{"README.md": "Read me.", "codeql-action-sync": executable} {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tarWriter.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	return buffer.Bytes()
+}
+
+func getTestUpdateService(t *testing.T, archive []byte, checksum string) (updateService, string) {
+	temporaryDirectory := test.CreateTemporaryDirectory(t)
+	executablePath := filepath.Join(temporaryDirectory, "codeql-action-sync")
+	require.NoError(t, ioutil.WriteFile(executablePath, []byte("old executable"), 0755))
+
+	githubTestServer, githubURL := test.GetTestHTTPServer(t)
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action-sync-tool/releases/latest", func(response http.ResponseWriter, request *http.Request) {
+		test.ServeHTTPResponseFromObject(t, github.RepositoryRelease{
+			TagName: github.String("v1.1.0"),
+			Assets: []*github.ReleaseAsset{
+				{ID: github.Int64(1), Name: github.String("codeql-action-sync_1.1.0_darwin_amd64.tar.gz")},
+				{ID: github.Int64(2), Name: github.String(archiveName)},
+				{ID: github.Int64(3), Name: github.String("codeql-action-sync_1.1.0_checksums.txt")},
+			},
+		}, response)
+	}).Methods("GET")
+	githubTestServer.HandleFunc("/api/v3/repos/github/codeql-action-sync-tool/releases/assets/{id}", func(response http.ResponseWriter, request *http.Request) {
+		switch mux.Vars(request)["id"] {
+		case "2":
+			_, err := response.Write(archive)
+			require.NoError(t, err)
+		case "3":
+			test.ServeHTTPResponseFromString(t, fmt.Sprintf("%s  codeql-action-sync_1.1.0_darwin_amd64.tar.gz\n%s  %s\n", checksum, checksum, archiveName), response)
+		default:
+			response.WriteHeader(http.StatusNotFound)
+		}
+	}).Methods("GET")
+
+	githubClient := github.NewClient(nil)
+	baseURL, err := url.Parse(githubURL + "/api/v3/")
+	require.NoError(t, err)
+	githubClient.BaseURL = baseURL
+	return updateService{
+		ctx:             context.Background(),
+		githubClient:    githubClient,
+		ownerName:       "github",
+		repositoryName:  "codeql-action-sync-tool",
+		executablePath:  executablePath,
+		operatingSystem: "linux",
+		architecture:    "amd64",
+		currentVersion:  "1.0.0",
+		writer:          &bytes.Buffer{},
+	}, executablePath
+}
+
+func checksumOf(content []byte) string {
+	checksum := sha256.Sum256(content)
+	return hex.EncodeToString(checksum[:])
+}
+
+func TestUpdate(t *testing.T) {
+	archive := createArchive(t, "new executable")
+	updateService, executablePath := getTestUpdateService(t, archive, checksumOf(archive))
+	require.NoError(t, updateService.update("", false))
+	test.RequireFileHasContent(t, "new executable", executablePath)
+	require.NoFileExists(t, executablePath+".old")
+}
+
+func TestUpdateRejectsChecksumMismatch(t *testing.T) {
+	archive := createArchive(t, "new executable")
+	updateService, executablePath := getTestUpdateService(t, archive, checksumOf([]byte("something else")))
+	require.EqualError(t, updateService.update("", false), errorChecksumMismatch)
+	test.RequireFileHasContent(t, "old executable", executablePath)
+}
+
+func TestUpdateCheckOnly(t *testing.T) {
+	archive := createArchive(t, "new executable")
+	updateService, executablePath := getTestUpdateService(t, archive, checksumOf(archive))
+	require.NoError(t, updateService.update("", true))
+	test.RequireFileHasContent(t, "old executable", executablePath)
+	require.Equal(t, "Version 1.1.0 of the sync tool is available. The current version is 1.0.0.\n", updateService.writer.(*bytes.Buffer).String())
+}
+
+func TestUpdateAlreadyUpToDate(t *testing.T) {
+	archive := createArchive(t, "new executable")
+	updateService, executablePath := getTestUpdateService(t, archive, checksumOf(archive))
+	updateService.currentVersion = "1.1.0"
+	require.NoError(t, updateService.update("", false))
+	test.RequireFileHasContent(t, "old executable", executablePath)
+}
+
+func TestUpdateMissingPlatform(t *testing.T) {
+	archive := createArchive(t, "new executable")
+	updateService, _ := getTestUpdateService(t, archive, checksumOf(archive))
+	updateService.operatingSystem = "plan9"
+	require.EqualError(t, updateService.update("", false), "Release v1.1.0 of the sync tool does not include a build for plan9/amd64.")
+}
+
+func TestUpdateDevelopmentBuild(t *testing.T) {
+	archive := createArchive(t, "new executable")
+	updateService, _ := getTestUpdateService(t, archive, checksumOf(archive))
+	updateService.currentVersion = "development"
+	require.EqualError(t, updateService.update("", false), errorDevelopmentBuild)
+}
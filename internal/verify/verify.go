@@ -0,0 +1,333 @@
+package verify
+
+import (
+	"encoding/json"
+	usererrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/list"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const OutputFormatText = "text"
+const OutputFormatJSON = "json"
+
+const errorUnknownOutputFormat = "The output format must be either `text` or `json`."
+const errorRemoteCache = "`verify` only checks caches on the local filesystem, as it never uses the network. Copy the cache to a local directory to verify it."
+const errorProblemsFound = "The cache failed verification. Pull it again before pushing."
+const errorNetworkRequest = "`verify` does not use the network, but a request was made to %s."
+
+// The checks a problem can be found by.
+const (
+	// CheckState means the cache was left incomplete by a pull.
+	CheckState = "state"
+	// CheckGit means the Git repository is missing objects or has corrupt ones.
+	CheckGit = "git"
+	// CheckReleases means a release is missing its metadata, its Git tag, or assets the last pull downloaded.
+	CheckReleases = "releases"
+	// CheckAssets means an asset does not match the checksum recorded when it was downloaded.
+	CheckAssets = "assets"
+)
+
+type Problem struct {
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// Result is everything verify checked, and the problems it found.
+type Result struct {
+	Cache      string    `json:"cache"`
+	GitObjects int       `json:"git_objects"`
+	Releases   int       `json:"releases"`
+	Assets     int       `json:"assets"`
+	Problems   []Problem `json:"problems"`
+}
+
+func (result *Result) problem(check string, format string, arguments ...interface{}) {
+	result.Problems = append(result.Problems, Problem{Check: check, Message: fmt.Sprintf(format, arguments...)})
+}
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf(errorNetworkRequest, request.URL.Host)
+}
+
+// InstallOfflineTransport makes every HTTP request made through the default transport fail, so that verifying a cache is sure not to dial out even if something it uses tries to.
+func InstallOfflineTransport() {
+	http.DefaultTransport = offlineTransport{}
+}
+
+func checkState(cacheDirectory cachedirectory.CacheDirectory, result *Result) error {
+	if cacheDirectory.CheckLock() != nil {
+		result.problem(CheckState, "A `pull` is in progress or was interrupted, so the cache is incomplete.")
+	}
+	partialAssets, err := cacheDirectory.ListPartialAssets()
+	if err != nil {
+		return err
+	}
+	for _, partialAsset := range partialAssets {
+		result.problem(CheckState, "The download of %s/%s was interrupted.", partialAsset.Release, partialAsset.Name)
+	}
+	return nil
+}
+
+// objectHash returns the hash of the content of an object, which is different from the hash it is stored under if it is corrupt.
+func objectHash(encodedObject plumbing.EncodedObject) (plumbing.Hash, error) {
+	reader, err := encodedObject.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer reader.Close()
+	hasher := plumbing.NewHasher(encodedObject.Type(), encodedObject.Size())
+	_, err = io.Copy(hasher, reader)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return hasher.Sum(), nil
+}
+
+// checkGit checks every object reachable from the references in the Git repository, in the same way as `git fsck`: each must be present, have the hash it is stored under, and decode. History left out of a shallow cache is not followed. The repository is returned so that the tags of releases can be checked, or nil if it could not be opened.
+func checkGit(cacheDirectory cachedirectory.CacheDirectory, result *Result) (*git.Repository, error) {
+	gitRepository, err := git.PlainOpen(cacheDirectory.GitPath())
+	if err != nil {
+		if err == git.ErrRepositoryNotExists {
+			result.problem(CheckGit, "The cache does not have a Git repository.")
+			return nil, nil
+		}
+		result.problem(CheckGit, "The Git repository could not be opened: %s", err)
+		return nil, nil
+	}
+	shallowCommits, err := gitRepository.Storer.Shallow()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading shallow commits from Git repository cache.")
+	}
+	shallow := map[plumbing.Hash]bool{}
+	for _, hash := range shallowCommits {
+		shallow[hash] = true
+	}
+
+	pending := []plumbing.Hash{}
+	referencedBy := map[plumbing.Hash]string{}
+	references, err := gitRepository.References()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference {
+			pending = append(pending, reference.Hash())
+			referencedBy[reference.Hash()] = reference.Name().String()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading references from Git repository cache.")
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	for len(pending) > 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		result.GitObjects++
+		encodedObject, err := gitRepository.Storer.EncodedObject(plumbing.AnyObject, hash)
+		if err != nil {
+			if name, isReference := referencedBy[hash]; isReference {
+				result.problem(CheckGit, "The object %s that %s points to is missing: %s", hash, name, err)
+			} else {
+				result.problem(CheckGit, "The object %s is missing: %s", hash, err)
+			}
+			continue
+		}
+		contentHash, err := objectHash(encodedObject)
+		if err != nil {
+			result.problem(CheckGit, "The object %s could not be read: %s", hash, err)
+			continue
+		}
+		if contentHash != hash {
+			result.problem(CheckGit, "The object %s is corrupt, as its content has the hash %s.", hash, contentHash)
+			continue
+		}
+		if encodedObject.Type() == plumbing.BlobObject {
+			continue
+		}
+		decodedObject, err := object.DecodeObject(gitRepository.Storer, encodedObject)
+		if err != nil {
+			result.problem(CheckGit, "The %s %s could not be decoded: %s", encodedObject.Type(), hash, err)
+			continue
+		}
+		switch typedObject := decodedObject.(type) {
+		case *object.Commit:
+			pending = append(pending, typedObject.TreeHash)
+			if !shallow[hash] {
+				pending = append(pending, typedObject.ParentHashes...)
+			}
+		case *object.Tree:
+			for _, entry := range typedObject.Entries {
+				if entry.Mode != filemode.Submodule {
+					pending = append(pending, entry.Hash)
+				}
+			}
+		case *object.Tag:
+			pending = append(pending, typedObject.Target)
+		}
+	}
+	return gitRepository, nil
+}
+
+// checkReleases checks that each release in the cache has metadata, a Git tag to push, and assets matching the checksums recorded when they were downloaded. The releases and assets recorded by the last successful pull must all still be in the cache.
+func checkReleases(cacheDirectory cachedirectory.CacheDirectory, gitRepository *git.Repository, result *Result) error {
+	checksums, err := cacheDirectory.ReadManifest()
+	if err != nil {
+		return err
+	}
+	contentsManifest, err := list.ReadManifest(cacheDirectory)
+	if err != nil {
+		return err
+	}
+	releases, err := cacheDirectory.ListReleases()
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+	cachedAssets := map[string]map[string]string{}
+	for _, releaseTag := range releases {
+		result.Releases++
+		metadata, err := cacheDirectory.ReadMetadata(releaseTag)
+		if err != nil {
+			result.problem(CheckReleases, "The release %s has no metadata.", releaseTag)
+		} else if json.Unmarshal(metadata, &github.RepositoryRelease{}) != nil {
+			result.problem(CheckReleases, "The metadata of the release %s could not be decoded.", releaseTag)
+		}
+		if gitRepository != nil {
+			_, err = gitRepository.Reference(plumbing.NewTagReferenceName(releaseTag), false)
+			if err != nil {
+				result.problem(CheckReleases, "The release %s has no Git tag.", releaseTag)
+			}
+		}
+
+		assets, err := cacheDirectory.ListAssets(releaseTag)
+		if err != nil {
+			return err
+		}
+		cachedAssets[releaseTag] = map[string]string{}
+		for _, asset := range assets {
+			result.Assets++
+			log.Debugf("Checking %s/%s...", releaseTag, asset.Name)
+			digest, err := cacheDirectory.AssetDigest(releaseTag, asset.Name)
+			if err != nil {
+				result.problem(CheckAssets, "The asset %s/%s could not be read: %s", releaseTag, asset.Name, err)
+				continue
+			}
+			cachedAssets[releaseTag][asset.Name] = digest
+			entry, exists := checksums.Get(releaseTag, asset.Name)
+			if !exists {
+				result.problem(CheckAssets, "The asset %s/%s has no checksum recorded, so it cannot be verified.", releaseTag, asset.Name)
+			} else if entry.Size != asset.Size {
+				result.problem(CheckAssets, "The asset %s/%s is %d bytes, but was %d bytes when it was downloaded.", releaseTag, asset.Name, asset.Size, entry.Size)
+			} else if entry.SHA256 != digest {
+				result.problem(CheckAssets, "The asset %s/%s has the checksum %s, but had %s when it was downloaded.", releaseTag, asset.Name, digest, entry.SHA256)
+			}
+		}
+	}
+
+	if contentsManifest == nil {
+		return nil
+	}
+	for _, release := range contentsManifest.Releases {
+		assets, exists := cachedAssets[release.Tag]
+		if !exists {
+			result.problem(CheckReleases, "The release %s was pulled, but is no longer in the cache.", release.Tag)
+			continue
+		}
+		for _, asset := range release.Assets {
+			digest, exists := assets[asset.Name]
+			if !exists {
+				result.problem(CheckReleases, "The asset %s/%s was pulled, but is no longer in the cache.", release.Tag, asset.Name)
+			} else if digest != asset.SHA256 {
+				result.problem(CheckAssets, "The asset %s/%s has the checksum %s, but the cache contents manifest records %s.", release.Tag, asset.Name, digest, asset.SHA256)
+			}
+		}
+	}
+	return nil
+}
+
+// Verify checks everything about the cache that can be checked without the network: that the last pull finished, that the Git repository is complete and not corrupt, and that every release is complete and its assets match the checksums recorded when they were downloaded. Only caches on the local filesystem can be verified.
+func Verify(cacheDirectory cachedirectory.CacheDirectory) (*Result, error) {
+	if cacheDirectory.IsRemote() {
+		return nil, usererrors.New(errorRemoteCache)
+	}
+	err := cacheDirectory.CheckOrCreateVersionFile(false, version.Version())
+	if err != nil {
+		return nil, err
+	}
+	result := Result{Cache: cacheDirectory.String(), Problems: []Problem{}}
+	err = checkState(cacheDirectory, &result)
+	if err != nil {
+		return nil, err
+	}
+	gitRepository, err := checkGit(cacheDirectory, &result)
+	if err != nil {
+		return nil, err
+	}
+	err = checkReleases(cacheDirectory, gitRepository, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func writeText(result *Result, writer io.Writer) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tabWriter, "Cache: %s\n", result.Cache)
+	fmt.Fprintf(tabWriter, "Checked %d Git objects, %d releases and %d assets.\n", result.GitObjects, result.Releases, result.Assets)
+	if len(result.Problems) == 0 {
+		fmt.Fprintln(tabWriter, "\nNo problems found.")
+		return tabWriter.Flush()
+	}
+	fmt.Fprintf(tabWriter, "\nProblems (%d):\n", len(result.Problems))
+	for _, problem := range result.Problems {
+		fmt.Fprintf(tabWriter, "  %s\t%s\n", problem.Check, problem.Message)
+	}
+	return tabWriter.Flush()
+}
+
+// Run verifies the cache and prints the result in the given output format. If any problems are found, an error with the CacheCorrupt exit code is returned.
+func Run(cacheDirectory cachedirectory.CacheDirectory, writer io.Writer, outputFormat string) error {
+	if outputFormat != OutputFormatText && outputFormat != OutputFormatJSON {
+		return usererrors.New(errorUnknownOutputFormat)
+	}
+	result, err := Verify(cacheDirectory)
+	if err != nil {
+		return err
+	}
+	if outputFormat == OutputFormatJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(result)
+	} else {
+		err = writeText(result, writer)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error writing verification result.")
+	}
+	if len(result.Problems) != 0 {
+		return exitcode.WithCode(usererrors.New(errorProblemsFound), exitcode.CacheCorrupt)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package verify
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/codeql-action-sync/internal/cachedirectory"
+	"github.com/github/codeql-action-sync/internal/exitcode"
+	"github.com/github/codeql-action-sync/internal/list"
+	"github.com/github/codeql-action-sync/internal/version"
+	"github.com/github/codeql-action-sync/test"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+const release = "codeql-bundle-20200101"
+
+type encodable interface {
+	Encode(plumbing.EncodedObject) error
+}
+
+func storeObject(t *testing.T, repository *git.Repository, decoded encodable) plumbing.Hash {
+	encoded := repository.Storer.NewEncodedObject()
+	require.NoError(t, decoded.Encode(encoded))
+	hash, err := repository.Storer.SetEncodedObject(encoded)
+	require.NoError(t, err)
+	return hash
+}
+
+func storeBlob(t *testing.T, repository *git.Repository, content string) plumbing.Hash {
+	encoded := repository.Storer.NewEncodedObject()
+	encoded.SetType(plumbing.BlobObject)
+	writer, err := encoded.Writer()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	hash, err := repository.Storer.SetEncodedObject(encoded)
+	require.NoError(t, err)
+	return hash
+}
+
+// getTestCacheDirectory creates a cache with one release and asset, and a Git repository with a commit that the default branch and the tag of the release point to. The hash of the blob in the commit is returned.
+func getTestCacheDirectory(t *testing.T) (cachedirectory.CacheDirectory, plumbing.Hash) {
+	cacheDirectory := cachedirectory.NewCacheDirectory(filepath.Join(test.CreateTemporaryDirectory(t), "cache"))
+	require.NoError(t, cacheDirectory.CheckOrCreateVersionFile(true, version.Version()))
+	require.NoError(t, cacheDirectory.WriteMetadata(release, []byte(`{"tag_name": "`+release+`"}`)))
+	require.NoError(t, cacheDirectory.WriteAsset(release, "codeql-bundle.tar.gz", strings.NewReader("a bundle"), 8))
+	checksums, err := cacheDirectory.ReadManifest()
+	require.NoError(t, err)
+	checksums.Set(release, "codeql-bundle.tar.gz", cachedirectory.ManifestEntry{Size: 8, SHA256: "cd98c950d629c66548537b4ecd68dab3e8cf59974ba6e40df5a1a7354b39db5f"})
+	require.NoError(t, cacheDirectory.WriteManifest(checksums))
+
+	repository, err := git.PlainInit(cacheDirectory.GitPath(), true)
+	require.NoError(t, err)
+	blob := storeBlob(t, repository, "The CodeQL Action.")
+	tree := storeObject(t, repository, &object.Tree{Entries: []object.TreeEntry{{Name: "README.md", Mode: filemode.Regular, Hash: blob}}})
+	signature := object.Signature{Name: "Octocat", Email: "octocat@example.com", When: time.Unix(1577836800, 0)}
+	commit := storeObject(t, repository, &object.Commit{Author: signature, Committer: signature, Message: "Initial commit.", TreeHash: tree})
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", commit)))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName(release), commit)))
+	return cacheDirectory, blob
+}
+
+func looseObjectPath(cacheDirectory cachedirectory.CacheDirectory, hash plumbing.Hash) string {
+	return filepath.Join(cacheDirectory.GitPath(), "objects", hash.String()[:2], hash.String()[2:])
+}
+
+func requireProblems(t *testing.T, cacheDirectory cachedirectory.CacheDirectory, expected ...Problem) {
+	result, err := Verify(cacheDirectory)
+	require.NoError(t, err)
+	require.Equal(t, expected, result.Problems)
+}
+
+func TestVerify(t *testing.T) {
+	cacheDirectory, _ := getTestCacheDirectory(t)
+	result, err := Verify(cacheDirectory)
+	require.NoError(t, err)
+	require.Equal(t, &Result{Cache: cacheDirectory.String(), GitObjects: 3, Releases: 1, Assets: 1, Problems: []Problem{}}, result)
+}
+
+func TestVerifyMissingGitObject(t *testing.T) {
+	cacheDirectory, blob := getTestCacheDirectory(t)
+	require.NoError(t, os.Remove(looseObjectPath(cacheDirectory, blob)))
+	requireProblems(t, cacheDirectory, Problem{Check: CheckGit, Message: "The object " + blob.String() + " is missing: object not found"})
+}
+
+func TestVerifyCorruptGitObject(t *testing.T) {
+	cacheDirectory, blob := getTestCacheDirectory(t)
+	content := bytes.Buffer{}
+	writer := zlib.NewWriter(&content)
+	_, err := writer.Write([]byte("blob 18\x00The CodeQL Actiom."))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	path := looseObjectPath(cacheDirectory, blob)
+	require.NoError(t, os.Chmod(path, 0644))
+	require.NoError(t, ioutil.WriteFile(path, content.Bytes(), 0644))
+	result, err := Verify(cacheDirectory)
+	require.NoError(t, err)
+	require.Len(t, result.Problems, 1)
+	require.Equal(t, CheckGit, result.Problems[0].Check)
+	require.Contains(t, result.Problems[0].Message, "The object "+blob.String()+" is corrupt")
+}
+
+func TestVerifyIncompleteRelease(t *testing.T) {
+	cacheDirectory, _ := getTestCacheDirectory(t)
+	repository, err := git.PlainOpen(cacheDirectory.GitPath())
+	require.NoError(t, err)
+	require.NoError(t, repository.Storer.RemoveReference(plumbing.NewTagReferenceName(release)))
+	require.NoError(t, ioutil.WriteFile(cacheDirectory.MetadataPath(release), []byte("{"), 0644))
+	requireProblems(t, cacheDirectory,
+		Problem{Check: CheckReleases, Message: "The metadata of the release " + release + " could not be decoded."},
+		Problem{Check: CheckReleases, Message: "The release " + release + " has no Git tag."},
+	)
+}
+
+func TestVerifyChangedAsset(t *testing.T) {
+	cacheDirectory, _ := getTestCacheDirectory(t)
+	require.NoError(t, cacheDirectory.WriteAsset(release, "codeql-bundle.tar.gz", strings.NewReader("a bundlf"), 8))
+	require.NoError(t, cacheDirectory.WriteAsset(release, "codeql-runner-linux", strings.NewReader("a runner"), 8))
+	requireProblems(t, cacheDirectory,
+		Problem{Check: CheckAssets, Message: "The asset " + release + "/codeql-bundle.tar.gz has the checksum fa45b399080e1208b152009d069a98bc98c6e3730d1a3e672cb9f30e5e9a4ab9, but had cd98c950d629c66548537b4ecd68dab3e8cf59974ba6e40df5a1a7354b39db5f when it was downloaded."},
+		Problem{Check: CheckAssets, Message: "The asset " + release + "/codeql-runner-linux has no checksum recorded, so it cannot be verified."},
+	)
+}
+
+func TestVerifyAgainstContentsManifest(t *testing.T) {
+	cacheDirectory, _ := getTestCacheDirectory(t)
+	manifest := list.Manifest{Releases: []list.Release{
+		{Tag: release, Assets: []list.Asset{{Name: "codeql-bundle.tar.gz", Size: 8, SHA256: "cd98c950d629c66548537b4ecd68dab3e8cf59974ba6e40df5a1a7354b39db5f"}, {Name: "codeql-runner-linux", Size: 8}}},
+		{Tag: "codeql-bundle-20200630"},
+	}}
+	manifestJSON, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, cacheDirectory.WriteContentsManifest(manifestJSON))
+	requireProblems(t, cacheDirectory,
+		Problem{Check: CheckReleases, Message: "The asset " + release + "/codeql-runner-linux was pulled, but is no longer in the cache."},
+		Problem{Check: CheckReleases, Message: "The release codeql-bundle-20200630 was pulled, but is no longer in the cache."},
+	)
+}
+
+func TestVerifyInterruptedPull(t *testing.T) {
+	cacheDirectory, _ := getTestCacheDirectory(t)
+	require.NoError(t, cacheDirectory.Lock())
+	requireProblems(t, cacheDirectory, Problem{Check: CheckState, Message: "A `pull` is in progress or was interrupted, so the cache is incomplete."})
+}
+
+func TestVerifyRemoteCache(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "access-key-id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret-access-key")
+	cacheDirectory, err := cachedirectory.OpenCacheDirectory("s3://bucket/prefix")
+	require.NoError(t, err)
+	_, err = Verify(cacheDirectory)
+	require.EqualError(t, err, errorRemoteCache)
+}
+
+func TestRun(t *testing.T) {
+	cacheDirectory, _ := getTestCacheDirectory(t)
+	output := bytes.Buffer{}
+	require.NoError(t, Run(cacheDirectory, &output, OutputFormatText))
+	require.Contains(t, output.String(), "Checked 3 Git objects, 1 releases and 1 assets.\n\nNo problems found.\n")
+
+	require.NoError(t, cacheDirectory.Lock())
+	output.Reset()
+	err := Run(cacheDirectory, &output, OutputFormatJSON)
+	require.EqualError(t, err, errorProblemsFound)
+	require.Equal(t, exitcode.CacheCorrupt, exitcode.Code(err))
+	result := Result{}
+	require.NoError(t, json.Unmarshal(output.Bytes(), &result))
+	require.Len(t, result.Problems, 1)
+}
+
+func TestInstallOfflineTransport(t *testing.T) {
+	defaultTransport := http.DefaultTransport
+	defer func() {
+		http.DefaultTransport = defaultTransport
+	}()
+	InstallOfflineTransport()
+	_, err := http.Get("https://github.com/github/codeql-action")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "`verify` does not use the network, but a request was made to github.com.")
+}
@@ -7,6 +7,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/github/codeql-action-sync/cmd"
+	"github.com/github/codeql-action-sync/internal/exitcode"
 )
 
 func main() {
@@ -16,6 +17,7 @@ func main() {
 		if err == cmd.SilentErr {
 			os.Exit(1)
 		}
-		log.Fatalf("%+v", err)
+		log.Errorf("%+v", err)
+		os.Exit(exitcode.Code(err))
 	}
 }
@@ -7,11 +7,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/require"
 )
 
@@ -61,12 +63,51 @@ func CheckExpectedReferencesInRepository(t *testing.T, repositoryPath string, ex
 	require.NoError(t, err)
 	actualReferences := []string{}
 	err = referenceIterator.ForEach(func(reference *plumbing.Reference) error {
-		referenceString := reference.String()
-		if referenceString != "ref: refs/heads/master HEAD" {
-			actualReferences = append(actualReferences, referenceString)
+		// `HEAD` only records the default branch, which is checked separately.
+		if reference.Name() != plumbing.HEAD {
+			actualReferences = append(actualReferences, reference.String())
 		}
 		return nil
 	})
 	require.NoError(t, err)
 	require.ElementsMatch(t, expectedReferences, actualReferences)
 }
+
+// testTagSignature is the signature of the tag created by CreateRepositoryWithSignedTag. It would not verify, but Git stores and transfers it like any other.
+const testTagSignature = "-----BEGIN PGP SIGNATURE-----\n\niHUEABYIAB0WIQTestSignatureOnly\n-----END PGP SIGNATURE-----\n"
+
+func storeObject(t *testing.T, repository *git.Repository, gitObject interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	encoded := repository.Storer.NewEncodedObject()
+	require.NoError(t, gitObject.Encode(encoded))
+	hash, err := repository.Storer.SetEncodedObject(encoded)
+	require.NoError(t, err)
+	return hash
+}
+
+// CreateRepositoryWithSignedTag creates a bare Git repository with a commit on `main` and a signed annotated tag of it, and returns the hash of the tag object.
+func CreateRepositoryWithSignedTag(t *testing.T, repositoryPath string, tagName string) plumbing.Hash {
+	repository, err := git.PlainInit(repositoryPath, true)
+	require.NoError(t, err)
+	signature := object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0).UTC()}
+	tree := storeObject(t, repository, &object.Tree{})
+	commit := storeObject(t, repository, &object.Commit{Author: signature, Committer: signature, Message: "A commit.\n", TreeHash: tree})
+	tag := storeObject(t, repository, &object.Tag{Name: tagName, Tagger: signature, Message: "A release.\n", TargetType: plumbing.CommitObject, Target: commit, PGPSignature: testTagSignature})
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), commit)))
+	require.NoError(t, repository.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName(tagName), tag)))
+	return tag
+}
+
+// CheckSignedTagInRepository checks that a repository has the tag created by CreateRepositoryWithSignedTag as the same tag object, with its annotation and signature, rather than as a lightweight tag.
+func CheckSignedTagInRepository(t *testing.T, repositoryPath string, tagName string, tagHash plumbing.Hash) {
+	repository, err := git.PlainOpen(repositoryPath)
+	require.NoError(t, err)
+	reference, err := repository.Reference(plumbing.NewTagReferenceName(tagName), false)
+	require.NoError(t, err)
+	require.Equal(t, tagHash, reference.Hash())
+	tag, err := repository.TagObject(tagHash)
+	require.NoError(t, err)
+	require.Equal(t, "A release.\n", tag.Message)
+	require.Equal(t, testTagSignature, tag.PGPSignature)
+}